@@ -15,6 +15,8 @@ var apiCmd = &args.Command{
 		usersCmd,
 		integrationsCmd,
 		rolesCmd,
+		tokensCmd,
+		statsCmd,
 		keys.Command(config.DefaultConfigDir(), "/api/v1/admin/keys"),
 	},
 }
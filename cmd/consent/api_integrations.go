@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/args"
 	"git.sr.ht/~jakintosh/command-go/pkg/envs"
 	"git.sr.ht/~jakintosh/consent/internal/api"
 	"git.sr.ht/~jakintosh/consent/internal/config"
+	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
 var integrationsCmd = &args.Command{
@@ -19,6 +24,9 @@ var integrationsCmd = &args.Command{
 		integrationsCreateCmd,
 		integrationsUpdateCmd,
 		integrationsDeleteCmd,
+		integrationsRotateSecretCmd,
+		integrationsRemoveSecretCmd,
+		integrationsLintCmd,
 	},
 }
 
@@ -92,7 +100,47 @@ var integrationsCreateCmd = &args.Command{
 		{
 			Long: "redirect",
 			Type: args.OptionTypeParameter,
-			Help: "redirect URL",
+			Help: "default redirect URL",
+		},
+		{
+			Long: "extra-redirect",
+			Type: args.OptionTypeArray,
+			Help: "additional redirect URL",
+		},
+		{
+			Long: "access-token-lifetime",
+			Type: args.OptionTypeParameter,
+			Help: "access token lifetime, e.g. 15m",
+		},
+		{
+			Long: "refresh-token-lifetime",
+			Type: args.OptionTypeParameter,
+			Help: "refresh token lifetime, e.g. 168h",
+		},
+		{
+			Long: "auth-code-lifetime",
+			Type: args.OptionTypeParameter,
+			Help: "authorization code lifetime, e.g. 60s",
+		},
+		{
+			Long: "allowed-scope",
+			Type: args.OptionTypeArray,
+			Help: "scope the integration may request",
+		},
+		{
+			Long: "exchange-audience",
+			Type: args.OptionTypeArray,
+			Help: "audience the integration may exchange access tokens for",
+		},
+		{
+			Long: "release-claim",
+			Type: args.OptionTypeArray,
+			Help: "user claim the integration may receive, e.g. email or groups",
+		},
+		{
+			Long: "reuse-refresh-tokens",
+			Type: args.OptionTypeFlag,
+			Help: "keep refresh tokens valid across refreshes instead of rotating them",
 		},
 	},
 	Handler: func(i *args.Input) error {
@@ -113,11 +161,21 @@ var integrationsCreateCmd = &args.Command{
 			return fmt.Errorf("--display, --audience, and --redirect are required")
 		}
 
+		policy := api.IntegrationPolicy{}
+		hasPolicy, err := applyPolicyOptions(i, &policy)
+		if err != nil {
+			return err
+		}
+
 		payload := api.Integration{
-			Name:     name,
-			Display:  *display,
-			Audience: *audience,
-			Redirect: *redirect,
+			Name:      name,
+			Display:   *display,
+			Audience:  *audience,
+			Redirect:  *redirect,
+			Redirects: i.GetArray("extra-redirect"),
+		}
+		if hasPolicy {
+			payload.Policy = &policy
 		}
 		body, err := json.Marshal(payload)
 		if err != nil {
@@ -156,7 +214,72 @@ var integrationsUpdateCmd = &args.Command{
 		{
 			Long: "redirect",
 			Type: args.OptionTypeParameter,
-			Help: "redirect URL",
+			Help: "default redirect URL",
+		},
+		{
+			Long: "extra-redirect",
+			Type: args.OptionTypeArray,
+			Help: "additional redirect URL, replacing existing ones",
+		},
+		{
+			Long: "clear-extra-redirects",
+			Type: args.OptionTypeFlag,
+			Help: "remove all additional redirect URLs",
+		},
+		{
+			Long: "access-token-lifetime",
+			Type: args.OptionTypeParameter,
+			Help: "access token lifetime, e.g. 15m",
+		},
+		{
+			Long: "refresh-token-lifetime",
+			Type: args.OptionTypeParameter,
+			Help: "refresh token lifetime, e.g. 168h",
+		},
+		{
+			Long: "auth-code-lifetime",
+			Type: args.OptionTypeParameter,
+			Help: "authorization code lifetime, e.g. 60s",
+		},
+		{
+			Long: "allowed-scope",
+			Type: args.OptionTypeArray,
+			Help: "scope the integration may request, replacing existing ones",
+		},
+		{
+			Long: "exchange-audience",
+			Type: args.OptionTypeArray,
+			Help: "audience the integration may exchange access tokens for, replacing existing ones",
+		},
+		{
+			Long: "release-claim",
+			Type: args.OptionTypeArray,
+			Help: "user claim the integration may receive, replacing existing ones",
+		},
+		{
+			Long: "reuse-refresh-tokens",
+			Type: args.OptionTypeFlag,
+			Help: "keep refresh tokens valid across refreshes instead of rotating them",
+		},
+		{
+			Long: "clear-allowed-scopes",
+			Type: args.OptionTypeFlag,
+			Help: "allow every registered scope",
+		},
+		{
+			Long: "clear-exchange-audiences",
+			Type: args.OptionTypeFlag,
+			Help: "disallow token exchange",
+		},
+		{
+			Long: "clear-released-claims",
+			Type: args.OptionTypeFlag,
+			Help: "release every claim except groups",
+		},
+		{
+			Long: "rotate-refresh-tokens",
+			Type: args.OptionTypeFlag,
+			Help: "rotate refresh tokens on every refresh",
 		},
 	},
 	Handler: func(i *args.Input) error {
@@ -173,8 +296,56 @@ var integrationsUpdateCmd = &args.Command{
 		display := i.GetParameter("display")
 		audience := i.GetParameter("audience")
 		redirect := i.GetParameter("redirect")
-		if display == nil && audience == nil && redirect == nil {
-			return fmt.Errorf("at least one of --display, --audience, or --redirect is required")
+		extraRedirects := i.GetArray("extra-redirect")
+		clearExtraRedirects := i.GetFlag("clear-extra-redirects")
+		if len(extraRedirects) > 0 && clearExtraRedirects {
+			return fmt.Errorf("--extra-redirect and --clear-extra-redirects cannot be combined")
+		}
+
+		// policies are replaced whole, so start from the current one
+		var current api.Integration
+		if err := client.Get("/admin/integrations/"+name, &current); err != nil {
+			return err
+		}
+		policy := api.IntegrationPolicy{}
+		if current.Policy != nil {
+			policy = *current.Policy
+		}
+		hasPolicy, err := applyPolicyOptions(i, &policy)
+		if err != nil {
+			return err
+		}
+		if i.GetFlag("clear-allowed-scopes") {
+			if len(i.GetArray("allowed-scope")) > 0 {
+				return fmt.Errorf("--allowed-scope and --clear-allowed-scopes cannot be combined")
+			}
+			policy.AllowedScopes = nil
+			hasPolicy = true
+		}
+		if i.GetFlag("clear-exchange-audiences") {
+			if len(i.GetArray("exchange-audience")) > 0 {
+				return fmt.Errorf("--exchange-audience and --clear-exchange-audiences cannot be combined")
+			}
+			policy.ExchangeAudiences = nil
+			hasPolicy = true
+		}
+		if i.GetFlag("clear-released-claims") {
+			if len(i.GetArray("release-claim")) > 0 {
+				return fmt.Errorf("--release-claim and --clear-released-claims cannot be combined")
+			}
+			policy.ReleasedClaims = nil
+			hasPolicy = true
+		}
+		if i.GetFlag("rotate-refresh-tokens") {
+			if i.GetFlag("reuse-refresh-tokens") {
+				return fmt.Errorf("--reuse-refresh-tokens and --rotate-refresh-tokens cannot be combined")
+			}
+			policy.ReuseRefreshTokens = false
+			hasPolicy = true
+		}
+
+		if display == nil && audience == nil && redirect == nil && len(extraRedirects) == 0 && !clearExtraRedirects && !hasPolicy {
+			return fmt.Errorf("at least one field to update is required")
 		}
 
 		payload := api.UpdateIntegrationRequest{
@@ -182,6 +353,14 @@ var integrationsUpdateCmd = &args.Command{
 			Audience: audience,
 			Redirect: redirect,
 		}
+		if clearExtraRedirects {
+			payload.Redirects = &[]string{}
+		} else if len(extraRedirects) > 0 {
+			payload.Redirects = &extraRedirects
+		}
+		if hasPolicy {
+			payload.Policy = &policy
+		}
 		body, err := json.Marshal(payload)
 		if err != nil {
 			return err
@@ -225,6 +404,120 @@ var integrationsDeleteCmd = &args.Command{
 	},
 }
 
+var integrationsRotateSecretCmd = &args.Command{
+	Name: "rotate-secret",
+	Help: "generate a new client secret, replacing any existing one",
+	Operands: []args.Operand{
+		{
+			Name: "name",
+			Help: "integration name",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
+		if err != nil {
+			return err
+		}
+
+		name := i.GetOperand("name")
+		if name == "" {
+			return fmt.Errorf("integration name is required")
+		}
+
+		var response api.IntegrationSecretResponse
+		if err := client.Post("/admin/integrations/"+name+"/secret", nil, &response); err != nil {
+			return err
+		}
+
+		fmt.Println(response.Secret)
+		return nil
+	},
+}
+
+var integrationsRemoveSecretCmd = &args.Command{
+	Name: "remove-secret",
+	Help: "remove the client secret, making the integration a public client",
+	Operands: []args.Operand{
+		{
+			Name: "name",
+			Help: "integration name",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
+		if err != nil {
+			return err
+		}
+
+		name := i.GetOperand("name")
+		if name == "" {
+			return fmt.Errorf("integration name is required")
+		}
+
+		if err := client.Delete("/admin/integrations/"+name+"/secret", nil); err != nil {
+			return err
+		}
+
+		fmt.Println("ok")
+		return nil
+	},
+}
+
+// applyPolicyOptions sets the token policy options given on the command line
+// and reports whether any were given.
+func applyPolicyOptions(
+	i *args.Input,
+	policy *api.IntegrationPolicy,
+) (
+	bool,
+	error,
+) {
+	changed := false
+
+	if value := i.GetParameter("access-token-lifetime"); value != nil {
+		lifetime, err := time.ParseDuration(*value)
+		if err != nil {
+			return false, fmt.Errorf("invalid --access-token-lifetime: %w", err)
+		}
+		policy.AccessTokenLifetime = int(lifetime / time.Second)
+		changed = true
+	}
+	if value := i.GetParameter("refresh-token-lifetime"); value != nil {
+		lifetime, err := time.ParseDuration(*value)
+		if err != nil {
+			return false, fmt.Errorf("invalid --refresh-token-lifetime: %w", err)
+		}
+		policy.RefreshTokenLifetime = int(lifetime / time.Second)
+		changed = true
+	}
+	if value := i.GetParameter("auth-code-lifetime"); value != nil {
+		lifetime, err := time.ParseDuration(*value)
+		if err != nil {
+			return false, fmt.Errorf("invalid --auth-code-lifetime: %w", err)
+		}
+		policy.AuthCodeLifetime = int(lifetime / time.Second)
+		changed = true
+	}
+	if scopes := i.GetArray("allowed-scope"); len(scopes) > 0 {
+		policy.AllowedScopes = scopes
+		changed = true
+	}
+	if audiences := i.GetArray("exchange-audience"); len(audiences) > 0 {
+		policy.ExchangeAudiences = audiences
+		changed = true
+	}
+	if claims := i.GetArray("release-claim"); len(claims) > 0 {
+		policy.ReleasedClaims = claims
+		changed = true
+	}
+	if i.GetFlag("reuse-refresh-tokens") {
+		policy.ReuseRefreshTokens = true
+		changed = true
+	}
+
+	return changed, nil
+}
+
 func printJSON(value any) error {
 	payload, err := json.MarshalIndent(value, "", "  ")
 	if err != nil {
@@ -234,3 +527,110 @@ func printJSON(value any) error {
 	fmt.Println(string(payload))
 	return nil
 }
+
+var integrationsLintCmd = &args.Command{
+	Name: "lint",
+	Help: "check integration definition files without contacting the server",
+	Operands: []args.Operand{
+		{
+			Name: "path",
+			Help: "JSON file, or directory of .json files, holding an integration or a list of them",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		path := i.GetOperand("path")
+		if path == "" {
+			return fmt.Errorf("path is required")
+		}
+
+		definitions, err := readIntegrationDefinitions(path)
+		if err != nil {
+			return err
+		}
+
+		integrations := make([]service.Integration, 0, len(definitions))
+		for _, definition := range definitions {
+			integrations = append(integrations, definition.integration.ToDomain())
+		}
+
+		problems := service.LintIntegrations(integrations)
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", definitions[problem.Index].file, problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problem(s) in %d integration(s)", len(problems), len(definitions))
+		}
+
+		fmt.Printf("%d integration(s) ok\n", len(definitions))
+		return nil
+	},
+}
+
+type integrationDefinition struct {
+	file        string
+	integration api.Integration
+}
+
+// readIntegrationDefinitions reads integrations from a JSON file, or from
+// every .json file in a directory. Each file holds one integration or a list,
+// in the shape `integrations get` and `integrations list` print. Unknown
+// fields are rejected so misspelled keys don't pass silently.
+func readIntegrationDefinitions(
+	path string,
+) (
+	[]integrationDefinition,
+	error,
+) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no .json files in %s", path)
+		}
+	}
+
+	var definitions []integrationDefinition
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var integrations []api.Integration
+		trimmed := bytes.TrimSpace(data)
+		if bytes.HasPrefix(trimmed, []byte("[")) {
+			err = decodeStrict(trimmed, &integrations)
+		} else {
+			var integration api.Integration
+			err = decodeStrict(trimmed, &integration)
+			integrations = append(integrations, integration)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		for _, integration := range integrations {
+			definitions = append(definitions, integrationDefinition{
+				file:        file,
+				integration: integration,
+			})
+		}
+	}
+	return definitions, nil
+}
+
+func decodeStrict(
+	data []byte,
+	v any,
+) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/command-go/pkg/envs"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+)
+
+var statsCmd = &args.Command{
+	Name: "stats",
+	Help: "show sign-in counts by integration since the server started",
+	Handler: func(i *args.Input) error {
+		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
+		if err != nil {
+			return err
+		}
+
+		var stats api.Stats
+		if err := client.Get("/admin/stats", &stats); err != nil {
+			return err
+		}
+
+		return printJSON(stats)
+	},
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/command-go/pkg/envs"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+)
+
+var tokensCmd = &args.Command{
+	Name: "tokens",
+	Help: "manage issued tokens",
+	Subcommands: []*args.Command{
+		tokensRevokeCmd,
+	},
+}
+
+var tokensRevokeCmd = &args.Command{
+	Name: "revoke",
+	Help: "revoke every refresh token issued to an audience",
+	Operands: []args.Operand{
+		{
+			Name: "audience",
+			Help: "audience of the app whose sessions to end",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
+		if err != nil {
+			return err
+		}
+
+		audience := i.GetOperand("audience")
+		if audience == "" {
+			return fmt.Errorf("audience is required")
+		}
+
+		body, err := json.Marshal(api.RevokeTokensRequest{Audience: audience})
+		if err != nil {
+			return err
+		}
+
+		var response api.RevokeTokensResponse
+		if err := client.Post("/admin/tokens/revoke", body, &response); err != nil {
+			return err
+		}
+
+		fmt.Printf("revoked %d refresh tokens\n", response.Revoked)
+		return nil
+	},
+}
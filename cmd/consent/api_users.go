@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/args"
 	"git.sr.ht/~jakintosh/command-go/pkg/envs"
@@ -18,7 +19,10 @@ var usersCmd = &args.Command{
 		usersGetCmd,
 		usersCreateCmd,
 		usersUpdateCmd,
+		usersDisableCmd,
+		usersEnableCmd,
 		usersDeleteCmd,
+		usersImpersonateCmd,
 	},
 }
 
@@ -89,6 +93,11 @@ var usersCreateCmd = &args.Command{
 			Type: args.OptionTypeArray,
 			Help: "user role",
 		},
+		{
+			Long: "expires",
+			Type: args.OptionTypeParameter,
+			Help: "RFC 3339 time after which the user can no longer sign in",
+		},
 	},
 	Handler: func(i *args.Input) error {
 		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
@@ -116,6 +125,13 @@ var usersCreateCmd = &args.Command{
 			Password: *password,
 			Roles:    roles,
 		}
+		if expires := i.GetParameter("expires"); expires != nil {
+			expiresAt, err := time.Parse(time.RFC3339, *expires)
+			if err != nil {
+				return fmt.Errorf("--expires must be an RFC 3339 time: %w", err)
+			}
+			payload.ExpiresAt = &expiresAt
+		}
 		body, err := json.Marshal(payload)
 		if err != nil {
 			return err
@@ -150,6 +166,11 @@ var usersUpdateCmd = &args.Command{
 			Type: args.OptionTypeArray,
 			Help: "user role",
 		},
+		{
+			Long: "expires",
+			Type: args.OptionTypeParameter,
+			Help: "RFC 3339 time after which the user can no longer sign in, or \"never\"",
+		},
 	},
 	Handler: func(i *args.Input) error {
 		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
@@ -164,8 +185,9 @@ var usersUpdateCmd = &args.Command{
 
 		handle := i.GetParameter("handle")
 		roles := i.GetArray("role")
-		if handle == nil && len(roles) == 0 {
-			return fmt.Errorf("at least one of --handle or --role is required")
+		expires := i.GetParameter("expires")
+		if handle == nil && len(roles) == 0 && expires == nil {
+			return fmt.Errorf("at least one of --handle, --role or --expires is required")
 		}
 
 		payload := api.UpdateUserRequest{
@@ -174,6 +196,15 @@ var usersUpdateCmd = &args.Command{
 		if len(roles) > 0 {
 			payload.Roles = &roles
 		}
+		if expires != nil {
+			expiresAt := *expires
+			if expiresAt == "never" {
+				expiresAt = ""
+			} else if _, err := time.Parse(time.RFC3339, expiresAt); err != nil {
+				return fmt.Errorf("--expires must be an RFC 3339 time or \"never\": %w", err)
+			}
+			payload.ExpiresAt = &expiresAt
+		}
 
 		body, err := json.Marshal(payload)
 		if err != nil {
@@ -189,6 +220,61 @@ var usersUpdateCmd = &args.Command{
 	},
 }
 
+var usersDisableCmd = &args.Command{
+	Name: "disable",
+	Help: "stop a user signing in or refreshing tokens, keeping their data",
+	Operands: []args.Operand{
+		{
+			Name: "subject",
+			Help: "user subject",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		return setUserDisabled(i, true)
+	},
+}
+
+var usersEnableCmd = &args.Command{
+	Name: "enable",
+	Help: "let a disabled user sign in again",
+	Operands: []args.Operand{
+		{
+			Name: "subject",
+			Help: "user subject",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		return setUserDisabled(i, false)
+	},
+}
+
+func setUserDisabled(
+	i *args.Input,
+	disabled bool,
+) error {
+	client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
+	if err != nil {
+		return err
+	}
+
+	subject := i.GetOperand("subject")
+	if subject == "" {
+		return fmt.Errorf("user subject is required")
+	}
+
+	body, err := json.Marshal(api.UpdateUserRequest{Disabled: &disabled})
+	if err != nil {
+		return err
+	}
+
+	if err := client.Patch("/admin/users/"+subject, body, nil); err != nil {
+		return err
+	}
+
+	fmt.Println("ok")
+	return nil
+}
+
 var usersDeleteCmd = &args.Command{
 	Name: "delete",
 	Help: "delete a user",
@@ -217,3 +303,77 @@ var usersDeleteCmd = &args.Command{
 		return nil
 	},
 }
+
+var usersImpersonateCmd = &args.Command{
+	Name: "impersonate",
+	Help: "issue an access token to act as a user",
+	Operands: []args.Operand{
+		{
+			Name: "subject",
+			Help: "user subject",
+		},
+	},
+	Options: []args.Option{
+		{
+			Long: "actor-token",
+			Type: args.OptionTypeParameter,
+			Help: "access token from the impersonating admin's own sign-in to consent",
+		},
+		{
+			Long: "integration",
+			Type: args.OptionTypeParameter,
+			Help: "integration to issue the token for",
+		},
+		{
+			Long: "scope",
+			Type: args.OptionTypeArray,
+			Help: "scope to grant",
+		},
+		{
+			Long: "reason",
+			Type: args.OptionTypeParameter,
+			Help: "reason recorded in the audit log",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		client, err := envs.ResolveClient(i, config.DefaultConfigDir(), config.APIUrlPrefix)
+		if err != nil {
+			return err
+		}
+
+		subject := i.GetOperand("subject")
+		if subject == "" {
+			return fmt.Errorf("user subject is required")
+		}
+
+		actorToken := i.GetParameter("actor-token")
+		if actorToken == nil {
+			return fmt.Errorf("--actor-token is required")
+		}
+
+		integration := i.GetParameter("integration")
+		if integration == nil {
+			return fmt.Errorf("--integration is required")
+		}
+
+		payload := api.ImpersonateRequest{
+			ActorToken:  *actorToken,
+			Integration: *integration,
+			Scopes:      i.GetArray("scope"),
+		}
+		if reason := i.GetParameter("reason"); reason != nil {
+			payload.Reason = *reason
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		var response api.ImpersonateResponse
+		if err := client.Post("/admin/users/"+subject+"/impersonate", body, &response); err != nil {
+			return err
+		}
+
+		return printJSON(response)
+	},
+}
@@ -16,6 +16,7 @@ var configCmd = &args.Command{
 	Subcommands: []*args.Command{
 		configInitCmd,
 		configShowCmd,
+		configValidateCmd,
 	},
 }
 
@@ -72,6 +73,7 @@ var configShowCmd = &args.Command{
 		resolved := i.GetFlag("resolved")
 		cfgDir := i.GetParameterOr("config-dir", "")
 		dataDir := i.GetParameterOr("data-dir", "")
+		cfgFile := i.GetParameterOr("config", "")
 
 		var cfgYaml any
 		if resolved {
@@ -81,6 +83,7 @@ var configShowCmd = &args.Command{
 			}
 
 			opts := config.RuntimeOptions{
+				ConfigFile:             cfgFile,
 				Overrides:              overrides,
 				RequireSigningKey:      false,
 				RequireBootstrapAPIKey: false,
@@ -92,7 +95,13 @@ var configShowCmd = &args.Command{
 
 			cfgYaml = runtime.View()
 		} else {
-			cfg, err := config.Load(cfgDir, dataDir)
+			var cfg config.Config
+			var err error
+			if cfgFile != "" {
+				cfg, err = config.LoadFile(cfgFile)
+			} else {
+				cfg, err = config.Load(cfgDir, dataDir)
+			}
 			if err != nil {
 				return err
 			}
@@ -108,3 +117,36 @@ var configShowCmd = &args.Command{
 		return err
 	},
 }
+
+var configValidateCmd = &args.Command{
+	Name: "validate",
+	Help: "check that config, environment, and secrets are ready to serve",
+	Handler: func(i *args.Input) error {
+
+		cfgDir := i.GetParameterOr("config-dir", "")
+		dataDir := i.GetParameterOr("data-dir", "")
+		overrides, err := resolveOverrides(i)
+		if err != nil {
+			return err
+		}
+
+		// mirror what serve requires so a passing check means serve will start
+		opts := config.RuntimeOptions{
+			ConfigFile:             i.GetParameterOr("config", ""),
+			Overrides:              overrides,
+			RequireSigningKey:      true,
+			RequireBootstrapAPIKey: false,
+		}
+		runtime, err := config.Resolve(cfgDir, dataDir, opts)
+		if err != nil {
+			return err
+		}
+
+		if runtime.Source.ConfigFilePresent {
+			fmt.Printf("config ok: %s\n", runtime.Paths.ConfigFile)
+		} else {
+			fmt.Printf("config ok: %s not found, using defaults\n", runtime.Paths.ConfigFile)
+		}
+		return nil
+	},
+}
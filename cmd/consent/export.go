@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+	"git.sr.ht/~jakintosh/consent/internal/database"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+var exportCmd = &args.Command{
+	Name: "export",
+	Help: "write identities, credentials, grants, and integrations to a backup file",
+	Options: append([]args.Option{
+		{
+			Long: "format",
+			Type: args.OptionTypeParameter,
+			Help: "export format; only json is supported",
+		},
+		{
+			Long: "output",
+			Type: args.OptionTypeParameter,
+			Help: "file to write instead of stdout",
+		},
+	}, runtimeOptions...),
+	Handler: func(i *args.Input) error {
+		format := strings.ToLower(strings.TrimSpace(i.GetParameterOr("format", "json")))
+		if format != "json" {
+			return fmt.Errorf("invalid --format %q: expected json", format)
+		}
+
+		runtime, db, err := openLocalStore(i)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		snapshot, err := service.Export(context.Background(), db)
+		if err != nil {
+			return err
+		}
+		payload, err := json.MarshalIndent(api.ExportFromDomain(snapshot), "", "  ")
+		if err != nil {
+			return err
+		}
+		payload = append(payload, '\n')
+
+		output := strings.TrimSpace(i.GetParameterOr("output", ""))
+		if output == "" {
+			_, err := os.Stdout.Write(payload)
+			return err
+		}
+		// the export holds password hashes, so keep it private
+		if err := os.WriteFile(output, payload, 0o600); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "exported %d users and %d integrations from %s\n",
+			len(snapshot.Users), len(snapshot.Integrations), runtime.Paths.DatabaseFile)
+		return nil
+	},
+}
+
+var importCmd = &args.Command{
+	Name:    "import",
+	Help:    "restore identities, credentials, grants, and integrations from an export",
+	Options: runtimeOptions,
+	Operands: []args.Operand{
+		{
+			Name: "path",
+			Help: "export file to read, or - for stdin",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		path := i.GetOperand("path")
+		if path == "" {
+			return fmt.Errorf("path is required")
+		}
+
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read export: %w", err)
+		}
+		var export api.Export
+		if err := json.Unmarshal(data, &export); err != nil {
+			return fmt.Errorf("failed to parse export: %w", err)
+		}
+
+		runtime, db, err := openLocalStore(i)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		// the target's own system integration and roles come first, as
+		// `consent init` seeds them
+		ctx := context.Background()
+		if err := service.SeedSystemIntegrations(ctx, db, runtime.Server.PublicURL); err != nil {
+			return err
+		}
+		if err := service.SeedSystemRoles(ctx, db); err != nil {
+			return err
+		}
+
+		result, err := service.Import(ctx, db, export.ToDomain())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("database: %s\n", runtime.Paths.DatabaseFile)
+		fmt.Printf("imported: %d roles, %d integrations, %d users\n", result.Roles, result.Integrations, result.Users)
+		for _, skipped := range result.Skipped {
+			fmt.Printf("skipped existing %s\n", skipped)
+		}
+		return nil
+	},
+}
+
+// openLocalStore resolves the runtime config and opens its database
+// directly, for commands that work without a running server.
+func openLocalStore(
+	i *args.Input,
+) (
+	config.Runtime,
+	*database.DB,
+	error,
+) {
+	overrides, err := resolveOverrides(i)
+	if err != nil {
+		return config.Runtime{}, nil, err
+	}
+	runtime, err := config.Resolve(
+		i.GetParameterOr("config-dir", ""),
+		i.GetParameterOr("data-dir", ""),
+		config.RuntimeOptions{Overrides: overrides},
+	)
+	if err != nil {
+		return config.Runtime{}, nil, err
+	}
+
+	db, err := database.Open(database.Options{
+		Path:         runtime.Paths.DatabaseFile,
+		WAL:          true,
+		BusyTimeout:  runtime.Config.Storage.BusyTimeout,
+		QueryTimeout: runtime.Config.Storage.QueryTimeout,
+	})
+	if err != nil {
+		return config.Runtime{}, nil, err
+	}
+	return runtime, db, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/args"
@@ -33,7 +34,10 @@ var initCmd = &args.Command{
 		}
 
 		dbOpts := database.Options{
-			Path: runtime.Paths.DatabaseFile,
+			Path:         runtime.Paths.DatabaseFile,
+			WAL:          true,
+			BusyTimeout:  runtime.Config.Storage.BusyTimeout,
+			QueryTimeout: runtime.Config.Storage.QueryTimeout,
 		}
 		db, err := database.Open(dbOpts)
 		if err != nil {
@@ -47,7 +51,7 @@ var initCmd = &args.Command{
 			PublicURL:      runtime.Server.PublicURL,
 			BootstrapToken: runtime.Secrets.BootstrapAPIKey,
 		}
-		if err := service.Init(initOpts); err != nil {
+		if err := service.Init(context.Background(), initOpts); err != nil {
 			return err
 		}
 
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+)
+
+var keygenCmd = &args.Command{
+	Name: "keygen",
+	Help: "generate a token signing key pair",
+	Options: []args.Option{
+		{
+			Long: "out-dir",
+			Type: args.OptionTypeParameter,
+			Help: "directory to write signing_key and verification_key.der to",
+		},
+		{
+			Long: "force",
+			Type: args.OptionTypeFlag,
+			Help: "overwrite existing key files",
+		},
+		{
+			Long: "print",
+			Type: args.OptionTypeParameter,
+			Help: "also print the verification key as base64, pem, or jwk",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		outDir := strings.TrimSpace(i.GetParameterOr("out-dir", ""))
+		if outDir == "" {
+			return fmt.Errorf("--out-dir is required")
+		}
+		format := strings.ToLower(strings.TrimSpace(i.GetParameterOr("print", "")))
+		switch format {
+		case "", "base64", "pem", "jwk":
+		default:
+			return fmt.Errorf("invalid --print %q: expected base64, pem, or jwk", format)
+		}
+
+		result, err := config.Keygen(outDir, i.GetFlag("force"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("signing key: %s\n", result.SigningKeyFile)
+		fmt.Printf("verification key: %s\n", result.VerificationKeyFile)
+		if format == "" {
+			return nil
+		}
+
+		encoded, err := encodeVerificationKey(result.VerificationKey, format)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		fmt.Println(encoded)
+		return nil
+	},
+}
+
+// encodeVerificationKey renders key for pasting into another system's
+// configuration.
+func encodeVerificationKey(
+	key *ecdsa.PublicKey,
+	format string,
+) (
+	string,
+	error,
+) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode verification key: %w", err)
+	}
+
+	switch format {
+	case "base64":
+		return base64.StdEncoding.EncodeToString(der), nil
+	case "pem":
+		block := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		return strings.TrimSpace(string(block)), nil
+	case "jwk":
+		// P-256 coordinates are fixed-width 32-byte big-endian values
+		point, err := key.ECDH()
+		if err != nil {
+			return "", fmt.Errorf("failed to encode verification key: %w", err)
+		}
+		raw := point.Bytes() // 0x04 || X || Y
+		jwk := struct {
+			KeyType   string `json:"kty"`
+			Curve     string `json:"crv"`
+			X         string `json:"x"`
+			Y         string `json:"y"`
+			Use       string `json:"use"`
+			Algorithm string `json:"alg"`
+		}{
+			KeyType:   "EC",
+			Curve:     "P-256",
+			X:         base64.RawURLEncoding.EncodeToString(raw[1:33]),
+			Y:         base64.RawURLEncoding.EncodeToString(raw[33:65]),
+			Use:       "sig",
+			Algorithm: "ES256",
+		}
+		payload, err := json.MarshalIndent(jwk, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(payload), nil
+	default:
+		return "", fmt.Errorf("unknown key format %q", format)
+	}
+}
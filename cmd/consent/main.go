@@ -22,6 +22,11 @@ var envsOpts = envs.CommandOptions{
 }
 
 var runtimeOptions = []args.Option{
+	{
+		Long: "config",
+		Type: args.OptionTypeParameter,
+		Help: "config file to use instead of config.yaml in the config dir",
+	},
 	{
 		Long: "public-url",
 		Type: args.OptionTypeParameter,
@@ -42,6 +47,31 @@ var runtimeOptions = []args.Option{
 		Type: args.OptionTypeFlag,
 		Help: "dev mode",
 	},
+	{
+		Long: "tls-cert",
+		Type: args.OptionTypeParameter,
+		Help: "TLS certificate file; serve HTTPS directly",
+	},
+	{
+		Long: "tls-key",
+		Type: args.OptionTypeParameter,
+		Help: "TLS private key file",
+	},
+	{
+		Long: "autocert",
+		Type: args.OptionTypeFlag,
+		Help: "serve HTTPS with certificates obtained automatically over ACME",
+	},
+	{
+		Long: "maintenance",
+		Type: args.OptionTypeFlag,
+		Help: "start in read-only maintenance mode",
+	},
+	{
+		Long: "templates-path",
+		Type: args.OptionTypeParameter,
+		Help: "directory of page templates overriding the built-in ones",
+	},
 	{
 		Short: 'v',
 		Long:  "verbose",
@@ -70,8 +100,12 @@ var root = &args.Command{
 	Subcommands: []*args.Command{
 		apiCmd,
 		configCmd,
+		exportCmd,
+		importCmd,
 		initCmd,
+		keygenCmd,
 		serveCmd,
+		tokenCmd,
 		envs.Command(envsOpts),
 		version.Command(VersionInfo),
 	},
@@ -112,5 +146,30 @@ func resolveOverrides(
 		overrides.DevMode = &devMode
 	}
 
+	if value := i.GetParameter("tls-cert"); value != nil {
+		trimmed := strings.TrimSpace(*value)
+		overrides.TLSCertFile = &trimmed
+	}
+
+	if value := i.GetParameter("tls-key"); value != nil {
+		trimmed := strings.TrimSpace(*value)
+		overrides.TLSKeyFile = &trimmed
+	}
+
+	if i.GetFlag("autocert") {
+		autocert := true
+		overrides.Autocert = &autocert
+	}
+
+	if i.GetFlag("maintenance") {
+		maintenance := true
+		overrides.Maintenance = &maintenance
+	}
+
+	if value := i.GetParameter("templates-path"); value != nil {
+		trimmed := strings.TrimSpace(*value)
+		overrides.TemplatesPath = &trimmed
+	}
+
 	return overrides, nil
 }
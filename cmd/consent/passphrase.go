@@ -0,0 +1,15 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// promptPassphrase asks for the signing key passphrase on the terminal,
+// without echoing it.
+func promptPassphrase() ([]byte, error) {
+	fmt.Fprint(os.Stderr, "signing key passphrase: ")
+	passphrase, err := readPassphrase(os.Stdin)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// readPassphrase can't disable echo on this platform, so it refuses to
+// prompt; supply the passphrase through the environment instead.
+func readPassphrase(f *os.File) ([]byte, error) {
+	return nil, errors.New("passphrase prompt unsupported on this platform")
+}
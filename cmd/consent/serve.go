@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/config"
 	"git.sr.ht/~jakintosh/consent/internal/server"
 	"git.sr.ht/~jakintosh/consent/internal/service"
@@ -19,26 +25,49 @@ var serveCmd = &args.Command{
 			Type: args.OptionTypeFlag,
 			Help: "emit Secure=false auth cookies",
 		},
+		args.Option{
+			Long: "dev",
+			Type: args.OptionTypeFlag,
+			Help: "run a throwaway local server: generated keys, an in-memory database, a test user, and a localhost integration",
+		},
 	),
 	Handler: func(i *args.Input) error {
 		cfgDir := i.GetParameterOr("config-dir", "")
 		dataDir := i.GetParameterOr("data-dir", "")
 		insecureCookies := i.GetFlag("insecure-cookies")
 		verbose := i.GetFlag("verbose")
+		dev := i.GetFlag("dev")
 
 		overrides, err := resolveOverrides(i)
 		if err != nil {
 			return err
 		}
 
-		runtimeOpts := config.RuntimeOptions{
-			Overrides:              overrides,
-			RequireSigningKey:      true,
-			RequireBootstrapAPIKey: false,
+		var runtime config.Runtime
+		if dev {
+			if i.GetParameter("config") != nil {
+				return fmt.Errorf("--dev doesn't read a config file; drop --config")
+			}
+			if runtime, err = config.ResolveDev(overrides); err != nil {
+				return err
+			}
+			insecureCookies = true
+		} else {
+			runtimeOpts := config.RuntimeOptions{
+				ConfigFile:             i.GetParameterOr("config", ""),
+				Overrides:              overrides,
+				RequireSigningKey:      true,
+				RequireBootstrapAPIKey: false,
+				PromptPassphrase:       promptPassphrase,
+			}
+			if runtime, err = config.Resolve(cfgDir, dataDir, runtimeOpts); err != nil {
+				return err
+			}
 		}
-		runtime, err := config.Resolve(cfgDir, dataDir, runtimeOpts)
-		if err != nil {
-			return err
+
+		// --verbose turns on request logging unless config chose a format
+		if verbose && runtime.Config.Server.AccessLog == "" {
+			runtime.Server.AccessLog = accesslog.FormatCommon
 		}
 
 		if verbose {
@@ -50,7 +79,13 @@ var serveCmd = &args.Command{
 			log.Printf("  Authority: %s", runtime.Server.AuthorityDomain)
 			log.Printf("  Listen: %s", runtime.Server.ListenAddress)
 			log.Printf("  Dev mode: %t", runtime.Server.DevMode)
+			log.Printf("  Maintenance: %t", runtime.Server.Maintenance)
+			log.Printf("  TLS: %s", runtime.View().Server.TLS)
+			log.Printf("  Access log: %s", runtime.Server.AccessLog)
 			log.Printf("  Insecure cookies: %t", insecureCookies)
+			for _, realm := range runtime.Realms {
+				log.Printf("  Realm %s: %s (database %s)", realm.Name, realm.Server.PublicURL, realm.Paths.DatabaseFile)
+			}
 		}
 
 		serverOpts := server.Options{
@@ -58,6 +93,60 @@ var serveCmd = &args.Command{
 			InsecureCookies: insecureCookies,
 			PasswordMode:    service.PasswordModeProduction,
 		}
-		return server.Serve(serverOpts)
+		if dev {
+			serverOpts.InitializeStore = true
+			serverOpts.Seed = seedDev
+			printDevBanner(runtime)
+		}
+
+		// stop gracefully on Ctrl-C or a service manager's SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := server.Serve(ctx, serverOpts); err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("Consent server stopped")
+		}
+		return nil
 	},
 }
+
+// Fixtures `serve --dev` seeds. The integration's redirect names no port,
+// so it matches an app on any localhost port.
+const (
+	devUserHandle       = "test"
+	devUserPassword     = "test"
+	devIntegrationName  = "example@localhost"
+	devIntegrationTitle = "Local Development"
+	devAudience         = "localhost"
+	devRedirect         = "http://localhost/auth/callback"
+)
+
+// seedDev adds the --dev test user, an admin, and the localhost integration.
+func seedDev(
+	ctx context.Context,
+	svc *service.Service,
+) error {
+	if _, err := svc.CreateUser(ctx, devUserHandle, devUserPassword, []string{service.ProtectedAdminRoleName}); err != nil {
+		return fmt.Errorf("failed to create test user: %w", err)
+	}
+	if err := svc.CreateIntegration(ctx, devIntegrationName, devIntegrationTitle, devAudience, devRedirect); err != nil {
+		return fmt.Errorf("failed to create integration: %w", err)
+	}
+	return nil
+}
+
+// printDevBanner says how to use a --dev server, since nothing about it is
+// written down anywhere else.
+func printDevBanner(
+	runtime config.Runtime,
+) {
+	authURL := runtime.Server.PublicBaseURL
+	log.Printf("Dev mode: keys and data are in memory and gone when the server stops")
+	log.Printf("  Sign in: %s as %s, password %s", authURL, devUserHandle, devUserPassword)
+	log.Printf("  Integration: %s, audience %s, redirecting to %s on any port", devIntegrationName, devAudience, devRedirect)
+	log.Printf("  Bootstrap API key: %s", runtime.Secrets.BootstrapAPIKey)
+	log.Printf("  Try: dev-client --auth-url %s --fetch-key --audience %s", authURL, devAudience)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+var tokenCmd = &args.Command{
+	Name: "token",
+	Help: "inspect and verify tokens",
+	Subcommands: []*args.Command{
+		tokenInspectCmd,
+		tokenVerifyCmd,
+	},
+}
+
+var tokenOperands = []args.Operand{
+	{
+		Name: "jwt",
+		Help: "encoded token",
+	},
+}
+
+var tokenInspectCmd = &args.Command{
+	Name:     "inspect",
+	Help:     "print a token's header and claims without verifying it",
+	Operands: tokenOperands,
+	Handler: func(i *args.Input) error {
+		token, err := parseTokenOperand(i)
+		if err != nil {
+			return err
+		}
+
+		printTokenDetails(token, time.Now())
+		return nil
+	},
+}
+
+var tokenVerifyCmd = &args.Command{
+	Name:     "verify",
+	Help:     "verify a token's signature and claims",
+	Operands: tokenOperands,
+	Options: []args.Option{
+		{
+			Long: "key",
+			Type: args.OptionTypeParameter,
+			Help: "DER or PEM verification key (default: the config dir's verification key)",
+		},
+		{
+			Long: "issuer",
+			Type: args.OptionTypeParameter,
+			Help: "issuer the token must name",
+		},
+		{
+			Long: "audience",
+			Type: args.OptionTypeParameter,
+			Help: "audience the token must include",
+		},
+	},
+	Handler: func(i *args.Input) error {
+		token, err := parseTokenOperand(i)
+		if err != nil {
+			return err
+		}
+
+		keyPath := i.GetParameterOr("key", "")
+		if keyPath == "" {
+			keyPath, err = config.VerificationKeyPath(i.GetParameterOr("config-dir", ""))
+			if err != nil {
+				return err
+			}
+		}
+		key, err := tokens.LoadPublicKeyFile(keyPath)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		printTokenDetails(token, now)
+		fmt.Println()
+
+		var failures []string
+		check := func(name string, err error) {
+			if err != nil {
+				fmt.Printf("%-10s FAIL (%v)\n", name+":", err)
+				failures = append(failures, name)
+				return
+			}
+			fmt.Printf("%-10s ok\n", name+":")
+		}
+
+		check("signature", verifyTokenSignature(token.Encoded(), key))
+		check("issued", func() error {
+			if token.IssuedAt().After(now) {
+				return fmt.Errorf("issued in the future")
+			}
+			return nil
+		}())
+		check("expiry", func() error {
+			if token.Expiration().Before(now) {
+				return fmt.Errorf("expired")
+			}
+			return nil
+		}())
+		if issuer := i.GetParameterOr("issuer", ""); issuer != "" {
+			check("issuer", func() error {
+				if token.Issuer() != issuer {
+					return fmt.Errorf("want %q", issuer)
+				}
+				return nil
+			}())
+		}
+		if audience := i.GetParameterOr("audience", ""); audience != "" {
+			check("audience", func() error {
+				if !slices.Contains(token.Audience(), audience) {
+					return fmt.Errorf("want %q", audience)
+				}
+				return nil
+			}())
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("token failed verification: %s", strings.Join(failures, ", "))
+		}
+		return nil
+	},
+}
+
+func parseTokenOperand(
+	i *args.Input,
+) (
+	*tokens.UnverifiedToken,
+	error,
+) {
+	encoded := strings.TrimSpace(i.GetOperand("jwt"))
+	if encoded == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+
+	token, err := tokens.ParseUnverified(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	return token, nil
+}
+
+func printTokenDetails(
+	token *tokens.UnverifiedToken,
+	now time.Time,
+) {
+	fmt.Println("header:")
+	_ = printJSON(token.Header())
+	fmt.Println("claims:")
+	_ = printJSON(token.Claims())
+	fmt.Println()
+
+	fmt.Printf("subject:   %s\n", token.Subject())
+	fmt.Printf("issuer:    %s\n", token.Issuer())
+	fmt.Printf("audience:  %s\n", strings.Join(token.Audience(), " "))
+	fmt.Printf("issued:    %s\n", describeTokenTime(token.IssuedAt(), now))
+
+	expires := describeTokenTime(token.Expiration(), now)
+	if !token.Expiration().IsZero() && token.Expiration().Before(now) {
+		expires += "  EXPIRED"
+	}
+	fmt.Printf("expires:   %s\n", expires)
+}
+
+func describeTokenTime(
+	at time.Time,
+	now time.Time,
+) string {
+	if at.IsZero() {
+		return "(not set)"
+	}
+	stamp := at.UTC().Format(time.RFC3339)
+	if at.After(now) {
+		return fmt.Sprintf("%s (in %s)", stamp, at.Sub(now).Round(time.Second))
+	}
+	return fmt.Sprintf("%s (%s ago)", stamp, now.Sub(at).Round(time.Second))
+}
+
+// verifyTokenSignature checks only the signature; claims are reported
+// separately so an expired token still shows whether it was genuine.
+func verifyTokenSignature(
+	encoded string,
+	key *ecdsa.PublicKey,
+) error {
+	parts := strings.Split(encoded, ".")
+	validator := tokens.InitClient(tokens.ClientOptions{VerificationKey: key})
+	return validator.VerifySignature(parts[0], parts[1], parts[2])
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+)
+
+// Limits on the notes each user can keep in the demo's memory.
+const (
+	maxNoteLength = 280
+	maxNotes      = 20
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var (
+	homeTemplate   = parsePage("home.html")
+	deniedTemplate = parsePage("denied.html")
+)
+
+func parsePage(
+	name string,
+) *template.Template {
+	return template.Must(template.ParseFS(templateFS, "templates/layout.html", "templates/"+name))
+}
+
+// profileFetcher looks up the signed-in user's profile. *client.Client
+// implements it; verifiers that don't leave the profile handle blank.
+type profileFetcher interface {
+	FetchUserInfo(accessToken string) (*client.UserInfo, error)
+}
+
+// app is the demo integration: a page that shows the session, a JSON API,
+// and a notes form, all protected by verifier.
+type app struct {
+	cfg      Config
+	verifier client.Verifier
+	notes    *noteBook
+}
+
+func newApp(
+	cfg Config,
+	verifier client.Verifier,
+) *app {
+	return &app{
+		cfg:      cfg,
+		verifier: verifier,
+		notes:    &noteBook{notes: map[string][]note{}},
+	}
+}
+
+// routes mounts the app's pages. The login callback and logout route are
+// mounted when the verifier provides them, as *client.Client does.
+func (a *app) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", a.handleHome)
+	mux.HandleFunc("GET /api/profile", a.handleProfile)
+	mux.HandleFunc("POST /notes", a.handleAddNote)
+	if handler, ok := a.verifier.(client.AuthorizationCodeHandler); ok {
+		mux.HandleFunc("GET /auth/callback", handler.HandleAuthorizationCode())
+	}
+	if handler, ok := a.verifier.(client.LogoutHandler); ok {
+		mux.HandleFunc("POST /api/logout", handler.HandleLogout())
+	}
+	return mux
+}
+
+// handleHome shows the session, or a login button without one.
+func (a *app) handleHome(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	page := a.homePage(r)
+	accessToken, csrf, err := a.verifier.VerifyAuthorizationGetCSRF(w, r)
+	if err != nil && !errors.Is(err, client.ErrTokenAbsent) {
+		log.Printf("%s: failed to verify authorization: %v", r.RequestURI, err)
+		page.AuthError = err.Error()
+	}
+	if accessToken != nil {
+		a.signIn(&page, accessToken, csrf)
+	}
+	render(w, r, http.StatusOK, homeTemplate, page)
+}
+
+// handleProfile is a JSON API route. API callers get a 401 they can act on
+// rather than a login redirect.
+func (a *app) handleProfile(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	accessToken, err := a.verifier.VerifyAuthorization(w, r)
+	if err != nil {
+		wire.WriteError(w, http.StatusUnauthorized, "sign in required")
+		return
+	}
+	wire.WriteData(w, http.StatusOK, profileResponse{
+		Subject: accessToken.Subject(),
+		Handle:  a.fetchHandle(accessToken.Encoded()),
+		Scopes:  accessToken.Scopes(),
+		Expires: accessToken.Expiration(),
+	})
+}
+
+// handleAddNote saves a note from the form on the home page. The form
+// carries the CSRF secret in a hidden field; scripts can send it in the
+// Consent-CSRF header instead. The page is rendered in the response rather
+// than redirected to, so the form picks up the CSRF secret of tokens the
+// check refreshed.
+func (a *app) handleAddNote(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	csrf := r.PostFormValue("csrf")
+	if csrf == "" {
+		csrf = client.CSRFFromRequest(r)
+	}
+	accessToken, nextCSRF, err := a.verifier.VerifyAuthorizationCheckCSRF(w, r, csrf)
+	if err != nil {
+		log.Printf("%s: rejected note: %v", r.RequestURI, err)
+		render(w, r, http.StatusForbidden, deniedTemplate, deniedPageData{Reason: err.Error()})
+		return
+	}
+
+	page := a.homePage(r)
+	text := strings.TrimSpace(r.PostFormValue("note"))
+	switch {
+	case text == "":
+		page.NoteError = "Write something first."
+	case len(text) > maxNoteLength:
+		page.NoteError = "Notes are limited to 280 characters."
+	default:
+		a.notes.add(accessToken.Subject(), text)
+		page.NoteSaved = true
+	}
+	a.signIn(&page, accessToken, nextCSRF)
+	render(w, r, http.StatusOK, homeTemplate, page)
+}
+
+func (a *app) homePage(
+	r *http.Request,
+) homePageData {
+	loginURL := a.cfg.AuthURL + "/authorize?" + url.Values{
+		"integration":  {a.cfg.Integration},
+		"scope":        {"identity", "profile"},
+		"redirect_uri": {a.cfg.PublicURL + "/auth/callback"},
+	}.Encode()
+	return homePageData{
+		Integration:          a.cfg.Integration,
+		Audience:             a.cfg.Audience,
+		AuthURL:              a.cfg.AuthURL,
+		PublicURL:            a.cfg.PublicURL,
+		CurrentHost:          r.Host,
+		LoginURL:             loginURL,
+		AccessCookiePresent:  cookiePresent(r, "accessToken"),
+		RefreshCookiePresent: cookiePresent(r, "refreshToken"),
+	}
+}
+
+// signIn fills in the signed-in parts of page.
+func (a *app) signIn(
+	page *homePageData,
+	accessToken *client.AccessToken,
+	csrf string,
+) {
+	page.Authenticated = true
+	page.CSRF = csrf
+	page.Subject = accessToken.Subject()
+	page.Handle = a.fetchHandle(accessToken.Encoded())
+	page.Scopes = strings.Join(accessToken.Scopes(), ", ")
+	page.Notes = a.notes.list(accessToken.Subject())
+}
+
+func (a *app) fetchHandle(
+	accessToken string,
+) string {
+	fetcher, ok := a.verifier.(profileFetcher)
+	if !ok {
+		return ""
+	}
+	userInfo, err := fetcher.FetchUserInfo(accessToken)
+	if err != nil {
+		log.Printf("failed to fetch userInfo: %v", err)
+		return ""
+	}
+	if userInfo.Profile == nil {
+		return ""
+	}
+	return userInfo.Profile.Handle
+}
+
+func render(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	page *template.Template,
+	data any,
+) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := page.ExecuteTemplate(w, "layout", data); err != nil {
+		log.Printf("%s: failed to render page: %v", r.RequestURI, err)
+	}
+}
+
+func cookiePresent(
+	r *http.Request,
+	name string,
+) bool {
+	_, err := r.Cookie(name)
+	return err == nil
+}
+
+// noteBook keeps each user's notes in memory, newest first.
+type noteBook struct {
+	mu    sync.Mutex
+	notes map[string][]note
+}
+
+type note struct {
+	Text  string
+	Added time.Time
+}
+
+func (b *noteBook) add(
+	subject string,
+	text string,
+) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	notes := append([]note{{Text: text, Added: time.Now()}}, b.notes[subject]...)
+	b.notes[subject] = notes[:min(len(notes), maxNotes)]
+}
+
+func (b *noteBook) list(
+	subject string,
+) []note {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]note(nil), b.notes[subject]...)
+}
+
+type profileResponse struct {
+	Subject string    `json:"subject"`
+	Handle  string    `json:"handle,omitempty"`
+	Scopes  []string  `json:"scopes"`
+	Expires time.Time `json:"expires"`
+}
+
+type homePageData struct {
+	Authenticated        bool
+	Integration          string
+	Audience             string
+	AuthURL              string
+	PublicURL            string
+	CurrentHost          string
+	LoginURL             string
+	CSRF                 string
+	Subject              string
+	Handle               string
+	Scopes               string
+	Notes                []note
+	NoteSaved            bool
+	NoteError            string
+	AuthError            string
+	AccessCookiePresent  bool
+	RefreshCookiePresent bool
+}
+
+type deniedPageData struct {
+	Reason string
+}
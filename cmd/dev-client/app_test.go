@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/args"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+	"git.sr.ht/~jakintosh/consent/pkg/testing/conformance"
+)
+
+func TestApp_Conformance(t *testing.T) {
+	cfg := Config{
+		AuthURL:     "http://consent.test",
+		PublicURL:   "http://app.test",
+		Integration: "example@localhost",
+		Audience:    conformance.DefaultAudience,
+	}
+	conformance.Run(t, conformance.Config{
+		NewApp: func(verifier client.Verifier) http.Handler {
+			return newApp(cfg, verifier).routes()
+		},
+		ProtectedPath: "/api/profile",
+		MutatingPath:  "/notes",
+	})
+}
+
+func TestParseConfig_EnvironmentAndFlags(t *testing.T) {
+	t.Setenv(envAuthURL, "https://consent.example.com/")
+	t.Setenv(envPublicURL, "https://app.example.com")
+	t.Setenv(envPort, "8080")
+
+	i := args.NewTestInput()
+	i.SetParameter("config-dir", t.TempDir())
+	i.SetParameter("listen", "127.0.0.1:9090")
+	cfg, err := parseConfig(i)
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+
+	// flags win over the environment, which wins over defaults
+	if cfg.Listen != "127.0.0.1:9090" {
+		t.Errorf("Listen = %q, want 127.0.0.1:9090 from --listen", cfg.Listen)
+	}
+	if cfg.AuthURL != "https://consent.example.com" || cfg.PublicURL != "https://app.example.com" {
+		t.Errorf("AuthURL/PublicURL = %q/%q, want the environment's", cfg.AuthURL, cfg.PublicURL)
+	}
+	if cfg.Audience != "app.example.com" {
+		t.Errorf("Audience = %q, want the public URL's host", cfg.Audience)
+	}
+	if cfg.Integration != defaultIntegrationName {
+		t.Errorf("Integration = %q, want the default", cfg.Integration)
+	}
+}
+
+func TestParseConfig_ConsentConfigDefaults(t *testing.T) {
+	configDir := t.TempDir()
+	consentConfig := config.Default()
+	consentConfig.Server.PublicURL = "https://auth.example.com"
+	consentConfig.Server.AuthorityDomain = "auth.example.com"
+	if err := config.Save(configDir, "", consentConfig); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	i := args.NewTestInput()
+	i.SetParameter("config-dir", configDir)
+	i.SetParameter("port", "8080")
+	cfg, err := parseConfig(i)
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+
+	// the consent server's config says where to find it
+	if cfg.AuthURL != "https://auth.example.com" || cfg.AuthorityDomain != "auth.example.com" {
+		t.Errorf("AuthURL/AuthorityDomain = %q/%q, want the consent config's", cfg.AuthURL, cfg.AuthorityDomain)
+	}
+	if cfg.Listen != ":8080" || cfg.PublicURL != "http://localhost:8080" {
+		t.Errorf("Listen/PublicURL = %q/%q, want :8080 and http://localhost:8080", cfg.Listen, cfg.PublicURL)
+	}
+}
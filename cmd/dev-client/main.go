@@ -1,12 +1,9 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/x509"
-	"errors"
 	"fmt"
-	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -20,20 +17,36 @@ import (
 )
 
 const (
-	defaultAuthURL         = "http://localhost:9001"
-	defaultAuthorityDomain = "localhost"
 	defaultPort            = 10000
 	defaultIntegrationName = "example@localhost"
 	defaultConfigDir       = "./config"
 )
 
+// Environment variables read for options not given on the command line.
+// Like cmd/consent, settings resolve from the consent config in the config
+// directory, then these variables, then flags.
+const (
+	envAuthURL         = "DEV_CLIENT_AUTH_URL"
+	envAuthorityDomain = "DEV_CLIENT_AUTHORITY_DOMAIN"
+	envPublicURL       = "DEV_CLIENT_PUBLIC_URL"
+	envPort            = "DEV_CLIENT_PORT"
+	envListen          = "DEV_CLIENT_LISTEN"
+	envIntegration     = "DEV_CLIENT_INTEGRATION"
+	envAudience        = "DEV_CLIENT_AUDIENCE"
+	envConfigDir       = "DEV_CLIENT_CONFIG_DIR"
+	envVerificationKey = "DEV_CLIENT_VERIFICATION_KEY"
+	envFetchKey        = "DEV_CLIENT_FETCH_KEY"
+)
+
 type Config struct {
 	AuthURL             string
 	AuthorityDomain     string
-	Port                int
+	PublicURL           string
+	Listen              string
 	Integration         string
 	Audience            string
 	VerificationKeyPath string
+	FetchKey            bool
 }
 
 var root = &args.Command{
@@ -56,37 +69,52 @@ var root = &args.Command{
 		{
 			Long: "auth-url",
 			Type: args.OptionTypeParameter,
-			Help: "consent server URL (default: http://localhost:9001)",
+			Help: "consent server URL (env: DEV_CLIENT_AUTH_URL, default: publicURL from <config-dir>/config.yaml, or http://localhost:9001)",
 		},
 		{
 			Long: "authority-domain",
 			Type: args.OptionTypeParameter,
-			Help: "consent authority domain (default: localhost)",
+			Help: "consent authority domain, the issuer of its tokens (env: DEV_CLIENT_AUTHORITY_DOMAIN, default: authorityDomain from <config-dir>/config.yaml, or localhost)",
+		},
+		{
+			Long: "public-url",
+			Type: args.OptionTypeParameter,
+			Help: "URL browsers reach this client at (env: DEV_CLIENT_PUBLIC_URL, default: http://localhost:<listen port>)",
 		},
 		{
 			Long: "port",
 			Type: args.OptionTypeParameter,
-			Help: "HTTP listen port (default: 10000)",
+			Help: "HTTP listen port on all interfaces (env: DEV_CLIENT_PORT, default: 10000)",
+		},
+		{
+			Long: "listen",
+			Type: args.OptionTypeParameter,
+			Help: "HTTP listen address, such as 127.0.0.1:10000; overrides --port (env: DEV_CLIENT_LISTEN)",
 		},
 		{
 			Long: "integration",
 			Type: args.OptionTypeParameter,
-			Help: "integration name for consent login (default: example@localhost)",
+			Help: "integration name for consent login (env: DEV_CLIENT_INTEGRATION, default: example@localhost)",
 		},
 		{
 			Long: "audience",
 			Type: args.OptionTypeParameter,
-			Help: "JWT audience (default: localhost:<port>)",
+			Help: "JWT audience (env: DEV_CLIENT_AUDIENCE, default: host of the public URL)",
 		},
 		{
 			Long: "config-dir",
 			Type: args.OptionTypeParameter,
-			Help: "path to consent config directory (default: ./config)",
+			Help: "path to consent config directory (env: DEV_CLIENT_CONFIG_DIR, default: ./config)",
 		},
 		{
 			Long: "verification-key",
 			Type: args.OptionTypeParameter,
-			Help: "path to verification key DER file (default: <config-dir>/secrets/verification_key.der)",
+			Help: "path to verification key DER or PEM file (env: DEV_CLIENT_VERIFICATION_KEY, default: <config-dir>/secrets/verification_key.der)",
+		},
+		{
+			Long: "fetch-key",
+			Type: args.OptionTypeFlag,
+			Help: "fetch the verification key and authority domain from the consent server instead (env: DEV_CLIENT_FETCH_KEY=true)",
 		},
 	},
 	Handler: func(i *args.Input) error {
@@ -100,46 +128,35 @@ var root = &args.Command{
 		if verbose {
 			log.Println("Starting development OAuth client...")
 			log.Printf("  Auth URL: %s", cfg.AuthURL)
-			log.Printf("  Authority domain: %s", cfg.AuthorityDomain)
+			log.Printf("  Public URL: %s", cfg.PublicURL)
 			log.Printf("  Integration: %s", cfg.Integration)
 			log.Printf("  Audience: %s", cfg.Audience)
-			log.Printf("  Verification key: %s", cfg.VerificationKeyPath)
-			log.Printf("  Port: %d", cfg.Port)
-		}
-
-		verificationKeyBytes, err := os.ReadFile(cfg.VerificationKeyPath)
-		if err != nil {
-			return fmt.Errorf("failed to load verification key %q: %w", cfg.VerificationKeyPath, err)
+			if cfg.FetchKey {
+				log.Printf("  Verification key: fetched from %s", cfg.AuthURL)
+			} else {
+				log.Printf("  Authority domain: %s", cfg.AuthorityDomain)
+				log.Printf("  Verification key: %s", cfg.VerificationKeyPath)
+			}
+			log.Printf("  Listen: %s", cfg.Listen)
 		}
 
-		verificationKey, err := decodePublicKey(verificationKeyBytes)
+		authClient, err := newAuthClient(cfg)
 		if err != nil {
 			return err
 		}
-
-		opts := tokens.ClientOptions{
-			VerificationKey: verificationKey,
-			IssuerDomain:    cfg.AuthorityDomain,
-			ValidAudience:   cfg.Audience,
+		if strings.HasPrefix(cfg.PublicURL, "http://") {
+			authClient.EnableInsecureCookies()
 		}
-		tkValidator := tokens.InitClient(opts)
-		authClient := client.Init(tkValidator, cfg.AuthURL)
-		authClient.EnableInsecureCookies()
 		if verbose {
 			authClient.SetLogLevel(client.LogLevelDebug)
 		}
 
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", homeHandler(authClient, cfg))
-		mux.HandleFunc("/api/example", exampleHandler(authClient, cfg.Integration))
-		mux.HandleFunc("/auth/callback", authClient.HandleAuthorizationCode())
-		mux.HandleFunc("/logout", authClient.HandleLogout())
-
 		if verbose {
-			log.Printf("Listening on :%d", cfg.Port)
+			log.Printf("Listening on %s", cfg.Listen)
 		}
 
-		if err := http.ListenAndServe(":"+strconv.Itoa(cfg.Port), mux); err != nil {
+		router := newApp(cfg, authClient).routes()
+		if err := http.ListenAndServe(cfg.Listen, router); err != nil {
 			return fmt.Errorf("server error: %w", err)
 		}
 
@@ -151,371 +168,146 @@ func main() {
 	root.Parse()
 }
 
+func newAuthClient(
+	cfg Config,
+) (
+	*client.Client,
+	error,
+) {
+	if cfg.FetchKey {
+		authClient, err := client.InitFromServer(cfg.AuthURL, cfg.Audience)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch verification key: %w", err)
+		}
+		return authClient, nil
+	}
+
+	verificationKey, err := tokens.LoadPublicKeyFile(cfg.VerificationKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load verification key: %w", err)
+	}
+	opts := tokens.ClientOptions{
+		VerificationKey: verificationKey,
+		IssuerDomain:    cfg.AuthorityDomain,
+		ValidAudience:   cfg.Audience,
+	}
+	return client.Init(tokens.InitClient(opts), cfg.AuthURL), nil
+}
+
+// option returns the --name parameter, falling back to the environment
+// variable env and then to fallback.
+func option(
+	i *args.Input,
+	name string,
+	env string,
+	fallback string,
+) string {
+	if value := i.GetParameter(name); value != nil {
+		return *value
+	}
+	if value := strings.TrimSpace(os.Getenv(env)); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func parseConfig(
 	i *args.Input,
 ) (
 	Config,
 	error,
 ) {
-	authURL := i.GetParameterOr("auth-url", defaultAuthURL)
-	authURL, err := normalizeAuthURL(authURL)
+	configDir := option(i, "config-dir", envConfigDir, defaultConfigDir)
+	if strings.TrimSpace(configDir) == "" {
+		return Config{}, fmt.Errorf("--config-dir cannot be empty")
+	}
+
+	// the consent server's own config, when there is one, says where it is
+	consentConfig, err := config.Load(configDir, "")
+	if err != nil {
+		return Config{}, err
+	}
+
+	authURL, err := normalizeURL("--auth-url", option(i, "auth-url", envAuthURL, consentConfig.Server.PublicURL))
 	if err != nil {
 		return Config{}, err
 	}
 
-	authorityDomain := i.GetParameterOr("authority-domain", defaultAuthorityDomain)
+	authorityDomain := option(i, "authority-domain", envAuthorityDomain, consentConfig.Server.AuthorityDomain)
 	if strings.TrimSpace(authorityDomain) == "" {
 		return Config{}, fmt.Errorf("--authority-domain cannot be empty")
 	}
 
-	port := i.GetIntParameterOr("port", defaultPort)
-	if port < 1 || port > 65535 {
-		return Config{}, fmt.Errorf("invalid --port %q: out of range", port)
+	port, err := strconv.Atoi(option(i, "port", envPort, strconv.Itoa(defaultPort)))
+	if err != nil || port < 1 || port > 65535 {
+		return Config{}, fmt.Errorf("invalid --port: expected a number from 1 to 65535")
+	}
+
+	listen := option(i, "listen", envListen, fmt.Sprintf(":%d", port))
+	_, listenPort, err := net.SplitHostPort(listen)
+	if err != nil || listenPort == "" {
+		return Config{}, fmt.Errorf("invalid --listen %q: expected host:port or :port", listen)
+	}
+
+	publicURL, err := normalizeURL("--public-url", option(i, "public-url", envPublicURL, "http://localhost:"+listenPort))
+	if err != nil {
+		return Config{}, err
 	}
 
-	integrationName := i.GetParameterOr("integration", defaultIntegrationName)
+	integrationName := option(i, "integration", envIntegration, defaultIntegrationName)
 	if strings.TrimSpace(integrationName) == "" {
 		return Config{}, fmt.Errorf("--integration cannot be empty")
 	}
 
-	audience := i.GetParameterOr("audience", fmt.Sprintf("localhost:%d", port))
+	parsedPublicURL, _ := url.Parse(publicURL)
+	audience := option(i, "audience", envAudience, parsedPublicURL.Host)
 	if strings.TrimSpace(audience) == "" {
 		return Config{}, fmt.Errorf("--audience cannot be empty")
 	}
 
-	configDir := i.GetParameterOr("config-dir", defaultConfigDir)
-	if strings.TrimSpace(configDir) == "" {
-		return Config{}, fmt.Errorf("--config-dir cannot be empty")
-	}
-
 	defaultVerificationKeyPath, err := config.VerificationKeyPath(configDir)
 	if err != nil {
 		return Config{}, err
 	}
-	verificationKeyPath := i.GetParameterOr("verification-key", defaultVerificationKeyPath)
+	verificationKeyPath := option(i, "verification-key", envVerificationKey, defaultVerificationKeyPath)
 	if strings.TrimSpace(verificationKeyPath) == "" {
 		return Config{}, fmt.Errorf("--verification-key cannot be empty")
 	}
 
+	fetchKey := i.GetFlag("fetch-key")
+	if !fetchKey {
+		if value := strings.TrimSpace(os.Getenv(envFetchKey)); value != "" {
+			if fetchKey, err = strconv.ParseBool(value); err != nil {
+				return Config{}, fmt.Errorf("invalid %s %q: expected true or false", envFetchKey, value)
+			}
+		}
+	}
+
 	return Config{
 		AuthURL:             authURL,
 		AuthorityDomain:     authorityDomain,
-		Port:                port,
+		PublicURL:           publicURL,
+		Listen:              listen,
 		Integration:         integrationName,
 		Audience:            audience,
 		VerificationKeyPath: verificationKeyPath,
+		FetchKey:            fetchKey,
 	}, nil
 }
 
-func normalizeAuthURL(raw string) (string, error) {
+// normalizeURL reduces raw, the value of flag, to its scheme and host.
+func normalizeURL(flag string, raw string) (string, error) {
 	parsed, err := url.Parse(strings.TrimSpace(raw))
 	if err != nil || parsed == nil {
-		return "", fmt.Errorf("invalid --auth-url: expected absolute URL with scheme and host")
+		return "", fmt.Errorf("invalid %s: expected absolute URL with scheme and host", flag)
 	}
 	if parsed.Scheme == "" || parsed.Host == "" {
-		return "", fmt.Errorf("invalid --auth-url: expected absolute URL with scheme and host")
+		return "", fmt.Errorf("invalid %s: expected absolute URL with scheme and host", flag)
 	}
 
 	if parsed.Path != "" && parsed.Path != "/" {
-		return "", fmt.Errorf("invalid --auth-url: path is not allowed")
+		return "", fmt.Errorf("invalid %s: path is not allowed", flag)
 	}
 
 	return (&url.URL{Scheme: parsed.Scheme, Host: parsed.Host}).String(), nil
 }
-
-func homeHandler(c client.Verifier, cfg Config) http.HandlerFunc {
-	loginURL := fmt.Sprintf("%s/authorize?integration=%s&scope=identity&scope=profile", cfg.AuthURL, url.QueryEscape(cfg.Integration))
-
-	return func(w http.ResponseWriter, r *http.Request) {
-		page := homePageData{
-			Integration:          cfg.Integration,
-			Audience:             cfg.Audience,
-			AuthURL:              cfg.AuthURL,
-			CurrentOrigin:        requestOrigin(r),
-			CurrentHost:          r.Host,
-			LoginURL:             loginURL,
-			AccessCookiePresent:  cookiePresent(r, "accessToken"),
-			RefreshCookiePresent: cookiePresent(r, "refreshToken"),
-		}
-
-		accessToken, csrf, err := c.VerifyAuthorizationGetCSRF(w, r)
-		if err != nil {
-			if !errors.Is(err, client.ErrTokenAbsent) {
-				log.Printf("%s: failed to verify authorization: %v", r.RequestURI, err)
-				page.AuthError = err.Error()
-			}
-		}
-
-		if accessToken != nil {
-			page.Authenticated = true
-			page.CSRF = csrf
-			page.Scopes = strings.Join(accessToken.Scopes(), ", ")
-			page.Subject = accessToken.Subject()
-		} else {
-			page.AuthHint = "Complete login in Consent and this page should refresh into the signed-in state."
-			if !page.AccessCookiePresent && !page.RefreshCookiePresent {
-				page.AuthHint = "No auth cookies are present yet. If you just completed login, the callback may not have stored cookies for this host."
-			}
-		}
-
-		if err := homeTemplate.Execute(w, page); err != nil {
-			log.Printf("%s: failed to render home page: %v", r.RequestURI, err)
-		}
-	}
-}
-
-func exampleHandler(c *client.Client, integrationName string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		page := examplePageData{
-			Integration:   integrationName,
-			CurrentOrigin: requestOrigin(r),
-			CurrentHost:   r.Host,
-		}
-
-		csrf := r.URL.Query().Get("csrf")
-		accessToken, csrf, err := c.VerifyAuthorizationCheckCSRF(w, r, csrf)
-		if err != nil {
-			log.Printf("%s: failed to verify authorization: %v", r.RequestURI, err)
-			page.AuthError = err.Error()
-		}
-
-		if accessToken != nil {
-			page.Authenticated = true
-			page.Handle = fetchProfileHandle(c, accessToken.Encoded())
-			page.CSRF = csrf
-			page.Scopes = strings.Join(accessToken.Scopes(), ", ")
-			page.Subject = accessToken.Subject()
-		} else {
-			page.AuthHint = "This route needs a valid session and matching CSRF token from the home page."
-		}
-
-		if err := exampleTemplate.Execute(w, page); err != nil {
-			log.Printf("%s: failed to render example page: %v", r.RequestURI, err)
-		}
-	}
-}
-
-func requestOrigin(r *http.Request) string {
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
-	}
-	return scheme + "://" + r.Host
-}
-
-func cookiePresent(
-	r *http.Request,
-	name string,
-) bool {
-	_, err := r.Cookie(name)
-	return err == nil
-}
-
-func fetchProfileHandle(
-	authClient *client.Client,
-	accessToken string,
-) string {
-	userInfo, err := authClient.FetchUserInfo(accessToken)
-	if err != nil {
-		log.Printf("failed to fetch userInfo: %v", err)
-		return ""
-	}
-	if userInfo.Profile == nil {
-		return ""
-	}
-	return userInfo.Profile.Handle
-}
-
-func decodePublicKey(
-	bytes []byte,
-) (
-	*ecdsa.PublicKey,
-	error,
-) {
-	parsedKey, err := x509.ParsePKIXPublicKey(bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse ecdsa verification key from DER: %w", err)
-	}
-
-	ecdsaKey, ok := parsedKey.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("failed to cast parsed key as *ecdsa.PublicKey")
-	}
-
-	return ecdsaKey, nil
-}
-
-type homePageData struct {
-	Authenticated        bool
-	Integration          string
-	Audience             string
-	AuthURL              string
-	CurrentOrigin        string
-	CurrentHost          string
-	LoginURL             string
-	LogoutURL            string
-	CSRF                 string
-	Subject              string
-	Scopes               string
-	AuthHint             string
-	AuthError            string
-	AccessCookiePresent  bool
-	RefreshCookiePresent bool
-}
-
-type examplePageData struct {
-	Authenticated bool
-	Integration   string
-	CurrentOrigin string
-	CurrentHost   string
-	Handle        string
-	Subject       string
-	Scopes        string
-	CSRF          string
-	AuthHint      string
-	AuthError     string
-}
-
-var homeTemplate = template.Must(template.New("home").Parse(`<!DOCTYPE html>
-<html lang="en">
-<head>
-	<meta charset="utf-8" />
-	<meta name="viewport" content="width=device-width, initial-scale=1" />
-	<title>Mock Client</title>
-	<style>
-		* { box-sizing: border-box; }
-		:root { color-scheme: light; font-family: sans-serif; font-size: 12pt; }
-		body { margin: 0; background: #f7f1fb; color: #22132f; }
-		header { background: #7521b0; color: #fff; padding: 1.25rem 1rem; }
-		header h1, header p { margin: 0; }
-		header p { margin-top: 0.35rem; opacity: 0.9; }
-		main { max-width: 760px; margin: 0 auto; padding: 1rem; }
-		.panel { background: #fff; border: 1px solid #e1d2ee; border-radius: 16px; padding: 1rem; margin: 1rem 0; box-shadow: 0 10px 30px rgba(117, 33, 176, 0.08); }
-		h2, h3 { margin-top: 0; color: #7521b0; }
-		p { line-height: 1.5; }
-		.actions { display: flex; gap: 0.75rem; flex-wrap: wrap; margin-top: 1rem; }
-		.button { display: inline-block; padding: 0.8rem 1rem; border-radius: 999px; text-decoration: none; font-weight: 700; }
-		.button-primary { background: #7521b0; color: #fff; }
-		.button-secondary { background: #f4ecfa; color: #7521b0; }
-		.grid { display: grid; gap: 0.75rem; }
-		@media (min-width: 640px) { .grid { grid-template-columns: repeat(2, minmax(0, 1fr)); } }
-		dl { margin: 0; }
-		dt { font-size: 0.9rem; color: #694e80; }
-		dd { margin: 0.2rem 0 0; font-family: monospace; overflow-wrap: anywhere; }
-		.status { padding: 0.9rem 1rem; border-radius: 12px; }
-		.status-ok { background: #eef9f0; color: #235d32; }
-		.status-warn { background: #fff5e8; color: #85511c; }
-		.status-error { background: #fdecec; color: #8f2332; }
-		code { font-family: monospace; }
-	</style>
-</head>
-<body>
-	<header>
-		<h1>Mock Client Playground</h1>
-		<p>Development UI for checking Consent browser login and token cookies.</p>
-	</header>
-	<main>
-		<section class="panel">
-			<h2>{{if .Authenticated}}Signed In{{else}}Signed Out{{end}}</h2>
-			{{if .Authenticated}}
-				<p class="status status-ok">This host has both a valid session and a refresh token. The page verified your session server-side.</p>
-			{{else if .AuthError}}
-				<p class="status status-error">The client received cookies or tokens it could not validate: <code>{{.AuthError}}</code></p>
-			{{else}}
-				<p class="status status-warn">{{.AuthHint}}</p>
-			{{end}}
-			<div class="actions">
-				{{if .Authenticated}}
-					<a class="button button-primary" href="/api/example?csrf={{.CSRF}}">Call Example API</a>
-					<a class="button button-secondary" href="/logout?csrf={{.CSRF}}">Log Out</a>
-				{{else}}
-					<a class="button button-primary" href="{{.LoginURL}}">Log In with Pollinator</a>
-				{{end}}
-			</div>
-		</section>
-
-		<section class="panel">
-			<h3>Integration Details</h3>
-			<div class="grid">
-				<dl><dt>Integration</dt><dd>{{.Integration}}</dd></dl>
-				<dl><dt>Audience</dt><dd>{{.Audience}}</dd></dl>
-				<dl><dt>Consent Server</dt><dd>{{.AuthURL}}</dd></dl>
-				<dl><dt>Current Origin</dt><dd>{{.CurrentOrigin}}</dd></dl>
-				<dl><dt>Current Host</dt><dd>{{.CurrentHost}}</dd></dl>
-				{{if .Authenticated}}
-					<dl><dt>Opaque Subject</dt><dd>{{.Subject}}</dd></dl>
-					<dl><dt>Granted Scopes</dt><dd>{{.Scopes}}</dd></dl>
-				{{end}}
-			</div>
-		</section>
-
-		<section class="panel">
-			<h3>Cookie Diagnostics</h3>
-			<p>This view checks for incoming HTTP-only token cookies before it asks the shared client library to validate them.</p>
-			<div class="grid">
-				<dl><dt>accessToken cookie</dt><dd>{{if .AccessCookiePresent}}present{{else}}missing{{end}}</dd></dl>
-				<dl><dt>refreshToken cookie</dt><dd>{{if .RefreshCookiePresent}}present{{else}}missing{{end}}</dd></dl>
-			</div>
-			<p>If you complete Consent login but still return here with both cookies missing, the browser likely did not store cookies for this host.</p>
-		</section>
-
-		<section class="panel">
-			<h3>Host Notes</h3>
-			<p>Mock integrations are registered on specific hosts like <code>mock1.localhost:9001</code>. The callback and the page you revisit need to use the same host, or the browser will treat them as separate cookie jars.</p>
-		</section>
-	</main>
-</body>
-</html>`))
-
-var exampleTemplate = template.Must(template.New("example").Parse(`<!DOCTYPE html>
-<html lang="en">
-<head>
-	<meta charset="utf-8" />
-	<meta name="viewport" content="width=device-width, initial-scale=1" />
-	<title>Mock Client API Result</title>
-	<style>
-		* { box-sizing: border-box; }
-		:root { font-family: sans-serif; font-size: 12pt; }
-		body { margin: 0; background: #f7f1fb; color: #22132f; }
-		main { max-width: 760px; margin: 0 auto; padding: 1rem; }
-		.panel { background: #fff; border: 1px solid #e1d2ee; border-radius: 16px; padding: 1rem; margin: 1rem 0; box-shadow: 0 10px 30px rgba(117, 33, 176, 0.08); }
-		h1, h2 { color: #7521b0; margin-top: 0; }
-		a { color: #7521b0; text-underline-offset: 0.2em; }
-		dl { margin: 0; }
-		dt { font-size: 0.9rem; color: #694e80; }
-		dd { margin: 0.2rem 0 0.9rem; font-family: monospace; overflow-wrap: anywhere; }
-		.status { padding: 0.9rem 1rem; border-radius: 12px; }
-		.status-ok { background: #eef9f0; color: #235d32; }
-		.status-error { background: #fdecec; color: #8f2332; }
-		.status-warn { background: #fff5e8; color: #85511c; }
-		code { font-family: monospace; }
-	</style>
-</head>
-<body>
-	<main>
-		<section class="panel">
-			<h1>Example API Result</h1>
-			{{if .Authenticated}}
-				<p class="status status-ok">Authenticated through Consent and successfully reached the example route.</p>
-			{{else if .AuthError}}
-				<p class="status status-error">Authorization failed: <code>{{.AuthError}}</code></p>
-			{{else}}
-				<p class="status status-warn">{{.AuthHint}}</p>
-			{{end}}
-			<p><a href="/">Back to Home</a>{{if .Authenticated}} | <a href="/api/example?csrf={{.CSRF}}">Repeat Request</a>{{end}}</p>
-		</section>
-		<section class="panel">
-			<h2>Details</h2>
-			<dl><dt>Integration</dt><dd>{{.Integration}}</dd></dl>
-			<dl><dt>Current Origin</dt><dd>{{.CurrentOrigin}}</dd></dl>
-			<dl><dt>Current Host</dt><dd>{{.CurrentHost}}</dd></dl>
-			{{if .Authenticated}}
-				<dl><dt>Profile Handle</dt><dd>{{if .Handle}}{{.Handle}}{{else}}profile scope granted but no handle was returned{{end}}</dd></dl>
-				<dl><dt>Opaque Subject</dt><dd>{{.Subject}}</dd></dl>
-				<dl><dt>Granted Scopes</dt><dd>{{.Scopes}}</dd></dl>
-				<dl><dt>Current CSRF</dt><dd>{{.CSRF}}</dd></dl>
-			{{end}}
-		</section>
-	</main>
-</body>
-</html>`))
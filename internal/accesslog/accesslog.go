@@ -0,0 +1,171 @@
+// Package accesslog writes one line per HTTP request, in Common Log Format or
+// JSON, with the response status, size, latency, matched route, and request
+// ID.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+)
+
+type Format string
+
+const (
+	FormatOff    Format = "off"
+	FormatCommon Format = "common"
+	FormatJSON   Format = "json"
+)
+
+// ParseFormat accepts "off", "common", or "json"; empty means off.
+func ParseFormat(
+	value string,
+) (
+	Format,
+	error,
+) {
+	switch format := Format(strings.ToLower(strings.TrimSpace(value))); format {
+	case "", FormatOff:
+		return FormatOff, nil
+	case FormatCommon, FormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown access log format %q; use off, common, or json", value)
+	}
+}
+
+// Entry is a single logged request. Route is the matched route pattern, such
+// as "POST /api/v1/auth/refresh", or empty when no route matched. RequestID
+// is set when next runs behind requestid.Handler.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Route     string    `json:"route,omitempty"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMS float64   `json:"latencyMs"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// Handler logs every request served by next to out. A FormatOff handler
+// returns next unchanged.
+func Handler(
+	next http.Handler,
+	out io.Writer,
+	format Format,
+) http.Handler {
+	if format == FormatOff || format == "" {
+		return next
+	}
+
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		routes := new(routeStack)
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), routeKey{}, routes)))
+
+		entry := Entry{
+			Time:      start,
+			Remote:    remoteHost(r.RemoteAddr),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Route:     routes.label(),
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			RequestID: requestid.FromContext(r.Context()),
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		_ = write(out, format, entry)
+	})
+}
+
+func write(
+	out io.Writer,
+	format Format,
+	entry Entry,
+) error {
+	if format == FormatJSON {
+		return json.NewEncoder(out).Encode(entry)
+	}
+
+	route := entry.Route
+	if route == "" {
+		route = "-"
+	}
+	requestID := entry.RequestID
+	if requestID == "" {
+		requestID = "-"
+	}
+	_, err := fmt.Fprintf(out, "%s - - [%s] %q %d %d %q %.3fms %s\n",
+		entry.Remote,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method+" "+entry.Path+" "+entry.Proto,
+		entry.Status,
+		entry.Bytes,
+		route,
+		entry.LatencyMS,
+		requestID,
+	)
+	return err
+}
+
+func remoteHost(
+	addr string,
+) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,119 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+)
+
+// buildRouter mounts a subrouter the way the consent server does.
+func buildRouter() http.Handler {
+	inner := http.NewServeMux()
+	inner.HandleFunc("POST /refresh", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	outer := http.NewServeMux()
+	wire.Subrouter(outer, "/api/v1/auth", accesslog.Routes(inner))
+	return accesslog.Routes(outer)
+}
+
+func serve(
+	handler http.Handler,
+	method string,
+	path string,
+) {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.0.2.1:5555"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestHandler_JSON(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	handler := accesslog.Handler(buildRouter(), &out, accesslog.FormatJSON)
+
+	serve(handler, http.MethodPost, "/api/v1/auth/refresh?x=1")
+
+	var entry accesslog.Entry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log line %q: %v", out.String(), err)
+	}
+	if entry.Route != "POST /api/v1/auth/refresh" {
+		t.Errorf("Route = %q, want POST /api/v1/auth/refresh", entry.Route)
+	}
+	if entry.Status != http.StatusCreated || entry.Bytes != 5 {
+		t.Errorf("Status/Bytes = %d/%d, want 201/5", entry.Status, entry.Bytes)
+	}
+	if entry.Path != "/api/v1/auth/refresh?x=1" || entry.Remote != "192.0.2.1" {
+		t.Errorf("Path/Remote = %q/%q, want request values", entry.Path, entry.Remote)
+	}
+}
+
+func TestHandler_Common(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	handler := accesslog.Handler(buildRouter(), &out, accesslog.FormatCommon)
+
+	serve(handler, http.MethodPost, "/api/v1/auth/refresh")
+	serve(handler, http.MethodGet, "/missing")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), out.String())
+	}
+	if !strings.HasPrefix(lines[0], "192.0.2.1 - - [") ||
+		!strings.Contains(lines[0], `"POST /api/v1/auth/refresh HTTP/1.1" 201 5 "POST /api/v1/auth/refresh"`) {
+		t.Errorf("unexpected log line: %q", lines[0])
+	}
+	// unmatched requests log the status without a route
+	if !strings.Contains(lines[1], `" 404 `) || !strings.Contains(lines[1], ` "-" `) {
+		t.Errorf("unexpected log line for unmatched route: %q", lines[1])
+	}
+}
+
+func TestHandler_Off(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	handler := accesslog.Handler(buildRouter(), &out, accesslog.FormatOff)
+
+	serve(handler, http.MethodPost, "/api/v1/auth/refresh")
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"", "off", "common", "JSON"} {
+		if _, err := accesslog.ParseFormat(value); err != nil {
+			t.Errorf("ParseFormat(%q) = %v, want nil", value, err)
+		}
+	}
+	if _, err := accesslog.ParseFormat("apache"); err == nil {
+		t.Error("ParseFormat(apache) = nil, want error")
+	}
+}
+
+func TestHandler_RequestID(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	handler := requestid.Handler(accesslog.Handler(buildRouter(), &out, accesslog.FormatCommon))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", nil)
+	req.Header.Set(requestid.Header, "trace-42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if line := strings.TrimSpace(out.String()); !strings.HasSuffix(line, "ms trace-42") {
+		t.Errorf("log line %q does not end with the request ID", line)
+	}
+}
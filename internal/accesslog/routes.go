@@ -0,0 +1,67 @@
+package accesslog
+
+import (
+	"net/http"
+	"strings"
+)
+
+type routeKey struct{}
+
+// routeStack collects the patterns matched by nested muxes, innermost first,
+// since each mux returns after the muxes mounted inside it.
+type routeStack struct {
+	patterns []string
+}
+
+// Routes wraps a ServeMux so the pattern it matches is recorded for the
+// access log. Wrapping every mux in a tree of subrouters lets the log join
+// their patterns into one route, such as "POST /api/v1/auth/refresh".
+func Routes(
+	mux *http.ServeMux,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the mux sets r.Pattern on the request it was given
+		mux.ServeHTTP(w, r)
+		if routes, ok := r.Context().Value(routeKey{}).(*routeStack); ok {
+			routes.patterns = append(routes.patterns, r.Pattern)
+		}
+	})
+}
+
+// label joins the mounted prefixes (outer patterns) with the innermost
+// pattern. It is empty when the innermost mux matched nothing.
+func (s *routeStack) label() string {
+	if len(s.patterns) == 0 || s.patterns[0] == "" {
+		return ""
+	}
+
+	method, path := splitPattern(s.patterns[0])
+	var prefix strings.Builder
+	for i := len(s.patterns) - 1; i > 0; i-- {
+		_, mount := splitPattern(s.patterns[i])
+		prefix.WriteString(strings.TrimSuffix(mount, "/"))
+	}
+	route := prefix.String() + path
+	if method == "" {
+		return route
+	}
+	return method + " " + route
+}
+
+// splitPattern separates a ServeMux pattern's optional method from its path.
+func splitPattern(
+	pattern string,
+) (
+	string,
+	string,
+) {
+	fields := strings.Fields(pattern)
+	switch len(fields) {
+	case 0:
+		return "", ""
+	case 1:
+		return "", fields[0]
+	default:
+		return fields[0], fields[1]
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type ChangeHandleRequest struct {
+	Handle   string `json:"username"`
+	Password string `json:"password"`
+}
+
+type UnlinkIdentityRequest struct {
+	Password string `json:"password"`
+}
+
+type Profile struct {
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email"`
+	AvatarURL   string `json:"avatarUrl"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"displayName,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	AvatarURL   *string `json:"avatarUrl,omitempty"`
+}
+
+func profileFromDomain(profile *service.Profile) Profile {
+	return Profile{
+		DisplayName: profile.DisplayName,
+		Email:       profile.Email,
+		AvatarURL:   profile.AvatarURL,
+	}
+}
+
+type LinkedIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Session is a signed-in client of the account. LastUsedAt and LastIP are
+// omitted until its refresh token is first used.
+type Session struct {
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"userAgent"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastIP     string     `json:"lastIp,omitempty"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+}
+
+func sessionsFromDomain(sessions []service.Session) []Session {
+	apiSessions := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		apiSession := Session{
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			CreatedAt: session.CreatedAt.UTC(),
+			LastIP:    session.LastIP,
+			ExpiresAt: session.ExpiresAt.UTC(),
+		}
+		if !session.LastUsedAt.IsZero() {
+			lastUsedAt := session.LastUsedAt.UTC()
+			apiSession.LastUsedAt = &lastUsedAt
+		}
+		apiSessions = append(apiSessions, apiSession)
+	}
+	return apiSessions
+}
+
+func linkedIdentitiesFromDomain(identities []service.ExternalIdentity) []LinkedIdentity {
+	apiIdentities := make([]LinkedIdentity, 0, len(identities))
+	for _, identity := range identities {
+		apiIdentities = append(apiIdentities, LinkedIdentity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			CreatedAt: identity.CreatedAt.UTC(),
+		})
+	}
+	return apiIdentities
+}
+
+func (a *API) buildAccountRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("DELETE /", a.handleDeleteAccount)
+	mux.HandleFunc("PUT    /handle", a.handleChangeHandle)
+	mux.HandleFunc("GET    /profile", a.handleGetProfile)
+	mux.HandleFunc("PATCH  /profile", a.handleUpdateProfile)
+	mux.HandleFunc("GET    /links", a.handleListAccountLinks)
+	mux.HandleFunc("DELETE /links/{provider}", a.handleUnlinkIdentity)
+	mux.HandleFunc("GET    /sessions", a.handleListAccountSessions)
+
+	return accesslog.Routes(mux)
+}
+
+func (a *API) handleDeleteAccount(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	req, err := decodeRequest[DeleteAccountRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	err = a.service.DeleteAccount(r.Context(), encodedToken, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, nil)
+}
+
+func (a *API) handleChangeHandle(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	req, err := decodeRequest[ChangeHandleRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	user, err := a.service.ChangeHandle(r.Context(), encodedToken, req.Password, req.Handle)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, userFromDomain(*user))
+}
+
+func (a *API) handleGetProfile(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	profile, err := a.service.GetAccountProfile(r.Context(), encodedToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, profileFromDomain(profile))
+}
+
+func (a *API) handleUpdateProfile(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	req, err := decodeRequest[UpdateProfileRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	profile, err := a.service.UpdateAccountProfile(r.Context(), encodedToken, &service.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		AvatarURL:   req.AvatarURL,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, profileFromDomain(profile))
+}
+
+func (a *API) handleListAccountLinks(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	identities, err := a.service.ListAccountLinks(r.Context(), encodedToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, linkedIdentitiesFromDomain(identities))
+}
+
+func (a *API) handleListAccountSessions(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	sessions, err := a.service.ListAccountSessions(r.Context(), encodedToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, sessionsFromDomain(sessions))
+}
+
+func (a *API) handleUnlinkIdentity(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, service.ErrTokenInvalid)
+		return
+	}
+
+	req, err := decodeRequest[UnlinkIdentityRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	err = a.service.UnlinkIdentity(r.Context(), encodedToken, req.Password, r.PathValue("provider"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, nil)
+}
@@ -0,0 +1,324 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func deleteAccount(
+	router http.Handler,
+	token *tokens.AccessToken,
+	body string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/account", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != nil {
+		req.Header.Set("Authorization", "Bearer "+token.Encoded())
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestAPIDeleteAccount_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	res := deleteAccount(env.Router, token, `{"password": "password"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// deleted user can no longer log in
+	body := `{
+		"handle": "alice",
+		"secret": "password",
+		"integration": "consent"
+	}`
+	result := wire.TestPost[any](env.Router, "/auth/login", body, jsonHeader)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+}
+
+func TestAPIDeleteAccount_WrongPassword(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	res := deleteAccount(env.Router, token, `{"password": "wrong"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestAPIDeleteAccount_RequiresBearerHeader(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	res := deleteAccount(env.Router, nil, `{"password": "password"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestAPIDeleteAccount_InvalidJSON(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	res := deleteAccount(env.Router, token, "not-json")
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestAPIChangeHandle_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	body := `{"username": "alicia", "password": "password"}`
+	result := wire.TestPut[api.User](env.Router, "/account/handle", body, jsonHeader, authHeader(token))
+	response := result.ExpectOK(t)
+	if response.Handle != "alicia" {
+		t.Fatalf("handle = %s, want alicia", response.Handle)
+	}
+	if response.Subject != token.Subject() {
+		t.Fatalf("subject = %s, want %s", response.Subject, token.Subject())
+	}
+}
+
+func TestAPIChangeHandle_Conflict(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.RegisterTestUser(t, "bob", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	body := `{"username": "bob", "password": "password"}`
+	result := wire.TestPut[any](env.Router, "/account/handle", body, jsonHeader, authHeader(token))
+	result.ExpectStatusError(t, http.StatusConflict)
+}
+
+func unlinkIdentity(
+	router http.Handler,
+	token *tokens.AccessToken,
+	provider string,
+	body string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/account/links/"+provider, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != nil {
+		req.Header.Set("Authorization", "Bearer "+token.Encoded())
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestAPIListAccountLinks_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+	if err := env.DB.InsertExternalIdentity(t.Context(), token.Subject(), "corp", "ext-123", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+
+	result := wire.TestGet[[]api.LinkedIdentity](env.Router, "/account/links", authHeader(token))
+	response := result.ExpectOK(t)
+	if len(response) != 1 {
+		t.Fatalf("links = %d, want 1", len(response))
+	}
+	if response[0].Provider != "corp" || response[0].Subject != "ext-123" {
+		t.Fatalf("link = %#v, want corp/ext-123", response[0])
+	}
+}
+
+func TestAPIListAccountLinks_RequiresBearerHeader(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestGet[any](env.Router, "/account/links")
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIUnlinkIdentity_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+	if err := env.DB.InsertExternalIdentity(t.Context(), token.Subject(), "corp", "ext-123", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+
+	res := unlinkIdentity(env.Router, token, "corp", `{"password": "password"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// the link is gone
+	res = unlinkIdentity(env.Router, token, "corp", `{"password": "password"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestAPIUnlinkIdentity_WrongPassword(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	res := unlinkIdentity(env.Router, token, "corp", `{"password": "wrong"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func sendProfileRequest(
+	router http.Handler,
+	method string,
+	token *tokens.AccessToken,
+	body string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/account/profile", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != nil {
+		req.Header.Set("Authorization", "Bearer "+token.Encoded())
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestAPIProfile_GetEmpty(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	result := wire.TestGet[api.Profile](env.Router, "/account/profile", authHeader(token))
+	profile := result.ExpectOK(t)
+	if profile != (api.Profile{}) {
+		t.Fatalf("profile = %#v, want empty", profile)
+	}
+}
+
+func TestAPIProfile_Patch(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	res := sendProfileRequest(env.Router, http.MethodPatch, token, `{"displayName": "Alice", "email": "alice@example.com"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// patching one field keeps the others
+	res = sendProfileRequest(env.Router, http.MethodPatch, token, `{"avatarUrl": "https://example.com/alice.png"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	result := wire.TestGet[api.Profile](env.Router, "/account/profile", authHeader(token))
+	profile := result.ExpectOK(t)
+	want := api.Profile{
+		DisplayName: "Alice",
+		Email:       "alice@example.com",
+		AvatarURL:   "https://example.com/alice.png",
+	}
+	if profile != want {
+		t.Fatalf("profile = %#v, want %#v", profile, want)
+	}
+}
+
+func TestAPIProfile_PatchInvalid(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	res := sendProfileRequest(env.Router, http.MethodPatch, token, `{"email": "nope"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", res.Code, res.Body.String())
+	}
+	expectErrorCode(t, res.Body.Bytes(), "invalid_profile")
+}
+
+func TestAPIProfile_RequiresBearerToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	res := sendProfileRequest(env.Router, http.MethodGet, nil, "")
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestAPIUserInfo_ProfileFields(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	accountToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+	res := sendProfileRequest(env.Router, http.MethodPatch, accountToken, `{"displayName": "Alice", "email": "alice@example.com"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	token := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience", consentAudience}, []string{"identity", "profile", "email"})
+	result := wire.TestGet[api.UserInfo](env.Router, "/auth/userinfo", authHeader(token))
+	response := result.ExpectOK(t)
+	if response.Profile == nil || response.Profile.DisplayName != "Alice" {
+		t.Fatalf("profile = %#v, want display name Alice", response.Profile)
+	}
+	if response.Email != "alice@example.com" {
+		t.Fatalf("email = %q, want alice@example.com", response.Email)
+	}
+}
+
+func TestAPIListAccountSessions_RecordsClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	// exchanging the code starts a session for this client
+	body := `{"code": "` + code + `", "clientId": "test-integration"}`
+	userAgent := wire.TestHeader{Key: "User-Agent", Value: "test-browser/1.0"}
+	wire.TestPost[api.RefreshResponse](env.Router, "/auth/exchange", body, jsonHeader, userAgent).ExpectOK(t)
+
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+	result := wire.TestGet[[]api.Session](env.Router, "/account/sessions", authHeader(token))
+	response := result.ExpectOK(t)
+	if len(response) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(response))
+	}
+	if response[0].UserAgent != "test-browser/1.0" || response[0].IP == "" {
+		t.Fatalf("session = %#v, want recorded client", response[0])
+	}
+	if response[0].LastUsedAt != nil {
+		t.Fatalf("LastUsedAt = %v, want unset before first refresh", response[0].LastUsedAt)
+	}
+}
+
+func TestAPIListAccountSessions_RequiresBearerHeader(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestGet[any](env.Router, "/account/sessions")
+	result.ExpectStatus(t, http.StatusBadRequest)
+}
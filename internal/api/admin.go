@@ -1,11 +1,42 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 )
 
+// Maintenance is the state of maintenance mode.
+type Maintenance struct {
+	Enabled bool `json:"enabled"`
+}
+
+// RevokeTokensRequest names the audience whose refresh tokens to revoke.
+type RevokeTokensRequest struct {
+	Audience string `json:"audience"`
+}
+
+type RevokeTokensResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// Stats reports activity since Since, when the server started.
+type Stats struct {
+	Since  time.Time    `json:"since"`
+	Logins []LoginStats `json:"logins"`
+}
+
+// LoginStats counts sign-ins credited to one integration.
+type LoginStats struct {
+	Integration string `json:"integration"`
+	Succeeded   int64  `json:"succeeded"`
+	Failed      int64  `json:"failed"`
+}
+
 func (a *API) buildAdminRouter() http.Handler {
 	mux := http.NewServeMux()
 
@@ -13,6 +44,99 @@ func (a *API) buildAdminRouter() http.Handler {
 	wire.Subrouter(mux, "/integrations", a.buildIntegrationsRouter())
 	wire.Subrouter(mux, "/roles", a.buildRolesRouter())
 	wire.Subrouter(mux, "/users", a.buildUsersRouter())
+	mux.HandleFunc("GET /maintenance", a.handleGetMaintenance)
+	mux.HandleFunc("PUT /maintenance", a.handleSetMaintenance)
+	mux.HandleFunc("POST /tokens/revoke", a.handleRevokeTokens)
+	mux.HandleFunc("GET /stats", a.handleStats)
+	mux.HandleFunc("GET /metrics", a.handleMetrics)
+	if a.reload != nil {
+		mux.HandleFunc("POST /reload", a.handleReload)
+	}
+
+	return accesslog.Routes(mux)
+}
+
+// handleReload re-reads file-backed server state on demand, for deployments
+// that load it once at startup. A partial failure is reported with what
+// failed; everything that loaded still takes effect.
+func (a *API) handleReload(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if err := a.reload(); err != nil {
+		writeErrorCode(w, http.StatusInternalServerError, CodeReloadFailed, err.Error())
+		return
+	}
+	wire.WriteData(w, http.StatusOK, nil)
+}
+
+func (a *API) handleGetMaintenance(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	wire.WriteData(w, http.StatusOK, Maintenance{Enabled: a.service.Maintenance()})
+}
+
+// handleSetMaintenance turns maintenance mode on or off. It is not itself
+// blocked by maintenance mode, or there would be no way out.
+func (a *API) handleSetMaintenance(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	req, err := decodeRequest[Maintenance](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	a.service.SetMaintenance(req.Enabled)
+	wire.WriteData(w, http.StatusOK, Maintenance{Enabled: a.service.Maintenance()})
+}
+
+// handleRevokeTokens revokes every refresh token issued to an audience.
+func (a *API) handleRevokeTokens(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	req, err := decodeRequest[RevokeTokensRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	revoked, err := a.service.RevokeAudienceTokens(r.Context(), req.Audience)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	wire.WriteData(w, http.StatusOK, RevokeTokensResponse{Revoked: revoked})
+}
+
+// handleStats reports sign-in counts by integration.
+func (a *API) handleStats(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	logins, since := a.service.LoginStats()
+	stats := Stats{Since: since, Logins: make([]LoginStats, 0, len(logins))}
+	for _, entry := range logins {
+		stats.Logins = append(stats.Logins, LoginStats(entry))
+	}
+	wire.WriteData(w, http.StatusOK, stats)
+}
 
-	return mux
+// handleMetrics reports the same counts as handleStats in the Prometheus
+// text format, for scraping with an admin API key.
+func (a *API) handleMetrics(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	logins, _ := a.service.LoginStats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP consent_logins_total Sign-ins by the integration users signed in for and their result.")
+	fmt.Fprintln(w, "# TYPE consent_logins_total counter")
+	for _, entry := range logins {
+		integration := strconv.Quote(entry.Integration)
+		fmt.Fprintf(w, "consent_logins_total{integration=%s,result=\"succeeded\"} %d\n", integration, entry.Succeeded)
+		fmt.Fprintf(w, "consent_logins_total{integration=%s,result=\"failed\"} %d\n", integration, entry.Failed)
+	}
 }
@@ -0,0 +1,180 @@
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func setupReloadRouter(
+	t *testing.T,
+	reload func() error,
+) (
+	*testutil.TestEnv,
+	http.Handler,
+) {
+	t.Helper()
+	env := testutil.SetupTestEnv(t)
+	apiServer, err := api.New(api.Options{
+		Service:   env.Service,
+		KeysStore: env.DB.KeysStore,
+		Reload:    reload,
+	})
+	if err != nil {
+		t.Fatalf("api.New failed: %v", err)
+	}
+	return env, apiServer.Router()
+}
+
+func postReload(
+	t *testing.T,
+	env *testutil.TestEnv,
+	router http.Handler,
+	authenticated bool,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	if authenticated {
+		header := env.APIKeyHeader(t)
+		req.Header.Set(header.Key, header.Value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIReload_CallsReload(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	env, router := setupReloadRouter(t, func() error {
+		calls++
+		return nil
+	})
+
+	if rec := postReload(t, env, router, false); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if calls != 0 {
+		t.Fatalf("reload called without authentication")
+	}
+
+	if rec := postReload(t, env, router, true); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if calls != 1 {
+		t.Fatalf("reload called %d times, want 1", calls)
+	}
+}
+
+func TestAPIReload_ReportsFailure(t *testing.T) {
+	t.Parallel()
+	env, router := setupReloadRouter(t, func() error {
+		return errors.New("templates/login.html: unexpected EOF")
+	})
+
+	rec := postReload(t, env, router, true)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, api.CodeReloadFailed) || !strings.Contains(body, "login.html") {
+		t.Errorf("body = %s, want reload_failed naming the broken page", body)
+	}
+}
+
+func TestAPIReload_NotConfigured(t *testing.T) {
+	t.Parallel()
+	env, router := setupReloadRouter(t, nil)
+
+	if rec := postReload(t, env, router, true); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPIMaintenance_PausesChanges(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+
+	result := wire.TestPut[api.Maintenance](env.Router, "/admin/maintenance", `{"enabled":true}`, jsonHeader, authHeader)
+	if state := result.ExpectOK(t); !state.Enabled {
+		t.Fatal("maintenance not enabled")
+	}
+	if state := wire.TestGet[api.Maintenance](env.Router, "/admin/maintenance", authHeader).ExpectOK(t); !state.Enabled {
+		t.Fatal("GET reports maintenance disabled")
+	}
+
+	body := `{"username": "newuser", "password": "securepass"}`
+	created := wire.TestPost[any](env.Router, "/admin/users", body, jsonHeader, authHeader)
+	created.ExpectStatusError(t, http.StatusServiceUnavailable)
+	if !strings.Contains(string(created.Raw), "maintenance") {
+		t.Errorf("body = %s, want maintenance error", created.Raw)
+	}
+
+	wire.TestPut[api.Maintenance](env.Router, "/admin/maintenance", `{"enabled":false}`, jsonHeader, authHeader).ExpectOK(t)
+	wire.TestPost[api.User](env.Router, "/admin/users", body, jsonHeader, authHeader).ExpectOK(t)
+}
+
+func TestAPIRevokeTokens_ByAudience(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	result := wire.TestPost[api.RevokeTokensResponse](env.Router, "/admin/tokens/revoke", `{"audience":"test-audience"}`, jsonHeader, authHeader)
+	if response := result.ExpectOK(t); response.Revoked != 1 {
+		t.Errorf("revoked = %d, want 1", response.Revoked)
+	}
+
+	// the revoked token no longer refreshes
+	body := `{"refreshToken":"` + token.Encoded() + `"}`
+	wire.TestPost[any](env.Router, "/auth/refresh", body, jsonHeader).ExpectStatusError(t, http.StatusBadRequest)
+
+	// an audience is required
+	missing := wire.TestPost[any](env.Router, "/admin/tokens/revoke", `{}`, jsonHeader, authHeader)
+	missing.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, missing.Raw, "invalid_audience")
+}
+
+func TestAPIStats_CountsLogins(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+	authHeader := env.APIKeyHeader(t)
+	for _, secret := range []string{"password123", "wrong"} {
+		body := `{"handle":"alice","secret":"` + secret + `","integration":"consent"}`
+		wire.TestPost[any](env.Router, "/auth/login", body, jsonHeader)
+	}
+
+	wire.TestGet[any](env.Router, "/admin/stats").ExpectStatusError(t, http.StatusUnauthorized)
+	stats := wire.TestGet[api.Stats](env.Router, "/admin/stats", authHeader).ExpectOK(t)
+	want := []api.LoginStats{{Integration: "consent", Succeeded: 1, Failed: 1}}
+	if !reflect.DeepEqual(stats.Logins, want) {
+		t.Errorf("Logins = %+v, want %+v", stats.Logins, want)
+	}
+
+	// the same counts are exposed for Prometheus
+	req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+	req.Header.Set(authHeader.Key, authHeader.Value)
+	rec := httptest.NewRecorder()
+	env.Router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, line := range []string{
+		`consent_logins_total{integration="consent",result="succeeded"} 1`,
+		`consent_logins_total{integration="consent",result="failed"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("metrics missing %q:\n%s", line, rec.Body)
+		}
+	}
+}
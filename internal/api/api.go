@@ -6,17 +6,25 @@ import (
 
 	"git.sr.ht/~jakintosh/command-go/pkg/keys"
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
 type Options struct {
 	Service   *service.Service
 	KeysStore keys.Store
+	CORS      CORSOptions
+
+	// Reload, when set, is called by POST /admin/reload to re-read the
+	// server's file-backed pages and catalogs.
+	Reload func() error
 }
 
 type API struct {
 	service *service.Service
 	keys    *keys.Service
+	cors    CORSOptions
+	reload  func() error
 }
 
 func New(
@@ -44,6 +52,8 @@ func New(
 	return &API{
 		service: options.Service,
 		keys:    keysSvc,
+		cors:    options.CORS,
+		reload:  options.Reload,
 	}, nil
 }
 
@@ -51,7 +61,13 @@ func (a *API) Router() http.Handler {
 	root := http.NewServeMux()
 
 	wire.Subrouter(root, "/auth", a.buildAuthRouter())
+	wire.Subrouter(root, "/account", a.buildAccountRouter())
+	wire.Subrouter(root, "/device", a.buildDeviceRouter())
+	root.HandleFunc("POST /token", a.handleToken)
+	root.HandleFunc("GET /userinfo", a.handleUserInfo)
+	root.HandleFunc("GET /key", a.handleVerificationKey)
+	root.HandleFunc("/forward-auth", a.handleForwardAuth)
 	wire.Subrouter(root, "/admin", a.keys.WithAuth(a.buildAdminRouter(), &service.PermissionAdmin))
 
-	return root
+	return a.withCORS(limitRequestBody(withClientInfo(accesslog.Routes(root))))
 }
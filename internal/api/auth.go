@@ -3,38 +3,94 @@ package api
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
+// LoginRequest signs a user in. SessionOnly is for a user who didn't ask to
+// stay signed in: it shortens the session and marks its refresh tokens
+// session-only.
 type LoginRequest struct {
 	Handle      string `json:"handle"`
 	Secret      string `json:"secret"`
 	Integration string `json:"integration"`
 	ReturnTo    string `json:"returnTo"`
+	SessionOnly bool   `json:"sessionOnly,omitempty"`
 }
 
 type LogoutRequest struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
+// RefreshRequest redeems a refresh token. Confidential integrations
+// authenticate with ClientID and ClientSecret, or with HTTP Basic auth.
 type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken"`
+	ClientID     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
 }
 
+// ExchangeRequest redeems a single-use authorization code, authenticating
+// the same way as RefreshRequest. The response is a RefreshResponse.
+type ExchangeRequest struct {
+	Code         string `json:"code"`
+	ClientID     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// RefreshResponse carries a fresh token pair. TokenType, ExpiresIn, and
+// RefreshExpiresIn follow the RFC 6749 token response, so clients can learn
+// the tokens' lifetimes, in seconds, without decoding them.
 type RefreshResponse struct {
-	RefreshToken string `json:"refreshToken"`
-	AccessToken  string `json:"accessToken"`
+	RefreshToken     string `json:"refreshToken"`
+	AccessToken      string `json:"accessToken"`
+	IDToken          string `json:"idToken,omitempty"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+// refreshResponse builds the response for a token pair this server issued.
+func (a *API) refreshResponse(
+	accessToken string,
+	refreshToken string,
+	idToken string,
+) (
+	RefreshResponse,
+	error,
+) {
+	expiresIn, err := a.service.AccessTokenExpiresIn(accessToken)
+	if err != nil {
+		return RefreshResponse{}, err
+	}
+	refreshExpiresIn, err := a.service.RefreshTokenExpiresIn(refreshToken)
+	if err != nil {
+		return RefreshResponse{}, err
+	}
+	return RefreshResponse{
+		RefreshToken:     refreshToken,
+		AccessToken:      accessToken,
+		IDToken:          idToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(expiresIn / time.Second),
+		RefreshExpiresIn: int(refreshExpiresIn / time.Second),
+	}, nil
 }
 
 type UserInfo struct {
 	Sub     string           `json:"sub"`
 	Profile *UserInfoProfile `json:"profile,omitempty"`
+	Email   string           `json:"email,omitempty"`
+	Groups  []string         `json:"groups,omitempty"`
 }
 
 type UserInfoProfile struct {
-	Handle string `json:"handle"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
 }
 
 func userInfoFromDomain(
@@ -43,9 +99,15 @@ func userInfoFromDomain(
 	response := UserInfo{}
 	if userInfo != nil {
 		response.Sub = userInfo.Sub
+		response.Email = userInfo.Email
+		response.Groups = userInfo.Groups
 	}
 	if userInfo != nil && userInfo.Profile != nil {
-		response.Profile = &UserInfoProfile{Handle: userInfo.Profile.Handle}
+		response.Profile = &UserInfoProfile{
+			Handle:      userInfo.Profile.Handle,
+			DisplayName: userInfo.Profile.DisplayName,
+			AvatarURL:   userInfo.Profile.AvatarURL,
+		}
 	}
 	return response
 }
@@ -56,9 +118,10 @@ func (a *API) buildAuthRouter() http.Handler {
 	mux.HandleFunc("POST /login", a.handleLogin)
 	mux.HandleFunc("POST /logout", a.handleLogout)
 	mux.HandleFunc("POST /refresh", a.handleRefresh)
+	mux.HandleFunc("POST /exchange", a.handleExchange)
 	mux.HandleFunc("GET  /userinfo", a.handleUserInfo)
 
-	return mux
+	return accesslog.Routes(mux)
 }
 
 func (a *API) handleLogin(
@@ -66,32 +129,36 @@ func (a *API) handleLogin(
 	r *http.Request,
 ) {
 	var req LoginRequest
-	switch r.Header.Get("Content-Type") {
+	switch requestMediaType(r) {
 	case "application/x-www-form-urlencoded":
 		req = LoginRequest{
 			Handle:      r.FormValue("handle"),
 			Secret:      r.FormValue("secret"),
 			Integration: r.FormValue("integration"),
 			ReturnTo:    r.FormValue("return_to"),
+			SessionOnly: r.FormValue("session_only") != "",
 		}
 		if req.Handle == "" || req.Secret == "" || req.Integration == "" {
-			wire.WriteError(w, http.StatusBadRequest, "Missing form fields")
+			writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing form fields")
 			return
 		}
 	case "application/json":
 		var err error
 		if req, err = decodeRequest[LoginRequest](r); err != nil {
-			wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+			writeDecodeError(w, err)
 			return
 		}
 	default:
-		wire.WriteError(w, http.StatusUnsupportedMediaType, "Unsupported content type")
+		writeErrorCode(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Unsupported content type")
 		return
 	}
 
-	redirectURL, err := a.service.GrantAuthCode(req.Handle, req.Secret, req.Integration, req.ReturnTo)
+	redirectURL, err := a.service.GrantAuthCodeWithOptions(r.Context(), req.Handle, req.Secret, req.Integration, service.LoginOptions{
+		ReturnTo:    req.ReturnTo,
+		SessionOnly: req.SessionOnly,
+	})
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -104,13 +171,13 @@ func (a *API) handleLogout(
 ) {
 	req, err := decodeRequest[LogoutRequest](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	err = a.service.RevokeRefreshToken(req.RefreshToken)
+	err = a.service.RevokeRefreshToken(r.Context(), req.RefreshToken)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -123,20 +190,70 @@ func (a *API) handleRefresh(
 ) {
 	req, err := decodeRequest[RefreshRequest](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	accessToken, refreshToken, err := a.service.RefreshAccessToken(req.RefreshToken)
+	client := service.ClientCredentials{ID: req.ClientID, Secret: req.ClientSecret}
+	if id, secret, ok := r.BasicAuth(); ok {
+		client = service.ClientCredentials{ID: id, Secret: secret}
+	}
+
+	accessToken, refreshToken, err := a.service.RefreshAccessToken(r.Context(), req.RefreshToken, client)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
-	wire.WriteData(w, http.StatusOK, RefreshResponse{
-		RefreshToken: refreshToken,
-		AccessToken:  accessToken,
-	})
+	idToken, err := a.service.IssueIDToken(r.Context(), accessToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response, err := a.refreshResponse(accessToken, refreshToken, idToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, response)
+}
+
+func (a *API) handleExchange(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	req, err := decodeRequest[ExchangeRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	client := service.ClientCredentials{ID: req.ClientID, Secret: req.ClientSecret}
+	if id, secret, ok := r.BasicAuth(); ok {
+		client = service.ClientCredentials{ID: id, Secret: secret}
+	}
+
+	accessToken, refreshToken, err := a.service.ExchangeAuthorizationCode(r.Context(), req.Code, client)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	idToken, err := a.service.IssueIDToken(r.Context(), accessToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response, err := a.refreshResponse(accessToken, refreshToken, idToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, response)
 }
 
 func (a *API) handleUserInfo(
@@ -146,13 +263,13 @@ func (a *API) handleUserInfo(
 	authHeader := r.Header.Get("Authorization")
 	encodedToken, ok := parseBearerToken(authHeader)
 	if !ok {
-		wire.WriteError(w, httpStatusFromError(service.ErrTokenInvalid), service.ErrTokenInvalid.Error())
+		writeError(w, service.ErrTokenInvalid)
 		return
 	}
 
-	userInfo, err := a.service.GetUserInfo(encodedToken)
+	userInfo, err := a.service.GetUserInfo(r.Context(), encodedToken)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -1,9 +1,12 @@
 package api_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
 	"git.sr.ht/~jakintosh/consent/internal/api"
@@ -157,7 +160,7 @@ func TestAPILogin_UnknownIntegration(t *testing.T) {
 	result := wire.TestPost[any](env.Router, "/auth/login", body, jsonHeader)
 	result.ExpectStatusError(t, http.StatusBadRequest)
 
-	_, err := env.Service.GetIntegration(service.InternalIntegrationName)
+	_, err := env.Service.GetIntegration(t.Context(), service.InternalIntegrationName)
 	if err != nil {
 		t.Fatalf("expected internal integration to exist: %v", err)
 	}
@@ -260,6 +263,71 @@ func TestAPIRefresh_Success(t *testing.T) {
 	}
 }
 
+func TestAPIRefresh_ReportsLifetimes(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	body := `{
+		"refreshToken": "` + token.Encoded() + `"
+	}`
+	result := wire.TestPost[api.RefreshResponse](env.Router, "/auth/refresh", body, jsonHeader)
+	response := result.ExpectOK(t)
+	if response.TokenType != "Bearer" {
+		t.Errorf("token_type = %q, want Bearer", response.TokenType)
+	}
+	if response.ExpiresIn != int(service.AccessTokenLifetime/time.Second) {
+		t.Errorf("expires_in = %d, want %d", response.ExpiresIn, int(service.AccessTokenLifetime/time.Second))
+	}
+	if response.RefreshExpiresIn <= response.ExpiresIn {
+		t.Errorf("refresh_expires_in = %d, want more than expires_in", response.RefreshExpiresIn)
+	}
+}
+
+func TestAPIRefresh_IssuesIDToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestRefreshTokenWithScopes(t, "alice", []string{"test-audience"}, []string{"identity", "profile"})
+	if err := env.DB.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
+		t.Fatalf("failed to store refresh token: %v", err)
+	}
+
+	body := `{
+		"refreshToken": "` + token.Encoded() + `"
+	}`
+	result := wire.TestPost[api.RefreshResponse](env.Router, "/auth/refresh", body, jsonHeader)
+	response := result.ExpectOK(t)
+
+	idToken := new(tokens.IDToken)
+	if err := idToken.Decode(response.IDToken, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode id token: %v", err)
+	}
+	if idToken.Subject() != token.Subject() {
+		t.Errorf("sub = %q, want %q", idToken.Subject(), token.Subject())
+	}
+	if idToken.Profile().Handle != "alice" {
+		t.Errorf("handle = %q, want alice", idToken.Profile().Handle)
+	}
+}
+
+func TestAPIRefresh_NoIDTokenWithoutIdentityScope(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	body := `{
+		"refreshToken": "` + token.Encoded() + `"
+	}`
+	result := wire.TestPost[api.RefreshResponse](env.Router, "/auth/refresh", body, jsonHeader)
+	response := result.ExpectOK(t)
+	if response.IDToken != "" {
+		t.Errorf("expected no id token, got %q", response.IDToken)
+	}
+}
+
 func TestAPIRefresh_InvalidToken(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
@@ -330,6 +398,90 @@ func TestAPIRefresh_InvalidJSON(t *testing.T) {
 	result.ExpectStatusError(t, http.StatusBadRequest)
 }
 
+func TestAPIExchange_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	body := `{
+		"code": "` + code + `",
+		"clientId": "test-integration"
+	}`
+	result := wire.TestPost[api.RefreshResponse](env.Router, "/auth/exchange", body, jsonHeader)
+	response := result.ExpectOK(t)
+	if response.AccessToken == "" || response.RefreshToken == "" {
+		t.Error("expected non-empty tokens")
+	}
+	if response.IDToken == "" {
+		t.Error("expected id token for identity scope")
+	}
+}
+
+func TestAPIExchange_CodeIsSingleUse(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	body := `{
+		"code": "` + code + `"
+	}`
+	result := wire.TestPost[api.RefreshResponse](env.Router, "/auth/exchange", body, jsonHeader)
+	result.ExpectOK(t)
+
+	badResult := wire.TestPost[any](env.Router, "/auth/exchange", body, jsonHeader)
+	badResult.ExpectStatusError(t, http.StatusBadRequest)
+}
+
+func TestAPIExchange_RefreshTokenIsNotCode(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	body := `{
+		"code": "` + token.Encoded() + `"
+	}`
+	result := wire.TestPost[any](env.Router, "/auth/exchange", body, jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+}
+
+func TestAPIExchange_ExpiredCode(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	sum := sha256.Sum256([]byte("expired-code"))
+	err = env.DB.InsertAuthorizationCode(t.Context(), &service.AuthorizationCode{
+		CodeHash:    hex.EncodeToString(sum[:]),
+		Subject:     user.Subject,
+		Integration: "test-integration",
+		ExpiresAt:   time.Now().Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("InsertAuthorizationCode failed: %v", err)
+	}
+
+	body := `{
+		"code": "expired-code"
+	}`
+	result := wire.TestPost[any](env.Router, "/auth/exchange", body, jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, result.Raw, "auth_code_expired")
+}
+
+func TestAPIExchange_InvalidJSON(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestPost[any](env.Router, "/auth/exchange", "bad-json", jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+}
+
 func TestAPIUserInfo_IdentityOnly(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
@@ -362,6 +514,19 @@ func TestAPIUserInfo_ProfileScope(t *testing.T) {
 	}
 }
 
+func TestAPIUserInfo_RootAlias(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+
+	token := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience", consentAudience}, []string{"identity"})
+	result := wire.TestGet[api.UserInfo](env.Router, "/userinfo", authHeader(token))
+	response := result.ExpectOK(t)
+	if response.Sub != token.Subject() {
+		t.Fatalf("sub = %q, want %q", response.Sub, token.Subject())
+	}
+}
+
 func TestAPIUserInfo_RequiresIdentityScope(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
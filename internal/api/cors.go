@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMaxAge is how long browsers may cache a preflight response.
+const corsMaxAge = 10 * time.Minute
+
+var (
+	corsAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	corsAllowedHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// CORSOptions lets browser apps on other origins call the API with
+// credentials. AllowedOrigins are exact origins such as
+// "https://app.example.com"; IntegrationOrigins additionally allows the
+// origin of every registered integration redirect.
+type CORSOptions struct {
+	AllowedOrigins     []string
+	IntegrationOrigins bool
+}
+
+func (o CORSOptions) enabled() bool {
+	return len(o.AllowedOrigins) > 0 || o.IntegrationOrigins
+}
+
+// withCORS adds CORS headers for allowed origins and answers preflight
+// requests. Requests from other origins pass through without CORS headers,
+// so browsers refuse to expose the response.
+func (a *API) withCORS(
+	next http.Handler,
+) http.Handler {
+	if !a.cors.enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !a.allowsOrigin(r.Context(), origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (a *API) allowsOrigin(
+	ctx context.Context,
+	origin string,
+) bool {
+	if slices.Contains(a.cors.AllowedOrigins, origin) {
+		return true
+	}
+	if !a.cors.IntegrationOrigins {
+		return false
+	}
+
+	origins, err := a.service.IntegrationOrigins(ctx)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(origins, origin)
+}
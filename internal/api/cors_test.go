@@ -0,0 +1,110 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func setupCORSRouter(
+	t *testing.T,
+	cors api.CORSOptions,
+) (
+	*testutil.TestEnv,
+	http.Handler,
+) {
+	t.Helper()
+	env := testutil.SetupTestEnvWithRouter(t)
+	apiServer, err := api.New(api.Options{
+		Service:   env.Service,
+		KeysStore: env.DB.KeysStore,
+		CORS:      cors,
+	})
+	if err != nil {
+		t.Fatalf("api.New failed: %v", err)
+	}
+	return env, apiServer.Router()
+}
+
+func corsRequest(
+	router http.Handler,
+	method string,
+	path string,
+	origin string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPICORS_PreflightAllowedOrigin(t *testing.T) {
+	t.Parallel()
+	_, router := setupCORSRouter(t, api.CORSOptions{AllowedOrigins: []string{"https://spa.example.test"}})
+
+	rec := corsRequest(router, http.MethodOptions, "/auth/refresh", "https://spa.example.test")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://spa.example.test" {
+		t.Errorf("Allow-Origin = %q, want request origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Allow-Credentials = %q, want true", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") == "" || rec.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight missing allowed methods or headers: %v", rec.Header())
+	}
+}
+
+func TestAPICORS_DisallowedOrigin(t *testing.T) {
+	t.Parallel()
+	_, router := setupCORSRouter(t, api.CORSOptions{AllowedOrigins: []string{"https://spa.example.test"}})
+
+	rec := corsRequest(router, http.MethodOptions, "/auth/refresh", "https://evil.example.test")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q, want none for disallowed origin", got)
+	}
+
+	rec = corsRequest(router, http.MethodPost, "/auth/logout", "https://evil.example.test")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q, want none for disallowed origin", got)
+	}
+}
+
+func TestAPICORS_IntegrationOrigins(t *testing.T) {
+	t.Parallel()
+	_, router := setupCORSRouter(t, api.CORSOptions{IntegrationOrigins: true})
+
+	// test-integration redirects to http://localhost:8080/callback
+	rec := corsRequest(router, http.MethodPost, "/auth/logout", "http://localhost:8080")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:8080" {
+		t.Errorf("Allow-Origin = %q, want integration origin", got)
+	}
+
+	rec = corsRequest(router, http.MethodPost, "/auth/logout", "http://localhost:9999")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q, want none for unregistered origin", got)
+	}
+}
+
+func TestAPICORS_DisabledByDefault(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	rec := corsRequest(env.Router, http.MethodOptions, "/auth/refresh", "https://spa.example.test")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q, want none without CORS config", got)
+	}
+}
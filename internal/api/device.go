@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+type DeviceCodeRequest struct {
+	Integration string   `json:"integration"`
+	Scopes      []string `json:"scopes"`
+}
+
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"deviceCode"`
+	UserCode                string `json:"userCode"`
+	VerificationURI         string `json:"verificationUri"`
+	VerificationURIComplete string `json:"verificationUriComplete"`
+	ExpiresIn               int    `json:"expiresIn"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceTokenRequest polls for the tokens of a device authorization.
+// Confidential integrations authenticate with ClientID and ClientSecret, or
+// with HTTP Basic auth.
+type DeviceTokenRequest struct {
+	DeviceCode   string `json:"deviceCode"`
+	ClientID     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+func deviceCodeFromDomain(grant *service.DeviceCodeGrant) DeviceCodeResponse {
+	return DeviceCodeResponse{
+		DeviceCode:              grant.DeviceCode,
+		UserCode:                grant.UserCode,
+		VerificationURI:         grant.VerificationURI,
+		VerificationURIComplete: grant.VerificationURIComplete,
+		ExpiresIn:               int(grant.ExpiresIn / time.Second),
+		Interval:                int(grant.Interval / time.Second),
+	}
+}
+
+func (a *API) buildDeviceRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /code", a.handleDeviceCode)
+	mux.HandleFunc("POST /token", a.handleDeviceToken)
+
+	return accesslog.Routes(mux)
+}
+
+func (a *API) handleDeviceCode(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req DeviceCodeRequest
+	switch requestMediaType(r) {
+	case "application/x-www-form-urlencoded":
+		req = DeviceCodeRequest{
+			Integration: r.FormValue("client_id"),
+			Scopes:      strings.Fields(r.FormValue("scope")),
+		}
+	case "application/json":
+		var err error
+		if req, err = decodeRequest[DeviceCodeRequest](r); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+	default:
+		writeErrorCode(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Unsupported content type")
+		return
+	}
+
+	grant, err := a.service.StartDeviceAuthorization(r.Context(), req.Integration, req.Scopes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, deviceCodeFromDomain(grant))
+}
+
+func (a *API) handleDeviceToken(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req DeviceTokenRequest
+	switch requestMediaType(r) {
+	case "application/x-www-form-urlencoded":
+		req = DeviceTokenRequest{
+			DeviceCode:   r.FormValue("device_code"),
+			ClientID:     r.FormValue("client_id"),
+			ClientSecret: r.FormValue("client_secret"),
+		}
+	case "application/json":
+		var err error
+		if req, err = decodeRequest[DeviceTokenRequest](r); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+	default:
+		writeErrorCode(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Unsupported content type")
+		return
+	}
+
+	client := service.ClientCredentials{ID: req.ClientID, Secret: req.ClientSecret}
+	if id, secret, ok := r.BasicAuth(); ok {
+		client = service.ClientCredentials{ID: id, Secret: secret}
+	}
+
+	accessToken, refreshToken, err := a.service.PollDeviceAuthorization(r.Context(), req.DeviceCode, client)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	idToken, err := a.service.IssueIDToken(r.Context(), accessToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response, err := a.refreshResponse(accessToken, refreshToken, idToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, response)
+}
@@ -0,0 +1,82 @@
+package api_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestAPIDeviceFlow_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+
+	// device starts the flow
+	body := `{"integration": "test-integration", "scopes": ["identity"]}`
+	code := wire.TestPost[api.DeviceCodeResponse](env.Router, "/device/code", body, jsonHeader).ExpectOK(t)
+	if code.DeviceCode == "" || code.UserCode == "" {
+		t.Fatalf("response = %#v, want device and user codes", code)
+	}
+	if code.ExpiresIn <= 0 || code.Interval <= 0 {
+		t.Fatalf("response = %#v, want positive expiry and interval", code)
+	}
+
+	// polling before approval is pending
+	pollBody := `{"deviceCode": "` + code.DeviceCode + `"}`
+	pending := wire.TestPost[any](env.Router, "/device/token", pollBody, jsonHeader).ExpectStatusError(t, http.StatusBadRequest)
+	if pending.Message != "authorization pending" {
+		t.Fatalf("error = %q, want authorization pending", pending.Message)
+	}
+
+	// user approves, device receives tokens
+	if err := env.Service.ApproveDeviceAuthorization(t.Context(), alice.Subject, time.Now(), code.UserCode); err != nil {
+		t.Fatalf("ApproveDeviceAuthorization failed: %v", err)
+	}
+	tokens := wire.TestPost[api.RefreshResponse](env.Router, "/device/token", pollBody, jsonHeader).ExpectOK(t)
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("response = %#v, want tokens", tokens)
+	}
+}
+
+func TestAPIDeviceCode_FormEncoded(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	body := url.Values{
+		"client_id": []string{"test-integration"},
+		"scope":     []string{"identity profile"},
+	}.Encode()
+	formHeader := wire.TestHeader{Key: "Content-Type", Value: "application/x-www-form-urlencoded"}
+	code := wire.TestPost[api.DeviceCodeResponse](env.Router, "/device/code", body, formHeader).ExpectOK(t)
+	if code.DeviceCode == "" {
+		t.Fatal("expected device code")
+	}
+}
+
+func TestAPIDeviceCode_UnknownIntegration(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	body := `{"integration": "missing", "scopes": ["identity"]}`
+	wire.TestPost[any](env.Router, "/device/code", body, jsonHeader).ExpectStatus(t, http.StatusBadRequest)
+}
+
+func TestAPIDeviceToken_UnknownDeviceCode(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	body := `{"deviceCode": "not-a-device-code"}`
+	result := wire.TestPost[any](env.Router, "/device/token", body, jsonHeader).ExpectStatusError(t, http.StatusBadRequest)
+	if result.Message != "device code not found" {
+		t.Fatalf("error = %q, want device code not found", result.Message)
+	}
+}
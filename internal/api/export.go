@@ -0,0 +1,135 @@
+package api
+
+import (
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// Export is the JSON document `consent export` writes and `consent import`
+// reads. Password and client secret hashes are included as stored, so
+// exports must be kept as carefully as the database itself.
+type Export struct {
+	Version      int                 `json:"version"`
+	ExportedAt   time.Time           `json:"exportedAt"`
+	Roles        []Role              `json:"roles"`
+	Integrations []ExportIntegration `json:"integrations"`
+	Users        []ExportUser        `json:"users"`
+}
+
+// ExportIntegration is an integration and its client secret hash.
+type ExportIntegration struct {
+	Integration
+	SecretHash string `json:"secretHash,omitempty"`
+}
+
+// ExportUser is a user and everything owned by them. PasswordHash is the
+// stored bcrypt hash.
+type ExportUser struct {
+	User
+	PasswordHash string           `json:"passwordHash"`
+	Profile      *Profile         `json:"profile,omitempty"`
+	Identities   []LinkedIdentity `json:"identities,omitempty"`
+	Grants       []ExportGrant    `json:"grants,omitempty"`
+}
+
+// ExportGrant is the scopes a user has granted one integration.
+type ExportGrant struct {
+	Integration string   `json:"integration"`
+	Scopes      []string `json:"scopes"`
+}
+
+// ExportFromDomain converts a service snapshot into its JSON form.
+func ExportFromDomain(
+	snapshot *service.Snapshot,
+) Export {
+	export := Export{
+		Version:      snapshot.Version,
+		ExportedAt:   snapshot.ExportedAt,
+		Roles:        rolesFromDomain(snapshot.Roles),
+		Integrations: make([]ExportIntegration, 0, len(snapshot.Integrations)),
+		Users:        make([]ExportUser, 0, len(snapshot.Users)),
+	}
+	for _, integration := range snapshot.Integrations {
+		export.Integrations = append(export.Integrations, ExportIntegration{
+			Integration: integrationFromDomain(integration.Integration),
+			SecretHash:  integration.SecretHash,
+		})
+	}
+	for _, user := range snapshot.Users {
+		exported := ExportUser{
+			User:         userFromDomain(user.User),
+			PasswordHash: string(user.PasswordHash),
+			Identities:   linkedIdentitiesFromDomain(user.ExternalIdentities),
+		}
+		if user.Profile != (service.Profile{}) {
+			profile := profileFromDomain(&user.Profile)
+			exported.Profile = &profile
+		}
+		for _, grant := range user.Grants {
+			exported.Grants = append(exported.Grants, ExportGrant{
+				Integration: grant.Integration,
+				Scopes:      grant.Scopes,
+			})
+		}
+		export.Users = append(export.Users, exported)
+	}
+	return export
+}
+
+// ToDomain converts an export read from a file into a service snapshot.
+func (e Export) ToDomain() *service.Snapshot {
+	snapshot := &service.Snapshot{
+		Version:    e.Version,
+		ExportedAt: e.ExportedAt,
+	}
+	for _, role := range e.Roles {
+		snapshot.Roles = append(snapshot.Roles, service.Role{Name: role.Name, Display: role.Display})
+	}
+	for _, integration := range e.Integrations {
+		snapshot.Integrations = append(snapshot.Integrations, service.IntegrationSnapshot{
+			Integration: integration.Integration.ToDomain(),
+			SecretHash:  integration.SecretHash,
+		})
+	}
+	for _, user := range e.Users {
+		imported := service.UserSnapshot{
+			User: service.User{
+				Subject:      user.Subject,
+				Handle:       user.Handle,
+				Roles:        user.Roles,
+				Disabled:     user.Disabled,
+				TermsVersion: user.TermsVersion,
+			},
+			PasswordHash: []byte(user.PasswordHash),
+		}
+		if user.ExpiresAt != nil {
+			imported.ExpiresAt = *user.ExpiresAt
+		}
+		if user.TermsAcceptedAt != nil {
+			imported.TermsAcceptedAt = *user.TermsAcceptedAt
+		}
+		if user.Profile != nil {
+			imported.Profile = service.Profile{
+				DisplayName: user.Profile.DisplayName,
+				Email:       user.Profile.Email,
+				AvatarURL:   user.Profile.AvatarURL,
+			}
+		}
+		for _, identity := range user.Identities {
+			imported.ExternalIdentities = append(imported.ExternalIdentities, service.ExternalIdentity{
+				Provider:  identity.Provider,
+				Subject:   identity.Subject,
+				CreatedAt: identity.CreatedAt,
+			})
+		}
+		for _, grant := range user.Grants {
+			imported.Grants = append(imported.Grants, service.Grant{
+				Integration: grant.Integration,
+				Scopes:      grant.Scopes,
+			})
+		}
+		snapshot.Users = append(snapshot.Users, imported)
+	}
+	return snapshot
+}
@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+func TestExport_JSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	snapshot := &service.Snapshot{
+		Version:    service.SnapshotVersion,
+		ExportedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Roles:      []service.Role{{Name: "admin", Display: "Administrator"}},
+		Integrations: []service.IntegrationSnapshot{{
+			Integration: service.Integration{
+				Name:     "app",
+				Display:  "App",
+				Audience: "app.test",
+				Redirect: "https://app.test/callback",
+				Policy:   service.IntegrationPolicy{AccessTokenLifetime: 10 * time.Minute},
+			},
+			SecretHash: "secret-hash",
+		}},
+		Users: []service.UserSnapshot{{
+			User:         service.User{Subject: "sub-alice", Handle: "alice", Roles: []string{"admin"}},
+			PasswordHash: []byte("$2a$10$hash"),
+			Profile:      service.Profile{DisplayName: "Alice"},
+			Grants:       []service.Grant{{Integration: "app", Scopes: []string{"identity"}}},
+		}},
+	}
+
+	payload, err := json.Marshal(api.ExportFromDomain(snapshot))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded api.Export
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	got := decoded.ToDomain()
+
+	if got.Version != snapshot.Version || !got.ExportedAt.Equal(snapshot.ExportedAt) {
+		t.Errorf("version/time = %d/%v", got.Version, got.ExportedAt)
+	}
+	if len(got.Integrations) != 1 || got.Integrations[0].SecretHash != "secret-hash" ||
+		got.Integrations[0].Policy.AccessTokenLifetime != 10*time.Minute {
+		t.Errorf("integrations = %+v", got.Integrations)
+	}
+	if len(got.Users) != 1 {
+		t.Fatalf("users = %+v, want one", got.Users)
+	}
+	user := got.Users[0]
+	if user.Subject != "sub-alice" || string(user.PasswordHash) != "$2a$10$hash" || user.Profile.DisplayName != "Alice" {
+		t.Errorf("user = %+v", user)
+	}
+	if len(user.Grants) != 1 || !slices.Equal(user.Grants[0].Scopes, []string{"identity"}) {
+		t.Errorf("grants = %+v", user.Grants)
+	}
+}
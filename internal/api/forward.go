@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// Identity headers set on a successful forward-auth response, for the proxy
+// to copy onto the request it passes upstream.
+const (
+	HeaderAuthUser    = "X-Auth-User"
+	HeaderAuthSubject = "X-Auth-Subject"
+	HeaderAuthRoles   = "X-Auth-Roles"
+	HeaderAuthScopes  = "X-Auth-Scopes"
+)
+
+// handleForwardAuth answers a reverse proxy's auth subrequest (Traefik
+// forwardAuth, NGINX auth_request). The proxied request's access token comes
+// from its Authorization header or the Go client's accessToken cookie; the
+// optional integration query parameter names whose tokens are accepted. A
+// valid token gets 200 and identity headers; an invalid token, or one whose
+// account is gone, disabled, or expired, gets 401 with the login page in
+// Location, carrying the proxied URL so the user comes back to it.
+func (a *API) handleForwardAuth(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	integration := r.URL.Query().Get("integration")
+
+	encodedToken, ok := parseBearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		if cookie, err := r.Cookie("accessToken"); err == nil {
+			encodedToken = cookie.Value
+		}
+	}
+
+	identity, err := a.service.ForwardAuth(r.Context(), encodedToken, integration)
+	if err != nil {
+		if errors.Is(err, service.ErrTokenInvalid) ||
+			errors.Is(err, service.ErrAccountNotFound) ||
+			errors.Is(err, service.ErrAccountDisabled) ||
+			errors.Is(err, service.ErrAccountExpired) {
+			w.Header().Set("Location", a.service.ForwardAuthLoginURL(r.Context(), integration, forwardedRequestURL(r)))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeErrorCode(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(HeaderAuthUser, identity.Handle)
+	w.Header().Set(HeaderAuthSubject, identity.Subject)
+	w.Header().Set(HeaderAuthRoles, strings.Join(identity.Roles, ","))
+	w.Header().Set(HeaderAuthScopes, strings.Join(identity.Scopes, " "))
+	w.WriteHeader(http.StatusOK)
+}
+
+// forwardedRequestURL rebuilds the URL the user asked the proxy for, from
+// NGINX's X-Original-URL or Traefik's X-Forwarded-Proto, -Host, and -Uri.
+// It returns empty when the proxy sent neither.
+func forwardedRequestURL(
+	r *http.Request,
+) string {
+	if original := r.Header.Get("X-Original-URL"); original != "" {
+		return original
+	}
+	host := firstHeaderValue(r.Header.Get("X-Forwarded-Host"))
+	if host == "" {
+		return ""
+	}
+	proto := firstHeaderValue(r.Header.Get("X-Forwarded-Proto"))
+	if proto == "" {
+		proto = "https"
+	}
+	return proto + "://" + host + r.Header.Get("X-Forwarded-Uri")
+}
+
+// firstHeaderValue returns the first entry of a comma-separated header that
+// proxies may have appended to.
+func firstHeaderValue(
+	value string,
+) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
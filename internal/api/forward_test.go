@@ -0,0 +1,144 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestAPIForwardAuth_PassesIdentity(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+	token := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience"}, []string{"identity"})
+
+	// a bearer token for the named integration passes
+	result := wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration", authHeader(token))
+	result.ExpectStatus(t, http.StatusOK)
+	if got := result.Headers.Get(api.HeaderAuthUser); got != "alice" {
+		t.Errorf("%s = %q, want alice", api.HeaderAuthUser, got)
+	}
+	if got := result.Headers.Get(api.HeaderAuthSubject); got != token.Subject() {
+		t.Errorf("%s = %q, want %q", api.HeaderAuthSubject, got, token.Subject())
+	}
+	if got := result.Headers.Get(api.HeaderAuthScopes); got != "identity" {
+		t.Errorf("%s = %q, want identity", api.HeaderAuthScopes, got)
+	}
+
+	// so does the Go client's cookie
+	cookie := wire.TestHeader{Key: "Cookie", Value: "accessToken=" + token.Encoded()}
+	result = wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration", cookie)
+	result.ExpectStatus(t, http.StatusOK)
+}
+
+func TestAPIForwardAuth_RedirectsToLogin(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+	token := env.IssueTestAccessToken(t, "alice", []string{"test-audience"})
+
+	// no token at all
+	result := wire.TestGet[any](env.Router, "/forward-auth")
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	if got := result.Headers.Get("Location"); got != "https://consent.test/login" {
+		t.Errorf("Location = %q, want the login page", got)
+	}
+
+	// a token for another integration
+	result = wire.TestGet[any](env.Router, "/forward-auth", authHeader(token))
+	result.ExpectStatus(t, http.StatusUnauthorized)
+
+	// an integration's login goes through its authorize page
+	result = wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration")
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	want := "https://consent.test/authorize?integration=test-integration&scope=identity"
+	if got := result.Headers.Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+}
+
+func TestAPIForwardAuth_CarriesRequestURL(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	// Traefik describes the proxied request in X-Forwarded-* headers
+	result := wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration",
+		wire.TestHeader{Key: "X-Forwarded-Proto", Value: "http"},
+		wire.TestHeader{Key: "X-Forwarded-Host", Value: "localhost:8080"},
+		wire.TestHeader{Key: "X-Forwarded-Uri", Value: "/reports?id=7"},
+	)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	want := "https://consent.test/authorize?integration=test-integration" +
+		"&redirect_uri=http%3A%2F%2Flocalhost%3A8080%2Fcallback" +
+		"&return_to=%2Freports%3Fid%3D7&scope=identity"
+	if got := result.Headers.Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	// NGINX sends the whole URL in X-Original-URL
+	original := wire.TestHeader{Key: "X-Original-URL", Value: "http://localhost:8080/reports?id=7"}
+	result = wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration", original)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	if got := result.Headers.Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	// a URL off the integration's redirect origins is left behind
+	foreign := wire.TestHeader{Key: "X-Original-URL", Value: "https://attacker.test/reports"}
+	result = wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration", foreign)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	want = "https://consent.test/authorize?integration=test-integration&scope=identity"
+	if got := result.Headers.Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	// Consent's own sign-in returns to URLs on its own origin
+	own := wire.TestHeader{Key: "X-Original-URL", Value: "https://consent.test/account"}
+	result = wire.TestGet[any](env.Router, "/forward-auth", own)
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	if got := result.Headers.Get("Location"); got != "https://consent.test/login?return_to=%2Faccount" {
+		t.Errorf("Location = %q, want the login page returning to /account", got)
+	}
+}
+
+func TestAPIForwardAuth_RefusesDisabledAccount(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+	token := env.IssueTestAccessToken(t, "alice", []string{"test-audience"})
+
+	// a still-valid token stops passing once its account is disabled
+	disabled := true
+	if _, err := env.Service.UpdateUser(t.Context(), token.Subject(), &service.UserUpdate{Disabled: &disabled}); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	result := wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration", authHeader(token))
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	want := "https://consent.test/authorize?integration=test-integration&scope=identity"
+	if got := result.Headers.Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+}
+
+func TestAPIForwardAuth_RefusesExpiredAccount(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+	token := env.IssueTestAccessToken(t, "alice", []string{"test-audience"})
+
+	// a still-valid token stops passing once its account expires
+	expiresAt := time.Now().Add(-time.Minute)
+	if _, err := env.Service.UpdateUser(t.Context(), token.Subject(), &service.UserUpdate{ExpiresAt: &expiresAt}); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	result := wire.TestGet[any](env.Router, "/forward-auth?integration=test-integration", authHeader(token))
+	result.ExpectStatus(t, http.StatusUnauthorized)
+	if got := result.Headers.Get("Location"); got == "" {
+		t.Errorf("expected the login page in Location")
+	}
+}
@@ -3,50 +3,209 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
+// Error codes for failures that happen before the service is reached.
+const (
+	CodeMalformedRequest     = "malformed_request"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRequestTooLarge      = "request_too_large"
+	CodeMissingParameter     = "missing_parameter"
+	CodeInternal             = "internal_error"
+	CodeReloadFailed         = "reload_failed"
+	CodeKeyUnavailable       = "key_unavailable"
+)
+
+// Error is the error body of an API response. Code is a stable identifier
+// clients can branch on; Message is human-readable and may change. RequestID
+// identifies the failed request in the server's logs.
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type errorResponse struct {
+	Error Error `json:"error"`
+}
+
+type apiErrorSpec struct {
+	err    error
+	status int
+	code   string
+}
+
+// apiErrorSpecs maps service errors to HTTP statuses and error codes. The first
+// matching entry wins, so more specific errors must come first.
+var apiErrorSpecs = []apiErrorSpec{
+	{service.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{service.ErrAccountNotFound, http.StatusUnauthorized, "invalid_credentials"},
+	{service.ErrInvalidClient, http.StatusUnauthorized, "invalid_client"},
+	{service.ErrReauthenticationRequired, http.StatusUnauthorized, "reauthentication_required"},
+
+	{service.ErrIntegrationNotFound, http.StatusBadRequest, "integration_not_found"},
+	{service.ErrTokenInvalid, http.StatusBadRequest, "token_invalid"},
+	{service.ErrAuthCodeExpired, http.StatusBadRequest, "auth_code_expired"},
+	{service.ErrInvalidAuthCode, http.StatusBadRequest, "invalid_auth_code"},
+	{service.ErrTokenNotFound, http.StatusBadRequest, "token_not_found"},
+	{service.ErrUserNotFound, http.StatusBadRequest, "user_not_found"},
+	{service.ErrRoleNotFound, http.StatusBadRequest, "role_not_found"},
+	{service.ErrIdentityLinkNotFound, http.StatusBadRequest, "identity_link_not_found"},
+	{service.ErrInvalidHandle, http.StatusBadRequest, "invalid_handle"},
+	{service.ErrInvalidUser, http.StatusBadRequest, "invalid_user"},
+	{service.ErrInvalidRole, http.StatusBadRequest, "invalid_role"},
+	{service.ErrInvalidUpdate, http.StatusBadRequest, "invalid_update"},
+	{service.ErrInvalidProfile, http.StatusBadRequest, "invalid_profile"},
+	{service.ErrInvalidUrl, http.StatusBadRequest, "invalid_url"},
+	{service.ErrInvalidRedirect, http.StatusBadRequest, "invalid_redirect"},
+	{service.ErrInvalidIntegration, http.StatusBadRequest, "invalid_integration"},
+	{service.ErrInvalidAudience, http.StatusBadRequest, "invalid_audience"},
+	{service.ErrInvalidScope, http.StatusBadRequest, "invalid_scope"},
+	{service.ErrMissingScope, http.StatusBadRequest, "missing_scope"},
+	{service.ErrIdentityScopeRequired, http.StatusBadRequest, "identity_scope_required"},
+	{service.ErrInvalidScopeDependency, http.StatusBadRequest, "invalid_scope_dependency"},
+	{service.ErrDeviceCodeNotFound, http.StatusBadRequest, "device_code_not_found"},
+	{service.ErrDeviceCodeExpired, http.StatusBadRequest, "device_code_expired"},
+	{service.ErrAuthorizationPending, http.StatusBadRequest, "authorization_pending"},
+	{service.ErrSlowDown, http.StatusBadRequest, "slow_down"},
+
+	{service.ErrIntegrationProtected, http.StatusForbidden, "integration_protected"},
+	{service.ErrRoleProtected, http.StatusForbidden, "role_protected"},
+	{service.ErrInsufficientScope, http.StatusForbidden, "insufficient_scope"},
+	{service.ErrAuthorizationDenied, http.StatusForbidden, "authorization_denied"},
+	{service.ErrNotAdmin, http.StatusForbidden, "not_admin"},
+	{service.ErrAccountDisabled, http.StatusForbidden, "account_disabled"},
+	{service.ErrAccountExpired, http.StatusForbidden, "account_expired"},
+	{service.ErrTermsNotAccepted, http.StatusForbidden, "terms_not_accepted"},
+
+	{service.ErrHandleExists, http.StatusConflict, "handle_exists"},
+	{service.ErrIntegrationExists, http.StatusConflict, "integration_exists"},
+	{service.ErrRoleExists, http.StatusConflict, "role_exists"},
+	{service.ErrRoleInUse, http.StatusConflict, "role_in_use"},
+	{service.ErrIdentityLinked, http.StatusConflict, "identity_linked"},
+	{service.ErrTermsOutdated, http.StatusConflict, "terms_outdated"},
+
+	{service.ErrInternal, http.StatusInternalServerError, CodeInternal},
+	{service.ErrMaintenance, http.StatusServiceUnavailable, "maintenance"},
+	{service.ErrBusy, http.StatusServiceUnavailable, "busy"},
+}
+
+// maxRequestBytes caps request bodies across the API. The largest requests,
+// integrations with many redirect URLs, are a few kilobytes.
+const maxRequestBytes = 1 << 20
+
+// errUnsupportedMediaType is returned by decodeRequest for bodies that
+// aren't declared as JSON.
+var errUnsupportedMediaType = errors.New("content type must be application/json")
+
+// limitRequestBody caps request bodies at maxRequestBytes. Reads past the
+// limit fail with *http.MaxBytesError.
+func limitRequestBody(
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withClientInfo attaches the caller's address and User-Agent to the request
+// context, so refresh tokens issued while handling it record the client.
+func withClientInfo(
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := service.WithClientInfo(r.Context(), service.ClientInfoFromRequest(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestMediaType returns the request's Content-Type without parameters.
+func requestMediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+// decodeRequest decodes a JSON request body into T. The body must be
+// declared as application/json, hold a single value, and name only fields T
+// has. Report its errors with writeDecodeError.
 func decodeRequest[T any](r *http.Request) (T, error) {
 	var req T
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if requestMediaType(r) != "application/json" {
+		return req, errUnsupportedMediaType
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return req, fmt.Errorf("unexpected data after request body")
+	}
+	return req, nil
 }
 
-func httpStatusFromError(err error) int {
+// writeDecodeError writes the response for a decodeRequest failure: 413 for
+// oversized bodies, 415 for bodies that aren't JSON, and 400 otherwise.
+func writeDecodeError(
+	w http.ResponseWriter,
+	err error,
+) {
+	var tooLarge *http.MaxBytesError
 	switch {
-	case errors.Is(err, service.ErrInvalidCredentials),
-		errors.Is(err, service.ErrAccountNotFound):
-		return http.StatusUnauthorized
-	case errors.Is(err, service.ErrIntegrationNotFound),
-		errors.Is(err, service.ErrTokenInvalid),
-		errors.Is(err, service.ErrTokenNotFound),
-		errors.Is(err, service.ErrUserNotFound),
-		errors.Is(err, service.ErrInvalidHandle),
-		errors.Is(err, service.ErrInvalidUser),
-		errors.Is(err, service.ErrInvalidRole),
-		errors.Is(err, service.ErrInvalidScope),
-		errors.Is(err, service.ErrMissingScope),
-		errors.Is(err, service.ErrIdentityScopeRequired),
-		errors.Is(err, service.ErrInvalidScopeDependency),
-		errors.Is(err, service.ErrRoleNotFound):
-		return http.StatusBadRequest
-	case errors.Is(err, service.ErrHandleExists),
-		errors.Is(err, service.ErrIntegrationExists),
-		errors.Is(err, service.ErrRoleExists),
-		errors.Is(err, service.ErrRoleInUse):
-		return http.StatusConflict
-	case errors.Is(err, service.ErrIntegrationProtected),
-		errors.Is(err, service.ErrRoleProtected),
-		errors.Is(err, service.ErrInsufficientScope):
-		return http.StatusForbidden
-	case errors.Is(err, service.ErrInvalidRedirect),
-		errors.Is(err, service.ErrInvalidIntegration):
-		return http.StatusBadRequest
-	case errors.Is(err, service.ErrInternal):
-		return http.StatusInternalServerError
+	case errors.As(err, &tooLarge):
+		writeErrorCode(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+	case errors.Is(err, errUnsupportedMediaType):
+		writeErrorCode(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Content type must be application/json")
 	default:
-		return http.StatusInternalServerError
+		writeErrorCode(w, http.StatusBadRequest, CodeMalformedRequest, "Malformed JSON")
 	}
 }
+
+func apiErrorFromError(err error) (int, string) {
+	for _, spec := range apiErrorSpecs {
+		if errors.Is(err, spec.err) {
+			return spec.status, spec.code
+		}
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// writeError writes a service error with its mapped status and code.
+func writeError(
+	w http.ResponseWriter,
+	err error,
+) {
+	status, code := apiErrorFromError(err)
+	if errors.Is(err, service.ErrBusy) {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeErrorCode(w, status, code, err.Error())
+}
+
+// writeErrorCode writes an error response in the same envelope as
+// wire.WriteError, with an added machine-readable code and the request ID
+// requestid.Handler put in the response headers.
+func writeErrorCode(
+	w http.ResponseWriter,
+	status int,
+	code string,
+	message string,
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Error: Error{
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get(requestid.Header),
+		},
+	})
+}
@@ -0,0 +1,164 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func expectErrorCode(
+	t *testing.T,
+	raw []byte,
+	code string,
+) api.Error {
+	t.Helper()
+
+	var body struct {
+		Error *api.Error `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatalf("failed to decode error body: %v", err)
+	}
+	if body.Error == nil {
+		t.Fatalf("expected error body, got %s", raw)
+	}
+	if body.Error.Code != code {
+		t.Errorf("expected error code %q, got %q", code, body.Error.Code)
+	}
+	if body.Error.Message == "" {
+		t.Error("expected error message")
+	}
+	return *body.Error
+}
+
+func TestAPIError_InvalidCredentials(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+
+	body := `{"handle":"alice","secret":"wrong","integration":"consent"}`
+	result := wire.TestPost[any](env.Router, "/auth/login", body, jsonHeader)
+	result.ExpectStatusError(t, http.StatusUnauthorized)
+	expectErrorCode(t, result.Raw, "invalid_credentials")
+}
+
+func TestAPIError_UnknownUserMatchesInvalidCredentials(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	body := `{"handle":"nobody","secret":"password","integration":"consent"}`
+	result := wire.TestPost[any](env.Router, "/auth/login", body, jsonHeader)
+	result.ExpectStatusError(t, http.StatusUnauthorized)
+	expectErrorCode(t, result.Raw, "invalid_credentials")
+}
+
+func TestAPIError_UnknownIntegration(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+
+	body := `{"handle":"alice","secret":"password123","integration":"unknown"}`
+	result := wire.TestPost[any](env.Router, "/auth/login", body, jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, result.Raw, "integration_not_found")
+}
+
+func TestAPIError_IncludesRequestID(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password123")
+
+	body := `{"handle":"alice","secret":"wrong","integration":"consent"}`
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestid.Header, "trace-42")
+	rec := httptest.NewRecorder()
+	requestid.Handler(env.Router).ServeHTTP(rec, req)
+
+	apiErr := expectErrorCode(t, rec.Body.Bytes(), "invalid_credentials")
+	if apiErr.RequestID != "trace-42" {
+		t.Errorf("RequestID = %q, want trace-42", apiErr.RequestID)
+	}
+}
+
+func TestAPIError_MalformedJSON(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestPost[any](env.Router, "/auth/refresh", "not-json", jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, result.Raw, api.CodeMalformedRequest)
+}
+
+func TestAPIError_UnsupportedContentType(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	textHeader := wire.TestHeader{Key: "Content-Type", Value: "text/plain"}
+	result := wire.TestPost[any](env.Router, "/auth/login", "hello", textHeader)
+	result.ExpectStatusError(t, http.StatusUnsupportedMediaType)
+	expectErrorCode(t, result.Raw, api.CodeUnsupportedMediaType)
+}
+
+func TestAPIError_JSONRoutesRequireJSON(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	textHeader := wire.TestHeader{Key: "Content-Type", Value: "text/plain"}
+	result := wire.TestPost[any](env.Router, "/auth/refresh", `{"refreshToken":"bogus"}`, textHeader)
+	result.ExpectStatusError(t, http.StatusUnsupportedMediaType)
+	expectErrorCode(t, result.Raw, api.CodeUnsupportedMediaType)
+
+	// parameters on the media type are fine
+	charsetHeader := wire.TestHeader{Key: "Content-Type", Value: "application/json; charset=utf-8"}
+	result = wire.TestPost[any](env.Router, "/auth/refresh", `{"refreshToken":"bogus"}`, charsetHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, result.Raw, "token_invalid")
+}
+
+func TestAPIError_UnknownField(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestPost[any](env.Router, "/auth/refresh", `{"refreshToken":"bogus","refresh_token":"bogus"}`, jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, result.Raw, api.CodeMalformedRequest)
+}
+
+func TestAPIError_TrailingData(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestPost[any](env.Router, "/auth/refresh", `{"refreshToken":"bogus"} {}`, jsonHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+	expectErrorCode(t, result.Raw, api.CodeMalformedRequest)
+}
+
+func TestAPIError_RequestTooLarge(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	body := `{"refreshToken":"` + strings.Repeat("a", 2<<20) + `"}`
+	result := wire.TestPost[any](env.Router, "/auth/refresh", body, jsonHeader)
+	result.ExpectStatusError(t, http.StatusRequestEntityTooLarge)
+	expectErrorCode(t, result.Raw, api.CodeRequestTooLarge)
+}
+
+func TestAPIError_KeepsWireEnvelope(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	result := wire.TestPost[any](env.Router, "/auth/refresh", `{"refreshToken":"bogus"}`, jsonHeader)
+	wireErr := result.ExpectStatusError(t, http.StatusBadRequest)
+	apiErr := expectErrorCode(t, result.Raw, "token_invalid")
+	if wireErr.Message != apiErr.Message {
+		t.Errorf("wire message %q does not match API message %q", wireErr.Message, apiErr.Message)
+	}
+}
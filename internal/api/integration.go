@@ -2,32 +2,100 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
 type Integration struct {
-	Name     string `json:"name"`
-	Display  string `json:"display"`
-	Audience string `json:"audience"`
-	Redirect string `json:"redirect"`
+	Name      string             `json:"name"`
+	Display   string             `json:"display"`
+	Audience  string             `json:"audience"`
+	Redirect  string             `json:"redirect"`
+	Redirects []string           `json:"redirects,omitempty"`
+	Policy    *IntegrationPolicy `json:"policy,omitempty"`
+	HasSecret bool               `json:"hasSecret,omitempty"`
+}
+
+// IntegrationSecretResponse carries a newly generated client secret. It is
+// only ever returned once.
+type IntegrationSecretResponse struct {
+	Secret string `json:"secret"`
+}
+
+// IntegrationPolicy overrides token issuance for an integration. Lifetimes
+// are in seconds; zero or omitted fields use the server defaults.
+type IntegrationPolicy struct {
+	AccessTokenLifetime  int      `json:"accessTokenLifetime,omitempty"`
+	RefreshTokenLifetime int      `json:"refreshTokenLifetime,omitempty"`
+	AllowedScopes        []string `json:"allowedScopes,omitempty"`
+	ReuseRefreshTokens   bool     `json:"reuseRefreshTokens,omitempty"`
+	AuthCodeLifetime     int      `json:"authCodeLifetime,omitempty"`
+	ExchangeAudiences    []string `json:"exchangeAudiences,omitempty"`
+	ReleasedClaims       []string `json:"releasedClaims,omitempty"`
 }
 
 type UpdateIntegrationRequest struct {
-	Display  *string `json:"display,omitempty"`
-	Audience *string `json:"audience,omitempty"`
-	Redirect *string `json:"redirect,omitempty"`
+	Display   *string            `json:"display,omitempty"`
+	Audience  *string            `json:"audience,omitempty"`
+	Redirect  *string            `json:"redirect,omitempty"`
+	Redirects *[]string          `json:"redirects,omitempty"`
+	Policy    *IntegrationPolicy `json:"policy,omitempty"`
 }
 
 func integrationFromDomain(
 	integration service.Integration,
 ) Integration {
 	return Integration{
-		Name:     integration.Name,
-		Display:  integration.Display,
-		Audience: integration.Audience,
-		Redirect: integration.Redirect,
+		Name:      integration.Name,
+		Display:   integration.Display,
+		Audience:  integration.Audience,
+		Redirect:  integration.Redirect,
+		Redirects: integration.Redirects,
+		Policy:    policyFromDomain(integration.Policy),
+		HasSecret: integration.HasSecret,
+	}
+}
+
+func policyFromDomain(
+	policy service.IntegrationPolicy,
+) *IntegrationPolicy {
+	if policy.AccessTokenLifetime == 0 &&
+		policy.RefreshTokenLifetime == 0 &&
+		len(policy.AllowedScopes) == 0 &&
+		!policy.ReuseRefreshTokens &&
+		policy.AuthCodeLifetime == 0 &&
+		len(policy.ExchangeAudiences) == 0 &&
+		len(policy.ReleasedClaims) == 0 {
+		return nil
+	}
+	return &IntegrationPolicy{
+		AccessTokenLifetime:  int(policy.AccessTokenLifetime / time.Second),
+		RefreshTokenLifetime: int(policy.RefreshTokenLifetime / time.Second),
+		AllowedScopes:        policy.AllowedScopes,
+		ReuseRefreshTokens:   policy.ReuseRefreshTokens,
+		AuthCodeLifetime:     int(policy.AuthCodeLifetime / time.Second),
+		ExchangeAudiences:    policy.ExchangeAudiences,
+		ReleasedClaims:       policy.ReleasedClaims,
+	}
+}
+
+func policyToDomain(
+	p *IntegrationPolicy,
+) *service.IntegrationPolicy {
+	if p == nil {
+		return nil
+	}
+	return &service.IntegrationPolicy{
+		AccessTokenLifetime:  time.Duration(p.AccessTokenLifetime) * time.Second,
+		RefreshTokenLifetime: time.Duration(p.RefreshTokenLifetime) * time.Second,
+		AllowedScopes:        p.AllowedScopes,
+		ReuseRefreshTokens:   p.ReuseRefreshTokens,
+		AuthCodeLifetime:     time.Duration(p.AuthCodeLifetime) * time.Second,
+		ExchangeAudiences:    p.ExchangeAudiences,
+		ReleasedClaims:       p.ReleasedClaims,
 	}
 }
 
@@ -41,6 +109,24 @@ func integrationsFromDomain(
 	return apiIntegrations
 }
 
+// ToDomain converts an integration definition, such as one read from a file
+// for linting, into its service form.
+func (i Integration) ToDomain() service.Integration {
+	var policy service.IntegrationPolicy
+	if i.Policy != nil {
+		policy = *policyToDomain(i.Policy)
+	}
+	return service.Integration{
+		Name:      i.Name,
+		Display:   i.Display,
+		Audience:  i.Audience,
+		Redirect:  i.Redirect,
+		Redirects: i.Redirects,
+		Policy:    policy,
+		HasSecret: i.HasSecret,
+	}
+}
+
 func (a *API) buildIntegrationsRouter() http.Handler {
 	mux := http.NewServeMux()
 
@@ -51,7 +137,10 @@ func (a *API) buildIntegrationsRouter() http.Handler {
 	mux.HandleFunc("PATCH  /{name}", a.handleUpdateIntegration)
 	mux.HandleFunc("DELETE /{name}", a.handleDeleteIntegration)
 
-	return mux
+	mux.HandleFunc("POST   /{name}/secret", a.handleRotateIntegrationSecret)
+	mux.HandleFunc("DELETE /{name}/secret", a.handleRemoveIntegrationSecret)
+
+	return accesslog.Routes(mux)
 }
 
 func (a *API) handleCreateIntegration(
@@ -60,13 +149,18 @@ func (a *API) handleCreateIntegration(
 ) {
 	req, err := decodeRequest[Integration](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	err = a.service.CreateIntegration(req.Name, req.Display, req.Audience, req.Redirect)
+	var policy service.IntegrationPolicy
+	if req.Policy != nil {
+		policy = *policyToDomain(req.Policy)
+	}
+
+	err = a.service.CreateIntegrationWithPolicy(r.Context(), req.Name, req.Display, req.Audience, req.Redirect, req.Redirects, policy)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -79,13 +173,13 @@ func (a *API) handleGetIntegration(
 ) {
 	name := r.PathValue("name")
 	if name == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing integration name")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing integration name")
 		return
 	}
 
-	integration, err := a.service.GetIntegration(name)
+	integration, err := a.service.GetIntegration(r.Context(), name)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -98,23 +192,25 @@ func (a *API) handleUpdateIntegration(
 ) {
 	name := r.PathValue("name")
 	if name == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing integration name")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing integration name")
 		return
 	}
 
 	req, err := decodeRequest[UpdateIntegrationRequest](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	err = a.service.UpdateIntegration(name, &service.IntegrationUpdate{
-		Display:  req.Display,
-		Audience: req.Audience,
-		Redirect: req.Redirect,
+	err = a.service.UpdateIntegration(r.Context(), name, &service.IntegrationUpdate{
+		Display:   req.Display,
+		Audience:  req.Audience,
+		Redirect:  req.Redirect,
+		Redirects: req.Redirects,
+		Policy:    policyToDomain(req.Policy),
 	})
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -127,13 +223,51 @@ func (a *API) handleDeleteIntegration(
 ) {
 	name := r.PathValue("name")
 	if name == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing integration name")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing integration name")
+		return
+	}
+
+	err := a.service.DeleteIntegration(r.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, nil)
+}
+
+func (a *API) handleRotateIntegrationSecret(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	name := r.PathValue("name")
+	if name == "" {
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing integration name")
+		return
+	}
+
+	secret, err := a.service.RotateIntegrationSecret(r.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, IntegrationSecretResponse{Secret: secret})
+}
+
+func (a *API) handleRemoveIntegrationSecret(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	name := r.PathValue("name")
+	if name == "" {
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing integration name")
 		return
 	}
 
-	err := a.service.DeleteIntegration(name)
+	err := a.service.RemoveIntegrationSecret(r.Context(), name)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -144,9 +278,9 @@ func (a *API) handleListIntegrations(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	integrations, err := a.service.ListIntegrations()
+	integrations, err := a.service.ListIntegrations(r.Context())
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
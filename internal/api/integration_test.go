@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
 )
@@ -23,7 +24,7 @@ func TestAPICreateIntegration_Success(t *testing.T) {
 	result := wire.TestPost[any](env.Router, "/admin/integrations", body, jsonHeader, authHeader)
 	result.ExpectStatus(t, http.StatusOK)
 
-	integration, err := env.Service.GetIntegration("svc-a")
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -140,6 +141,80 @@ func TestAPICreateIntegration_ProtectedName(t *testing.T) {
 	result.ExpectStatusError(t, http.StatusForbidden)
 }
 
+func TestAPICreateIntegration_Policy(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+
+	body := `{
+		"name":"svc-a",
+		"display":"Service A",
+		"audience":"aud-a",
+		"redirect":"https://svc-a.test/callback",
+		"policy":{
+			"accessTokenLifetime":300,
+			"allowedScopes":["identity"],
+			"reuseRefreshTokens":true,
+			"authCodeLifetime":60
+		}
+	}`
+	result := wire.TestPost[any](env.Router, "/admin/integrations", body, jsonHeader, authHeader)
+	result.ExpectStatus(t, http.StatusOK)
+
+	// policy is returned in seconds
+	get := wire.TestGet[api.Integration](env.Router, "/admin/integrations/svc-a", authHeader)
+	integration := get.ExpectOK(t)
+	if integration.Policy == nil {
+		t.Fatal("expected policy in response")
+	}
+	if integration.Policy.AccessTokenLifetime != 300 || !integration.Policy.ReuseRefreshTokens {
+		t.Errorf("policy = %+v, want 300s with reuse", *integration.Policy)
+	}
+	if integration.Policy.AuthCodeLifetime != 60 {
+		t.Errorf("AuthCodeLifetime = %d, want 60", integration.Policy.AuthCodeLifetime)
+	}
+
+	// invalid policies are rejected
+	body = `{
+		"name":"svc-b",
+		"display":"Service B",
+		"audience":"aud-b",
+		"redirect":"https://svc-b.test/callback",
+		"policy":{"allowedScopes":["nonexistent"]}
+	}`
+	result = wire.TestPost[any](env.Router, "/admin/integrations", body, jsonHeader, authHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+}
+
+func TestAPIRotateIntegrationSecret(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+
+	// the secret is returned once and the integration reports having one
+	result := wire.TestPost[api.IntegrationSecretResponse](env.Router, "/admin/integrations/svc-a/secret", "", authHeader)
+	if response := result.ExpectOK(t); response.Secret == "" {
+		t.Fatal("expected secret in response")
+	}
+	get := wire.TestGet[api.Integration](env.Router, "/admin/integrations/svc-a", authHeader)
+	if integration := get.ExpectOK(t); !integration.HasSecret {
+		t.Error("expected hasSecret after rotation")
+	}
+
+	// removing it makes the integration public again
+	remove := wire.TestDelete[any](env.Router, "/admin/integrations/svc-a/secret", authHeader)
+	remove.ExpectStatus(t, http.StatusOK)
+	get = wire.TestGet[api.Integration](env.Router, "/admin/integrations/svc-a", authHeader)
+	if integration := get.ExpectOK(t); integration.HasSecret {
+		t.Error("expected no secret after removal")
+	}
+
+	// the internal integration is protected
+	result = wire.TestPost[api.IntegrationSecretResponse](env.Router, "/admin/integrations/consent/secret", "", authHeader)
+	result.ExpectStatusError(t, http.StatusForbidden)
+}
+
 func TestAPIGetIntegration_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
@@ -179,7 +254,7 @@ func TestAPIUpdateIntegration_Success(t *testing.T) {
 	result := wire.TestPatch[any](env.Router, "/admin/integrations/svc-a", body, jsonHeader, authHeader)
 	result.ExpectStatus(t, http.StatusOK)
 
-	integration, err := env.Service.GetIntegration("svc-a")
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -238,7 +313,7 @@ func TestAPIDeleteIntegration_Success(t *testing.T) {
 	result := wire.TestDelete[any](env.Router, "/admin/integrations/svc-a", authHeader)
 	result.ExpectStatus(t, http.StatusOK)
 
-	_, err := env.Service.GetIntegration("svc-a")
+	_, err := env.Service.GetIntegration(t.Context(), "svc-a")
 	if err == nil {
 		t.Fatal("expected error after delete")
 	}
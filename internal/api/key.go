@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"strings"
+)
+
+// Media types served by GET /key. DER is the default so the response can be
+// saved straight to the verification_key.der file that apps load.
+const (
+	keyMediaTypeDER = "application/octet-stream"
+	keyMediaTypePEM = "application/x-pem-file"
+)
+
+// IssuerHeader names the issuer domain on GET /key responses, so a client
+// bootstrapping from the key also learns which "iss" claim to expect.
+const IssuerHeader = "Consent-Issuer"
+
+// keyMaxAge is how long caches may reuse a verification key response. Keys
+// only change on a restart with new key material, and the ETag lets clients
+// revalidate cheaply after it lapses.
+const keyMaxAge = "3600"
+
+func (a *API) handleVerificationKey(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	key := a.service.VerificationKey()
+	if key == nil {
+		writeErrorCode(w, http.StatusServiceUnavailable, CodeKeyUnavailable, "No verification key configured")
+		return
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		writeErrorCode(w, http.StatusInternalServerError, CodeInternal, "Failed to encode verification key")
+		return
+	}
+
+	body, mediaType := der, keyMediaTypeDER
+	if acceptsPEM(r.Header.Get("Accept")) {
+		body = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		mediaType = keyMediaTypePEM
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "public, max-age="+keyMaxAge)
+	header.Add("Vary", "Accept")
+	header.Set(IssuerHeader, a.service.IssuerDomain())
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// acceptsPEM reports whether an Accept header asks for PEM. Anything else,
+// including no preference, gets DER.
+func acceptsPEM(
+	accept string,
+) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), keyMediaTypePEM) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header names etag, comparing
+// weakly as RFC 9110 requires for If-None-Match.
+func etagMatches(
+	ifNoneMatch string,
+	etag string,
+) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
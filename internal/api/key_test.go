@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func getKey(
+	t *testing.T,
+	router http.Handler,
+	headers map[string]string,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/key", nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIKey_ServesDERByDefault(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	rec := getKey(t, env.Router, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/octet-stream" {
+		t.Fatalf("Content-Type = %q, want application/octet-stream", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Fatalf("Cache-Control = %q, want public, max-age=3600", got)
+	}
+	if got := rec.Header().Get(api.IssuerHeader); got != "test.consent.local" {
+		t.Fatalf("%s = %q, want test.consent.local", api.IssuerHeader, got)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("body is not a PKIX key: %v", err)
+	}
+	if !env.Service.VerificationKey().Equal(key) {
+		t.Fatal("served key does not match the service's verification key")
+	}
+}
+
+func TestAPIKey_ServesPEMWhenAccepted(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	rec := getKey(t, env.Router, map[string]string{"Accept": "application/x-pem-file"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-pem-file" {
+		t.Fatalf("Content-Type = %q, want application/x-pem-file", got)
+	}
+	block, _ := pem.Decode(rec.Body.Bytes())
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("body = %q, want a PUBLIC KEY PEM block", rec.Body.String())
+	}
+
+	der := getKey(t, env.Router, nil)
+	if rec.Header().Get("ETag") == der.Header().Get("ETag") {
+		t.Fatal("PEM and DER responses share an ETag")
+	}
+}
+
+func TestAPIKey_NotModifiedForMatchingETag(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	etag := getKey(t, env.Router, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("response has no ETag")
+	}
+
+	rec := getKey(t, env.Router, map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("304 body = %q, want empty", rec.Body.String())
+	}
+
+	rec = getKey(t, env.Router, map[string]string{"If-None-Match": `"stale"`})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stale ETag status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
@@ -45,7 +46,7 @@ func (a *API) buildRolesRouter() http.Handler {
 	mux.HandleFunc("PUT    /{name}", a.handleUpdateRole)
 	mux.HandleFunc("DELETE /{name}", a.handleDeleteRole)
 
-	return mux
+	return accesslog.Routes(mux)
 }
 
 func (a *API) handleCreateRole(
@@ -54,13 +55,13 @@ func (a *API) handleCreateRole(
 ) {
 	req, err := decodeRequest[Role](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	role, err := a.service.CreateRole(req.Name, req.Display)
+	role, err := a.service.CreateRole(r.Context(), req.Name, req.Display)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -73,13 +74,13 @@ func (a *API) handleGetRole(
 ) {
 	name := r.PathValue("name")
 	if name == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing role name")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing role name")
 		return
 	}
 
-	role, err := a.service.GetRole(name)
+	role, err := a.service.GetRole(r.Context(), name)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -92,19 +93,19 @@ func (a *API) handleUpdateRole(
 ) {
 	name := r.PathValue("name")
 	if name == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing role name")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing role name")
 		return
 	}
 
 	req, err := decodeRequest[UpdateRoleRequest](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	role, err := a.service.UpdateRole(name, req.Display)
+	role, err := a.service.UpdateRole(r.Context(), name, req.Display)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -117,13 +118,13 @@ func (a *API) handleDeleteRole(
 ) {
 	name := r.PathValue("name")
 	if name == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing role name")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing role name")
 		return
 	}
 
-	err := a.service.DeleteRole(name)
+	err := a.service.DeleteRole(r.Context(), name)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -134,9 +135,9 @@ func (a *API) handleListRoles(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	roles, err := a.service.ListRoles()
+	roles, err := a.service.ListRoles(r.Context())
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
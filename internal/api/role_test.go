@@ -196,7 +196,7 @@ func TestAPIDeleteRole_Success(t *testing.T) {
 	result := wire.TestDelete[any](env.Router, "/admin/roles/temp", authHeader)
 	result.ExpectStatus(t, http.StatusOK)
 
-	_, err := env.Service.GetRole("temp")
+	_, err := env.Service.GetRole(t.Context(), "temp")
 	if err == nil {
 		t.Fatal("expected error after delete")
 	}
@@ -229,7 +229,7 @@ func TestAPIDeleteRole_InUse(t *testing.T) {
 	env.RegisterTestUser(t, "alice", "password")
 
 	// Create a user with this role
-	_, err := env.Service.CreateUser("bob", "password2", []string{"editor"})
+	_, err := env.Service.CreateUser(t.Context(), "bob", "password2", []string{"editor"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -239,7 +239,7 @@ func TestAPIDeleteRole_InUse(t *testing.T) {
 	result.ExpectStatus(t, http.StatusOK)
 
 	// Verify role is gone
-	_, err = env.Service.GetRole("editor")
+	_, err = env.Service.GetRole(t.Context(), "editor")
 	if !errors.Is(err, service.ErrRoleNotFound) {
 		t.Fatalf("expected role to be deleted, got: %v", err)
 	}
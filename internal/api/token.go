@@ -0,0 +1,275 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// TokenTypeAccessToken is the RFC 8693 token type of consent access tokens,
+// the only type token exchange accepts and issues.
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// TokenResponse is the RFC 6749 token endpoint success response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+
+	// IssuedTokenType is set on token exchange responses (RFC 8693).
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// TokenError is the RFC 6749 token endpoint error response.
+type TokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// handleToken is a spec-shaped OAuth token endpoint. Unlike the bespoke JSON
+// routes, it takes form-encoded requests and answers with bare RFC 6749 JSON.
+func (a *API) handleToken(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "content type must be application/x-www-form-urlencoded")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	client, ok := clientCredentialsFromRequest(r)
+	if !ok {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "use only one client authentication method")
+		return
+	}
+
+	var accessToken, refreshToken string
+	var err error
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case GrantTypeAuthorizationCode:
+		code := r.PostForm.Get("code")
+		if code == "" {
+			writeTokenError(w, http.StatusBadRequest, "invalid_request", "code is required")
+			return
+		}
+		accessToken, refreshToken, err = a.service.ExchangeAuthorizationCode(r.Context(), code, client)
+
+	case GrantTypeRefreshToken:
+		token := r.PostForm.Get("refresh_token")
+		if token == "" {
+			writeTokenError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+			return
+		}
+		accessToken, refreshToken, err = a.service.RefreshAccessToken(r.Context(), token, client)
+
+	case GrantTypeDeviceCode:
+		deviceCode := r.PostForm.Get("device_code")
+		if deviceCode == "" {
+			writeTokenError(w, http.StatusBadRequest, "invalid_request", "device_code is required")
+			return
+		}
+		accessToken, refreshToken, err = a.service.PollDeviceAuthorization(r.Context(), deviceCode, client)
+
+	case GrantTypeTokenExchange:
+		a.handleTokenExchange(w, r, client)
+		return
+
+	case GrantTypeClientCredentials:
+		// tokens are only ever issued on behalf of a user
+		writeTokenError(w, http.StatusBadRequest, "unauthorized_client", "client credentials are not enabled for any integration")
+		return
+
+	case "":
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "grant_type is required")
+		return
+
+	default:
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", grantType)
+		return
+	}
+	if err != nil {
+		status, code := tokenErrorFromError(err)
+		if code == "invalid_client" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="consent"`)
+		}
+		writeTokenError(w, status, code, err.Error())
+		return
+	}
+
+	idToken, err := a.service.IssueIDToken(r.Context(), accessToken)
+	if err != nil {
+		status, code := tokenErrorFromError(err)
+		writeTokenError(w, status, code, err.Error())
+		return
+	}
+
+	expiresIn, err := a.service.AccessTokenExpiresIn(accessToken)
+	if err != nil {
+		status, code := tokenErrorFromError(err)
+		writeTokenError(w, status, code, err.Error())
+		return
+	}
+
+	writeTokenJSON(w, http.StatusOK, TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(expiresIn / time.Second),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+}
+
+// handleTokenExchange answers an RFC 8693 token exchange: the client trades
+// a user's access token for one for another integration's audience.
+func (a *API) handleTokenExchange(
+	w http.ResponseWriter,
+	r *http.Request,
+	client service.ClientCredentials,
+) {
+	subjectToken := r.PostForm.Get("subject_token")
+	audience := r.PostForm.Get("audience")
+	if subjectToken == "" || audience == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "subject_token and audience are required")
+		return
+	}
+	if r.PostForm.Get("subject_token_type") != TokenTypeAccessToken {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "subject_token_type must be "+TokenTypeAccessToken)
+		return
+	}
+	if requested := r.PostForm.Get("requested_token_type"); requested != "" && requested != TokenTypeAccessToken {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "only access tokens can be requested")
+		return
+	}
+
+	accessToken, err := a.service.ExchangeAccessToken(r.Context(), subjectToken, audience, client)
+	if err != nil {
+		status, code := tokenErrorFromError(err)
+		if code == "invalid_client" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="consent"`)
+		}
+		writeTokenError(w, status, code, err.Error())
+		return
+	}
+
+	expiresIn, err := a.service.AccessTokenExpiresIn(accessToken)
+	if err != nil {
+		status, code := tokenErrorFromError(err)
+		writeTokenError(w, status, code, err.Error())
+		return
+	}
+
+	writeTokenJSON(w, http.StatusOK, TokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(expiresIn / time.Second),
+		IssuedTokenType: TokenTypeAccessToken,
+	})
+}
+
+func tokenErrorFromError(err error) (int, string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTarget):
+		return http.StatusBadRequest, "invalid_target"
+	case errors.Is(err, service.ErrInsufficientScope):
+		return http.StatusBadRequest, "invalid_scope"
+	case errors.Is(err, service.ErrAuthorizationPending):
+		return http.StatusBadRequest, "authorization_pending"
+	case errors.Is(err, service.ErrSlowDown):
+		return http.StatusBadRequest, "slow_down"
+	case errors.Is(err, service.ErrDeviceCodeExpired):
+		return http.StatusBadRequest, "expired_token"
+	case errors.Is(err, service.ErrAuthorizationDenied):
+		return http.StatusBadRequest, "access_denied"
+	case errors.Is(err, service.ErrIntegrationNotFound),
+		errors.Is(err, service.ErrInvalidClient):
+		return http.StatusUnauthorized, "invalid_client"
+	case errors.Is(err, service.ErrTokenInvalid),
+		errors.Is(err, service.ErrInvalidAuthCode),
+		errors.Is(err, service.ErrAuthCodeExpired),
+		errors.Is(err, service.ErrTokenNotFound),
+		errors.Is(err, service.ErrReauthenticationRequired),
+		errors.Is(err, service.ErrDeviceCodeNotFound),
+		errors.Is(err, service.ErrAccountNotFound),
+		errors.Is(err, service.ErrAccountDisabled),
+		errors.Is(err, service.ErrAccountExpired),
+		errors.Is(err, service.ErrTermsNotAccepted),
+		errors.Is(err, service.ErrInvalidIntegration):
+		return http.StatusBadRequest, "invalid_grant"
+	default:
+		return http.StatusInternalServerError, "server_error"
+	}
+}
+
+// clientCredentialsFromRequest reads client credentials from HTTP Basic auth
+// or, failing that, the client_id and client_secret form fields. It reports
+// false when a request mixes both methods.
+func clientCredentialsFromRequest(
+	r *http.Request,
+) (
+	service.ClientCredentials,
+	bool,
+) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return service.ClientCredentials{
+			ID:     r.PostForm.Get("client_id"),
+			Secret: r.PostForm.Get("client_secret"),
+		}, true
+	}
+	if r.PostForm.Get("client_secret") != "" {
+		return service.ClientCredentials{}, false
+	}
+
+	// RFC 6749 form-encodes credentials before base64 encoding them
+	if unescaped, err := url.QueryUnescape(id); err == nil {
+		id = unescaped
+	}
+	if unescaped, err := url.QueryUnescape(secret); err == nil {
+		secret = unescaped
+	}
+	return service.ClientCredentials{ID: id, Secret: secret}, true
+}
+
+func writeTokenError(
+	w http.ResponseWriter,
+	status int,
+	code string,
+	description string,
+) {
+	writeTokenJSON(w, status, TokenError{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+func writeTokenJSON(
+	w http.ResponseWriter,
+	status int,
+	body any,
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
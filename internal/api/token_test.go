@@ -0,0 +1,375 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func postToken(
+	t *testing.T,
+	router http.Handler,
+	form url.Values,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func expectTokenResponse(
+	t *testing.T,
+	res *httptest.ResponseRecorder,
+) api.TokenResponse {
+	t.Helper()
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+	if res.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Cache-Control = %q, want no-store", res.Header().Get("Cache-Control"))
+	}
+	var response api.TokenResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" {
+		t.Fatalf("response = %#v, want tokens", response)
+	}
+	if response.TokenType != "Bearer" || response.ExpiresIn <= 0 {
+		t.Fatalf("response = %#v, want Bearer with expiry", response)
+	}
+	return response
+}
+
+func expectTokenError(
+	t *testing.T,
+	res *httptest.ResponseRecorder,
+	status int,
+	code string,
+) {
+	t.Helper()
+	if res.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, res.Code, res.Body.String())
+	}
+	var response api.TokenError
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Error != code {
+		t.Fatalf("error = %q, want %q", response.Error, code)
+	}
+}
+
+func TestAPIToken_AuthorizationCode(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	res := postToken(t, env.Router, url.Values{
+		"grant_type": []string{"authorization_code"},
+		"code":       []string{code},
+		"client_id":  []string{"test-integration"},
+	})
+	expectTokenResponse(t, res)
+
+	// codes are single-use
+	res = postToken(t, env.Router, url.Values{
+		"grant_type": []string{"authorization_code"},
+		"code":       []string{code},
+	})
+	expectTokenError(t, res, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestAPIToken_ConfidentialClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "test-integration")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	// unauthenticated exchange is rejected with a Basic challenge
+	res := postToken(t, env.Router, url.Values{
+		"grant_type": []string{"authorization_code"},
+		"code":       []string{code},
+		"client_id":  []string{"test-integration"},
+	})
+	expectTokenError(t, res, http.StatusUnauthorized, "invalid_client")
+	if res.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected WWW-Authenticate header")
+	}
+
+	// client_secret_post authenticates; the failed attempt consumed the
+	// first code
+	code = env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+	res = postToken(t, env.Router, url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"client_id":     []string{"test-integration"},
+		"client_secret": []string{secret},
+	})
+	response := expectTokenResponse(t, res)
+
+	// so does HTTP Basic auth
+	form := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{response.RefreshToken},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("test-integration", secret)
+	rec := httptest.NewRecorder()
+	env.Router.ServeHTTP(rec, req)
+	expectTokenResponse(t, rec)
+}
+
+func TestAPIToken_MixedClientAuthentication(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	form := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{"token"},
+		"client_secret": []string{"secret"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("test-integration", "secret")
+	rec := httptest.NewRecorder()
+	env.Router.ServeHTTP(rec, req)
+	expectTokenError(t, rec, http.StatusBadRequest, "invalid_request")
+}
+
+func TestAPIToken_AuthorizationCodeWrongClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "other", "Other", "other-audience", "http://localhost:9090/callback")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	res := postToken(t, env.Router, url.Values{
+		"grant_type": []string{"authorization_code"},
+		"code":       []string{code},
+		"client_id":  []string{"other"},
+	})
+	expectTokenError(t, res, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestAPIToken_RefreshToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	refreshToken := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	res := postToken(t, env.Router, url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{refreshToken.Encoded()},
+	})
+	response := expectTokenResponse(t, res)
+
+	// the rotated refresh token works
+	res = postToken(t, env.Router, url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{response.RefreshToken},
+	})
+	expectTokenResponse(t, res)
+}
+
+func TestAPIToken_IntegrationPolicyLifetime(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	err := env.Service.UpdateIntegration(t.Context(), "test-integration", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{AccessTokenLifetime: 5 * time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	refreshToken := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	// expires_in reports the integration's access token lifetime
+	res := postToken(t, env.Router, url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{refreshToken.Encoded()},
+	})
+	response := expectTokenResponse(t, res)
+	if response.ExpiresIn != 300 {
+		t.Errorf("ExpiresIn = %d, want 300", response.ExpiresIn)
+	}
+}
+
+func TestAPIToken_IDToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+
+	res := postToken(t, env.Router, url.Values{
+		"grant_type": []string{"authorization_code"},
+		"code":       []string{code},
+		"client_id":  []string{"test-integration"},
+	})
+	response := expectTokenResponse(t, res)
+	if response.IDToken == "" {
+		t.Fatal("expected id_token for identity scope")
+	}
+	if !strings.Contains(res.Body.String(), `"id_token"`) {
+		t.Errorf("expected id_token key in body: %s", res.Body.String())
+	}
+}
+
+func TestAPIToken_DeviceCodePending(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+
+	res := postToken(t, env.Router, url.Values{
+		"grant_type":  []string{"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": []string{grant.DeviceCode},
+	})
+	expectTokenError(t, res, http.StatusBadRequest, "authorization_pending")
+}
+
+func TestAPIToken_DeviceCodeConfidentialClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "test-integration")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+	if err := env.Service.ApproveDeviceAuthorization(t.Context(), alice.Subject, time.Now(), grant.UserCode); err != nil {
+		t.Fatalf("ApproveDeviceAuthorization failed: %v", err)
+	}
+
+	// holding the device code alone is not enough
+	res := postToken(t, env.Router, url.Values{
+		"grant_type":  []string{"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": []string{grant.DeviceCode},
+		"client_id":   []string{"test-integration"},
+	})
+	expectTokenError(t, res, http.StatusUnauthorized, "invalid_client")
+
+	// and the rejected poll didn't use up the code
+	res = postToken(t, env.Router, url.Values{
+		"grant_type":    []string{"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code":   []string{grant.DeviceCode},
+		"client_id":     []string{"test-integration"},
+		"client_secret": []string{secret},
+	})
+	if response := expectTokenResponse(t, res); response.AccessToken == "" {
+		t.Fatal("expected access token")
+	}
+}
+
+func TestAPIToken_RequestErrors(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	cases := []struct {
+		name string
+		form url.Values
+		code string
+	}{
+		{"missing grant type", url.Values{}, "invalid_request"},
+		{"unknown grant type", url.Values{"grant_type": []string{"password"}}, "unsupported_grant_type"},
+		{"missing code", url.Values{"grant_type": []string{"authorization_code"}}, "invalid_request"},
+		{"client credentials", url.Values{"grant_type": []string{"client_credentials"}}, "unauthorized_client"},
+	}
+	for _, tc := range cases {
+		res := postToken(t, env.Router, tc.form)
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", tc.name, res.Code)
+		}
+		expectTokenError(t, res, http.StatusBadRequest, tc.code)
+	}
+}
+
+func TestAPIToken_RequiresFormEncoding(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"grant_type": "refresh_token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	env.Router.ServeHTTP(res, req)
+
+	expectTokenError(t, res, http.StatusBadRequest, "invalid_request")
+}
+
+func TestAPIToken_TokenExchange(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-b", "Service B", "aud-b", "https://svc-b.test/callback")
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "test-integration")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	err = env.Service.UpdateIntegration(t.Context(), "test-integration", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{ExchangeAudiences: []string{"aud-b"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+	original := expectTokenResponse(t, postToken(t, env.Router, url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"client_id":     []string{"test-integration"},
+		"client_secret": []string{secret},
+	}))
+
+	exchange := func(audience string) *httptest.ResponseRecorder {
+		return postToken(t, env.Router, url.Values{
+			"grant_type":         []string{api.GrantTypeTokenExchange},
+			"subject_token":      []string{original.AccessToken},
+			"subject_token_type": []string{api.TokenTypeAccessToken},
+			"audience":           []string{audience},
+			"client_id":          []string{"test-integration"},
+			"client_secret":      []string{secret},
+		})
+	}
+
+	res := exchange("aud-b")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+	var response api.TokenResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.AccessToken == "" || response.RefreshToken != "" {
+		t.Fatalf("response = %#v, want access token only", response)
+	}
+	if response.IssuedTokenType != api.TokenTypeAccessToken {
+		t.Errorf("issued_token_type = %q, want %q", response.IssuedTokenType, api.TokenTypeAccessToken)
+	}
+
+	// audiences outside the policy are rejected
+	expectTokenError(t, exchange("aud-c"), http.StatusBadRequest, "invalid_target")
+}
@@ -1,35 +1,102 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
+// User is an account. Disabled is left out for enabled accounts, so exports
+// from before accounts could be disabled import as enabled, and ExpiresAt
+// for accounts that never expire. TermsVersion is the version of the terms
+// of service the user last accepted, at TermsAcceptedAt.
 type User struct {
-	Subject string   `json:"subject"`
-	Handle  string   `json:"username"`
-	Roles   []string `json:"roles"`
+	Subject         string     `json:"subject"`
+	Handle          string     `json:"username"`
+	Roles           []string   `json:"roles"`
+	Disabled        bool       `json:"disabled,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	TermsVersion    string     `json:"termsVersion,omitempty"`
+	TermsAcceptedAt *time.Time `json:"termsAcceptedAt,omitempty"`
 }
 
 type CreateUserRequest struct {
-	Handle   string   `json:"username"`
-	Password string   `json:"password"`
-	Roles    []string `json:"roles"`
+	Handle    string     `json:"username"`
+	Password  string     `json:"password"`
+	Roles     []string   `json:"roles"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
+// UpdateUserRequest changes the fields that are set. ExpiresAt is an RFC
+// 3339 time, or empty to remove the account's expiry.
 type UpdateUserRequest struct {
-	Handle *string   `json:"username,omitempty"`
-	Roles  *[]string `json:"roles,omitempty"`
+	Handle    *string   `json:"username,omitempty"`
+	Roles     *[]string `json:"roles,omitempty"`
+	Disabled  *bool     `json:"disabled,omitempty"`
+	ExpiresAt *string   `json:"expiresAt,omitempty"`
+}
+
+// ImpersonateRequest asks for an access token for a user at Integration.
+// ActorToken is an access token from the impersonating administrator's own
+// sign-in to consent; the token is issued to, and audited as, its subject.
+// Reason is recorded in the audit log.
+type ImpersonateRequest struct {
+	ActorToken  string   `json:"actorToken"`
+	Integration string   `json:"integration"`
+	Scopes      []string `json:"scopes"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+type ImpersonateResponse struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresIn   int    `json:"expiresIn"`
 }
 
 func userFromDomain(user service.User) User {
-	return User{
-		Subject: user.Subject,
-		Handle:  user.Handle,
-		Roles:   append([]string(nil), user.Roles...),
+	apiUser := User{
+		Subject:      user.Subject,
+		Handle:       user.Handle,
+		Roles:        append([]string(nil), user.Roles...),
+		Disabled:     user.Disabled,
+		TermsVersion: user.TermsVersion,
+	}
+	if !user.ExpiresAt.IsZero() {
+		expiresAt := user.ExpiresAt.UTC()
+		apiUser.ExpiresAt = &expiresAt
 	}
+	if !user.TermsAcceptedAt.IsZero() {
+		acceptedAt := user.TermsAcceptedAt.UTC()
+		apiUser.TermsAcceptedAt = &acceptedAt
+	}
+	return apiUser
+}
+
+// toDomain converts the request's fields into a service update.
+func (req UpdateUserRequest) toDomain() (
+	*service.UserUpdate,
+	error,
+) {
+	update := &service.UserUpdate{
+		Handle:   req.Handle,
+		Roles:    req.Roles,
+		Disabled: req.Disabled,
+	}
+	if req.ExpiresAt != nil {
+		var expiresAt time.Time
+		if *req.ExpiresAt != "" {
+			parsed, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				return nil, fmt.Errorf("%w: expiresAt must be an RFC 3339 time", service.ErrInvalidUpdate)
+			}
+			expiresAt = parsed
+		}
+		update.ExpiresAt = &expiresAt
+	}
+	return update, nil
 }
 
 func usersFromDomain(users []service.User) []User {
@@ -50,7 +117,9 @@ func (a *API) buildUsersRouter() http.Handler {
 	mux.HandleFunc("PATCH  /{subject}", a.handleUpdateUser)
 	mux.HandleFunc("DELETE /{subject}", a.handleDeleteUser)
 
-	return mux
+	mux.HandleFunc("POST   /{subject}/impersonate", a.handleImpersonateUser)
+
+	return accesslog.Routes(mux)
 }
 
 func (a *API) handleCreateUser(
@@ -59,13 +128,17 @@ func (a *API) handleCreateUser(
 ) {
 	req, err := decodeRequest[CreateUserRequest](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	user, err := a.service.CreateUser(req.Handle, req.Password, req.Roles)
+	options := service.UserOptions{}
+	if req.ExpiresAt != nil {
+		options.ExpiresAt = *req.ExpiresAt
+	}
+	user, err := a.service.CreateUserWithOptions(r.Context(), req.Handle, req.Password, req.Roles, options)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -78,13 +151,13 @@ func (a *API) handleGetUser(
 ) {
 	subject := r.PathValue("subject")
 	if subject == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing user subject")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing user subject")
 		return
 	}
 
-	user, err := a.service.GetUser(subject)
+	user, err := a.service.GetUser(r.Context(), subject)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -95,9 +168,9 @@ func (a *API) handleListUsers(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	users, err := a.service.ListUsers()
+	users, err := a.service.ListUsers(r.Context())
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -110,19 +183,24 @@ func (a *API) handleUpdateUser(
 ) {
 	subject := r.PathValue("subject")
 	if subject == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing user subject")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing user subject")
 		return
 	}
 
 	req, err := decodeRequest[UpdateUserRequest](r)
 	if err != nil {
-		wire.WriteError(w, http.StatusBadRequest, "Malformed JSON")
+		writeDecodeError(w, err)
 		return
 	}
 
-	user, err := a.service.UpdateUser(subject, &service.UserUpdate{Handle: req.Handle, Roles: req.Roles})
+	update, err := req.toDomain()
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
+		return
+	}
+	user, err := a.service.UpdateUser(r.Context(), subject, update)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -135,15 +213,48 @@ func (a *API) handleDeleteUser(
 ) {
 	subject := r.PathValue("subject")
 	if subject == "" {
-		wire.WriteError(w, http.StatusBadRequest, "Missing user subject")
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing user subject")
 		return
 	}
 
-	err := a.service.DeleteUser(subject)
+	err := a.service.DeleteUser(r.Context(), subject)
 	if err != nil {
-		wire.WriteError(w, httpStatusFromError(err), err.Error())
+		writeError(w, err)
 		return
 	}
 
 	wire.WriteData(w, http.StatusOK, nil)
 }
+
+func (a *API) handleImpersonateUser(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	subject := r.PathValue("subject")
+	if subject == "" {
+		writeErrorCode(w, http.StatusBadRequest, CodeMissingParameter, "Missing user subject")
+		return
+	}
+
+	req, err := decodeRequest[ImpersonateRequest](r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	accessToken, err := a.service.Impersonate(r.Context(), req.ActorToken, subject, req.Integration, req.Scopes, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	expiresIn, err := a.service.AccessTokenExpiresIn(accessToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wire.WriteData(w, http.StatusOK, ImpersonateResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   int(expiresIn.Seconds()),
+	})
+}
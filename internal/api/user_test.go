@@ -4,9 +4,11 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
 	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
 )
 
@@ -120,7 +122,7 @@ func TestAPIGetUser_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
 	authHeader := env.APIKeyHeader(t)
-	user, err := env.Service.CreateUser("alice", "password", []string{"admin"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", []string{"admin"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -151,10 +153,10 @@ func TestAPIListUsers(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
 	authHeader := env.APIKeyHeader(t)
-	if _, err := env.Service.CreateUser("alice", "password", []string{"admin"}); err != nil {
+	if _, err := env.Service.CreateUser(t.Context(), "alice", "password", []string{"admin"}); err != nil {
 		t.Fatalf("CreateUser alice failed: %v", err)
 	}
-	if _, err := env.Service.CreateUser("bob", "password", nil); err != nil {
+	if _, err := env.Service.CreateUser(t.Context(), "bob", "password", nil); err != nil {
 		t.Fatalf("CreateUser bob failed: %v", err)
 	}
 
@@ -172,7 +174,7 @@ func TestAPIUpdateUser_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
 	authHeader := env.APIKeyHeader(t)
-	user, err := env.Service.CreateUser("alice", "password", []string{"admin"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", []string{"admin"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -190,7 +192,7 @@ func TestAPIUpdateUser_Success(t *testing.T) {
 	result := wire.TestPatch[any](env.Router, "/admin/users/"+user.Subject, body, jsonHeader, authHeader)
 	result.ExpectStatus(t, http.StatusOK)
 
-	updated, err := env.Service.GetUser(user.Subject)
+	updated, err := env.Service.GetUser(t.Context(), user.Subject)
 	if err != nil {
 		t.Fatalf("GetUser failed: %v", err)
 	}
@@ -209,6 +211,69 @@ func TestAPIUpdateUser_Success(t *testing.T) {
 	}
 }
 
+func TestAPIUpdateUser_Disable(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	result := wire.TestPatch[api.User](env.Router, "/admin/users/"+user.Subject, `{"disabled":true}`, jsonHeader, authHeader)
+	if updated := result.ExpectStatusOK(t, http.StatusOK); !updated.Disabled {
+		t.Fatal("response does not report the user disabled")
+	}
+
+	// the right password no longer signs alice in
+	loginBody := `{
+		"handle": "alice",
+		"secret": "password",
+		"integration": "consent"
+	}`
+	loginResult := wire.TestPost[any](env.Router, "/auth/login", loginBody, jsonHeader)
+	loginResult.ExpectStatus(t, http.StatusForbidden)
+	if !strings.Contains(string(loginResult.Raw), "account_disabled") {
+		t.Errorf("login error = %s, want account_disabled", loginResult.Raw)
+	}
+}
+
+func TestAPIUpdateUser_Expiry(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	result := wire.TestPatch[api.User](env.Router, "/admin/users/"+user.Subject, `{"expiresAt":"2001-02-03T04:05:06Z"}`, jsonHeader, authHeader)
+	updated := result.ExpectStatusOK(t, http.StatusOK)
+	if updated.ExpiresAt == nil || updated.ExpiresAt.Format(time.RFC3339) != "2001-02-03T04:05:06Z" {
+		t.Fatalf("ExpiresAt = %v, want 2001-02-03T04:05:06Z", updated.ExpiresAt)
+	}
+
+	loginBody := `{
+		"handle": "alice",
+		"secret": "password",
+		"integration": "consent"
+	}`
+	loginResult := wire.TestPost[any](env.Router, "/auth/login", loginBody, jsonHeader)
+	loginResult.ExpectStatus(t, http.StatusForbidden)
+	if !strings.Contains(string(loginResult.Raw), "account_expired") {
+		t.Errorf("login error = %s, want account_expired", loginResult.Raw)
+	}
+
+	// an empty time removes the expiry
+	result = wire.TestPatch[api.User](env.Router, "/admin/users/"+user.Subject, `{"expiresAt":""}`, jsonHeader, authHeader)
+	if updated := result.ExpectStatusOK(t, http.StatusOK); updated.ExpiresAt != nil {
+		t.Fatalf("ExpiresAt = %v, want none", updated.ExpiresAt)
+	}
+
+	invalid := wire.TestPatch[any](env.Router, "/admin/users/"+user.Subject, `{"expiresAt":"tomorrow"}`, jsonHeader, authHeader)
+	invalid.ExpectStatus(t, http.StatusBadRequest)
+}
+
 func TestAPIUpdateUser_NotFound(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
@@ -225,7 +290,7 @@ func TestAPIUpdateUser_InvalidRoles(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
 	authHeader := env.APIKeyHeader(t)
-	user, err := env.Service.CreateUser("alice", "password", []string{"admin"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", []string{"admin"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -244,7 +309,7 @@ func TestAPIDeleteUser_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnvWithRouter(t)
 	authHeader := env.APIKeyHeader(t)
-	user, err := env.Service.CreateUser("alice", "password", nil)
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", nil)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -252,7 +317,7 @@ func TestAPIDeleteUser_Success(t *testing.T) {
 	result := wire.TestDelete[any](env.Router, "/admin/users/"+user.Subject, authHeader)
 	result.ExpectStatus(t, http.StatusOK)
 
-	_, err = env.Service.GetUser(user.Subject)
+	_, err = env.Service.GetUser(t.Context(), user.Subject)
 	if err == nil {
 		t.Fatal("expected error after delete")
 	}
@@ -266,3 +331,54 @@ func TestAPIDeleteUser_NotFound(t *testing.T) {
 	result := wire.TestDelete[any](env.Router, "/admin/users/missing", authHeader)
 	result.ExpectStatusError(t, http.StatusBadRequest)
 }
+
+func TestAPIImpersonateUser(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithRouter(t)
+	authHeader := env.APIKeyHeader(t)
+	admin, err := env.Service.CreateUser(t.Context(), "admin", "password", []string{"admin"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	alice, err := env.Service.CreateUser(t.Context(), "alice", "password", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	internal, err := env.Service.GetIntegration(t.Context(), service.InternalIntegrationName)
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	adminToken := env.IssueTestAccessToken(t, admin.Subject, []string{internal.Audience}).Encoded()
+	aliceToken := env.IssueTestAccessToken(t, alice.Subject, []string{internal.Audience}).Encoded()
+
+	body := `{
+		"actorToken": "` + adminToken + `",
+		"integration": "test-integration",
+		"scopes": ["identity"],
+		"reason": "support ticket"
+	}`
+	result := wire.TestPost[api.ImpersonateResponse](env.Router, "/admin/users/"+alice.Subject+"/impersonate", body, jsonHeader, authHeader)
+	response := result.ExpectOK(t)
+	if response.AccessToken == "" || response.ExpiresIn <= 0 {
+		t.Fatalf("response = %+v, want token and expiry", response)
+	}
+
+	// only admins may act as someone else
+	body = `{
+		"actorToken": "` + aliceToken + `",
+		"integration": "test-integration",
+		"scopes": ["identity"]
+	}`
+	result = wire.TestPost[api.ImpersonateResponse](env.Router, "/admin/users/"+admin.Subject+"/impersonate", body, jsonHeader, authHeader)
+	result.ExpectStatusError(t, http.StatusForbidden)
+
+	// and the API key alone can't name an admin as the actor
+	body = `{
+		"actorToken": "` + admin.Subject + `",
+		"integration": "test-integration",
+		"scopes": ["identity"]
+	}`
+	result = wire.TestPost[api.ImpersonateResponse](env.Router, "/admin/users/"+alice.Subject+"/impersonate", body, jsonHeader, authHeader)
+	result.ExpectStatusError(t, http.StatusBadRequest)
+}
@@ -6,6 +6,9 @@ import (
 	"log"
 	"net/http"
 
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/internal/i18n"
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/pkg/client"
 )
@@ -19,14 +22,35 @@ type AuthConfig struct {
 }
 
 type Options struct {
-	Service *service.Service
-	Auth    AuthConfig
+	Service         *service.Service
+	Auth            AuthConfig
+	InsecureCookies bool
+
+	// TemplatesPath is an optional directory of templates that override the
+	// embedded ones by file name; edits are picked up without a restart.
+	TemplatesPath string
+
+	// StaticTemplates reads TemplatesPath only at startup and on Reload.
+	StaticTemplates bool
+
+	// Branding names the deployment on every page.
+	Branding Branding
+
+	// Locale is the page language used when a browser's Accept-Language
+	// names none of the supported locales. Empty means English.
+	Locale string
+
+	// LocalesPath is an optional directory of <locale>.json message
+	// catalogs that add languages or replace built-in translations.
+	LocalesPath string
 }
 
 type App struct {
-	service   *service.Service
-	auth      AuthConfig
-	templates *Templates
+	service         *service.Service
+	auth            AuthConfig
+	templates       *Templates
+	locales         i18n.Options
+	insecureCookies bool
 }
 
 func New(
@@ -62,7 +86,21 @@ func New(
 		routes[pattern] = handler
 	}
 
-	templates, err := NewTemplates()
+	locales := i18n.Options{
+		DefaultLocale: options.Locale,
+		Dir:           options.LocalesPath,
+	}
+	catalog, err := i18n.New(locales)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load locales: %w", err)
+	}
+
+	templates, err := NewTemplates(TemplateOptions{
+		OverridesDir: options.TemplatesPath,
+		Static:       options.StaticTemplates,
+		Branding:     options.Branding,
+		Catalog:      catalog,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load templates: %w", err)
 	}
@@ -75,28 +113,54 @@ func New(
 			LogoutURL: options.Auth.LogoutURL,
 			Routes:    routes,
 		},
-		templates: templates,
+		templates:       templates,
+		locales:         locales,
+		insecureCookies: options.InsecureCookies,
 	}, nil
 }
 
+// Reload re-reads the template overrides and locale catalogs from disk.
+func (a *App) Reload() error {
+	catalog, err := i18n.New(a.locales)
+	if err != nil {
+		return fmt.Errorf("failed to reload locales: %w", err)
+	}
+	if err := a.templates.Reload(catalog); err != nil {
+		return fmt.Errorf("failed to reload templates: %w", err)
+	}
+	return nil
+}
+
 func (a *App) Router() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", a.serve(a.handleGetHome))
 	mux.HandleFunc("GET /login", a.serve(a.handleGetLogin))
 	mux.HandleFunc("POST /login", a.serve(a.handlePostLogin))
+	mux.HandleFunc("GET /login/with/{provider}", a.serve(a.handleGetUpstreamLogin))
+	mux.HandleFunc("GET /login/with/{provider}/callback", a.serve(a.handleGetUpstreamCallback))
+	mux.HandleFunc("GET /link/with/{provider}", a.serve(a.handleGetUpstreamLink))
 	mux.HandleFunc("GET /authorize", a.serve(a.handleGetAuthorize))
 	mux.HandleFunc("POST /authorize", a.serve(a.handlePostAuthorize))
+	mux.HandleFunc("GET /forward-auth/callback", a.serve(a.handleGetForwardAuthCallback))
+	mux.HandleFunc("GET /device", a.serve(a.handleGetDevice))
+	mux.HandleFunc("POST /device", a.serve(a.handlePostDevice))
+	mux.HandleFunc("GET /not-me", a.serve(a.handleGetNotMe))
+	mux.HandleFunc("POST /not-me", a.serve(a.handlePostNotMe))
+	mux.HandleFunc("POST /terms", a.serve(a.handlePostTerms))
+	mux.HandleFunc("GET /static/{name}", handleGetStatic)
+	mux.HandleFunc("GET /assets/consent.js", handleGetConsentJS)
 	for pattern, handler := range a.auth.Routes {
 		mux.HandleFunc(pattern, handler)
 	}
-	return mux
+	return accesslog.Routes(mux)
 }
 
 type appHandler func(http.ResponseWriter, *http.Request) *appError
 
 type statusPageData struct {
-	Title   string
-	Message string
+	Title     string
+	Message   string
+	RequestID string
 }
 
 func (a *App) serve(handler appHandler) http.HandlerFunc {
@@ -111,8 +175,9 @@ func (a *App) serve(handler appHandler) http.HandlerFunc {
 				}
 			}
 			page := statusPageData{
-				Title:   spec.title,
-				Message: spec.message,
+				Title:     spec.title,
+				Message:   spec.message,
+				RequestID: requestid.FromContext(r.Context()),
 			}
 			a.returnTemplate(w, r, spec.status, "status.html", page)
 		}
@@ -126,22 +191,51 @@ func (a *App) returnTemplate(
 	name string,
 	data any,
 ) {
-	bytes, err := a.templates.RenderTemplate(name, data)
+	locale := a.templates.Catalog().Negotiate(r.Header.Get("Accept-Language"))
+	bytes, err := a.templates.RenderLocalized(name, locale, data)
 	if err != nil {
 		logAppErr(r, fmt.Sprintf("couldn't render template: %v", err))
-		error := http.StatusText(http.StatusInternalServerError)
-		code := http.StatusInternalServerError
-		http.Error(w, error, code)
-		return
+		status = http.StatusInternalServerError
+		bytes, err = a.renderInternalErrorPage(name, locale)
+		if err != nil {
+			error := http.StatusText(http.StatusInternalServerError)
+			http.Error(w, error, status)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", locale)
+	w.Header().Add("Vary", "Accept-Language")
 	if status != http.StatusOK {
 		w.WriteHeader(status)
 	}
 	_, _ = w.Write(bytes)
 }
 
+// renderInternalErrorPage renders the status page for a failed render of
+// page in locale. If the status page itself failed, the caller falls back to plain text.
+func (a *App) renderInternalErrorPage(
+	page string,
+	locale string,
+) (
+	[]byte,
+	error,
+) {
+	if page == "status.html" {
+		return nil, fmt.Errorf("status page failed to render")
+	}
+	spec := appErrorSpecs[errRender]
+	return a.templates.RenderLocalized("status.html", locale, statusPageData{
+		Title:   spec.title,
+		Message: spec.message,
+	})
+}
+
 func logAppErr(r *http.Request, msg string) {
+	if id := requestid.FromContext(r.Context()); id != "" {
+		log.Printf("%s %s [%s]: %s\n", r.Method, r.URL.String(), id, msg)
+		return
+	}
 	log.Printf("%s %s: %s\n", r.Method, r.URL.String(), msg)
 }
@@ -34,3 +34,31 @@ func TestReturnStatusPage_RenderFailureFallsBackToInternalServerError(t *testing
 		t.Fatalf("expected standard internal server error body")
 	}
 }
+
+func TestReturnTemplate_RenderFailureShowsStatusPage(t *testing.T) {
+	brokenLogin := template.Must(template.New("login.html").Parse(`partial{{template "missing.html" .}}`))
+	status := template.Must(template.New("status.html").Parse(`{{.Title}}: {{.Message}}`))
+	appServer := &App{
+		templates: &Templates{
+			pages: map[string]*template.Template{
+				"login.html":  brokenLogin,
+				"status.html": status,
+			},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	rr := httptest.NewRecorder()
+	appServer.returnTemplate(rr, req, http.StatusOK, "login.html", nil)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	body := rr.Body.String()
+	if strings.Contains(body, "partial") {
+		t.Fatalf("body contains partial page output: %q", body)
+	}
+	if !strings.Contains(body, "Server Error: This page could not be displayed right now.") {
+		t.Fatalf("body = %q, want server error status page", body)
+	}
+}
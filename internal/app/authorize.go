@@ -4,6 +4,8 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/pkg/client"
@@ -16,6 +18,8 @@ type authorizePageData struct {
 	GrantedScopes      []service.ScopeDefinition
 	MissingScopes      []service.ScopeDefinition
 	State              string
+	RedirectURI        string
+	ReturnTo           string
 	CSRF               string
 }
 
@@ -27,6 +31,8 @@ func (a *App) handleGetAuthorize(
 	svcName := r.URL.Query().Get("integration")
 	scopes := r.URL.Query()["scope"]
 	state := r.URL.Query().Get("state")
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	returnTo := r.URL.Query().Get("return_to")
 
 	// get auth status
 	accessToken, csrf, err := a.auth.Verifier.VerifyAuthorizationGetCSRF(w, r)
@@ -39,8 +45,19 @@ func (a *App) handleGetAuthorize(
 	}
 	sub := accessToken.Subject()
 
+	// a sign-in older than max_age must be repeated first
+	if maxAge, ok := parseMaxAge(r.URL.Query().Get("max_age")); ok && !authenticatedWithin(accessToken, maxAge) {
+		http.Redirect(w, r, a.reauthenticateURL(r), http.StatusSeeOther)
+		return nil
+	}
+
+	// the current terms must be accepted before apps get tokens
+	if shown, termsErr := a.showPendingTerms(w, r, sub, csrf); shown || termsErr != nil {
+		return termsErr
+	}
+
 	// get a review of what needs to be authorized
-	review, err := a.service.ReviewAuthorizationRequest(sub, svcName, scopes, state)
+	review, err := a.service.ReviewAuthorizationRequest(r.Context(), sub, svcName, scopes, state, redirectURI, returnTo)
 	if err != nil {
 		return appErr(errAuthorizePrepare, err)
 	}
@@ -54,12 +71,23 @@ func (a *App) handleGetAuthorize(
 			GrantedScopes:      review.GrantedScopes,
 			MissingScopes:      review.MissingScopes,
 			State:              review.Request.State,
+			RedirectURI:        review.Request.Redirect,
+			ReturnTo:           review.Request.ReturnTo,
 			CSRF:               csrf,
 		})
 
 	case false: // try auto-approve and redirect
-		redirectURL, err := a.service.ApproveAuthorization(sub, review)
+		redirectURL, err := a.service.ApproveAuthorization(r.Context(), sub, accessToken.AuthTime(), review)
 		if err != nil {
+			if errors.Is(err, service.ErrAccountDisabled) {
+				return appErr(errAccountDisabled, err)
+			}
+			if errors.Is(err, service.ErrAccountExpired) {
+				return appErr(errAccountExpired, err)
+			}
+			if errors.Is(err, service.ErrTermsNotAccepted) {
+				return appErr(errTermsNotAccepted, err)
+			}
 			return appErr(errAuthorizeAutoApprove, err)
 		}
 		http.Redirect(w, r, redirectURL.String(), http.StatusSeeOther)
@@ -81,6 +109,8 @@ func (a *App) handlePostAuthorize(
 	scopes := r.Form["scope"]
 	state := r.FormValue("state")
 	svc := r.FormValue("integration")
+	redirectURI := r.FormValue("redirect_uri")
+	returnTo := r.FormValue("return_to")
 
 	// validate user
 	accessToken, _, err := a.auth.Verifier.VerifyAuthorizationCheckCSRF(w, r, csrf)
@@ -97,7 +127,7 @@ func (a *App) handlePostAuthorize(
 	sub := accessToken.Subject()
 
 	// review auth request
-	review, err := a.service.ReviewAuthorizationRequest(sub, svc, scopes, state)
+	review, err := a.service.ReviewAuthorizationRequest(r.Context(), sub, svc, scopes, state, redirectURI, returnTo)
 	if err != nil {
 		return appErr(errAuthorizeSubmitInvalid, err)
 	}
@@ -105,8 +135,17 @@ func (a *App) handlePostAuthorize(
 	// handle action and redirect
 	switch action {
 	case "approve":
-		redirectURL, err := a.service.ApproveAuthorization(sub, review)
+		redirectURL, err := a.service.ApproveAuthorization(r.Context(), sub, accessToken.AuthTime(), review)
 		if err != nil {
+			if errors.Is(err, service.ErrAccountDisabled) {
+				return appErr(errAccountDisabled, err)
+			}
+			if errors.Is(err, service.ErrAccountExpired) {
+				return appErr(errAccountExpired, err)
+			}
+			if errors.Is(err, service.ErrTermsNotAccepted) {
+				return appErr(errTermsNotAccepted, err)
+			}
 			return appErr(errAuthorizeApprove, err)
 		}
 		http.Redirect(w, r, redirectURL.String(), http.StatusSeeOther)
@@ -127,13 +166,63 @@ func (a *App) handlePostAuthorize(
 
 func (a *App) loginReturnToURL(
 	r *http.Request,
+) string {
+	return a.loginURL(r.URL.RequestURI(), "")
+}
+
+// reauthenticateURL sends a signed-in user to log in again before returning
+// to r. The return trip drops max_age, which the fresh sign-in satisfies.
+func (a *App) reauthenticateURL(
+	r *http.Request,
+) string {
+	returnTo := *r.URL
+	query := returnTo.Query()
+	query.Del("max_age")
+	returnTo.RawQuery = query.Encode()
+	return a.loginURL(returnTo.RequestURI(), promptLogin)
+}
+
+func (a *App) loginURL(
+	returnTo string,
+	prompt string,
 ) string {
 	loginURL, err := url.Parse(a.auth.LoginURL)
 	if err != nil || loginURL == nil {
-		return "/login?return_to=" + url.QueryEscape(r.URL.RequestURI())
+		loginURL = &url.URL{Path: "/login"}
 	}
 	query := loginURL.Query()
-	query.Set("return_to", r.URL.RequestURI())
+	query.Set("return_to", returnTo)
+	if prompt != "" {
+		query.Set("prompt", prompt)
+	}
 	loginURL.RawQuery = query.Encode()
 	return loginURL.String()
 }
+
+// parseMaxAge reads an OIDC-style max_age parameter: the most seconds since
+// the user last signed in that the request accepts.
+func parseMaxAge(
+	value string,
+) (
+	time.Duration,
+	bool,
+) {
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// authenticatedWithin reports whether the user behind token signed in at most
+// maxAge ago. Tokens without an auth time never qualify.
+func authenticatedWithin(
+	token *client.AccessToken,
+	maxAge time.Duration,
+) bool {
+	authTime := token.AuthTime()
+	return !authTime.IsZero() && time.Since(authTime) <= maxAge
+}
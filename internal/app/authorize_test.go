@@ -3,6 +3,7 @@ package app
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -44,7 +45,7 @@ func TestAuthorize_AuthenticatedRendersApprovalPage(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	env.RegisterTestUser(t, "alice", "password")
 	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "https://integration.test/callback")
-	user, err := env.DB.GetUserByHandle("alice")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
@@ -89,11 +90,11 @@ func TestAuthorize_AuthenticatedSeparatesGrantedAndMissingScopes(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	env.RegisterTestUser(t, "alice", "password")
 	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "https://integration.test/callback")
-	user, err := env.DB.GetUserByHandle("alice")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
-	if err := env.DB.InsertGrants(user.Subject, "test-integration", []string{"identity"}); err != nil {
+	if err := env.DB.InsertGrants(t.Context(), user.Subject, "test-integration", []string{"identity"}); err != nil {
 		t.Fatalf("InsertGrants failed: %v", err)
 	}
 	tv := consenttesting.NewTestVerifier("consent.test", "consent.test")
@@ -137,7 +138,7 @@ func TestAuthorizeSubmit_InvalidCSRFRendersStatusPage(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	env.RegisterTestUser(t, "alice", "password")
 	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "https://integration.test/callback")
-	user, err := env.DB.GetUserByHandle("alice")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
@@ -182,11 +183,11 @@ func TestAuthorize_AuthenticatedAutoRedirectsWhenGrantExists(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	env.RegisterTestUser(t, "alice", "password")
 	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "https://integration.test/callback")
-	user, err := env.DB.GetUserByHandle("alice")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
-	if err := env.DB.InsertGrants(user.Subject, "test-integration", []string{"identity"}); err != nil {
+	if err := env.DB.InsertGrants(t.Context(), user.Subject, "test-integration", []string{"identity"}); err != nil {
 		t.Fatalf("InsertGrants failed: %v", err)
 	}
 	tv := consenttesting.NewTestVerifier("consent.test", "consent.test")
@@ -219,3 +220,114 @@ func TestAuthorize_AuthenticatedAutoRedirectsWhenGrantExists(t *testing.T) {
 		t.Fatalf("redirect = %q, want auth_code and state", location)
 	}
 }
+
+func TestAuthorize_MaxAgeRequiresFreshSignIn(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "https://integration.test/callback")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	tv := consenttesting.NewTestVerifier("consent.test", "consent.test")
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// test tokens don't say when the user signed in, so they are too old
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/authorize?integration=test-integration&scope=identity&max_age=300", user.Subject)
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	if location.Path != "/login" || location.Query().Get("prompt") != "login" {
+		t.Fatalf("redirect = %q, want login with prompt=login", location)
+	}
+	if returnTo := location.Query().Get("return_to"); returnTo != "/authorize?integration=test-integration&scope=identity" {
+		t.Fatalf("return_to = %q, want the request without max_age", returnTo)
+	}
+}
+
+func TestAuthorize_MultipleRedirectsRequireExactMatch(t *testing.T) {
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	if err := env.Service.CreateIntegration(
+		t.Context(),
+		"test-integration", "Test Integration", "test-audience",
+		"https://integration.test/callback",
+		"https://staging.integration.test/callback",
+	); err != nil {
+		t.Fatalf("CreateIntegration failed: %v", err)
+	}
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	if err := env.DB.InsertGrants(t.Context(), user.Subject, "test-integration", []string{"identity"}); err != nil {
+		t.Fatalf("InsertGrants failed: %v", err)
+	}
+	tv := consenttesting.NewTestVerifier("consent.test", "consent.test")
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	authorize := func(redirectURI string) *httptest.ResponseRecorder {
+		target := "/authorize?integration=test-integration&scope=identity"
+		if redirectURI != "" {
+			target += "&redirect_uri=" + url.QueryEscape(redirectURI)
+		}
+		req, err := tv.AuthenticatedRequest(http.MethodGet, target, user.Subject)
+		if err != nil {
+			t.Fatalf("AuthenticatedRequest failed: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		appServer.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a registered redirect is used as-is
+	rr := authorize("https://staging.integration.test/callback")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if location := rr.Header().Get("Location"); !strings.HasPrefix(location, "https://staging.integration.test/callback?") {
+		t.Fatalf("redirect = %q, want staging callback", location)
+	}
+
+	// omitting the redirect is ambiguous and an unregistered one is refused
+	for _, redirectURI := range []string{"", "https://evil.test/callback"} {
+		rr := authorize(redirectURI)
+		if rr.Code == http.StatusSeeOther {
+			t.Fatalf("redirect_uri %q: unexpected redirect to %q", redirectURI, rr.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+)
+
+type devicePageData struct {
+	UserCode           string
+	Error              string
+	Review             bool
+	IntegrationDisplay string
+	GrantedScopes      []service.ScopeDefinition
+	MissingScopes      []service.ScopeDefinition
+	CSRF               string
+}
+
+func (a *App) handleGetDevice(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	userCode := r.URL.Query().Get("user_code")
+
+	// get auth status
+	accessToken, csrf, err := a.auth.Verifier.VerifyAuthorizationGetCSRF(w, r)
+	if err != nil {
+		if !errors.Is(err, client.ErrTokenAbsent) {
+			logAppErr(r, "failed to verify authorization: "+err.Error())
+		}
+		http.Redirect(w, r, a.loginReturnToURL(r), http.StatusSeeOther)
+		return nil
+	}
+
+	// without a code, ask for one
+	if userCode == "" {
+		a.returnTemplate(w, r, http.StatusOK, "device.html", devicePageData{})
+		return nil
+	}
+
+	// the current terms must be accepted before devices get tokens
+	if shown, termsErr := a.showPendingTerms(w, r, accessToken.Subject(), csrf); shown || termsErr != nil {
+		return termsErr
+	}
+
+	review, err := a.service.ReviewDeviceAuthorization(r.Context(), accessToken.Subject(), userCode)
+	if err != nil {
+		if errors.Is(err, service.ErrDeviceCodeNotFound) || errors.Is(err, service.ErrDeviceCodeExpired) {
+			a.returnTemplate(w, r, http.StatusBadRequest, "device.html", devicePageData{
+				UserCode: userCode,
+				Error:    "That code is invalid or has expired.",
+			})
+			return nil
+		}
+		return appErr(errDevicePrepare, err)
+	}
+
+	a.returnTemplate(w, r, http.StatusOK, "device.html", devicePageData{
+		UserCode:           review.UserCode,
+		Review:             true,
+		IntegrationDisplay: review.Review.Request.Integration.Display,
+		GrantedScopes:      review.Review.GrantedScopes,
+		MissingScopes:      review.Review.MissingScopes,
+		CSRF:               csrf,
+	})
+	return nil
+}
+
+func (a *App) handlePostDevice(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	// parse form values
+	if err := r.ParseForm(); err != nil {
+		return appErr(errAuthorizeFormInvalid, err)
+	}
+	csrf := r.FormValue("csrf")
+	action := r.FormValue("action")
+	userCode := r.FormValue("user_code")
+
+	// validate user
+	accessToken, _, err := a.auth.Verifier.VerifyAuthorizationCheckCSRF(w, r, csrf)
+	if err != nil {
+		if errors.Is(err, client.ErrCSRFInvalid) {
+			return appErr(errAuthorizeCSRFExpired, err)
+		}
+		if !errors.Is(err, client.ErrTokenAbsent) {
+			logAppErr(r, "failed to verify device submit: "+err.Error())
+		}
+		http.Redirect(w, r, a.loginReturnToURL(r), http.StatusSeeOther)
+		return nil
+	}
+	sub := accessToken.Subject()
+
+	// handle action
+	var page statusPageData
+	switch action {
+	case "approve":
+		if err := a.service.ApproveDeviceAuthorization(r.Context(), sub, accessToken.AuthTime(), userCode); err != nil {
+			if errors.Is(err, service.ErrTermsNotAccepted) {
+				return appErr(errTermsNotAccepted, err)
+			}
+			return appErr(errDeviceDecision, err)
+		}
+		page = statusPageData{
+			Title:   "Device Approved",
+			Message: "Your device is now signed in. You can return to it to continue.",
+		}
+
+	case "deny":
+		if err := a.service.DenyDeviceAuthorization(r.Context(), sub, userCode); err != nil {
+			return appErr(errDeviceDecision, err)
+		}
+		page = statusPageData{
+			Title:   "Device Denied",
+			Message: "The device was not given access to your account.",
+		}
+
+	default:
+		return appErr(errAuthorizeActionMissing, nil)
+	}
+
+	a.returnTemplate(w, r, http.StatusOK, "status.html", page)
+	return nil
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+func newDeviceTestApp(t *testing.T) (*App, *testutil.TestEnv, *consenttesting.TestVerifier) {
+	t.Helper()
+
+	env := testutil.SetupTestEnv(t)
+	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "http://localhost:8080/callback")
+	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return appServer, env, tv
+}
+
+func TestDevice_UnauthenticatedRedirectsToLogin(t *testing.T) {
+	appServer, _, _ := newDeviceTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/device?user_code=BCDF-GHJK", nil)
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr.Header().Get("Location") != "/login?return_to=%2Fdevice%3Fuser_code%3DBCDF-GHJK" {
+		t.Fatalf("location = %q, want login with return_to", rr.Header().Get("Location"))
+	}
+}
+
+func TestDevice_UnknownCodeRendersForm(t *testing.T) {
+	appServer, _, tv := newDeviceTestApp(t)
+
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/device?user_code=BCDF-GHJK", "alice")
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "That code is invalid or has expired.") {
+		t.Fatalf("expected invalid code message")
+	}
+}
+
+func TestDevice_ApproveRecordsDecision(t *testing.T) {
+	appServer, env, tv := newDeviceTestApp(t)
+	env.RegisterTestUser(t, "alice", "password")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+
+	// review page shows the integration and code
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/device?user_code="+grant.UserCode, alice.Subject)
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Authorize Test Integration") {
+		t.Fatalf("expected device review page")
+	}
+
+	// approve with csrf
+	_, csrf, err := tv.VerifyAuthorizationGetCSRF(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("VerifyAuthorizationGetCSRF failed: %v", err)
+	}
+	form := url.Values{
+		"user_code": []string{grant.UserCode},
+		"csrf":      []string{csrf},
+		"action":    []string{"approve"},
+	}
+	post := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range req.Cookies() {
+		post.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, post)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "Device Approved") {
+		t.Fatalf("expected approval status page")
+	}
+
+	// device can now redeem the code
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); err != nil {
+		t.Fatalf("PollDeviceAuthorization failed: %v", err)
+	}
+}
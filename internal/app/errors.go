@@ -18,6 +18,26 @@ const (
 	errLoginFormInvalid
 	errLoginFailed
 	errHomeSessionUI
+	errUpstreamUnknown
+	errUpstreamBegin
+	errUpstreamStateInvalid
+	errUpstreamDenied
+	errUpstreamLoginFailed
+	errUpstreamLinkConflict
+	errForwardAuthCodeInvalid
+	errForwardAuthFailed
+	errHomeLinkedIdentities
+	errDevicePrepare
+	errDeviceDecision
+	errNotMeInvalid
+	errNotMeRevoke
+	errMaintenance
+	errAccountDisabled
+	errAccountExpired
+	errTermsNotAccepted
+	errTermsFormInvalid
+	errTermsAccept
+	errRender
 )
 
 type appError struct {
@@ -34,6 +54,13 @@ type appErrorSpec struct {
 }
 
 var appErrorSpecs = map[appErrorKind]appErrorSpec{
+	errRender: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "This page could not be displayed right now.",
+		logMessage: "failed to render page",
+		loggable:   true,
+	},
 	errAuthorizeRequestInvalid: {
 		status:     http.StatusBadRequest,
 		title:      "Bad Request",
@@ -123,6 +150,130 @@ var appErrorSpecs = map[appErrorKind]appErrorSpec{
 		logMessage: "failed to build logout URL",
 		loggable:   true,
 	},
+	errUpstreamUnknown: {
+		status:   http.StatusNotFound,
+		title:    "Not Found",
+		message:  "That login provider is not available.",
+		loggable: false,
+	},
+	errUpstreamBegin: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "Login with that provider could not be started right now.",
+		logMessage: "failed to begin upstream login",
+		loggable:   true,
+	},
+	errUpstreamStateInvalid: {
+		status:   http.StatusBadRequest,
+		title:    "Login Expired",
+		message:  "This login attempt is no longer valid. Start again from the login page.",
+		loggable: false,
+	},
+	errUpstreamDenied: {
+		status:     http.StatusForbidden,
+		title:      "Login Cancelled",
+		message:    "The login provider did not approve this login.",
+		logMessage: "upstream provider returned error",
+		loggable:   true,
+	},
+	errUpstreamLoginFailed: {
+		status:     http.StatusBadGateway,
+		title:      "Login Failed",
+		message:    "Login with that provider could not be completed right now.",
+		logMessage: "failed to complete upstream login",
+		loggable:   true,
+	},
+	errUpstreamLinkConflict: {
+		status:   http.StatusConflict,
+		title:    "Already Linked",
+		message:  "That login is already linked to a different account.",
+		loggable: false,
+	},
+	errForwardAuthCodeInvalid: {
+		status:   http.StatusBadRequest,
+		title:    "Login Expired",
+		message:  "This sign-in link is no longer valid. Go back to the app and try again.",
+		loggable: false,
+	},
+	errForwardAuthFailed: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "Sign-in to that app could not be completed right now.",
+		logMessage: "failed to complete forward auth",
+		loggable:   true,
+	},
+	errHomeLinkedIdentities: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "Your linked logins could not be loaded right now.",
+		logMessage: "failed to list linked identities",
+		loggable:   true,
+	},
+	errDevicePrepare: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "This device request could not be loaded right now.",
+		logMessage: "failed to review device authorization",
+		loggable:   true,
+	},
+	errDeviceDecision: {
+		status:     http.StatusBadRequest,
+		title:      "Device Request Expired",
+		message:    "This device request is no longer valid. Start again on your device.",
+		logMessage: "failed to record device decision",
+		loggable:   true,
+	},
+	errNotMeInvalid: {
+		status:   http.StatusBadRequest,
+		title:    "Link Expired",
+		message:  "This link is invalid or has expired. Sign in to review and end your sessions.",
+		loggable: false,
+	},
+	errNotMeRevoke: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "Your sessions could not be ended right now.",
+		logMessage: "failed to revoke sign-in",
+		loggable:   true,
+	},
+	errMaintenance: {
+		status:   http.StatusServiceUnavailable,
+		title:    "Down for Maintenance",
+		message:  "Changes are paused while this server is maintained. Try again later.",
+		loggable: false,
+	},
+	errAccountDisabled: {
+		status:   http.StatusForbidden,
+		title:    "Account Disabled",
+		message:  "This account has been disabled. Contact an administrator to restore it.",
+		loggable: false,
+	},
+	errAccountExpired: {
+		status:   http.StatusForbidden,
+		title:    "Account Expired",
+		message:  "This account has expired. Contact an administrator to extend it.",
+		loggable: false,
+	},
+	errTermsNotAccepted: {
+		status:   http.StatusForbidden,
+		title:    "Terms Not Accepted",
+		message:  "The terms of service have changed. Go back and reload the page to read and accept them.",
+		loggable: false,
+	},
+	errTermsFormInvalid: {
+		status:     http.StatusBadRequest,
+		title:      "Bad Request",
+		message:    "That terms form could not be processed.",
+		logMessage: "failed to parse terms form",
+		loggable:   true,
+	},
+	errTermsAccept: {
+		status:     http.StatusInternalServerError,
+		title:      "Server Error",
+		message:    "Your acceptance of the terms could not be recorded right now.",
+		logMessage: "failed to accept terms",
+		loggable:   true,
+	},
 }
 
 func appErr(kind appErrorKind, err error) *appError {
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+const (
+	upstreamStateCookieName    = "upstreamState"
+	upstreamVerifierCookieName = "upstreamVerifier"
+	upstreamReturnToCookieName = "upstreamReturnTo"
+	upstreamIntentCookieName   = "upstreamIntent"
+	upstreamIntentLink         = "link"
+	upstreamCookiePath         = "/login/with/"
+	upstreamCookieLifetime     = 10 * time.Minute
+)
+
+type upstreamLink struct {
+	Display string
+	URL     string
+}
+
+type upstreamLinkStatus struct {
+	Display string
+	Linked  bool
+	LinkURL string
+}
+
+func (a *App) handleGetUpstreamLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	returnTo := sanitizeReturnTo(r.URL.Query().Get("return_to"))
+	return a.beginUpstream(w, r, returnTo, "")
+}
+
+func (a *App) handleGetUpstreamLink(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	// linking attaches a credential to the current session's account, so it
+	// requires the same CSRF proof as other session actions
+	csrf := r.URL.Query().Get("csrf")
+	if _, _, err := a.auth.Verifier.VerifyAuthorizationCheckCSRF(w, r, csrf); err != nil {
+		if !errors.Is(err, client.ErrTokenAbsent) {
+			logAppErr(r, "failed to verify authorization: "+err.Error())
+		}
+		http.Redirect(w, r, a.auth.LoginURL, http.StatusSeeOther)
+		return nil
+	}
+
+	return a.beginUpstream(w, r, "/", upstreamIntentLink)
+}
+
+// beginUpstream binds a fresh state, PKCE verifier, and optional intent to the
+// browser and redirects to the upstream provider.
+func (a *App) beginUpstream(
+	w http.ResponseWriter,
+	r *http.Request,
+	returnTo string,
+	intent string,
+) *appError {
+	provider := r.PathValue("provider")
+
+	state, err := generateUpstreamSecret()
+	if err != nil {
+		return appErr(errUpstreamBegin, err)
+	}
+	verifier, err := generateUpstreamSecret()
+	if err != nil {
+		return appErr(errUpstreamBegin, err)
+	}
+
+	authorizeURL, err := a.service.BeginUpstreamLogin(provider, state, verifier)
+	if err != nil {
+		if errors.Is(err, service.ErrUpstreamNotFound) {
+			return appErr(errUpstreamUnknown, err)
+		}
+		return appErr(errUpstreamBegin, err)
+	}
+
+	a.setUpstreamCookie(w, upstreamStateCookieName, state, upstreamCookieLifetime)
+	a.setUpstreamCookie(w, upstreamVerifierCookieName, verifier, upstreamCookieLifetime)
+	a.setUpstreamCookie(w, upstreamReturnToCookieName, returnTo, upstreamCookieLifetime)
+	if intent != "" {
+		a.setUpstreamCookie(w, upstreamIntentCookieName, intent, upstreamCookieLifetime)
+	} else {
+		a.setUpstreamCookie(w, upstreamIntentCookieName, "", -1)
+	}
+	http.Redirect(w, r, authorizeURL.String(), http.StatusSeeOther)
+	return nil
+}
+
+func (a *App) handleGetUpstreamCallback(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	provider := r.PathValue("provider")
+	query := r.URL.Query()
+
+	// the state and verifier cookies are single-use regardless of outcome
+	stateCookie, stateErr := r.Cookie(upstreamStateCookieName)
+	verifier := ""
+	if cookie, err := r.Cookie(upstreamVerifierCookieName); err == nil {
+		verifier = cookie.Value
+	}
+	returnTo := "/"
+	if cookie, err := r.Cookie(upstreamReturnToCookieName); err == nil {
+		returnTo = sanitizeReturnTo(cookie.Value)
+	}
+	intent := ""
+	if cookie, err := r.Cookie(upstreamIntentCookieName); err == nil {
+		intent = cookie.Value
+	}
+	a.setUpstreamCookie(w, upstreamStateCookieName, "", -1)
+	a.setUpstreamCookie(w, upstreamVerifierCookieName, "", -1)
+	a.setUpstreamCookie(w, upstreamReturnToCookieName, "", -1)
+	a.setUpstreamCookie(w, upstreamIntentCookieName, "", -1)
+
+	state := query.Get("state")
+	if stateErr != nil || state == "" || verifier == "" ||
+		!tokens.SecureCompare(stateCookie.Value, state) {
+		return appErr(errUpstreamStateInvalid, nil)
+	}
+
+	if upstreamError := query.Get("error"); upstreamError != "" {
+		return appErr(errUpstreamDenied, errors.New(upstreamError))
+	}
+
+	if intent == upstreamIntentLink {
+		return a.completeUpstreamLink(w, r, provider, query.Get("code"), verifier, returnTo)
+	}
+
+	redirectURL, err := a.service.CompleteUpstreamLogin(r.Context(), provider, query.Get("code"), verifier, returnTo)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUpstreamNotFound):
+			return appErr(errUpstreamUnknown, err)
+		case errors.Is(err, service.ErrMaintenance):
+			return appErr(errMaintenance, err)
+		case errors.Is(err, service.ErrAccountDisabled):
+			return appErr(errAccountDisabled, err)
+		case errors.Is(err, service.ErrAccountExpired):
+			return appErr(errAccountExpired, err)
+		default:
+			return appErr(errUpstreamLoginFailed, err)
+		}
+	}
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusSeeOther)
+	return nil
+}
+
+func (a *App) completeUpstreamLink(
+	w http.ResponseWriter,
+	r *http.Request,
+	provider string,
+	code string,
+	verifier string,
+	returnTo string,
+) *appError {
+	accessToken, err := a.auth.Verifier.VerifyAuthorization(w, r)
+	if err != nil {
+		return appErr(errUpstreamStateInvalid, err)
+	}
+
+	err = a.service.LinkUpstreamIdentity(r.Context(), accessToken.Subject(), provider, code, verifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUpstreamNotFound):
+			return appErr(errUpstreamUnknown, err)
+		case errors.Is(err, service.ErrIdentityLinked):
+			return appErr(errUpstreamLinkConflict, err)
+		case errors.Is(err, service.ErrMaintenance):
+			return appErr(errMaintenance, err)
+		default:
+			return appErr(errUpstreamLoginFailed, err)
+		}
+	}
+
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+	return nil
+}
+
+// upstreamLinkStatuses reports which upstream providers are linked to the
+// user, with CSRF-bound URLs to link the rest.
+func (a *App) upstreamLinkStatuses(
+	ctx context.Context,
+	subject string,
+	csrf string,
+) (
+	[]upstreamLinkStatus,
+	error,
+) {
+	identities, err := a.service.ListLinkedIdentities(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]bool, len(identities))
+	for _, identity := range identities {
+		linked[identity.Provider] = true
+	}
+
+	providers := a.service.ListUpstreamProviders()
+	statuses := make([]upstreamLinkStatus, 0, len(providers))
+	for _, provider := range providers {
+		linkURL := url.URL{
+			Path:     "/link/with/" + url.PathEscape(provider.Name),
+			RawQuery: url.Values{"csrf": []string{csrf}}.Encode(),
+		}
+		statuses = append(statuses, upstreamLinkStatus{
+			Display: provider.Display,
+			Linked:  linked[provider.Name],
+			LinkURL: linkURL.String(),
+		})
+	}
+	return statuses, nil
+}
+
+func (a *App) upstreamLinks(
+	returnTo string,
+) []upstreamLink {
+	providers := a.service.ListUpstreamProviders()
+	links := make([]upstreamLink, 0, len(providers))
+	for _, provider := range providers {
+		loginURL := url.URL{
+			Path:     upstreamCookiePath + url.PathEscape(provider.Name),
+			RawQuery: url.Values{"return_to": []string{returnTo}}.Encode(),
+		}
+		links = append(links, upstreamLink{
+			Display: provider.Display,
+			URL:     loginURL.String(),
+		})
+	}
+	return links
+}
+
+func (a *App) setUpstreamCookie(
+	w http.ResponseWriter,
+	name string,
+	value string,
+	lifetime time.Duration,
+) {
+	maxAge := int(lifetime.Seconds())
+	if lifetime < 0 {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     upstreamCookiePath,
+		Value:    value,
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   !a.insecureCookies,
+		HttpOnly: true,
+	})
+}
+
+func generateUpstreamSecret() (
+	string,
+	error,
+) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
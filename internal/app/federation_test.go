@@ -0,0 +1,203 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+func newUpstreamTestApp(t *testing.T) *App {
+	t.Helper()
+
+	env := testutil.SetupTestEnvWithUpstreams(t, []service.UpstreamProvider{{
+		Name:         "corp",
+		Display:      "Corp SSO",
+		AuthorizeURL: "https://idp.test/authorize",
+		TokenURL:     "https://idp.test/token",
+		UserInfoURL:  "https://idp.test/userinfo",
+		ClientID:     "consent",
+		ClientSecret: "upstream-secret",
+		RedirectURL:  "https://consent.test/login/with/corp/callback",
+	}})
+	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return appServer
+}
+
+func TestLogin_RendersUpstreamLinks(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/login?return_to=%2Fprofile", nil)
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Log in with Corp SSO") {
+		t.Fatalf("expected upstream login link")
+	}
+	if !strings.Contains(body, `href="/login/with/corp?return_to=%2Fprofile"`) {
+		t.Fatalf("expected upstream link to preserve return_to")
+	}
+}
+
+func TestUpstreamLogin_RedirectsWithStateCookie(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/login/with/corp?return_to=%2Fprofile", nil)
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid location: %v", err)
+	}
+	if location.Host != "idp.test" {
+		t.Fatalf("location = %q, want upstream authorize URL", location)
+	}
+
+	var stateCookie, verifierCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		switch cookie.Name {
+		case upstreamStateCookieName:
+			stateCookie = cookie
+		case upstreamVerifierCookieName:
+			verifierCookie = cookie
+		}
+	}
+	if stateCookie == nil || stateCookie.Value == "" {
+		t.Fatal("expected state cookie")
+	}
+	if !stateCookie.HttpOnly || !stateCookie.Secure {
+		t.Fatalf("state cookie = %#v, want HttpOnly and Secure", stateCookie)
+	}
+	if location.Query().Get("state") != stateCookie.Value {
+		t.Fatalf("state = %q, want cookie value", location.Query().Get("state"))
+	}
+	if verifierCookie == nil || verifierCookie.Value == "" {
+		t.Fatal("expected verifier cookie")
+	}
+	sum := sha256.Sum256([]byte(verifierCookie.Value))
+	if location.Query().Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("code_challenge = %q, want S256 of verifier cookie", location.Query().Get("code_challenge"))
+	}
+}
+
+func TestUpstreamCallback_MissingVerifier(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/login/with/corp/callback?code=good&state=expected", nil)
+	req.AddCookie(&http.Cookie{Name: upstreamStateCookieName, Value: "expected"})
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestUpstreamLogin_UnknownProvider(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/login/with/missing", nil)
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpstreamCallback_StateMismatch(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/login/with/corp/callback?code=good&state=forged", nil)
+	req.AddCookie(&http.Cookie{Name: upstreamStateCookieName, Value: "expected"})
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestUpstreamLink_RequiresCSRF(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+	tv := appServer.auth.Verifier.(*consenttesting.TestVerifier)
+
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/link/with/corp?csrf=wrong", "alice")
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr.Header().Get("Location") != "/login" {
+		t.Fatalf("location = %q, want /login", rr.Header().Get("Location"))
+	}
+}
+
+func TestUpstreamLink_SetsLinkIntent(t *testing.T) {
+	appServer := newUpstreamTestApp(t)
+	tv := appServer.auth.Verifier.(*consenttesting.TestVerifier)
+
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/link/with/corp", "alice")
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	_, csrf, err := tv.VerifyAuthorizationGetCSRF(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("VerifyAuthorizationGetCSRF failed: %v", err)
+	}
+	req.URL.RawQuery = url.Values{"csrf": []string{csrf}}.Encode()
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	var intent string
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == upstreamIntentCookieName {
+			intent = cookie.Value
+		}
+	}
+	if intent != upstreamIntentLink {
+		t.Fatalf("intent cookie = %q, want %q", intent, upstreamIntentLink)
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// forwardAuthCookieName is the cookie forward auth reads a proxied app's
+// access token from, the same one the Go client sets.
+const forwardAuthCookieName = "accessToken"
+
+// handleGetForwardAuthCallback finishes a forward-auth login. The proxy routes
+// the integration's redirect, on the proxied app's origin, to this handler, so
+// the accessToken cookie it sets belongs to the app and forward auth finds it
+// on the next request. Confidential integrations authenticate with HTTP Basic
+// credentials the proxy adds; public ones may name themselves with the
+// integration query parameter.
+func (a *App) handleGetForwardAuthCallback(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	query := r.URL.Query()
+	client := service.ClientCredentials{ID: query.Get("integration")}
+	if id, secret, ok := r.BasicAuth(); ok {
+		client = service.ClientCredentials{ID: id, Secret: secret}
+	}
+
+	accessToken, err := a.service.CompleteForwardAuth(r.Context(), query.Get("auth_code"), client)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidAuthCode), errors.Is(err, service.ErrAuthCodeExpired):
+			return appErr(errForwardAuthCodeInvalid, err)
+		case errors.Is(err, service.ErrAccountDisabled):
+			return appErr(errAccountDisabled, err)
+		case errors.Is(err, service.ErrAccountExpired):
+			return appErr(errAccountExpired, err)
+		default:
+			return appErr(errForwardAuthFailed, err)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     forwardAuthCookieName,
+		Path:     "/",
+		Value:    accessToken.Encoded(),
+		MaxAge:   int(time.Until(accessToken.Expiration()).Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		Secure:   !a.insecureCookies,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, sanitizeReturnTo(query.Get("return_to")), http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+func newForwardAuthTestApp(
+	t *testing.T,
+) (
+	*testutil.TestEnv,
+	*App,
+	*consenttesting.TestVerifier,
+) {
+	t.Helper()
+
+	env := testutil.SetupTestEnv(t)
+	env.CreateTestIntegration(t, "proxied", "Proxied App", "proxied-audience", "https://app.test/_consent/callback")
+	tv := consenttesting.NewTestVerifier("consent.test", "consent.test")
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return env, appServer, tv
+}
+
+func TestForwardAuth_ReturnsToRequestedURL(t *testing.T) {
+	env, appServer, tv := newForwardAuthTestApp(t)
+	env.RegisterTestUser(t, "alice", "password")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	if err := env.DB.InsertGrants(t.Context(), user.Subject, "proxied", []string{"identity"}); err != nil {
+		t.Fatalf("InsertGrants failed: %v", err)
+	}
+
+	// the proxy turns alice away from the page alice asked for
+	loginURL, err := url.Parse(env.Service.ForwardAuthLoginURL(t.Context(), "proxied", "https://app.test/reports?id=7"))
+	if err != nil {
+		t.Fatalf("invalid login URL: %v", err)
+	}
+
+	// alice's Consent session approves at once, back to the app's callback
+	req, err := tv.AuthenticatedRequest(http.MethodGet, loginURL.RequestURI(), user.Subject)
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("authorize status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	callbackURL, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback URL: %v", err)
+	}
+	if callbackURL.Host != "app.test" || callbackURL.Path != "/_consent/callback" {
+		t.Fatalf("callback = %q, want the app's redirect", callbackURL)
+	}
+
+	// the proxy routes the callback here, which sets the app's cookie
+	req = httptest.NewRequest(http.MethodGet, "/forward-auth/callback?"+callbackURL.RawQuery, nil)
+	rr = httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("callback status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if got := rr.Header().Get("Location"); got != "/reports?id=7" {
+		t.Fatalf("callback location = %q, want /reports?id=7", got)
+	}
+
+	var accessCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == forwardAuthCookieName {
+			accessCookie = cookie
+		}
+	}
+	if accessCookie == nil || !accessCookie.HttpOnly || !accessCookie.Secure || accessCookie.Path != "/" {
+		t.Fatalf("access cookie = %#v, want a Secure HttpOnly cookie on /", accessCookie)
+	}
+
+	// and forward auth now lets alice through
+	identity, err := env.Service.ForwardAuth(t.Context(), accessCookie.Value, "proxied")
+	if err != nil {
+		t.Fatalf("ForwardAuth failed: %v", err)
+	}
+	if identity.Handle != "alice" {
+		t.Fatalf("handle = %q, want alice", identity.Handle)
+	}
+}
+
+func TestForwardAuthCallback_RejectsUsedCode(t *testing.T) {
+	env, appServer, _ := newForwardAuthTestApp(t)
+	env.RegisterTestUser(t, "alice", "password")
+	code := env.IssueTestAuthorizationCode(t, "alice", "proxied", []string{"identity"})
+
+	target := "/forward-auth/callback?" + url.Values{"auth_code": []string{code}}.Encode()
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("first status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+
+	rr = httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("replay status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -12,6 +12,7 @@ type homePageData struct {
 	Authenticated bool
 	LoginURL      string
 	LogoutURL     string
+	Upstreams     []upstreamLinkStatus
 }
 
 func (a *App) handleGetHome(
@@ -33,10 +34,15 @@ func (a *App) handleGetHome(
 		if err != nil {
 			return appErr(errHomeSessionUI, err)
 		}
+		upstreams, err := a.upstreamLinkStatuses(r.Context(), accessToken.Subject(), csrfSecret)
+		if err != nil {
+			return appErr(errHomeLinkedIdentities, err)
+		}
 		data = homePageData{
 			Authenticated: true,
 			LoginURL:      a.auth.LoginURL,
 			LogoutURL:     logoutUrl,
+			Upstreams:     upstreams,
 		}
 	} else {
 		data = homePageData{
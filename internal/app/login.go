@@ -8,10 +8,17 @@ import (
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
+// promptLogin asks the login page to sign the user in again even if they
+// already are, as step-up authentication does before sensitive actions.
+const promptLogin = "login"
+
 type loginPageData struct {
-	Handle   string
-	ReturnTo string
-	Error    string
+	Handle         string
+	Remember       bool
+	Reauthenticate bool
+	ReturnTo       string
+	Error          string
+	Upstreams      []upstreamLink
 }
 
 func (a *App) handleGetLogin(
@@ -19,15 +26,20 @@ func (a *App) handleGetLogin(
 	r *http.Request,
 ) *appError {
 	returnTo := sanitizeReturnTo(r.URL.Query().Get("return_to"))
+	reauthenticate := r.URL.Query().Get("prompt") == promptLogin
 
-	_, err := a.auth.Verifier.VerifyAuthorization(w, r)
-	if err == nil {
-		http.Redirect(w, r, returnTo, http.StatusSeeOther)
-		return nil
+	if !reauthenticate {
+		_, err := a.auth.Verifier.VerifyAuthorization(w, r)
+		if err == nil {
+			http.Redirect(w, r, returnTo, http.StatusSeeOther)
+			return nil
+		}
 	}
 
 	page := loginPageData{
-		ReturnTo: returnTo,
+		Reauthenticate: reauthenticate,
+		ReturnTo:       returnTo,
+		Upstreams:      a.upstreamLinks(returnTo),
 	}
 	a.returnTemplate(w, r, http.StatusOK, "login.html", page)
 	return nil
@@ -45,20 +57,29 @@ func (a *App) handlePostLogin(
 	returnTo := sanitizeReturnTo(r.FormValue("return_to"))
 	handle := r.FormValue("handle")
 	secret := r.FormValue("secret")
+	remember := r.FormValue("remember") != ""
+	reauthenticate := r.FormValue("prompt") == promptLogin
 
 	// validate input
 	if handle == "" || secret == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		a.returnTemplate(w, r, http.StatusUnauthorized, "login.html", loginPageData{
-			Handle:   handle,
-			ReturnTo: returnTo,
-			Error:    "Enter both your handle and secret.",
+			Handle:         handle,
+			Remember:       remember,
+			Reauthenticate: reauthenticate,
+			ReturnTo:       returnTo,
+			Error:          "Enter both your handle and secret.",
+			Upstreams:      a.upstreamLinks(returnTo),
 		})
 		return nil
 	}
 
 	// call service
-	redirectURL, err := a.service.GrantAuthCode(handle, secret, service.InternalIntegrationName, returnTo)
+	ctx := service.WithClientInfo(r.Context(), service.ClientInfoFromRequest(r))
+	redirectURL, err := a.service.GrantAuthCodeWithOptions(ctx, handle, secret, service.InternalIntegrationName, service.LoginOptions{
+		ReturnTo:    returnTo,
+		SessionOnly: !remember,
+	})
 	if err != nil {
 		// handle errors
 		switch {
@@ -66,9 +87,32 @@ func (a *App) handlePostLogin(
 			errors.Is(err, service.ErrAccountNotFound):
 			w.WriteHeader(http.StatusUnauthorized)
 			a.returnTemplate(w, r, http.StatusUnauthorized, "login.html", loginPageData{
-				Handle:   handle,
-				ReturnTo: returnTo,
-				Error:    "Invalid handle or secret.",
+				Handle:         handle,
+				Remember:       remember,
+				Reauthenticate: reauthenticate,
+				ReturnTo:       returnTo,
+				Error:          "Invalid handle or secret.",
+				Upstreams:      a.upstreamLinks(returnTo),
+			})
+			return nil
+		case errors.Is(err, service.ErrAccountDisabled):
+			a.returnTemplate(w, r, http.StatusForbidden, "login.html", loginPageData{
+				Handle:         handle,
+				Remember:       remember,
+				Reauthenticate: reauthenticate,
+				ReturnTo:       returnTo,
+				Error:          "This account has been disabled. Contact an administrator to restore it.",
+				Upstreams:      a.upstreamLinks(returnTo),
+			})
+			return nil
+		case errors.Is(err, service.ErrAccountExpired):
+			a.returnTemplate(w, r, http.StatusForbidden, "login.html", loginPageData{
+				Handle:         handle,
+				Remember:       remember,
+				Reauthenticate: reauthenticate,
+				ReturnTo:       returnTo,
+				Error:          "This account has expired. Contact an administrator to extend it.",
+				Upstreams:      a.upstreamLinks(returnTo),
 			})
 			return nil
 		default:
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -42,6 +44,9 @@ func TestLogin_UnauthenticatedRendersForm(t *testing.T) {
 	if !strings.Contains(rr.Body.String(), `value="/authorize?integration=mock1"`) {
 		t.Fatalf("expected return_to to be preserved in form")
 	}
+	if !strings.Contains(rr.Body.String(), `<link rel="stylesheet" href="/static/consent.css?v=`) {
+		t.Fatalf("expected versioned stylesheet link")
+	}
 }
 
 func TestLogin_AuthenticatedRedirectsToReturnTo(t *testing.T) {
@@ -77,6 +82,43 @@ func TestLogin_AuthenticatedRedirectsToReturnTo(t *testing.T) {
 	}
 }
 
+func TestLogin_PromptLoginRendersFormWhenAuthenticated(t *testing.T) {
+	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
+	env := testutil.SetupTestEnv(t)
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/login?return_to=%2Fauthorize%3Fintegration%3Dmock1&prompt=login", "alice")
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Enter your secret again to continue.") {
+		t.Fatalf("expected reauthentication notice, got %q", body)
+	}
+	if !strings.Contains(body, `name="prompt" value="login"`) {
+		t.Fatalf("expected prompt to carry through the form, got %q", body)
+	}
+}
+
 func TestLogin_AuthenticatedRejectsAbsoluteReturnTo(t *testing.T) {
 	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
 	env := testutil.SetupTestEnv(t)
@@ -238,3 +280,117 @@ func TestLoginSubmit_InvalidReturnToFallsBackHome(t *testing.T) {
 		t.Fatalf("redirect = %q, want sanitized home return_to", location)
 	}
 }
+
+func TestLogin_RendersNegotiatedLocale(t *testing.T) {
+	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
+	env := testutil.SetupTestEnv(t)
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9,en;q=0.5")
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Language"); got != "es" {
+		t.Errorf("Content-Language = %q, want es", got)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `<html lang="es">`) {
+		t.Errorf("expected html lang attribute")
+	}
+	if !strings.Contains(body, "Iniciar sesión") {
+		t.Errorf("expected Spanish login page, got %q", body)
+	}
+}
+
+func TestLogin_DefaultLocale(t *testing.T) {
+	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
+	env := testutil.SetupTestEnv(t)
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+		Locale: "es",
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	rr := httptest.NewRecorder()
+
+	appServer.Router().ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Content-Language"); got != "es" {
+		t.Errorf("Content-Language = %q, want es", got)
+	}
+	if !strings.Contains(rr.Body.String(), "Secreto") {
+		t.Errorf("expected Spanish login page without Accept-Language")
+	}
+}
+
+func TestApp_ReloadPicksUpLocaleChanges(t *testing.T) {
+	tv := consenttesting.NewTestVerifier("consent.test", "app.test")
+	env := testutil.SetupTestEnv(t)
+	dir := t.TempDir()
+	catalog := filepath.Join(dir, "de.json")
+	if err := os.WriteFile(catalog, []byte(`{"Log In": "Anmelden"}`), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+		LocalesPath: dir,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	render := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.Header.Set("Accept-Language", "de")
+		rr := httptest.NewRecorder()
+		appServer.Router().ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	if body := render(); !strings.Contains(body, "Anmelden") {
+		t.Fatalf("expected German login page")
+	}
+
+	if err := os.WriteFile(catalog, []byte(`{"Log In": "Einloggen"}`), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := appServer.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if body := render(); !strings.Contains(body, "Einloggen") {
+		t.Fatalf("expected reloaded translation")
+	}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+type notMePageData struct {
+	Token string
+}
+
+// handleGetNotMe confirms before ending sessions, so mail scanners that open
+// the link from a new-device notice don't sign the user out.
+func (a *App) handleGetNotMe(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return appErr(errNotMeInvalid, nil)
+	}
+
+	a.returnTemplate(w, r, http.StatusOK, "not-me.html", notMePageData{Token: token})
+	return nil
+}
+
+func (a *App) handlePostNotMe(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	// parse form values
+	if err := r.ParseForm(); err != nil {
+		return appErr(errNotMeInvalid, err)
+	}
+	token := r.FormValue("token")
+	if token == "" {
+		return appErr(errNotMeInvalid, nil)
+	}
+
+	// end the sessions
+	if _, err := a.service.RevokeSignIn(r.Context(), token); err != nil {
+		if errors.Is(err, service.ErrTokenInvalid) {
+			return appErr(errNotMeInvalid, err)
+		}
+		return appErr(errNotMeRevoke, err)
+	}
+
+	a.returnTemplate(w, r, http.StatusOK, "status.html", statusPageData{
+		Title:   "Devices Signed Out",
+		Message: "Every device that signed in since the notice was sent is now signed out. Change your secret to keep them out.",
+	})
+	return nil
+}
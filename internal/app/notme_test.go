@@ -0,0 +1,73 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotMe_GetConfirmsWithoutRevoking(t *testing.T) {
+	appServer, env, _ := newDeviceTestApp(t)
+	env.RegisterTestUser(t, "alice", "password")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	session := env.StoreTestRefreshToken(t, "alice", []string{"app.test"})
+	token, err := env.TokenIssuer.NewAccessToken().
+		Subject(alice.Subject).
+		Audience("https://consent.test/not-me").
+		Lifetime(time.Hour).
+		AuthTime(session.IssuedAt()).
+		Issue()
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	// opening the link only asks
+	req := httptest.NewRequest(http.MethodGet, "/not-me?token="+url.QueryEscape(token.Encoded()), nil)
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `action="/not-me"`) {
+		t.Fatal("expected confirmation form")
+	}
+	if _, err := env.DB.GetRefreshTokenOwner(t.Context(), session.Encoded()); err != nil {
+		t.Fatalf("session revoked by GET: %v", err)
+	}
+
+	// confirming ends the session
+	form := url.Values{"token": {token.Encoded()}}
+	req = httptest.NewRequest(http.MethodPost, "/not-me", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if _, err := env.DB.GetRefreshTokenOwner(t.Context(), session.Encoded()); err == nil {
+		t.Fatal("expected session to be revoked")
+	}
+}
+
+func TestNotMe_InvalidToken(t *testing.T) {
+	appServer, _, _ := newDeviceTestApp(t)
+
+	form := url.Values{"token": {"garbage"}}
+	req := httptest.NewRequest(http.MethodPost, "/not-me", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "This link is invalid or has expired.") {
+		t.Fatal("expected invalid link message")
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+//go:embed static/*
+var staticFS embed.FS
+
+// Assets requested with their current version are cached for a year; any
+// other request revalidates against the ETag.
+const (
+	staticCacheVersioned   = "public, max-age=31536000, immutable"
+	staticCacheUnversioned = "public, no-cache"
+)
+
+type staticAsset struct {
+	content []byte
+	version string
+}
+
+// staticAssets are the embedded files under static/, keyed by name, with a
+// content hash that versions their URLs.
+var staticAssets = sync.OnceValue(func() map[string]staticAsset {
+	assets := map[string]staticAsset{}
+	entries, _ := fs.ReadDir(staticFS, "static")
+	for _, entry := range entries {
+		content, err := fs.ReadFile(staticFS, path.Join("static", entry.Name()))
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(content)
+		assets[entry.Name()] = staticAsset{
+			content: content,
+			version: hex.EncodeToString(sum[:6]),
+		}
+	}
+	return assets
+})
+
+// assetURL returns the versioned URL of a static asset, for the asset
+// template function.
+func assetURL(
+	name string,
+) string {
+	asset, ok := staticAssets()[name]
+	if !ok {
+		return "/static/" + name
+	}
+	return "/static/" + name + "?v=" + asset.version
+}
+
+func handleGetStatic(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	serveStatic(w, r, r.PathValue("name"))
+}
+
+// handleGetConsentJS serves the browser helper at a stable path for apps to
+// include from other origins. It is versioned like any other asset, and
+// shared openly so pages can load it with subresource integrity.
+func handleGetConsentJS(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	serveStatic(w, r, "consent.js")
+}
+
+func serveStatic(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+) {
+	asset, ok := staticAssets()[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("v") == asset.version {
+		w.Header().Set("Cache-Control", staticCacheVersioned)
+	} else {
+		w.Header().Set("Cache-Control", staticCacheUnversioned)
+	}
+	w.Header().Set("ETag", `"`+asset.version+`"`)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(asset.content))
+}
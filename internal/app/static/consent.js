@@ -0,0 +1,113 @@
+/*
+ * consent.js — browser helper for apps that sign in with consent.
+ *
+ * Include it from the consent server and name your integration:
+ *
+ *   <meta name="consent-csrf" content="{{ .CSRF }}">
+ *   <script src="https://auth.example.com/assets/consent.js"
+ *           data-integration="my-app" data-scope="identity profile"></script>
+ *
+ * The helper follows the conventions of the Go client (pkg/client): tokens
+ * stay in HttpOnly cookies the page never reads, and the CSRF secret from
+ * VerifyAuthorizationGetCSRF is sent back in the `csrf` query parameter or
+ * the Consent-CSRF header.
+ */
+(function (global) {
+	"use strict";
+
+	var VERSION = "1";
+	var CSRF_HEADER = "Consent-CSRF";
+
+	var script = document.currentScript;
+	var data = (script && script.dataset) || {};
+
+	var settings = {
+		server: script ? new URL(script.src, location.href).origin : location.origin,
+		integration: data.integration || "",
+		scopes: (data.scope || "").split(/\s+/).filter(Boolean),
+		logoutPath: data.logout || "/logout",
+		csrf: null
+	};
+
+	function configure(options) {
+		for (var key in options) {
+			if (Object.prototype.hasOwnProperty.call(settings, key)) {
+				settings[key] = options[key];
+			}
+		}
+	}
+
+	// csrf returns the secret set with configure, or else the one the page
+	// rendered into its consent-csrf meta tag.
+	function csrf() {
+		if (settings.csrf) {
+			return settings.csrf;
+		}
+		var meta = document.querySelector('meta[name="consent-csrf"]');
+		return meta ? meta.getAttribute("content") : "";
+	}
+
+	function loginURL(options) {
+		options = options || {};
+		var url = new URL("/authorize", settings.server);
+		url.searchParams.set("integration", options.integration || settings.integration);
+		(options.scopes || settings.scopes).forEach(function (scope) {
+			url.searchParams.append("scope", scope);
+		});
+		if (options.redirectURI) {
+			url.searchParams.set("redirect_uri", options.redirectURI);
+		}
+		if (options.state) {
+			url.searchParams.set("state", options.state);
+		}
+		if (options.maxAge !== undefined) {
+			url.searchParams.set("max_age", String(options.maxAge));
+		}
+		return url.toString();
+	}
+
+	function login(options) {
+		location.assign(loginURL(options));
+	}
+
+	// logout posts to the app's logout handler, which revokes the refresh
+	// token, clears the cookies, and redirects home.
+	function logout() {
+		var url = new URL(settings.logoutPath, location.href);
+		url.searchParams.set("csrf", csrf());
+		var form = document.createElement("form");
+		form.method = "POST";
+		form.action = url.toString();
+		document.body.appendChild(form);
+		form.submit();
+	}
+
+	// fetch adds the CSRF header to same-origin requests and sends the user
+	// to sign in when the app answers 401.
+	function consentFetch(input, init) {
+		init = init || {};
+		var request = new Request(input, init);
+		var headers = new Headers(request.headers);
+		if (new URL(request.url).origin === location.origin && csrf()) {
+			headers.set(CSRF_HEADER, csrf());
+		}
+		return global.fetch(new Request(request, { headers: headers, credentials: "same-origin" }))
+			.then(function (response) {
+				if (response.status === 401 && init.login !== false && settings.integration) {
+					login();
+				}
+				return response;
+			});
+	}
+
+	global.consent = {
+		version: VERSION,
+		csrfHeader: CSRF_HEADER,
+		configure: configure,
+		csrf: csrf,
+		loginURL: loginURL,
+		login: login,
+		logout: logout,
+		fetch: consentFetch
+	};
+})(window);
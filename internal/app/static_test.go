@@ -0,0 +1,84 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatic_ServesVersionedAssets(t *testing.T) {
+	appServer := &App{}
+	router := appServer.Router()
+
+	url := assetURL("consent.css")
+	if !strings.HasPrefix(url, "/static/consent.css?v=") {
+		t.Fatalf("assetURL = %q, want versioned URL", url)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/css") {
+		t.Errorf("Content-Type = %q, want text/css", got)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != staticCacheVersioned {
+		t.Errorf("Cache-Control = %q, want %q", got, staticCacheVersioned)
+	}
+	if !strings.Contains(rr.Body.String(), "prefers-color-scheme: dark") {
+		t.Errorf("expected dark theme in stylesheet")
+	}
+
+	// unversioned requests must revalidate, and matching ETags skip the body
+	req = httptest.NewRequest(http.MethodGet, "/static/consent.css", nil)
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotModified)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != staticCacheUnversioned {
+		t.Errorf("Cache-Control = %q, want %q", got, staticCacheUnversioned)
+	}
+}
+
+func TestStatic_UnknownAsset(t *testing.T) {
+	appServer := &App{}
+
+	req := httptest.NewRequest(http.MethodGet, "/static/missing.js", nil)
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestStatic_ServesConsentJS(t *testing.T) {
+	appServer := &App{}
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/consent.js", nil)
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/javascript") {
+		t.Errorf("Content-Type = %q, want text/javascript", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := rr.Header().Get("ETag"); got != `"`+staticAssets()["consent.js"].version+`"` {
+		t.Errorf("ETag = %q, want the asset version", got)
+	}
+	if !strings.Contains(rr.Body.String(), `"Consent-CSRF"`) {
+		t.Errorf("expected the Consent-CSRF header in the helper")
+	}
+}
@@ -3,43 +3,274 @@ package app
 import (
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
+	"maps"
+	"os"
 	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"git.sr.ht/~jakintosh/consent/internal/i18n"
 )
 
 //go:embed templates/*
 var templatesFS embed.FS
 
+// Branding is the deployment's identity shown on every page, available to
+// templates through the brand function.
+type Branding struct {
+	Name         string
+	Organization string
+}
+
+// DefaultBranding matches the built-in templates' original text.
+var DefaultBranding = Branding{
+	Name:         "Consent",
+	Organization: "Pollinator Network",
+}
+
+// TemplateOptions configures how page templates are loaded.
+type TemplateOptions struct {
+	// OverridesDir is an optional directory whose .html files replace the
+	// embedded template of the same name or add a new page. Changes to it
+	// are picked up on the next render unless Static is set.
+	OverridesDir string
+
+	// Static loads OverridesDir once and only re-reads it on Reload, for
+	// deployments that would rather not stat it on every render.
+	Static bool
+
+	// Branding is returned by the brand template function. Empty fields use
+	// DefaultBranding.
+	Branding Branding
+
+	// Catalog translates page text for the t function. Nil renders every
+	// page in English.
+	Catalog *i18n.Catalog
+
+	// Funcs are added to every template alongside the built-in functions.
+	Funcs template.FuncMap
+}
+
 type Templates struct {
-	pages map[string]*template.Template
+	mu      sync.RWMutex
+	pages   map[string]*template.Template
+	broken  map[string]error
+	catalog *i18n.Catalog
+
+	// hot reload of OverridesDir; reloadMu lets one render rebuild the
+	// pages while others keep reading the current set
+	reloadMu    sync.Mutex
+	load        func() (map[string]*template.Template, map[string]error, error)
+	dir         string
+	fingerprint string
+	static      bool
 }
 
-func NewTemplates() (
+func NewTemplates(
+	options TemplateOptions,
+) (
 	*Templates,
 	error,
 ) {
-	return newTemplatesFromFS(templatesFS)
+	funcs := templateFuncs(options)
+	if options.OverridesDir == "" {
+		templates, err := newTemplatesFromFS(templatesFS, funcs)
+		if err != nil {
+			return nil, err
+		}
+		templates.catalog = options.Catalog
+		return templates, nil
+	}
+
+	info, err := os.Stat(options.OverridesDir)
+	if err != nil {
+		return nil, fmt.Errorf("templates path: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("templates path %s is not a directory", options.OverridesDir)
+	}
+
+	overlay := overlayFS{
+		base:     templatesFS,
+		override: os.DirFS(options.OverridesDir),
+		dir:      "templates",
+	}
+	fingerprint, err := dirFingerprint(options.OverridesDir)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := newTemplatesFromFS(overlay, funcs)
+	if err != nil {
+		return nil, err
+	}
+	templates.catalog = options.Catalog
+	templates.load = func() (map[string]*template.Template, map[string]error, error) {
+		return parsePages(overlay, funcs)
+	}
+	templates.dir = options.OverridesDir
+	templates.fingerprint = fingerprint
+	templates.static = options.Static
+	return templates, nil
+}
+
+// localeFuncs are the per-render functions that translate page text into
+// locale.
+func localeFuncs(
+	catalog *i18n.Catalog,
+	locale string,
+) template.FuncMap {
+	return template.FuncMap{
+		"t": func(message string, args ...any) string {
+			return catalog.Translate(locale, message, args...)
+		},
+		"locale": func() string { return locale },
+	}
+}
+
+func templateFuncs(
+	options TemplateOptions,
+) template.FuncMap {
+	branding := options.Branding
+	if branding.Name == "" {
+		branding.Name = DefaultBranding.Name
+	}
+	if branding.Organization == "" {
+		branding.Organization = DefaultBranding.Organization
+	}
+
+	funcs := template.FuncMap{
+		"brand": func() Branding { return branding },
+		"asset": assetURL,
+	}
+	maps.Copy(funcs, localeFuncs(nil, i18n.SourceLocale))
+	maps.Copy(funcs, options.Funcs)
+	return funcs
+}
+
+// overlayFS serves files under dir from override when present, falling back
+// to base for everything else.
+type overlayFS struct {
+	base     fs.FS
+	override fs.FS
+	dir      string
+}
+
+func (o overlayFS) Open(
+	name string,
+) (
+	fs.File,
+	error,
+) {
+	if rel, ok := strings.CutPrefix(name, o.dir+"/"); ok {
+		file, err := o.override.Open(rel)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.base.Open(name)
+}
+
+func (o overlayFS) ReadDir(
+	name string,
+) (
+	[]fs.DirEntry,
+	error,
+) {
+	entries, err := fs.ReadDir(o.base, name)
+	if err != nil || name != o.dir {
+		return entries, err
+	}
+
+	overrides, err := fs.ReadDir(o.override, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]fs.DirEntry{}
+	for _, entry := range slices.Concat(entries, overrides) {
+		merged[entry.Name()] = entry
+	}
+	return slices.SortedFunc(maps.Values(merged), func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	}), nil
+}
+
+// dirFingerprint summarizes the names, sizes, and modification times of the
+// files in dir, so any edit changes it.
+func dirFingerprint(
+	dir string,
+) (
+	string,
+	error,
+) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("templates path: %w", err)
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed since ReadDir, e.g. an editor's temp file
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("templates path: %w", err)
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
 }
 
 func newTemplatesFromFS(
 	templateFS fs.FS,
+	funcs template.FuncMap,
 ) (
 	*Templates,
 	error,
 ) {
-	pageFiles, err := fs.Glob(templateFS, "templates/*.html")
+	pages, broken, err := parsePages(templateFS, funcs)
 	if err != nil {
 		return nil, err
 	}
+	if len(broken) > 0 {
+		return nil, joinPageErrors(broken)
+	}
+	return &Templates{pages: pages}, nil
+}
 
-	baseTemplate, err := template.ParseFS(templateFS, "templates/base.html")
+// parsePages parses every page against base.html. A page that fails to parse
+// is reported in broken rather than failing the others; only an unusable
+// base or an empty result is an error.
+func parsePages(
+	templateFS fs.FS,
+	funcs template.FuncMap,
+) (
+	pages map[string]*template.Template,
+	broken map[string]error,
+	err error,
+) {
+	pageFiles, err := fs.Glob(templateFS, "templates/*.html")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	pages := map[string]*template.Template{}
+	baseTemplate, err := template.New("base.html").Funcs(funcs).ParseFS(templateFS, "templates/base.html")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pages = map[string]*template.Template{}
+	broken = map[string]error{}
 	for _, pageFile := range pageFiles {
 		pageName := path.Base(pageFile)
 		if pageName == "base.html" {
@@ -48,23 +279,128 @@ func newTemplatesFromFS(
 
 		pageTemplate, err := baseTemplate.Clone()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if _, err := pageTemplate.ParseFS(templateFS, pageFile); err != nil {
-			return nil, err
+			broken[pageName] = err
+			continue
 		}
 
 		pages[pageName] = pageTemplate
 	}
 
 	if len(pages) == 0 {
-		return nil, fmt.Errorf("no renderable templates found")
+		if len(broken) > 0 {
+			return nil, nil, joinPageErrors(broken)
+		}
+		return nil, nil, fmt.Errorf("no renderable templates found")
 	}
 
-	return &Templates{pages: pages}, nil
+	return pages, broken, nil
+}
+
+// joinPageErrors combines per-page parse errors in a stable order.
+func joinPageErrors(
+	broken map[string]error,
+) error {
+	errs := make([]error, 0, len(broken))
+	for _, name := range slices.Sorted(maps.Keys(broken)) {
+		errs = append(errs, broken[name])
+	}
+	return errors.Join(errs...)
+}
+
+// reloadIfChanged re-parses the templates when the overrides directory has
+// changed since they were last loaded. The new set is built aside and swapped
+// in whole, so renders never see a half-loaded set. Pages that fail to parse
+// are recorded as broken while the rest are updated; if base.html itself
+// fails, the previous set is kept and the reload is retried on the next
+// render.
+func (t *Templates) reloadIfChanged() error {
+	if t.load == nil || t.static {
+		return nil
+	}
+
+	fingerprint, err := dirFingerprint(t.dir)
+	if err != nil {
+		return err
+	}
+	if t.loadedFingerprint() == fingerprint {
+		return nil
+	}
+
+	t.reloadMu.Lock()
+	defer t.reloadMu.Unlock()
+	// another render may have reloaded while this one waited
+	if t.loadedFingerprint() == fingerprint {
+		return nil
+	}
+
+	pages, broken, err := t.load()
+	if err != nil {
+		return fmt.Errorf("reload templates: %w", err)
+	}
+
+	t.mu.Lock()
+	t.pages = pages
+	t.broken = broken
+	t.fingerprint = fingerprint
+	t.mu.Unlock()
+	return nil
+}
+
+// Reload re-reads the overrides directory regardless of whether it has
+// changed and switches page text to catalog. Pages that fail to parse are
+// returned as an error, but the rest of the reload still takes effect.
+func (t *Templates) Reload(
+	catalog *i18n.Catalog,
+) error {
+	t.reloadMu.Lock()
+	defer t.reloadMu.Unlock()
+
+	var pages map[string]*template.Template
+	var broken map[string]error
+	var fingerprint string
+	if t.load != nil {
+		var err error
+		if fingerprint, err = dirFingerprint(t.dir); err != nil {
+			return err
+		}
+		if pages, broken, err = t.load(); err != nil {
+			return fmt.Errorf("reload templates: %w", err)
+		}
+	}
+
+	t.mu.Lock()
+	t.catalog = catalog
+	if t.load != nil {
+		t.pages = pages
+		t.broken = broken
+		t.fingerprint = fingerprint
+	}
+	t.mu.Unlock()
+
+	if len(broken) > 0 {
+		return joinPageErrors(broken)
+	}
+	return nil
+}
+
+// Catalog returns the catalog pages are currently translated with.
+func (t *Templates) Catalog() *i18n.Catalog {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.catalog
 }
 
+func (t *Templates) loadedFingerprint() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.fingerprint
+}
+
+// RenderTemplate executes a page in the default locale.
 func (t *Templates) RenderTemplate(
 	name string,
 	data any,
@@ -72,13 +408,45 @@ func (t *Templates) RenderTemplate(
 	[]byte,
 	error,
 ) {
+	return t.RenderLocalized(name, t.Catalog().DefaultLocale(), data)
+}
+
+// RenderLocalized executes a page into a buffer with its text translated into
+// locale, so a failing template never produces partial output. Pages are
+// cloned per render to bind the locale; the parsed originals are never
+// executed, which keeps them cloneable.
+func (t *Templates) RenderLocalized(
+	name string,
+	locale string,
+	data any,
+) (
+	[]byte,
+	error,
+) {
+	if err := t.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	t.mu.RLock()
 	pageTemplate, ok := t.pages[name]
+	brokenErr := t.broken[name]
+	catalog := t.catalog
+	t.mu.RUnlock()
+	if brokenErr != nil {
+		return nil, fmt.Errorf("template %s failed to load: %w", name, brokenErr)
+	}
 	if !ok {
 		return nil, fmt.Errorf("unknown template: %s", name)
 	}
 
+	localized, err := pageTemplate.Clone()
+	if err != nil {
+		return nil, err
+	}
+	localized.Funcs(localeFuncs(catalog, locale))
+
 	var buf bytes.Buffer
-	if err := pageTemplate.ExecuteTemplate(&buf, name, data); err != nil {
+	if err := localized.ExecuteTemplate(&buf, name, data); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -1,13 +1,18 @@
 package app
 
 import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"testing/fstest"
 )
 
 func TestNewTemplatesFromFS_LoadsRenderablePages(t *testing.T) {
-	templates, err := newTemplatesFromFS(testTemplateFS())
+	templates, err := newTemplatesFromFS(testTemplateFS(), nil)
 	if err != nil {
 		t.Fatalf("newTemplatesFromFS failed: %v", err)
 	}
@@ -56,7 +61,7 @@ func TestNewTemplatesFromFS_LoadsRenderablePages(t *testing.T) {
 }
 
 func TestTemplatesRenderTemplate_UnknownTemplate(t *testing.T) {
-	templates, err := newTemplatesFromFS(testTemplateFS())
+	templates, err := newTemplatesFromFS(testTemplateFS(), nil)
 	if err != nil {
 		t.Fatalf("newTemplatesFromFS failed: %v", err)
 	}
@@ -75,7 +80,7 @@ func TestNewTemplatesFromFS_NoRenderableTemplates(t *testing.T) {
 		"templates/base.html": {
 			Data: []byte(`{{define "base.html"}}<body>{{template "content" .}}</body>{{end}}`),
 		},
-	})
+	}, nil)
 	if err == nil {
 		t.Fatalf("expected no renderable templates error")
 	}
@@ -84,6 +89,273 @@ func TestNewTemplatesFromFS_NoRenderableTemplates(t *testing.T) {
 	}
 }
 
+func TestNewTemplatesFromFS_OverlayOverridesAndAddsPages(t *testing.T) {
+	templates, err := newTemplatesFromFS(overlayFS{
+		base: testTemplateFS(),
+		override: fstest.MapFS{
+			"login.html": {
+				Data: []byte(`{{define "content"}}custom login: {{.Message}}{{end}}{{template "base.html" .}}`),
+			},
+			"extra.html": {
+				Data: []byte(`{{define "content"}}extra: {{.Message}}{{end}}{{template "base.html" .}}`),
+			},
+		},
+		dir: "templates",
+	}, nil)
+	if err != nil {
+		t.Fatalf("newTemplatesFromFS failed: %v", err)
+	}
+
+	loginBytes, err := templates.RenderTemplate("login.html", map[string]string{"Message": "hello"})
+	if err != nil {
+		t.Fatalf("RenderTemplate(login.html) failed: %v", err)
+	}
+	if !strings.Contains(string(loginBytes), "custom login: hello") {
+		t.Fatalf("expected override login content, got %q", loginBytes)
+	}
+
+	// pages without an override still come from the base
+	homeBytes, err := templates.RenderTemplate("home.html", map[string]string{"Message": "hello"})
+	if err != nil {
+		t.Fatalf("RenderTemplate(home.html) failed: %v", err)
+	}
+	if !strings.Contains(string(homeBytes), "home: hello") {
+		t.Fatalf("expected base home content, got %q", homeBytes)
+	}
+
+	if _, err := templates.RenderTemplate("extra.html", map[string]string{"Message": "hello"}); err != nil {
+		t.Fatalf("RenderTemplate(extra.html) failed: %v", err)
+	}
+}
+
+func TestNewTemplates_OverridesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	custom := `{{define "style"}}{{end}}{{define "content"}}custom status{{end}}{{template "base.html" .}}`
+	if err := os.WriteFile(filepath.Join(dir, "status.html"), []byte(custom), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	templates, err := NewTemplates(TemplateOptions{OverridesDir: dir})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+	statusBytes, err := templates.RenderTemplate("status.html", nil)
+	if err != nil {
+		t.Fatalf("RenderTemplate(status.html) failed: %v", err)
+	}
+	if !strings.Contains(string(statusBytes), "custom status") {
+		t.Fatalf("expected override status content, got %q", statusBytes)
+	}
+
+	if _, err := NewTemplates(TemplateOptions{OverridesDir: filepath.Join(dir, "missing")}); err == nil {
+		t.Fatal("expected error for missing templates directory")
+	}
+}
+
+func TestNewTemplates_ReloadsChangedOverrides(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "status.html")
+	writePage := func(content string) {
+		t.Helper()
+		data := `{{define "style"}}{{end}}{{define "content"}}` + content + `{{end}}{{template "base.html" .}}`
+		if err := os.WriteFile(page, []byte(data), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	writePage("first version")
+	loaded, err := NewTemplates(TemplateOptions{OverridesDir: dir})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+	expectRender := func(want string) {
+		t.Helper()
+		out, err := loaded.RenderTemplate("status.html", nil)
+		if err != nil {
+			t.Fatalf("RenderTemplate failed: %v", err)
+		}
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("rendered %q, want %q", out, want)
+		}
+	}
+	expectRender("first version")
+
+	// a broken edit is reported without discarding the working templates
+	writePage("{{ if }}")
+	if _, err := loaded.RenderTemplate("status.html", nil); err == nil {
+		t.Fatal("expected reload error for broken template")
+	}
+
+	writePage("second version, now longer")
+	expectRender("second version, now longer")
+}
+
+func TestNewTemplates_ReloadKeepsPagesThatParse(t *testing.T) {
+	dir := t.TempDir()
+	writePage := func(name, content string) {
+		t.Helper()
+		data := `{{define "content"}}` + content + `{{end}}{{template "base.html" .}}`
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	writePage("status.html", "status v1")
+	writePage("extra.html", "extra v1")
+	loaded, err := NewTemplates(TemplateOptions{OverridesDir: dir})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+
+	// one bad file doesn't hold back the rest of the reload
+	writePage("status.html", "{{ if }}")
+	writePage("extra.html", "extra v2, updated")
+
+	if _, err := loaded.RenderTemplate("status.html", nil); err == nil {
+		t.Fatal("expected error for broken page")
+	}
+	out, err := loaded.RenderTemplate("extra.html", nil)
+	if err != nil {
+		t.Fatalf("RenderTemplate(extra.html) failed: %v", err)
+	}
+	if !strings.Contains(string(out), "extra v2, updated") {
+		t.Fatalf("rendered %q, want updated extra page", out)
+	}
+	if _, err := loaded.RenderTemplate("login.html", nil); err != nil {
+		t.Fatalf("RenderTemplate(login.html) failed: %v", err)
+	}
+}
+
+func TestNewTemplates_ReportsEveryBrokenPage(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"status.html", "login.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{{ if }}"), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	_, err := NewTemplates(TemplateOptions{OverridesDir: dir})
+	if err == nil {
+		t.Fatal("expected error for broken overrides")
+	}
+	for _, name := range []string{"status.html", "login.html"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+}
+
+func TestTemplates_ConcurrentRenderDuringReload(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "extra.html")
+	writePage := func(i int) {
+		// write aside and rename so renders never see a half-written file
+		data := fmt.Sprintf(`{{define "content"}}version %d{{end}}{{template "base.html" .}}`, i)
+		if err := os.WriteFile(page+".tmp", []byte(data), 0o644); err != nil {
+			t.Errorf("WriteFile failed: %v", err)
+		}
+		if err := os.Rename(page+".tmp", page); err != nil {
+			t.Errorf("Rename failed: %v", err)
+		}
+	}
+	writePage(0)
+	loaded, err := NewTemplates(TemplateOptions{OverridesDir: dir})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if _, err := loaded.RenderTemplate("extra.html", nil); err != nil {
+					t.Errorf("RenderTemplate failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 20; i++ {
+		writePage(i)
+	}
+	wg.Wait()
+}
+
+func TestNewTemplates_StaticReloadsOnlyOnDemand(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "extra.html")
+	writePage := func(content string) {
+		t.Helper()
+		data := `{{define "content"}}` + content + `{{end}}{{template "base.html" .}}`
+		if err := os.WriteFile(page, []byte(data), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	expectRender := func(loaded *Templates, want string) {
+		t.Helper()
+		out, err := loaded.RenderTemplate("extra.html", nil)
+		if err != nil {
+			t.Fatalf("RenderTemplate failed: %v", err)
+		}
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("rendered %q, want %q", out, want)
+		}
+	}
+
+	writePage("first version")
+	loaded, err := NewTemplates(TemplateOptions{OverridesDir: dir, Static: true})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+
+	writePage("second version, not yet loaded")
+	expectRender(loaded, "first version")
+
+	if err := loaded.Reload(nil); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	expectRender(loaded, "second version, not yet loaded")
+
+	// a broken page is reported but doesn't block the reload
+	if err := os.WriteFile(filepath.Join(dir, "status.html"), []byte("{{ if }}"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	writePage("third version")
+	if err := loaded.Reload(nil); err == nil || !strings.Contains(err.Error(), "status.html") {
+		t.Fatalf("Reload error = %v, want status.html parse error", err)
+	}
+	expectRender(loaded, "third version")
+}
+
+func TestNewTemplates_BrandAndCustomFuncs(t *testing.T) {
+	loaded, err := NewTemplates(TemplateOptions{
+		Branding: Branding{Name: "Acme ID"},
+		Funcs: template.FuncMap{
+			"shout": strings.ToUpper,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+
+	out, err := loaded.RenderTemplate("status.html", map[string]string{"Title": "t", "Message": "m"})
+	if err != nil {
+		t.Fatalf("RenderTemplate failed: %v", err)
+	}
+	if !strings.Contains(string(out), "<title>Acme ID</title>") {
+		t.Errorf("expected brand name in title")
+	}
+	// unset fields keep the defaults
+	if !strings.Contains(string(out), DefaultBranding.Organization) {
+		t.Errorf("expected default organization in header")
+	}
+
+	funcs := templateFuncs(TemplateOptions{Funcs: template.FuncMap{"shout": strings.ToUpper}})
+	if _, ok := funcs["shout"]; !ok {
+		t.Errorf("custom func missing from template funcs")
+	}
+}
+
 func testTemplateFS() fstest.MapFS {
 	return fstest.MapFS{
 		"templates/base.html": {
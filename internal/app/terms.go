@@ -0,0 +1,91 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+)
+
+type termsPageData struct {
+	Version  string
+	URL      string
+	ReturnTo string
+	CSRF     string
+	Error    string
+}
+
+// showPendingTerms renders the terms page in place of r's page when subject
+// has yet to accept the current terms of service, and reports whether it
+// did. Accepting them returns the user to r.
+func (a *App) showPendingTerms(
+	w http.ResponseWriter,
+	r *http.Request,
+	subject string,
+	csrf string,
+) (
+	bool,
+	*appError,
+) {
+	pending, err := a.service.TermsPending(r.Context(), subject)
+	if err != nil {
+		return false, appErr(errAuthorizePrepare, err)
+	}
+	if !pending {
+		return false, nil
+	}
+
+	terms := a.service.Terms()
+	a.returnTemplate(w, r, http.StatusOK, "terms.html", termsPageData{
+		Version:  terms.Version,
+		URL:      terms.URL,
+		ReturnTo: r.URL.RequestURI(),
+		CSRF:     csrf,
+	})
+	return true, nil
+}
+
+func (a *App) handlePostTerms(
+	w http.ResponseWriter,
+	r *http.Request,
+) *appError {
+	// parse form values
+	if err := r.ParseForm(); err != nil {
+		return appErr(errTermsFormInvalid, err)
+	}
+	csrf := r.FormValue("csrf")
+	version := r.FormValue("version")
+	returnTo := sanitizeReturnTo(r.FormValue("return_to"))
+
+	// validate user
+	accessToken, newCSRF, err := a.auth.Verifier.VerifyAuthorizationCheckCSRF(w, r, csrf)
+	if err != nil {
+		if errors.Is(err, client.ErrCSRFInvalid) {
+			return appErr(errAuthorizeCSRFExpired, err)
+		}
+		if !errors.Is(err, client.ErrTokenAbsent) {
+			logAppErr(r, "failed to verify terms submit: "+err.Error())
+		}
+		http.Redirect(w, r, a.loginURL(returnTo, ""), http.StatusSeeOther)
+		return nil
+	}
+
+	// record acceptance and carry on
+	if err := a.service.AcceptTerms(r.Context(), accessToken.Subject(), version); err != nil {
+		if errors.Is(err, service.ErrTermsOutdated) {
+			terms := a.service.Terms()
+			a.returnTemplate(w, r, http.StatusConflict, "terms.html", termsPageData{
+				Version:  terms.Version,
+				URL:      terms.URL,
+				ReturnTo: returnTo,
+				CSRF:     newCSRF,
+				Error:    "The terms changed while you were reading them. Read the new version and accept again.",
+			})
+			return nil
+		}
+		return appErr(errTermsAccept, err)
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+func TestAuthorize_PendingTermsMustBeAccepted(t *testing.T) {
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.Terms = service.Terms{Version: "2026-10", URL: "https://example.com/terms"}
+	})
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "https://integration.test/callback")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	tv := consenttesting.NewTestVerifier("consent.test", "consent.test")
+
+	appServer, err := New(Options{
+		Service: env.Service,
+		Auth: AuthConfig{
+			Verifier:  tv,
+			LoginURL:  "/login",
+			LogoutURL: "/logout",
+			Routes:    map[string]http.HandlerFunc{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// the terms page stands in for the approval page
+	authorizePath := "/authorize?integration=test-integration&scope=identity"
+	req, err := tv.AuthenticatedRequest(http.MethodGet, authorizePath, user.Subject)
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Terms of Service") || !strings.Contains(body, "https://example.com/terms") {
+		t.Fatalf("expected terms page content")
+	}
+	if strings.Contains(body, "Authorize Test Integration") {
+		t.Fatalf("approval page shown before the terms were accepted")
+	}
+
+	// accepting returns to the authorization request
+	_, csrf, err := tv.VerifyAuthorizationGetCSRF(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("VerifyAuthorizationGetCSRF failed: %v", err)
+	}
+	form := url.Values{
+		"version":   {"2026-10"},
+		"return_to": {authorizePath},
+		"csrf":      {csrf},
+	}
+	post := httptest.NewRequest(http.MethodPost, "/terms", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range req.Cookies() {
+		post.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, post)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusSeeOther, rr.Body.String())
+	}
+	if location := rr.Header().Get("Location"); location != authorizePath {
+		t.Fatalf("redirect = %q, want %q", location, authorizePath)
+	}
+	if pending, err := env.Service.TermsPending(t.Context(), user.Subject); err != nil || pending {
+		t.Fatalf("TermsPending = %t, %v, want false", pending, err)
+	}
+
+	rr = httptest.NewRecorder()
+	appServer.Router().ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "Authorize Test Integration") {
+		t.Fatalf("expected approval page after accepting the terms")
+	}
+}
@@ -3,10 +3,17 @@ package config
 import (
 	"bytes"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,15 +21,33 @@ const (
 	APIKeyFileName     = "api_key"
 	APIUrlPrefix       = "/api/v1"
 	AppName            = "consent"
+	AutocertDirName    = "autocert"
 	ConfigFileName     = "config.yaml"
 	DatabaseFileName   = "auth.db"
 	SecretsDirName     = "secrets"
 	SigningKeyFileName = "signing_key"
+	UpstreamSecretFmt  = "upstream_%s_client_secret"
 	VerifyKeyFileName  = "verification_key.der"
+	WebhookSecretFmt   = "webhook_%s_secret"
 )
 
+// StorageDriverSQLite is the only storage driver currently available.
+const StorageDriverSQLite = "sqlite"
+
+// maxAuthCodeLifetime mirrors the service limit, which follows RFC 6749's
+// ten-minute recommendation.
+const maxAuthCodeLifetime = 10 * time.Minute
+
 type Config struct {
-	Server ServerConfig `yaml:"server"`
+	Server    ServerConfig     `yaml:"server"`
+	Storage   StorageConfig    `yaml:"storage,omitempty"`
+	Upstreams []UpstreamConfig `yaml:"upstreams,omitempty"`
+	Webhooks  []WebhookConfig  `yaml:"webhooks,omitempty"`
+	Secrets   SecretsConfig    `yaml:"secrets,omitempty"`
+	Signing   SigningConfig    `yaml:"signing,omitempty"`
+	Passwords PasswordsConfig  `yaml:"passwords,omitempty"`
+	Terms     TermsConfig      `yaml:"terms,omitempty"`
+	Realms    []RealmConfig    `yaml:"realms,omitempty"`
 }
 
 type ServerConfig struct {
@@ -30,6 +55,145 @@ type ServerConfig struct {
 	AuthorityDomain string `yaml:"authorityDomain"`
 	Port            int    `yaml:"port"`
 	DevMode         bool   `yaml:"devMode"`
+
+	// AuthCodeLifetime is how long authorization codes stay valid, e.g.
+	// "30s". Zero uses the service default.
+	AuthCodeLifetime time.Duration `yaml:"authCodeLifetime,omitempty"`
+
+	// AccessTokenLifetime and RefreshTokenLifetime are the deployment-wide
+	// token lifetimes, e.g. "15m" and "168h". Zero uses the service
+	// defaults; integration policies may still override them.
+	AccessTokenLifetime  time.Duration `yaml:"accessTokenLifetime,omitempty"`
+	RefreshTokenLifetime time.Duration `yaml:"refreshTokenLifetime,omitempty"`
+
+	TLS TLSConfig `yaml:"tls,omitempty"`
+
+	CORS CORSConfig `yaml:"cors,omitempty"`
+
+	// TemplatesPath is an optional directory of page templates that
+	// override the built-in ones by file name.
+	TemplatesPath string `yaml:"templatesPath,omitempty"`
+
+	// StaticTemplates reads TemplatesPath and LocalesPath only at startup,
+	// on SIGHUP, and on POST /api/v1/admin/reload, instead of checking the
+	// templates for edits on every page render.
+	StaticTemplates bool `yaml:"staticTemplates,omitempty"`
+
+	// Maintenance starts the server in read-only maintenance mode: sign-ins
+	// and refreshes work, but registrations and edits are refused until an
+	// administrator turns it off.
+	Maintenance bool `yaml:"maintenance,omitempty"`
+
+	// Branding names the deployment on the login and consent pages.
+	Branding BrandingConfig `yaml:"branding,omitempty"`
+
+	// Locale is the page language, e.g. "es", used when a browser's
+	// Accept-Language names no supported locale. Unset means English.
+	Locale string `yaml:"locale,omitempty"`
+
+	// LocalesPath is an optional directory of <locale>.json message
+	// catalogs that add languages or replace built-in translations.
+	LocalesPath string `yaml:"localesPath,omitempty"`
+
+	// AccessLog is the request log format: "off", "common", or "json".
+	// Unset leaves it off unless serve runs with --verbose.
+	AccessLog string `yaml:"accessLog,omitempty"`
+}
+
+// BrandingConfig names the deployment in page templates. Name replaces
+// "Consent" and Organization replaces "Pollinator Network"; empty fields
+// keep those defaults.
+type BrandingConfig struct {
+	Name         string `yaml:"name,omitempty"`
+	Organization string `yaml:"organization,omitempty"`
+}
+
+// CORSConfig lets browser apps on other origins call the API with
+// credentials. AllowedOrigins are exact origins such as
+// "https://app.example.com"; IntegrationOrigins also allows the origin of
+// every registered integration redirect.
+type CORSConfig struct {
+	AllowedOrigins     []string `yaml:"allowedOrigins,omitempty"`
+	IntegrationOrigins bool     `yaml:"integrationOrigins,omitempty"`
+}
+
+// StorageConfig selects where consent keeps its state. Path overrides the
+// database file in the data directory. BusyTimeout and MaxOpenConns tune
+// the SQLite connection pool, and QueryTimeout bounds each store call; zero
+// uses the store's defaults.
+type StorageConfig struct {
+	Driver       string        `yaml:"driver,omitempty"`
+	Path         string        `yaml:"path,omitempty"`
+	BusyTimeout  time.Duration `yaml:"busyTimeout,omitempty"`
+	MaxOpenConns int           `yaml:"maxOpenConns,omitempty"`
+	QueryTimeout time.Duration `yaml:"queryTimeout,omitempty"`
+}
+
+// PasswordsConfig tunes password hashing. Cost is the bcrypt cost of new
+// hashes, from 10 (the default) to 31; each step doubles the work. Workers
+// bounds how many passwords are hashed or checked at once, so a burst of
+// registrations can't take every core; zero uses one per CPU.
+type PasswordsConfig struct {
+	Cost    int `yaml:"cost,omitempty"`
+	Workers int `yaml:"workers,omitempty"`
+}
+
+// TermsConfig asks users to accept a version of the terms of service, read
+// at URL, before apps are issued tokens for them. Changing Version asks
+// everyone to accept again; leaving it empty asks for nothing.
+type TermsConfig struct {
+	Version string `yaml:"version,omitempty"`
+	URL     string `yaml:"url,omitempty"`
+}
+
+// TLSConfig lets the server terminate TLS itself, either with a certificate
+// and key on disk or with certificates obtained automatically over ACME.
+// When TLS is on, plain HTTP on RedirectPort (80 by default) redirects to
+// HTTPS and, with Autocert, answers ACME challenges.
+type TLSConfig struct {
+	CertFile      string `yaml:"certFile,omitempty"`
+	KeyFile       string `yaml:"keyFile,omitempty"`
+	Autocert      bool   `yaml:"autocert,omitempty"`
+	AutocertEmail string `yaml:"autocertEmail,omitempty"`
+	RedirectPort  int    `yaml:"redirectPort,omitempty"`
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.Autocert || t.CertFile != "" || t.KeyFile != ""
+}
+
+// UpstreamConfig describes an external OIDC/OAuth provider users can log in
+// with. The client secret is read from the secrets provider, not config.yaml.
+type UpstreamConfig struct {
+	Name         string   `yaml:"name"`
+	Display      string   `yaml:"display,omitempty"`
+	AuthorizeURL string   `yaml:"authorizeURL"`
+	TokenURL     string   `yaml:"tokenURL"`
+	UserInfoURL  string   `yaml:"userInfoURL"`
+	ClientID     string   `yaml:"clientID"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+	SubjectClaim string   `yaml:"subjectClaim,omitempty"`
+	HandleClaim  string   `yaml:"handleClaim,omitempty"`
+}
+
+// WebhookConfig registers a URL to be sent identity events. Events limits the
+// event types it receives; empty means all of them. The signing secret is
+// read from the secrets provider, not config.yaml.
+type WebhookConfig struct {
+	Name   string   `yaml:"name"`
+	URL    string   `yaml:"url"`
+	Events []string `yaml:"events,omitempty"`
+}
+
+// RealmConfig hosts another, fully isolated deployment in the same process.
+// ConfigDir and DataDir hold the realm's own config.yaml, secrets, and
+// database, laid out as for a standalone server (see `consent init`).
+// Requests are routed to the realm by the host of its public URL.
+type RealmConfig struct {
+	Name      string `yaml:"name"`
+	ConfigDir string `yaml:"configDir"`
+	DataDir   string `yaml:"dataDir"`
 }
 
 type Paths struct {
@@ -44,10 +208,23 @@ type Paths struct {
 }
 
 type Overrides struct {
-	PublicURL       *string
-	AuthorityDomain *string
-	Port            *int
-	DevMode         *bool
+	PublicURL            *string
+	AuthorityDomain      *string
+	Port                 *int
+	DevMode              *bool
+	TLSCertFile          *string
+	TLSKeyFile           *string
+	Autocert             *bool
+	AuthCodeLifetime     *time.Duration
+	AccessTokenLifetime  *time.Duration
+	RefreshTokenLifetime *time.Duration
+	StoragePath          *string
+	AccessLog            *string
+	TemplatesPath        *string
+	StaticTemplates      *bool
+	Maintenance          *bool
+	Locale               *string
+	LocalesPath          *string
 }
 
 func Default() Config {
@@ -89,20 +266,46 @@ func Load(
 		return Config{}, err
 	}
 
+	return loadFile(paths.ConfigFile, false)
+}
+
+// LoadFile reads the config at path, which unlike the config directory's
+// default file must exist.
+func LoadFile(
+	path string,
+) (
+	Config,
+	error,
+) {
+	resolved, err := expandPath(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return loadFile(resolved, true)
+}
+
+func loadFile(
+	path string,
+	required bool,
+) (
+	Config,
+	error,
+) {
 	cfg := Default()
 
-	data, err := os.ReadFile(paths.ConfigFile)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(err) && !required {
 			return cfg, nil
 		}
-		return Config{}, fmt.Errorf("config: read %s: %w", paths.ConfigFile, err)
+		return Config{}, fmt.Errorf("config: read %s: %w", path, err)
 	}
 
 	decoder := yaml.NewDecoder(bytes.NewReader(data))
 	decoder.KnownFields(true)
 	if err := decoder.Decode(&cfg); err != nil {
-		return Config{}, fmt.Errorf("config: decode %s: %w", paths.ConfigFile, err)
+		return Config{}, fmt.Errorf("config: decode %s: %w", path, err)
 	}
 
 	cfg.Normalize()
@@ -195,6 +398,50 @@ func resolvePaths(
 func (c *Config) Normalize() {
 	c.Server.PublicURL = strings.TrimSpace(c.Server.PublicURL)
 	c.Server.AuthorityDomain = strings.TrimSpace(c.Server.AuthorityDomain)
+	c.Server.TLS.CertFile = strings.TrimSpace(c.Server.TLS.CertFile)
+	c.Server.TLS.KeyFile = strings.TrimSpace(c.Server.TLS.KeyFile)
+	c.Server.TLS.AutocertEmail = strings.TrimSpace(c.Server.TLS.AutocertEmail)
+	for i, origin := range c.Server.CORS.AllowedOrigins {
+		c.Server.CORS.AllowedOrigins[i] = strings.TrimRight(strings.TrimSpace(origin), "/")
+	}
+	c.Server.AccessLog = strings.ToLower(strings.TrimSpace(c.Server.AccessLog))
+	c.Server.TemplatesPath = strings.TrimSpace(c.Server.TemplatesPath)
+	c.Server.Branding.Name = strings.TrimSpace(c.Server.Branding.Name)
+	c.Server.Branding.Organization = strings.TrimSpace(c.Server.Branding.Organization)
+	c.Server.Locale = strings.TrimSpace(c.Server.Locale)
+	c.Server.LocalesPath = strings.TrimSpace(c.Server.LocalesPath)
+	c.Storage.Driver = strings.ToLower(strings.TrimSpace(c.Storage.Driver))
+	c.Storage.Path = strings.TrimSpace(c.Storage.Path)
+	c.Secrets.Provider = strings.ToLower(strings.TrimSpace(c.Secrets.Provider))
+	c.Secrets.Vault.Address = strings.TrimSpace(c.Secrets.Vault.Address)
+	c.Secrets.Vault.Mount = strings.TrimSpace(c.Secrets.Vault.Mount)
+	c.Secrets.Vault.Path = strings.TrimSpace(c.Secrets.Vault.Path)
+	c.Signing.Vault.Address = strings.TrimSpace(c.Signing.Vault.Address)
+	c.Signing.Vault.Mount = strings.TrimSpace(c.Signing.Vault.Mount)
+	c.Signing.Vault.Key = strings.TrimSpace(c.Signing.Vault.Key)
+	for i := range c.Upstreams {
+		upstream := &c.Upstreams[i]
+		upstream.Name = strings.TrimSpace(upstream.Name)
+		upstream.Display = strings.TrimSpace(upstream.Display)
+		upstream.AuthorizeURL = strings.TrimSpace(upstream.AuthorizeURL)
+		upstream.TokenURL = strings.TrimSpace(upstream.TokenURL)
+		upstream.UserInfoURL = strings.TrimSpace(upstream.UserInfoURL)
+		upstream.ClientID = strings.TrimSpace(upstream.ClientID)
+	}
+	for i := range c.Realms {
+		realm := &c.Realms[i]
+		realm.Name = strings.TrimSpace(realm.Name)
+		realm.ConfigDir = strings.TrimSpace(realm.ConfigDir)
+		realm.DataDir = strings.TrimSpace(realm.DataDir)
+	}
+	for i := range c.Webhooks {
+		webhook := &c.Webhooks[i]
+		webhook.Name = strings.TrimSpace(webhook.Name)
+		webhook.URL = strings.TrimSpace(webhook.URL)
+		for j := range webhook.Events {
+			webhook.Events[j] = strings.ToLower(strings.TrimSpace(webhook.Events[j]))
+		}
+	}
 }
 
 func (c Config) Validate() error {
@@ -214,6 +461,217 @@ func (c Config) Validate() error {
 		return fmt.Errorf("config: server.port must be between 1 and 65535")
 	}
 
+	if c.Server.AuthCodeLifetime < 0 || c.Server.AuthCodeLifetime > maxAuthCodeLifetime {
+		return fmt.Errorf("config: server.authCodeLifetime must be between 0s and %s", maxAuthCodeLifetime)
+	}
+
+	access, refresh := c.Server.AccessTokenLifetime, c.Server.RefreshTokenLifetime
+	if access < 0 || refresh < 0 {
+		return fmt.Errorf("config: server token lifetimes cannot be negative")
+	}
+	if access > 0 && refresh > 0 && access > refresh {
+		return fmt.Errorf("config: server.accessTokenLifetime cannot exceed server.refreshTokenLifetime")
+	}
+
+	if _, err := accesslog.ParseFormat(c.Server.AccessLog); err != nil {
+		return fmt.Errorf("config: server.accessLog: %w", err)
+	}
+
+	if c.Server.Locale != "" {
+		if _, err := language.Parse(c.Server.Locale); err != nil {
+			return fmt.Errorf("config: server.locale %q is not a valid language tag", c.Server.Locale)
+		}
+	}
+
+	if c.Storage.Driver != "" && c.Storage.Driver != StorageDriverSQLite {
+		return fmt.Errorf("config: storage.driver %q is not supported; use %q", c.Storage.Driver, StorageDriverSQLite)
+	}
+	if c.Storage.BusyTimeout < 0 {
+		return fmt.Errorf("config: storage.busyTimeout cannot be negative")
+	}
+	if c.Storage.MaxOpenConns < 0 {
+		return fmt.Errorf("config: storage.maxOpenConns cannot be negative")
+	}
+	if c.Storage.QueryTimeout < 0 {
+		return fmt.Errorf("config: storage.queryTimeout cannot be negative")
+	}
+
+	if err := c.Secrets.validate(); err != nil {
+		return fmt.Errorf("config: secrets: %w", err)
+	}
+	if c.Passwords.Cost != 0 && (c.Passwords.Cost < bcrypt.DefaultCost || c.Passwords.Cost > bcrypt.MaxCost) {
+		return fmt.Errorf("config: passwords.cost must be between %d and %d", bcrypt.DefaultCost, bcrypt.MaxCost)
+	}
+	if c.Passwords.Workers < 0 {
+		return fmt.Errorf("config: passwords.workers cannot be negative")
+	}
+	if c.Terms.Version != "" {
+		if parsed, err := url.Parse(c.Terms.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("config: terms.url must be an absolute http(s) URL")
+		}
+	} else if c.Terms.URL != "" {
+		return fmt.Errorf("config: terms.url requires terms.version")
+	}
+	if c.Signing.Workers < 0 {
+		return fmt.Errorf("config: signing.workers cannot be negative")
+	}
+	if c.Signing.Queue < 0 {
+		return fmt.Errorf("config: signing.queue cannot be negative")
+	}
+	if err := c.Signing.Vault.validate(); err != nil {
+		return fmt.Errorf("config: signing.vault: %w", err)
+	}
+
+	if err := c.Server.TLS.validate(c.Server.PublicURL); err != nil {
+		return fmt.Errorf("config: server.tls: %w", err)
+	}
+
+	for i, origin := range c.Server.CORS.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			return fmt.Errorf("config: server.cors.allowedOrigins[%d]: %w", i, err)
+		}
+	}
+
+	seen := make(map[string]struct{}, len(c.Upstreams))
+	for i, upstream := range c.Upstreams {
+		if err := upstream.validate(); err != nil {
+			return fmt.Errorf("config: upstreams[%d]: %w", i, err)
+		}
+		if _, ok := seen[upstream.Name]; ok {
+			return fmt.Errorf("config: upstreams[%d]: duplicate name %q", i, upstream.Name)
+		}
+		seen[upstream.Name] = struct{}{}
+	}
+
+	seen = make(map[string]struct{}, len(c.Webhooks))
+	for i, webhook := range c.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return fmt.Errorf("config: webhooks[%d]: %w", i, err)
+		}
+		if _, ok := seen[webhook.Name]; ok {
+			return fmt.Errorf("config: webhooks[%d]: duplicate name %q", i, webhook.Name)
+		}
+		seen[webhook.Name] = struct{}{}
+	}
+
+	seen = make(map[string]struct{}, len(c.Realms))
+	for i, realm := range c.Realms {
+		if err := realm.validate(); err != nil {
+			return fmt.Errorf("config: realms[%d]: %w", i, err)
+		}
+		if _, ok := seen[realm.Name]; ok {
+			return fmt.Errorf("config: realms[%d]: duplicate name %q", i, realm.Name)
+		}
+		seen[realm.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+func (t TLSConfig) validate(
+	publicURL string,
+) error {
+	if !t.Enabled() {
+		return nil
+	}
+	if t.Autocert && (t.CertFile != "" || t.KeyFile != "") {
+		return fmt.Errorf("autocert cannot be combined with certFile and keyFile")
+	}
+	if !t.Autocert && (t.CertFile == "" || t.KeyFile == "") {
+		return fmt.Errorf("certFile and keyFile must be set together")
+	}
+	if t.RedirectPort < 0 || t.RedirectPort > 65535 {
+		return fmt.Errorf("redirectPort must be between 1 and 65535")
+	}
+	if parsed, err := url.Parse(publicURL); err == nil && parsed.Scheme != "https" {
+		return fmt.Errorf("server.publicURL must use https when TLS is enabled")
+	}
+	return nil
+}
+
+// validateOrigin accepts a bare origin: scheme and host, without path,
+// query, or wildcard, since credentialed CORS cannot use "*".
+func validateOrigin(
+	origin string,
+) error {
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q must be an http or https origin", origin)
+	}
+	if parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+		return fmt.Errorf("%q must be an origin without path, query, or credentials", origin)
+	}
+	if strings.Contains(parsed.Host, "*") {
+		return fmt.Errorf("%q cannot contain a wildcard", origin)
+	}
+	return nil
+}
+
+func (u UpstreamConfig) validate() error {
+	if err := validateSecretName(u.Name); err != nil {
+		return err
+	}
+	if u.ClientID == "" {
+		return fmt.Errorf("clientID is required")
+	}
+
+	endpoints := []struct {
+		field string
+		value string
+	}{
+		{"authorizeURL", u.AuthorizeURL},
+		{"tokenURL", u.TokenURL},
+		{"userInfoURL", u.UserInfoURL},
+	}
+	for _, endpoint := range endpoints {
+		parsed, err := url.Parse(endpoint.value)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("%s must be an absolute URL with scheme and host", endpoint.field)
+		}
+	}
+
+	return nil
+}
+
+func (w WebhookConfig) validate() error {
+	if err := validateSecretName(w.Name); err != nil {
+		return err
+	}
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(service.EventTypes(), service.EventType(event)) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+func (r RealmConfig) validate() error {
+	if err := validateSecretName(r.Name); err != nil {
+		return err
+	}
+	if r.ConfigDir == "" || r.DataDir == "" {
+		return fmt.Errorf("configDir and dataDir are required")
+	}
+	return nil
+}
+
+// validateSecretName checks the name of a config entry whose secret is looked
+// up by name, so it must be safe in file and environment variable names.
+func validateSecretName(
+	name string,
+) error {
+	if name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("name %q may only contain lowercase letters, digits, and '-'", name)
+		}
+	}
 	return nil
 }
 
@@ -234,6 +692,45 @@ func (c Config) WithOverrides(
 	if overrides.DevMode != nil {
 		resolved.Server.DevMode = *overrides.DevMode
 	}
+	if overrides.TLSCertFile != nil {
+		resolved.Server.TLS.CertFile = *overrides.TLSCertFile
+	}
+	if overrides.TLSKeyFile != nil {
+		resolved.Server.TLS.KeyFile = *overrides.TLSKeyFile
+	}
+	if overrides.Autocert != nil {
+		resolved.Server.TLS.Autocert = *overrides.Autocert
+	}
+	if overrides.AuthCodeLifetime != nil {
+		resolved.Server.AuthCodeLifetime = *overrides.AuthCodeLifetime
+	}
+	if overrides.AccessTokenLifetime != nil {
+		resolved.Server.AccessTokenLifetime = *overrides.AccessTokenLifetime
+	}
+	if overrides.RefreshTokenLifetime != nil {
+		resolved.Server.RefreshTokenLifetime = *overrides.RefreshTokenLifetime
+	}
+	if overrides.StoragePath != nil {
+		resolved.Storage.Path = *overrides.StoragePath
+	}
+	if overrides.AccessLog != nil {
+		resolved.Server.AccessLog = *overrides.AccessLog
+	}
+	if overrides.TemplatesPath != nil {
+		resolved.Server.TemplatesPath = *overrides.TemplatesPath
+	}
+	if overrides.StaticTemplates != nil {
+		resolved.Server.StaticTemplates = *overrides.StaticTemplates
+	}
+	if overrides.Maintenance != nil {
+		resolved.Server.Maintenance = *overrides.Maintenance
+	}
+	if overrides.Locale != nil {
+		resolved.Server.Locale = *overrides.Locale
+	}
+	if overrides.LocalesPath != nil {
+		resolved.Server.LocalesPath = *overrides.LocalesPath
+	}
 
 	resolved.Normalize()
 	return resolved
@@ -6,12 +6,18 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/config"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
 func TestLoad_DefaultWhenConfigMissing(t *testing.T) {
@@ -22,7 +28,7 @@ func TestLoad_DefaultWhenConfigMissing(t *testing.T) {
 		t.Fatalf("Load failed: %v", err)
 	}
 
-	if cfg != config.Default() {
+	if !reflect.DeepEqual(cfg, config.Default()) {
 		t.Fatalf("Load() = %#v, want %#v", cfg, config.Default())
 	}
 }
@@ -164,6 +170,140 @@ func TestInit_IsNonDestructiveUnlessForced(t *testing.T) {
 	}
 }
 
+func TestKeygen_WritesMatchingKeyPair(t *testing.T) {
+	t.Parallel()
+
+	outDir := filepath.Join(t.TempDir(), "keys")
+	result, err := config.Keygen(outDir, false)
+	if err != nil {
+		t.Fatalf("Keygen failed: %v", err)
+	}
+
+	signingInfo, err := os.Stat(result.SigningKeyFile)
+	if err != nil {
+		t.Fatalf("Stat signing key failed: %v", err)
+	}
+	if mode := signingInfo.Mode().Perm(); mode != 0o600 {
+		t.Errorf("signing key mode = %o, want 600", mode)
+	}
+	verificationInfo, err := os.Stat(result.VerificationKeyFile)
+	if err != nil {
+		t.Fatalf("Stat verification key failed: %v", err)
+	}
+	if mode := verificationInfo.Mode().Perm(); mode != 0o644 {
+		t.Errorf("verification key mode = %o, want 644", mode)
+	}
+
+	signingDER, err := os.ReadFile(result.SigningKeyFile)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	signingKey, err := x509.ParseECPrivateKey(signingDER)
+	if err != nil {
+		t.Fatalf("ParseECPrivateKey failed: %v", err)
+	}
+	verificationDER, err := os.ReadFile(result.VerificationKeyFile)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	verificationKey, err := x509.ParsePKIXPublicKey(verificationDER)
+	if err != nil {
+		t.Fatalf("ParsePKIXPublicKey failed: %v", err)
+	}
+	if !signingKey.PublicKey.Equal(verificationKey) || !result.VerificationKey.Equal(verificationKey) {
+		t.Fatal("verification key does not match signing key")
+	}
+
+	if _, err := config.Keygen(outDir, false); err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Fatalf("second Keygen error = %v, want overwrite refusal", err)
+	}
+	if _, err := config.Keygen(outDir, true); err != nil {
+		t.Fatalf("forced Keygen failed: %v", err)
+	}
+}
+
+func TestResolve_AcceptsPEMSigningKey(t *testing.T) {
+	t.Parallel()
+
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	result, err := config.Init(configDir, dataDir, config.InitOptions{})
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	if err := os.WriteFile(result.Paths.SigningKeyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{RequireSigningKey: true})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if !runtime.Secrets.SigningKey.Equal(key) {
+		t.Fatal("SigningKey does not match the PEM key")
+	}
+}
+
+func TestResolve_DecryptsSigningKey(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	result, err := config.Init(configDir, dataDir, config.InitOptions{})
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	encrypted, err := tokens.EncryptPrivateKey(key, []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey failed: %v", err)
+	}
+	if err := os.WriteFile(result.Paths.SigningKeyFile, encrypted, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// with no passphrase source, resolving fails
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	opts := config.RuntimeOptions{RequireSigningKey: true}
+	if _, err := config.Resolve(configDir, dataDir, opts); err == nil {
+		t.Fatal("Resolve succeeded without a passphrase")
+	}
+
+	// the prompt supplies it
+	opts.PromptPassphrase = func() ([]byte, error) { return []byte("hunter2"), nil }
+	runtime, err := config.Resolve(configDir, dataDir, opts)
+	if err != nil {
+		t.Fatalf("Resolve with prompt failed: %v", err)
+	}
+	if !runtime.Secrets.SigningKey.Equal(key) {
+		t.Fatal("SigningKey does not match the encrypted key")
+	}
+
+	// a systemd credential takes precedence over the prompt
+	credentials := t.TempDir()
+	credential := filepath.Join(credentials, config.SigningKeyPassphraseCredential)
+	if err := os.WriteFile(credential, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+	opts.PromptPassphrase = func() ([]byte, error) { return []byte("wrong"), nil }
+	if _, err := config.Resolve(configDir, dataDir, opts); err != nil {
+		t.Fatalf("Resolve with credential failed: %v", err)
+	}
+}
+
 func generateSigningKeyBase64() (string, error) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -177,3 +317,803 @@ func generateSigningKeyBase64() (string, error) {
 
 	return base64.StdEncoding.EncodeToString(privateDER), nil
 }
+
+func TestResolve_UpstreamSecretAndRedirect(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	if err := config.Save(configDir, dataDir, config.Config{
+		Server: config.ServerConfig{
+			PublicURL:       "https://consent.example.test",
+			AuthorityDomain: "consent.example.test",
+			Port:            9001,
+		},
+		Upstreams: []config.UpstreamConfig{{
+			Name:         "corp-sso",
+			AuthorizeURL: "https://idp.example.test/authorize",
+			TokenURL:     "https://idp.example.test/token",
+			UserInfoURL:  "https://idp.example.test/userinfo",
+			ClientID:     "consent",
+		}},
+	}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	t.Setenv(config.UpstreamSecretEnv("corp-sso"), "upstream-secret")
+
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	if len(runtime.Upstreams) != 1 {
+		t.Fatalf("Upstreams = %d, want 1", len(runtime.Upstreams))
+	}
+	upstream := runtime.Upstreams[0]
+	if upstream.ClientSecret != "upstream-secret" {
+		t.Fatalf("ClientSecret = %q, want env value", upstream.ClientSecret)
+	}
+	if upstream.RedirectURL != "https://consent.example.test/login/with/corp-sso/callback" {
+		t.Fatalf("RedirectURL = %q, want callback under public URL", upstream.RedirectURL)
+	}
+}
+
+func TestValidate_RejectsInvalidUpstreams(t *testing.T) {
+	t.Parallel()
+
+	valid := config.UpstreamConfig{
+		Name:         "corp",
+		AuthorizeURL: "https://idp.example.test/authorize",
+		TokenURL:     "https://idp.example.test/token",
+		UserInfoURL:  "https://idp.example.test/userinfo",
+		ClientID:     "consent",
+	}
+	badName := valid
+	badName.Name = "Corp SSO"
+	relativeURL := valid
+	relativeURL.TokenURL = "/token"
+
+	cases := map[string][]config.UpstreamConfig{
+		"bad name":     {badName},
+		"relative url": {relativeURL},
+		"duplicate":    {valid, valid},
+	}
+	for name, upstreams := range cases {
+		cfg := config.Default()
+		cfg.Upstreams = upstreams
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestResolve_WebhookSecret(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	if err := config.Save(configDir, dataDir, config.Config{
+		Server: config.ServerConfig{
+			PublicURL:       "https://consent.example.test",
+			AuthorityDomain: "consent.example.test",
+			Port:            9001,
+		},
+		Webhooks: []config.WebhookConfig{{
+			Name:   "provisioner",
+			URL:    "https://hooks.example.test/consent",
+			Events: []string{"user.registered", "user.deleted"},
+		}},
+	}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// a missing secret is an error
+	if _, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{}); err == nil {
+		t.Fatal("Resolve without webhook secret = nil error, want error")
+	}
+
+	t.Setenv(config.WebhookSecretEnv("provisioner"), "webhook-secret")
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(runtime.Webhooks) != 1 {
+		t.Fatalf("Webhooks = %d, want 1", len(runtime.Webhooks))
+	}
+	webhook := runtime.Webhooks[0]
+	if webhook.Secret != "webhook-secret" {
+		t.Fatalf("Secret = %q, want env value", webhook.Secret)
+	}
+	if len(webhook.Events) != 2 {
+		t.Fatalf("Events = %v, want two events", webhook.Events)
+	}
+}
+
+func TestValidate_RejectsInvalidWebhooks(t *testing.T) {
+	t.Parallel()
+
+	valid := config.WebhookConfig{
+		Name: "provisioner",
+		URL:  "https://hooks.example.test/consent",
+	}
+	badName := valid
+	badName.Name = "Provisioner"
+	relativeURL := valid
+	relativeURL.URL = "/hooks"
+	unknownEvent := valid
+	unknownEvent.Events = []string{"user.updated"}
+
+	cases := map[string][]config.WebhookConfig{
+		"bad name":      {badName},
+		"relative url":  {relativeURL},
+		"unknown event": {unknownEvent},
+		"duplicate":     {valid, valid},
+	}
+	for name, webhooks := range cases {
+		cfg := config.Default()
+		cfg.Webhooks = webhooks
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func saveWebhookConfig(
+	t *testing.T,
+	secrets config.SecretsConfig,
+) (
+	string,
+	string,
+) {
+	t.Helper()
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := config.Save(configDir, dataDir, config.Config{
+		Server: config.ServerConfig{
+			PublicURL:       "https://consent.example.test",
+			AuthorityDomain: "consent.example.test",
+			Port:            9001,
+		},
+		Webhooks: []config.WebhookConfig{{
+			Name: "provisioner",
+			URL:  "https://hooks.example.test/consent",
+		}},
+		Secrets: secrets,
+	}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	return configDir, dataDir
+}
+
+func TestResolve_SystemdSecrets(t *testing.T) {
+	configDir, dataDir := saveWebhookConfig(t, config.SecretsConfig{Provider: "systemd"})
+
+	credentials := t.TempDir()
+	if err := os.WriteFile(filepath.Join(credentials, "webhook_provisioner_secret"), []byte("credential-secret\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if runtime.Webhooks[0].Secret != "credential-secret" {
+		t.Fatalf("Secret = %q, want credential value", runtime.Webhooks[0].Secret)
+	}
+	if view := runtime.View(); view.Secrets.Provider != "systemd" {
+		t.Fatalf("View provider = %q, want systemd", view.Secrets.Provider)
+	}
+}
+
+func TestResolve_VaultSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/data/consent/prod" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"webhook_provisioner_secret":"vault-secret"}}}`))
+	}))
+	t.Cleanup(vault.Close)
+	configDir, dataDir := saveWebhookConfig(t, config.SecretsConfig{
+		Provider: "vault",
+		Vault:    config.VaultConfig{Address: vault.URL, Mount: "kv", Path: "consent/prod"},
+	})
+
+	// without a token vault can't be read
+	t.Setenv(config.EnvVaultToken, "")
+	if _, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{}); err == nil {
+		t.Fatal("Resolve without vault token = nil error, want error")
+	}
+
+	t.Setenv(config.EnvVaultToken, "vault-token")
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if runtime.Webhooks[0].Secret != "vault-secret" {
+		t.Fatalf("Secret = %q, want vault value", runtime.Webhooks[0].Secret)
+	}
+
+	// the environment still overrides the provider
+	t.Setenv(config.WebhookSecretEnv("provisioner"), "env-secret")
+	runtime, err = config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if runtime.Webhooks[0].Secret != "env-secret" {
+		t.Fatalf("Secret = %q, want env value", runtime.Webhooks[0].Secret)
+	}
+}
+
+func TestResolve_EnvSecretsIgnoreFiles(t *testing.T) {
+	configDir, dataDir := saveWebhookConfig(t, config.SecretsConfig{Provider: "env"})
+	secretsDir := filepath.Join(configDir, config.SecretsDirName)
+	if err := os.MkdirAll(secretsDir, 0o700); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(secretsDir, "webhook_provisioner_secret"), []byte("file-secret"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	_, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err == nil || !strings.Contains(err.Error(), "CONSENT_WEBHOOK_PROVISIONER_SECRET") {
+		t.Fatalf("Resolve err = %v, want hint naming the variable", err)
+	}
+}
+
+func TestResolve_FileEnvVariables(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	mounted := t.TempDir()
+	writeMounted := func(name string, content string) string {
+		t.Helper()
+		path := filepath.Join(mounted, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		return path
+	}
+
+	encodedKey, err := generateSigningKeyBase64()
+	if err != nil {
+		t.Fatalf("generateSigningKeyBase64 failed: %v", err)
+	}
+	databaseFile := filepath.Join(t.TempDir(), "consent.db")
+	t.Setenv(config.EnvSigningKeyDERBase64+config.EnvFileSuffix, writeMounted("signing_key", encodedKey+"\n"))
+	t.Setenv(config.EnvBootstrapAPIKey+config.EnvFileSuffix, writeMounted("bootstrap_api_key", "bootstrap.from.file\n"))
+	t.Setenv(config.EnvStoragePath+config.EnvFileSuffix, writeMounted("storage_path", databaseFile+"\n"))
+	t.Setenv(config.EnvAuthorityDomain+config.EnvFileSuffix, writeMounted("authority_domain", "file.test"))
+	t.Setenv(config.EnvAuthorityDomain, "env.test")
+
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{
+		RequireSigningKey:      true,
+		RequireBootstrapAPIKey: true,
+	})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	if runtime.Secrets.SigningKey == nil || runtime.Source.SigningKeySource != config.SecretSourceEnv {
+		t.Errorf("SigningKey source = %q, want the key from the mounted file", runtime.Source.SigningKeySource)
+	}
+	if runtime.Secrets.BootstrapAPIKey != "bootstrap.from.file" {
+		t.Errorf("BootstrapAPIKey = %q, want bootstrap.from.file", runtime.Secrets.BootstrapAPIKey)
+	}
+	if runtime.Paths.DatabaseFile != databaseFile {
+		t.Errorf("DatabaseFile = %q, want %q", runtime.Paths.DatabaseFile, databaseFile)
+	}
+
+	// the variable itself wins over its file
+	if runtime.Server.AuthorityDomain != "env.test" {
+		t.Errorf("AuthorityDomain = %q, want env.test", runtime.Server.AuthorityDomain)
+	}
+
+	t.Setenv(config.EnvPort+config.EnvFileSuffix, filepath.Join(mounted, "missing"))
+	if _, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{}); err == nil || !strings.Contains(err.Error(), "CONSENT_PORT_FILE") {
+		t.Fatalf("Resolve err = %v, want an error naming CONSENT_PORT_FILE", err)
+	}
+}
+
+func TestValidate_RejectsInvalidSecrets(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]config.SecretsConfig{
+		"unknown provider":   {Provider: "keychain"},
+		"vault without path": {Provider: "vault"},
+		"relative vault url": {Provider: "vault", Vault: config.VaultConfig{Address: "vault:8200", Path: "consent"}},
+	}
+	for name, secrets := range cases {
+		cfg := config.Default()
+		cfg.Secrets = secrets
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestValidate_RejectsInvalidPasswords(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]config.PasswordsConfig{
+		"cost below default": {Cost: 4},
+		"cost above max":     {Cost: 32},
+		"negative workers":   {Workers: -1},
+	}
+	for name, passwords := range cases {
+		cfg := config.Default()
+		cfg.Passwords = passwords
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestValidate_RejectsInvalidTerms(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]config.TermsConfig{
+		"url without version": {URL: "https://example.com/terms"},
+		"version without url": {Version: "2026-10"},
+		"relative url":        {Version: "2026-10", URL: "/terms"},
+	}
+	for name, terms := range cases {
+		cfg := config.Default()
+		cfg.Terms = terms
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestResolve_VaultSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	public := strings.ReplaceAll(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), "\n", `\n`)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/transit/keys/consent" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"type":"ecdsa-p256","latest_version":1,"keys":{"1":{"public_key":"` + public + `"}}}}`))
+	}))
+	t.Cleanup(vault.Close)
+
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Signing.Vault = config.VaultTransitConfig{Key: "consent"}
+	if err := config.Save(configDir, dataDir, cfg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	t.Setenv(config.EnvVaultAddress, vault.URL)
+	t.Setenv(config.EnvVaultToken, "vault-token")
+
+	// the server signs in vault, with no signing key on disk
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{RequireSigningKey: true})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if runtime.Secrets.SigningKey != nil || runtime.Secrets.Signer == nil {
+		t.Fatal("expected a vault signer and no signing key")
+	}
+	if !runtime.Secrets.VerificationKey().Equal(&key.PublicKey) {
+		t.Fatal("VerificationKey does not match the vault key")
+	}
+	if runtime.Source.SigningKeySource != config.SecretSourceVault {
+		t.Fatalf("SigningKeySource = %q, want vault", runtime.Source.SigningKeySource)
+	}
+
+	// commands that don't sign leave vault alone
+	t.Setenv(config.EnvVaultToken, "")
+	runtime, err = config.Resolve(configDir, dataDir, config.RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if runtime.Secrets.Signer != nil {
+		t.Fatal("expected no signer when none is required")
+	}
+	if _, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{RequireSigningKey: true}); err == nil {
+		t.Fatal("Resolve without vault token = nil error, want error")
+	}
+}
+
+func TestValidate_RejectsInvalidSigning(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]config.SigningConfig{
+		"address without key": {Vault: config.VaultTransitConfig{Address: "https://vault.example.test"}},
+		"relative vault url":  {Vault: config.VaultTransitConfig{Address: "vault:8200", Key: "consent"}},
+		"negative workers":    {Workers: -1},
+		"negative queue":      {Workers: 4, Queue: -1},
+	}
+	for name, signing := range cases {
+		cfg := config.Default()
+		cfg.Signing = signing
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestLoad_AuthCodeLifetime(t *testing.T) {
+	t.Parallel()
+
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	payload := []byte("server:\n  publicURL: http://localhost:9001\n  authorityDomain: localhost\n  port: 9001\n  authCodeLifetime: 45s\n")
+	if err := os.WriteFile(filepath.Join(configDir, config.ConfigFileName), payload, 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	cfg, err := config.Load(configDir, dataDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Server.AuthCodeLifetime != 45*time.Second {
+		t.Fatalf("AuthCodeLifetime = %v, want 45s", cfg.Server.AuthCodeLifetime)
+	}
+
+	cfg.Server.AuthCodeLifetime = time.Hour
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate() = nil, want error for lifetime above ten minutes")
+	}
+}
+
+func TestValidate_TLS(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		publicURL string
+		tls       config.TLSConfig
+		valid     bool
+	}{
+		"off":                {"http://localhost:9001", config.TLSConfig{}, true},
+		"files":              {"https://consent.example.test", config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, true},
+		"autocert":           {"https://consent.example.test", config.TLSConfig{Autocert: true}, true},
+		"cert without key":   {"https://consent.example.test", config.TLSConfig{CertFile: "cert.pem"}, false},
+		"autocert and files": {"https://consent.example.test", config.TLSConfig{Autocert: true, CertFile: "cert.pem", KeyFile: "key.pem"}, false},
+		"http public url":    {"http://consent.example.test", config.TLSConfig{Autocert: true}, false},
+		"bad redirect port":  {"https://consent.example.test", config.TLSConfig{Autocert: true, RedirectPort: 70000}, false},
+	}
+	for name, tc := range cases {
+		cfg := config.Default()
+		cfg.Server.PublicURL = tc.publicURL
+		cfg.Server.TLS = tc.tls
+		err := cfg.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: Validate() = %v, want nil", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestResolve_TLSOverrides(t *testing.T) {
+	t.Parallel()
+
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	publicURL := "https://consent.example.test"
+	autocert := true
+
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{
+		Overrides: config.Overrides{
+			PublicURL: &publicURL,
+			Autocert:  &autocert,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	tls := runtime.Server.TLS
+	if !tls.Enabled || !tls.Autocert {
+		t.Fatalf("TLS = %+v, want autocert enabled", tls)
+	}
+	if tls.AutocertHost != "consent.example.test" {
+		t.Errorf("AutocertHost = %q, want consent.example.test", tls.AutocertHost)
+	}
+	if tls.AutocertDir != filepath.Join(runtime.Paths.DataDir, config.AutocertDirName) {
+		t.Errorf("AutocertDir = %q, want under data dir", tls.AutocertDir)
+	}
+	if tls.RedirectAddress != ":80" {
+		t.Errorf("RedirectAddress = %q, want :80", tls.RedirectAddress)
+	}
+}
+
+func TestResolveDev_GeneratesSecretsInMemory(t *testing.T) {
+	t.Setenv(config.EnvAuthorityDomain, "env.test")
+	t.Setenv(config.EnvPort, "8001")
+
+	flagPort := 7001
+	runtime, err := config.ResolveDev(config.Overrides{Port: &flagPort})
+	if err != nil {
+		t.Fatalf("ResolveDev failed: %v", err)
+	}
+
+	if runtime.Paths.DatabaseFile != config.DevDatabaseFile {
+		t.Errorf("DatabaseFile = %q, want %q", runtime.Paths.DatabaseFile, config.DevDatabaseFile)
+	}
+	if runtime.Secrets.SigningKey == nil || runtime.Secrets.BootstrapAPIKey == "" {
+		t.Error("want a generated signing key and bootstrap API key")
+	}
+
+	// defaults, then the environment, then flags
+	if runtime.Server.PublicURL != "http://localhost:7001" {
+		t.Errorf("PublicURL = %q, want the default host at the flag's port", runtime.Server.PublicURL)
+	}
+	if runtime.Server.AuthorityDomain != "env.test" {
+		t.Errorf("AuthorityDomain = %q, want env.test from the environment", runtime.Server.AuthorityDomain)
+	}
+	if runtime.Server.Port != 7001 {
+		t.Errorf("Port = %d, want 7001 from the flag", runtime.Server.Port)
+	}
+}
+
+func TestResolve_ConfigFileEnvAndFlagPrecedence(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "cfg")
+	dataDir := filepath.Join(t.TempDir(), "data")
+	configFile := filepath.Join(t.TempDir(), "consent.yaml")
+
+	payload := []byte("server:\n  publicURL: http://file.test:9001\n  authorityDomain: file.test\n  port: 9001\n  accessTokenLifetime: 15m\n  refreshTokenLifetime: 168h\nstorage:\n  driver: sqlite\n")
+	if err := os.WriteFile(configFile, payload, 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	databaseFile := filepath.Join(t.TempDir(), "consent.db")
+	t.Setenv(config.EnvAuthorityDomain, "env.test")
+	t.Setenv(config.EnvPort, "8001")
+	t.Setenv(config.EnvAccessTokenLifetime, "5m")
+	t.Setenv(config.EnvStoragePath, databaseFile)
+	t.Setenv(config.EnvMaintenance, "true")
+
+	flagPort := 7001
+	runtime, err := config.Resolve(configDir, dataDir, config.RuntimeOptions{
+		ConfigFile: configFile,
+		Overrides:  config.Overrides{Port: &flagPort},
+	})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	// file values apply unless the environment overrides them, and flags
+	// override both
+	if runtime.Paths.ConfigFile != configFile || !runtime.Source.ConfigFilePresent {
+		t.Errorf("ConfigFile = %q (present %t), want %q", runtime.Paths.ConfigFile, runtime.Source.ConfigFilePresent, configFile)
+	}
+	if runtime.Server.PublicURL != "http://file.test:9001" {
+		t.Errorf("PublicURL = %q, want file value", runtime.Server.PublicURL)
+	}
+	if runtime.Server.AuthorityDomain != "env.test" {
+		t.Errorf("AuthorityDomain = %q, want env value", runtime.Server.AuthorityDomain)
+	}
+	if runtime.Server.Port != flagPort {
+		t.Errorf("Port = %d, want flag value %d", runtime.Server.Port, flagPort)
+	}
+	if runtime.Server.AccessTokenLifetime != 5*time.Minute || runtime.Server.RefreshTokenLifetime != 168*time.Hour {
+		t.Errorf("lifetimes = %v/%v, want 5m/168h", runtime.Server.AccessTokenLifetime, runtime.Server.RefreshTokenLifetime)
+	}
+	if runtime.Paths.DatabaseFile != databaseFile {
+		t.Errorf("DatabaseFile = %q, want %q", runtime.Paths.DatabaseFile, databaseFile)
+	}
+	if !runtime.Server.Maintenance {
+		t.Error("Maintenance = false, want env value")
+	}
+}
+
+func TestResolve_InvalidEnvOverride(t *testing.T) {
+	t.Setenv(config.EnvPort, "not-a-port")
+
+	_, err := config.Resolve(filepath.Join(t.TempDir(), "cfg"), filepath.Join(t.TempDir(), "data"), config.RuntimeOptions{})
+	if err == nil || !strings.Contains(err.Error(), config.EnvPort) {
+		t.Fatalf("Resolve error = %v, want error naming %s", err, config.EnvPort)
+	}
+}
+
+func TestLoadFile_RequiresFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := config.LoadFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Fatal("LoadFile() = nil, want error for missing file")
+	}
+}
+
+func TestValidate_TokenLifetimesAndStorage(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		mutate func(*config.Config)
+		valid  bool
+	}{
+		"defaults": {func(c *config.Config) {}, true},
+		"lifetimes": {func(c *config.Config) {
+			c.Server.AccessTokenLifetime, c.Server.RefreshTokenLifetime = time.Minute, time.Hour
+		}, true},
+		"negative lifetime": {func(c *config.Config) { c.Server.RefreshTokenLifetime = -time.Hour }, false},
+		"access over refresh": {func(c *config.Config) {
+			c.Server.AccessTokenLifetime, c.Server.RefreshTokenLifetime = 2*time.Hour, time.Hour
+		}, false},
+		"sqlite driver":  {func(c *config.Config) { c.Storage.Driver = config.StorageDriverSQLite }, true},
+		"unknown driver": {func(c *config.Config) { c.Storage.Driver = "postgres" }, false},
+		"pool tuning": {func(c *config.Config) {
+			c.Storage.BusyTimeout, c.Storage.MaxOpenConns = 10*time.Second, 8
+		}, true},
+		"negative busy timeout":  {func(c *config.Config) { c.Storage.BusyTimeout = -time.Second }, false},
+		"negative pool size":     {func(c *config.Config) { c.Storage.MaxOpenConns = -1 }, false},
+		"negative query timeout": {func(c *config.Config) { c.Storage.QueryTimeout = -time.Second }, false},
+		"json access log":        {func(c *config.Config) { c.Server.AccessLog = "json" }, true},
+		"unknown access log":     {func(c *config.Config) { c.Server.AccessLog = "apache" }, false},
+	}
+	for name, tc := range cases {
+		cfg := config.Default()
+		tc.mutate(&cfg)
+		err := cfg.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: Validate() = %v, want nil", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestValidate_CORSOrigins(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		origin string
+		valid  bool
+	}{
+		"https origin":   {"https://app.example.test", true},
+		"trailing slash": {"https://app.example.test/", true},
+		"with port":      {"http://localhost:3000", true},
+		"with path":      {"https://app.example.test/app", false},
+		"wildcard":       {"*", false},
+		"wildcard host":  {"https://*.example.test", false},
+		"no scheme":      {"app.example.test", false},
+	}
+	for name, tc := range cases {
+		cfg := config.Default()
+		cfg.Server.CORS.AllowedOrigins = []string{tc.origin}
+		cfg.Normalize()
+		err := cfg.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: Validate() = %v, want nil", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestValidate_Locale(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		locale string
+		valid  bool
+	}{
+		"unset":    {"", true},
+		"language": {"es", true},
+		"region":   {"pt-BR", true},
+		"garbage":  {"not a locale", false},
+	}
+	for name, tc := range cases {
+		cfg := config.Default()
+		cfg.Server.Locale = tc.locale
+		cfg.Normalize()
+		err := cfg.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: Validate() = %v, want nil", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
+
+func TestResolve_Realms(t *testing.T) {
+	primaryConfig, primaryData := filepath.Join(t.TempDir(), "cfg"), filepath.Join(t.TempDir(), "data")
+	realmConfig, realmData := filepath.Join(t.TempDir(), "acme-cfg"), filepath.Join(t.TempDir(), "acme-data")
+	if _, err := config.Init(primaryConfig, primaryData, config.InitOptions{}); err != nil {
+		t.Fatalf("Init primary failed: %v", err)
+	}
+	realmURL, realmDomain := "https://auth.acme.test", "auth.acme.test"
+	if _, err := config.Init(realmConfig, realmData, config.InitOptions{
+		Overrides: config.Overrides{PublicURL: &realmURL, AuthorityDomain: &realmDomain},
+	}); err != nil {
+		t.Fatalf("Init realm failed: %v", err)
+	}
+
+	cfg, err := config.Load(primaryConfig, primaryData)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	cfg.Realms = []config.RealmConfig{{Name: "acme", ConfigDir: realmConfig, DataDir: realmData}}
+	if err := config.Save(primaryConfig, primaryData, cfg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// the environment configures only the primary realm
+	t.Setenv(config.EnvPublicURL, "http://env.test:9001")
+	t.Setenv(config.EnvBootstrapAPIKey, "bootstrap.from.env")
+
+	runtime, err := config.Resolve(primaryConfig, primaryData, config.RuntimeOptions{RequireSigningKey: true})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if runtime.Server.PublicURL != "http://env.test:9001" {
+		t.Errorf("primary PublicURL = %q, want env value", runtime.Server.PublicURL)
+	}
+	if len(runtime.Realms) != 1 {
+		t.Fatalf("len(Realms) = %d, want 1", len(runtime.Realms))
+	}
+	realm := runtime.Realms[0]
+	if realm.Name != "acme" || realm.Server.PublicURL != realmURL || realm.Server.AuthorityDomain != realmDomain {
+		t.Errorf("realm = %s at %s for %s", realm.Name, realm.Server.PublicURL, realm.Server.AuthorityDomain)
+	}
+	if realm.Source.BootstrapAPIKeySource != config.SecretSourceFile || realm.Secrets.BootstrapAPIKey == "bootstrap.from.env" {
+		t.Errorf("realm bootstrap key source = %q, want its own file", realm.Source.BootstrapAPIKeySource)
+	}
+	if realm.Secrets.SigningKey == nil || realm.Secrets.SigningKey.Equal(runtime.Secrets.SigningKey) {
+		t.Error("realm signing key is missing or shared with the primary")
+	}
+	if realm.Paths.DatabaseFile == runtime.Paths.DatabaseFile {
+		t.Error("realm shares the primary database")
+	}
+
+	// two realms cannot claim the same host
+	cfg.Realms = append(cfg.Realms, config.RealmConfig{Name: "copy", ConfigDir: realmConfig, DataDir: filepath.Join(t.TempDir(), "copy-data")})
+	if err := config.Save(primaryConfig, primaryData, cfg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	_, err = config.Resolve(primaryConfig, primaryData, config.RuntimeOptions{})
+	if err == nil || !strings.Contains(err.Error(), "auth.acme.test") {
+		t.Fatalf("Resolve error = %v, want duplicate host", err)
+	}
+}
+
+func TestValidate_RejectsInvalidRealms(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string][]config.RealmConfig{
+		"missing name": {{ConfigDir: "a", DataDir: "b"}},
+		"missing dirs": {{Name: "acme"}},
+		"bad name":     {{Name: "Acme Corp", ConfigDir: "a", DataDir: "b"}},
+		"duplicate": {
+			{Name: "acme", ConfigDir: "a", DataDir: "b"},
+			{Name: "acme", ConfigDir: "c", DataDir: "d"},
+		},
+	}
+	for name, realms := range cases {
+		cfg := config.Default()
+		cfg.Realms = realms
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: Validate() = nil, want error", name)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/keys"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
 type InitOptions struct {
@@ -97,7 +98,11 @@ func resolveKeyMaterial() (
 	error,
 ) {
 	var privateDER []byte
-	if value, ok := os.LookupEnv(EnvSigningKeyDERBase64); ok && strings.TrimSpace(value) != "" {
+	value, err := getenv(EnvSigningKeyDERBase64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(value) != "" {
 		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
 		if err != nil {
 			return nil, nil, fmt.Errorf("config: decode %s: %w", EnvSigningKeyDERBase64, err)
@@ -106,30 +111,157 @@ func resolveKeyMaterial() (
 	}
 
 	if len(privateDER) == 0 {
-		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err != nil {
-			return nil, nil, fmt.Errorf("config: generate signing key: %w", err)
-		}
+		return generateKeyMaterial()
+	}
 
-		privateDER, err = x509.MarshalECPrivateKey(key)
-		if err != nil {
-			return nil, nil, fmt.Errorf("config: encode signing key: %w", err)
-		}
+	privateKey, err := tokens.ParsePrivateKey(privateDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: parse %s: %w", EnvSigningKeyDERBase64, err)
+	}
 
-		publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
-		if err != nil {
-			return nil, nil, fmt.Errorf("config: encode verification key: %w", err)
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: encode verification key: %w", err)
+	}
+
+	return privateDER, publicDER, nil
+}
+
+// KeygenResult reports where Keygen wrote a key pair.
+type KeygenResult struct {
+	SigningKeyFile      string
+	VerificationKeyFile string
+	VerificationKey     *ecdsa.PublicKey
+}
+
+// Keygen generates a signing key pair and writes it to outDir in the layout
+// `consent config init` uses: the SEC1 DER signing key readable only by its
+// owner, and the PKIX DER verification key world-readable. Existing files are
+// kept unless force is set.
+func Keygen(
+	outDir string,
+	force bool,
+) (
+	KeygenResult,
+	error,
+) {
+	if strings.TrimSpace(outDir) == "" {
+		return KeygenResult{}, fmt.Errorf("config: key output directory required")
+	}
+
+	signingKeyDER, verificationKeyDER, err := generateKeyMaterial()
+	if err != nil {
+		return KeygenResult{}, err
+	}
+	verificationKey, err := x509.ParsePKIXPublicKey(verificationKeyDER)
+	if err != nil {
+		return KeygenResult{}, fmt.Errorf("config: parse verification key: %w", err)
+	}
+
+	result := KeygenResult{
+		SigningKeyFile:      filepath.Join(outDir, SigningKeyFileName),
+		VerificationKeyFile: filepath.Join(outDir, VerifyKeyFileName),
+		VerificationKey:     verificationKey.(*ecdsa.PublicKey),
+	}
+	if !force {
+		for _, path := range []string{result.SigningKeyFile, result.VerificationKeyFile} {
+			exists, err := fileExists(path)
+			if err != nil {
+				return KeygenResult{}, err
+			}
+			if exists {
+				return KeygenResult{}, fmt.Errorf("config: refusing to overwrite existing file: %s", path)
+			}
 		}
+	}
+
+	if err := os.MkdirAll(outDir, 0o700); err != nil {
+		return KeygenResult{}, fmt.Errorf("config: create %s: %w", outDir, err)
+	}
+	if err := writeFileAtomic(result.SigningKeyFile, signingKeyDER, 0o600, force); err != nil {
+		return KeygenResult{}, err
+	}
+	if err := writeFileAtomic(result.VerificationKeyFile, verificationKeyDER, 0o644, force); err != nil {
+		return KeygenResult{}, err
+	}
+
+	return result, nil
+}
 
-		return privateDER, publicDER, nil
+// DevDatabaseFile is the database ResolveDev runs on: SQLite held in
+// memory, gone when the server stops.
+const DevDatabaseFile = ":memory:"
+
+// ResolveDev builds the throwaway runtime of `consent serve --dev`: the
+// default config with environment and then flag overrides, served at
+// localhost on its port unless the public URL is set, a signing key and
+// bootstrap API key generated for this run, and an in-memory database.
+// Nothing is read from or written to the config and data directories.
+func ResolveDev(
+	overrides Overrides,
+) (
+	Runtime,
+	error,
+) {
+	envOverrides, err := EnvOverrides()
+	if err != nil {
+		return Runtime{}, err
+	}
+	defaults := Default()
+	cfg := defaults.WithOverrides(envOverrides).WithOverrides(overrides)
+	// the default public URL follows a changed port, so browsers can find it
+	if cfg.Server.PublicURL == defaults.Server.PublicURL && cfg.Server.Port != defaults.Server.Port {
+		cfg.Server.PublicURL = fmt.Sprintf("http://localhost:%d", cfg.Server.Port)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Runtime{}, err
 	}
 
-	privateKey, err := x509.ParseECPrivateKey(privateDER)
+	server, err := ResolveServer(cfg)
 	if err != nil {
-		return nil, nil, fmt.Errorf("config: parse %s: %w", EnvSigningKeyDERBase64, err)
+		return Runtime{}, err
+	}
+	paths := Paths{DatabaseFile: DevDatabaseFile}
+	if server.TLS, err = resolveTLS(paths, cfg.Server.TLS, server.ParsedPublicURL); err != nil {
+		return Runtime{}, err
 	}
 
-	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Runtime{}, fmt.Errorf("config: generate signing key: %w", err)
+	}
+	bootstrapAPIKey, err := keys.GenerateBootstrapKey()
+	if err != nil {
+		return Runtime{}, fmt.Errorf("config: generate bootstrap api key: %w", err)
+	}
+
+	return Runtime{
+		Config: cfg,
+		Paths:  paths,
+		Server: server,
+		Secrets: RuntimeSecrets{
+			SigningKey:      signingKey,
+			BootstrapAPIKey: bootstrapAPIKey,
+		},
+	}, nil
+}
+
+func generateKeyMaterial() (
+	[]byte,
+	[]byte,
+	error,
+) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: generate signing key: %w", err)
+	}
+
+	privateDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: encode signing key: %w", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
 	if err != nil {
 		return nil, nil, fmt.Errorf("config: encode verification key: %w", err)
 	}
@@ -141,7 +273,11 @@ func resolveBootstrapAPIKey() (
 	string,
 	error,
 ) {
-	if value := strings.TrimSpace(os.Getenv(EnvBootstrapAPIKey)); value != "" {
+	value, err := getenv(EnvBootstrapAPIKey)
+	if err != nil {
+		return "", err
+	}
+	if value = strings.TrimSpace(value); value != "" {
 		return value, nil
 	}
 
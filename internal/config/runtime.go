@@ -1,56 +1,197 @@
 package config
 
 import (
+	"bytes"
+	"cmp"
 	"crypto/ecdsa"
-	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
 const (
-	EnvSigningKeyDERBase64 = "CONSENT_SIGNING_KEY_DER_BASE64"
-	EnvBootstrapAPIKey     = "CONSENT_BOOTSTRAP_API_KEY"
+	EnvSigningKeyDERBase64  = "CONSENT_SIGNING_KEY_DER_BASE64"
+	EnvSigningKeyPassphrase = "CONSENT_SIGNING_KEY_PASSPHRASE"
+	EnvBootstrapAPIKey      = "CONSENT_BOOTSTRAP_API_KEY"
+	EnvUpstreamSecretFmt    = "CONSENT_UPSTREAM_%s_CLIENT_SECRET"
+	EnvWebhookSecretFmt     = "CONSENT_WEBHOOK_%s_SECRET"
 )
 
+// EnvFileSuffix names the companion of each variable above and below that
+// holds a path instead of a value, as container platforms mount secrets:
+// CONSENT_BOOTSTRAP_API_KEY_FILE=/run/secrets/bootstrap_api_key reads the
+// bootstrap API key from that file. The variable itself wins when both are
+// set.
+const EnvFileSuffix = "_FILE"
+
+// SigningKeyPassphraseCredential is the systemd credential (see
+// LoadCredential= in systemd.exec) that holds the passphrase for an
+// encrypted signing key.
+const SigningKeyPassphraseCredential = "signing_key_passphrase"
+
+// Environment variables that override config file settings. Command-line
+// overrides take precedence over these.
+const (
+	EnvPublicURL            = "CONSENT_PUBLIC_URL"
+	EnvAuthorityDomain      = "CONSENT_AUTHORITY_DOMAIN"
+	EnvPort                 = "CONSENT_PORT"
+	EnvDevMode              = "CONSENT_DEV_MODE"
+	EnvTLSCertFile          = "CONSENT_TLS_CERT_FILE"
+	EnvTLSKeyFile           = "CONSENT_TLS_KEY_FILE"
+	EnvAutocert             = "CONSENT_AUTOCERT"
+	EnvAuthCodeLifetime     = "CONSENT_AUTH_CODE_LIFETIME"
+	EnvAccessTokenLifetime  = "CONSENT_ACCESS_TOKEN_LIFETIME"
+	EnvRefreshTokenLifetime = "CONSENT_REFRESH_TOKEN_LIFETIME"
+	EnvStoragePath          = "CONSENT_STORAGE_PATH"
+	EnvAccessLog            = "CONSENT_ACCESS_LOG"
+	EnvTemplatesPath        = "CONSENT_TEMPLATES_PATH"
+	EnvStaticTemplates      = "CONSENT_STATIC_TEMPLATES"
+	EnvMaintenance          = "CONSENT_MAINTENANCE"
+	EnvLocale               = "CONSENT_LOCALE"
+	EnvLocalesPath          = "CONSENT_LOCALES_PATH"
+)
+
+// RuntimeOptions configures Resolve. ConfigFile, when set, replaces
+// config.yaml in the config directory and must exist.
 type RuntimeOptions struct {
+	ConfigFile             string
 	Overrides              Overrides
 	RequireSigningKey      bool
 	RequireBootstrapAPIKey bool
+
+	// PromptPassphrase, when set, asks for an encrypted signing key's
+	// passphrase if neither the environment nor a systemd credential
+	// supplies it.
+	PromptPassphrase func() ([]byte, error)
+
+	// realm resolves a realm's directories, which read nothing from the
+	// environment so realms stay isolated from the primary deployment.
+	realm bool
+}
+
+// env returns envVar, or "" when resolving a realm, which no variable is
+// named.
+func (o RuntimeOptions) env(
+	envVar string,
+) string {
+	if o.realm {
+		return ""
+	}
+	return envVar
 }
 
 type SecretSource string
 
 const (
-	SecretSourceNone SecretSource = ""
-	SecretSourceEnv  SecretSource = "env"
-	SecretSourceFile SecretSource = "file"
+	SecretSourceNone  SecretSource = ""
+	SecretSourceEnv   SecretSource = "env"
+	SecretSourceFile  SecretSource = "file"
+	SecretSourceVault SecretSource = "vault"
 )
 
 type Runtime struct {
-	Config  Config
-	Paths   Paths
-	Server  RuntimeServer
-	Secrets RuntimeSecrets
-	Source  RuntimeSource
+	Config    Config
+	Paths     Paths
+	Server    RuntimeServer
+	Secrets   RuntimeSecrets
+	Source    RuntimeSource
+	Upstreams []RuntimeUpstream
+	Webhooks  []RuntimeWebhook
+	Realms    []RuntimeRealm
+}
+
+// RuntimeRealm is another deployment served by the same process, resolved
+// from its own config and data directories.
+type RuntimeRealm struct {
+	Name string
+	Runtime
+}
+
+// RuntimeUpstream is an upstream provider with its resolved client secret
+// and the consent callback URL registered with the provider.
+type RuntimeUpstream struct {
+	UpstreamConfig
+	ClientSecret string
+	RedirectURL  string
+}
+
+// RuntimeWebhook is a webhook with its resolved signing secret.
+type RuntimeWebhook struct {
+	WebhookConfig
+	Secret string
 }
 
 type RuntimeServer struct {
-	PublicURL       string
-	PublicBaseURL   string
-	PublicHost      string
-	ParsedPublicURL *url.URL
-	AuthorityDomain string
-	Port            int
-	ListenAddress   string
-	DevMode         bool
+	PublicURL            string
+	PublicBaseURL        string
+	PublicHost           string
+	ParsedPublicURL      *url.URL
+	AuthorityDomain      string
+	Port                 int
+	ListenAddress        string
+	DevMode              bool
+	AuthCodeLifetime     time.Duration
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	TLS                  RuntimeTLS
+	CORS                 CORSConfig
+	AccessLog            accesslog.Format
+	TemplatesPath        string
+	StaticTemplates      bool
+	Maintenance          bool
+	Branding             BrandingConfig
+	Locale               string
+	LocalesPath          string
+}
+
+// RuntimeTLS is the resolved TLS setup. Enabled is false when the server
+// serves plain HTTP, such as behind a TLS-terminating proxy.
+type RuntimeTLS struct {
+	Enabled         bool
+	CertFile        string
+	KeyFile         string
+	Autocert        bool
+	AutocertEmail   string
+	AutocertHost    string
+	AutocertDir     string
+	RedirectAddress string
 }
 
 type RuntimeSecrets struct {
 	SigningKey      *ecdsa.PrivateKey
 	BootstrapAPIKey string
+
+	// Signer, when set, signs tokens in place of SigningKey, for keys held
+	// in hardware or by a remote service. Resolve sets it for a Vault
+	// transit key; embedders may set others directly.
+	Signer tokens.Signer
+}
+
+// TokenServerOptions returns the signing half of tokens.ServerOptions for
+// these secrets.
+func (s RuntimeSecrets) TokenServerOptions() tokens.ServerOptions {
+	return tokens.ServerOptions{
+		SigningKey: s.SigningKey,
+		Signer:     s.Signer,
+	}
+}
+
+// VerificationKey returns the public half of the signing key, or nil if
+// there is no usable one.
+func (s RuntimeSecrets) VerificationKey() *ecdsa.PublicKey {
+	key, err := s.TokenServerOptions().PublicKey()
+	if err != nil {
+		return nil
+	}
+	return key
 }
 
 type RuntimeSource struct {
@@ -66,22 +207,41 @@ type View struct {
 	Server  ViewServer  `yaml:"server" json:"server"`
 	Secrets ViewSecrets `yaml:"secrets" json:"secrets"`
 	Source  ViewSource  `yaml:"source" json:"source"`
+	Realms  []ViewRealm `yaml:"realms,omitempty" json:"realms,omitempty"`
 }
 
-type ViewServer struct {
+// ViewRealm summarizes a resolved realm.
+type ViewRealm struct {
+	Name            string `yaml:"name" json:"name"`
 	PublicURL       string `yaml:"publicURL" json:"publicURL"`
-	PublicBaseURL   string `yaml:"publicBaseURL" json:"publicBaseURL"`
-	PublicHost      string `yaml:"publicHost" json:"publicHost"`
 	AuthorityDomain string `yaml:"authorityDomain" json:"authorityDomain"`
-	Port            int    `yaml:"port" json:"port"`
-	ListenAddress   string `yaml:"listenAddress" json:"listenAddress"`
-	DevMode         bool   `yaml:"devMode" json:"devMode"`
+	DatabaseFile    string `yaml:"databaseFile" json:"databaseFile"`
+	SigningKeySet   bool   `yaml:"signingKeySet" json:"signingKeySet"`
+}
+
+type ViewServer struct {
+	PublicURL            string        `yaml:"publicURL" json:"publicURL"`
+	PublicBaseURL        string        `yaml:"publicBaseURL" json:"publicBaseURL"`
+	PublicHost           string        `yaml:"publicHost" json:"publicHost"`
+	AuthorityDomain      string        `yaml:"authorityDomain" json:"authorityDomain"`
+	Port                 int           `yaml:"port" json:"port"`
+	ListenAddress        string        `yaml:"listenAddress" json:"listenAddress"`
+	DevMode              bool          `yaml:"devMode" json:"devMode"`
+	AuthCodeLifetime     time.Duration `yaml:"authCodeLifetime,omitempty" json:"authCodeLifetime,omitempty"`
+	AccessTokenLifetime  time.Duration `yaml:"accessTokenLifetime,omitempty" json:"accessTokenLifetime,omitempty"`
+	RefreshTokenLifetime time.Duration `yaml:"refreshTokenLifetime,omitempty" json:"refreshTokenLifetime,omitempty"`
+	TLS                  string        `yaml:"tls" json:"tls"`
+	AccessLog            string        `yaml:"accessLog" json:"accessLog"`
+	Maintenance          bool          `yaml:"maintenance,omitempty" json:"maintenance,omitempty"`
 }
 
 type ViewSecrets struct {
 	SigningKeySet      bool `yaml:"signingKeySet" json:"signingKeySet"`
 	VerificationKeySet bool `yaml:"verificationKeySet" json:"verificationKeySet"`
 	BootstrapAPIKeySet bool `yaml:"bootstrapAPIKeySet" json:"bootstrapAPIKeySet"`
+
+	// Provider is where per-service secrets are read from.
+	Provider string `yaml:"provider" json:"provider"`
 }
 
 type ViewSource struct {
@@ -91,48 +251,79 @@ type ViewSource struct {
 	VerificationKeyPresent bool         `yaml:"verificationKeyPresent" json:"verificationKeyPresent"`
 }
 
+// Resolve loads the config file, applies environment then command-line
+// overrides, and loads secrets.
 func Resolve(configDir string, dataDir string, opts RuntimeOptions) (Runtime, error) {
 	paths, err := resolvePaths(configDir, dataDir)
 	if err != nil {
 		return Runtime{}, err
 	}
 
-	cfg, err := Load(configDir, dataDir)
+	var cfg Config
+	if strings.TrimSpace(opts.ConfigFile) != "" {
+		if paths.ConfigFile, err = expandPath(opts.ConfigFile); err != nil {
+			return Runtime{}, err
+		}
+		cfg, err = LoadFile(paths.ConfigFile)
+	} else {
+		cfg, err = Load(configDir, dataDir)
+	}
 	if err != nil {
 		return Runtime{}, err
 	}
 
+	if !opts.realm {
+		envOverrides, err := EnvOverrides()
+		if err != nil {
+			return Runtime{}, err
+		}
+		cfg = cfg.WithOverrides(envOverrides)
+	}
+
 	cfg = cfg.WithOverrides(opts.Overrides)
 	if err := cfg.Validate(); err != nil {
 		return Runtime{}, err
 	}
 
-	publicURL, parsedURL, err := normalizePublicURL(cfg.Server.PublicURL)
-	if err != nil {
-		return Runtime{}, fmt.Errorf("config: %w", err)
+	if cfg.Storage.Path != "" {
+		if paths.DatabaseFile, err = expandPath(cfg.Storage.Path); err != nil {
+			return Runtime{}, err
+		}
 	}
 
-	signingKeyDER, signingKeySource, err := loadSecretBytes(paths.SigningKeyFile, EnvSigningKeyDERBase64, true)
+	server, err := ResolveServer(cfg)
 	if err != nil {
 		return Runtime{}, err
 	}
 
-	var signingKey *ecdsa.PrivateKey
-	if len(signingKeyDER) > 0 {
-		signingKey, err = x509.ParseECPrivateKey(signingKeyDER)
-		if err != nil {
-			return Runtime{}, fmt.Errorf("config: parse signing key: %w", err)
+	var (
+		signingKey       *ecdsa.PrivateKey
+		signer           tokens.Signer
+		signingKeySource SecretSource
+	)
+	switch {
+	case cfg.Signing.Vault.Key != "" && opts.realm:
+		return Runtime{}, fmt.Errorf("config: realms can't sign with a vault key")
+	case cfg.Signing.Vault.Key != "":
+		// only the server signs; other commands needn't reach vault
+		signingKeySource = SecretSourceVault
+		if opts.RequireSigningKey {
+			if signer, err = newVaultSigner(cfg.Signing.Vault); err != nil {
+				return Runtime{}, err
+			}
+		}
+	default:
+		if signingKey, signingKeySource, err = loadSigningKey(paths, opts); err != nil {
+			return Runtime{}, err
 		}
-	} else if opts.RequireSigningKey {
-		return Runtime{}, fmt.Errorf("config: signing key is required; set %s or create %s", EnvSigningKeyDERBase64, paths.SigningKeyFile)
 	}
 
-	bootstrapAPIKey, bootstrapKeySource, err := loadSecretString(paths.BootstrapAPIKeyFile, EnvBootstrapAPIKey)
+	bootstrapAPIKey, bootstrapKeySource, err := loadSecretString(paths.BootstrapAPIKeyFile, opts.env(EnvBootstrapAPIKey))
 	if err != nil {
 		return Runtime{}, err
 	}
 	if bootstrapAPIKey == "" && opts.RequireBootstrapAPIKey {
-		return Runtime{}, fmt.Errorf("config: bootstrap api key is required; set %s or create %s", EnvBootstrapAPIKey, paths.BootstrapAPIKeyFile)
+		return Runtime{}, fmt.Errorf("config: bootstrap api key is required; %s", secretHint(opts.env(EnvBootstrapAPIKey), paths.BootstrapAPIKeyFile))
 	}
 
 	verificationKeyPresent, err := fileExists(paths.VerificationKeyFile)
@@ -145,22 +336,34 @@ func Resolve(configDir string, dataDir string, opts RuntimeOptions) (Runtime, er
 		return Runtime{}, err
 	}
 
-	return Runtime{
+	server.TLS, err = resolveTLS(paths, cfg.Server.TLS, server.ParsedPublicURL)
+	if err != nil {
+		return Runtime{}, err
+	}
+
+	secrets, err := newSecretsProvider(cfg.Secrets, paths, opts)
+	if err != nil {
+		return Runtime{}, err
+	}
+
+	upstreams, err := resolveUpstreams(secrets, cfg.Upstreams, server.PublicBaseURL, opts.env)
+	if err != nil {
+		return Runtime{}, err
+	}
+
+	webhooks, err := resolveWebhooks(secrets, cfg.Webhooks, opts.env)
+	if err != nil {
+		return Runtime{}, err
+	}
+
+	runtime := Runtime{
 		Config: cfg,
 		Paths:  paths,
-		Server: RuntimeServer{
-			PublicURL:       publicURL,
-			PublicBaseURL:   strings.TrimRight(publicURL, "/"),
-			PublicHost:      parsedURL.Host,
-			ParsedPublicURL: parsedURL,
-			AuthorityDomain: cfg.Server.AuthorityDomain,
-			Port:            cfg.Server.Port,
-			ListenAddress:   fmt.Sprintf(":%d", cfg.Server.Port),
-			DevMode:         cfg.Server.DevMode,
-		},
+		Server: server,
 		Secrets: RuntimeSecrets{
 			SigningKey:      signingKey,
 			BootstrapAPIKey: bootstrapAPIKey,
+			Signer:          signer,
 		},
 		Source: RuntimeSource{
 			SigningKeySource:       signingKeySource,
@@ -168,26 +371,396 @@ func Resolve(configDir string, dataDir string, opts RuntimeOptions) (Runtime, er
 			VerificationKeyPresent: verificationKeyPresent,
 			ConfigFilePresent:      configFilePresent,
 		},
+		Upstreams: upstreams,
+		Webhooks:  webhooks,
+	}
+	if runtime.Realms, err = resolveRealms(runtime, opts); err != nil {
+		return Runtime{}, err
+	}
+
+	return runtime, nil
+}
+
+// EnvOverrides reads config overrides from CONSENT_* environment variables.
+// Unset or empty variables leave the config file's value in place. Each
+// variable may instead name a file with its EnvFileSuffix companion.
+func EnvOverrides() (Overrides, error) {
+	var overrides Overrides
+	var err error
+
+	if overrides.PublicURL, err = lookupEnvString(EnvPublicURL); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.AuthorityDomain, err = lookupEnvString(EnvAuthorityDomain); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.TLSCertFile, err = lookupEnvString(EnvTLSCertFile); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.TLSKeyFile, err = lookupEnvString(EnvTLSKeyFile); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.StoragePath, err = lookupEnvString(EnvStoragePath); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.AccessLog, err = lookupEnvString(EnvAccessLog); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.TemplatesPath, err = lookupEnvString(EnvTemplatesPath); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.Locale, err = lookupEnvString(EnvLocale); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.LocalesPath, err = lookupEnvString(EnvLocalesPath); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.Port, err = lookupEnv(EnvPort, strconv.Atoi); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.DevMode, err = lookupEnv(EnvDevMode, strconv.ParseBool); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.Autocert, err = lookupEnv(EnvAutocert, strconv.ParseBool); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.StaticTemplates, err = lookupEnv(EnvStaticTemplates, strconv.ParseBool); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.Maintenance, err = lookupEnv(EnvMaintenance, strconv.ParseBool); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.AuthCodeLifetime, err = lookupEnv(EnvAuthCodeLifetime, time.ParseDuration); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.AccessTokenLifetime, err = lookupEnv(EnvAccessTokenLifetime, time.ParseDuration); err != nil {
+		return Overrides{}, err
+	}
+	if overrides.RefreshTokenLifetime, err = lookupEnv(EnvRefreshTokenLifetime, time.ParseDuration); err != nil {
+		return Overrides{}, err
+	}
+
+	return overrides, nil
+}
+
+// getenv returns the value of envVar, or else the contents of the file
+// named by its EnvFileSuffix companion, less a trailing newline. It returns
+// "" when neither is set.
+func getenv(envVar string) (string, error) {
+	if envVar == "" {
+		return "", nil
+	}
+	if value := os.Getenv(envVar); value != "" {
+		return value, nil
+	}
+	path := strings.TrimSpace(os.Getenv(envVar + EnvFileSuffix))
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("config: read %s%s: %w", envVar, EnvFileSuffix, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func lookupEnvString(envVar string) (*string, error) {
+	value, err := getenv(envVar)
+	if err != nil {
+		return nil, err
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	return &value, nil
+}
+
+func lookupEnv[T any](envVar string, parse func(string) (T, error)) (*T, error) {
+	value, err := lookupEnvString(envVar)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	parsed, err := parse(*value)
+	if err != nil {
+		return nil, fmt.Errorf("config: invalid %s %q: %w", envVar, *value, err)
+	}
+	return &parsed, nil
+}
+
+// ResolveServer derives the server settings from a validated config. TLS,
+// which depends on file paths, is left for Resolve to fill in.
+func ResolveServer(cfg Config) (RuntimeServer, error) {
+	publicURL, parsedURL, err := normalizePublicURL(cfg.Server.PublicURL)
+	if err != nil {
+		return RuntimeServer{}, fmt.Errorf("config: %w", err)
+	}
+
+	accessLog, err := accesslog.ParseFormat(cfg.Server.AccessLog)
+	if err != nil {
+		return RuntimeServer{}, fmt.Errorf("config: %w", err)
+	}
+
+	var templatesPath string
+	if cfg.Server.TemplatesPath != "" {
+		if templatesPath, err = expandPath(cfg.Server.TemplatesPath); err != nil {
+			return RuntimeServer{}, err
+		}
+	}
+
+	var localesPath string
+	if cfg.Server.LocalesPath != "" {
+		if localesPath, err = expandPath(cfg.Server.LocalesPath); err != nil {
+			return RuntimeServer{}, err
+		}
+	}
+
+	return RuntimeServer{
+		PublicURL:            publicURL,
+		PublicBaseURL:        strings.TrimRight(publicURL, "/"),
+		PublicHost:           parsedURL.Host,
+		ParsedPublicURL:      parsedURL,
+		AuthorityDomain:      cfg.Server.AuthorityDomain,
+		Port:                 cfg.Server.Port,
+		ListenAddress:        fmt.Sprintf(":%d", cfg.Server.Port),
+		DevMode:              cfg.Server.DevMode,
+		AuthCodeLifetime:     cfg.Server.AuthCodeLifetime,
+		AccessTokenLifetime:  cfg.Server.AccessTokenLifetime,
+		RefreshTokenLifetime: cfg.Server.RefreshTokenLifetime,
+		CORS:                 cfg.Server.CORS,
+		AccessLog:            accessLog,
+		TemplatesPath:        templatesPath,
+		StaticTemplates:      cfg.Server.StaticTemplates,
+		Maintenance:          cfg.Server.Maintenance,
+		Branding:             cfg.Server.Branding,
+		Locale:               cfg.Server.Locale,
+		LocalesPath:          localesPath,
 	}, nil
 }
 
+// UpstreamSecretPath returns the client secret file for an upstream provider.
+func (p Paths) UpstreamSecretPath(name string) string {
+	return filepath.Join(p.SecretsDir, fmt.Sprintf(UpstreamSecretFmt, name))
+}
+
+// UpstreamSecretEnv returns the environment variable that overrides the
+// client secret file for an upstream provider.
+func UpstreamSecretEnv(name string) string {
+	return fmt.Sprintf(EnvUpstreamSecretFmt, strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+}
+
+// WebhookSecretPath returns the signing secret file for a webhook.
+func (p Paths) WebhookSecretPath(name string) string {
+	return filepath.Join(p.SecretsDir, fmt.Sprintf(WebhookSecretFmt, name))
+}
+
+// WebhookSecretEnv returns the environment variable that overrides the
+// signing secret file for a webhook.
+func WebhookSecretEnv(name string) string {
+	return fmt.Sprintf(EnvWebhookSecretFmt, strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+}
+
+func resolveTLS(
+	paths Paths,
+	cfg TLSConfig,
+	publicURL *url.URL,
+) (
+	RuntimeTLS,
+	error,
+) {
+	if !cfg.Enabled() {
+		return RuntimeTLS{}, nil
+	}
+
+	redirectPort := cfg.RedirectPort
+	if redirectPort == 0 {
+		redirectPort = 80
+	}
+	tls := RuntimeTLS{
+		Enabled:         true,
+		Autocert:        cfg.Autocert,
+		AutocertEmail:   cfg.AutocertEmail,
+		RedirectAddress: fmt.Sprintf(":%d", redirectPort),
+	}
+
+	if cfg.Autocert {
+		tls.AutocertHost = publicURL.Hostname()
+		tls.AutocertDir = filepath.Join(paths.DataDir, AutocertDirName)
+		return tls, nil
+	}
+
+	var err error
+	if tls.CertFile, err = expandPath(cfg.CertFile); err != nil {
+		return RuntimeTLS{}, err
+	}
+	if tls.KeyFile, err = expandPath(cfg.KeyFile); err != nil {
+		return RuntimeTLS{}, err
+	}
+	return tls, nil
+}
+
+func (t RuntimeTLS) mode() string {
+	switch {
+	case !t.Enabled:
+		return "off"
+	case t.Autocert:
+		return "autocert"
+	default:
+		return "files"
+	}
+}
+
+func resolveUpstreams(
+	secrets SecretsProvider,
+	upstreams []UpstreamConfig,
+	publicBaseURL string,
+	env func(string) string,
+) (
+	[]RuntimeUpstream,
+	error,
+) {
+	resolved := make([]RuntimeUpstream, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		secretName := fmt.Sprintf(UpstreamSecretFmt, upstream.Name)
+		secret, hint, err := loadServiceSecret(secrets, secretName, env(UpstreamSecretEnv(upstream.Name)))
+		if err != nil {
+			return nil, err
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("config: upstream %q client secret is required; %s", upstream.Name, hint)
+		}
+
+		resolved = append(resolved, RuntimeUpstream{
+			UpstreamConfig: upstream,
+			ClientSecret:   secret,
+			RedirectURL:    publicBaseURL + "/login/with/" + upstream.Name + "/callback",
+		})
+	}
+	return resolved, nil
+}
+
+func resolveWebhooks(
+	secrets SecretsProvider,
+	webhooks []WebhookConfig,
+	env func(string) string,
+) (
+	[]RuntimeWebhook,
+	error,
+) {
+	resolved := make([]RuntimeWebhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		secretName := fmt.Sprintf(WebhookSecretFmt, webhook.Name)
+		secret, hint, err := loadServiceSecret(secrets, secretName, env(WebhookSecretEnv(webhook.Name)))
+		if err != nil {
+			return nil, err
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("config: webhook %q secret is required; %s", webhook.Name, hint)
+		}
+
+		resolved = append(resolved, RuntimeWebhook{
+			WebhookConfig: webhook,
+			Secret:        secret,
+		})
+	}
+	return resolved, nil
+}
+
+// resolveRealms resolves each of the primary's realms from its own
+// directories. Environment and command-line overrides apply only to the
+// primary, and no two realms may share a public host or a database. The
+// primary's listener and TLS settings serve every realm, so realms may not
+// configure TLS of their own.
+func resolveRealms(
+	primary Runtime,
+	opts RuntimeOptions,
+) (
+	[]RuntimeRealm,
+	error,
+) {
+	if len(primary.Config.Realms) == 0 {
+		return nil, nil
+	}
+	if opts.realm {
+		return nil, fmt.Errorf("config: realms cannot define realms of their own")
+	}
+
+	hosts := map[string]string{realmHost(primary.Server): "the primary realm"}
+	databases := map[string]string{primary.Paths.DatabaseFile: "the primary realm"}
+	resolved := make([]RuntimeRealm, 0, len(primary.Config.Realms))
+	for _, realm := range primary.Config.Realms {
+		runtime, err := Resolve(realm.ConfigDir, realm.DataDir, RuntimeOptions{
+			RequireSigningKey:      opts.RequireSigningKey,
+			RequireBootstrapAPIKey: opts.RequireBootstrapAPIKey,
+			realm:                  true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w (realm %q)", err, realm.Name)
+		}
+		if runtime.Server.TLS.Enabled {
+			return nil, fmt.Errorf("config: realm %q: tls is configured by the primary realm", realm.Name)
+		}
+
+		host := realmHost(runtime.Server)
+		if owner, ok := hosts[host]; ok {
+			return nil, fmt.Errorf("config: realm %q: host %s is already served by %s", realm.Name, host, owner)
+		}
+		hosts[host] = fmt.Sprintf("realm %q", realm.Name)
+		if owner, ok := databases[runtime.Paths.DatabaseFile]; ok {
+			return nil, fmt.Errorf("config: realm %q: database %s is already used by %s", realm.Name, runtime.Paths.DatabaseFile, owner)
+		}
+		databases[runtime.Paths.DatabaseFile] = fmt.Sprintf("realm %q", realm.Name)
+
+		resolved = append(resolved, RuntimeRealm{
+			Name:    realm.Name,
+			Runtime: runtime,
+		})
+	}
+	return resolved, nil
+}
+
+// realmHost is the host name requests are routed by, without its port.
+func realmHost(
+	server RuntimeServer,
+) string {
+	return strings.ToLower(server.ParsedPublicURL.Hostname())
+}
+
 func (r Runtime) View() View {
+	var realms []ViewRealm
+	for _, realm := range r.Realms {
+		realms = append(realms, ViewRealm{
+			Name:            realm.Name,
+			PublicURL:       realm.Server.PublicURL,
+			AuthorityDomain: realm.Server.AuthorityDomain,
+			DatabaseFile:    realm.Paths.DatabaseFile,
+			SigningKeySet:   realm.Secrets.SigningKey != nil,
+		})
+	}
+
 	return View{
 		Config: r.Config,
 		Paths:  r.Paths,
 		Server: ViewServer{
-			PublicURL:       r.Server.PublicURL,
-			PublicBaseURL:   r.Server.PublicBaseURL,
-			PublicHost:      r.Server.PublicHost,
-			AuthorityDomain: r.Server.AuthorityDomain,
-			Port:            r.Server.Port,
-			ListenAddress:   r.Server.ListenAddress,
-			DevMode:         r.Server.DevMode,
+			PublicURL:            r.Server.PublicURL,
+			PublicBaseURL:        r.Server.PublicBaseURL,
+			PublicHost:           r.Server.PublicHost,
+			AuthorityDomain:      r.Server.AuthorityDomain,
+			Port:                 r.Server.Port,
+			ListenAddress:        r.Server.ListenAddress,
+			DevMode:              r.Server.DevMode,
+			AuthCodeLifetime:     r.Server.AuthCodeLifetime,
+			AccessTokenLifetime:  r.Server.AccessTokenLifetime,
+			RefreshTokenLifetime: r.Server.RefreshTokenLifetime,
+			TLS:                  r.Server.TLS.mode(),
+			AccessLog:            string(r.Server.AccessLog),
+			Maintenance:          r.Server.Maintenance,
 		},
 		Secrets: ViewSecrets{
-			SigningKeySet:      r.Secrets.SigningKey != nil,
+			SigningKeySet:      r.Secrets.SigningKey != nil || r.Secrets.Signer != nil,
 			VerificationKeySet: r.Source.VerificationKeyPresent,
 			BootstrapAPIKeySet: strings.TrimSpace(r.Secrets.BootstrapAPIKey) != "",
+			Provider:           cmp.Or(r.Config.Secrets.Provider, SecretsProviderFile),
 		},
 		Source: ViewSource{
 			ConfigFilePresent:      r.Source.ConfigFilePresent,
@@ -195,6 +768,7 @@ func (r Runtime) View() View {
 			BootstrapAPIKeySource:  r.Source.BootstrapAPIKeySource,
 			VerificationKeyPresent: r.Source.VerificationKeyPresent,
 		},
+		Realms: realms,
 	}
 }
 
@@ -216,7 +790,11 @@ func normalizePublicURL(raw string) (string, *url.URL, error) {
 }
 
 func loadSecretBytes(path string, envVar string, base64Decode bool) ([]byte, SecretSource, error) {
-	if value, ok := os.LookupEnv(envVar); ok && strings.TrimSpace(value) != "" {
+	value, err := getenv(envVar)
+	if err != nil {
+		return nil, SecretSourceNone, err
+	}
+	if strings.TrimSpace(value) != "" {
 		if !base64Decode {
 			return []byte(value), SecretSourceEnv, nil
 		}
@@ -239,8 +817,99 @@ func loadSecretBytes(path string, envVar string, base64Decode bool) ([]byte, Sec
 	return data, SecretSourceFile, nil
 }
 
+// loadSigningKey reads the signing key from its file or environment
+// variable, decrypting it if need be.
+func loadSigningKey(
+	paths Paths,
+	opts RuntimeOptions,
+) (
+	*ecdsa.PrivateKey,
+	SecretSource,
+	error,
+) {
+	signingKeyDER, signingKeySource, err := loadSecretBytes(paths.SigningKeyFile, opts.env(EnvSigningKeyDERBase64), true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var signingKey *ecdsa.PrivateKey
+	if tokens.IsEncryptedPrivateKey(signingKeyDER) && opts.realm {
+		return nil, "", fmt.Errorf("config: %s is encrypted; realm signing keys must be stored unencrypted", paths.SigningKeyFile)
+	} else if tokens.IsEncryptedPrivateKey(signingKeyDER) {
+		passphrase, err := loadSigningKeyPassphrase(opts.PromptPassphrase)
+		if err != nil {
+			return nil, "", err
+		}
+		if passphrase != nil {
+			signingKey, err = tokens.ParseEncryptedPrivateKey(signingKeyDER, passphrase)
+			clear(passphrase)
+			if err != nil {
+				return nil, "", fmt.Errorf("config: decrypt signing key: %w", err)
+			}
+		} else if opts.RequireSigningKey {
+			return nil, "", fmt.Errorf("config: signing key is encrypted; set %s or the %s systemd credential", EnvSigningKeyPassphrase, SigningKeyPassphraseCredential)
+		}
+	} else if len(signingKeyDER) > 0 {
+		signingKey, err = tokens.ParsePrivateKey(signingKeyDER)
+		if err != nil {
+			return nil, "", fmt.Errorf("config: parse signing key: %w", err)
+		}
+	} else if opts.RequireSigningKey {
+		return nil, "", fmt.Errorf("config: signing key is required; %s", secretHint(opts.env(EnvSigningKeyDERBase64), paths.SigningKeyFile))
+	}
+	return signingKey, signingKeySource, nil
+}
+
+// loadSigningKeyPassphrase finds the passphrase for an encrypted signing key
+// in the environment, then the systemd credentials directory, then by
+// prompting. It returns nil if there is no source to ask.
+func loadSigningKeyPassphrase(prompt func() ([]byte, error)) ([]byte, error) {
+	value, err := getenv(EnvSigningKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	if value != "" {
+		return []byte(value), nil
+	}
+
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, SigningKeyPassphraseCredential))
+		if err == nil {
+			return bytes.TrimRight(data, "\r\n"), nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("config: read %s credential: %w", SigningKeyPassphraseCredential, err)
+		}
+	}
+
+	if prompt != nil {
+		passphrase, err := prompt()
+		if err != nil {
+			return nil, fmt.Errorf("config: read signing key passphrase: %w", err)
+		}
+		return passphrase, nil
+	}
+	return nil, nil
+}
+
+// secretHint says where a missing secret can be supplied. envVar is empty
+// when no environment variable is read.
+func secretHint(
+	envVar string,
+	path string,
+) string {
+	if envVar == "" {
+		return "create " + path
+	}
+	return fmt.Sprintf("set %s or create %s", envVar, path)
+}
+
 func loadSecretString(path string, envVar string) (string, SecretSource, error) {
-	if value, ok := os.LookupEnv(envVar); ok && strings.TrimSpace(value) != "" {
+	value, err := getenv(envVar)
+	if err != nil {
+		return "", SecretSourceNone, err
+	}
+	if strings.TrimSpace(value) != "" {
 		return strings.TrimSpace(value), SecretSourceEnv, nil
 	}
 
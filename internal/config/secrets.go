@@ -0,0 +1,385 @@
+package config
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens/vault"
+)
+
+// Secrets providers selectable with secrets.provider.
+const (
+	SecretsProviderFile    = "file"
+	SecretsProviderEnv     = "env"
+	SecretsProviderSystemd = "systemd"
+	SecretsProviderVault   = "vault"
+)
+
+const (
+	EnvVaultAddress = "VAULT_ADDR"
+	EnvVaultToken   = "VAULT_TOKEN"
+
+	// defaultVaultMount is where Vault mounts its KV version 2 engine by
+	// default.
+	defaultVaultMount = "secret"
+
+	vaultTimeout = 10 * time.Second
+)
+
+// SecretsConfig chooses where per-service secrets, such as upstream client
+// secrets and webhook signing secrets, are read from, so they needn't sit
+// beside config.yaml. Provider is "file" (the default: one file per secret
+// in the secrets directory), "env", "systemd", or "vault". Whatever the
+// provider, a secret's CONSENT_* environment variable overrides it.
+type SecretsConfig struct {
+	Provider string      `yaml:"provider,omitempty"`
+	Vault    VaultConfig `yaml:"vault,omitempty"`
+}
+
+// VaultConfig reads secrets from a HashiCorp Vault KV version 2 engine:
+// each secret is a field, named as its file would be, of the entry at Path
+// in the engine mounted at Mount ("secret" by default). Address defaults to
+// VAULT_ADDR, and the token is read from VAULT_TOKEN.
+type VaultConfig struct {
+	Address string `yaml:"address,omitempty"`
+	Mount   string `yaml:"mount,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+}
+
+// SigningConfig chooses how tokens are signed. With Vault.Key set, they are
+// signed by a Vault transit key in place of the signing key file. Workers,
+// when positive, bounds how many tokens are signed at once, and Queue how
+// many more may wait before issuing fails with 503 busy, so a refresh storm
+// degrades rather than piling up work.
+type SigningConfig struct {
+	Vault   VaultTransitConfig `yaml:"vault,omitempty"`
+	Workers int                `yaml:"workers,omitempty"`
+	Queue   int                `yaml:"queue,omitempty"`
+}
+
+// VaultTransitConfig names an ecdsa-p256 key in Vault's transit engine
+// mounted at Mount ("transit" by default). Address defaults to VAULT_ADDR,
+// and the token is read from VAULT_TOKEN.
+type VaultTransitConfig struct {
+	Address string `yaml:"address,omitempty"`
+	Mount   string `yaml:"mount,omitempty"`
+	Key     string `yaml:"key,omitempty"`
+}
+
+func (v VaultTransitConfig) validate() error {
+	if v.Key == "" && (v.Address != "" || v.Mount != "") {
+		return fmt.Errorf("key is required")
+	}
+	return validateVaultAddress(v.Address)
+}
+
+// newVaultSigner connects to the transit key cfg names.
+func newVaultSigner(
+	cfg VaultTransitConfig,
+) (
+	*vault.TransitSigner,
+	error,
+) {
+	token, err := getenv(EnvVaultToken)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	signer, err := vault.NewTransitSigner(ctx, vault.Options{
+		Address: cmp.Or(cfg.Address, os.Getenv(EnvVaultAddress)),
+		Token:   strings.TrimSpace(token),
+		Mount:   cfg.Mount,
+		Key:     cfg.Key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("config: signing key: %w", err)
+	}
+	return signer, nil
+}
+
+func validateVaultAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+	parsed, err := url.Parse(address)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("vault address must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func (s SecretsConfig) validate() error {
+	switch s.Provider {
+	case "", SecretsProviderFile, SecretsProviderEnv, SecretsProviderSystemd:
+	case SecretsProviderVault:
+		if s.Vault.Path == "" {
+			return fmt.Errorf("vault.path is required")
+		}
+		if err := validateVaultAddress(s.Vault.Address); err != nil {
+			return fmt.Errorf("vault.address: %w", err)
+		}
+	default:
+		return fmt.Errorf("provider %q is not supported; use %q, %q, %q, or %q", s.Provider,
+			SecretsProviderFile, SecretsProviderEnv, SecretsProviderSystemd, SecretsProviderVault)
+	}
+	return nil
+}
+
+// SecretsProvider looks up per-service secrets by name, such as
+// "webhook_audit_secret". A secret the provider doesn't hold is empty, not
+// an error.
+type SecretsProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// FileSecrets reads each secret from the file of its name in Dir.
+type FileSecrets struct {
+	Dir string
+}
+
+func (f FileSecrets) Secret(
+	_ context.Context,
+	name string,
+) (
+	string,
+	error,
+) {
+	return readSecretFile(filepath.Join(f.Dir, name))
+}
+
+// EnvSecrets reads each secret from the environment variable SecretEnv
+// names for it, or the file its EnvFileSuffix companion names.
+type EnvSecrets struct{}
+
+func (EnvSecrets) Secret(
+	_ context.Context,
+	name string,
+) (
+	string,
+	error,
+) {
+	value, err := getenv(SecretEnv(name))
+	return strings.TrimSpace(value), err
+}
+
+// SecretEnv returns the environment variable holding the secret name, such
+// as CONSENT_WEBHOOK_AUDIT_SECRET for "webhook_audit_secret".
+func SecretEnv(name string) string {
+	return "CONSENT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// CredentialSecrets reads each secret from the systemd credential of its
+// name, as passed with LoadCredential= or LoadCredentialEncrypted=. Dir is
+// the credentials directory, $CREDENTIALS_DIRECTORY when empty.
+type CredentialSecrets struct {
+	Dir string
+}
+
+func (c CredentialSecrets) Secret(
+	_ context.Context,
+	name string,
+) (
+	string,
+	error,
+) {
+	dir := c.Dir
+	if dir == "" {
+		dir = os.Getenv("CREDENTIALS_DIRECTORY")
+	}
+	if dir == "" {
+		return "", fmt.Errorf("config: read %s credential: CREDENTIALS_DIRECTORY is not set", name)
+	}
+	return readSecretFile(filepath.Join(dir, name))
+}
+
+// VaultSecrets reads secrets from the fields of one entry in a Vault KV
+// version 2 engine. The entry is fetched once, on the first lookup.
+type VaultSecrets struct {
+	Address    string
+	Token      string
+	Mount      string
+	Path       string
+	HTTPClient *http.Client
+
+	once   sync.Once
+	fields map[string]any
+	err    error
+}
+
+func (v *VaultSecrets) Secret(
+	ctx context.Context,
+	name string,
+) (
+	string,
+	error,
+) {
+	v.once.Do(func() { v.fields, v.err = v.fetch(ctx) })
+	if v.err != nil {
+		return "", v.err
+	}
+
+	value, ok := v.fields[name]
+	if !ok {
+		return "", nil
+	}
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("config: vault field %q is not a string", name)
+	}
+	return strings.TrimSpace(secret), nil
+}
+
+func (v *VaultSecrets) fetch(
+	ctx context.Context,
+) (
+	map[string]any,
+	error,
+) {
+	if v.Address == "" {
+		return nil, fmt.Errorf("config: vault address is required; set secrets.vault.address or %s", EnvVaultAddress)
+	}
+	if v.Token == "" {
+		return nil, fmt.Errorf("config: vault token is required; set %s", EnvVaultToken)
+	}
+	mount := v.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	client := v.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: vaultTimeout}
+	}
+
+	endpoint := strings.TrimRight(v.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/data/" + strings.Trim(v.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("config: vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("config: read vault secrets: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("config: read vault secrets at %s/%s: %s", mount, v.Path, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("config: decode vault secrets: %w", err)
+	}
+	return body.Data.Data, nil
+}
+
+// newSecretsProvider builds the provider cfg selects. Realms read nothing
+// from the environment, so they may only keep secrets in files.
+func newSecretsProvider(
+	cfg SecretsConfig,
+	paths Paths,
+	opts RuntimeOptions,
+) (
+	SecretsProvider,
+	error,
+) {
+	if opts.realm && cfg.Provider != "" && cfg.Provider != SecretsProviderFile {
+		return nil, fmt.Errorf("config: realm secrets must use the %q provider", SecretsProviderFile)
+	}
+
+	switch cfg.Provider {
+	case SecretsProviderEnv:
+		return EnvSecrets{}, nil
+	case SecretsProviderSystemd:
+		return CredentialSecrets{}, nil
+	case SecretsProviderVault:
+		address := cfg.Vault.Address
+		if address == "" {
+			address = os.Getenv(EnvVaultAddress)
+		}
+		token, err := getenv(EnvVaultToken)
+		if err != nil {
+			return nil, err
+		}
+		return &VaultSecrets{
+			Address: address,
+			Token:   strings.TrimSpace(token),
+			Mount:   cfg.Vault.Mount,
+			Path:    cfg.Vault.Path,
+		}, nil
+	default:
+		return FileSecrets{Dir: paths.SecretsDir}, nil
+	}
+}
+
+// loadServiceSecret reads the secret name, preferring envVar when it is set.
+// It returns a hint for supplying the secret alongside an empty one.
+func loadServiceSecret(
+	provider SecretsProvider,
+	name string,
+	envVar string,
+) (
+	string,
+	string,
+	error,
+) {
+	value, err := getenv(envVar)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(value) != "" {
+		return strings.TrimSpace(value), "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	secret, err := provider.Secret(ctx, name)
+	if err != nil || secret != "" {
+		return secret, "", err
+	}
+
+	var hint string
+	switch p := provider.(type) {
+	case FileSecrets:
+		hint = secretHint(envVar, filepath.Join(p.Dir, name))
+	case EnvSecrets:
+		hint = "set " + SecretEnv(name)
+	case CredentialSecrets:
+		hint = fmt.Sprintf("pass the %s systemd credential", name)
+	case *VaultSecrets:
+		hint = fmt.Sprintf("add the %s field to %s in vault", name, p.Path)
+	default:
+		hint = "add " + name + " to the secrets provider"
+	}
+	return "", hint, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("config: read %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
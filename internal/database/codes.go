@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// InsertAuthorizationCode stores a code for the user identified by subject.
+// Returns sql.ErrNoRows if the user does not exist.
+func (db *DB) InsertAuthorizationCode(
+	ctx context.Context,
+	code *service.AuthorizationCode,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var authTime int64
+	if !code.AuthTime.IsZero() {
+		authTime = code.AuthTime.Unix()
+	}
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO authorization_code (code_hash, owner, integration, scopes, expires_at, session_only, auth_time)
+		SELECT ?1, u.id, ?3, ?4, ?5, ?6, ?7
+		FROM user u
+		WHERE u.subject=?2`,
+		code.CodeHash,
+		code.Subject,
+		code.Integration,
+		strings.Join(code.Scopes, " "),
+		code.ExpiresAt.Unix(),
+		code.SessionOnly,
+		authTime,
+	)
+	if err != nil {
+		return fmt.Errorf("insert authorization code: %w", err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes a code and returns it, so each code can
+// only be redeemed once. Returns sql.ErrNoRows if no code matches.
+func (db *DB) ConsumeAuthorizationCode(
+	ctx context.Context,
+	codeHash string,
+) (
+	*service.AuthorizationCode,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin authorization code transaction: %w", err)
+	}
+
+	row := tx.QueryRowContext(ctx, `
+		SELECT c.code_hash, u.subject, c.integration, c.scopes, c.expires_at, c.session_only, c.auth_time
+		FROM authorization_code c
+		JOIN user u ON c.owner = u.id
+		WHERE c.code_hash=?1`,
+		codeHash,
+	)
+
+	var code service.AuthorizationCode
+	var scopes string
+	var expiresAt, authTime int64
+	if err := row.Scan(
+		&code.CodeHash,
+		&code.Subject,
+		&code.Integration,
+		&scopes,
+		&expiresAt,
+		&code.SessionOnly,
+		&authTime,
+	); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("couldn't scan authorization code: %w", err)
+	}
+	code.Scopes = strings.Fields(scopes)
+	code.ExpiresAt = time.Unix(expiresAt, 0)
+	if authTime != 0 {
+		code.AuthTime = time.Unix(authTime, 0)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM authorization_code
+		WHERE code_hash=?1`,
+		codeHash,
+	); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("delete authorization code: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit authorization code consumption: %w", err)
+	}
+	return &code, nil
+}
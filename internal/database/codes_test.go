@@ -0,0 +1,69 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/database"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func insertAuthorizationCode(t *testing.T, store *database.DB, hash string) {
+	t.Helper()
+	if err := store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), nil); err != nil {
+		t.Fatalf("InsertUser failed: %v", err)
+	}
+	if err := store.InsertIntegration(t.Context(), service.Integration{Name: "code-app", Display: "Code App", Audience: "code.test", Redirect: "https://code.test/callback"}); err != nil {
+		t.Fatalf("InsertIntegration failed: %v", err)
+	}
+	err := store.InsertAuthorizationCode(t.Context(), &service.AuthorizationCode{
+		CodeHash:    hash,
+		Subject:     "subject-alice",
+		Integration: "code-app",
+		Scopes:      []string{"identity", "profile"},
+		ExpiresAt:   time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("InsertAuthorizationCode failed: %v", err)
+	}
+}
+
+func TestAuthorizationCode_ConsumeOnce(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertAuthorizationCode(t, store, "hash-1")
+
+	code, err := store.ConsumeAuthorizationCode(t.Context(), "hash-1")
+	if err != nil {
+		t.Fatalf("ConsumeAuthorizationCode failed: %v", err)
+	}
+	if code.Subject != "subject-alice" || code.Integration != "code-app" {
+		t.Fatalf("code = %#v, want subject-alice for code-app", code)
+	}
+	if len(code.Scopes) != 2 || code.ExpiresAt.IsZero() {
+		t.Fatalf("code = %#v, want scopes and expiry preserved", code)
+	}
+
+	_, err = store.ConsumeAuthorizationCode(t.Context(), "hash-1")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second consume err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestAuthorizationCode_UnknownUser(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	err := store.InsertAuthorizationCode(t.Context(), &service.AuthorizationCode{
+		CodeHash:    "hash-1",
+		Subject:     "subject-nobody",
+		Integration: "code-app",
+		ExpiresAt:   time.Now().Add(time.Minute),
+	})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -1,51 +1,78 @@
 package database
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/keys"
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	_ "modernc.org/sqlite"
 )
 
+// Defaults for Options fields left zero.
+const (
+	DefaultBusyTimeout  = 5 * time.Second
+	DefaultMaxOpenConns = 4
+	DefaultQueryTimeout = 10 * time.Second
+)
+
+// Options configures the SQLite store. WAL lets readers proceed while a
+// write is in progress, which is what allows more than one connection;
+// without it, or for in-memory databases, the pool is a single connection.
+// BusyTimeout is how long a connection waits on a lock before failing with
+// "database is locked". QueryTimeout bounds every store call, including any
+// wait for a lock, on top of the caller's own deadline.
 type Options struct {
-	Path string
-	WAL  bool
+	Path         string
+	WAL          bool
+	BusyTimeout  time.Duration
+	MaxOpenConns int
+	QueryTimeout time.Duration
 }
 
 type DB struct {
 	Conn      *sql.DB
 	KeysStore *keys.SQLStore
+
+	queryTimeout time.Duration
 }
 
 var _ service.Store = (*DB)(nil)
 
 func Open(opts Options) (*DB, error) {
-	conn, err := sql.Open("sqlite", opts.Path)
-	if err != nil {
-		return nil, fmt.Errorf("open database: %w", err)
+	if opts.BusyTimeout < 0 || opts.MaxOpenConns < 0 || opts.QueryTimeout < 0 {
+		return nil, fmt.Errorf("open database: timeouts and max open connections cannot be negative")
 	}
+	busyTimeout := cmp.Or(opts.BusyTimeout, DefaultBusyTimeout)
+	wal := opts.WAL && isFileBackedSQLite(opts.Path)
 
-	conn.SetMaxOpenConns(1)
+	maxOpenConns := 1
+	if wal {
+		maxOpenConns = cmp.Or(opts.MaxOpenConns, DefaultMaxOpenConns)
+	}
 
-	if _, err := conn.Exec("PRAGMA foreign_keys = ON;"); err != nil {
-		_ = conn.Close()
-		return nil, fmt.Errorf("enable foreign keys: %w", err)
+	conn, err := sql.Open("sqlite", dataSourceName(opts.Path, busyTimeout, wal))
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
 	}
-	if _, err := conn.Exec("PRAGMA busy_timeout = 5000;"); err != nil {
+	conn.SetMaxOpenConns(maxOpenConns)
+	conn.SetMaxIdleConns(maxOpenConns)
+
+	// connect now so bad pragmas or an unwritable path fail at startup
+	if err := conn.Ping(); err != nil {
 		_ = conn.Close()
-		return nil, fmt.Errorf("set busy timeout: %w", err)
-	}
-	if opts.WAL && isFileBackedSQLite(opts.Path) {
-		if _, err := conn.Exec("PRAGMA journal_mode = WAL;"); err != nil {
-			_ = conn.Close()
-			return nil, fmt.Errorf("enable wal mode: %w", err)
-		}
+		return nil, fmt.Errorf("open database: %w", err)
 	}
 
-	db := &DB{Conn: conn}
+	db := &DB{
+		Conn:         conn,
+		queryTimeout: cmp.Or(opts.QueryTimeout, DefaultQueryTimeout),
+	}
 	if err := db.migrate(); err != nil {
 		_ = conn.Close()
 		return nil, fmt.Errorf("run migrations: %w", err)
@@ -65,6 +92,57 @@ func (db *DB) Close() error {
 	return db.Conn.Close()
 }
 
+// Ping checks the database still answers queries.
+func (db *DB) Ping(
+	ctx context.Context,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var one int
+	if err := db.Conn.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return fmt.Errorf("ping database: %w", err)
+	}
+	return nil
+}
+
+// withTimeout bounds a store call by the query timeout, so a stuck database
+// fails the request instead of hanging it.
+func (db *DB) withTimeout(
+	ctx context.Context,
+) (
+	context.Context,
+	context.CancelFunc,
+) {
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// dataSourceName adds the connection pragmas to path. They are applied by
+// the driver to every connection the pool opens, not just the first.
+// Transactions begin IMMEDIATE so a transaction that will write takes the
+// write lock up front, where busy_timeout applies, rather than failing when
+// it upgrades from a read.
+func dataSourceName(
+	path string,
+	busyTimeout time.Duration,
+	wal bool,
+) string {
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	params.Add("_pragma", "foreign_keys(1)")
+	if wal {
+		params.Add("_pragma", "journal_mode(WAL)")
+		params.Add("_pragma", "synchronous(NORMAL)")
+	}
+	params.Set("_txlock", "immediate")
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + params.Encode()
+}
+
 func isFileBackedSQLite(path string) bool {
 	if path == ":memory:" {
 		return false
@@ -1,7 +1,12 @@
 package database_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/database"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
@@ -22,12 +27,12 @@ func TestOpen_CreatesSchema(t *testing.T) {
 	store := testutil.SetupTestDB(t)
 
 	// schema is created - insert and retrieve works
-	err := store.InsertUser("subject-test-user", "test-user", []byte("secret-hash"), nil)
+	err := store.InsertUser(t.Context(), "subject-test-user", "test-user", []byte("secret-hash"), nil)
 	if err != nil {
 		t.Fatalf("schema not created - InsertUser failed: %v", err)
 	}
 
-	secret, err := store.GetSecret("test-user")
+	secret, err := store.GetSecret(t.Context(), "test-user")
 	if err != nil {
 		t.Fatalf("schema not created - GetSecret failed: %v", err)
 	}
@@ -62,7 +67,7 @@ func TestOpen_ExistingDatabaseRunsMigrationsOnce(t *testing.T) {
 	if err != nil {
 		t.Fatalf("first database.Open failed: %v", err)
 	}
-	if err := first.InsertUser("subject-alice", "alice", []byte("secret"), nil); err != nil {
+	if err := first.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), nil); err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 	if err := first.Close(); err != nil {
@@ -75,7 +80,7 @@ func TestOpen_ExistingDatabaseRunsMigrationsOnce(t *testing.T) {
 	}
 	defer second.Close()
 
-	secret, err := second.GetSecret("alice")
+	secret, err := second.GetSecret(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetSecret failed: %v", err)
 	}
@@ -83,3 +88,123 @@ func TestOpen_ExistingDatabaseRunsMigrationsOnce(t *testing.T) {
 		t.Fatalf("secret = %q, want %q", string(secret), "secret")
 	}
 }
+
+func TestOpen_WALConfiguresEveryConnection(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir() + "/consent.sqlite"
+	store, err := database.Open(database.Options{
+		Path:         path,
+		WAL:          true,
+		BusyTimeout:  2 * time.Second,
+		MaxOpenConns: 3,
+	})
+	if err != nil {
+		t.Fatalf("database.Open failed: %v", err)
+	}
+	defer store.Close()
+
+	if got := store.Conn.Stats().MaxOpenConnections; got != 3 {
+		t.Fatalf("MaxOpenConnections = %d, want 3", got)
+	}
+
+	// hold every connection at once so each one is checked, not just the first
+	ctx := context.Background()
+	for i := range 3 {
+		conn, err := store.Conn.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Conn failed: %v", err)
+		}
+		defer conn.Close()
+
+		var journalMode string
+		var foreignKeys, busyTimeout int
+		if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+			t.Fatalf("journal_mode query failed: %v", err)
+		}
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			t.Fatalf("foreign_keys query failed: %v", err)
+		}
+		if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+			t.Fatalf("busy_timeout query failed: %v", err)
+		}
+		if journalMode != "wal" || foreignKeys != 1 || busyTimeout != 2000 {
+			t.Errorf("connection %d: journal_mode=%s foreign_keys=%d busy_timeout=%d", i, journalMode, foreignKeys, busyTimeout)
+		}
+	}
+}
+
+func TestOpen_InMemoryUsesSingleConnection(t *testing.T) {
+	t.Parallel()
+
+	store, err := database.Open(database.Options{Path: ":memory:", WAL: true, MaxOpenConns: 8})
+	if err != nil {
+		t.Fatalf("database.Open failed: %v", err)
+	}
+	defer store.Close()
+
+	// each in-memory connection would be a separate, empty database
+	if got := store.Conn.Stats().MaxOpenConnections; got != 1 {
+		t.Fatalf("MaxOpenConnections = %d, want 1", got)
+	}
+}
+
+func TestOpen_ConcurrentWritesDoNotLock(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir() + "/consent.sqlite"
+	store, err := database.Open(database.Options{Path: path, WAL: true})
+	if err != nil {
+		t.Fatalf("database.Open failed: %v", err)
+	}
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle := fmt.Sprintf("user-%d", i)
+			if err := store.InsertUser(t.Context(), "subject-"+handle, handle, []byte("secret"), nil); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := store.GetSecret(t.Context(), handle); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+}
+
+func TestStore_CanceledContextStopsQuery(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	// a request that has gone away doesn't reach the database
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := store.ListUsers(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ListUsers error = %v, want context.Canceled", err)
+	}
+}
+
+func TestStore_QueryTimeout(t *testing.T) {
+	t.Parallel()
+
+	store, err := database.Open(database.Options{Path: ":memory:", QueryTimeout: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("database.Open failed: %v", err)
+	}
+	defer store.Close()
+
+	// every call is bounded by the store's query timeout
+	if _, err := store.ListUsers(t.Context()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ListUsers error = %v, want context.DeadlineExceeded", err)
+	}
+}
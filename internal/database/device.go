@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+func (db *DB) InsertDeviceAuthorization(
+	ctx context.Context,
+	authorization *service.DeviceAuthorization,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO device_authorization (
+			device_code_hash,
+			user_code,
+			integration,
+			scopes,
+			status,
+			expires_at,
+			poll_interval
+		)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)`,
+		authorization.DeviceCodeHash,
+		authorization.UserCode,
+		authorization.Integration,
+		strings.Join(authorization.Scopes, " "),
+		string(authorization.Status),
+		authorization.ExpiresAt.Unix(),
+		int64(authorization.Interval/time.Second),
+	)
+	if err != nil {
+		return fmt.Errorf("insert device authorization: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) GetDeviceAuthorization(
+	ctx context.Context,
+	deviceCodeHash string,
+) (
+	*service.DeviceAuthorization,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.Conn.QueryRowContext(ctx, `
+		SELECT d.device_code_hash, d.user_code, d.integration, d.scopes, d.status,
+			COALESCE(u.subject, ''), d.expires_at, d.poll_interval, d.last_polled_at, d.auth_time
+		FROM device_authorization d
+		LEFT JOIN user u ON d.owner = u.id
+		WHERE d.device_code_hash=?1`,
+		deviceCodeHash,
+	)
+	return scanDeviceAuthorization(row)
+}
+
+func (db *DB) GetDeviceAuthorizationByUserCode(
+	ctx context.Context,
+	userCode string,
+) (
+	*service.DeviceAuthorization,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.Conn.QueryRowContext(ctx, `
+		SELECT d.device_code_hash, d.user_code, d.integration, d.scopes, d.status,
+			COALESCE(u.subject, ''), d.expires_at, d.poll_interval, d.last_polled_at, d.auth_time
+		FROM device_authorization d
+		LEFT JOIN user u ON d.owner = u.id
+		WHERE d.user_code=?1`,
+		userCode,
+	)
+	return scanDeviceAuthorization(row)
+}
+
+// DecideDeviceAuthorization records the user's decision on a pending device
+// authorization, and when they last entered their credentials. Returns
+// sql.ErrNoRows if no pending authorization matches.
+func (db *DB) DecideDeviceAuthorization(
+	ctx context.Context,
+	userCode string,
+	subject string,
+	status service.DeviceAuthorizationStatus,
+	authTime time.Time,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var authTimeUnix int64
+	if !authTime.IsZero() {
+		authTimeUnix = authTime.Unix()
+	}
+	result, err := db.Conn.ExecContext(ctx, `
+		UPDATE device_authorization
+		SET status=?1, owner=(SELECT id FROM user WHERE subject=?2), auth_time=?5
+		WHERE user_code=?3 AND status=?4`,
+		string(status),
+		subject,
+		userCode,
+		string(service.DeviceAuthorizationPending),
+		authTimeUnix,
+	)
+	if err != nil {
+		return fmt.Errorf("decide device authorization: %w", err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateDeviceAuthorizationPoll records when the device last polled and the
+// interval it must keep before polling again.
+func (db *DB) UpdateDeviceAuthorizationPoll(
+	ctx context.Context,
+	deviceCodeHash string,
+	polledAt time.Time,
+	interval time.Duration,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.Conn.ExecContext(ctx, `
+		UPDATE device_authorization
+		SET last_polled_at=?1, poll_interval=?2
+		WHERE device_code_hash=?3`,
+		polledAt.Unix(),
+		int64(interval/time.Second),
+		deviceCodeHash,
+	)
+	if err != nil {
+		return fmt.Errorf("update device authorization poll: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteDeviceAuthorization(
+	ctx context.Context,
+	deviceCodeHash string,
+) (
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		DELETE FROM device_authorization
+		WHERE device_code_hash=?1`,
+		deviceCodeHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete device authorization: %w", err)
+	}
+	return !resultsEmpty(result), nil
+}
+
+func scanDeviceAuthorization(
+	row *sql.Row,
+) (
+	*service.DeviceAuthorization,
+	error,
+) {
+	var authorization service.DeviceAuthorization
+	var scopes, status string
+	var expiresAt, interval, lastPolledAt, authTime int64
+	err := row.Scan(
+		&authorization.DeviceCodeHash,
+		&authorization.UserCode,
+		&authorization.Integration,
+		&scopes,
+		&status,
+		&authorization.Subject,
+		&expiresAt,
+		&interval,
+		&lastPolledAt,
+		&authTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	authorization.Scopes = strings.Fields(scopes)
+	authorization.Status = service.DeviceAuthorizationStatus(status)
+	authorization.ExpiresAt = time.Unix(expiresAt, 0)
+	authorization.Interval = time.Duration(interval) * time.Second
+	if lastPolledAt > 0 {
+		authorization.LastPolledAt = time.Unix(lastPolledAt, 0)
+	}
+	authorization.AuthTime = unixOrZero(authTime)
+	return &authorization, nil
+}
@@ -0,0 +1,121 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/database"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func insertDeviceAuthorization(t *testing.T, store *database.DB, hash, userCode string) {
+	t.Helper()
+	if err := store.InsertIntegration(t.Context(), service.Integration{Name: "device-app", Display: "Device App", Audience: "device.test", Redirect: "https://device.test/callback"}); err != nil {
+		t.Fatalf("InsertIntegration failed: %v", err)
+	}
+	err := store.InsertDeviceAuthorization(t.Context(), &service.DeviceAuthorization{
+		DeviceCodeHash: hash,
+		UserCode:       userCode,
+		Integration:    "device-app",
+		Scopes:         []string{"identity", "profile"},
+		Status:         service.DeviceAuthorizationPending,
+		ExpiresAt:      time.Now().Add(time.Minute),
+		Interval:       5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("InsertDeviceAuthorization failed: %v", err)
+	}
+}
+
+func TestDeviceAuthorization_InsertAndGet(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertDeviceAuthorization(t, store, "hash-1", "BCDF-GHJK")
+
+	byHash, err := store.GetDeviceAuthorization(t.Context(), "hash-1")
+	if err != nil {
+		t.Fatalf("GetDeviceAuthorization failed: %v", err)
+	}
+	if byHash.UserCode != "BCDF-GHJK" || byHash.Status != service.DeviceAuthorizationPending {
+		t.Fatalf("authorization = %#v, want pending BCDF-GHJK", byHash)
+	}
+	if len(byHash.Scopes) != 2 || byHash.Interval != 5*time.Second {
+		t.Fatalf("authorization = %#v, want scopes and interval preserved", byHash)
+	}
+
+	byCode, err := store.GetDeviceAuthorizationByUserCode(t.Context(), "BCDF-GHJK")
+	if err != nil {
+		t.Fatalf("GetDeviceAuthorizationByUserCode failed: %v", err)
+	}
+	if byCode.DeviceCodeHash != "hash-1" {
+		t.Fatalf("device code hash = %q, want hash-1", byCode.DeviceCodeHash)
+	}
+}
+
+func TestDeviceAuthorization_DuplicateUserCode(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertDeviceAuthorization(t, store, "hash-1", "BCDF-GHJK")
+
+	err := store.InsertDeviceAuthorization(t.Context(), &service.DeviceAuthorization{
+		DeviceCodeHash: "hash-2",
+		UserCode:       "BCDF-GHJK",
+		Integration:    "device-app",
+		Status:         service.DeviceAuthorizationPending,
+		ExpiresAt:      time.Now().Add(time.Minute),
+	})
+	if err == nil {
+		t.Fatal("expected error for duplicate user code")
+	}
+}
+
+func TestDecideDeviceAuthorization_OnlyOnce(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+	insertDeviceAuthorization(t, store, "hash-1", "BCDF-GHJK")
+
+	authTime := time.Unix(1700000000, 0)
+	err := store.DecideDeviceAuthorization(t.Context(), "BCDF-GHJK", "subject-alice", service.DeviceAuthorizationApproved, authTime)
+	if err != nil {
+		t.Fatalf("DecideDeviceAuthorization failed: %v", err)
+	}
+
+	authorization, err := store.GetDeviceAuthorization(t.Context(), "hash-1")
+	if err != nil {
+		t.Fatalf("GetDeviceAuthorization failed: %v", err)
+	}
+	if authorization.Status != service.DeviceAuthorizationApproved || authorization.Subject != "subject-alice" {
+		t.Fatalf("authorization = %#v, want approved by alice", authorization)
+	}
+	if !authorization.AuthTime.Equal(authTime) {
+		t.Fatalf("auth time = %v, want %v", authorization.AuthTime, authTime)
+	}
+
+	// a decided authorization cannot be changed
+	err = store.DecideDeviceAuthorization(t.Context(), "BCDF-GHJK", "subject-alice", service.DeviceAuthorizationDenied, time.Time{})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteDeviceAuthorization(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertDeviceAuthorization(t, store, "hash-1", "BCDF-GHJK")
+
+	deleted, err := store.DeleteDeviceAuthorization(t.Context(), "hash-1")
+	if err != nil || !deleted {
+		t.Fatalf("DeleteDeviceAuthorization = %v, %v; want true, nil", deleted, err)
+	}
+	deleted, err = store.DeleteDeviceAuthorization(t.Context(), "hash-1")
+	if err != nil || deleted {
+		t.Fatalf("DeleteDeviceAuthorization = %v, %v; want false, nil", deleted, err)
+	}
+	if _, err := store.GetDeviceAuthorization(t.Context(), "hash-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("error = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+func (db *DB) GetUserByExternalIdentity(
+	ctx context.Context,
+	provider string,
+	externalSubject string,
+) (
+	*service.User,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT u.subject, u.handle, NOT u.enabled, u.expires_at, u.terms_version, u.terms_accepted_at, r.name
+		FROM external_identity e
+		JOIN user u ON e.owner = u.id
+		LEFT JOIN user_roles ur ON u.subject = ur.user_subject
+		LEFT JOIN role r ON ur.role_name = r.name
+		WHERE e.provider=?1 AND e.external_subject=?2`,
+		provider,
+		externalSubject,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query user by external identity %q: %w", provider, err)
+	}
+	defer rows.Close()
+
+	return scanUserRows(rows)
+}
+
+func (db *DB) InsertExternalIdentity(
+	ctx context.Context,
+	subject string,
+	provider string,
+	externalSubject string,
+	createdAt time.Time,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO external_identity (provider, external_subject, owner, created_at)
+		SELECT ?1, ?2, u.id, ?3
+		FROM user u
+		WHERE u.subject=?4`,
+		provider,
+		externalSubject,
+		createdAt.Unix(),
+		subject,
+	)
+	if err != nil {
+		return fmt.Errorf("insert external identity: %w", err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *DB) ListExternalIdentities(
+	ctx context.Context,
+	subject string,
+) (
+	[]service.ExternalIdentity,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT e.provider, e.external_subject, e.created_at
+		FROM external_identity e
+		JOIN user u ON e.owner = u.id
+		WHERE u.subject=?1
+		ORDER BY e.provider, e.created_at`,
+		subject,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query external identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []service.ExternalIdentity{}
+	for rows.Next() {
+		var identity service.ExternalIdentity
+		var createdAt int64
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan external identity: %w", err)
+		}
+		identity.CreatedAt = time.Unix(createdAt, 0)
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate external identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+func (db *DB) DeleteExternalIdentities(
+	ctx context.Context,
+	subject string,
+	provider string,
+) (
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		DELETE FROM external_identity
+		WHERE provider=?1
+		AND owner=(SELECT id FROM user WHERE subject=?2)`,
+		provider,
+		subject,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete external identities: %w", err)
+	}
+	return !resultsEmpty(result), nil
+}
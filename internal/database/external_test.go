@@ -0,0 +1,139 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestInsertExternalIdentity_ResolvesUser(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+
+	if err := store.InsertExternalIdentity(t.Context(), "subject-alice", "corp", "ext-123", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+
+	user, err := store.GetUserByExternalIdentity(t.Context(), "corp", "ext-123")
+	if err != nil {
+		t.Fatalf("GetUserByExternalIdentity failed: %v", err)
+	}
+	if user.Subject != "subject-alice" || user.Handle != "alice" {
+		t.Fatalf("user = %#v, want alice", user)
+	}
+}
+
+func TestInsertExternalIdentity_UnknownUser(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	err := store.InsertExternalIdentity(t.Context(), "subject-missing", "corp", "ext-123", time.Now())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestInsertExternalIdentity_DuplicateLink(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+	insertUser(t, store, "bob", nil)
+
+	if err := store.InsertExternalIdentity(t.Context(), "subject-alice", "corp", "ext-123", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+
+	// the same upstream subject cannot be linked to a second user
+	if err := store.InsertExternalIdentity(t.Context(), "subject-bob", "corp", "ext-123", time.Now()); err == nil {
+		t.Fatal("expected error for duplicate external identity")
+	}
+}
+
+func TestGetUserByExternalIdentity_RemovedWithUser(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+
+	if err := store.InsertExternalIdentity(t.Context(), "subject-alice", "corp", "ext-123", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+	if _, err := store.DeleteUser(t.Context(), "subject-alice"); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	_, err := store.GetUserByExternalIdentity(t.Context(), "corp", "ext-123")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestListExternalIdentities_ReturnsUserLinks(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+	insertUser(t, store, "bob", nil)
+
+	for _, link := range []struct{ subject, provider, external string }{
+		{"subject-alice", "corp", "ext-alice"},
+		{"subject-alice", "github", "gh-alice"},
+		{"subject-bob", "corp", "ext-bob"},
+	} {
+		if err := store.InsertExternalIdentity(t.Context(), link.subject, link.provider, link.external, time.Now()); err != nil {
+			t.Fatalf("InsertExternalIdentity failed: %v", err)
+		}
+	}
+
+	identities, err := store.ListExternalIdentities(t.Context(), "subject-alice")
+	if err != nil {
+		t.Fatalf("ListExternalIdentities failed: %v", err)
+	}
+	if len(identities) != 2 {
+		t.Fatalf("identities = %d, want 2", len(identities))
+	}
+	if identities[0].Provider != "corp" || identities[0].Subject != "ext-alice" {
+		t.Fatalf("identities[0] = %#v, want corp link", identities[0])
+	}
+	if identities[1].Provider != "github" || identities[1].Subject != "gh-alice" {
+		t.Fatalf("identities[1] = %#v, want github link", identities[1])
+	}
+}
+
+func TestDeleteExternalIdentities_OnlyRemovesOwnLinks(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+	insertUser(t, store, "bob", nil)
+
+	if err := store.InsertExternalIdentity(t.Context(), "subject-alice", "corp", "ext-alice", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+	if err := store.InsertExternalIdentity(t.Context(), "subject-bob", "corp", "ext-bob", time.Now()); err != nil {
+		t.Fatalf("InsertExternalIdentity failed: %v", err)
+	}
+
+	deleted, err := store.DeleteExternalIdentities(t.Context(), "subject-alice", "corp")
+	if err != nil {
+		t.Fatalf("DeleteExternalIdentities failed: %v", err)
+	}
+	if !deleted {
+		t.Fatal("expected link to be deleted")
+	}
+
+	// a second delete finds nothing
+	deleted, err = store.DeleteExternalIdentities(t.Context(), "subject-alice", "corp")
+	if err != nil {
+		t.Fatalf("DeleteExternalIdentities failed: %v", err)
+	}
+	if deleted {
+		t.Fatal("expected no link to be deleted")
+	}
+
+	// bob's link is untouched
+	if _, err := store.GetUserByExternalIdentity(t.Context(), "corp", "ext-bob"); err != nil {
+		t.Fatalf("GetUserByExternalIdentity failed: %v", err)
+	}
+}
@@ -1,18 +1,25 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
 func (db *DB) ListGrantedScopeNames(
+	ctx context.Context,
 	subject string,
 	integration string,
 ) (
 	[]string,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
 		SELECT g.scope_name
 		FROM grant g
 		JOIN user u ON g.owner = u.id
@@ -42,20 +49,24 @@ func (db *DB) ListGrantedScopeNames(
 }
 
 func (db *DB) InsertGrants(
+	ctx context.Context,
 	subject string,
 	integration string,
 	scopes []string,
 ) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	if len(scopes) == 0 {
 		return nil
 	}
 
-	tx, err := db.Conn.Begin()
+	tx, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin grant insert transaction: %w", err)
 	}
 
-	stmt, err := tx.Prepare(`
+	stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO grant (owner, integration, scope_name, created_at)
 		SELECT u.id, ?1, ?2, ?3
 		FROM user u
@@ -69,7 +80,7 @@ func (db *DB) InsertGrants(
 
 	createdAt := time.Now().Unix()
 	for _, scope := range scopes {
-		if _, err := stmt.Exec(integration, scope, createdAt, subject); err != nil {
+		if _, err := stmt.ExecContext(ctx, integration, scope, createdAt, subject); err != nil {
 			_ = tx.Rollback()
 			return fmt.Errorf("insert grant %q: %w", scope, err)
 		}
@@ -81,3 +92,47 @@ func (db *DB) InsertGrants(
 
 	return nil
 }
+
+// ListGrants returns every scope subject has granted, grouped by integration
+// and sorted by integration name.
+func (db *DB) ListGrants(
+	ctx context.Context,
+	subject string,
+) (
+	[]service.Grant,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT g.integration, g.scope_name
+		FROM grant g
+		JOIN user u ON g.owner = u.id
+		WHERE u.subject=?1
+		ORDER BY g.integration, g.scope_name`,
+		subject,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []service.Grant
+	for rows.Next() {
+		var integration, scope string
+		if err := rows.Scan(&integration, &scope); err != nil {
+			return nil, fmt.Errorf("scan grant: %w", err)
+		}
+		if len(grants) == 0 || grants[len(grants)-1].Integration != integration {
+			grants = append(grants, service.Grant{Integration: integration})
+		}
+		last := &grants[len(grants)-1]
+		last.Scopes = append(last.Scopes, scope)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate grants: %w", err)
+	}
+
+	return grants, nil
+}
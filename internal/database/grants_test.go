@@ -12,7 +12,7 @@ func TestListGrantedScopeNames_Empty(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	scopes, err := store.ListGrantedScopeNames("subject-alice", "nonexistent")
+	scopes, err := store.ListGrantedScopeNames(t.Context(), "subject-alice", "nonexistent")
 	if err != nil {
 		t.Fatalf("ListGrantedScopeNames failed: %v", err)
 	}
@@ -27,12 +27,12 @@ func TestListGrantedScopeNames_WithGrants(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	err := store.InsertGrants("subject-alice", "test-integration", []string{"read", "write", "admin"})
+	err := store.InsertGrants(t.Context(), "subject-alice", "test-integration", []string{"read", "write", "admin"})
 	if err != nil {
 		t.Fatalf("InsertGrants failed: %v", err)
 	}
 
-	scopes, err := store.ListGrantedScopeNames("subject-alice", "test-integration")
+	scopes, err := store.ListGrantedScopeNames(t.Context(), "subject-alice", "test-integration")
 	if err != nil {
 		t.Fatalf("ListGrantedScopeNames failed: %v", err)
 	}
@@ -54,12 +54,12 @@ func TestListGrantedScopeNames_WrongIntegration(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	err := store.InsertGrants("subject-alice", "integration-a", []string{"read"})
+	err := store.InsertGrants(t.Context(), "subject-alice", "integration-a", []string{"read"})
 	if err != nil {
 		t.Fatalf("InsertGrants failed: %v", err)
 	}
 
-	scopes, err := store.ListGrantedScopeNames("subject-alice", "integration-b")
+	scopes, err := store.ListGrantedScopeNames(t.Context(), "subject-alice", "integration-b")
 	if err != nil {
 		t.Fatalf("ListGrantedScopeNames failed: %v", err)
 	}
@@ -74,12 +74,12 @@ func TestInsertGrants_Success(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	err := store.InsertGrants("subject-alice", "test-integration", []string{"read", "write"})
+	err := store.InsertGrants(t.Context(), "subject-alice", "test-integration", []string{"read", "write"})
 	if err != nil {
 		t.Fatalf("InsertGrants failed: %v", err)
 	}
 
-	scopes, err := store.ListGrantedScopeNames("subject-alice", "test-integration")
+	scopes, err := store.ListGrantedScopeNames(t.Context(), "subject-alice", "test-integration")
 	if err != nil {
 		t.Fatalf("ListGrantedScopeNames failed: %v", err)
 	}
@@ -94,17 +94,17 @@ func TestInsertGrants_Idempotent(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	err := store.InsertGrants("subject-alice", "test-integration", []string{"read"})
+	err := store.InsertGrants(t.Context(), "subject-alice", "test-integration", []string{"read"})
 	if err != nil {
 		t.Fatalf("InsertGrants first call failed: %v", err)
 	}
 
-	err = store.InsertGrants("subject-alice", "test-integration", []string{"read"})
+	err = store.InsertGrants(t.Context(), "subject-alice", "test-integration", []string{"read"})
 	if err != nil {
 		t.Fatalf("InsertGrants second call failed: %v", err)
 	}
 
-	scopes, err := store.ListGrantedScopeNames("subject-alice", "test-integration")
+	scopes, err := store.ListGrantedScopeNames(t.Context(), "subject-alice", "test-integration")
 	if err != nil {
 		t.Fatalf("ListGrantedScopeNames failed: %v", err)
 	}
@@ -119,12 +119,12 @@ func TestInsertGrants_NonExistentUser(t *testing.T) {
 
 	// InsertGrants for non-existent user should not fail at the DB layer
 	// (the FK constraint on owner prevents it, but the subquery returns no rows)
-	err := store.InsertGrants("nonexistent-user", "test-integration", []string{"read"})
+	err := store.InsertGrants(t.Context(), "nonexistent-user", "test-integration", []string{"read"})
 	if err != nil {
 		t.Fatalf("InsertGrants should succeed for non-existent user (no rows inserted): %v", err)
 	}
 
-	scopes, err := store.ListGrantedScopeNames("nonexistent-user", "test-integration")
+	scopes, err := store.ListGrantedScopeNames(t.Context(), "nonexistent-user", "test-integration")
 	if err != nil {
 		t.Fatalf("ListGrantedScopeNames failed: %v", err)
 	}
@@ -140,8 +140,35 @@ func TestInsertGrants_EmptyScopes(t *testing.T) {
 	insertUser(t, store, "alice", nil)
 
 	// Empty scopes should be a no-op
-	err := store.InsertGrants("subject-alice", "test-integration", []string{})
+	err := store.InsertGrants(t.Context(), "subject-alice", "test-integration", []string{})
 	if err != nil {
 		t.Fatalf("InsertGrants with empty scopes failed: %v", err)
 	}
 }
+
+func TestListGrants_GroupsByIntegration(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	insertUser(t, store, "alice", nil)
+	if err := store.InsertGrants(t.Context(), "subject-alice", "integration-b", []string{"read"}); err != nil {
+		t.Fatalf("InsertGrants failed: %v", err)
+	}
+	if err := store.InsertGrants(t.Context(), "subject-alice", "integration-a", []string{"write", "read"}); err != nil {
+		t.Fatalf("InsertGrants failed: %v", err)
+	}
+
+	grants, err := store.ListGrants(t.Context(), "subject-alice")
+	if err != nil {
+		t.Fatalf("ListGrants failed: %v", err)
+	}
+	if len(grants) != 2 {
+		t.Fatalf("len(grants) = %d, want 2", len(grants))
+	}
+	if grants[0].Integration != "integration-a" || len(grants[0].Scopes) != 2 {
+		t.Errorf("grants[0] = %+v, want integration-a with two scopes", grants[0])
+	}
+	if grants[1].Integration != "integration-b" || len(grants[1].Scopes) != 1 {
+		t.Errorf("grants[1] = %+v, want integration-b with one scope", grants[1])
+	}
+}
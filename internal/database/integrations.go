@@ -1,26 +1,42 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
 func (db *DB) InsertIntegration(
-	name string,
-	display string,
-	audience string,
-	redirect string,
+	ctx context.Context,
+	integration service.Integration,
 ) error {
-	_, err := db.Conn.Exec(`
-		INSERT INTO integration (name, display, audience, redirect)
-		VALUES (?1, ?2, ?3, ?4)`,
-		name,
-		display,
-		audience,
-		redirect,
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	policy := integration.Policy
+	_, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO integration (
+			name, display, audience, redirect, redirects,
+			access_token_lifetime, refresh_token_lifetime, allowed_scopes, reuse_refresh_tokens,
+			auth_code_lifetime, exchange_audiences, released_claims
+		)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12)`,
+		integration.Name,
+		integration.Display,
+		integration.Audience,
+		integration.Redirect,
+		strings.Join(integration.Redirects, " "),
+		int64(policy.AccessTokenLifetime/time.Second),
+		int64(policy.RefreshTokenLifetime/time.Second),
+		strings.Join(policy.AllowedScopes, " "),
+		policy.ReuseRefreshTokens,
+		int64(policy.AuthCodeLifetime/time.Second),
+		strings.Join(policy.ExchangeAudiences, " "),
+		strings.Join(policy.ReleasedClaims, " "),
 	)
 	if err != nil {
 		return fmt.Errorf("insert integration: %w", err)
@@ -29,18 +45,22 @@ func (db *DB) InsertIntegration(
 }
 
 func (db *DB) UpsertSystemIntegrations(
+	ctx context.Context,
 	integrations []service.Integration,
 ) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	if len(integrations) == 0 {
 		return nil
 	}
 
-	tx, err := db.Conn.Begin()
+	tx, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin system integration upsert transaction: %w", err)
 	}
 
-	stmt, err := tx.Prepare(`
+	stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO integration (name, display, audience, redirect)
 		VALUES (?1, ?2, ?3, ?4)
 		ON CONFLICT(name) DO UPDATE SET
@@ -54,7 +74,7 @@ func (db *DB) UpsertSystemIntegrations(
 	defer stmt.Close()
 
 	for _, integration := range integrations {
-		if _, err := stmt.Exec(integration.Name, integration.Display, integration.Audience, integration.Redirect); err != nil {
+		if _, err := stmt.ExecContext(ctx, integration.Name, integration.Display, integration.Audience, integration.Redirect); err != nil {
 			_ = tx.Rollback()
 			return fmt.Errorf("upsert system integration %q: %w", integration.Name, err)
 		}
@@ -68,25 +88,23 @@ func (db *DB) UpsertSystemIntegrations(
 }
 
 func (db *DB) GetIntegration(
+	ctx context.Context,
 	name string,
 ) (
 	service.Integration,
 	error,
 ) {
-	row := db.Conn.QueryRow(`
-		SELECT name, display, audience, redirect
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.Conn.QueryRowContext(ctx, `
+		SELECT `+integrationColumns+`
 		FROM integration
 		WHERE name=?1`,
 		name,
 	)
 
-	var record service.Integration
-	err := row.Scan(
-		&record.Name,
-		&record.Display,
-		&record.Audience,
-		&record.Redirect,
-	)
+	record, err := scanIntegration(row)
 	if err != nil {
 		return service.Integration{}, fmt.Errorf("couldn't scan integration: %w", err)
 	}
@@ -94,9 +112,13 @@ func (db *DB) GetIntegration(
 }
 
 func (db *DB) UpdateIntegration(
+	ctx context.Context,
 	name string,
 	updates *service.IntegrationUpdate,
 ) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var setClauses []string
 	var args []any
 	argIdx := 1
@@ -116,6 +138,33 @@ func (db *DB) UpdateIntegration(
 		args = append(args, *updates.Redirect)
 		argIdx++
 	}
+	if updates.Redirects != nil {
+		setClauses = append(setClauses, fmt.Sprintf("redirects=?%d", argIdx))
+		args = append(args, strings.Join(*updates.Redirects, " "))
+		argIdx++
+	}
+	if updates.Policy != nil {
+		policy := updates.Policy
+		setClauses = append(setClauses,
+			fmt.Sprintf("access_token_lifetime=?%d", argIdx),
+			fmt.Sprintf("refresh_token_lifetime=?%d", argIdx+1),
+			fmt.Sprintf("allowed_scopes=?%d", argIdx+2),
+			fmt.Sprintf("reuse_refresh_tokens=?%d", argIdx+3),
+			fmt.Sprintf("auth_code_lifetime=?%d", argIdx+4),
+			fmt.Sprintf("exchange_audiences=?%d", argIdx+5),
+			fmt.Sprintf("released_claims=?%d", argIdx+6),
+		)
+		args = append(args,
+			int64(policy.AccessTokenLifetime/time.Second),
+			int64(policy.RefreshTokenLifetime/time.Second),
+			strings.Join(policy.AllowedScopes, " "),
+			policy.ReuseRefreshTokens,
+			int64(policy.AuthCodeLifetime/time.Second),
+			strings.Join(policy.ExchangeAudiences, " "),
+			strings.Join(policy.ReleasedClaims, " "),
+		)
+		argIdx += 7
+	}
 
 	if len(setClauses) == 0 {
 		return nil
@@ -130,7 +179,7 @@ func (db *DB) UpdateIntegration(
 	)
 	args = append(args, name)
 
-	result, err := db.Conn.Exec(query, args...)
+	result, err := db.Conn.ExecContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("update integration %q: %w", name, err)
 	}
@@ -140,13 +189,68 @@ func (db *DB) UpdateIntegration(
 	return nil
 }
 
+// SetIntegrationSecret stores the hash of an integration's client secret. An
+// empty hash removes the secret.
+func (db *DB) SetIntegrationSecret(
+	ctx context.Context,
+	name string,
+	secretHash string,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		UPDATE integration
+		SET secret=?1
+		WHERE name=?2`,
+		secretHash,
+		name,
+	)
+	if err != nil {
+		return fmt.Errorf("set integration secret %q: %w", name, err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetIntegrationSecret returns the stored client secret hash, which is empty
+// for integrations without a secret.
+func (db *DB) GetIntegrationSecret(
+	ctx context.Context,
+	name string,
+) (
+	string,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var secretHash string
+	err := db.Conn.QueryRowContext(ctx, `
+		SELECT secret
+		FROM integration
+		WHERE name=?1`,
+		name,
+	).Scan(&secretHash)
+	if err != nil {
+		return "", fmt.Errorf("query integration secret %q: %w", name, err)
+	}
+	return secretHash, nil
+}
+
 func (db *DB) DeleteIntegration(
+	ctx context.Context,
 	name string,
 ) (
 	bool,
 	error,
 ) {
-	result, err := db.Conn.Exec(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
 		DELETE FROM integration
 		WHERE name=?1`,
 		name,
@@ -159,12 +263,17 @@ func (db *DB) DeleteIntegration(
 	return deleted, nil
 }
 
-func (db *DB) ListIntegrations() (
+func (db *DB) ListIntegrations(
+	ctx context.Context,
+) (
 	[]service.Integration,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
-		SELECT name, display, audience, redirect
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT `+integrationColumns+`
 		FROM integration
 		ORDER BY name`)
 	if err != nil {
@@ -174,13 +283,8 @@ func (db *DB) ListIntegrations() (
 
 	var records []service.Integration
 	for rows.Next() {
-		var record service.Integration
-		if err := rows.Scan(
-			&record.Name,
-			&record.Display,
-			&record.Audience,
-			&record.Redirect,
-		); err != nil {
+		record, err := scanIntegration(rows)
+		if err != nil {
 			return nil, fmt.Errorf("couldn't scan integration: %w", err)
 		}
 		records = append(records, record)
@@ -191,3 +295,52 @@ func (db *DB) ListIntegrations() (
 	}
 	return records, nil
 }
+
+const integrationColumns = `name, display, audience, redirect, redirects,
+	access_token_lifetime, refresh_token_lifetime, allowed_scopes, reuse_refresh_tokens,
+	auth_code_lifetime, exchange_audiences, released_claims, secret != ''`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanIntegration(
+	row rowScanner,
+) (
+	service.Integration,
+	error,
+) {
+	var record service.Integration
+	var redirects, allowedScopes, exchangeAudiences, releasedClaims string
+	var accessLifetime, refreshLifetime, authCodeLifetime int64
+	err := row.Scan(
+		&record.Name,
+		&record.Display,
+		&record.Audience,
+		&record.Redirect,
+		&redirects,
+		&accessLifetime,
+		&refreshLifetime,
+		&allowedScopes,
+		&record.Policy.ReuseRefreshTokens,
+		&authCodeLifetime,
+		&exchangeAudiences,
+		&releasedClaims,
+		&record.HasSecret,
+	)
+	if err != nil {
+		return service.Integration{}, err
+	}
+	record.Redirects = strings.Fields(redirects)
+	record.Policy.AccessTokenLifetime = time.Duration(accessLifetime) * time.Second
+	record.Policy.RefreshTokenLifetime = time.Duration(refreshLifetime) * time.Second
+	record.Policy.AllowedScopes = strings.Fields(allowedScopes)
+	record.Policy.AuthCodeLifetime = time.Duration(authCodeLifetime) * time.Second
+	if exchangeAudiences != "" {
+		record.Policy.ExchangeAudiences = strings.Fields(exchangeAudiences)
+	}
+	if releasedClaims != "" {
+		record.Policy.ReleasedClaims = strings.Fields(releasedClaims)
+	}
+	return record, nil
+}
@@ -3,7 +3,9 @@ package database_test
 import (
 	"database/sql"
 	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
@@ -13,21 +15,90 @@ func TestInsertIntegration_Success(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback"})
 	if err != nil {
 		t.Fatalf("InsertIntegration failed: %v", err)
 	}
 }
 
+func TestInsertIntegration_Policy(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	policy := service.IntegrationPolicy{
+		AccessTokenLifetime:  5 * time.Minute,
+		RefreshTokenLifetime: 24 * time.Hour,
+		AllowedScopes:        []string{"identity", "profile"},
+		ReuseRefreshTokens:   true,
+		AuthCodeLifetime:     time.Minute,
+	}
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback", Policy: policy})
+	if err != nil {
+		t.Fatalf("InsertIntegration failed: %v", err)
+	}
+
+	integration, err := store.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if !reflect.DeepEqual(integration.Policy, policy) {
+		t.Fatalf("Policy = %+v, want %+v", integration.Policy, policy)
+	}
+
+	// updating replaces the whole policy
+	if err := store.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Policy: &service.IntegrationPolicy{}}); err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	integration, err = store.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	cleared := integration.Policy
+	if cleared.AccessTokenLifetime != 0 || cleared.RefreshTokenLifetime != 0 || len(cleared.AllowedScopes) != 0 || cleared.ReuseRefreshTokens || cleared.AuthCodeLifetime != 0 {
+		t.Fatalf("Policy = %+v, want zero policy", cleared)
+	}
+}
+
+func TestInsertIntegration_Redirects(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback", Redirects: []string{"https://staging.test/callback", "https://dev.test/callback"}})
+	if err != nil {
+		t.Fatalf("InsertIntegration failed: %v", err)
+	}
+
+	integration, err := store.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if len(integration.Redirects) != 2 || integration.Redirects[0] != "https://staging.test/callback" || integration.Redirects[1] != "https://dev.test/callback" {
+		t.Fatalf("Redirects = %v", integration.Redirects)
+	}
+
+	// clearing the list leaves only the default redirect
+	cleared := []string{}
+	if err := store.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Redirects: &cleared}); err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	integration, err = store.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if len(integration.Redirects) != 0 || integration.Redirect != "https://svc-a.test/callback" {
+		t.Fatalf("integration = %#v, want default redirect only", integration)
+	}
+}
+
 func TestInsertIntegration_DuplicateName(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	if err := store.InsertIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback"); err != nil {
+	if err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback"}); err != nil {
 		t.Fatalf("InsertIntegration failed: %v", err)
 	}
 
-	err := store.InsertIntegration("svc-a", "Service A2", "aud-a", "https://svc-a.test/redirect")
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A2", Audience: "aud-a", Redirect: "https://svc-a.test/redirect"})
 	if err == nil {
 		t.Fatal("expected error for duplicate integration name")
 	}
@@ -37,7 +108,7 @@ func TestUpsertSystemIntegrations_Empty(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.UpsertSystemIntegrations(nil)
+	err := store.UpsertSystemIntegrations(t.Context(), nil)
 	if err != nil {
 		t.Fatalf("UpsertSystemIntegrations failed: %v", err)
 	}
@@ -47,7 +118,7 @@ func TestUpsertSystemIntegrations_Insert(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.UpsertSystemIntegrations([]service.Integration{
+	err := store.UpsertSystemIntegrations(t.Context(), []service.Integration{
 		{
 			Name:     "consent",
 			Display:  "Consent",
@@ -59,7 +130,7 @@ func TestUpsertSystemIntegrations_Insert(t *testing.T) {
 		t.Fatalf("UpsertSystemIntegrations failed: %v", err)
 	}
 
-	record, err := store.GetIntegration("consent")
+	record, err := store.GetIntegration(t.Context(), "consent")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -72,11 +143,11 @@ func TestUpsertSystemIntegrations_MixedBatch(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	if err := store.InsertIntegration("svc-a", "Old", "old-aud", "https://old.test/callback"); err != nil {
+	if err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Old", Audience: "old-aud", Redirect: "https://old.test/callback"}); err != nil {
 		t.Fatalf("InsertIntegration failed: %v", err)
 	}
 
-	err := store.UpsertSystemIntegrations([]service.Integration{
+	err := store.UpsertSystemIntegrations(t.Context(), []service.Integration{
 		{
 			Name:     "svc-a",
 			Display:  "Service A",
@@ -94,7 +165,7 @@ func TestUpsertSystemIntegrations_MixedBatch(t *testing.T) {
 		t.Fatalf("UpsertSystemIntegrations failed: %v", err)
 	}
 
-	record, err := store.GetIntegration("svc-a")
+	record, err := store.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -102,7 +173,7 @@ func TestUpsertSystemIntegrations_MixedBatch(t *testing.T) {
 		t.Fatalf("Display = %s, want Service A", record.Display)
 	}
 
-	_, err = store.GetIntegration("consent")
+	_, err = store.GetIntegration(t.Context(), "consent")
 	if err != nil {
 		t.Fatalf("GetIntegration consent failed: %v", err)
 	}
@@ -112,12 +183,12 @@ func TestGetIntegration_Exists(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback"})
 	if err != nil {
 		t.Fatalf("InsertIntegration failed: %v", err)
 	}
 
-	record, err := store.GetIntegration("svc-a")
+	record, err := store.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -139,7 +210,7 @@ func TestGetIntegration_NotFound(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	_, err := store.GetIntegration("missing")
+	_, err := store.GetIntegration(t.Context(), "missing")
 	if !errors.Is(err, sql.ErrNoRows) {
 		t.Fatalf("expected sql.ErrNoRows, got %v", err)
 	}
@@ -149,7 +220,7 @@ func TestUpdateIntegration_Success(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback"})
 	if err != nil {
 		t.Fatalf("InsertIntegration failed: %v", err)
 	}
@@ -157,12 +228,12 @@ func TestUpdateIntegration_Success(t *testing.T) {
 	display := "Service A2"
 	audience := "aud-b"
 	redirect := "https://svc-a.test/new"
-	err = store.UpdateIntegration("svc-a", &service.IntegrationUpdate{Display: &display, Audience: &audience, Redirect: &redirect})
+	err = store.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Display: &display, Audience: &audience, Redirect: &redirect})
 	if err != nil {
 		t.Fatalf("UpdateIntegration failed: %v", err)
 	}
 
-	record, err := store.GetIntegration("svc-a")
+	record, err := store.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -182,7 +253,7 @@ func TestUpdateIntegration_NotFound(t *testing.T) {
 	store := testutil.SetupTestDB(t)
 
 	display := "Service"
-	err := store.UpdateIntegration("missing", &service.IntegrationUpdate{Display: &display})
+	err := store.UpdateIntegration(t.Context(), "missing", &service.IntegrationUpdate{Display: &display})
 	if err == nil {
 		t.Fatal("expected error for missing integration")
 	}
@@ -192,12 +263,12 @@ func TestDeleteIntegration_Success(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback"})
 	if err != nil {
 		t.Fatalf("InsertIntegration failed: %v", err)
 	}
 
-	deleted, err := store.DeleteIntegration("svc-a")
+	deleted, err := store.DeleteIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("DeleteIntegration failed: %v", err)
 	}
@@ -205,7 +276,7 @@ func TestDeleteIntegration_Success(t *testing.T) {
 		t.Fatal("expected integration to be deleted")
 	}
 
-	_, err = store.GetIntegration("svc-a")
+	_, err = store.GetIntegration(t.Context(), "svc-a")
 	if !errors.Is(err, sql.ErrNoRows) {
 		t.Fatalf("expected sql.ErrNoRows, got %v", err)
 	}
@@ -215,7 +286,7 @@ func TestDeleteIntegration_NotFound(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	deleted, err := store.DeleteIntegration("missing")
+	deleted, err := store.DeleteIntegration(t.Context(), "missing")
 	if err != nil {
 		t.Fatalf("DeleteIntegration failed: %v", err)
 	}
@@ -228,7 +299,7 @@ func TestListIntegrations_Empty(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	records, err := store.ListIntegrations()
+	records, err := store.ListIntegrations(t.Context())
 	if err != nil {
 		t.Fatalf("ListIntegrations failed: %v", err)
 	}
@@ -256,12 +327,12 @@ func TestListIntegrations_Multiple(t *testing.T) {
 		},
 	}
 	for _, integration := range integrations {
-		if err := store.InsertIntegration(integration.Name, integration.Display, integration.Audience, integration.Redirect); err != nil {
+		if err := store.InsertIntegration(t.Context(), integration); err != nil {
 			t.Fatalf("InsertIntegration failed: %v", err)
 		}
 	}
 
-	records, err := store.ListIntegrations()
+	records, err := store.ListIntegrations(t.Context())
 	if err != nil {
 		t.Fatalf("ListIntegrations failed: %v", err)
 	}
@@ -275,3 +346,34 @@ func TestListIntegrations_Multiple(t *testing.T) {
 		t.Errorf("expected svc-b second, got %s", records[1].Name)
 	}
 }
+
+func TestSetIntegrationSecret(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	if err := store.InsertIntegration(t.Context(), service.Integration{Name: "svc-a", Display: "Service A", Audience: "aud-a", Redirect: "https://svc-a.test/callback"}); err != nil {
+		t.Fatalf("InsertIntegration failed: %v", err)
+	}
+
+	if err := store.SetIntegrationSecret(t.Context(), "svc-a", "hash"); err != nil {
+		t.Fatalf("SetIntegrationSecret failed: %v", err)
+	}
+	secretHash, err := store.GetIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegrationSecret failed: %v", err)
+	}
+	if secretHash != "hash" {
+		t.Errorf("secret = %q, want hash", secretHash)
+	}
+	integration, err := store.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if !integration.HasSecret {
+		t.Error("expected HasSecret")
+	}
+
+	// unknown integrations report no rows
+	if err := store.SetIntegrationSecret(t.Context(), "missing", "hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AcquireLease takes or renews the lease name for holder until now+ttl. It
+// succeeds when the lease is free, expired, or already held by holder, and
+// reports whether holder has it afterwards.
+func (db *DB) AcquireLease(
+	ctx context.Context,
+	name string,
+	holder string,
+	now time.Time,
+	ttl time.Duration,
+) (
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO lease (name, holder, expires_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (name) DO UPDATE
+		SET holder=excluded.holder, expires_at=excluded.expires_at
+		WHERE lease.holder=excluded.holder OR lease.expires_at<=?4`,
+		name,
+		holder,
+		now.Add(ttl).UnixMilli(),
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("acquire lease: %w", err)
+	}
+	return !resultsEmpty(result), nil
+}
+
+// ReleaseLease gives up the lease name if holder has it, so another holder
+// can take it without waiting for it to expire.
+func (db *DB) ReleaseLease(
+	ctx context.Context,
+	name string,
+	holder string,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.Conn.ExecContext(ctx, `
+		DELETE FROM lease
+		WHERE name=?1 AND holder=?2`,
+		name,
+		holder,
+	); err != nil {
+		return fmt.Errorf("release lease: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestAcquireLease(t *testing.T) {
+	t.Parallel()
+	db := testutil.SetupTestDB(t)
+	ctx := t.Context()
+	now := time.Unix(1_700_000_000, 0)
+	ttl := 30 * time.Second
+
+	acquire := func(holder string, at time.Time) bool {
+		t.Helper()
+		held, err := db.AcquireLease(ctx, "janitor", holder, at, ttl)
+		if err != nil {
+			t.Fatalf("AcquireLease failed: %v", err)
+		}
+		return held
+	}
+
+	// the first holder takes the lease and keeps it by renewing
+	if !acquire("a", now) {
+		t.Fatal("a did not take the free lease")
+	}
+	if acquire("b", now.Add(10*time.Second)) {
+		t.Fatal("b took a lease a holds")
+	}
+	if !acquire("a", now.Add(20*time.Second)) {
+		t.Fatal("a could not renew its lease")
+	}
+
+	// another holder takes it once it lapses
+	if acquire("b", now.Add(49*time.Second)) {
+		t.Fatal("b took the lease before it expired")
+	}
+	if !acquire("b", now.Add(50*time.Second)) {
+		t.Fatal("b did not take the expired lease")
+	}
+	if acquire("a", now.Add(51*time.Second)) {
+		t.Fatal("a took back a lease b holds")
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	t.Parallel()
+	db := testutil.SetupTestDB(t)
+	ctx := t.Context()
+	now := time.Now()
+
+	if _, err := db.AcquireLease(ctx, "janitor", "a", now, time.Minute); err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+
+	// only the holder can release it
+	if err := db.ReleaseLease(ctx, "janitor", "b"); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	if held, _ := db.AcquireLease(ctx, "janitor", "b", now, time.Minute); held {
+		t.Fatal("b took the lease after releasing one it didn't hold")
+	}
+
+	if err := db.ReleaseLease(ctx, "janitor", "a"); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	if held, _ := db.AcquireLease(ctx, "janitor", "b", now, time.Minute); !held {
+		t.Fatal("b did not take the released lease")
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// loginDeviceRetention is how long a device the user hasn't signed in from
+// is remembered; signing in from it again afterwards counts as new.
+const loginDeviceRetention = 180 * 24 * time.Hour
+
+// RecordLoginDevice remembers that the user identified by subject signed in
+// from client at at. The device is new if the user has signed in from others
+// before, but not from this one; a user's first device isn't new. Unknown
+// subjects return sql.ErrNoRows.
+func (db *DB) RecordLoginDevice(
+	ctx context.Context,
+	subject string,
+	client service.ClientInfo,
+	at time.Time,
+) (
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin login device record: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner int64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT id FROM user WHERE subject=?1`,
+		subject,
+	).Scan(&owner); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM login_device
+		WHERE owner=?1 AND last_seen<?2`,
+		owner,
+		at.Add(-loginDeviceRetention).Unix(),
+	); err != nil {
+		return false, fmt.Errorf("forget old login devices: %w", err)
+	}
+
+	var devices, known int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(ip=?2 AND user_agent=?3), 0)
+		FROM login_device
+		WHERE owner=?1`,
+		owner,
+		client.IP,
+		client.UserAgent,
+	).Scan(&devices, &known); err != nil {
+		return false, fmt.Errorf("read login devices: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO login_device (owner, ip, user_agent, last_seen)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (owner, ip, user_agent) DO UPDATE SET
+			last_seen=excluded.last_seen`,
+		owner,
+		client.IP,
+		client.UserAgent,
+		at.Unix(),
+	); err != nil {
+		return false, fmt.Errorf("record login device: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit login device record: %w", err)
+	}
+	return devices > 0 && known == 0, nil
+}
@@ -0,0 +1,57 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestRecordLoginDevice(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+	alice, err := store.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	laptop := service.ClientInfo{IP: "192.0.2.1", UserAgent: "laptop"}
+	phone := service.ClientInfo{IP: "198.51.100.1", UserAgent: "phone"}
+	now := time.Unix(1700000000, 0)
+
+	steps := []struct {
+		name   string
+		client service.ClientInfo
+		at     time.Time
+		isNew  bool
+	}{
+		{"first device", laptop, now, false},
+		{"same device", laptop, now.Add(time.Hour), false},
+		{"second device", phone, now.Add(2 * time.Hour), true},
+		{"second device again", phone, now.Add(3 * time.Hour), false},
+		{"after a long absence", phone, now.AddDate(1, 0, 0), false},
+		{"long unused device", laptop, now.AddDate(1, 0, 0).Add(time.Hour), true},
+	}
+	for _, step := range steps {
+		isNew, err := store.RecordLoginDevice(t.Context(), alice.Subject, step.client, step.at)
+		if err != nil {
+			t.Fatalf("%s: RecordLoginDevice failed: %v", step.name, err)
+		}
+		if isNew != step.isNew {
+			t.Errorf("%s: isNew = %v, want %v", step.name, isNew, step.isNew)
+		}
+	}
+}
+
+func TestRecordLoginDevice_UnknownUser(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	_, err := env.DB.RecordLoginDevice(t.Context(), "nobody", service.ClientInfo{}, time.Now())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("err = %v, want sql.ErrNoRows", err)
+	}
+}
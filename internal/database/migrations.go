@@ -58,6 +58,188 @@ var migrations = []Migration{
 				UNIQUE (owner, integration, scope_name)
 			)`,
 	},
+	{
+		Version: 2,
+		Name:    "create external identity links",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS external_identity (
+				provider         TEXT NOT NULL,
+				external_subject TEXT NOT NULL,
+				owner            INTEGER NOT NULL,
+				created_at       INTEGER NOT NULL,
+				PRIMARY KEY (provider, external_subject),
+				FOREIGN KEY (owner) REFERENCES user(id) ON DELETE CASCADE
+			)`,
+	},
+	{
+		Version: 3,
+		Name:    "create device authorizations",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS device_authorization (
+				device_code_hash TEXT PRIMARY KEY,
+				user_code        TEXT UNIQUE NOT NULL,
+				integration      TEXT NOT NULL,
+				scopes           TEXT NOT NULL,
+				status           TEXT NOT NULL,
+				owner            INTEGER,
+				expires_at       INTEGER NOT NULL,
+				poll_interval    INTEGER NOT NULL,
+				last_polled_at   INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY (integration) REFERENCES integration(name) ON DELETE CASCADE,
+				FOREIGN KEY (owner)       REFERENCES user(id) ON DELETE CASCADE
+			)`,
+	},
+	{
+		Version: 4,
+		Name:    "create user profiles",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS profile (
+				owner        INTEGER PRIMARY KEY,
+				display_name TEXT NOT NULL DEFAULT '',
+				email        TEXT NOT NULL DEFAULT '',
+				avatar_url   TEXT NOT NULL DEFAULT '',
+				FOREIGN KEY (owner) REFERENCES user(id) ON DELETE CASCADE
+			)`,
+	},
+	{
+		Version: 5,
+		Name:    "add integration redirect lists",
+		SQL: `
+			ALTER TABLE integration ADD COLUMN redirects TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		Version: 6,
+		Name:    "add integration token policies",
+		SQL: `
+			ALTER TABLE integration ADD COLUMN access_token_lifetime INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE integration ADD COLUMN refresh_token_lifetime INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE integration ADD COLUMN allowed_scopes TEXT NOT NULL DEFAULT '';
+			ALTER TABLE integration ADD COLUMN reuse_refresh_tokens INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 7,
+		Name:    "add integration client secrets",
+		SQL: `
+			ALTER TABLE integration ADD COLUMN secret TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		Version: 8,
+		Name:    "create authorization codes",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS authorization_code (
+				code_hash   TEXT PRIMARY KEY,
+				owner       INTEGER NOT NULL,
+				integration TEXT NOT NULL,
+				scopes      TEXT NOT NULL,
+				expires_at  INTEGER NOT NULL,
+				FOREIGN KEY (integration) REFERENCES integration(name) ON DELETE CASCADE,
+				FOREIGN KEY (owner)       REFERENCES user(id) ON DELETE CASCADE
+			)`,
+	},
+	{
+		Version: 9,
+		Name:    "add integration auth code lifetimes",
+		SQL: `
+			ALTER TABLE integration ADD COLUMN auth_code_lifetime INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 10,
+		Name:    "add refresh token session details",
+		SQL: `
+			ALTER TABLE refresh ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE refresh ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+			ALTER TABLE refresh ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+			ALTER TABLE refresh ADD COLUMN last_used_at INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 11,
+		Name:    "add integration exchange audiences",
+		SQL: `
+			ALTER TABLE integration ADD COLUMN exchange_audiences TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		Version: 12,
+		Name:    "add integration released claims",
+		SQL: `
+			ALTER TABLE integration ADD COLUMN released_claims TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		Version: 13,
+		Name:    "add refresh token last ip",
+		SQL: `
+			ALTER TABLE refresh ADD COLUMN last_ip TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		Version: 14,
+		Name:    "add authorization code session only",
+		SQL: `
+			ALTER TABLE authorization_code ADD COLUMN session_only INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 15,
+		Name:    "add authorization code auth time",
+		SQL: `
+			ALTER TABLE authorization_code ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 16,
+		Name:    "create refresh token audiences",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS refresh_audience (
+				audience TEXT NOT NULL,
+				refresh  INTEGER NOT NULL,
+				PRIMARY KEY (audience, refresh),
+				FOREIGN KEY (refresh) REFERENCES refresh(id) ON DELETE CASCADE
+			)`,
+	},
+	{
+		Version: 17,
+		Name:    "create login devices",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS login_device (
+				owner      INTEGER NOT NULL,
+				ip         TEXT NOT NULL,
+				user_agent TEXT NOT NULL,
+				last_seen  INTEGER NOT NULL,
+				PRIMARY KEY (owner, ip, user_agent),
+				FOREIGN KEY (owner) REFERENCES user(id) ON DELETE CASCADE
+			)`,
+	},
+	{
+		Version: 18,
+		Name:    "create leases",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS lease (
+				name       TEXT PRIMARY KEY,
+				holder     TEXT NOT NULL,
+				expires_at INTEGER NOT NULL
+			)`,
+	},
+	{
+		Version: 19,
+		Name:    "add user enabled",
+		SQL: `
+			ALTER TABLE user ADD COLUMN enabled INTEGER NOT NULL DEFAULT 1`,
+	},
+	{
+		Version: 20,
+		Name:    "add user expiry",
+		SQL: `
+			ALTER TABLE user ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 21,
+		Name:    "add user terms acceptance",
+		SQL: `
+			ALTER TABLE user ADD COLUMN terms_version TEXT NOT NULL DEFAULT '';
+			ALTER TABLE user ADD COLUMN terms_accepted_at INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		Version: 22,
+		Name:    "add device authorization auth time",
+		SQL: `
+			ALTER TABLE device_authorization ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0`,
+	},
 }
 
 func (db *DB) migrate() error {
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// GetProfile returns the profile for subject. Users who never saved a profile
+// get an empty one; unknown subjects return sql.ErrNoRows.
+func (db *DB) GetProfile(
+	ctx context.Context,
+	subject string,
+) (
+	*service.Profile,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	profile := &service.Profile{}
+	err := db.Conn.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(p.display_name, ''),
+			COALESCE(p.email, ''),
+			COALESCE(p.avatar_url, '')
+		FROM user u
+		LEFT JOIN profile p ON p.owner = u.id
+		WHERE u.subject=?1`,
+		subject,
+	).Scan(
+		&profile.DisplayName,
+		&profile.Email,
+		&profile.AvatarURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (db *DB) UpsertProfile(
+	ctx context.Context,
+	subject string,
+	profile *service.Profile,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO profile (owner, display_name, email, avatar_url)
+		SELECT u.id, ?1, ?2, ?3
+		FROM user u
+		WHERE u.subject=?4
+		ON CONFLICT (owner) DO UPDATE SET
+			display_name=excluded.display_name,
+			email=excluded.email,
+			avatar_url=excluded.avatar_url`,
+		profile.DisplayName,
+		profile.Email,
+		profile.AvatarURL,
+		subject,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert profile: %w", err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestGetProfile_EmptyByDefault(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+
+	profile, err := store.GetProfile(t.Context(), "subject-alice")
+	if err != nil {
+		t.Fatalf("GetProfile failed: %v", err)
+	}
+	if *profile != (service.Profile{}) {
+		t.Fatalf("profile = %#v, want empty", profile)
+	}
+}
+
+func TestGetProfile_UnknownUser(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	_, err := store.GetProfile(t.Context(), "subject-missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestUpsertProfile_InsertAndUpdate(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+
+	first := &service.Profile{DisplayName: "Alice", Email: "alice@example.com"}
+	if err := store.UpsertProfile(t.Context(), "subject-alice", first); err != nil {
+		t.Fatalf("UpsertProfile failed: %v", err)
+	}
+
+	// a second upsert replaces the stored fields
+	second := &service.Profile{DisplayName: "Alice A.", AvatarURL: "https://example.com/a.png"}
+	if err := store.UpsertProfile(t.Context(), "subject-alice", second); err != nil {
+		t.Fatalf("UpsertProfile failed: %v", err)
+	}
+
+	profile, err := store.GetProfile(t.Context(), "subject-alice")
+	if err != nil {
+		t.Fatalf("GetProfile failed: %v", err)
+	}
+	if *profile != *second {
+		t.Fatalf("profile = %#v, want %#v", profile, second)
+	}
+}
+
+func TestUpsertProfile_UnknownUser(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	err := store.UpsertProfile(t.Context(), "subject-missing", &service.Profile{DisplayName: "Ghost"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("error = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -1,36 +1,96 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
+	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
+// InsertRefreshToken stores a token that starts a new session, recording the
+// client it was issued to.
 func (db *DB) InsertRefreshToken(
+	ctx context.Context,
 	token *tokens.RefreshToken,
+	client service.ClientInfo,
 ) error {
-	_, err := db.Conn.Exec(`
-		INSERT INTO refresh (owner, jwt, expiration)
-		SELECT u.id, ?1, ?2
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin refresh token insert: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO refresh (owner, jwt, expiration, created_at, ip, user_agent)
+		SELECT u.id, ?1, ?2, ?4, ?5, ?6
 		FROM user u
 		WHERE u.subject=?3`,
 		token.Encoded(),
 		token.Expiration().Unix(),
 		token.Subject(),
+		token.IssuedAt().Unix(),
+		client.IP,
+		client.UserAgent,
 	)
 	if err != nil {
+		_ = tx.Rollback()
 		return fmt.Errorf("insert refresh token: %w", err)
 	}
+	if !resultsEmpty(result) {
+		if err := insertRefreshAudiences(ctx, tx, result, token.Audience()); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit refresh token insert: %w", err)
+	}
+	return nil
+}
+
+// insertRefreshAudiences records the audiences of the refresh token just
+// inserted with result, so DeleteRefreshTokensByAudience can find it.
+func insertRefreshAudiences(
+	ctx context.Context,
+	tx *sql.Tx,
+	result sql.Result,
+	audiences []string,
+) error {
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read refresh token id: %w", err)
+	}
+	for _, audience := range audiences {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO refresh_audience (audience, refresh)
+			VALUES (?1, ?2)`,
+			audience,
+			id,
+		); err != nil {
+			return fmt.Errorf("insert refresh token audience: %w", err)
+		}
+	}
 	return nil
 }
 
 func (db *DB) GetRefreshTokenOwner(
+	ctx context.Context,
 	jwt string,
 ) (
 	string,
 	error,
 ) {
-	row := db.Conn.QueryRow(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.Conn.QueryRowContext(ctx, `
 		SELECT u.subject
 		FROM refresh r
 		JOIN user u ON r.owner = u.id
@@ -46,13 +106,46 @@ func (db *DB) GetRefreshTokenOwner(
 	return subject, nil
 }
 
+// GetRefreshSession returns the session of the stored token jwt. Returns
+// false if jwt is not stored.
+func (db *DB) GetRefreshSession(
+	ctx context.Context,
+	jwt string,
+) (
+	service.Session,
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.Conn.QueryRowContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM refresh r
+		WHERE r.jwt=?1`,
+		jwt,
+	)
+	session, err := scanSession(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return service.Session{}, false, nil
+	}
+	if err != nil {
+		return service.Session{}, false, fmt.Errorf("query refresh session: %w", err)
+	}
+	return session, true, nil
+}
+
 func (db *DB) DeleteRefreshToken(
+	ctx context.Context,
 	jwt string,
 ) (
 	bool,
 	error,
 ) {
-	result, err := db.Conn.Exec(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
 		DELETE FROM refresh
 		WHERE id IN (
 			SELECT r.id
@@ -69,3 +162,311 @@ func (db *DB) DeleteRefreshToken(
 	deleted := !resultsEmpty(result)
 	return deleted, nil
 }
+
+// RotateRefreshToken replaces the stored token jwt with next in one
+// transaction, so a failure part way leaves the old token usable rather than
+// the user with neither. next keeps the old token's session details and is
+// marked used at its issue time by client. Returns false, storing nothing, if
+// jwt is not stored.
+func (db *DB) RotateRefreshToken(
+	ctx context.Context,
+	jwt string,
+	next *tokens.RefreshToken,
+	client service.ClientInfo,
+) (
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin refresh token rotation: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO refresh (owner, jwt, expiration, created_at, ip, user_agent, last_used_at, last_ip)
+		SELECT r.owner, ?2, ?3, r.created_at, r.ip, r.user_agent, ?4, COALESCE(NULLIF(?6, ''), r.last_ip)
+		FROM refresh r
+		JOIN user u ON r.owner=u.id
+		WHERE r.jwt=?1 AND u.subject=?5
+		LIMIT 1`,
+		jwt,
+		next.Encoded(),
+		next.Expiration().Unix(),
+		next.IssuedAt().Unix(),
+		next.Subject(),
+		client.IP,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("insert refresh token: %w", err)
+	}
+	if resultsEmpty(result) {
+		_ = tx.Rollback()
+		return false, nil
+	}
+	if err := insertRefreshAudiences(ctx, tx, result, next.Audience()); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM refresh
+		WHERE jwt=?1`,
+		jwt,
+	); err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("delete refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit refresh token rotation: %w", err)
+	}
+	return true, nil
+}
+
+// TouchRefreshToken records that a stored token was used at usedAt by client
+// without replacing it. Returns false if jwt is not stored.
+func (db *DB) TouchRefreshToken(
+	ctx context.Context,
+	jwt string,
+	usedAt time.Time,
+	client service.ClientInfo,
+) (
+	bool,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		UPDATE refresh
+		SET last_used_at=?2, last_ip=COALESCE(NULLIF(?3, ''), last_ip)
+		WHERE jwt=?1`,
+		jwt,
+		usedAt.Unix(),
+		client.IP,
+	)
+	if err != nil {
+		return false, fmt.Errorf("touch refresh token: %w", err)
+	}
+	return !resultsEmpty(result), nil
+}
+
+// ListRefreshTokens returns the sessions of the user identified by subject,
+// most recently created first.
+func (db *DB) ListRefreshTokens(
+	ctx context.Context,
+	subject string,
+) (
+	[]service.Session,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM refresh r
+		JOIN user u ON r.owner = u.id
+		WHERE u.subject=?1
+		ORDER BY r.created_at DESC, r.id DESC`,
+		subject,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []service.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan refresh token: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate refresh tokens: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteRefreshTokensByAudience deletes every stored refresh token issued to
+// audience, returning how many were deleted.
+func (db *DB) DeleteRefreshTokensByAudience(
+	ctx context.Context,
+	audience string,
+) (
+	int,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		DELETE FROM refresh
+		WHERE id IN (
+			SELECT refresh
+			FROM refresh_audience
+			WHERE audience=?1
+		)`,
+		audience,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete refresh tokens by audience: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("count deleted refresh tokens: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// DeleteRefreshTokensSince deletes the stored refresh tokens of the user
+// identified by subject whose sessions started at or after since, returning
+// how many were deleted.
+func (db *DB) DeleteRefreshTokensSince(
+	ctx context.Context,
+	subject string,
+	since time.Time,
+) (
+	int,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		DELETE FROM refresh
+		WHERE owner=(SELECT id FROM user WHERE subject=?1)
+			AND created_at>=?2`,
+		subject,
+		since.Unix(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete refresh tokens since: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("count deleted refresh tokens: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// DeleteUserTokens deletes the refresh tokens, authorization codes, and
+// device authorizations of the user identified by subject. Deleting the user
+// removes them too; this is for clearing them while the user remains.
+func (db *DB) DeleteUserTokens(
+	ctx context.Context,
+	subject string,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin user token deletion: %w", err)
+	}
+	for _, table := range []string{"refresh", "authorization_code", "device_authorization"} {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM `+table+`
+			WHERE owner=(SELECT id FROM user WHERE subject=?1)`,
+			subject,
+		); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("delete user tokens from %s: %w", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit user token deletion: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredTokens deletes the refresh tokens, authorization codes, and
+// device authorizations that expired by now, none of which can be redeemed
+// any longer, and returns how many it deleted.
+func (db *DB) DeleteExpiredTokens(
+	ctx context.Context,
+	now time.Time,
+) (
+	int,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin expired token deletion: %w", err)
+	}
+	var total int64
+	for _, expiry := range []struct{ table, column string }{
+		{"refresh", "expiration"},
+		{"authorization_code", "expires_at"},
+		{"device_authorization", "expires_at"},
+	} {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM `+expiry.table+`
+			WHERE `+expiry.column+`<=?1`,
+			now.Unix(),
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, fmt.Errorf("delete expired tokens from %s: %w", expiry.table, err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, fmt.Errorf("count expired tokens deleted from %s: %w", expiry.table, err)
+		}
+		total += deleted
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit expired token deletion: %w", err)
+	}
+	return int(total), nil
+}
+
+// unixOrZero converts a stored timestamp, where 0 means unset.
+func unixOrZero(
+	seconds int64,
+) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// sessionColumns lists the refresh columns scanSession reads, for a query
+// that aliases the refresh table as r.
+const sessionColumns = `r.ip, r.user_agent, r.created_at, r.last_used_at, r.last_ip, r.expiration`
+
+// scanSession reads a row selected with sessionColumns.
+func scanSession(
+	row rowScanner,
+) (
+	service.Session,
+	error,
+) {
+	var session service.Session
+	var createdAt, lastUsedAt, expiresAt int64
+	if err := row.Scan(
+		&session.IP,
+		&session.UserAgent,
+		&createdAt,
+		&lastUsedAt,
+		&session.LastIP,
+		&expiresAt,
+	); err != nil {
+		return service.Session{}, err
+	}
+	session.CreatedAt = unixOrZero(createdAt)
+	session.LastUsedAt = unixOrZero(lastUsedAt)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	return session, nil
+}
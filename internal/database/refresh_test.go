@@ -2,8 +2,11 @@ package database_test
 
 import (
 	"testing"
+	"time"
 
+	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
 var (
@@ -20,7 +23,7 @@ func TestInsertRefreshToken_Success(t *testing.T) {
 	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
 
 	// inserting a refresh token succeeds
-	err := store.InsertRefreshToken(token)
+	err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{})
 	if err != nil {
 		t.Fatalf("InsertRefreshToken failed: %v", err)
 	}
@@ -33,7 +36,7 @@ func TestInsertRefreshToken_NonExistentUser(t *testing.T) {
 
 	// insert token for non-existent user should succeed (subquery returns no rows)
 	token := env.IssueTestRefreshToken(t, "nonexistent-user", testAudience1)
-	err := store.InsertRefreshToken(token)
+	err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{})
 	if err != nil {
 		t.Fatalf("InsertRefreshToken should succeed (no rows inserted): %v", err)
 	}
@@ -49,10 +52,10 @@ func TestInsertRefreshToken_MultipleTokens(t *testing.T) {
 	token2 := env.IssueTestRefreshToken(t, "alice", testAudience2)
 
 	// multiple tokens for same user can be stored
-	if err := store.InsertRefreshToken(token1); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), token1, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken token1 failed: %v", err)
 	}
-	if err := store.InsertRefreshToken(token2); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), token2, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken token2 failed: %v", err)
 	}
 }
@@ -66,12 +69,12 @@ func TestDeleteRefreshToken_Exists(t *testing.T) {
 	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
 
 	// first insert the token
-	if err := store.InsertRefreshToken(token); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken failed: %v", err)
 	}
 
 	// deleting existing token returns true
-	deleted, err := store.DeleteRefreshToken(token.Encoded())
+	deleted, err := store.DeleteRefreshToken(t.Context(), token.Encoded())
 	if err != nil {
 		t.Fatalf("DeleteRefreshToken failed: %v", err)
 	}
@@ -86,7 +89,7 @@ func TestDeleteRefreshToken_NotExists(t *testing.T) {
 	store := env.DB
 
 	// deleting non-existent token returns false
-	deleted, err := store.DeleteRefreshToken("nonexistent-jwt")
+	deleted, err := store.DeleteRefreshToken(t.Context(), "nonexistent-jwt")
 	if err != nil {
 		t.Fatalf("DeleteRefreshToken failed: %v", err)
 	}
@@ -104,12 +107,12 @@ func TestDeleteRefreshToken_DoubleDelete(t *testing.T) {
 	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
 
 	// first insert the token
-	if err := store.InsertRefreshToken(token); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken failed: %v", err)
 	}
 
 	// first delete succeeds
-	deleted1, err := store.DeleteRefreshToken(token.Encoded())
+	deleted1, err := store.DeleteRefreshToken(t.Context(), token.Encoded())
 	if err != nil {
 		t.Fatalf("DeleteRefreshToken first call failed: %v", err)
 	}
@@ -118,7 +121,7 @@ func TestDeleteRefreshToken_DoubleDelete(t *testing.T) {
 	}
 
 	// second delete fails
-	deleted2, err := store.DeleteRefreshToken(token.Encoded())
+	deleted2, err := store.DeleteRefreshToken(t.Context(), token.Encoded())
 	if err != nil {
 		t.Fatalf("DeleteRefreshToken second call failed: %v", err)
 	}
@@ -136,16 +139,16 @@ func TestGetRefreshTokenOwner_Exists(t *testing.T) {
 	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
 
 	// first insert the token
-	if err := store.InsertRefreshToken(token); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken failed: %v", err)
 	}
 
 	// owner is returned for existing token
-	owner, err := store.GetRefreshTokenOwner(token.Encoded())
+	owner, err := store.GetRefreshTokenOwner(t.Context(), token.Encoded())
 	if err != nil {
 		t.Fatalf("GetRefreshTokenOwner failed: %v", err)
 	}
-	user, err := store.GetUserByHandle("alice")
+	user, err := store.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
@@ -160,7 +163,7 @@ func TestGetRefreshTokenOwner_NotExists(t *testing.T) {
 	store := env.DB
 
 	// querying non-existent token returns error
-	_, err := store.GetRefreshTokenOwner("nonexistent-jwt")
+	_, err := store.GetRefreshTokenOwner(t.Context(), "nonexistent-jwt")
 	if err == nil {
 		t.Error("expected error for non-existent token")
 	}
@@ -175,15 +178,15 @@ func TestGetRefreshTokenOwner_AfterDelete(t *testing.T) {
 	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
 
 	// first insert the token
-	if err := store.InsertRefreshToken(token); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken failed: %v", err)
 	}
 
 	// delete the token
-	_, _ = store.DeleteRefreshToken(token.Encoded())
+	_, _ = store.DeleteRefreshToken(t.Context(), token.Encoded())
 
 	// querying deleted token returns error
-	_, err := store.GetRefreshTokenOwner(token.Encoded())
+	_, err := store.GetRefreshTokenOwner(t.Context(), token.Encoded())
 	if err == nil {
 		t.Error("expected error for deleted token")
 	}
@@ -203,19 +206,19 @@ func TestRefreshToken_MultipleUsers(t *testing.T) {
 	bobToken := env.IssueTestRefreshToken(t, "bob", testAudience1)
 
 	// store tokens for both users
-	if err := store.InsertRefreshToken(aliceToken); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), aliceToken, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken alice failed: %v", err)
 	}
-	if err := store.InsertRefreshToken(bobToken); err != nil {
+	if err := store.InsertRefreshToken(t.Context(), bobToken, service.ClientInfo{}); err != nil {
 		t.Fatalf("InsertRefreshToken bob failed: %v", err)
 	}
 
 	// each token returns correct owner
-	aliceOwner, err := store.GetRefreshTokenOwner(aliceToken.Encoded())
+	aliceOwner, err := store.GetRefreshTokenOwner(t.Context(), aliceToken.Encoded())
 	if err != nil {
 		t.Fatalf("GetRefreshTokenOwner alice failed: %v", err)
 	}
-	aliceUser, err := store.GetUserByHandle("alice")
+	aliceUser, err := store.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle alice failed: %v", err)
 	}
@@ -223,11 +226,11 @@ func TestRefreshToken_MultipleUsers(t *testing.T) {
 		t.Errorf("alice owner = %s, want %s", aliceOwner, aliceUser.Subject)
 	}
 
-	bobOwner, err := store.GetRefreshTokenOwner(bobToken.Encoded())
+	bobOwner, err := store.GetRefreshTokenOwner(t.Context(), bobToken.Encoded())
 	if err != nil {
 		t.Fatalf("GetRefreshTokenOwner bob failed: %v", err)
 	}
-	bobUser, err := store.GetUserByHandle("bob")
+	bobUser, err := store.GetUserByHandle(t.Context(), "bob")
 	if err != nil {
 		t.Fatalf("GetUserByHandle bob failed: %v", err)
 	}
@@ -235,3 +238,343 @@ func TestRefreshToken_MultipleUsers(t *testing.T) {
 		t.Errorf("bob owner = %s, want %s", bobOwner, bobUser.Subject)
 	}
 }
+
+func TestRotateRefreshToken_ReplacesStoredToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+
+	// setup env
+	old := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	next := env.IssueTestRefreshToken(t, "alice", testAudience2)
+	if err := store.InsertRefreshToken(t.Context(), old, service.ClientInfo{}); err != nil {
+		t.Fatalf("InsertRefreshToken failed: %v", err)
+	}
+
+	// rotation swaps the old token for the new one
+	rotated, err := store.RotateRefreshToken(t.Context(), old.Encoded(), next, service.ClientInfo{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if !rotated {
+		t.Fatal("expected rotated=true")
+	}
+	if _, err := store.GetRefreshTokenOwner(t.Context(), old.Encoded()); err == nil {
+		t.Error("expected old token to be deleted")
+	}
+	if _, err := store.GetRefreshTokenOwner(t.Context(), next.Encoded()); err != nil {
+		t.Errorf("expected new token to be stored: %v", err)
+	}
+}
+
+func TestRotateRefreshToken_NotStoredInsertsNothing(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+
+	// setup env
+	old := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	next := env.IssueTestRefreshToken(t, "alice", testAudience2)
+
+	// rotating an unknown token reports false and leaves the store unchanged
+	rotated, err := store.RotateRefreshToken(t.Context(), old.Encoded(), next, service.ClientInfo{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if rotated {
+		t.Error("expected rotated=false for unknown token")
+	}
+	if _, err := store.GetRefreshTokenOwner(t.Context(), next.Encoded()); err == nil {
+		t.Error("expected new token not to be stored")
+	}
+}
+
+func TestListRefreshTokens_RecordsClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+
+	// setup env
+	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	client := service.ClientInfo{IP: "203.0.113.7", UserAgent: "test-browser/1.0"}
+	if err := store.InsertRefreshToken(t.Context(), token, client); err != nil {
+		t.Fatalf("InsertRefreshToken failed: %v", err)
+	}
+
+	// the session carries the client and has not been used yet
+	sessions, err := store.ListRefreshTokens(t.Context(), token.Subject())
+	if err != nil {
+		t.Fatalf("ListRefreshTokens failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(sessions))
+	}
+	session := sessions[0]
+	if session.IP != client.IP || session.UserAgent != client.UserAgent {
+		t.Errorf("session client = %q/%q, want %q/%q", session.IP, session.UserAgent, client.IP, client.UserAgent)
+	}
+	if session.CreatedAt.Unix() != token.IssuedAt().Unix() {
+		t.Errorf("CreatedAt = %v, want %v", session.CreatedAt, token.IssuedAt())
+	}
+	if !session.LastUsedAt.IsZero() {
+		t.Errorf("LastUsedAt = %v, want zero", session.LastUsedAt)
+	}
+	if session.ExpiresAt.Unix() != token.Expiration().Unix() {
+		t.Errorf("ExpiresAt = %v, want %v", session.ExpiresAt, token.Expiration())
+	}
+}
+
+func TestRotateRefreshToken_KeepsSession(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+
+	// setup env
+	old := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	next := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	client := service.ClientInfo{IP: "203.0.113.7", UserAgent: "test-browser/1.0"}
+	if err := store.InsertRefreshToken(t.Context(), old, client); err != nil {
+		t.Fatalf("InsertRefreshToken failed: %v", err)
+	}
+	refresher := service.ClientInfo{IP: "198.51.100.2"}
+	if _, err := store.RotateRefreshToken(t.Context(), old.Encoded(), next, refresher); err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+
+	// the rotated token keeps the original client and records its use
+	sessions, err := store.ListRefreshTokens(t.Context(), old.Subject())
+	if err != nil {
+		t.Fatalf("ListRefreshTokens failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(sessions))
+	}
+	if sessions[0].IP != client.IP || sessions[0].UserAgent != client.UserAgent {
+		t.Errorf("session client = %q/%q, want original", sessions[0].IP, sessions[0].UserAgent)
+	}
+	if sessions[0].LastUsedAt.Unix() != next.IssuedAt().Unix() {
+		t.Errorf("LastUsedAt = %v, want %v", sessions[0].LastUsedAt, next.IssuedAt())
+	}
+	if sessions[0].LastIP != refresher.IP {
+		t.Errorf("LastIP = %q, want %q", sessions[0].LastIP, refresher.IP)
+	}
+}
+
+func TestTouchRefreshToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+
+	// setup env
+	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
+		t.Fatalf("InsertRefreshToken failed: %v", err)
+	}
+
+	// touching a stored token records its last use
+	usedAt := time.Unix(1_900_000_000, 0)
+	found, err := store.TouchRefreshToken(t.Context(), token.Encoded(), usedAt, service.ClientInfo{IP: "198.51.100.9"})
+	if err != nil || !found {
+		t.Fatalf("TouchRefreshToken = %v, %v; want true, nil", found, err)
+	}
+	sessions, err := store.ListRefreshTokens(t.Context(), token.Subject())
+	if err != nil {
+		t.Fatalf("ListRefreshTokens failed: %v", err)
+	}
+	if len(sessions) != 1 || !sessions[0].LastUsedAt.Equal(usedAt) || sessions[0].LastIP != "198.51.100.9" {
+		t.Fatalf("sessions = %+v, want one last used at %v from 198.51.100.9", sessions, usedAt)
+	}
+
+	// unknown tokens are reported as not found
+	found, err = store.TouchRefreshToken(t.Context(), "nonexistent-jwt", usedAt, service.ClientInfo{})
+	if err != nil || found {
+		t.Fatalf("TouchRefreshToken = %v, %v; want false, nil", found, err)
+	}
+}
+
+func TestGetRefreshSession(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+
+	// setup env
+	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	client := service.ClientInfo{IP: "203.0.113.7", UserAgent: "test-browser/1.0"}
+	if err := store.InsertRefreshToken(t.Context(), token, client); err != nil {
+		t.Fatalf("InsertRefreshToken failed: %v", err)
+	}
+
+	// a stored token's session is returned
+	session, found, err := store.GetRefreshSession(t.Context(), token.Encoded())
+	if err != nil || !found {
+		t.Fatalf("GetRefreshSession = %v, %v; want true, nil", found, err)
+	}
+	if session.IP != client.IP || session.UserAgent != client.UserAgent {
+		t.Errorf("session client = %q/%q, want %q/%q", session.IP, session.UserAgent, client.IP, client.UserAgent)
+	}
+
+	// unknown tokens are reported as not found
+	_, found, err = store.GetRefreshSession(t.Context(), "nonexistent-jwt")
+	if err != nil || found {
+		t.Fatalf("GetRefreshSession = %v, %v; want false, nil", found, err)
+	}
+}
+
+func TestDeleteUserTokens_KeepsUserAndOthers(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t,
+		testutil.TestUser{Handle: "alice", Password: "password"},
+		testutil.TestUser{Handle: "bob", Password: "password"},
+	)
+	store := env.DB
+
+	// setup env
+	aliceToken := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	bobToken := env.IssueTestRefreshToken(t, "bob", testAudience1)
+	for _, token := range []*tokens.RefreshToken{aliceToken, bobToken} {
+		if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
+			t.Fatalf("InsertRefreshToken failed: %v", err)
+		}
+	}
+
+	if err := store.DeleteUserTokens(t.Context(), aliceToken.Subject()); err != nil {
+		t.Fatalf("DeleteUserTokens failed: %v", err)
+	}
+
+	// alice's sessions are gone, but alice and bob's sessions remain
+	if sessions, _ := store.ListRefreshTokens(t.Context(), aliceToken.Subject()); len(sessions) != 0 {
+		t.Errorf("alice has %d sessions, want 0", len(sessions))
+	}
+	if sessions, _ := store.ListRefreshTokens(t.Context(), bobToken.Subject()); len(sessions) != 1 {
+		t.Errorf("bob has %d sessions, want 1", len(sessions))
+	}
+	if _, err := store.GetUserBySubject(t.Context(), aliceToken.Subject()); err != nil {
+		t.Errorf("GetUserBySubject failed: %v", err)
+	}
+}
+
+func TestDeleteRefreshTokensByAudience(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t,
+		testutil.TestUser{Handle: "alice", Password: "password"},
+		testutil.TestUser{Handle: "bob", Password: "password"},
+	)
+	store := env.DB
+
+	// setup env: alice's token is rotated, so only its successor is stored
+	old := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	rotated := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	bobs := env.IssueTestRefreshToken(t, "bob", []string{"test-audience-1", "test-audience-2"})
+	other := env.IssueTestRefreshToken(t, "bob", testAudience2)
+	for _, token := range []*tokens.RefreshToken{old, bobs, other} {
+		if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
+			t.Fatalf("InsertRefreshToken failed: %v", err)
+		}
+	}
+	if ok, err := store.RotateRefreshToken(t.Context(), old.Encoded(), rotated, service.ClientInfo{}); err != nil || !ok {
+		t.Fatalf("RotateRefreshToken = %v, %v", ok, err)
+	}
+
+	// every token naming the audience goes, whatever else it names
+	deleted, err := store.DeleteRefreshTokensByAudience(t.Context(), "test-audience-1")
+	if err != nil {
+		t.Fatalf("DeleteRefreshTokensByAudience failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted = %d, want 2", deleted)
+	}
+	for _, token := range []*tokens.RefreshToken{rotated, bobs} {
+		if _, err := store.GetRefreshTokenOwner(t.Context(), token.Encoded()); err == nil {
+			t.Error("expected token for audience to be deleted")
+		}
+	}
+	if _, err := store.GetRefreshTokenOwner(t.Context(), other.Encoded()); err != nil {
+		t.Errorf("expected token for other audience to remain: %v", err)
+	}
+}
+
+func TestDeleteRefreshTokensSince(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t,
+		testutil.TestUser{Handle: "alice", Password: "password"},
+		testutil.TestUser{Handle: "bob", Password: "password"},
+	)
+	store := env.DB
+	alices := env.StoreTestRefreshToken(t, "alice", testAudience1)
+	bobs := env.StoreTestRefreshToken(t, "bob", testAudience1)
+	alice, err := store.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+
+	// sessions started before the cutoff are kept
+	deleted, err := store.DeleteRefreshTokensSince(t.Context(), alice.Subject, alices.IssuedAt().Add(time.Second))
+	if err != nil {
+		t.Fatalf("DeleteRefreshTokensSince failed: %v", err)
+	}
+	if deleted != 0 {
+		t.Errorf("deleted = %d, want 0 before the cutoff", deleted)
+	}
+
+	// the user's sessions from the cutoff on go, and no one else's
+	deleted, err = store.DeleteRefreshTokensSince(t.Context(), alice.Subject, alices.IssuedAt())
+	if err != nil {
+		t.Fatalf("DeleteRefreshTokensSince failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted = %d, want 1", deleted)
+	}
+	if _, err := store.GetRefreshTokenOwner(t.Context(), alices.Encoded()); err == nil {
+		t.Error("expected alice's token to be deleted")
+	}
+	if _, err := store.GetRefreshTokenOwner(t.Context(), bobs.Encoded()); err != nil {
+		t.Errorf("expected bob's token to remain: %v", err)
+	}
+}
+
+func TestDeleteExpiredTokens(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password"})
+	store := env.DB
+	now := time.Now()
+
+	// setup env: a refresh token lasting an hour and a code lasting a minute
+	token := env.IssueTestRefreshToken(t, "alice", testAudience1)
+	if err := store.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
+		t.Fatalf("InsertRefreshToken failed: %v", err)
+	}
+	if err := store.InsertIntegration(t.Context(), service.Integration{Name: "code-app", Display: "Code App", Audience: "code.test", Redirect: "https://code.test/callback"}); err != nil {
+		t.Fatalf("InsertIntegration failed: %v", err)
+	}
+	if err := store.InsertAuthorizationCode(t.Context(), &service.AuthorizationCode{
+		CodeHash:    "hash-1",
+		Subject:     token.Subject(),
+		Integration: "code-app",
+		ExpiresAt:   now.Add(time.Minute),
+	}); err != nil {
+		t.Fatalf("InsertAuthorizationCode failed: %v", err)
+	}
+
+	prune := func(at time.Time) int {
+		t.Helper()
+		deleted, err := store.DeleteExpiredTokens(t.Context(), at)
+		if err != nil {
+			t.Fatalf("DeleteExpiredTokens failed: %v", err)
+		}
+		return deleted
+	}
+
+	// each is deleted only once it has expired
+	if deleted := prune(now); deleted != 0 {
+		t.Fatalf("deleted %d before anything expired, want 0", deleted)
+	}
+	if deleted := prune(now.Add(2 * time.Minute)); deleted != 1 {
+		t.Fatalf("deleted %d once the code expired, want 1", deleted)
+	}
+	if sessions, _ := store.ListRefreshTokens(t.Context(), token.Subject()); len(sessions) != 1 {
+		t.Fatalf("alice has %d sessions, want the unexpired one", len(sessions))
+	}
+	if deleted := prune(now.Add(2 * time.Hour)); deleted != 1 {
+		t.Fatalf("deleted %d once the refresh token expired, want 1", deleted)
+	}
+}
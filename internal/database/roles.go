@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -9,10 +10,14 @@ import (
 )
 
 func (db *DB) InsertRole(
+	ctx context.Context,
 	name string,
 	display string,
 ) error {
-	_, err := db.Conn.Exec(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.Conn.ExecContext(ctx, `
 		INSERT INTO role (name, display)
 		VALUES (?1, ?2)`,
 		name,
@@ -25,12 +30,16 @@ func (db *DB) InsertRole(
 }
 
 func (db *DB) GetRole(
+	ctx context.Context,
 	name string,
 ) (
 	service.Role,
 	error,
 ) {
-	row := db.Conn.QueryRow(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.Conn.QueryRowContext(ctx, `
 		SELECT name, display
 		FROM role
 		WHERE name=?1`,
@@ -48,11 +57,16 @@ func (db *DB) GetRole(
 	return record, nil
 }
 
-func (db *DB) ListRoles() (
+func (db *DB) ListRoles(
+	ctx context.Context,
+) (
 	[]service.Role,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
 		SELECT name, display
 		FROM role
 		ORDER BY name`)
@@ -80,9 +94,13 @@ func (db *DB) ListRoles() (
 }
 
 func (db *DB) UpdateRole(
+	ctx context.Context,
 	name string,
 	updates *service.RoleUpdate,
 ) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var setClauses []string
 	var args []any
 	argIdx := 1
@@ -106,7 +124,7 @@ func (db *DB) UpdateRole(
 	)
 	args = append(args, name)
 
-	result, err := db.Conn.Exec(query, args...)
+	result, err := db.Conn.ExecContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("couldn't update role: %w", err)
 	}
@@ -117,12 +135,16 @@ func (db *DB) UpdateRole(
 }
 
 func (db *DB) DeleteRole(
+	ctx context.Context,
 	name string,
 ) (
 	bool,
 	error,
 ) {
-	result, err := db.Conn.Exec(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
 		DELETE FROM role
 		WHERE name=?1`,
 		name,
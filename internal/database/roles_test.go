@@ -13,12 +13,12 @@ func TestInsertRole_RoundTrip(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("editor", "Content Editor")
+	err := store.InsertRole(t.Context(), "editor", "Content Editor")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	role, err := store.GetRole("editor")
+	role, err := store.GetRole(t.Context(), "editor")
 	if err != nil {
 		t.Fatalf("GetRole failed: %v", err)
 	}
@@ -34,7 +34,7 @@ func TestGetRole_NotFound(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	_, err := store.GetRole("nonexistent")
+	_, err := store.GetRole(t.Context(), "nonexistent")
 	if !errors.Is(err, sql.ErrNoRows) {
 		t.Fatalf("expected sql.ErrNoRows, got %v", err)
 	}
@@ -44,20 +44,20 @@ func TestListRoles(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("viewer", "Viewer")
+	err := store.InsertRole(t.Context(), "viewer", "Viewer")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
-	err = store.InsertRole("admin", "Administrator")
+	err = store.InsertRole(t.Context(), "admin", "Administrator")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %s", err)
 	}
-	err = store.InsertRole("editor", "Editor")
+	err = store.InsertRole(t.Context(), "editor", "Editor")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	roles, err := store.ListRoles()
+	roles, err := store.ListRoles(t.Context())
 	if err != nil {
 		t.Fatalf("ListRoles failed: %v", err)
 	}
@@ -73,18 +73,18 @@ func TestUpdateRoleDisplay(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("editor", "Editor")
+	err := store.InsertRole(t.Context(), "editor", "Editor")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
 	display := "Senior Editor"
-	err = store.UpdateRole("editor", &service.RoleUpdate{Display: &display})
+	err = store.UpdateRole(t.Context(), "editor", &service.RoleUpdate{Display: &display})
 	if err != nil {
 		t.Fatalf("UpdateRole failed: %v", err)
 	}
 
-	role, err := store.GetRole("editor")
+	role, err := store.GetRole(t.Context(), "editor")
 	if err != nil {
 		t.Fatalf("GetRole failed: %v", err)
 	}
@@ -98,7 +98,7 @@ func TestUpdateRoleDisplay_NotFound(t *testing.T) {
 	store := testutil.SetupTestDB(t)
 
 	display := "Something"
-	err := store.UpdateRole("nonexistent", &service.RoleUpdate{Display: &display})
+	err := store.UpdateRole(t.Context(), "nonexistent", &service.RoleUpdate{Display: &display})
 	if err == nil {
 		t.Fatal("expected error for nonexistent role")
 	}
@@ -111,12 +111,12 @@ func TestDeleteRole(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("deleteme", "Delete Me")
+	err := store.InsertRole(t.Context(), "deleteme", "Delete Me")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	deleted, err := store.DeleteRole("deleteme")
+	deleted, err := store.DeleteRole(t.Context(), "deleteme")
 	if err != nil {
 		t.Fatalf("DeleteRole failed: %v", err)
 	}
@@ -124,7 +124,7 @@ func TestDeleteRole(t *testing.T) {
 		t.Fatal("expected deleted = true")
 	}
 
-	_, err = store.GetRole("deleteme")
+	_, err = store.GetRole(t.Context(), "deleteme")
 	if err == nil {
 		t.Fatal("expected GetRole to fail after deletion")
 	}
@@ -134,7 +134,7 @@ func TestDeleteRole_NotFound(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	deleted, err := store.DeleteRole("nonexistent")
+	deleted, err := store.DeleteRole(t.Context(), "nonexistent")
 	if err != nil {
 		t.Fatalf("DeleteRole failed: %v", err)
 	}
@@ -147,21 +147,21 @@ func TestInsertUser_RolesRoundTrip(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("admin", "Administrator")
+	err := store.InsertRole(t.Context(), "admin", "Administrator")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
-	err = store.InsertRole("ops", "Operations")
+	err = store.InsertRole(t.Context(), "ops", "Operations")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	err = store.InsertUser("subject-alice", "alice", []byte("secret"), []string{"admin", "ops"})
+	err = store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), []string{"admin", "ops"})
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 
-	user, err := store.GetUserBySubject("subject-alice")
+	user, err := store.GetUserBySubject(t.Context(), "subject-alice")
 	if err != nil {
 		t.Fatalf("GetUserBySubject failed: %v", err)
 	}
@@ -181,12 +181,12 @@ func TestInsertUser_AutoCreatesRoles(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertUser("subject-alice", "alice", []byte("secret"), []string{"auto-role"})
+	err := store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), []string{"auto-role"})
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 
-	role, err := store.GetRole("auto-role")
+	role, err := store.GetRole(t.Context(), "auto-role")
 	if err != nil {
 		t.Fatalf("GetRole failed: %v", err)
 	}
@@ -202,26 +202,26 @@ func TestUpdateUser_RolesRoundTrip(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("admin", "Administrator")
+	err := store.InsertRole(t.Context(), "admin", "Administrator")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
-	err = store.InsertRole("billing", "Billing")
+	err = store.InsertRole(t.Context(), "billing", "Billing")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	err = store.InsertUser("subject-alice", "alice", []byte("secret"), nil)
+	err = store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), nil)
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 
-	err = store.UpdateUser("subject-alice", "alice-2", []string{"admin", "billing"})
+	err = store.UpdateUser(t.Context(), service.User{Subject: "subject-alice", Handle: "alice-2", Roles: []string{"admin", "billing"}})
 	if err != nil {
 		t.Fatalf("UpdateUser failed: %v", err)
 	}
 
-	user, err := store.GetUserBySubject("subject-alice")
+	user, err := store.GetUserBySubject(t.Context(), "subject-alice")
 	if err != nil {
 		t.Fatalf("GetUserBySubject failed: %v", err)
 	}
@@ -244,25 +244,25 @@ func TestListUsers_Roles(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("admin", "Administrator")
+	err := store.InsertRole(t.Context(), "admin", "Administrator")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
-	err = store.InsertRole("viewer", "Viewer")
+	err = store.InsertRole(t.Context(), "viewer", "Viewer")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	err = store.InsertUser("subject-alice", "alice", []byte("secret"), []string{"admin"})
+	err = store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), []string{"admin"})
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
-	err = store.InsertUser("subject-bob", "bob", []byte("secret"), []string{"viewer", "admin"})
+	err = store.InsertUser(t.Context(), "subject-bob", "bob", []byte("secret"), []string{"viewer", "admin"})
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 
-	users, err := store.ListUsers()
+	users, err := store.ListUsers(t.Context())
 	if err != nil {
 		t.Fatalf("ListUsers failed: %v", err)
 	}
@@ -291,17 +291,17 @@ func TestDeleteUser_CascadesUserRoles(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("admin", "Administrator")
+	err := store.InsertRole(t.Context(), "admin", "Administrator")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	err = store.InsertUser("subject-alice", "alice", []byte("secret"), []string{"admin"})
+	err = store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), []string{"admin"})
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 
-	deleted, err := store.DeleteUser("subject-alice")
+	deleted, err := store.DeleteUser(t.Context(), "subject-alice")
 	if err != nil {
 		t.Fatalf("DeleteUser failed: %v", err)
 	}
@@ -309,12 +309,12 @@ func TestDeleteUser_CascadesUserRoles(t *testing.T) {
 		t.Fatal("expected deleted = true")
 	}
 
-	_, err = store.GetUserBySubject("subject-alice")
+	_, err = store.GetUserBySubject(t.Context(), "subject-alice")
 	if err == nil {
 		t.Fatal("expected GetUserBySubject to fail after deletion")
 	}
 
-	roles, err := store.ListRoles()
+	roles, err := store.ListRoles(t.Context())
 	if err != nil {
 		t.Fatalf("ListRoles failed: %v", err)
 	}
@@ -327,17 +327,17 @@ func TestDeleteRole_CascadesUserRoles(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.InsertRole("admin", "Administrator")
+	err := store.InsertRole(t.Context(), "admin", "Administrator")
 	if err != nil {
 		t.Fatalf("InsertRole failed: %v", err)
 	}
 
-	err = store.InsertUser("subject-alice", "alice", []byte("secret"), []string{"admin"})
+	err = store.InsertUser(t.Context(), "subject-alice", "alice", []byte("secret"), []string{"admin"})
 	if err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 
-	deleted, err := store.DeleteRole("admin")
+	deleted, err := store.DeleteRole(t.Context(), "admin")
 	if err != nil {
 		t.Fatalf("DeleteRole failed: %v", err)
 	}
@@ -345,7 +345,7 @@ func TestDeleteRole_CascadesUserRoles(t *testing.T) {
 		t.Fatal("expected deleted = true")
 	}
 
-	user, err := store.GetUserBySubject("subject-alice")
+	user, err := store.GetUserBySubject(t.Context(), "subject-alice")
 	if err != nil {
 		t.Fatalf("GetUserBySubject failed: %v", err)
 	}
@@ -1,26 +1,32 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 )
 
 func (db *DB) InsertUser(
+	ctx context.Context,
 	subject string,
 	handle string,
 	secret []byte,
 	roles []string,
 ) error {
-	tx, err := db.Conn.Begin()
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin user insert transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO user (subject, handle, secret)
 		VALUES (?1, ?2, ?3)`,
 		subject,
@@ -31,7 +37,7 @@ func (db *DB) InsertUser(
 		return fmt.Errorf("insert user: %w", err)
 	}
 
-	if err := ensureAndAssignRolesTx(tx, subject, roles); err != nil {
+	if err := ensureAndAssignRolesTx(ctx, tx, subject, roles); err != nil {
 		return err
 	}
 
@@ -43,13 +49,17 @@ func (db *DB) InsertUser(
 }
 
 func (db *DB) GetUserByHandle(
+	ctx context.Context,
 	handle string,
 ) (
 	*service.User,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
-		SELECT u.subject, u.handle, r.name
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT u.subject, u.handle, NOT u.enabled, u.expires_at, u.terms_version, u.terms_accepted_at, r.name
 		FROM user u
 		LEFT JOIN user_roles ur ON u.subject = ur.user_subject
 		LEFT JOIN role r ON ur.role_name = r.name
@@ -65,13 +75,17 @@ func (db *DB) GetUserByHandle(
 }
 
 func (db *DB) GetUserBySubject(
+	ctx context.Context,
 	subject string,
 ) (
 	*service.User,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
-		SELECT u.subject, u.handle, r.name
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT u.subject, u.handle, NOT u.enabled, u.expires_at, u.terms_version, u.terms_accepted_at, r.name
 		FROM user u
 		LEFT JOIN user_roles ur ON u.subject = ur.user_subject
 		LEFT JOIN role r ON ur.role_name = r.name
@@ -86,12 +100,17 @@ func (db *DB) GetUserBySubject(
 	return scanUserRows(rows)
 }
 
-func (db *DB) ListUsers() (
+func (db *DB) ListUsers(
+	ctx context.Context,
+) (
 	[]service.User,
 	error,
 ) {
-	rows, err := db.Conn.Query(`
-		SELECT u.subject, u.handle, r.name
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT u.subject, u.handle, NOT u.enabled, u.expires_at, u.terms_version, u.terms_accepted_at, r.name
 		FROM user u
 		LEFT JOIN user_roles ur ON u.subject = ur.user_subject
 		LEFT JOIN role r ON ur.role_name = r.name
@@ -105,10 +124,12 @@ func (db *DB) ListUsers() (
 	var order []string
 
 	for rows.Next() {
-		var subject, handle string
+		var subject, handle, termsVersion string
+		var disabled bool
+		var expiresAt, termsAcceptedAt int64
 		var roleName *string
 
-		err := rows.Scan(&subject, &handle, &roleName)
+		err := rows.Scan(&subject, &handle, &disabled, &expiresAt, &termsVersion, &termsAcceptedAt, &roleName)
 		if err != nil {
 			return nil, fmt.Errorf("scan user row: %w", err)
 		}
@@ -116,9 +137,13 @@ func (db *DB) ListUsers() (
 		record, exists := bySubject[subject]
 		if !exists {
 			record = &service.User{
-				Subject: subject,
-				Handle:  handle,
-				Roles:   nil,
+				Subject:         subject,
+				Handle:          handle,
+				Roles:           nil,
+				Disabled:        disabled,
+				ExpiresAt:       unixOrZero(expiresAt),
+				TermsVersion:    termsVersion,
+				TermsAcceptedAt: unixOrZero(termsAcceptedAt),
 			}
 			bySubject[subject] = record
 			order = append(order, subject)
@@ -145,23 +170,34 @@ func (db *DB) ListUsers() (
 	return records, nil
 }
 
+// UpdateUser saves user's handle, roles, disabled flag, and expiry.
 func (db *DB) UpdateUser(
-	subject string,
-	handle string,
-	roles []string,
+	ctx context.Context,
+	user service.User,
 ) error {
-	tx, err := db.Conn.Begin()
+	subject, roles := user.Subject, user.Roles
+	var expiresAt int64
+	if !user.ExpiresAt.IsZero() {
+		expiresAt = user.ExpiresAt.Unix()
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin user update transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	result, err := tx.Exec(`
+	result, err := tx.ExecContext(ctx, `
 		UPDATE user
-		SET handle=?1
+		SET handle=?1, enabled=?3, expires_at=?4
 		WHERE subject=?2`,
-		handle,
+		user.Handle,
 		subject,
+		!user.Disabled,
+		expiresAt,
 	)
 	if err != nil {
 		return fmt.Errorf("update user %q: %w", subject, err)
@@ -180,7 +216,7 @@ func (db *DB) UpdateUser(
 		}
 		notInClause := strings.Join(placeholders, ", ")
 
-		_, err = tx.Exec(fmt.Sprintf(`
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
 			DELETE FROM user_roles
 			WHERE user_subject=?1 AND role_name NOT IN (%s)`, notInClause),
 			args...,
@@ -189,11 +225,11 @@ func (db *DB) UpdateUser(
 			return fmt.Errorf("remove obsolete roles for user %q: %w", subject, err)
 		}
 
-		if err := ensureAndAssignRolesTx(tx, subject, roles); err != nil {
+		if err := ensureAndAssignRolesTx(ctx, tx, subject, roles); err != nil {
 			return err
 		}
 	} else {
-		_, err = tx.Exec(`
+		_, err = tx.ExecContext(ctx, `
 			DELETE FROM user_roles
 			WHERE user_subject=?1`,
 			subject,
@@ -210,13 +246,43 @@ func (db *DB) UpdateUser(
 	return nil
 }
 
+// UpdateUserHandle renames a user. Refresh tokens and grants reference the
+// user row id, so ownership follows the rename without further updates.
+func (db *DB) UpdateUserHandle(
+	ctx context.Context,
+	subject string,
+	handle string,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		UPDATE user
+		SET handle=?1
+		WHERE subject=?2`,
+		handle,
+		subject,
+	)
+	if err != nil {
+		return fmt.Errorf("update handle for user %q: %w", subject, err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (db *DB) DeleteUser(
+	ctx context.Context,
 	subject string,
 ) (
 	bool,
 	error,
 ) {
-	result, err := db.Conn.Exec(`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
 		DELETE FROM user
 		WHERE subject=?1`,
 		subject,
@@ -229,14 +295,86 @@ func (db *DB) DeleteUser(
 	return deleted, nil
 }
 
+// ListExpiredUsers returns the subjects of users whose accounts expired
+// after since and no later than until.
+func (db *DB) ListExpiredUsers(
+	ctx context.Context,
+	since time.Time,
+	until time.Time,
+) (
+	[]string,
+	error,
+) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT subject
+		FROM user
+		WHERE expires_at > ?1 AND expires_at <= ?2
+		ORDER BY expires_at`,
+		max(since.Unix(), 0),
+		until.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query expired users: %w", err)
+	}
+	defer rows.Close()
+
+	var subjects []string
+	for rows.Next() {
+		var subject string
+		if err := rows.Scan(&subject); err != nil {
+			return nil, fmt.Errorf("scan expired user: %w", err)
+		}
+		subjects = append(subjects, subject)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't iterate expired users: %w", err)
+	}
+	return subjects, nil
+}
+
+// AcceptTerms records that the user accepted version of the terms of service
+// at acceptedAt, replacing any version they accepted before.
+func (db *DB) AcceptTerms(
+	ctx context.Context,
+	subject string,
+	version string,
+	acceptedAt time.Time,
+) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.Conn.ExecContext(ctx, `
+		UPDATE user
+		SET terms_version=?2, terms_accepted_at=?3
+		WHERE subject=?1`,
+		subject,
+		version,
+		acceptedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("accept terms for user %q: %w", subject, err)
+	}
+	if resultsEmpty(result) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (db *DB) GetSecret(
+	ctx context.Context,
 	handle string,
 ) (
 	[]byte,
 	error,
 ) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var secret []byte
-	err := db.Conn.QueryRow(`
+	err := db.Conn.QueryRowContext(ctx, `
 		SELECT secret
 		FROM user
 		WHERE handle=?1`,
@@ -255,7 +393,9 @@ func scanUserRows(
 	error,
 ) {
 	var record *service.User
-	var subject, handle string
+	var subject, handle, termsVersion string
+	var disabled bool
+	var expiresAt, termsAcceptedAt int64
 	var roleNames []string
 
 	for rows.Next() {
@@ -264,6 +404,10 @@ func scanUserRows(
 		if err := rows.Scan(
 			&subject,
 			&handle,
+			&disabled,
+			&expiresAt,
+			&termsVersion,
+			&termsAcceptedAt,
 			&roleName,
 		); err != nil {
 			return nil, fmt.Errorf("scan user row: %w", err)
@@ -271,9 +415,13 @@ func scanUserRows(
 
 		if record == nil {
 			record = &service.User{
-				Subject: subject,
-				Handle:  handle,
-				Roles:   roleNames,
+				Subject:         subject,
+				Handle:          handle,
+				Roles:           roleNames,
+				Disabled:        disabled,
+				ExpiresAt:       unixOrZero(expiresAt),
+				TermsVersion:    termsVersion,
+				TermsAcceptedAt: unixOrZero(termsAcceptedAt),
 			}
 		}
 
@@ -295,11 +443,12 @@ func scanUserRows(
 }
 
 func ensureAndAssignRolesTx(
+	ctx context.Context,
 	tx *sql.Tx,
 	subject string,
 	roles []string,
 ) error {
-	ensureStmt, err := tx.Prepare(`
+	ensureStmt, err := tx.PrepareContext(ctx, `
 		INSERT OR IGNORE INTO role (name, display)
 		VALUES (?1, ?1)`)
 	if err != nil {
@@ -307,7 +456,7 @@ func ensureAndAssignRolesTx(
 	}
 	defer ensureStmt.Close()
 
-	assignStmt, err := tx.Prepare(`
+	assignStmt, err := tx.PrepareContext(ctx, `
 		INSERT OR IGNORE INTO user_roles (user_subject, role_name)
 		VALUES (?1, ?2)`)
 	if err != nil {
@@ -316,10 +465,10 @@ func ensureAndAssignRolesTx(
 	defer assignStmt.Close()
 
 	for _, role := range roles {
-		if _, err := ensureStmt.Exec(role); err != nil {
+		if _, err := ensureStmt.ExecContext(ctx, role); err != nil {
 			return fmt.Errorf("ensure role %q: %w", role, err)
 		}
-		if _, err := assignStmt.Exec(subject, role); err != nil {
+		if _, err := assignStmt.ExecContext(ctx, subject, role); err != nil {
 			return fmt.Errorf("assign role %q to user %q: %w", role, subject, err)
 		}
 	}
@@ -1,27 +1,31 @@
 package database_test
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"slices"
 	"testing"
+	"time"
 
+	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
 )
 
 func insertUser(t *testing.T, store interface {
-	InsertUser(string, string, []byte, []string) error
+	InsertUser(context.Context, string, string, []byte, []string) error
 }, handle string, roles []string) {
 	t.Helper()
-	if err := store.InsertUser("subject-"+handle, handle, []byte("hashed-password"), roles); err != nil {
+	if err := store.InsertUser(t.Context(), "subject-"+handle, handle, []byte("hashed-password"), roles); err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 }
 
 func insertUserWithSecret(t *testing.T, store interface {
-	InsertUser(string, string, []byte, []string) error
+	InsertUser(context.Context, string, string, []byte, []string) error
 }, subject, handle string, secret []byte, roles []string) {
 	t.Helper()
-	if err := store.InsertUser(subject, handle, secret, roles); err != nil {
+	if err := store.InsertUser(t.Context(), subject, handle, secret, roles); err != nil {
 		t.Fatalf("InsertUser failed: %v", err)
 	}
 }
@@ -42,7 +46,7 @@ func TestInsertUser_DuplicateHandle(t *testing.T) {
 	insertUser(t, store, "alice", nil)
 
 	// second insert with same handle fails
-	err := store.InsertUser("subject-alice-2", "alice", []byte("password2"), nil)
+	err := store.InsertUser(t.Context(), "subject-alice-2", "alice", []byte("password2"), nil)
 	if err == nil {
 		t.Fatal("expected error for duplicate handle")
 	}
@@ -63,7 +67,7 @@ func TestInsertUser_EmptyHandle(t *testing.T) {
 	store := testutil.SetupTestDB(t)
 
 	// empty handle is allowed by schema
-	err := store.InsertUser("subject-empty", "", []byte("password"), nil)
+	err := store.InsertUser(t.Context(), "subject-empty", "", []byte("password"), nil)
 	if err != nil {
 		t.Fatalf("InsertUser with empty handle failed: %v", err)
 	}
@@ -77,7 +81,7 @@ func TestInsertUser_BinarySecret(t *testing.T) {
 	binarySecret := []byte{0x00, 0x01, 0x02, 0xff, 0xfe, 0xfd}
 	insertUserWithSecret(t, store, "subject-binary-user", "binary-user", binarySecret, nil)
 
-	secret, err := store.GetSecret("binary-user")
+	secret, err := store.GetSecret(t.Context(), "binary-user")
 	if err != nil {
 		t.Fatalf("GetSecret failed: %v", err)
 	}
@@ -98,7 +102,7 @@ func TestGetUserByHandle_Success(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	user, err := store.GetUserByHandle("alice")
+	user, err := store.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
@@ -116,7 +120,7 @@ func TestGetUserBySubject_Success(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	user, err := store.GetUserBySubject("subject-alice")
+	user, err := store.GetUserBySubject(t.Context(), "subject-alice")
 	if err != nil {
 		t.Fatalf("GetUserBySubject failed: %v", err)
 	}
@@ -135,7 +139,7 @@ func TestListUsers_Success(t *testing.T) {
 	insertUser(t, store, "alice", nil)
 	insertUser(t, store, "bob", nil)
 
-	users, err := store.ListUsers()
+	users, err := store.ListUsers(t.Context())
 	if err != nil {
 		t.Fatalf("ListUsers failed: %v", err)
 	}
@@ -156,12 +160,12 @@ func TestUpdateUser_Success(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	err := store.UpdateUser("subject-alice", "alice-updated", nil)
+	err := store.UpdateUser(t.Context(), service.User{Subject: "subject-alice", Handle: "alice-updated"})
 	if err != nil {
 		t.Fatalf("UpdateUser failed: %v", err)
 	}
 
-	user, err := store.GetUserByHandle("alice-updated")
+	user, err := store.GetUserByHandle(t.Context(), "alice-updated")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
@@ -170,11 +174,112 @@ func TestUpdateUser_Success(t *testing.T) {
 	}
 }
 
+func TestUpdateUser_Disabled(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	insertUser(t, store, "alice", nil)
+	user, err := store.GetUserBySubject(t.Context(), "subject-alice")
+	if err != nil {
+		t.Fatalf("GetUserBySubject failed: %v", err)
+	}
+	if user.Disabled {
+		t.Fatal("new user is disabled")
+	}
+
+	if err := store.UpdateUser(t.Context(), service.User{Subject: "subject-alice", Handle: "alice", Disabled: true}); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	user, err = store.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	if !user.Disabled {
+		t.Error("user was not disabled")
+	}
+	users, err := store.ListUsers(t.Context())
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	if len(users) != 1 || !users[0].Disabled {
+		t.Errorf("ListUsers = %+v, want alice disabled", users)
+	}
+}
+
+func TestListExpiredUsers(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	now := time.Now().Truncate(time.Second)
+
+	// alice expired an hour ago, bob a day ago, carol expires tomorrow, and
+	// dave never does
+	expiries := map[string]time.Time{
+		"alice": now.Add(-time.Hour),
+		"bob":   now.Add(-24 * time.Hour),
+		"carol": now.Add(24 * time.Hour),
+		"dave":  {},
+	}
+	for handle, expiresAt := range expiries {
+		insertUser(t, store, handle, nil)
+		user := service.User{Subject: "subject-" + handle, Handle: handle, ExpiresAt: expiresAt}
+		if err := store.UpdateUser(t.Context(), user); err != nil {
+			t.Fatalf("UpdateUser failed: %v", err)
+		}
+	}
+
+	user, err := store.GetUserBySubject(t.Context(), "subject-carol")
+	if err != nil {
+		t.Fatalf("GetUserBySubject failed: %v", err)
+	}
+	if !user.ExpiresAt.Equal(expiries["carol"]) {
+		t.Errorf("ExpiresAt = %v, want %v", user.ExpiresAt, expiries["carol"])
+	}
+
+	subjects, err := store.ListExpiredUsers(t.Context(), time.Time{}, now)
+	if err != nil {
+		t.Fatalf("ListExpiredUsers failed: %v", err)
+	}
+	if !slices.Equal(subjects, []string{"subject-bob", "subject-alice"}) {
+		t.Errorf("expired = %v, want bob and alice", subjects)
+	}
+
+	subjects, err = store.ListExpiredUsers(t.Context(), now.Add(-2*time.Hour), now)
+	if err != nil {
+		t.Fatalf("ListExpiredUsers failed: %v", err)
+	}
+	if !slices.Equal(subjects, []string{"subject-alice"}) {
+		t.Errorf("expired since two hours ago = %v, want alice", subjects)
+	}
+}
+
+func TestAcceptTerms(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+	insertUser(t, store, "alice", nil)
+	acceptedAt := time.Now().Truncate(time.Second)
+
+	if err := store.AcceptTerms(t.Context(), "subject-alice", "2026-10", acceptedAt); err != nil {
+		t.Fatalf("AcceptTerms failed: %v", err)
+	}
+	user, err := store.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	if user.TermsVersion != "2026-10" || !user.TermsAcceptedAt.Equal(acceptedAt) {
+		t.Errorf("terms = %q at %v, want 2026-10 at %v", user.TermsVersion, user.TermsAcceptedAt, acceptedAt)
+	}
+
+	err = store.AcceptTerms(t.Context(), "nonexistent", "2026-10", acceptedAt)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
 func TestUpdateUser_NotFound(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	err := store.UpdateUser("nonexistent", "new-handle", nil)
+	err := store.UpdateUser(t.Context(), service.User{Subject: "nonexistent", Handle: "new-handle"})
 	if !errors.Is(err, sql.ErrNoRows) {
 		t.Fatalf("expected sql.ErrNoRows, got %v", err)
 	}
@@ -186,7 +291,7 @@ func TestDeleteUser_Success(t *testing.T) {
 
 	insertUser(t, store, "alice", nil)
 
-	deleted, err := store.DeleteUser("subject-alice")
+	deleted, err := store.DeleteUser(t.Context(), "subject-alice")
 	if err != nil {
 		t.Fatalf("DeleteUser failed: %v", err)
 	}
@@ -199,7 +304,7 @@ func TestDeleteUser_NotFound(t *testing.T) {
 	t.Parallel()
 	store := testutil.SetupTestDB(t)
 
-	deleted, err := store.DeleteUser("nonexistent")
+	deleted, err := store.DeleteUser(t.Context(), "nonexistent")
 	if err != nil {
 		t.Fatalf("DeleteUser failed: %v", err)
 	}
@@ -216,7 +321,7 @@ func TestGetSecret_ExistingUser(t *testing.T) {
 	insertUserWithSecret(t, store, "test-subject", "bob", []byte("my-secret-hash"), nil)
 
 	// retrieving secret for existing user returns correct value
-	secret, err := store.GetSecret("bob")
+	secret, err := store.GetSecret(t.Context(), "bob")
 	if err != nil {
 		t.Fatalf("GetSecret failed: %v", err)
 	}
@@ -230,7 +335,7 @@ func TestGetSecret_NonExistentUser(t *testing.T) {
 	store := testutil.SetupTestDB(t)
 
 	// querying non-existent user returns ErrNoRows
-	_, err := store.GetSecret("unknown")
+	_, err := store.GetSecret(t.Context(), "unknown")
 	if !errors.Is(err, sql.ErrNoRows) {
 		t.Errorf("expected sql.ErrNoRows, got %v", err)
 	}
@@ -245,7 +350,7 @@ func TestGetSecret_CorrectUser(t *testing.T) {
 	insertUser(t, store, "bob", nil)
 
 	// each user's secret is retrieved correctly
-	secret, err := store.GetSecret("alice")
+	secret, err := store.GetSecret(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetSecret failed: %v", err)
 	}
@@ -253,7 +358,7 @@ func TestGetSecret_CorrectUser(t *testing.T) {
 		t.Errorf("GetSecret = %s, want hashed-password", string(secret))
 	}
 
-	secret, err = store.GetSecret("bob")
+	secret, err = store.GetSecret(t.Context(), "bob")
 	if err != nil {
 		t.Fatalf("GetSecret failed: %v", err)
 	}
@@ -261,3 +366,45 @@ func TestGetSecret_CorrectUser(t *testing.T) {
 		t.Errorf("GetSecret = %s, want hashed-password", string(secret))
 	}
 }
+
+func TestUpdateUserHandle_Success(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	insertUser(t, store, "alice", nil)
+
+	// renaming updates the handle for the subject
+	if err := store.UpdateUserHandle(t.Context(), "subject-alice", "alicia"); err != nil {
+		t.Fatalf("UpdateUserHandle failed: %v", err)
+	}
+	user, err := store.GetUserBySubject(t.Context(), "subject-alice")
+	if err != nil {
+		t.Fatalf("GetUserBySubject failed: %v", err)
+	}
+	if user.Handle != "alicia" {
+		t.Fatalf("handle = %s, want alicia", user.Handle)
+	}
+}
+
+func TestUpdateUserHandle_Conflict(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	insertUser(t, store, "alice", nil)
+	insertUser(t, store, "bob", nil)
+
+	// renaming onto an existing handle fails
+	if err := store.UpdateUserHandle(t.Context(), "subject-alice", "bob"); err == nil {
+		t.Fatal("expected error for duplicate handle")
+	}
+}
+
+func TestUpdateUserHandle_NotFound(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	err := store.UpdateUserHandle(t.Context(), "subject-missing", "alicia")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
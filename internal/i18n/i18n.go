@@ -0,0 +1,184 @@
+// Package i18n translates the server-rendered pages. Messages are keyed by
+// their English text, so English needs no catalog and a message missing from
+// a catalog is shown in English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// SourceLocale is the language the templates are written in.
+const SourceLocale = "en"
+
+type Options struct {
+	// DefaultLocale is used when a request's Accept-Language names no
+	// supported language. Empty uses SourceLocale.
+	DefaultLocale string
+
+	// Dir is an optional directory of <locale>.json catalogs that add
+	// languages or replace built-in messages.
+	Dir string
+}
+
+// Catalog holds the translations for every supported locale. A nil Catalog
+// supports only SourceLocale.
+type Catalog struct {
+	locales  []string
+	messages map[string]map[string]string
+	matcher  language.Matcher
+}
+
+func New(
+	options Options,
+) (
+	*Catalog,
+	error,
+) {
+	messages := map[string]map[string]string{}
+	if err := loadCatalogs(localesFS, "locales", messages); err != nil {
+		return nil, err
+	}
+	if options.Dir != "" {
+		if err := loadCatalogs(os.DirFS(options.Dir), ".", messages); err != nil {
+			return nil, fmt.Errorf("locales path: %w", err)
+		}
+	}
+
+	defaultLocale := SourceLocale
+	if options.DefaultLocale != "" {
+		tag, err := language.Parse(options.DefaultLocale)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default locale %q: %w", options.DefaultLocale, err)
+		}
+		defaultLocale = tag.String()
+	}
+	if _, ok := messages[defaultLocale]; !ok && defaultLocale != SourceLocale {
+		return nil, fmt.Errorf("no catalog for default locale %q", defaultLocale)
+	}
+
+	// the default locale comes first so the matcher falls back to it
+	locales := []string{defaultLocale}
+	if defaultLocale != SourceLocale {
+		locales = append(locales, SourceLocale)
+	}
+	for _, locale := range slices.Sorted(maps.Keys(messages)) {
+		if !slices.Contains(locales, locale) {
+			locales = append(locales, locale)
+		}
+	}
+
+	tags := make([]language.Tag, len(locales))
+	for i, locale := range locales {
+		tags[i] = language.Make(locale)
+	}
+
+	return &Catalog{
+		locales:  locales,
+		messages: messages,
+		matcher:  language.NewMatcher(tags),
+	}, nil
+}
+
+// loadCatalogs reads every <locale>.json file in dir into messages, merging
+// over any messages already loaded for the same locale.
+func loadCatalogs(
+	fsys fs.FS,
+	dir string,
+	messages map[string]map[string]string,
+) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".json")
+		tag, err := language.Parse(name)
+		if err != nil {
+			return fmt.Errorf("catalog %s: invalid locale: %w", file, err)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("catalog %s: %w", file, err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("catalog %s: %w", file, err)
+		}
+
+		locale := tag.String()
+		if messages[locale] == nil {
+			messages[locale] = map[string]string{}
+		}
+		for message, translation := range catalog {
+			messages[locale][message] = translation
+		}
+	}
+	return nil
+}
+
+// DefaultLocale returns the locale used when negotiation finds no match.
+func (c *Catalog) DefaultLocale() string {
+	if c == nil {
+		return SourceLocale
+	}
+	return c.locales[0]
+}
+
+// Locales returns every supported locale, default first.
+func (c *Catalog) Locales() []string {
+	if c == nil {
+		return []string{SourceLocale}
+	}
+	return slices.Clone(c.locales)
+}
+
+// Negotiate picks the supported locale that best matches an Accept-Language
+// header, falling back to the default locale.
+func (c *Catalog) Negotiate(
+	acceptLanguage string,
+) string {
+	if c == nil {
+		return SourceLocale
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return c.DefaultLocale()
+	}
+	_, index, confidence := c.matcher.Match(tags...)
+	if confidence == language.No {
+		return c.DefaultLocale()
+	}
+	return c.locales[index]
+}
+
+// Translate returns message in locale, formatted with args as by
+// fmt.Sprintf. Messages without a translation are used as written.
+func (c *Catalog) Translate(
+	locale string,
+	message string,
+	args ...any,
+) string {
+	if c != nil {
+		if translation := c.messages[locale][message]; translation != "" {
+			message = translation
+		}
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
@@ -0,0 +1,109 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	catalog, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"es", "es"},
+		{"es-MX,es;q=0.9,en;q=0.8", "es"},
+		{"fr-CA, fr;q=0.9", "en"},
+		{"fr;q=0.9, es;q=0.5", "es"},
+		{"not a language!!", "en"},
+	}
+	for _, tc := range cases {
+		if got := catalog.Negotiate(tc.header); got != tc.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestNew_DefaultLocale(t *testing.T) {
+	catalog, err := New(Options{DefaultLocale: "es"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := catalog.Negotiate("fr"); got != "es" {
+		t.Errorf("Negotiate(fr) = %q, want default es", got)
+	}
+	if got := catalog.Negotiate("en-US"); got != "en" {
+		t.Errorf("Negotiate(en-US) = %q, want en", got)
+	}
+
+	if _, err := New(Options{DefaultLocale: "de"}); err == nil {
+		t.Error("expected error for default locale without a catalog")
+	}
+	if _, err := New(Options{DefaultLocale: "???"}); err == nil {
+		t.Error("expected error for invalid default locale")
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	catalog, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if got := catalog.Translate("es", "Log in to %s", "Acme"); got != "Inicia sesión en Acme" {
+		t.Errorf("Translate(es) = %q", got)
+	}
+	if got := catalog.Translate("en", "Log in to %s", "Acme"); got != "Log in to Acme" {
+		t.Errorf("Translate(en) = %q", got)
+	}
+	if got := catalog.Translate("es", "Untranslated message"); got != "Untranslated message" {
+		t.Errorf("missing translation = %q, want source text", got)
+	}
+
+	var english *Catalog
+	if got := english.Translate("es", "Log In"); got != "Log In" {
+		t.Errorf("nil catalog Translate = %q, want source text", got)
+	}
+}
+
+func TestNew_DirAddsAndOverridesCatalogs(t *testing.T) {
+	dir := t.TempDir()
+	writeCatalog := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	writeCatalog("de.json", `{"Log In": "Anmelden"}`)
+	writeCatalog("es.json", `{"Log In": "Entrar"}`)
+
+	catalog, err := New(Options{Dir: dir, DefaultLocale: "de"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := catalog.Locales(); !slices.Equal(got, []string{"de", "en", "es"}) {
+		t.Errorf("Locales() = %v", got)
+	}
+	if got := catalog.Translate("de", "Log In"); got != "Anmelden" {
+		t.Errorf("Translate(de) = %q", got)
+	}
+	if got := catalog.Translate("es", "Log In"); got != "Entrar" {
+		t.Errorf("Translate(es) = %q, want override", got)
+	}
+	// built-in messages the override doesn't mention are kept
+	if got := catalog.Translate("es", "Handle"); got != "Usuario" {
+		t.Errorf("Translate(es, Handle) = %q, want built-in", got)
+	}
+
+	writeCatalog("xx-invalid-!.json", `{}`)
+	if _, err := New(Options{Dir: dir}); err == nil {
+		t.Error("expected error for invalid catalog file name")
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
 	"git.sr.ht/~jakintosh/consent/internal/api"
@@ -38,9 +40,35 @@ type apiCounters struct {
 	logoutCalls  atomic.Int32
 }
 
+// e2eService defines an integration the harness registers, with an app
+// server of its own. Zero lifetimes use the server defaults.
+type e2eService struct {
+	name            string
+	display         string
+	audience        string
+	accessLifetime  time.Duration
+	refreshLifetime time.Duration
+}
+
+var defaultE2EService = e2eService{
+	name:     testServiceName,
+	display:  testServiceNameUI,
+	audience: testAppAudience,
+}
+
+// e2eApp is the app server for one e2eService and the validator it checks
+// tokens with.
+type e2eApp struct {
+	server    *httptest.Server
+	validator tokens.Validator
+}
+
+// e2eHarness runs a consent server and an app per service. appServer and
+// validator belong to the first service.
 type e2eHarness struct {
 	consentServer *httptest.Server
 	appServer     *httptest.Server
+	apps          map[string]*e2eApp
 	db            *database.DB
 
 	signingKey *ecdsa.PrivateKey
@@ -207,7 +235,68 @@ func TestAuthFlow_E2E(t *testing.T) {
 	identityUserInfoResp.Body.Close()
 }
 
-func newE2EHarness(t *testing.T) *e2eHarness {
+func TestLoginAs_IssuesAppTokens(t *testing.T) {
+	h := newE2EHarness(t)
+	defer h.close()
+
+	first := h.loginAs(t, testServiceName, testUserHandle, testUserPassword)
+	if first.accessToken.Subject() == testUserHandle {
+		t.Fatalf("expected opaque sub, got handle %q", first.accessToken.Subject())
+	}
+
+	// the second login finds the integration already approved
+	second := h.loginAs(t, testServiceName, testUserHandle, testUserPassword)
+	if second.accessToken.Subject() != first.accessToken.Subject() {
+		t.Fatalf("second login sub = %q, want %q", second.accessToken.Subject(), first.accessToken.Subject())
+	}
+
+	protectedResp := getNoRedirectWithCookies(t, h.appServer.Client(), h.appServer.URL+"/protected", second.cookies()...)
+	defer protectedResp.Body.Close()
+	if protectedResp.StatusCode != http.StatusOK {
+		t.Fatalf("protected status = %d, want %d", protectedResp.StatusCode, http.StatusOK)
+	}
+	if body := readBody(t, protectedResp); body != second.accessToken.Subject() {
+		t.Fatalf("protected body = %q, want %q", body, second.accessToken.Subject())
+	}
+}
+
+func TestHarness_MultipleServices(t *testing.T) {
+	notes := e2eService{
+		name:           "notes-app",
+		display:        "Notes App",
+		audience:       "notes-app.local",
+		accessLifetime: 2 * time.Minute,
+	}
+	h := newE2EHarness(t, defaultE2EService, notes)
+	defer h.close()
+
+	example := h.loginAs(t, testServiceName, testUserHandle, testUserPassword)
+	notesSession := h.loginAs(t, notes.name, testUserHandle, testUserPassword)
+
+	if got := notesSession.accessToken.Audience(); !slices.Contains(got, notes.audience) || slices.Contains(got, testAppAudience) {
+		t.Fatalf("notes audience = %v, want %s only among apps", got, notes.audience)
+	}
+	lifetime := notesSession.accessToken.Expiration().Sub(notesSession.accessToken.IssuedAt())
+	if lifetime != notes.accessLifetime {
+		t.Fatalf("notes access lifetime = %v, want %v", lifetime, notes.accessLifetime)
+	}
+
+	notesApp := h.apps[notes.name].server
+	resp := getNoRedirectWithCookies(t, notesApp.Client(), notesApp.URL+"/protected", notesSession.cookies()...)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("notes app with notes tokens status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	resp = getNoRedirectWithCookies(t, notesApp.Client(), notesApp.URL+"/protected", example.cookies()...)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("notes app with example tokens status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+// newE2EHarness starts a harness serving services, or the example app alone
+// if none are given.
+func newE2EHarness(t *testing.T, services ...e2eService) *e2eHarness {
 	t.Helper()
 
 	dbOpts := database.Options{
@@ -246,7 +335,7 @@ func newE2EHarness(t *testing.T) *e2eHarness {
 		PublicURL:      h.consentServer.URL,
 		BootstrapToken: testBootstrapKey,
 	}
-	if err := service.Init(initOpts); err != nil {
+	if err := service.Init(t.Context(), initOpts); err != nil {
 		t.Fatalf("service.Init failed: %v", err)
 	}
 
@@ -299,21 +388,134 @@ func newE2EHarness(t *testing.T) *e2eHarness {
 	}
 	appHandler = appServer.Router()
 
-	if _, err := svc.CreateUser(testUserHandle, testUserPassword, nil); err != nil {
+	if _, err := svc.CreateUser(t.Context(), testUserHandle, testUserPassword, nil); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
-	if err := svc.CreateIntegration(testServiceName, testServiceNameUI, testAppAudience, h.appServerURL()+"/auth/callback"); err != nil {
-		t.Fatalf("CreateIntegration failed: %v", err)
+	if len(services) == 0 {
+		services = []e2eService{defaultE2EService}
+	}
+	h.apps = map[string]*e2eApp{}
+	for _, svcDef := range services {
+		h.apps[svcDef.name] = h.startApp(t, svc, svcDef)
 	}
+	first := h.apps[services[0].name]
+	h.appServer = first.server
+	h.validator = first.validator
 
-	clientOpts = tokens.ClientOptions{
-		VerificationKey: &signingKey.PublicKey,
-		IssuerDomain:    testIssuerDomain,
-		ValidAudience:   testAppAudience,
+	return h
+}
+
+// e2eSession holds the tokens the consent server issued one service for a
+// user, as that service's app would hold them after its callback.
+type e2eSession struct {
+	accessToken  *tokens.AccessToken
+	refreshToken *tokens.RefreshToken
+}
+
+// loginAs drives the browser side of the OAuth flow for handle: it logs in
+// to the consent app, approves serviceName for the identity scope if needed,
+// and exchanges the resulting auth code for that service's tokens.
+func (h *e2eHarness) loginAs(t *testing.T, serviceName string, handle string, password string) *e2eSession {
+	t.Helper()
+	consent := h.consentServer.Client()
+
+	loginBody := url.Values{
+		"handle":      []string{handle},
+		"secret":      []string{password},
+		"integration": []string{service.InternalIntegrationName},
+	}
+	loginResp := postFormNoRedirect(t, consent, h.consentServer.URL+"/api/v1/auth/login", loginBody)
+	loginResp.Body.Close()
+	if loginResp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("login status = %d, want %d", loginResp.StatusCode, http.StatusSeeOther)
+	}
+
+	callbackResp := getNoRedirectWithCookies(t, consent, loginResp.Header.Get("Location"))
+	callbackResp.Body.Close()
+	accessCookie := cookieByName(callbackResp.Cookies(), "accessToken")
+	refreshCookie := cookieByName(callbackResp.Cookies(), "refreshToken")
+	if accessCookie == nil || refreshCookie == nil {
+		t.Fatalf("consent callback should set accessToken and refreshToken cookies")
 	}
-	h.validator = tokens.InitClient(clientOpts)
 
-	authClient := consentclient.Init(h.validator, h.consentServer.URL)
+	authorizeURL := h.consentServer.URL + "/authorize?integration=" + url.QueryEscape(serviceName) + "&scope=identity&state=" + url.QueryEscape(testState)
+	authorizeResp := getNoRedirectWithCookies(t, consent, authorizeURL, accessCookie, refreshCookie)
+	authorizeResp.Body.Close()
+	redirect := authorizeResp.Header.Get("Location")
+	if authorizeResp.StatusCode == http.StatusOK {
+		// first authorization: approve on the user's behalf
+		consentValidator := tokens.InitClient(tokens.ClientOptions{
+			VerificationKey: &h.signingKey.PublicKey,
+			IssuerDomain:    testIssuerDomain,
+			ValidAudience:   mustURL(t, h.consentServer.URL).Host,
+		})
+		approveBody := url.Values{
+			"integration": []string{serviceName},
+			"scope":       []string{"identity"},
+			"state":       []string{testState},
+			"csrf":        []string{decodeRefreshCSRF(t, refreshCookie.Value, consentValidator)},
+			"action":      []string{"approve"},
+		}
+		approveResp := postFormWithCookiesNoRedirect(t, consent, h.consentServer.URL+"/authorize", approveBody, accessCookie, refreshCookie)
+		approveResp.Body.Close()
+		redirect = approveResp.Header.Get("Location")
+	}
+	code := mustURL(t, redirect).Query().Get("auth_code")
+	if code == "" {
+		t.Fatalf("authorize redirect = %q, want auth_code", redirect)
+	}
+
+	exchangeBody, err := json.Marshal(api.ExchangeRequest{Code: code})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	exchangeResp, err := consent.Post(h.consentServer.URL+"/api/v1/auth/exchange", "application/json", bytes.NewReader(exchangeBody))
+	if err != nil {
+		t.Fatalf("POST /api/v1/auth/exchange failed: %v", err)
+	}
+	defer exchangeResp.Body.Close()
+	if exchangeResp.StatusCode != http.StatusOK {
+		t.Fatalf("exchange status = %d, want %d: %s", exchangeResp.StatusCode, http.StatusOK, readBody(t, exchangeResp))
+	}
+	var pair api.RefreshResponse
+	decodeWireData(t, exchangeResp, &pair)
+
+	app, ok := h.apps[serviceName]
+	if !ok {
+		t.Fatalf("harness has no service %q", serviceName)
+	}
+	session := &e2eSession{
+		accessToken:  new(tokens.AccessToken),
+		refreshToken: new(tokens.RefreshToken),
+	}
+	if err := session.accessToken.Decode(pair.AccessToken, app.validator); err != nil {
+		t.Fatalf("AccessToken.Decode failed: %v", err)
+	}
+	if err := session.refreshToken.Decode(pair.RefreshToken, app.validator); err != nil {
+		t.Fatalf("RefreshToken.Decode failed: %v", err)
+	}
+	return session
+}
+
+// cookies returns the session as the app's auth cookies.
+func (session *e2eSession) cookies() []*http.Cookie {
+	return []*http.Cookie{
+		{Name: "accessToken", Value: session.accessToken.Encoded()},
+		{Name: "refreshToken", Value: session.refreshToken.Encoded()},
+	}
+}
+
+// startApp registers svcDef as an integration and serves an app for it that
+// protects /protected with its own consent client.
+func (h *e2eHarness) startApp(t *testing.T, svc *service.Service, svcDef e2eService) *e2eApp {
+	t.Helper()
+
+	validator := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &h.signingKey.PublicKey,
+		IssuerDomain:    testIssuerDomain,
+		ValidAudience:   svcDef.audience,
+	})
+	authClient := consentclient.Init(validator, h.consentServer.URL)
 	appMux := http.NewServeMux()
 	appMux.HandleFunc("/auth/callback", authClient.HandleAuthorizationCode())
 	appMux.HandleFunc("/logout", authClient.HandleLogout())
@@ -326,25 +528,27 @@ func newE2EHarness(t *testing.T) *e2eHarness {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(token.Subject()))
 	})
-	h.appServer = httptest.NewTLSServer(appMux)
+	server := httptest.NewTLSServer(appMux)
 
-	if err := svc.UpdateIntegration(testServiceName, &service.IntegrationUpdate{Redirect: stringPtr(h.appServer.URL + "/auth/callback")}); err != nil {
-		t.Fatalf("UpdateIntegration redirect failed: %v", err)
+	if err := svc.CreateIntegration(t.Context(), svcDef.name, svcDef.display, svcDef.audience, server.URL+"/auth/callback"); err != nil {
+		t.Fatalf("CreateIntegration %s failed: %v", svcDef.name, err)
 	}
-
-	return h
-}
-
-func (h *e2eHarness) appServerURL() string {
-	if h.appServer != nil {
-		return h.appServer.URL
+	if svcDef.accessLifetime != 0 || svcDef.refreshLifetime != 0 {
+		policy := &service.IntegrationPolicy{
+			AccessTokenLifetime:  svcDef.accessLifetime,
+			RefreshTokenLifetime: svcDef.refreshLifetime,
+		}
+		if err := svc.UpdateIntegration(t.Context(), svcDef.name, &service.IntegrationUpdate{Policy: policy}); err != nil {
+			t.Fatalf("UpdateIntegration %s policy failed: %v", svcDef.name, err)
+		}
 	}
-	return "https://placeholder.invalid"
+
+	return &e2eApp{server: server, validator: validator}
 }
 
 func (h *e2eHarness) close() {
-	if h.appServer != nil {
-		h.appServer.Close()
+	for _, app := range h.apps {
+		app.server.Close()
 	}
 	if h.consentServer != nil {
 		h.consentServer.Close()
@@ -466,7 +670,3 @@ func mustURL(t *testing.T, value string) *url.URL {
 	}
 	return parsed
 }
-
-func stringPtr(value string) *string {
-	return &value
-}
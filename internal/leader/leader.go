@@ -0,0 +1,197 @@
+// Package leader elects one of the replicas sharing a database to run
+// background work, such as deleting expired tokens. Each replica's Elector
+// keeps trying to take a named lease in the store. The holder renews it well
+// before it expires; when the holder stops, crashes, or loses the store, the
+// lease lapses and another replica takes it.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a lease lasts without renewal, for Options left
+// zero. Electors renew every third of it.
+const DefaultTTL = 30 * time.Second
+
+// releaseTimeout bounds giving up the lease on the way out, after the
+// elector's own context has ended.
+const releaseTimeout = 5 * time.Second
+
+// Lease is the store leases are kept in; *database.DB implements it.
+type Lease interface {
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (held bool, err error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// Options configures an Elector.
+type Options struct {
+	Lease Lease
+
+	// Name is the lease replicas compete for; Holder identifies this one.
+	// An empty Holder uses NewHolderID.
+	Name   string
+	Holder string
+
+	// TTL is how long the lease lasts without renewal. Zero uses
+	// DefaultTTL.
+	TTL time.Duration
+
+	// Now reads the clock. Nil uses time.Now.
+	Now func() time.Time
+}
+
+// Status is an elector's view after its latest attempt at the lease.
+// Err is that attempt's error, if any.
+type Status struct {
+	Leader    bool
+	Holder    string
+	CheckedAt time.Time
+	Err       error
+}
+
+// Elector holds or waits for one lease on behalf of this replica.
+type Elector struct {
+	lease  Lease
+	name   string
+	holder string
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	status  Status
+	expires time.Time
+}
+
+// New returns an elector for options. It does nothing until Run.
+func New(
+	options Options,
+) *Elector {
+	holder := options.Holder
+	if holder == "" {
+		holder = NewHolderID()
+	}
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	now := options.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &Elector{
+		lease:  options.Lease,
+		name:   options.Name,
+		holder: holder,
+		ttl:    ttl,
+		now:    now,
+		status: Status{Holder: holder},
+	}
+}
+
+// NewHolderID names this process: its hostname, which is the pod name under
+// Kubernetes, and a random suffix to tell restarts apart.
+func NewHolderID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "consent"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Run competes for the lease until ctx ends, then releases it if held.
+func (e *Elector) Run(
+	ctx context.Context,
+) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.Check(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check makes one attempt to take or renew the lease and returns the
+// result. A replica that can't reach the store stays leader only until the
+// lease it last renewed runs out, as other replicas can take it then.
+func (e *Elector) Check(
+	ctx context.Context,
+) Status {
+	now := e.now()
+	held, err := e.lease.AcquireLease(ctx, e.name, e.holder, now, e.ttl)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wasLeader := e.status.Leader
+	switch {
+	case err != nil:
+		err = fmt.Errorf("leader: %w", err)
+		e.status.Leader = wasLeader && now.Before(e.expires)
+	case held:
+		e.status.Leader = true
+		e.expires = now.Add(e.ttl)
+	default:
+		e.status.Leader = false
+	}
+	e.status.CheckedAt = now
+	e.status.Err = err
+
+	switch {
+	case e.status.Leader && !wasLeader:
+		log.Printf("leader: %s took lease %q", e.holder, e.name)
+	case !e.status.Leader && wasLeader && err != nil:
+		log.Printf("leader: %s lost lease %q: %v", e.holder, e.name, err)
+	case !e.status.Leader && wasLeader:
+		log.Printf("leader: %s lost lease %q to another replica", e.holder, e.name)
+	}
+	return e.status
+}
+
+// Status returns the result of the latest attempt at the lease.
+func (e *Elector) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// IsLeader reports whether this replica holds the lease.
+func (e *Elector) IsLeader() bool {
+	return e.Status().Leader
+}
+
+// Stalled reports whether Run has stopped attempting the lease, going a
+// whole TTL without a check, as when the process is wedged.
+func (e *Elector) Stalled() bool {
+	checkedAt := e.Status().CheckedAt
+	return !checkedAt.IsZero() && e.now().Sub(checkedAt) > e.ttl
+}
+
+func (e *Elector) release() {
+	e.mu.Lock()
+	leader := e.status.Leader
+	e.status.Leader = false
+	e.mu.Unlock()
+	if !leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := e.lease.ReleaseLease(ctx, e.name, e.holder); err != nil {
+		log.Printf("leader: %s failed to release lease %q: %v", e.holder, e.name, err)
+	}
+}
@@ -0,0 +1,120 @@
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/leader"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newElector(
+	lease leader.Lease,
+	holder string,
+	c *clock,
+) *leader.Elector {
+	return leader.New(leader.Options{
+		Lease:  lease,
+		Name:   "janitor",
+		Holder: holder,
+		TTL:    30 * time.Second,
+		Now:    c.Now,
+	})
+}
+
+func TestElector_FailsOver(t *testing.T) {
+	t.Parallel()
+	db := testutil.SetupTestDB(t)
+	c := &clock{now: time.Now()}
+	a, b := newElector(db, "a", c), newElector(db, "b", c)
+
+	if !a.Check(t.Context()).Leader {
+		t.Fatal("a did not take the free lease")
+	}
+	if b.Check(t.Context()).Leader {
+		t.Fatal("b became leader while a holds the lease")
+	}
+
+	// a stops renewing, so b takes over once the lease lapses
+	c.now = c.now.Add(31 * time.Second)
+	if !b.Check(t.Context()).Leader {
+		t.Fatal("b did not take the lapsed lease")
+	}
+	if status := a.Check(t.Context()); status.Leader || status.Err != nil {
+		t.Fatalf("a status = %+v, want a follower without error", status)
+	}
+}
+
+func TestElector_RunReleasesLease(t *testing.T) {
+	t.Parallel()
+	db := testutil.SetupTestDB(t)
+	c := &clock{now: time.Now()}
+	a, b := newElector(db, "a", c), newElector(db, "b", c)
+
+	// a takes the lease once and gives it up as Run returns
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	a.Run(ctx)
+	if a.IsLeader() {
+		t.Fatal("a is still leader after Run returned")
+	}
+	if !b.Check(t.Context()).Leader {
+		t.Fatal("b did not take the released lease")
+	}
+}
+
+// flakyLease fails every attempt once broken is set.
+type flakyLease struct {
+	leader.Lease
+	broken bool
+}
+
+func (f *flakyLease) AcquireLease(
+	ctx context.Context,
+	name string,
+	holder string,
+	now time.Time,
+	ttl time.Duration,
+) (
+	bool,
+	error,
+) {
+	if f.broken {
+		return false, errors.New("database is locked")
+	}
+	return f.Lease.AcquireLease(ctx, name, holder, now, ttl)
+}
+
+func TestElector_KeepsLeadUntilLeaseLapses(t *testing.T) {
+	t.Parallel()
+	lease := &flakyLease{Lease: testutil.SetupTestDB(t)}
+	c := &clock{now: time.Now()}
+	a := newElector(lease, "a", c)
+	if !a.Check(t.Context()).Leader {
+		t.Fatal("a did not take the free lease")
+	}
+
+	// while the store is unreachable, a leads only as long as its lease runs
+	lease.broken = true
+	c.now = c.now.Add(20 * time.Second)
+	if status := a.Check(t.Context()); !status.Leader || status.Err == nil {
+		t.Fatalf("status = %+v, want leader with the store's error", status)
+	}
+	c.now = c.now.Add(20 * time.Second)
+	if a.Check(t.Context()).Leader {
+		t.Fatal("a is still leader after its lease lapsed")
+	}
+	if a.Stalled() {
+		t.Fatal("a reports stalled right after a check")
+	}
+	c.now = c.now.Add(time.Minute)
+	if !a.Stalled() {
+		t.Fatal("a does not report stalled after a TTL without checks")
+	}
+}
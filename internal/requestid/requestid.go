@@ -0,0 +1,81 @@
+// Package requestid tags each HTTP request with an ID, so one request can be
+// followed through access logs, audit events, and error responses, and
+// across services that pass the ID along in the X-Request-ID header.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// Header carries request IDs between services and back to clients.
+const Header = "X-Request-ID"
+
+// maxLength bounds inbound IDs, which end up in every log line for the
+// request.
+const maxLength = 128
+
+type contextKey struct{}
+
+// Handler gives every request served by next an ID, available from
+// FromContext and echoed in the response's X-Request-ID header. An inbound
+// X-Request-ID is kept if it is a plausible ID, so a caller's ID carries
+// through; anything else is replaced with a new one.
+func Handler(
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+	})
+}
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id is safe to adopt from another service: 1 to 128
+// letters, digits, or the punctuation -_.:/+= common in trace IDs. Anything
+// else could forge log lines or bloat them.
+func Valid(
+	id string,
+) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithID returns ctx carrying request ID id.
+func WithID(
+	ctx context.Context,
+	id string,
+) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID of the request ctx belongs to, or empty outside
+// a request.
+func FromContext(
+	ctx context.Context,
+) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,66 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+)
+
+func serve(
+	t *testing.T,
+	inbound string,
+) (
+	string,
+	string,
+) {
+	t.Helper()
+	var seen string
+	handler := requestid.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestid.FromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if inbound != "" {
+		req.Header.Set(requestid.Header, inbound)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return seen, rec.Header().Get(requestid.Header)
+}
+
+func TestHandler_AssignsID(t *testing.T) {
+	t.Parallel()
+
+	seen, echoed := serve(t, "")
+	if seen == "" || seen != echoed {
+		t.Fatalf("context ID = %q, header = %q, want the same new ID", seen, echoed)
+	}
+	if other, _ := serve(t, ""); other == seen {
+		t.Error("expected a different ID per request")
+	}
+}
+
+func TestHandler_HonorsInboundID(t *testing.T) {
+	t.Parallel()
+
+	seen, echoed := serve(t, "gateway-7f3a:span/2")
+	if seen != "gateway-7f3a:span/2" || echoed != seen {
+		t.Fatalf("context ID = %q, header = %q, want the inbound ID", seen, echoed)
+	}
+}
+
+func TestHandler_ReplacesUnsafeIDs(t *testing.T) {
+	t.Parallel()
+
+	for _, inbound := range []string{
+		"forged\nlog line",
+		"has space",
+		strings.Repeat("a", 129),
+	} {
+		if seen, _ := serve(t, inbound); seen == inbound || !requestid.Valid(seen) {
+			t.Errorf("inbound %q: got ID %q, want a fresh one", inbound, seen)
+		}
+	}
+}
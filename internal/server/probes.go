@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+)
+
+// probeTimeout bounds the store check each /readyz request makes.
+const probeTimeout = 2 * time.Second
+
+// probeStatus reports one store to /readyz: whether it answers, and whether
+// this replica holds its janitor lease.
+type probeStatus struct {
+	Realm  string `json:"realm,omitempty"`
+	Store  string `json:"store"`
+	Leader bool   `json:"leader"`
+	Holder string `json:"holder"`
+}
+
+// probes answers the liveness and readiness probes ahead of next, without
+// request IDs or access logging, since orchestrators call them every few
+// seconds. /livez fails only when the process is wedged, so a restart would
+// help; /readyz fails while any store of the server or its realms can't be
+// reached, so traffic goes to other replicas until it recovers. Leadership
+// is reported but doesn't affect either: every replica serves requests.
+func (s *Server) probes(
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/livez":
+			s.handleLivez(w)
+		case "/readyz":
+			s.handleReadyz(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (s *Server) handleLivez(
+	w http.ResponseWriter,
+) {
+	for _, srv := range s.all() {
+		if srv.elector.Stalled() {
+			wire.WriteError(w, http.StatusServiceUnavailable, "leader election stalled")
+			return
+		}
+	}
+	wire.WriteData(w, http.StatusOK, "ok")
+}
+
+func (s *Server) handleReadyz(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	status := http.StatusOK
+	statuses := make([]probeStatus, 0, len(s.realms)+1)
+	for _, srv := range s.all() {
+		election := srv.elector.Status()
+		probe := probeStatus{
+			Realm:  srv.name,
+			Store:  "ok",
+			Leader: election.Leader,
+			Holder: election.Holder,
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		err := srv.db.Ping(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("readyz: realm %q: %v", srv.name, err)
+			probe.Store = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		statuses = append(statuses, probe)
+	}
+	wire.WriteData(w, status, statuses)
+}
+
+// all returns the server followed by its realms.
+func (s *Server) all() []*Server {
+	return append([]*Server{s}, s.realms...)
+}
@@ -1,70 +1,312 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
 	"git.sr.ht/~jakintosh/consent/internal/api"
 	"git.sr.ht/~jakintosh/consent/internal/app"
 	"git.sr.ht/~jakintosh/consent/internal/config"
 	"git.sr.ht/~jakintosh/consent/internal/database"
+	"git.sr.ht/~jakintosh/consent/internal/leader"
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
 	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/webhook"
 	"git.sr.ht/~jakintosh/consent/pkg/client"
 	"git.sr.ht/~jakintosh/consent/pkg/testing"
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens/vault"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Limits for the public HTTP server. Slow or oversized requests are cut off
+// rather than holding connections open, and shutdown waits this long for
+// in-flight requests to finish.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+	maxHeaderBytes    = 1 << 20
+	shutdownTimeout   = 30 * time.Second
 )
 
 type Options struct {
 	Runtime         config.Runtime
 	InsecureCookies bool
 	PasswordMode    service.PasswordMode
+
+	// AccessLog receives request log lines in the runtime's access log
+	// format. Nil writes to stderr.
+	AccessLog io.Writer
+
+	// InitializeStore seeds the database on startup using the runtime's
+	// public URL and bootstrap API key.
+	InitializeStore bool
+
+	// Seed, when set, fills the store through the assembled service before
+	// the server takes requests, as `consent serve --dev` does with its
+	// test user and integration.
+	Seed func(context.Context, *service.Service) error
+
+	// RefreshPolicy and OnRefreshAnomaly judge and audit refreshes; nil uses
+	// the service defaults, which flag anomalies to the log.
+	RefreshPolicy    service.RefreshPolicy
+	OnRefreshAnomaly func(context.Context, service.RefreshAnomaly)
+
+	// OnImpersonation audits impersonation tokens; nil logs them.
+	OnImpersonation func(context.Context, service.Impersonation)
+
+	// OnEvent receives identity events alongside the runtime's webhooks.
+	// It must not block.
+	OnEvent func(context.Context, service.Event)
+
+	// Mailer sends users notices such as sign-ins from new devices; nil
+	// sends none.
+	Mailer service.Mailer
 }
 
-func Serve(
+// janitorLease is the lease replicas sharing a database compete for; its
+// holder runs the background janitor.
+const janitorLease = "janitor"
+
+// Server is an assembled consent server: storage, service, API, and web app
+// behind one handler. A server with realms also holds a server for each
+// realm, and its handler routes requests to them by host.
+type Server struct {
+	name     string
+	host     string
+	db       *database.DB
+	handler  http.Handler
+	app      *app.App
+	service  *service.Service
+	elector  *leader.Elector
+	webhooks *webhook.Dispatcher
+	realms   []*Server
+}
+
+// New opens the database and assembles the consent server, along with a
+// server for each of the runtime's realms. With InitializeStore, it first
+// seeds system integrations, roles, and the bootstrap API key, as
+// `consent init` does; this is safe to repeat.
+func New(
 	options Options,
-) error {
-	if options.Runtime.Secrets.SigningKey == nil {
-		return fmt.Errorf("failed to initialize service: signing key required")
+) (
+	*Server,
+	error,
+) {
+	if options.Runtime.Secrets.SigningKey == nil && options.Runtime.Secrets.Signer == nil {
+		return nil, fmt.Errorf("failed to initialize service: signing key required")
+	}
+	if _, err := options.Runtime.Secrets.TokenServerOptions().PublicKey(); err != nil {
+		return nil, fmt.Errorf("failed to initialize service: %w", err)
 	}
 
 	// build database
 	dbOpts := database.Options{
-		Path: options.Runtime.Paths.DatabaseFile,
+		Path:         options.Runtime.Paths.DatabaseFile,
+		WAL:          true,
+		BusyTimeout:  options.Runtime.Config.Storage.BusyTimeout,
+		MaxOpenConns: options.Runtime.Config.Storage.MaxOpenConns,
+		QueryTimeout: options.Runtime.Config.Storage.QueryTimeout,
 	}
 	db, err := database.Open(dbOpts)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// realms get the caller's hooks, but not this server's webhooks
+	realmOptions := options
+
+	// webhooks deliver in the background until the server is closed
+	var webhooks *webhook.Dispatcher
+	if len(options.Runtime.Webhooks) > 0 {
+		webhooks = webhook.New(webhook.Options{Endpoints: buildWebhookEndpoints(options)})
+		onEvent := options.OnEvent
+		options.OnEvent = func(ctx context.Context, event service.Event) {
+			webhooks.Notify(ctx, event)
+			if onEvent != nil {
+				onEvent(ctx, event)
+			}
+		}
+	}
+
+	handler, appServer, svc, err := buildHandler(db, options)
+	if err != nil {
+		if webhooks != nil {
+			webhooks.Close()
+		}
+		_ = db.Close()
+		return nil, err
+	}
+
+	srv := &Server{
+		host:     hostname(options.Runtime.Server.PublicHost),
+		db:       db,
+		handler:  handler,
+		app:      appServer,
+		service:  svc,
+		elector:  leader.New(leader.Options{Lease: db, Name: janitorLease}),
+		webhooks: webhooks,
+	}
+	if len(options.Runtime.Realms) == 0 {
+		srv.handler = srv.probes(handler)
+		return srv, nil
+	}
+
+	// each realm is a server of its own, sharing only the process
+	for _, realm := range options.Runtime.Realms {
+		realmOptions.Runtime = realm.Runtime
+		realmServer, err := New(realmOptions)
+		if err != nil {
+			_ = srv.Close()
+			return nil, fmt.Errorf("realm %q: %w", realm.Name, err)
+		}
+		realmServer.name = realm.Name
+		srv.realms = append(srv.realms, realmServer)
+	}
+	srv.handler = srv.probes(routeRealms(handler, srv.realms))
+	return srv, nil
+}
+
+// Handler returns the server's routes: the web app at /, the API under
+// /api/v1, and the /livez and /readyz probes.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Reload re-reads the page template overrides and locale catalogs of the
+// server and its realms. Pages that fail to parse are reported, while
+// everything else that loaded takes effect.
+func (s *Server) Reload() error {
+	errs := []error{s.app.Reload()}
+	for _, realm := range s.realms {
+		if err := realm.Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("realm %q: %w", realm.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops webhook delivery and closes the database of the server and
+// its realms. Stop serving requests first.
+func (s *Server) Close() error {
+	var errs []error
+	for _, realm := range s.realms {
+		errs = append(errs, realm.Close())
+	}
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
+	errs = append(errs, s.db.Close())
+	return errors.Join(errs...)
+}
+
+// routeRealms sends each request to the realm whose public host it names,
+// and any other request to primary.
+func routeRealms(
+	primary http.Handler,
+	realms []*Server,
+) http.Handler {
+	byHost := make(map[string]http.Handler, len(realms))
+	for _, realm := range realms {
+		byHost[realm.host] = realm.handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := byHost[hostname(r.Host)]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		primary.ServeHTTP(w, r)
+	})
+}
+
+// hostname strips any port from host and lowercases it.
+func hostname(
+	host string,
+) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+func buildHandler(
+	db *database.DB,
+	options Options,
+) (
+	http.Handler,
+	*app.App,
+	*service.Service,
+	error,
+) {
+	if options.InitializeStore {
+		initOpts := service.InitOptions{
+			Store:          db,
+			KeysStore:      db.KeysStore,
+			PublicURL:      options.Runtime.Server.PublicURL,
+			BootstrapToken: options.Runtime.Secrets.BootstrapAPIKey,
+		}
+		if err := service.Init(context.Background(), initOpts); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to initialize store: %w", err)
+		}
 	}
-	defer db.Close()
 
 	// build service
 	svcOpts := service.Options{
-		PasswordMode: options.PasswordMode,
-		Store:        db,
+		PasswordMode:    options.PasswordMode,
+		PasswordCost:    options.Runtime.Config.Passwords.Cost,
+		PasswordWorkers: options.Runtime.Config.Passwords.Workers,
+		Store:           db,
+		PublicURL:       options.Runtime.Server.PublicBaseURL,
 		TokenServerOpts: tokens.ServerOptions{
 			SigningKey:   options.Runtime.Secrets.SigningKey,
+			Signer:       options.Runtime.Secrets.Signer,
 			IssuerDomain: options.Runtime.Server.AuthorityDomain,
+			SignWorkers:  options.Runtime.Config.Signing.Workers,
+			SignQueue:    options.Runtime.Config.Signing.Queue,
 		},
 		ResourceTokenClientOpts: tokens.ClientOptions{
-			VerificationKey: &options.Runtime.Secrets.SigningKey.PublicKey,
+			VerificationKey: options.Runtime.Secrets.VerificationKey(),
 			IssuerDomain:    options.Runtime.Server.AuthorityDomain,
 			ValidAudience:   options.Runtime.Server.AuthorityDomain,
 		},
+		Upstreams:            buildUpstreamProviders(options),
+		AuthCodeLifetime:     options.Runtime.Server.AuthCodeLifetime,
+		AccessTokenLifetime:  options.Runtime.Server.AccessTokenLifetime,
+		RefreshTokenLifetime: options.Runtime.Server.RefreshTokenLifetime,
+		Maintenance:          options.Runtime.Server.Maintenance,
+		RefreshPolicy:        options.RefreshPolicy,
+		OnRefreshAnomaly:     options.OnRefreshAnomaly,
+		OnImpersonation:      options.OnImpersonation,
+		OnEvent:              options.OnEvent,
+		Mailer:               options.Mailer,
+		Terms: service.Terms{
+			Version: options.Runtime.Config.Terms.Version,
+			URL:     options.Runtime.Config.Terms.URL,
+		},
 	}
 	svc, err := service.New(svcOpts)
 	if err != nil {
-		return fmt.Errorf("failed to initialize service: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to initialize service: %w", err)
 	}
-
-	// build api
-	apiOpts := api.Options{
-		Service:   svc,
-		KeysStore: db.KeysStore,
-	}
-	apiServer, err := api.New(apiOpts)
-	if err != nil {
-		return fmt.Errorf("failed to initialize api server: %w", err)
+	if options.Seed != nil {
+		if err := options.Seed(context.Background(), svc); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to seed store: %w", err)
+		}
 	}
 
 	// build app
@@ -75,12 +317,36 @@ func Serve(
 		authConfig = buildProdAuthConfig(options)
 	}
 	appOpts := app.Options{
-		Service: svc,
-		Auth:    authConfig,
+		Service:         svc,
+		Auth:            authConfig,
+		InsecureCookies: options.InsecureCookies,
+		TemplatesPath:   options.Runtime.Server.TemplatesPath,
+		StaticTemplates: options.Runtime.Server.StaticTemplates,
+		Branding: app.Branding{
+			Name:         options.Runtime.Server.Branding.Name,
+			Organization: options.Runtime.Server.Branding.Organization,
+		},
+		Locale:      options.Runtime.Server.Locale,
+		LocalesPath: options.Runtime.Server.LocalesPath,
 	}
 	appServer, err := app.New(appOpts)
 	if err != nil {
-		return fmt.Errorf("failed to initialize app server: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to initialize app server: %w", err)
+	}
+
+	// build api
+	apiOpts := api.Options{
+		Service:   svc,
+		KeysStore: db.KeysStore,
+		CORS: api.CORSOptions{
+			AllowedOrigins:     options.Runtime.Server.CORS.AllowedOrigins,
+			IntegrationOrigins: options.Runtime.Server.CORS.IntegrationOrigins,
+		},
+		Reload: appServer.Reload,
+	}
+	apiServer, err := api.New(apiOpts)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to initialize api server: %w", err)
 	}
 
 	// build router
@@ -88,15 +354,225 @@ func Serve(
 	wire.Subrouter(mux, "/", appServer.Router())
 	wire.Subrouter(mux, "/api/v1", apiServer.Router())
 
-	//serve
-	return http.ListenAndServe(options.Runtime.Server.ListenAddress, mux)
+	accessLog := options.AccessLog
+	if accessLog == nil {
+		accessLog = os.Stderr
+	}
+	handler := accesslog.Handler(accesslog.Routes(mux), accessLog, options.Runtime.Server.AccessLog)
+	return requestid.Handler(handler), appServer, svc, nil
+}
+
+// Serve runs the consent server until ctx is cancelled, then stops accepting
+// connections, drains in-flight requests, and closes the database. Meanwhile,
+// the server and each realm compete with other replicas sharing their
+// database for its janitor lease, and the holder deletes expired tokens.
+func Serve(
+	ctx context.Context,
+	options Options,
+) error {
+	srv, err := New(options)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	// background work stops, releasing leases, before the database closes
+	ctx, cancel := context.WithCancel(ctx)
+	var background sync.WaitGroup
+	defer background.Wait()
+	defer cancel()
+	for _, s := range srv.all() {
+		background.Add(2)
+		go func() {
+			defer background.Done()
+			s.elector.Run(ctx)
+		}()
+		go func() {
+			defer background.Done()
+			runJanitor(ctx, s.elector, s.service, janitorInterval)
+		}()
+	}
+
+	// SIGHUP reloads templates and locales without a restart
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				if err := srv.Reload(); err != nil {
+					log.Printf("reload: %v", err)
+				} else {
+					log.Printf("reload: templates and locales reloaded")
+				}
+			}
+		}
+	}()
+
+	if signer, ok := options.Runtime.Secrets.Signer.(*vault.TransitSigner); ok {
+		go monitorSigner(ctx, signer, signerCheckInterval)
+	}
+
+	// serve
+	httpServer := newHTTPServer(options.Runtime.Server.ListenAddress, srv.Handler())
+	tlsOpts := options.Runtime.Server.TLS
+	if !tlsOpts.Enabled {
+		return run(ctx, listener{httpServer, httpServer.ListenAndServe})
+	}
+
+	// plain HTTP only redirects to HTTPS (and answers ACME challenges)
+	realmHosts := make(map[string]string, len(options.Runtime.Realms))
+	autocertHosts := []string{tlsOpts.AutocertHost}
+	for _, realm := range options.Runtime.Realms {
+		realmHosts[hostname(realm.Server.PublicHost)] = realm.Server.PublicHost
+		autocertHosts = append(autocertHosts, hostname(realm.Server.PublicHost))
+	}
+	redirect := redirectToHTTPS(options.Runtime.Server.PublicHost, realmHosts)
+	certFile, keyFile := tlsOpts.CertFile, tlsOpts.KeyFile
+	if tlsOpts.Autocert {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(autocertHosts...),
+			Cache:      autocert.DirCache(tlsOpts.AutocertDir),
+			Email:      tlsOpts.AutocertEmail,
+		}
+		httpServer.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+		certFile, keyFile = "", ""
+	}
+	redirectServer := newHTTPServer(tlsOpts.RedirectAddress, redirect)
+
+	return run(ctx,
+		listener{httpServer, func() error { return httpServer.ListenAndServeTLS(certFile, keyFile) }},
+		listener{redirectServer, redirectServer.ListenAndServe},
+	)
+}
+
+func newHTTPServer(
+	address string,
+	handler http.Handler,
+) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// redirectToHTTPS sends every request to the same path on the public host
+// over HTTPS, or on a realm's public host when the request names one. Any
+// other Host header is ignored so the redirect can't be pointed elsewhere.
+func redirectToHTTPS(
+	publicHost string,
+	realmHosts map[string]string,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := publicHost
+		if realmHost, ok := realmHosts[hostname(r.Host)]; ok {
+			host = realmHost
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+type listener struct {
+	server *http.Server
+	serve  func() error
+}
+
+// run serves every listener until ctx is cancelled or one of them fails, then
+// shuts them all down, draining in-flight requests.
+func run(
+	ctx context.Context,
+	listeners ...listener,
+) error {
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			serveErr <- l.serve()
+		}()
+	}
+
+	var runErr error
+	select {
+	case err := <-serveErr:
+		runErr = fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
+	}
+
+	// drain in-flight requests before the database is closed
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, l := range listeners {
+		if err := l.server.Shutdown(shutdownCtx); err != nil && runErr == nil {
+			runErr = fmt.Errorf("failed to shut down server: %w", err)
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	for range listeners {
+		if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server error: %w", err)
+		}
+	}
+	return nil
+}
+
+func buildUpstreamProviders(
+	options Options,
+) []service.UpstreamProvider {
+	upstreams := make([]service.UpstreamProvider, 0, len(options.Runtime.Upstreams))
+	for _, upstream := range options.Runtime.Upstreams {
+		upstreams = append(upstreams, service.UpstreamProvider{
+			Name:         upstream.Name,
+			Display:      upstream.Display,
+			AuthorizeURL: upstream.AuthorizeURL,
+			TokenURL:     upstream.TokenURL,
+			UserInfoURL:  upstream.UserInfoURL,
+			ClientID:     upstream.ClientID,
+			ClientSecret: upstream.ClientSecret,
+			RedirectURL:  upstream.RedirectURL,
+			Scopes:       upstream.Scopes,
+			SubjectClaim: upstream.SubjectClaim,
+			HandleClaim:  upstream.HandleClaim,
+		})
+	}
+	return upstreams
+}
+
+func buildWebhookEndpoints(
+	options Options,
+) []webhook.Endpoint {
+	endpoints := make([]webhook.Endpoint, 0, len(options.Runtime.Webhooks))
+	for _, hook := range options.Runtime.Webhooks {
+		events := make([]service.EventType, 0, len(hook.Events))
+		for _, event := range hook.Events {
+			events = append(events, service.EventType(event))
+		}
+		endpoints = append(endpoints, webhook.Endpoint{
+			Name:   hook.Name,
+			URL:    hook.URL,
+			Secret: hook.Secret,
+			Events: events,
+		})
+	}
+	return endpoints
 }
 
 func buildProdAuthConfig(
 	options Options,
 ) app.AuthConfig {
 	prodClientOpts := tokens.ClientOptions{
-		VerificationKey: &options.Runtime.Secrets.SigningKey.PublicKey,
+		VerificationKey: options.Runtime.Secrets.VerificationKey(),
 		IssuerDomain:    options.Runtime.Server.AuthorityDomain,
 		ValidAudience:   options.Runtime.Server.PublicHost,
 	}
@@ -133,3 +609,91 @@ func buildDevAuthConfig(
 		},
 	}
 }
+
+// signerCheckInterval is how often Serve checks that a Vault transit signer
+// can still sign.
+const signerCheckInterval = time.Minute
+
+// monitorSigner checks signer every interval until ctx ends, logging when
+// Vault stops being able to sign and when it recovers, along with signing
+// latency so far.
+func monitorSigner(
+	ctx context.Context,
+	signer *vault.TransitSigner,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := signer.Check(ctx)
+		stats := signer.Stats()
+		switch {
+		case err != nil && healthy:
+			log.Printf("signer: vault can't sign: %v (%d signs, %d failed, mean %s, max %s)",
+				err, stats.Signs, stats.Failures, stats.MeanLatency(), stats.MaxLatency)
+		case err == nil && !healthy:
+			log.Printf("signer: vault recovered (%d signs, %d failed, mean %s, max %s)",
+				stats.Signs, stats.Failures, stats.MeanLatency(), stats.MaxLatency)
+		}
+		healthy = err == nil
+	}
+}
+
+// janitorInterval is how often the janitor deletes expired tokens.
+const janitorInterval = 10 * time.Minute
+
+// runJanitor deletes expired refresh tokens, authorization codes, and device
+// authorizations, and signs out accounts that have expired since the last
+// run, every interval until ctx ends, while elector holds the lease, so only
+// one replica sharing the database does it.
+func runJanitor(
+	ctx context.Context,
+	elector *leader.Elector,
+	svc *service.Service,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// accounts that expired before since have been signed out; it starts at
+	// zero so a new leader sweeps every expired account once
+	var since time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !elector.IsLeader() {
+			since = time.Time{}
+			continue
+		}
+		deleted, err := svc.PruneExpiredTokens(ctx)
+		switch {
+		case err != nil:
+			log.Printf("janitor: %v", err)
+		case deleted > 0:
+			log.Printf("janitor: deleted %d expired tokens", deleted)
+		}
+
+		now := time.Now()
+		revoked, err := svc.RevokeExpiredAccounts(ctx, since, now)
+		switch {
+		case err != nil:
+			log.Printf("janitor: %v", err)
+			continue
+		case revoked > 0:
+			log.Printf("janitor: signed out %d expired accounts", revoked)
+		}
+		since = now
+	}
+}
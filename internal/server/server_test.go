@@ -0,0 +1,377 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/keys"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+	"git.sr.ht/~jakintosh/consent/internal/server"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func testOptions(
+	t *testing.T,
+	publicURL string,
+	address string,
+) server.Options {
+	t.Helper()
+	return server.Options{
+		Runtime: config.Runtime{
+			Paths: config.Paths{
+				DatabaseFile: filepath.Join(t.TempDir(), "consent.sqlite"),
+			},
+			Server: config.RuntimeServer{
+				PublicBaseURL:   publicURL,
+				PublicHost:      address,
+				AuthorityDomain: "consent.test",
+				ListenAddress:   address,
+			},
+			Secrets: config.RuntimeSecrets{
+				SigningKey: consenttesting.SharedTestKey(),
+			},
+		},
+		PasswordMode: service.PasswordModeTesting,
+	}
+}
+
+// startServer runs Serve until the test ends and waits until url answers.
+func startServer(
+	t *testing.T,
+	options server.Options,
+	client *http.Client,
+	url string,
+) (
+	context.CancelFunc,
+	<-chan error,
+) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, options)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := client.Get(url)
+		if err == nil {
+			res.Body.Close()
+			return cancel, done
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server never started: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectShutdown(
+	t *testing.T,
+	cancel context.CancelFunc,
+	done <-chan error,
+) {
+	t.Helper()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve returned %v, want nil after shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after context was cancelled")
+	}
+}
+
+func TestServe_ShutsDownWhenContextCancelled(t *testing.T) {
+	address := freeAddress(t)
+	options := testOptions(t, "http://"+address, address)
+
+	cancel, done := startServer(t, options, http.DefaultClient, "http://"+address+"/")
+	expectShutdown(t, cancel, done)
+}
+
+func TestServe_TLSWithRedirect(t *testing.T) {
+	address := freeAddress(t)
+	redirectAddress := freeAddress(t)
+	certFile, keyFile := writeTestCertificate(t)
+	options := testOptions(t, "https://"+address, address)
+	options.Runtime.Server.TLS = config.RuntimeTLS{
+		Enabled:         true,
+		CertFile:        certFile,
+		KeyFile:         keyFile,
+		RedirectAddress: redirectAddress,
+	}
+
+	httpsClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	cancel, done := startServer(t, options, httpsClient, "https://"+address+"/")
+
+	// plain HTTP redirects to the public host over HTTPS
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+redirectAddress+"/login?x=1", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Host = "attacker.test"
+	res, err := noRedirect.Do(req)
+	if err != nil {
+		t.Fatalf("GET over HTTP failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusPermanentRedirect)
+	}
+	if location := res.Header.Get("Location"); location != "https://"+address+"/login?x=1" {
+		t.Errorf("Location = %q, want https://%s/login?x=1", location, address)
+	}
+
+	expectShutdown(t, cancel, done)
+}
+
+func TestServe_ProbesReportLeadership(t *testing.T) {
+	address := freeAddress(t)
+	options := testOptions(t, "http://"+address, address)
+	cancel, done := startServer(t, options, http.DefaultClient, "http://"+address+"/livez")
+
+	// the only replica takes the janitor lease right away
+	var readiness struct {
+		Data []struct {
+			Store  string `json:"store"`
+			Leader bool   `json:"leader"`
+		} `json:"data"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := http.Get("http://" + address + "/readyz")
+		if err != nil {
+			t.Fatalf("GET /readyz failed: %v", err)
+		}
+		err = json.NewDecoder(res.Body).Decode(&readiness)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode /readyz: %v", err)
+		}
+		if res.StatusCode != http.StatusOK || len(readiness.Data) != 1 || readiness.Data[0].Store != "ok" {
+			t.Fatalf("GET /readyz: status %d, body %+v", res.StatusCode, readiness)
+		}
+		if readiness.Data[0].Leader {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server never became leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expectShutdown(t, cancel, done)
+}
+
+func TestNew_ReadyzFailsWithoutStore(t *testing.T) {
+	t.Parallel()
+	srv, err := server.New(testOptions(t, "http://consent.test", "consent.test"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	probe := func(path string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if status := probe("/readyz"); status != http.StatusOK {
+		t.Fatalf("GET /readyz: status = %d, want 200", status)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// a store that can't answer takes the replica out of rotation, but
+	// restarting it wouldn't help
+	if status := probe("/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz: status = %d, want 503", status)
+	}
+	if status := probe("/livez"); status != http.StatusOK {
+		t.Errorf("GET /livez: status = %d, want 200", status)
+	}
+}
+
+func TestNew_RoutesRealmsByHost(t *testing.T) {
+	t.Parallel()
+	realmKey, err := consenttesting.GenerateTestKey()
+	if err != nil {
+		t.Fatalf("GenerateTestKey failed: %v", err)
+	}
+	options := testOptions(t, "http://consent.test", "consent.test")
+	options.Runtime.Realms = []config.RuntimeRealm{{
+		Name: "acme",
+		Runtime: config.Runtime{
+			Paths: config.Paths{
+				DatabaseFile: filepath.Join(t.TempDir(), "acme.sqlite"),
+			},
+			Server: config.RuntimeServer{
+				PublicBaseURL:   "https://auth.acme.test",
+				PublicHost:      "auth.acme.test",
+				AuthorityDomain: "auth.acme.test",
+			},
+			Secrets: config.RuntimeSecrets{
+				SigningKey: realmKey,
+			},
+		},
+	}}
+
+	srv, err := server.New(options)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+
+	// each host serves the verification key of its own realm
+	verificationKey := func(host string) []byte {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/key", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/v1/key on %s: status = %d", host, rec.Code)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		return body
+	}
+	primaryDER, _ := x509.MarshalPKIXPublicKey(consenttesting.SharedTestKey().Public())
+	realmDER, _ := x509.MarshalPKIXPublicKey(realmKey.Public())
+
+	if key := verificationKey("Auth.Acme.Test:443"); !slices.Equal(key, realmDER) {
+		t.Error("realm host did not serve the realm's key")
+	}
+	if key := verificationKey("consent.test"); !slices.Equal(key, primaryDER) {
+		t.Error("primary host did not serve the primary key")
+	}
+	if key := verificationKey("unknown.test"); !slices.Equal(key, primaryDER) {
+		t.Error("unknown host did not fall back to the primary realm")
+	}
+}
+
+func TestNew_SeedsInMemoryStore(t *testing.T) {
+	t.Parallel()
+	options := testOptions(t, "http://consent.test", "consent.test")
+	options.Runtime.Paths.DatabaseFile = config.DevDatabaseFile
+	options.Runtime.Server.PublicURL = "http://consent.test"
+	bootstrapAPIKey, err := keys.GenerateBootstrapKey()
+	if err != nil {
+		t.Fatalf("GenerateBootstrapKey failed: %v", err)
+	}
+	options.Runtime.Secrets.BootstrapAPIKey = bootstrapAPIKey
+	options.InitializeStore = true
+	options.Seed = func(ctx context.Context, svc *service.Service) error {
+		_, err := svc.CreateUser(ctx, "test", "test", nil)
+		return err
+	}
+
+	srv, err := server.New(options)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+
+	// the seeded user can sign in, so the seed and requests share the store
+	body := strings.NewReader(`{"handle":"test","secret":"test","integration":"` + service.InternalIntegrationName + `"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", body)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("login: status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestNew_SeedError(t *testing.T) {
+	t.Parallel()
+	options := testOptions(t, "http://consent.test", "consent.test")
+	options.Seed = func(context.Context, *service.Service) error {
+		return errors.New("no fixtures")
+	}
+
+	if _, err := server.New(options); err == nil || !strings.Contains(err.Error(), "no fixtures") {
+		t.Fatalf("New error = %v, want the seed's error", err)
+	}
+}
+
+func writeTestCertificate(
+	t *testing.T,
+) (
+	string,
+	string,
+) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// DeleteAccount permanently removes the account that owns the access token.
+// The caller must re-enter the account password. Refresh tokens, grants, and
+// every other record of the user are owned by the user row and are removed
+// with it; consent keeps no audit trail in its database, so nothing is left
+// to anonymize. The user.deleted event carries only the subject, which
+// downstream systems need to delete their copies, and not the handle.
+func (s *Service) DeleteAccount(
+	ctx context.Context,
+	encodedAccessToken string,
+	password string,
+) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	user, err := s.authenticateAccountRequest(ctx, encodedAccessToken, password)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.deleteUser(ctx, user.Subject)
+	if err != nil {
+		return fmt.Errorf("%w: failed to delete account: %v", ErrInternal, err)
+	}
+	if !deleted {
+		return ErrAccountNotFound
+	}
+	s.emit(ctx, Event{Type: EventUserDeleted, Subject: user.Subject})
+
+	return nil
+}
+
+// authenticateAccountRequest resolves the user behind a consent API access
+// token and confirms the supplied password belongs to that user.
+func (s *Service) authenticateAccountRequest(
+	ctx context.Context,
+	encodedAccessToken string,
+	password string,
+) (
+	*User,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.resourceTokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	user, err := s.store.GetUserBySubject(ctx, accessToken.Subject())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, fmt.Errorf("%w: failed to get account: %v", ErrInternal, err)
+	}
+
+	if err := s.throttledPassword(ctx, user.Handle, password); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// checkPassword compares a plaintext password against the stored hash for handle.
+func (s *Service) checkPassword(
+	ctx context.Context,
+	handle string,
+	password string,
+) error {
+	secretHash, err := s.store.GetSecret(ctx, handle)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrAccountNotFound, handle)
+		}
+		return fmt.Errorf("%w: failed to retrieve secret: %v", ErrInternal, err)
+	}
+
+	return s.passwords.compare(ctx, secretHash, []byte(password))
+}
+
+// ChangeHandle renames the account that owns the access token. The caller must
+// re-enter the account password, and the new handle must not belong to
+// another account.
+func (s *Service) ChangeHandle(
+	ctx context.Context,
+	encodedAccessToken string,
+	password string,
+	handle string,
+) (
+	*User,
+	error,
+) {
+	user, err := s.authenticateAccountRequest(ctx, encodedAccessToken, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.RenameUser(ctx, user.Subject, handle)
+}
+
+// RenameUser changes the handle of the user identified by subject.
+// Returns ErrHandleExists if another user already holds the handle.
+func (s *Service) RenameUser(
+	ctx context.Context,
+	subject string,
+	handle string,
+) (
+	*User,
+	error,
+) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		return nil, ErrInvalidUser
+	}
+	if handle == "" {
+		return nil, ErrInvalidHandle
+	}
+
+	err := s.store.UpdateUserHandle(ctx, subject, handle)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, subject)
+		}
+		if isUniqueConstraintError(err) {
+			return nil, ErrHandleExists
+		}
+		return nil, fmt.Errorf("%w: failed to rename user: %v", ErrInternal, err)
+	}
+
+	return s.GetUser(ctx, subject)
+}
@@ -0,0 +1,200 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+const consentAudience = "test.consent.local"
+
+func TestDeleteAccount_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	// setup user with a stored refresh token and grants
+	env.RegisterTestUser(t, "alice", "password")
+	refreshToken := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+	if err := env.DB.InsertGrants(t.Context(), accessToken.Subject(), "test-integration", []string{"identity"}); err != nil {
+		t.Fatalf("InsertGrants failed: %v", err)
+	}
+
+	// deleting with the correct password succeeds
+	if err := env.Service.DeleteAccount(t.Context(), accessToken.Encoded(), "password"); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+
+	// user, refresh tokens, and grants are gone
+	if _, err := env.Service.GetUser(t.Context(), accessToken.Subject()); !errors.Is(err, service.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := env.DB.GetRefreshTokenOwner(t.Context(), refreshToken.Encoded()); err == nil {
+		t.Fatal("expected refresh token to be deleted")
+	}
+	scopes, err := env.DB.ListGrantedScopeNames(t.Context(), accessToken.Subject(), "test-integration")
+	if err != nil {
+		t.Fatalf("ListGrantedScopeNames failed: %v", err)
+	}
+	if len(scopes) != 0 {
+		t.Fatalf("scopes = %v, want none", scopes)
+	}
+}
+
+func TestDeleteAccount_EventOmitsHandle(t *testing.T) {
+	t.Parallel()
+	var events []service.Event
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.OnEvent = func(_ context.Context, event service.Event) {
+			events = append(events, event)
+		}
+	})
+	env.RegisterTestUser(t, "alice", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+	events = nil
+
+	if err := env.Service.DeleteAccount(t.Context(), accessToken.Encoded(), "password"); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+
+	// downstream systems learn which subject to delete, not who it was
+	i := slices.IndexFunc(events, func(event service.Event) bool {
+		return event.Type == service.EventUserDeleted
+	})
+	if i < 0 {
+		t.Fatalf("no user.deleted event in %+v", events)
+	}
+	if events[i].Subject != accessToken.Subject() || events[i].Handle != "" {
+		t.Errorf("user.deleted event = %+v, want subject only", events[i])
+	}
+}
+
+func TestDeleteAccount_WrongPassword(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	// wrong password is rejected and the account survives
+	err := env.Service.DeleteAccount(t.Context(), accessToken.Encoded(), "wrong")
+	if !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := env.Service.GetUser(t.Context(), accessToken.Subject()); err != nil {
+		t.Fatalf("expected user to remain, got %v", err)
+	}
+}
+
+func TestDeleteAccount_InvalidToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	err := env.Service.DeleteAccount(t.Context(), "not-a-token", "password")
+	if !errors.Is(err, service.ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func TestDeleteAccount_RequiresConsentAudience(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{"test-audience"})
+
+	err := env.Service.DeleteAccount(t.Context(), accessToken.Encoded(), "password")
+	if !errors.Is(err, service.ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func TestChangeHandle_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	refreshToken := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	user, err := env.Service.ChangeHandle(t.Context(), accessToken.Encoded(), "password", "alicia")
+	if err != nil {
+		t.Fatalf("ChangeHandle failed: %v", err)
+	}
+	if user.Handle != "alicia" {
+		t.Fatalf("handle = %s, want alicia", user.Handle)
+	}
+
+	// refresh tokens stay with the renamed user
+	owner, err := env.DB.GetRefreshTokenOwner(t.Context(), refreshToken.Encoded())
+	if err != nil {
+		t.Fatalf("GetRefreshTokenOwner failed: %v", err)
+	}
+	if owner != accessToken.Subject() {
+		t.Fatalf("owner = %s, want %s", owner, accessToken.Subject())
+	}
+
+	// new handle can log in, old handle cannot
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alicia", "password", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode with new handle failed: %v", err)
+	}
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "password", service.InternalIntegrationName); !errors.Is(err, service.ErrAccountNotFound) {
+		t.Fatalf("expected ErrAccountNotFound for old handle, got %v", err)
+	}
+}
+
+func TestChangeHandle_Conflict(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	env.RegisterTestUser(t, "bob", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	_, err := env.Service.ChangeHandle(t.Context(), accessToken.Encoded(), "password", "bob")
+	if !errors.Is(err, service.ErrHandleExists) {
+		t.Fatalf("expected ErrHandleExists, got %v", err)
+	}
+}
+
+func TestChangeHandle_WrongPassword(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	_, err := env.Service.ChangeHandle(t.Context(), accessToken.Encoded(), "wrong", "alicia")
+	if !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestRenameUser_EmptyHandle(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	_, err = env.Service.RenameUser(t.Context(), user.Subject, "")
+	if !errors.Is(err, service.ErrInvalidHandle) {
+		t.Fatalf("expected ErrInvalidHandle, got %v", err)
+	}
+}
+
+func TestRenameUser_UnknownSubject(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	_, err := env.Service.RenameUser(t.Context(), "missing", "alicia")
+	if !errors.Is(err, service.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+)
+
+// DefaultMinRefreshInterval is how close together the default refresh policy
+// lets a session's refreshes come before flagging them.
+const DefaultMinRefreshInterval = 10 * time.Second
+
+// RefreshVerdict is a RefreshPolicy's judgement of a refresh.
+type RefreshVerdict int
+
+const (
+	// RefreshAllow lets the refresh proceed unremarked.
+	RefreshAllow RefreshVerdict = iota
+
+	// RefreshFlag lets the refresh proceed but reports it as an anomaly.
+	RefreshFlag
+
+	// RefreshReauthenticate rejects the refresh and ends the session, so
+	// the user has to sign in again.
+	RefreshReauthenticate
+)
+
+func (v RefreshVerdict) String() string {
+	switch v {
+	case RefreshAllow:
+		return "allow"
+	case RefreshFlag:
+		return "flag"
+	case RefreshReauthenticate:
+		return "reauthenticate"
+	default:
+		return "unknown"
+	}
+}
+
+// RefreshAttempt describes a refresh token being redeemed. Session is the
+// token's session as stored before this refresh, and Client is where the
+// refresh came from.
+type RefreshAttempt struct {
+	Subject     string
+	Integration string
+	Session     Session
+	Client      ClientInfo
+	At          time.Time
+}
+
+// RefreshDecision is a verdict and, unless it allows the refresh, why.
+type RefreshDecision struct {
+	Verdict RefreshVerdict
+	Reason  string
+}
+
+// RefreshAnomaly is the audit event for a refresh a RefreshPolicy flagged or
+// rejected.
+type RefreshAnomaly struct {
+	Attempt  RefreshAttempt
+	Decision RefreshDecision
+}
+
+// RefreshPolicy judges each refresh of a stored refresh token, so
+// deployments can flag or stop refreshes that don't look like the session's
+// owner.
+type RefreshPolicy interface {
+	CheckRefresh(ctx context.Context, attempt RefreshAttempt) RefreshDecision
+}
+
+// NetworkRefreshPolicy is the default RefreshPolicy. It flags a refresh from
+// a different network than the session last refreshed from (the same /24 for
+// IPv4 or /64 for IPv6 counts as the same network), and a refresh sooner than
+// MinInterval after the previous one. Unknown addresses are never flagged.
+type NetworkRefreshPolicy struct {
+	// MinInterval is the shortest expected gap between a session's
+	// refreshes. Zero disables the frequency check.
+	MinInterval time.Duration
+
+	// Reauthenticate rejects anomalous refreshes instead of only flagging
+	// them.
+	Reauthenticate bool
+}
+
+func (p NetworkRefreshPolicy) CheckRefresh(
+	_ context.Context,
+	attempt RefreshAttempt,
+) RefreshDecision {
+	verdict := RefreshFlag
+	if p.Reauthenticate {
+		verdict = RefreshReauthenticate
+	}
+
+	session := attempt.Session
+	lastIP := session.LastIP
+	if lastIP == "" {
+		lastIP = session.IP
+	}
+	if !sameNetwork(lastIP, attempt.Client.IP) {
+		return RefreshDecision{Verdict: verdict, Reason: "refresh from a new network"}
+	}
+	if p.MinInterval > 0 && !session.LastUsedAt.IsZero() && attempt.At.Sub(session.LastUsedAt) < p.MinInterval {
+		return RefreshDecision{Verdict: verdict, Reason: "refreshed too frequently"}
+	}
+	return RefreshDecision{Verdict: RefreshAllow}
+}
+
+// sameNetwork reports whether two client addresses share a network. Unknown
+// or unparseable addresses are treated as matching.
+func sameNetwork(
+	a string,
+	b string,
+) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+	addrA, addrB = addrA.Unmap(), addrB.Unmap()
+	if addrA.Is4() != addrB.Is4() {
+		return false
+	}
+	bits := 64
+	if addrA.Is4() {
+		bits = 24
+	}
+	prefixA, _ := addrA.Prefix(bits)
+	prefixB, _ := addrB.Prefix(bits)
+	return prefixA == prefixB
+}
+
+// logRefreshAnomaly is the audit hook used when none is configured.
+func logRefreshAnomaly(
+	ctx context.Context,
+	anomaly RefreshAnomaly,
+) {
+	attempt := anomaly.Attempt
+	log.Printf(
+		"refresh anomaly: %s (%s) subject=%s integration=%s ip=%s session_ip=%s request=%s",
+		anomaly.Decision.Reason,
+		anomaly.Decision.Verdict,
+		attempt.Subject,
+		attempt.Integration,
+		attempt.Client.IP,
+		attempt.Session.IP,
+		requestid.FromContext(ctx),
+	)
+}
+
+// checkRefresh runs the refresh policy over a refresh of the stored token
+// encodedRefreshToken, reporting anomalies to the audit hook. It returns
+// ErrReauthenticationRequired, after ending the session, if the policy
+// rejects the refresh.
+func (s *Service) checkRefresh(
+	ctx context.Context,
+	encodedRefreshToken string,
+	subject string,
+	integration *Integration,
+) error {
+	session, found, err := s.tokenStore.GetRefreshSession(ctx, encodedRefreshToken)
+	if err != nil {
+		return fmt.Errorf("%w: refresh token couldn't be read: %v", ErrInternal, err)
+	}
+	if !found {
+		return ErrTokenNotFound
+	}
+
+	attempt := RefreshAttempt{
+		Subject: subject,
+		Session: session,
+		Client:  clientInfoFrom(ctx),
+		At:      time.Now(),
+	}
+	if integration != nil {
+		attempt.Integration = integration.Name
+	}
+	decision := s.refreshPolicy.CheckRefresh(ctx, attempt)
+	if decision.Verdict == RefreshAllow {
+		return nil
+	}
+	s.onRefreshAnomaly(ctx, RefreshAnomaly{Attempt: attempt, Decision: decision})
+
+	if decision.Verdict != RefreshReauthenticate {
+		return nil
+	}
+	if _, err := s.tokenStore.DeleteRefreshToken(ctx, encodedRefreshToken); err != nil {
+		return fmt.Errorf("%w: failed to delete refresh token: %v", ErrInternal, err)
+	}
+	return fmt.Errorf("%w: %s", ErrReauthenticationRequired, decision.Reason)
+}
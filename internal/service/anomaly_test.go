@@ -0,0 +1,94 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestNetworkRefreshPolicy(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	policy := service.NetworkRefreshPolicy{MinInterval: time.Minute}
+
+	tests := []struct {
+		name    string
+		session service.Session
+		ip      string
+		at      time.Time
+		want    service.RefreshVerdict
+	}{
+		{"same address", service.Session{IP: "203.0.113.7"}, "203.0.113.7", now, service.RefreshAllow},
+		{"same ipv4 network", service.Session{IP: "203.0.113.7"}, "203.0.113.200", now, service.RefreshAllow},
+		{"new ipv4 network", service.Session{IP: "203.0.113.7"}, "198.51.100.2", now, service.RefreshFlag},
+		{"last refresh network wins", service.Session{IP: "203.0.113.7", LastIP: "198.51.100.2"}, "198.51.100.3", now, service.RefreshAllow},
+		{"same ipv6 network", service.Session{IP: "2001:db8:1:2::1"}, "2001:db8:1:2::99", now, service.RefreshAllow},
+		{"new ipv6 network", service.Session{IP: "2001:db8:1:2::1"}, "2001:db8:1:3::1", now, service.RefreshFlag},
+		{"address family change", service.Session{IP: "203.0.113.7"}, "2001:db8::1", now, service.RefreshFlag},
+		{"unknown address", service.Session{}, "198.51.100.2", now, service.RefreshAllow},
+		{"too frequent", service.Session{IP: "203.0.113.7", LastUsedAt: now.Add(-time.Second)}, "203.0.113.7", now, service.RefreshFlag},
+		{"spaced out", service.Session{IP: "203.0.113.7", LastUsedAt: now.Add(-time.Hour)}, "203.0.113.7", now, service.RefreshAllow},
+	}
+	for _, tt := range tests {
+		attempt := service.RefreshAttempt{Session: tt.session, Client: service.ClientInfo{IP: tt.ip}, At: tt.at}
+		decision := policy.CheckRefresh(t.Context(), attempt)
+		if decision.Verdict != tt.want {
+			t.Errorf("%s: verdict = %v, want %v", tt.name, decision.Verdict, tt.want)
+		}
+		if decision.Verdict != service.RefreshAllow && decision.Reason == "" {
+			t.Errorf("%s: expected a reason", tt.name)
+		}
+	}
+}
+
+func TestRefreshAccessToken_Anomaly(t *testing.T) {
+	t.Parallel()
+	var anomalies []service.RefreshAnomaly
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.RefreshPolicy = service.NetworkRefreshPolicy{Reauthenticate: true}
+		options.OnRefreshAnomaly = func(_ context.Context, anomaly service.RefreshAnomaly) {
+			anomalies = append(anomalies, anomaly)
+		}
+	})
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "http://localhost:8080/callback")
+	code := env.IssueTestAuthorizationCode(t, "alice", "test-integration", []string{"identity"})
+	home := service.WithClientInfo(t.Context(), service.ClientInfo{IP: "203.0.113.7"})
+	_, refreshToken, err := env.Service.ExchangeAuthorizationCode(home, code, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+
+	// refreshing from the same network is allowed
+	nearby := service.WithClientInfo(t.Context(), service.ClientInfo{IP: "203.0.113.9"})
+	_, refreshToken, err = env.Service.RefreshAccessToken(nearby, refreshToken, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	if len(anomalies) != 0 {
+		t.Fatalf("anomalies = %+v, want none", anomalies)
+	}
+
+	// refreshing from a new network requires signing in again
+	away := service.WithClientInfo(t.Context(), service.ClientInfo{IP: "198.51.100.2"})
+	_, _, err = env.Service.RefreshAccessToken(away, refreshToken, service.ClientCredentials{})
+	if !errors.Is(err, service.ErrReauthenticationRequired) {
+		t.Fatalf("expected ErrReauthenticationRequired, got %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Decision.Verdict != service.RefreshReauthenticate {
+		t.Fatalf("anomalies = %+v, want one reauthentication", anomalies)
+	}
+	if anomalies[0].Attempt.Client.IP != "198.51.100.2" || anomalies[0].Attempt.Session.IP != "203.0.113.7" {
+		t.Errorf("anomaly attempt = %+v, want refresh from 198.51.100.2 of session from 203.0.113.7", anomalies[0].Attempt)
+	}
+
+	// and ends the session
+	_, _, err = env.Service.RefreshAccessToken(home, refreshToken, service.ClientCredentials{})
+	if !errors.Is(err, service.ErrTokenNotFound) {
+		t.Errorf("expected ErrTokenNotFound after reauthentication, got %v", err)
+	}
+}
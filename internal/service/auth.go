@@ -1,28 +1,53 @@
 package service
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"slices"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
+// Default token lifetimes, used unless the deployment or an integration's
+// policy overrides them.
+const (
+	AccessTokenLifetime  = 30 * time.Minute
+	RefreshTokenLifetime = 72 * time.Hour
+	IDTokenLifetime      = AccessTokenLifetime
+
+	// SessionRefreshTokenLifetime caps refresh tokens for sign-ins the user
+	// didn't ask to keep.
+	SessionRefreshTokenLifetime = 24 * time.Hour
+)
+
+// LoginOptions are the choices a user makes when signing in. ReturnTo is
+// where to go afterwards. SessionOnly, for a user who didn't ask to stay
+// signed in, caps the session at SessionRefreshTokenLifetime and marks its
+// refresh tokens session-only, so clients keep them in cookies that end with
+// the browser session.
+type LoginOptions struct {
+	ReturnTo    string
+	SessionOnly bool
+}
+
 type UserInfoProfile struct {
-	Handle string
+	Handle      string
+	DisplayName string
+	AvatarURL   string
 }
 
 type UserInfo struct {
 	Sub     string
 	Profile *UserInfoProfile
+	Email   string
+	Groups  []string
 }
 
 func (s *Service) GetUserInfo(
+	ctx context.Context,
 	encodedAccessToken string,
 ) (
 	*UserInfo,
@@ -30,29 +55,125 @@ func (s *Service) GetUserInfo(
 ) {
 	accessToken := new(tokens.AccessToken)
 	if err := accessToken.Decode(encodedAccessToken, s.resourceTokenValidator); err != nil {
-		return nil, fmt.Errorf("%w: couldn't decode access token: %v", ErrTokenInvalid, err)
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
 	}
 
 	if !slices.Contains(accessToken.Scopes(), ScopeIdentity) {
 		return nil, ErrInsufficientScope
 	}
 
-	user, err := s.store.GetUserBySubject(accessToken.Subject())
+	return s.releasedUserInfo(ctx, accessToken)
+}
+
+// releasedUserInfo collects the claims about the token's subject that its
+// integration may receive: profile claims need the profile scope, email the
+// email scope, and each must be released by the integration's policy.
+func (s *Service) releasedUserInfo(
+	ctx context.Context,
+	accessToken *tokens.AccessToken,
+) (
+	*UserInfo,
+	error,
+) {
+	user, err := s.store.GetUserBySubject(ctx, accessToken.Subject())
 	if err != nil {
 		return nil, ErrAccountNotFound
 	}
 
+	profile, err := s.GetProfile(ctx, user.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	integration, err := s.integrationForAudience(ctx, accessToken.Audience())
+	if err != nil {
+		return nil, err
+	}
+	policy := IntegrationPolicy{}
+	if integration != nil {
+		policy = integration.Policy
+	}
+
 	userInfo := &UserInfo{Sub: accessToken.Subject()}
 	if slices.Contains(accessToken.Scopes(), ScopeProfile) {
-		userInfo.Profile = &UserInfoProfile{
-			Handle: user.Handle,
+		userInfo.Profile = &UserInfoProfile{}
+		if policy.ReleasesClaim(ClaimPreferredUsername) {
+			userInfo.Profile.Handle = user.Handle
+		}
+		if policy.ReleasesClaim(ClaimName) {
+			userInfo.Profile.DisplayName = profile.DisplayName
+		}
+		if policy.ReleasesClaim(ClaimPicture) {
+			userInfo.Profile.AvatarURL = profile.AvatarURL
+		}
+		if policy.ReleasesClaim(ClaimGroups) {
+			userInfo.Groups = user.Roles
 		}
 	}
+	if slices.Contains(accessToken.Scopes(), ScopeEmail) && policy.ReleasesClaim(ClaimEmail) {
+		userInfo.Email = profile.Email
+	}
 
 	return userInfo, nil
 }
 
+// IssueIDToken issues an ID token to accompany an access token. Tokens
+// without the identity scope get no ID token and an empty string is returned.
+// Only the claims releasedUserInfo allows are included.
+func (s *Service) IssueIDToken(
+	ctx context.Context,
+	encodedAccessToken string,
+) (
+	string,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.tokenValidator); err != nil {
+		return "", fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	if !slices.Contains(accessToken.Scopes(), ScopeIdentity) {
+		return "", nil
+	}
+
+	userInfo, err := s.releasedUserInfo(ctx, accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	claims := tokens.IDTokenProfile{
+		Email:  userInfo.Email,
+		Groups: userInfo.Groups,
+	}
+	if userInfo.Profile != nil {
+		claims.Handle = userInfo.Profile.Handle
+		claims.Name = userInfo.Profile.DisplayName
+		claims.Picture = userInfo.Profile.AvatarURL
+	}
+
+	// the ID token is for the integration, not the consent API
+	audience := slices.DeleteFunc(slices.Clone(accessToken.Audience()), func(aud string) bool {
+		return aud == s.consentAPIAudience
+	})
+	if len(audience) == 0 {
+		audience = accessToken.Audience()
+	}
+
+	idToken, err := s.tokenIssuer.IssueIDToken(
+		accessToken.Subject(),
+		audience,
+		claims,
+		IDTokenLifetime,
+	)
+	if err != nil {
+		return "", issueError("id token", err)
+	}
+
+	return idToken.Encoded(), nil
+}
+
 func (s *Service) GrantAuthCode(
+	ctx context.Context,
 	handle string,
 	secret string,
 	integrationName string,
@@ -61,51 +182,82 @@ func (s *Service) GrantAuthCode(
 	*url.URL,
 	error,
 ) {
-	redirectReturnTo := ""
+	options := LoginOptions{}
 	if len(returnTo) > 0 {
-		redirectReturnTo = returnTo[0]
+		options.ReturnTo = returnTo[0]
 	}
+	return s.GrantAuthCodeWithOptions(ctx, handle, secret, integrationName, options)
+}
 
-	secretHash, err := s.store.GetSecret(handle)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, handle)
+// GrantAuthCodeWithOptions is GrantAuthCode with the user's login choices.
+func (s *Service) GrantAuthCodeWithOptions(
+	ctx context.Context,
+	handle string,
+	secret string,
+	integrationName string,
+	options LoginOptions,
+) (
+	*url.URL,
+	error,
+) {
+	if err := s.throttledPassword(ctx, handle, secret); err != nil {
+		s.emitLoginFailed(ctx, handle, integrationName, err)
+		if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrAccountNotFound) {
+			s.countLogin(ctx, integrationName, options.ReturnTo, false)
 		}
-		return nil, fmt.Errorf("%w: failed to retrieve secret: %v", ErrInternal, err)
+		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(secretHash, []byte(secret))
-	if err != nil {
-		return nil, ErrInvalidCredentials
-	}
-
-	user, err := s.store.GetUserByHandle(handle)
+	user, err := s.store.GetUserByHandle(ctx, handle)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, handle)
 	}
-
-	integration, err := s.GetIntegration(integrationName)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrIntegrationNotFound, integrationName)
+	if err := accountActive(user); err != nil {
+		s.emitLoginFailed(ctx, handle, integrationName, err)
+		s.countLogin(ctx, integrationName, options.ReturnTo, false)
+		return nil, err
 	}
 
 	if integrationName != InternalIntegrationName {
+		if _, err := s.GetIntegration(ctx, integrationName); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIntegrationNotFound, integrationName)
+		}
 		return nil, ErrInvalidIntegration
 	}
 
-	refreshToken, err := s.tokenIssuer.IssueRefreshToken(
-		user.Subject,
-		[]string{integration.Audience},
-		nil,
-		time.Second*10,
-	)
+	signedInAt := time.Now()
+	redirect, err := s.issueInternalAuthCode(ctx, user.Subject, options)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(ctx, Event{Type: EventLoginSucceeded, Subject: user.Subject, Handle: user.Handle, Integration: InternalIntegrationName})
+	s.countLogin(ctx, integrationName, options.ReturnTo, true)
+	s.notifyNewDevice(ctx, user, signedInAt)
+	return redirect, nil
+}
+
+// issueInternalAuthCode issues a short-lived auth code for the consent app
+// itself and builds the redirect to its callback. The user has just signed
+// in, so the code's auth time is now.
+func (s *Service) issueInternalAuthCode(
+	ctx context.Context,
+	subject string,
+	options LoginOptions,
+) (
+	*url.URL,
+	error,
+) {
+	integration, err := s.GetIntegration(ctx, InternalIntegrationName)
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to issue refresh token: %v", ErrInternal, err)
+		return nil, fmt.Errorf("%w: %s", ErrIntegrationNotFound, InternalIntegrationName)
 	}
 
-	err = s.store.InsertRefreshToken(refreshToken)
+	code, err := s.issueAuthorizationCode(ctx, subject, integration, nil, tokens.IssueOptions{
+		AuthTime:    time.Now(),
+		SessionOnly: options.SessionOnly,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		return nil, err
 	}
 
 	redirectURL, err := parseAndValidateRedirectURL(integration.Redirect)
@@ -113,24 +265,40 @@ func (s *Service) GrantAuthCode(
 		return nil, fmt.Errorf("%w: invalid redirect URL: %v", ErrInternal, ErrInvalidRedirect)
 	}
 
-	return buildAuthCodeRedirectURL(redirectURL, refreshToken.Encoded(), "", redirectReturnTo), nil
+	return buildAuthCodeRedirectURL(redirectURL, code, "", options.ReturnTo), nil
 }
 
 func (s *Service) RevokeRefreshToken(
+	ctx context.Context,
 	encodedRefreshToken string,
 ) error {
-	deleted, err := s.store.DeleteRefreshToken(encodedRefreshToken)
+	deleted, err := s.tokenStore.DeleteRefreshToken(ctx, encodedRefreshToken)
 	if err != nil {
 		return fmt.Errorf("%w: failed to delete refresh token: %v", ErrInternal, err)
 	}
 	if !deleted {
 		return ErrTokenNotFound
 	}
+
+	// the store just vouched for the token, so its claims can be trusted
+	event := Event{Type: EventTokenRevoked}
+	if token, err := tokens.ParseUnverified(encodedRefreshToken); err == nil {
+		event.Subject = token.Subject()
+	}
+	s.emit(ctx, event)
 	return nil
 }
 
+// RefreshAccessToken redeems a refresh token for a new token pair, using the
+// policy of the integration the token was issued to. Confidential
+// integrations must authenticate with client. Refresh tokens are rotated
+// unless the policy reuses them, in which case the presented token is
+// returned again as long as it outlives the new access token. The refresh
+// policy may flag the refresh or end the session first.
 func (s *Service) RefreshAccessToken(
+	ctx context.Context,
 	encodedRefreshToken string,
+	client ClientCredentials,
 ) (
 	string,
 	string,
@@ -138,41 +306,233 @@ func (s *Service) RefreshAccessToken(
 ) {
 	token := tokens.RefreshToken{}
 	if err := token.Decode(encodedRefreshToken, s.tokenValidator); err != nil {
-		return "", "", fmt.Errorf("%w: couldn't decode refresh token: %v", ErrTokenInvalid, err)
+		return "", "", fmt.Errorf("%w: couldn't decode refresh token: %w", ErrTokenInvalid, err)
 	}
 
-	deleted, err := s.store.DeleteRefreshToken(encodedRefreshToken)
+	integration, err := s.authenticateClient(ctx, &token, client)
 	if err != nil {
-		return "", "", fmt.Errorf("%w: refresh token couldn't be deleted: %v", ErrInternal, err)
+		return "", "", err
 	}
-	if !deleted {
+	policy := IntegrationPolicy{}
+	if integration != nil {
+		policy = integration.Policy
+	}
+	user, err := s.activeUser(ctx, token.Subject())
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.checkTerms(user, integration); err != nil {
+		return "", "", err
+	}
+	if err := s.checkRefresh(ctx, encodedRefreshToken, token.Subject(), integration); err != nil {
+		return "", "", err
+	}
+
+	// nearly expired refresh tokens are always rotated
+	if policy.ReuseRefreshTokens && time.Until(token.Expiration()) > s.accessLifetime(policy) {
+		found, err := s.tokenStore.TouchRefreshToken(ctx, encodedRefreshToken, time.Now(), clientInfoFrom(ctx))
+		if err != nil {
+			return "", "", fmt.Errorf("%w: refresh token couldn't be read: %v", ErrInternal, err)
+		}
+		if !found {
+			return "", "", ErrTokenNotFound
+		}
+
+		accessToken, err := s.issueAccessToken(token.Subject(), token.Audience(), token.Scopes(), policy, tokens.IssueOptions{
+			AuthTime:     token.AuthTime(),
+			TermsVersion: user.TermsVersion,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		return accessToken, encodedRefreshToken, nil
+	}
+
+	// sign the new pair before touching the store, then swap the stored
+	// token in one transaction; the new token continues the old one's session
+	accessToken, newRefreshToken, err := s.mintTokenPair(token.Subject(), token.Audience(), token.Scopes(), policy, tokens.IssueOptions{
+		AuthTime:     token.AuthTime(),
+		SessionOnly:  token.SessionOnly(),
+		TermsVersion: user.TermsVersion,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	rotated, err := s.tokenStore.RotateRefreshToken(ctx, encodedRefreshToken, newRefreshToken, clientInfoFrom(ctx))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: refresh token couldn't be rotated: %v", ErrInternal, err)
+	}
+	if !rotated {
 		return "", "", ErrTokenNotFound
 	}
 
-	accessToken, err := s.tokenIssuer.IssueAccessToken(
-		token.Subject(),
-		token.Audience(),
-		token.Scopes(),
-		time.Minute*30,
-	)
+	return accessToken, newRefreshToken.Encoded(), nil
+}
+
+// AccessTokenExpiresIn returns how long an access token issued by this
+// server was issued for, which depends on the integration's policy.
+func (s *Service) AccessTokenExpiresIn(
+	encodedAccessToken string,
+) (
+	time.Duration,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.tokenValidator); err != nil {
+		return 0, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+	return accessToken.Expiration().Sub(accessToken.IssuedAt()), nil
+}
+
+// RefreshTokenExpiresIn returns how long a refresh token issued by this
+// server was issued for, which depends on the integration's policy and
+// whether the session is session-only.
+func (s *Service) RefreshTokenExpiresIn(
+	encodedRefreshToken string,
+) (
+	time.Duration,
+	error,
+) {
+	refreshToken := new(tokens.RefreshToken)
+	if err := refreshToken.Decode(encodedRefreshToken, s.tokenValidator); err != nil {
+		return 0, fmt.Errorf("%w: couldn't decode refresh token: %w", ErrTokenInvalid, err)
+	}
+	return refreshToken.Expiration().Sub(refreshToken.IssuedAt()), nil
+}
+
+// issueTokenPair issues an access token and a stored refresh token with
+// lifetimes from policy and the sign-in details in options. The access token
+// carries the version of the terms of service the user has accepted.
+func (s *Service) issueTokenPair(
+	ctx context.Context,
+	subject string,
+	audience []string,
+	scopes []string,
+	policy IntegrationPolicy,
+	options tokens.IssueOptions,
+) (
+	string,
+	string,
+	error,
+) {
+	user, err := s.activeUser(ctx, subject)
+	if err != nil {
+		return "", "", err
+	}
+	options.TermsVersion = user.TermsVersion
+	accessToken, newRefreshToken, err := s.mintTokenPair(subject, audience, scopes, policy, options)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.tokenStore.InsertRefreshToken(ctx, newRefreshToken, clientInfoFrom(ctx))
 	if err != nil {
-		return "", "", fmt.Errorf("%w: couldn't issue access token: %v", ErrInternal, err)
+		return "", "", fmt.Errorf("%w: failed to store refresh token: %v", ErrInternal, err)
 	}
 
-	newRefreshToken, err := s.tokenIssuer.IssueRefreshToken(
-		token.Subject(),
-		token.Audience(),
-		token.Scopes(),
-		time.Hour*72,
+	return accessToken, newRefreshToken.Encoded(), nil
+}
+
+// mintTokenPair signs an access token and a refresh token with lifetimes from
+// policy without storing either. Session-only refresh tokens are capped at
+// SessionRefreshTokenLifetime.
+func (s *Service) mintTokenPair(
+	subject string,
+	audience []string,
+	scopes []string,
+	policy IntegrationPolicy,
+	options tokens.IssueOptions,
+) (
+	string,
+	*tokens.RefreshToken,
+	error,
+) {
+	accessLifetime := s.accessLifetime(policy)
+	refreshLifetime := s.refreshLifetime(policy)
+	if options.SessionOnly {
+		refreshLifetime = min(refreshLifetime, SessionRefreshTokenLifetime)
+		accessLifetime = min(accessLifetime, refreshLifetime)
+	}
+	accessToken, refreshToken, err := s.tokenIssuer.IssueTokenPairWithOptions(
+		subject,
+		audience,
+		scopes,
+		accessLifetime,
+		refreshLifetime,
+		options,
 	)
 	if err != nil {
-		return "", "", fmt.Errorf("%w: couldn't issue refresh token: %v", ErrInternal, err)
+		return "", nil, issueError("tokens", err)
 	}
+	return accessToken.Encoded(), refreshToken, nil
+}
 
-	err = s.store.InsertRefreshToken(newRefreshToken)
+func (s *Service) issueAccessToken(
+	subject string,
+	audience []string,
+	scopes []string,
+	policy IntegrationPolicy,
+	options tokens.IssueOptions,
+) (
+	string,
+	error,
+) {
+	accessToken, err := s.tokenIssuer.IssueAccessTokenWithOptions(
+		subject,
+		audience,
+		scopes,
+		s.accessLifetime(policy),
+		options,
+	)
 	if err != nil {
-		return "", "", fmt.Errorf("%w: failed to store refresh token: %v", ErrInternal, err)
+		return "", issueError("access token", err)
+	}
+	return accessToken.Encoded(), nil
+}
+
+// issueError reports a failure to issue what. A full signing queue is
+// ErrBusy, which clients may retry shortly; anything else is internal.
+func issueError(
+	what string,
+	err error,
+) error {
+	if errors.Is(err, tokens.ErrSignerBusy()) {
+		return fmt.Errorf("%w: couldn't issue %s, try again shortly", ErrBusy, what)
+	}
+	return fmt.Errorf("%w: couldn't issue %s: %v", ErrInternal, what, err)
+}
+
+// accessLifetime returns the access token lifetime under policy: the policy's
+// lifetime if set, otherwise the deployment's.
+func (s *Service) accessLifetime(
+	policy IntegrationPolicy,
+) time.Duration {
+	if policy.AccessTokenLifetime > 0 {
+		return policy.AccessTokenLifetime
 	}
+	return s.defaultAccessLifetime
+}
+
+// refreshLifetime returns the refresh token lifetime under policy: the
+// policy's lifetime if set, otherwise the deployment's.
+func (s *Service) refreshLifetime(
+	policy IntegrationPolicy,
+) time.Duration {
+	if policy.RefreshTokenLifetime > 0 {
+		return policy.RefreshTokenLifetime
+	}
+	return s.defaultRefreshLifetime
+}
 
-	return accessToken.Encoded(), newRefreshToken.Encoded(), nil
+func validateTokenLifetimes(
+	access time.Duration,
+	refresh time.Duration,
+) error {
+	if access < 0 || refresh < 0 {
+		return errors.New("token lifetimes cannot be negative")
+	}
+	if access > refresh {
+		return errors.New("access token lifetime exceeds refresh token lifetime")
+	}
+	return nil
 }
@@ -1,12 +1,18 @@
 package service_test
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"errors"
+	"io"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
 func TestGrantAuthCode_Success(t *testing.T) {
@@ -17,7 +23,7 @@ func TestGrantAuthCode_Success(t *testing.T) {
 	env.RegisterTestUser(t, "alice", "password123")
 
 	// returns redirect URL with auth_code
-	redirectURL, err := env.Service.GrantAuthCode("alice", "password123", service.InternalIntegrationName)
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
 	if err != nil {
 		t.Fatalf("Login failed: %v", err)
 	}
@@ -38,7 +44,7 @@ func TestGrantAuthCode_RedirectURL(t *testing.T) {
 	env.RegisterTestUser(t, "alice", "password123")
 
 	// redirects to the integration's configured callback URL
-	redirectURL, err := env.Service.GrantAuthCode("alice", "password123", service.InternalIntegrationName)
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
 	if err != nil {
 		t.Fatalf("Login failed: %v", err)
 	}
@@ -58,7 +64,7 @@ func TestGrantAuthCode_WrongPassword(t *testing.T) {
 	env.RegisterTestUser(t, "alice", "password123")
 
 	// wrong password returns ErrInvalidCredentials
-	_, err := env.Service.GrantAuthCode("alice", "wrongpassword", service.InternalIntegrationName)
+	_, err := env.Service.GrantAuthCode(t.Context(), "alice", "wrongpassword", service.InternalIntegrationName)
 	if !errors.Is(err, service.ErrInvalidCredentials) {
 		t.Errorf("expected ErrInvalidCredentials, got %v", err)
 	}
@@ -69,7 +75,7 @@ func TestGrantAuthCode_UnknownUser(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	// unknown user returns ErrAccountNotFound
-	_, err := env.Service.GrantAuthCode("unknown", "password", service.InternalIntegrationName)
+	_, err := env.Service.GrantAuthCode(t.Context(), "unknown", "password", service.InternalIntegrationName)
 	if !errors.Is(err, service.ErrAccountNotFound) {
 		t.Errorf("expected ErrAccountNotFound, got %v", err)
 	}
@@ -83,13 +89,13 @@ func TestGrantAuthCode_UnknownIntegration(t *testing.T) {
 	env.RegisterTestUser(t, "alice", "password123")
 
 	// unknown integration returns ErrIntegrationNotFound
-	_, err := env.Service.GrantAuthCode("alice", "password123", "nonexistent-service")
+	_, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", "nonexistent-service")
 	if !errors.Is(err, service.ErrIntegrationNotFound) {
 		t.Errorf("expected ErrIntegrationNotFound, got %v", err)
 	}
 }
 
-func TestGrantAuthCode_StoresRefreshToken(t *testing.T) {
+func TestGrantAuthCode_CodeIsNotRefreshToken(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
@@ -97,44 +103,187 @@ func TestGrantAuthCode_StoresRefreshToken(t *testing.T) {
 	env.RegisterTestUser(t, "alice", "password123")
 
 	// grant auth_code and get redirect
-	redirectURL, err := env.Service.GrantAuthCode("alice", "password123", service.InternalIntegrationName)
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	authCode := redirectURL.Query().Get("auth_code")
+	if authCode == "" {
+		t.Fatal("redirect has no auth_code")
+	}
+
+	// auth_code is opaque, not a JWT
+	if strings.Contains(authCode, ".") {
+		t.Errorf("auth_code looks like a JWT: %s", authCode)
+	}
+
+	// auth_code can't be used as a refresh token
+	if _, _, err := env.Service.RefreshAccessToken(t.Context(), authCode, service.ClientCredentials{}); !errors.Is(err, service.ErrTokenInvalid) {
+		t.Errorf("expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func TestExchangeAuthorizationCode_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	// setup env
+	env.RegisterTestUser(t, "alice", "password123")
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
 	if err != nil {
 		t.Fatalf("Login failed: %v", err)
 	}
 	authCode := redirectURL.Query().Get("auth_code")
 
-	// token is stored and owner can be retrieved
-	owner, err := env.DB.GetRefreshTokenOwner(authCode)
+	// exchange code for tokens
+	accessToken, refreshToken, err := env.Service.ExchangeAuthorizationCode(t.Context(), authCode, service.ClientCredentials{})
 	if err != nil {
-		t.Fatalf("Token not stored: %v", err)
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
 	}
-	user, err := env.DB.GetUserByHandle("alice")
+
+	// refresh token is stored for the user
+	owner, err := env.DB.GetRefreshTokenOwner(t.Context(), refreshToken)
+	if err != nil {
+		t.Fatalf("refresh token not stored: %v", err)
+	}
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
 	if owner != user.Subject {
 		t.Errorf("token owner = %s, want %s", owner, user.Subject)
 	}
+
+	// access token is for the user
+	token := new(tokens.AccessToken)
+	if err := token.Decode(accessToken, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode access token: %v", err)
+	}
+	if token.Subject() != user.Subject {
+		t.Errorf("access token subject = %s, want %s", token.Subject(), user.Subject)
+	}
 }
 
-func TestGrantAuthCode_AuthCodeIsValidJWT(t *testing.T) {
+func TestGrantAuthCodeWithOptions_SessionOnly(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
 	// setup env
 	env.RegisterTestUser(t, "alice", "password123")
+	redirectURL, err := env.Service.GrantAuthCodeWithOptions(
+		t.Context(),
+		"alice",
+		"password123",
+		service.InternalIntegrationName,
+		service.LoginOptions{SessionOnly: true},
+	)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	authCode := redirectURL.Query().Get("auth_code")
 
-	// grant auth_code and get redirect
-	redirectURL, err := env.Service.GrantAuthCode("alice", "password123", service.InternalIntegrationName)
+	// exchanged refresh token is session-only and short-lived
+	_, encodedRefresh, err := env.Service.ExchangeAuthorizationCode(t.Context(), authCode, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+	refresh := new(tokens.RefreshToken)
+	if err := refresh.Decode(encodedRefresh, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode refresh token: %v", err)
+	}
+	if !refresh.SessionOnly() {
+		t.Error("refresh token should be session-only")
+	}
+	if lifetime := refresh.Expiration().Sub(refresh.IssuedAt()); lifetime > service.SessionRefreshTokenLifetime {
+		t.Errorf("refresh lifetime = %v, want at most %v", lifetime, service.SessionRefreshTokenLifetime)
+	}
+
+	// rotation keeps the session-only mark
+	_, encodedRotated, err := env.Service.RefreshAccessToken(t.Context(), encodedRefresh, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	rotated := new(tokens.RefreshToken)
+	if err := rotated.Decode(encodedRotated, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode rotated refresh token: %v", err)
+	}
+	if !rotated.SessionOnly() {
+		t.Error("rotated refresh token should be session-only")
+	}
+}
+
+func TestGrantAuthCode_StampsAuthTime(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	// setup env
+	env.RegisterTestUser(t, "alice", "password123")
+	before := time.Now().Truncate(time.Second)
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
 	if err != nil {
 		t.Fatalf("Login failed: %v", err)
 	}
 	authCode := redirectURL.Query().Get("auth_code")
 
-	// auth_code is valid JWT format (3 dot-separated parts)
-	parts := strings.Split(authCode, ".")
-	if len(parts) != 3 {
-		t.Errorf("auth_code not valid JWT format, has %d parts", len(parts))
+	// the exchanged access token carries the sign-in time
+	encodedAccess, encodedRefresh, err := env.Service.ExchangeAuthorizationCode(t.Context(), authCode, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+	access := new(tokens.AccessToken)
+	if err := access.Decode(encodedAccess, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode access token: %v", err)
+	}
+	authTime := access.AuthTime()
+	if authTime.Before(before) || authTime.After(time.Now()) {
+		t.Fatalf("auth time = %v, want the sign-in time", authTime)
+	}
+
+	// refreshing keeps the original sign-in time
+	encodedAccess, _, err = env.Service.RefreshAccessToken(t.Context(), encodedRefresh, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	refreshed := new(tokens.AccessToken)
+	if err := refreshed.Decode(encodedAccess, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode refreshed access token: %v", err)
+	}
+	if !refreshed.AuthTime().Equal(authTime) {
+		t.Errorf("refreshed auth time = %v, want %v", refreshed.AuthTime(), authTime)
+	}
+}
+
+func TestExchangeAuthorizationCode_SingleUse(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	// setup env
+	env.RegisterTestUser(t, "alice", "password123")
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	authCode := redirectURL.Query().Get("auth_code")
+
+	// first exchange succeeds
+	if _, _, err := env.Service.ExchangeAuthorizationCode(t.Context(), authCode, service.ClientCredentials{}); err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+
+	// second exchange fails
+	_, _, err = env.Service.ExchangeAuthorizationCode(t.Context(), authCode, service.ClientCredentials{})
+	if !errors.Is(err, service.ErrInvalidAuthCode) {
+		t.Errorf("expected ErrInvalidAuthCode, got %v", err)
+	}
+}
+
+func TestExchangeAuthorizationCode_Unknown(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	_, _, err := env.Service.ExchangeAuthorizationCode(t.Context(), "not-a-code", service.ClientCredentials{})
+	if !errors.Is(err, service.ErrInvalidAuthCode) {
+		t.Errorf("expected ErrInvalidAuthCode, got %v", err)
 	}
 }
 
@@ -147,7 +296,7 @@ func TestRefreshAccessToken_Success(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// refreshing valid token returns new access and refresh tokens
-	accessToken, newRefreshToken, err := env.Service.RefreshAccessToken(token.Encoded())
+	accessToken, newRefreshToken, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if err != nil {
 		t.Fatalf("RefreshAccessToken failed: %v", err)
 	}
@@ -164,7 +313,7 @@ func TestRefreshAccessToken_InvalidToken(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	// malformed token returns ErrTokenInvalid
-	_, _, err := env.Service.RefreshAccessToken("invalid-token")
+	_, _, err := env.Service.RefreshAccessToken(t.Context(), "invalid-token", service.ClientCredentials{})
 	if !errors.Is(err, service.ErrTokenInvalid) {
 		t.Errorf("expected ErrTokenInvalid, got %v", err)
 	}
@@ -179,7 +328,7 @@ func TestRefreshAccessToken_TokenNotInStore(t *testing.T) {
 	token := env.IssueTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// valid token not in store returns ErrTokenNotFound
-	_, _, err := env.Service.RefreshAccessToken(token.Encoded())
+	_, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if !errors.Is(err, service.ErrTokenNotFound) {
 		t.Errorf("expected ErrTokenNotFound, got %v", err)
 	}
@@ -194,13 +343,13 @@ func TestRefreshAccessToken_DeletesOldToken(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// first refresh succeeds
-	_, _, err := env.Service.RefreshAccessToken(token.Encoded())
+	_, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if err != nil {
 		t.Fatalf("RefreshAccessToken failed: %v", err)
 	}
 
 	// old token is deleted and can't be used again
-	_, _, err = env.Service.RefreshAccessToken(token.Encoded())
+	_, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if !errors.Is(err, service.ErrTokenNotFound) {
 		t.Errorf("old token should be deleted, got %v", err)
 	}
@@ -215,17 +364,17 @@ func TestRefreshAccessToken_StoresNewToken(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// refresh returns new token
-	_, newRefreshToken, err := env.Service.RefreshAccessToken(token.Encoded())
+	_, newRefreshToken, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if err != nil {
 		t.Fatalf("RefreshAccessToken failed: %v", err)
 	}
 
 	// new token is stored in database
-	owner, err := env.DB.GetRefreshTokenOwner(newRefreshToken)
+	owner, err := env.DB.GetRefreshTokenOwner(t.Context(), newRefreshToken)
 	if err != nil {
 		t.Fatalf("new token not stored: %v", err)
 	}
-	user, err := env.DB.GetUserByHandle("alice")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByHandle failed: %v", err)
 	}
@@ -243,13 +392,13 @@ func TestRefreshAccessToken_CanBeRefreshedAgain(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// first refresh succeeds
-	_, newRefreshToken1, err := env.Service.RefreshAccessToken(token.Encoded())
+	_, newRefreshToken1, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if err != nil {
 		t.Fatalf("First RefreshAccessToken failed: %v", err)
 	}
 
 	// new token can be used for another refresh
-	_, newRefreshToken2, err := env.Service.RefreshAccessToken(newRefreshToken1)
+	_, newRefreshToken2, err := env.Service.RefreshAccessToken(t.Context(), newRefreshToken1, service.ClientCredentials{})
 	if err != nil {
 		t.Fatalf("Second RefreshAccessToken failed: %v", err)
 	}
@@ -258,6 +407,115 @@ func TestRefreshAccessToken_CanBeRefreshedAgain(t *testing.T) {
 	}
 }
 
+func TestRefreshAccessToken_DefaultLifetimes(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.StoreTestRefreshToken(t, "alice", []string{"unregistered-audience"})
+
+	// tokens for no known integration use the default lifetimes
+	accessToken, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	expiresIn, err := env.Service.AccessTokenExpiresIn(accessToken)
+	if err != nil {
+		t.Fatalf("AccessTokenExpiresIn failed: %v", err)
+	}
+	if expiresIn != service.AccessTokenLifetime {
+		t.Errorf("expiresIn = %v, want %v", expiresIn, service.AccessTokenLifetime)
+	}
+}
+
+func TestRefreshAccessToken_IntegrationLifetimes(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	err := env.Service.CreateIntegrationWithPolicy(
+		t.Context(),
+		"svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil,
+		service.IntegrationPolicy{
+			AccessTokenLifetime:  5 * time.Minute,
+			RefreshTokenLifetime: 24 * time.Hour,
+		},
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegrationWithPolicy failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"aud-a", "test.consent.local"})
+
+	// issued tokens follow the integration's policy
+	accessToken, newRefreshToken, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	expiresIn, err := env.Service.AccessTokenExpiresIn(accessToken)
+	if err != nil {
+		t.Fatalf("AccessTokenExpiresIn failed: %v", err)
+	}
+	if expiresIn != 5*time.Minute {
+		t.Errorf("access expiresIn = %v, want 5m", expiresIn)
+	}
+
+	refreshToken := tokens.RefreshToken{}
+	if err := refreshToken.Decode(newRefreshToken, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode refresh token: %v", err)
+	}
+	if lifetime := refreshToken.Expiration().Sub(refreshToken.IssuedAt()); lifetime != 24*time.Hour {
+		t.Errorf("refresh lifetime = %v, want 24h", lifetime)
+	}
+}
+
+func TestRefreshAccessToken_ReuseRefreshTokens(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	env.RegisterTestUser(t, "alice", "password")
+	err := env.Service.CreateIntegrationWithPolicy(
+		t.Context(),
+		"svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil,
+		service.IntegrationPolicy{ReuseRefreshTokens: true},
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegrationWithPolicy failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"aud-a"})
+
+	// the same refresh token is returned and stays usable
+	for range 2 {
+		accessToken, refreshToken, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+		if err != nil {
+			t.Fatalf("RefreshAccessToken failed: %v", err)
+		}
+		if accessToken == "" {
+			t.Error("expected non-empty access token")
+		}
+		if refreshToken != token.Encoded() {
+			t.Error("expected refresh token to be reused")
+		}
+	}
+
+	// each use is recorded on the session
+	sessions, err := env.Service.ListSessions(t.Context(), token.Subject())
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].LastUsedAt.IsZero() {
+		t.Errorf("sessions = %+v, want one with a last use", sessions)
+	}
+
+	// revoking still invalidates it
+	if err := env.Service.RevokeRefreshToken(t.Context(), token.Encoded()); err != nil {
+		t.Fatalf("RevokeRefreshToken failed: %v", err)
+	}
+	_, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if !errors.Is(err, service.ErrTokenNotFound) {
+		t.Errorf("expected ErrTokenNotFound, got %v", err)
+	}
+}
+
 func TestRevokeRefreshToken_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
@@ -267,7 +525,7 @@ func TestRevokeRefreshToken_Success(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// revoking valid token succeeds
-	err := env.Service.RevokeRefreshToken(token.Encoded())
+	err := env.Service.RevokeRefreshToken(t.Context(), token.Encoded())
 	if err != nil {
 		t.Fatalf("RevokeRefreshToken failed: %v", err)
 	}
@@ -278,7 +536,7 @@ func TestRevokeRefreshToken_NotFound(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	// revoking non-existent token returns ErrTokenNotFound
-	err := env.Service.RevokeRefreshToken("nonexistent-token")
+	err := env.Service.RevokeRefreshToken(t.Context(), "nonexistent-token")
 	if !errors.Is(err, service.ErrTokenNotFound) {
 		t.Errorf("expected ErrTokenNotFound, got %v", err)
 	}
@@ -293,12 +551,12 @@ func TestRevokeRefreshToken_CantRefreshAfterRevoke(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// revoke the token
-	if err := env.Service.RevokeRefreshToken(token.Encoded()); err != nil {
+	if err := env.Service.RevokeRefreshToken(t.Context(), token.Encoded()); err != nil {
 		t.Fatalf("RevokeRefreshToken failed: %v", err)
 	}
 
 	// revoked token can't be used for refresh
-	_, _, err := env.Service.RefreshAccessToken(token.Encoded())
+	_, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
 	if !errors.Is(err, service.ErrTokenNotFound) {
 		t.Errorf("expected ErrTokenNotFound after revoke, got %v", err)
 	}
@@ -313,13 +571,215 @@ func TestRevokeRefreshToken_DoubleRevoke(t *testing.T) {
 	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
 
 	// first revoke succeeds
-	if err := env.Service.RevokeRefreshToken(token.Encoded()); err != nil {
+	if err := env.Service.RevokeRefreshToken(t.Context(), token.Encoded()); err != nil {
 		t.Fatalf("First RevokeRefreshToken failed: %v", err)
 	}
 
 	// second revoke returns ErrTokenNotFound
-	err := env.Service.RevokeRefreshToken(token.Encoded())
+	err := env.Service.RevokeRefreshToken(t.Context(), token.Encoded())
 	if !errors.Is(err, service.ErrTokenNotFound) {
 		t.Errorf("expected ErrTokenNotFound on second revoke, got %v", err)
 	}
 }
+
+func TestIssueIDToken_IdentityScope(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+
+	accessToken := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience", consentAudience}, []string{"identity"})
+
+	// identity scope yields an ID token for the integration audience only
+	encoded, err := env.Service.IssueIDToken(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+	idToken := new(tokens.IDToken)
+	if err := idToken.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode id token: %v", err)
+	}
+	if idToken.Subject() != accessToken.Subject() {
+		t.Errorf("sub = %q, want %q", idToken.Subject(), accessToken.Subject())
+	}
+	if len(idToken.Audience()) != 1 || idToken.Audience()[0] != "test-audience" {
+		t.Errorf("aud = %v, want [test-audience]", idToken.Audience())
+	}
+	if !reflect.DeepEqual(idToken.Profile(), tokens.IDTokenProfile{}) {
+		t.Errorf("profile = %+v, want empty", idToken.Profile())
+	}
+}
+
+func TestIssueIDToken_ProfileScope(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+
+	accessToken := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience"}, []string{"identity", "profile"})
+
+	// profile scope releases the handle
+	encoded, err := env.Service.IssueIDToken(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+	idToken := new(tokens.IDToken)
+	if err := idToken.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode id token: %v", err)
+	}
+	if idToken.Profile().Handle != "alice" {
+		t.Errorf("handle = %q, want alice", idToken.Profile().Handle)
+	}
+}
+
+func TestIssueIDToken_ReleasedClaims(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	if _, err := env.Service.CreateUser(t.Context(), "alice", "password", []string{service.ProtectedAdminRoleName}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "http://localhost:8080/callback")
+	scopes := []string{"identity", "profile", "email"}
+
+	issue := func() tokens.IDTokenProfile {
+		t.Helper()
+		accessToken := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience"}, scopes)
+		encoded, err := env.Service.IssueIDToken(t.Context(), accessToken.Encoded())
+		if err != nil {
+			t.Fatalf("IssueIDToken failed: %v", err)
+		}
+		idToken := new(tokens.IDToken)
+		if err := idToken.Decode(encoded, env.TokenValidator); err != nil {
+			t.Fatalf("failed to decode id token: %v", err)
+		}
+		return idToken.Profile()
+	}
+
+	// without a release policy, groups are withheld
+	if profile := issue(); profile.Handle != "alice" || profile.Groups != nil {
+		t.Errorf("default profile = %+v, want handle and no groups", profile)
+	}
+
+	// a release policy limits claims to those listed
+	err := env.Service.UpdateIntegration(t.Context(), "test-integration", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{ReleasedClaims: []string{service.ClaimGroups}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	want := tokens.IDTokenProfile{Groups: []string{service.ProtectedAdminRoleName}}
+	if profile := issue(); !reflect.DeepEqual(profile, want) {
+		t.Errorf("released profile = %+v, want %+v", profile, want)
+	}
+}
+
+func TestIssueIDToken_WithoutIdentityScope(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{"test-audience"})
+
+	// no identity scope means no ID token
+	encoded, err := env.Service.IssueIDToken(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+	if encoded != "" {
+		t.Errorf("expected no id token, got %q", encoded)
+	}
+}
+
+func TestIssueIDToken_InvalidToken(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	_, err := env.Service.IssueIDToken(t.Context(), "invalid-token")
+	if !errors.Is(err, service.ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func TestExchangeAuthorizationCode_RecordsClientInfo(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	// setup env
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	code := env.IssueTestAuthorizationCode(t, "alice", "svc-a", []string{service.ScopeIdentity})
+	client := service.ClientInfo{IP: "203.0.113.7", UserAgent: strings.Repeat("x", 1000)}
+	ctx := service.WithClientInfo(t.Context(), client)
+
+	// the new session records the client from the context, with the
+	// User-Agent truncated
+	_, refreshToken, err := env.Service.ExchangeAuthorizationCode(ctx, code, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+	subject, err := env.DB.GetRefreshTokenOwner(t.Context(), refreshToken)
+	if err != nil {
+		t.Fatalf("GetRefreshTokenOwner failed: %v", err)
+	}
+	sessions, err := env.Service.ListSessions(t.Context(), subject)
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(sessions))
+	}
+	if sessions[0].IP != client.IP || len(sessions[0].UserAgent) != 256 {
+		t.Errorf("session = %q/%d bytes, want %q/256 bytes", sessions[0].IP, len(sessions[0].UserAgent), client.IP)
+	}
+}
+
+// gatedSigner signs only once its gate is opened, reporting each signature
+// as it starts.
+type gatedSigner struct {
+	key     *ecdsa.PrivateKey
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (s gatedSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s gatedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.started <- struct{}{}
+	<-s.gate
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestRefreshAccessToken_SignerBusy(t *testing.T) {
+	t.Parallel()
+	signer := gatedSigner{started: make(chan struct{}, 4), gate: make(chan struct{})}
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		signer.key = options.TokenServerOpts.SigningKey
+		options.TokenServerOpts.Signer = signer
+		options.TokenServerOpts.SignWorkers = 1
+	})
+	env.RegisterTestUser(t, "alice", "password")
+	first := env.StoreTestRefreshToken(t, "alice", []string{"test.consent.local"})
+	second := env.StoreTestRefreshToken(t, "alice", []string{"test.consent.local"})
+
+	// while the only signer is busy, refreshes fail fast as retryable
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := env.Service.RefreshAccessToken(t.Context(), first.Encoded(), service.ClientCredentials{})
+		done <- err
+	}()
+	<-signer.started
+	_, _, err := env.Service.RefreshAccessToken(t.Context(), second.Encoded(), service.ClientCredentials{})
+	if !errors.Is(err, service.ErrBusy) {
+		t.Fatalf("RefreshAccessToken err = %v, want ErrBusy", err)
+	}
+
+	close(signer.gate)
+	if err := <-done; err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+
+	// the refused token wasn't spent, so a retry succeeds
+	if _, _, err := env.Service.RefreshAccessToken(t.Context(), second.Encoded(), service.ClientCredentials{}); err != nil {
+		t.Fatalf("RefreshAccessToken retry failed: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// ClientCredentials identify the integration redeeming an authorization code
+// or refresh token. Integrations without a secret are public clients and may
+// present no credentials at all.
+type ClientCredentials struct {
+	ID     string
+	Secret string
+}
+
+// RotateIntegrationSecret generates a new client secret for an integration,
+// replacing any existing one. Only a hash is stored, so the returned secret
+// cannot be retrieved again.
+func (s *Service) RotateIntegrationSecret(
+	ctx context.Context,
+	name string,
+) (
+	string,
+	error,
+) {
+	if err := s.writable(); err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", ErrInvalidIntegration
+	}
+	if name == InternalIntegrationName {
+		return "", ErrIntegrationProtected
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to generate client secret: %v", ErrInternal, err)
+	}
+
+	if err := s.store.SetIntegrationSecret(ctx, name, hashSecret(secret)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %s", ErrIntegrationNotFound, name)
+		}
+		return "", fmt.Errorf("%w: failed to store client secret: %v", ErrInternal, err)
+	}
+
+	return secret, nil
+}
+
+// RemoveIntegrationSecret makes an integration a public client again.
+func (s *Service) RemoveIntegrationSecret(
+	ctx context.Context,
+	name string,
+) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if name == "" {
+		return ErrInvalidIntegration
+	}
+	if name == InternalIntegrationName {
+		return ErrIntegrationProtected
+	}
+
+	if err := s.store.SetIntegrationSecret(ctx, name, ""); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrIntegrationNotFound, name)
+		}
+		return fmt.Errorf("%w: failed to remove client secret: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// authenticateClient checks the credentials presented when redeeming token
+// and returns the integration it was issued to, or nil if it matches none.
+// A presented client ID must name an integration in the token's audience.
+// Integrations with a secret must always present their ID and secret.
+func (s *Service) authenticateClient(
+	ctx context.Context,
+	token *tokens.RefreshToken,
+	client ClientCredentials,
+) (
+	*Integration,
+	error,
+) {
+	var integration *Integration
+	if client.ID != "" {
+		named, err := s.GetIntegration(ctx, client.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(token.Audience(), named.Audience) {
+			return nil, fmt.Errorf("%w: token was not issued to %s", ErrInvalidIntegration, client.ID)
+		}
+		integration = named
+	} else {
+		issuedTo, err := s.integrationForAudience(ctx, token.Audience())
+		if err != nil {
+			return nil, err
+		}
+		integration = issuedTo
+	}
+
+	if integration == nil {
+		return nil, nil
+	}
+	if err := s.checkClientSecret(ctx, integration, client); err != nil {
+		return nil, err
+	}
+	return integration, nil
+}
+
+// checkClientSecret verifies the secret of confidential integrations. Public
+// integrations need no secret.
+func (s *Service) checkClientSecret(
+	ctx context.Context,
+	integration *Integration,
+	client ClientCredentials,
+) error {
+	if !integration.HasSecret {
+		return nil
+	}
+	if client.ID == "" || client.Secret == "" {
+		return fmt.Errorf("%w: %s requires client authentication", ErrInvalidClient, integration.Name)
+	}
+
+	secretHash, err := s.store.GetIntegrationSecret(ctx, integration.Name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to get client secret: %v", ErrInternal, err)
+	}
+	if !tokens.SecureCompare(secretHash, hashSecret(client.Secret)) {
+		return ErrInvalidClient
+	}
+	return nil
+}
+
+// integrationForAudience finds the integration a token was issued to. Tokens
+// list the integration's audience first, so audiences are matched in order.
+func (s *Service) integrationForAudience(
+	ctx context.Context,
+	audience []string,
+) (
+	*Integration,
+	error,
+) {
+	integrations, err := s.store.ListIntegrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list integrations: %v", ErrInternal, err)
+	}
+
+	for _, aud := range audience {
+		for _, integration := range integrations {
+			if integration.Audience == aud {
+				return &integration, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,117 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestRotateIntegrationSecret_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	if secret == "" {
+		t.Fatal("expected non-empty secret")
+	}
+
+	// only a hash is stored
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if !integration.HasSecret {
+		t.Error("expected HasSecret after rotation")
+	}
+	stored, err := env.DB.GetIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegrationSecret failed: %v", err)
+	}
+	if stored == "" || stored == secret {
+		t.Errorf("stored secret = %q, want a hash", stored)
+	}
+
+	// rotating again issues a different secret
+	rotated, err := env.Service.RotateIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	if rotated == secret {
+		t.Error("expected a new secret")
+	}
+}
+
+func TestRotateIntegrationSecret_Errors(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	if _, err := env.Service.RotateIntegrationSecret(t.Context(), "missing"); !errors.Is(err, service.ErrIntegrationNotFound) {
+		t.Errorf("expected ErrIntegrationNotFound, got %v", err)
+	}
+	if _, err := env.Service.RotateIntegrationSecret(t.Context(), service.InternalIntegrationName); !errors.Is(err, service.ErrIntegrationProtected) {
+		t.Errorf("expected ErrIntegrationProtected, got %v", err)
+	}
+	if err := env.Service.RemoveIntegrationSecret(t.Context(), "missing"); !errors.Is(err, service.ErrIntegrationNotFound) {
+		t.Errorf("expected ErrIntegrationNotFound, got %v", err)
+	}
+}
+
+func TestRefreshAccessToken_ConfidentialClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"aud-a"})
+
+	// missing or wrong credentials are rejected without consuming the token
+	rejected := []service.ClientCredentials{
+		{},
+		{ID: "svc-a"},
+		{ID: "svc-a", Secret: "wrong"},
+	}
+	for _, client := range rejected {
+		_, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), client)
+		if !errors.Is(err, service.ErrInvalidClient) {
+			t.Errorf("credentials %+v: expected ErrInvalidClient, got %v", client, err)
+		}
+	}
+
+	// the right secret redeems the token
+	_, refreshToken, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{ID: "svc-a", Secret: secret})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+
+	// once the secret is removed the integration is a public client again
+	if err := env.Service.RemoveIntegrationSecret(t.Context(), "svc-a"); err != nil {
+		t.Fatalf("RemoveIntegrationSecret failed: %v", err)
+	}
+	if _, _, err := env.Service.RefreshAccessToken(t.Context(), refreshToken, service.ClientCredentials{}); err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+}
+
+func TestExchangeAuthorizationCode_WrongClient(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	env.CreateTestIntegration(t, "svc-b", "Service B", "aud-b", "https://svc-b.test/callback")
+	code := env.IssueTestAuthorizationCode(t, "alice", "svc-a", []string{service.ScopeIdentity})
+
+	// a code issued to one integration can't be redeemed by another
+	_, _, err := env.Service.ExchangeAuthorizationCode(t.Context(), code, service.ClientCredentials{ID: "svc-b"})
+	if !errors.Is(err, service.ErrInvalidIntegration) {
+		t.Errorf("expected ErrInvalidIntegration, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// Authorization code lifetimes. The default applies unless the deployment or
+// an integration's policy sets its own; RFC 6749 recommends at most ten
+// minutes.
+const (
+	AuthorizationCodeLifetime    = 10 * time.Second
+	MaxAuthorizationCodeLifetime = 10 * time.Minute
+)
+
+// AuthorizationCode is a single-use code the user's browser carries back to
+// an integration's redirect, which the integration exchanges server-side for
+// tokens. Only a hash of the code is stored. AuthTime is when the user last
+// entered their credentials, or zero if unknown.
+type AuthorizationCode struct {
+	CodeHash    string
+	Subject     string
+	Integration string
+	Scopes      []string
+	ExpiresAt   time.Time
+	SessionOnly bool
+	AuthTime    time.Time
+}
+
+// ExchangeAuthorizationCode redeems an authorization code for a token pair.
+// Codes are consumed by the first attempt, successful or not. When client.ID
+// is set, the code must have been issued to that integration, and
+// confidential integrations must authenticate with their secret.
+func (s *Service) ExchangeAuthorizationCode(
+	ctx context.Context,
+	code string,
+	client ClientCredentials,
+) (
+	string,
+	string,
+	error,
+) {
+	record, integration, err := s.redeemAuthorizationCode(ctx, code, client)
+	if err != nil {
+		return "", "", err
+	}
+
+	// the consent app's own tokens are not for the consent API audience
+	audience := []string{integration.Audience}
+	if integration.Name != InternalIntegrationName {
+		audience = append(audience, s.consentAPIAudience)
+	}
+
+	return s.issueTokenPair(ctx, record.Subject, audience, record.Scopes, integration.Policy, tokens.IssueOptions{
+		AuthTime:    record.AuthTime,
+		SessionOnly: record.SessionOnly,
+	})
+}
+
+// redeemAuthorizationCode consumes code and returns it with the integration it
+// was issued to, once client is allowed to redeem it.
+func (s *Service) redeemAuthorizationCode(
+	ctx context.Context,
+	code string,
+	client ClientCredentials,
+) (
+	*AuthorizationCode,
+	*Integration,
+	error,
+) {
+	if code == "" {
+		return nil, nil, ErrInvalidAuthCode
+	}
+
+	record, err := s.tokenStore.ConsumeAuthorizationCode(ctx, hashSecret(code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrInvalidAuthCode
+		}
+		return nil, nil, fmt.Errorf("%w: failed to consume authorization code: %v", ErrInternal, err)
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, nil, ErrAuthCodeExpired
+	}
+
+	integration, err := s.GetIntegration(ctx, record.Integration)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client.ID != "" && client.ID != integration.Name {
+		return nil, nil, fmt.Errorf("%w: authorization code was not issued to %s", ErrInvalidIntegration, client.ID)
+	}
+	if err := s.checkClientSecret(ctx, integration, client); err != nil {
+		return nil, nil, err
+	}
+	return record, integration, nil
+}
+
+// issueAuthorizationCode stores a new code for subject and returns it. The
+// tokens it is exchanged for are issued with options.
+func (s *Service) issueAuthorizationCode(
+	ctx context.Context,
+	subject string,
+	integration *Integration,
+	scopes []string,
+	options tokens.IssueOptions,
+) (
+	string,
+	error,
+) {
+	user, err := s.activeUser(ctx, subject)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkTerms(user, integration); err != nil {
+		return "", err
+	}
+	code, err := generateSecret()
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to generate authorization code: %v", ErrInternal, err)
+	}
+
+	err = s.tokenStore.InsertAuthorizationCode(ctx, &AuthorizationCode{
+		CodeHash:    hashSecret(code),
+		Subject:     subject,
+		Integration: integration.Name,
+		Scopes:      scopes,
+		ExpiresAt:   time.Now().Add(s.authCodeLifetime(integration.Policy)),
+		SessionOnly: options.SessionOnly,
+		AuthTime:    options.AuthTime,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %s", ErrUserNotFound, subject)
+		}
+		return "", fmt.Errorf("%w: failed to store authorization code: %v", ErrInternal, err)
+	}
+
+	return code, nil
+}
+
+// authCodeLifetime returns how long codes issued under policy stay valid: the
+// policy's lifetime if set, otherwise the deployment's.
+func (s *Service) authCodeLifetime(
+	policy IntegrationPolicy,
+) time.Duration {
+	if policy.AuthCodeLifetime > 0 {
+		return policy.AuthCodeLifetime
+	}
+	return s.defaultAuthCodeLifetime
+}
+
+func validateAuthCodeLifetime(
+	lifetime time.Duration,
+) error {
+	if lifetime < 0 {
+		return errors.New("auth code lifetime cannot be negative")
+	}
+	if lifetime > MaxAuthorizationCodeLifetime {
+		return fmt.Errorf("auth code lifetime cannot exceed %s", MaxAuthorizationCodeLifetime)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestExchangeAuthorizationCode_Expired(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	err = env.DB.InsertAuthorizationCode(t.Context(), &service.AuthorizationCode{
+		CodeHash:    hashCode("expired-code"),
+		Subject:     user.Subject,
+		Integration: service.InternalIntegrationName,
+		ExpiresAt:   time.Now().Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("InsertAuthorizationCode failed: %v", err)
+	}
+
+	// expiry is reported distinctly from an unknown code
+	_, _, err = env.Service.ExchangeAuthorizationCode(t.Context(), "expired-code", service.ClientCredentials{})
+	if !errors.Is(err, service.ErrAuthCodeExpired) {
+		t.Errorf("expected ErrAuthCodeExpired, got %v", err)
+	}
+	if errors.Is(err, service.ErrInvalidAuthCode) {
+		t.Errorf("expired code should not be reported as ErrInvalidAuthCode")
+	}
+}
+
+func TestIssueAuthorizationCode_DefaultLifetime(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+
+	issuedAt := time.Now()
+	code := env.IssueTestAuthorizationCode(t, "alice", "svc-a", []string{service.ScopeIdentity})
+
+	record, err := env.DB.ConsumeAuthorizationCode(t.Context(), hashCode(code))
+	if err != nil {
+		t.Fatalf("ConsumeAuthorizationCode failed: %v", err)
+	}
+	lifetime := record.ExpiresAt.Sub(issuedAt)
+	if lifetime < service.AuthorizationCodeLifetime-time.Second || lifetime > service.AuthorizationCodeLifetime+time.Second {
+		t.Errorf("code lifetime = %v, want about %v", lifetime, service.AuthorizationCodeLifetime)
+	}
+}
+
+func TestIssueAuthorizationCode_IntegrationLifetime(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	err := env.Service.CreateIntegrationWithPolicy(
+		t.Context(),
+		"svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil,
+		service.IntegrationPolicy{AuthCodeLifetime: 2 * time.Minute},
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegrationWithPolicy failed: %v", err)
+	}
+
+	issuedAt := time.Now()
+	code := env.IssueTestAuthorizationCode(t, "alice", "svc-a", []string{service.ScopeIdentity})
+
+	record, err := env.DB.ConsumeAuthorizationCode(t.Context(), hashCode(code))
+	if err != nil {
+		t.Fatalf("ConsumeAuthorizationCode failed: %v", err)
+	}
+	lifetime := record.ExpiresAt.Sub(issuedAt)
+	if lifetime < 2*time.Minute-time.Second || lifetime > 2*time.Minute+time.Second {
+		t.Errorf("code lifetime = %v, want about 2m", lifetime)
+	}
+}
+
+func TestNew_RejectsInvalidAuthCodeLifetime(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	_, err := service.New(service.Options{
+		Store:            store,
+		AuthCodeLifetime: time.Hour,
+	})
+	if err == nil {
+		t.Fatal("expected error for auth code lifetime above the maximum")
+	}
+}
+
+func TestNew_RejectsInvalidTokenLifetimes(t *testing.T) {
+	t.Parallel()
+	store := testutil.SetupTestDB(t)
+
+	_, err := service.New(service.Options{
+		Store:                store,
+		AccessTokenLifetime:  2 * time.Hour,
+		RefreshTokenLifetime: time.Hour,
+	})
+	if err == nil {
+		t.Fatal("expected error for access token lifetime above refresh token lifetime")
+	}
+}
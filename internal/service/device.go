@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+const (
+	deviceCodeLifetime     = 10 * time.Minute
+	deviceCodePollInterval = 5 * time.Second
+	deviceCodeSlowDownStep = 5 * time.Second
+	deviceUserCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	deviceUserCodeLength   = 8
+	deviceUserCodeAttempts = 8
+)
+
+// DeviceAuthorizationStatus tracks the user's decision on a device request.
+type DeviceAuthorizationStatus string
+
+const (
+	DeviceAuthorizationPending  DeviceAuthorizationStatus = "pending"
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	DeviceAuthorizationDenied   DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceAuthorization is a stored device authorization request (RFC 8628).
+// Only a hash of the device code is persisted. AuthTime is when the user who
+// approved it last entered their credentials; the device's tokens inherit it.
+type DeviceAuthorization struct {
+	DeviceCodeHash string
+	UserCode       string
+	Integration    string
+	Scopes         []string
+	Status         DeviceAuthorizationStatus
+	Subject        string
+	ExpiresAt      time.Time
+	Interval       time.Duration
+	LastPolledAt   time.Time
+	AuthTime       time.Time
+}
+
+// DeviceCodeGrant is returned to a device starting the device flow.
+type DeviceCodeGrant struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+	Interval                time.Duration
+}
+
+// DeviceAuthorizationReview pairs a pending device request with a review of
+// its scopes against the subject's existing grants.
+type DeviceAuthorizationReview struct {
+	UserCode string
+	Review   *AuthorizationReview
+}
+
+// StartDeviceAuthorization validates a device request for an integration and
+// issues a device code for polling and a user code for the verification page.
+func (s *Service) StartDeviceAuthorization(
+	ctx context.Context,
+	integrationName string,
+	requestedScopes []string,
+) (
+	*DeviceCodeGrant,
+	error,
+) {
+	integration, err := s.GetIntegration(ctx, integrationName)
+	if err != nil {
+		return nil, err
+	}
+	if integration.Name == InternalIntegrationName {
+		return nil, ErrInvalidIntegration
+	}
+
+	scopes, err := validateRequestedScopes(requestedScopes)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := generateDeviceCode()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to generate device code: %v", ErrInternal, err)
+	}
+
+	for attempt := 0; attempt < deviceUserCodeAttempts; attempt++ {
+		userCode, err := generateUserCode()
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate user code: %v", ErrInternal, err)
+		}
+
+		err = s.tokenStore.InsertDeviceAuthorization(ctx, &DeviceAuthorization{
+			DeviceCodeHash: hashSecret(deviceCode),
+			UserCode:       userCode,
+			Integration:    integration.Name,
+			Scopes:         scopes,
+			Status:         DeviceAuthorizationPending,
+			ExpiresAt:      time.Now().Add(deviceCodeLifetime),
+			Interval:       deviceCodePollInterval,
+		})
+		if err != nil {
+			if isUniqueConstraintError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("%w: failed to store device authorization: %v", ErrInternal, err)
+		}
+
+		verificationURI := s.publicURL + "/device"
+		return &DeviceCodeGrant{
+			DeviceCode:              deviceCode,
+			UserCode:                userCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+			ExpiresIn:               deviceCodeLifetime,
+			Interval:                deviceCodePollInterval,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: no free user code", ErrInternal)
+}
+
+// ReviewDeviceAuthorization looks up a pending device request by user code and
+// reviews its scopes for the subject.
+func (s *Service) ReviewDeviceAuthorization(
+	ctx context.Context,
+	subject string,
+	userCode string,
+) (
+	*DeviceAuthorizationReview,
+	error,
+) {
+	authorization, err := s.getPendingDeviceAuthorization(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+
+	review, err := s.reviewScopes(ctx, subject, authorization.Integration, authorization.Scopes, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceAuthorizationReview{
+		UserCode: authorization.UserCode,
+		Review:   review,
+	}, nil
+}
+
+// ApproveDeviceAuthorization stores any missing grants and lets the device
+// redeem its device code for tokens. authTime is when the user last entered
+// their credentials, as carried by their consent session; the device's
+// tokens inherit it.
+func (s *Service) ApproveDeviceAuthorization(
+	ctx context.Context,
+	subject string,
+	authTime time.Time,
+	userCode string,
+) error {
+	review, err := s.ReviewDeviceAuthorization(ctx, subject, userCode)
+	if err != nil {
+		return err
+	}
+	user, err := s.activeUser(ctx, subject)
+	if err != nil {
+		return err
+	}
+	if err := s.checkTerms(user, &review.Review.Request.Integration); err != nil {
+		return err
+	}
+
+	missingScopeNames := scopeNames(review.Review.MissingScopes)
+	if err := s.store.InsertGrants(ctx,
+		subject,
+		review.Review.Request.Integration.Name,
+		missingScopeNames,
+	); err != nil {
+		return fmt.Errorf("%w: failed to store grants: %v", ErrInternal, err)
+	}
+
+	return s.decideDeviceAuthorization(ctx, review.UserCode, subject, DeviceAuthorizationApproved, authTime)
+}
+
+// DenyDeviceAuthorization rejects a pending device request.
+func (s *Service) DenyDeviceAuthorization(
+	ctx context.Context,
+	subject string,
+	userCode string,
+) error {
+	authorization, err := s.getPendingDeviceAuthorization(ctx, userCode)
+	if err != nil {
+		return err
+	}
+
+	return s.decideDeviceAuthorization(ctx, authorization.UserCode, subject, DeviceAuthorizationDenied, time.Time{})
+}
+
+// PollDeviceAuthorization is called by the device until the user decides.
+// It returns ErrAuthorizationPending or ErrSlowDown while waiting, and an
+// access and refresh token once approved. Each ErrSlowDown adds five seconds
+// to the interval the device must keep between polls. Device codes are
+// single-use. Devices of confidential integrations must authenticate with
+// client on every poll, as for an authorization code.
+func (s *Service) PollDeviceAuthorization(
+	ctx context.Context,
+	deviceCode string,
+	client ClientCredentials,
+) (
+	string,
+	string,
+	error,
+) {
+	deviceCodeHash := hashSecret(deviceCode)
+	authorization, err := s.tokenStore.GetDeviceAuthorization(ctx, deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", ErrDeviceCodeNotFound
+		}
+		return "", "", fmt.Errorf("%w: failed to get device authorization: %v", ErrInternal, err)
+	}
+
+	now := time.Now()
+	if now.After(authorization.ExpiresAt) {
+		_, _ = s.tokenStore.DeleteDeviceAuthorization(ctx, deviceCodeHash)
+		return "", "", ErrDeviceCodeExpired
+	}
+
+	integration, err := s.GetIntegration(ctx, authorization.Integration)
+	if err != nil {
+		return "", "", err
+	}
+	if client.ID != "" && client.ID != integration.Name {
+		return "", "", fmt.Errorf("%w: device code was not issued to %s", ErrInvalidIntegration, client.ID)
+	}
+	if err := s.checkClientSecret(ctx, integration, client); err != nil {
+		return "", "", err
+	}
+
+	switch authorization.Status {
+	case DeviceAuthorizationPending:
+		// a device polling too fast must wait 5s longer from now on (RFC
+		// 8628 §3.5)
+		interval := authorization.Interval
+		tooFast := !authorization.LastPolledAt.IsZero() && now.Sub(authorization.LastPolledAt) < interval
+		if tooFast {
+			interval += deviceCodeSlowDownStep
+		}
+		if err := s.tokenStore.UpdateDeviceAuthorizationPoll(ctx, deviceCodeHash, now, interval); err != nil {
+			return "", "", fmt.Errorf("%w: failed to record device poll: %v", ErrInternal, err)
+		}
+		if tooFast {
+			return "", "", ErrSlowDown
+		}
+		return "", "", ErrAuthorizationPending
+
+	case DeviceAuthorizationDenied:
+		_, _ = s.tokenStore.DeleteDeviceAuthorization(ctx, deviceCodeHash)
+		return "", "", ErrAuthorizationDenied
+
+	case DeviceAuthorizationApproved:
+		deleted, err := s.tokenStore.DeleteDeviceAuthorization(ctx, deviceCodeHash)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: failed to consume device code: %v", ErrInternal, err)
+		}
+		if !deleted {
+			return "", "", ErrDeviceCodeNotFound
+		}
+
+		return s.issueTokenPair(
+			ctx,
+			authorization.Subject,
+			[]string{integration.Audience, s.consentAPIAudience},
+			authorization.Scopes,
+			integration.Policy,
+			tokens.IssueOptions{AuthTime: authorization.AuthTime},
+		)
+
+	default:
+		return "", "", fmt.Errorf("%w: unknown device authorization status %q", ErrInternal, authorization.Status)
+	}
+}
+
+func (s *Service) getPendingDeviceAuthorization(
+	ctx context.Context,
+	userCode string,
+) (
+	*DeviceAuthorization,
+	error,
+) {
+	authorization, err := s.tokenStore.GetDeviceAuthorizationByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return nil, fmt.Errorf("%w: failed to get device authorization: %v", ErrInternal, err)
+	}
+	if time.Now().After(authorization.ExpiresAt) {
+		return nil, ErrDeviceCodeExpired
+	}
+	if authorization.Status != DeviceAuthorizationPending {
+		return nil, ErrDeviceCodeNotFound
+	}
+	return authorization, nil
+}
+
+func (s *Service) decideDeviceAuthorization(
+	ctx context.Context,
+	userCode string,
+	subject string,
+	status DeviceAuthorizationStatus,
+	authTime time.Time,
+) error {
+	err := s.tokenStore.DecideDeviceAuthorization(ctx, userCode, subject, status, authTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDeviceCodeNotFound
+		}
+		return fmt.Errorf("%w: failed to record device decision: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+func generateDeviceCode() (
+	string,
+	error,
+) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// generateUserCode returns a code like "BCDF-GHJK" drawn from consonants so
+// it is easy to type and cannot spell words.
+func generateUserCode() (
+	string,
+	error,
+) {
+	alphabetLen := byte(len(deviceUserCodeAlphabet))
+	limit := 255 - (255 % alphabetLen)
+
+	code := make([]byte, 0, deviceUserCodeLength)
+	buf := make([]byte, 1)
+	for len(code) < deviceUserCodeLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		if buf[0] >= limit {
+			continue
+		}
+		code = append(code, deviceUserCodeAlphabet[buf[0]%alphabetLen])
+	}
+
+	half := deviceUserCodeLength / 2
+	return string(code[:half]) + "-" + string(code[half:]), nil
+}
+
+// normalizeUserCode accepts user input with any case, spacing, or dashes.
+func normalizeUserCode(
+	userCode string,
+) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(userCode) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	code := b.String()
+	if len(code) != deviceUserCodeLength {
+		return code
+	}
+	half := deviceUserCodeLength / 2
+	return code[:half] + "-" + code[half:]
+}
@@ -0,0 +1,183 @@
+package service_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func setupDeviceEnv(t *testing.T) (*testutil.TestEnv, string) {
+	t.Helper()
+	env := testutil.SetupTestEnv(t)
+	env.CreateTestIntegration(t, "test-integration", "Test Integration", "test-audience", "http://localhost:8080/callback")
+	env.RegisterTestUser(t, "alice", "password")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	return env, alice.Subject
+}
+
+func TestStartDeviceAuthorization_IssuesCodes(t *testing.T) {
+	t.Parallel()
+	env, _ := setupDeviceEnv(t)
+
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+	if grant.DeviceCode == "" {
+		t.Fatal("expected device code")
+	}
+	if len(grant.UserCode) != 9 || grant.UserCode[4] != '-' {
+		t.Fatalf("user code = %q, want XXXX-XXXX", grant.UserCode)
+	}
+	if grant.VerificationURI != "https://consent.test/device" {
+		t.Fatalf("verification URI = %q, want https://consent.test/device", grant.VerificationURI)
+	}
+	if grant.VerificationURIComplete != "https://consent.test/device?user_code="+grant.UserCode {
+		t.Fatalf("verification URI complete = %q", grant.VerificationURIComplete)
+	}
+}
+
+func TestStartDeviceAuthorization_RejectsInvalidRequest(t *testing.T) {
+	t.Parallel()
+	env, _ := setupDeviceEnv(t)
+
+	if _, err := env.Service.StartDeviceAuthorization(t.Context(), "missing", []string{"identity"}); !errors.Is(err, service.ErrIntegrationNotFound) {
+		t.Fatalf("expected ErrIntegrationNotFound, got %v", err)
+	}
+	if _, err := env.Service.StartDeviceAuthorization(t.Context(), service.InternalIntegrationName, []string{"identity"}); !errors.Is(err, service.ErrInvalidIntegration) {
+		t.Fatalf("expected ErrInvalidIntegration, got %v", err)
+	}
+	if _, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"profile"}); !errors.Is(err, service.ErrIdentityScopeRequired) {
+		t.Fatalf("expected ErrIdentityScopeRequired, got %v", err)
+	}
+}
+
+func TestPollDeviceAuthorization_ApprovedIssuesTokensOnce(t *testing.T) {
+	t.Parallel()
+	env, subject := setupDeviceEnv(t)
+
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity", "profile"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+
+	// device polls before approval
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); !errors.Is(err, service.ErrAuthorizationPending) {
+		t.Fatalf("expected ErrAuthorizationPending, got %v", err)
+	}
+
+	// polling again immediately is too fast
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); !errors.Is(err, service.ErrSlowDown) {
+		t.Fatalf("expected ErrSlowDown, got %v", err)
+	}
+
+	// user approves with a lowercase, undashed code
+	userCode := strings.ToLower(grant.UserCode[:4] + grant.UserCode[5:])
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := env.Service.ApproveDeviceAuthorization(t.Context(), subject, authTime, userCode); err != nil {
+		t.Fatalf("ApproveDeviceAuthorization failed: %v", err)
+	}
+
+	accessToken, refreshToken, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("PollDeviceAuthorization failed: %v", err)
+	}
+	if refreshToken == "" {
+		t.Fatal("expected refresh token")
+	}
+	token := new(tokens.AccessToken)
+	if err := token.Decode(accessToken, env.TokenValidator); err != nil {
+		t.Fatalf("access token decode failed: %v", err)
+	}
+	if token.Subject() != subject {
+		t.Fatalf("subject = %q, want %q", token.Subject(), subject)
+	}
+	if !token.AuthTime().Equal(authTime) {
+		t.Fatalf("auth time = %v, want %v, when the approving user signed in", token.AuthTime(), authTime)
+	}
+
+	// grants were recorded for the integration
+	scopes, err := env.DB.ListGrantedScopeNames(t.Context(), subject, "test-integration")
+	if err != nil {
+		t.Fatalf("ListGrantedScopeNames failed: %v", err)
+	}
+	if len(scopes) != 2 {
+		t.Fatalf("granted scopes = %v, want identity and profile", scopes)
+	}
+
+	// the device code cannot be redeemed twice
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); !errors.Is(err, service.ErrDeviceCodeNotFound) {
+		t.Fatalf("expected ErrDeviceCodeNotFound, got %v", err)
+	}
+}
+
+func TestPollDeviceAuthorization_SlowDownRaisesInterval(t *testing.T) {
+	t.Parallel()
+	env, _ := setupDeviceEnv(t)
+
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+	env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{})
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); !errors.Is(err, service.ErrSlowDown) {
+		t.Fatalf("expected ErrSlowDown, got %v", err)
+	}
+
+	// the interval grew by five seconds
+	authorization, err := env.DB.GetDeviceAuthorizationByUserCode(t.Context(), grant.UserCode)
+	if err != nil {
+		t.Fatalf("GetDeviceAuthorizationByUserCode failed: %v", err)
+	}
+	if want := grant.Interval + 5*time.Second; authorization.Interval != want {
+		t.Fatalf("interval = %v, want %v", authorization.Interval, want)
+	}
+
+	// so waiting only the old interval is still too fast
+	polledAt := time.Now().Add(-grant.Interval - time.Second)
+	if err := env.DB.UpdateDeviceAuthorizationPoll(t.Context(), authorization.DeviceCodeHash, polledAt, authorization.Interval); err != nil {
+		t.Fatalf("UpdateDeviceAuthorizationPoll failed: %v", err)
+	}
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); !errors.Is(err, service.ErrSlowDown) {
+		t.Fatalf("expected ErrSlowDown after the old interval, got %v", err)
+	}
+}
+
+func TestPollDeviceAuthorization_Denied(t *testing.T) {
+	t.Parallel()
+	env, subject := setupDeviceEnv(t)
+
+	grant, err := env.Service.StartDeviceAuthorization(t.Context(), "test-integration", []string{"identity"})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization failed: %v", err)
+	}
+	if err := env.Service.DenyDeviceAuthorization(t.Context(), subject, grant.UserCode); err != nil {
+		t.Fatalf("DenyDeviceAuthorization failed: %v", err)
+	}
+
+	if _, _, err := env.Service.PollDeviceAuthorization(t.Context(), grant.DeviceCode, service.ClientCredentials{}); !errors.Is(err, service.ErrAuthorizationDenied) {
+		t.Fatalf("expected ErrAuthorizationDenied, got %v", err)
+	}
+
+	// a decided request cannot be approved afterwards
+	if err := env.Service.ApproveDeviceAuthorization(t.Context(), subject, time.Now(), grant.UserCode); !errors.Is(err, service.ErrDeviceCodeNotFound) {
+		t.Fatalf("expected ErrDeviceCodeNotFound, got %v", err)
+	}
+}
+
+func TestReviewDeviceAuthorization_UnknownCode(t *testing.T) {
+	t.Parallel()
+	env, subject := setupDeviceEnv(t)
+
+	if _, err := env.Service.ReviewDeviceAuthorization(t.Context(), subject, "BCDF-GHJK"); !errors.Is(err, service.ErrDeviceCodeNotFound) {
+		t.Fatalf("expected ErrDeviceCodeNotFound, got %v", err)
+	}
+}
@@ -5,31 +5,54 @@ import (
 )
 
 var (
-	ErrInvalidCredentials     = errors.New("invalid credentials")
-	ErrAccountNotFound        = errors.New("account not found")
-	ErrIntegrationNotFound    = errors.New("integration not found")
-	ErrTokenInvalid           = errors.New("token invalid")
-	ErrTokenNotFound          = errors.New("token not found")
-	ErrInternal               = errors.New("internal error")
-	ErrHandleExists           = errors.New("handle already exists")
-	ErrInvalidHandle          = errors.New("invalid handle")
-	ErrInvalidUser            = errors.New("invalid user")
-	ErrUserNotFound           = errors.New("user not found")
-	ErrInvalidRole            = errors.New("invalid role")
-	ErrIntegrationExists      = errors.New("integration already exists")
-	ErrIntegrationProtected   = errors.New("integration is protected")
-	ErrInvalidIntegration     = errors.New("invalid integration")
-	ErrInvalidUrl             = errors.New("invalid URL")
-	ErrInvalidRedirect        = errors.New("invalid redirect URL")
-	ErrInvalidScope           = errors.New("invalid scope")
-	ErrMissingScope           = errors.New("missing scope")
-	ErrIdentityScopeRequired  = errors.New("identity scope required")
-	ErrInvalidScopeDependency = errors.New("invalid scope dependency")
-	ErrInsufficientScope      = errors.New("insufficient scope")
-	ErrAuthorizationDenied    = errors.New("authorization denied")
-	ErrRoleNotFound           = errors.New("role not found")
-	ErrRoleExists             = errors.New("role already exists")
-	ErrRoleProtected          = errors.New("role is protected")
-	ErrRoleInUse              = errors.New("role is in use")
-	ErrInvalidUpdate          = errors.New("invalid update")
+	ErrInvalidCredentials       = errors.New("invalid credentials")
+	ErrAccountNotFound          = errors.New("account not found")
+	ErrAccountDisabled          = errors.New("account disabled")
+	ErrAccountExpired           = errors.New("account expired")
+	ErrTermsNotAccepted         = errors.New("terms of service not accepted")
+	ErrTermsOutdated            = errors.New("terms of service have changed")
+	ErrIntegrationNotFound      = errors.New("integration not found")
+	ErrTokenInvalid             = errors.New("token invalid")
+	ErrTokenNotFound            = errors.New("token not found")
+	ErrInternal                 = errors.New("internal error")
+	ErrHandleExists             = errors.New("handle already exists")
+	ErrInvalidHandle            = errors.New("invalid handle")
+	ErrInvalidUser              = errors.New("invalid user")
+	ErrUserNotFound             = errors.New("user not found")
+	ErrInvalidRole              = errors.New("invalid role")
+	ErrIntegrationExists        = errors.New("integration already exists")
+	ErrIntegrationProtected     = errors.New("integration is protected")
+	ErrInvalidIntegration       = errors.New("invalid integration")
+	ErrInvalidClient            = errors.New("invalid client credentials")
+	ErrAuthCodeExpired          = errors.New("authorization code expired")
+	ErrInvalidAuthCode          = errors.New("invalid authorization code")
+	ErrInvalidUrl               = errors.New("invalid URL")
+	ErrInvalidRedirect          = errors.New("invalid redirect URL")
+	ErrInvalidScope             = errors.New("invalid scope")
+	ErrMissingScope             = errors.New("missing scope")
+	ErrIdentityScopeRequired    = errors.New("identity scope required")
+	ErrInvalidScopeDependency   = errors.New("invalid scope dependency")
+	ErrInsufficientScope        = errors.New("insufficient scope")
+	ErrAuthorizationDenied      = errors.New("authorization denied")
+	ErrRoleNotFound             = errors.New("role not found")
+	ErrRoleExists               = errors.New("role already exists")
+	ErrRoleProtected            = errors.New("role is protected")
+	ErrRoleInUse                = errors.New("role is in use")
+	ErrInvalidUpdate            = errors.New("invalid update")
+	ErrInvalidProfile           = errors.New("invalid profile")
+	ErrUpstreamNotFound         = errors.New("upstream provider not found")
+	ErrUpstreamFailed           = errors.New("upstream login failed")
+	ErrIdentityLinked           = errors.New("identity already linked to another account")
+	ErrIdentityLinkNotFound     = errors.New("identity link not found")
+	ErrDeviceCodeNotFound       = errors.New("device code not found")
+	ErrDeviceCodeExpired        = errors.New("device code expired")
+	ErrAuthorizationPending     = errors.New("authorization pending")
+	ErrSlowDown                 = errors.New("slow down")
+	ErrInvalidTarget            = errors.New("invalid exchange target")
+	ErrInvalidAudience          = errors.New("invalid audience")
+	ErrReauthenticationRequired = errors.New("reauthentication required")
+	ErrNotAdmin                 = errors.New("not an administrator")
+	ErrInvalidSnapshot          = errors.New("invalid snapshot")
+	ErrMaintenance              = errors.New("down for maintenance")
+	ErrBusy                     = errors.New("too busy")
 )
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+)
+
+// EventType names an identity event.
+type EventType string
+
+const (
+	// EventUserRegistered is emitted when an account is created, whether by
+	// an administrator, self-registration, or a first federated sign-in.
+	EventUserRegistered EventType = "user.registered"
+
+	// EventUserDeleted is emitted when an account is removed, by an
+	// administrator or by its owner. It carries the subject but not the
+	// handle.
+	EventUserDeleted EventType = "user.deleted"
+
+	// EventUserDisabled and EventUserEnabled are emitted when an
+	// administrator disables an account, or enables it again.
+	EventUserDisabled EventType = "user.disabled"
+	EventUserEnabled  EventType = "user.enabled"
+
+	// EventTermsAccepted is emitted when a user accepts the current terms
+	// of service.
+	EventTermsAccepted EventType = "terms.accepted"
+
+	// EventTokenRevoked is emitted when a refresh token is revoked.
+	EventTokenRevoked EventType = "token.revoked"
+
+	// EventLoginSucceeded is emitted when a user signs in to consent, with a
+	// password or through an upstream provider.
+	EventLoginSucceeded EventType = "login.succeeded"
+
+	// EventLoginFailed is emitted when a password sign-in is rejected.
+	EventLoginFailed EventType = "login.failed"
+)
+
+// EventTypes lists every event type the service emits.
+func EventTypes() []EventType {
+	return []EventType{
+		EventUserRegistered,
+		EventUserDeleted,
+		EventUserDisabled,
+		EventUserEnabled,
+		EventTermsAccepted,
+		EventTokenRevoked,
+		EventLoginSucceeded,
+		EventLoginFailed,
+	}
+}
+
+// Event is something that happened to an identity, for systems downstream of
+// consent to react to. ID is unique per event. Subject is empty when the
+// account is unknown, as for a failed sign-in with an unregistered handle.
+// Handle is set when the service has it to hand, and Integration when the
+// event happened while signing in to one. RequestID is the ID of the HTTP
+// request that caused the event, when there was one.
+type Event struct {
+	ID          string
+	Type        EventType
+	Time        time.Time
+	Subject     string
+	Handle      string
+	Integration string
+	Client      ClientInfo
+	RequestID   string
+}
+
+// emit fills in an event's ID, time, client, and request ID and hands it to
+// the event hook.
+func (s *Service) emit(
+	ctx context.Context,
+	event Event,
+) {
+	event.ID = newEventID()
+	event.Time = time.Now().UTC()
+	event.Client = clientInfoFrom(ctx)
+	event.RequestID = requestid.FromContext(ctx)
+	s.onEvent(ctx, event)
+}
+
+// emitLoginFailed reports a password sign-in rejected with err. Failures of
+// the service itself aren't the user's, so they aren't reported.
+func (s *Service) emitLoginFailed(
+	ctx context.Context,
+	handle string,
+	integrationName string,
+	err error,
+) {
+	if !errors.Is(err, ErrInvalidCredentials) &&
+		!errors.Is(err, ErrAccountNotFound) &&
+		!errors.Is(err, ErrAccountDisabled) &&
+		!errors.Is(err, ErrAccountExpired) {
+		return
+	}
+	event := Event{Type: EventLoginFailed, Handle: handle, Integration: integrationName}
+	if user, err := s.store.GetUserByHandle(ctx, handle); err == nil {
+		event.Subject = user.Subject
+	}
+	s.emit(ctx, event)
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// discardEvent is the event hook used when none is configured.
+func discardEvent(
+	context.Context,
+	Event,
+) {
+}
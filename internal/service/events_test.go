@@ -0,0 +1,63 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+	var events []service.Event
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.OnEvent = func(_ context.Context, event service.Event) {
+			events = append(events, event)
+		}
+	})
+
+	alice, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed: %v", err)
+	}
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "wrong", service.InternalIntegrationName); err == nil {
+		t.Fatal("GrantAuthCode with wrong password succeeded")
+	}
+	if _, err := env.Service.GrantAuthCode(t.Context(), "nobody", "wrong", service.InternalIntegrationName); err == nil {
+		t.Fatal("GrantAuthCode for unknown handle succeeded")
+	}
+	token := env.StoreTestRefreshToken(t, alice.Subject, []string{"test-audience"})
+	if err := env.Service.RevokeRefreshToken(t.Context(), token.Encoded()); err != nil {
+		t.Fatalf("RevokeRefreshToken failed: %v", err)
+	}
+	if err := env.Service.DeleteUser(t.Context(), alice.Subject); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	want := []service.Event{
+		{Type: service.EventUserRegistered, Subject: alice.Subject, Handle: "alice"},
+		{Type: service.EventLoginSucceeded, Subject: alice.Subject, Handle: "alice", Integration: service.InternalIntegrationName},
+		{Type: service.EventLoginFailed, Subject: alice.Subject, Handle: "alice", Integration: service.InternalIntegrationName},
+		{Type: service.EventLoginFailed, Handle: "nobody", Integration: service.InternalIntegrationName},
+		{Type: service.EventTokenRevoked, Subject: alice.Subject},
+		{Type: service.EventUserDeleted, Subject: alice.Subject},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	seen := make(map[string]bool)
+	for i, event := range events {
+		if event.ID == "" || seen[event.ID] || event.Time.IsZero() {
+			t.Errorf("event %d: id %q, time %v; want unique id and a time", i, event.ID, event.Time)
+		}
+		seen[event.ID] = true
+		event.ID, event.Time, event.Client = "", want[i].Time, service.ClientInfo{}
+		if event != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// ExchangeAccessToken trades an access token issued to one integration for an
+// access token for the same user at targetAudience, in the manner of RFC 8693
+// token exchange, so a backend can call another service on the user's behalf
+// without sharing refresh tokens.
+//
+// The caller must authenticate as the integration the token was issued to,
+// which must be confidential, and its policy must list targetAudience in
+// ExchangeAudiences. The new token is for targetAudience alone, carries only
+// the scopes the target allows, and expires no later than the original. No
+// refresh token is issued, and none at all until the user has accepted the
+// current terms of service.
+func (s *Service) ExchangeAccessToken(
+	ctx context.Context,
+	encodedAccessToken string,
+	targetAudience string,
+	client ClientCredentials,
+) (
+	string,
+	error,
+) {
+	token := new(tokens.AccessToken)
+	if err := token.Decode(encodedAccessToken, s.tokenValidator); err != nil {
+		return "", fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	source, err := s.integrationForAudience(ctx, token.Audience())
+	if err != nil {
+		return "", err
+	}
+	if source == nil || source.Name == InternalIntegrationName {
+		return "", fmt.Errorf("%w: token was not issued to an integration", ErrTokenInvalid)
+	}
+	if client.ID != source.Name {
+		return "", fmt.Errorf("%w: token exchange requires the client the token was issued to", ErrInvalidClient)
+	}
+	if !source.HasSecret {
+		return "", fmt.Errorf("%w: token exchange requires a confidential client", ErrInvalidClient)
+	}
+	if err := s.checkClientSecret(ctx, source, client); err != nil {
+		return "", err
+	}
+
+	if !slices.Contains(source.Policy.ExchangeAudiences, targetAudience) {
+		return "", fmt.Errorf("%w: %s may not exchange tokens for %q", ErrInvalidTarget, source.Name, targetAudience)
+	}
+	target, err := s.integrationForAudience(ctx, []string{targetAudience})
+	if err != nil {
+		return "", err
+	}
+	if target == nil || target.Name == InternalIntegrationName {
+		return "", fmt.Errorf("%w: no integration has audience %q", ErrInvalidTarget, targetAudience)
+	}
+
+	// narrow scopes to what the target may be granted
+	scopes := slices.DeleteFunc(slices.Clone(token.Scopes()), func(scope string) bool {
+		return !target.Policy.AllowsScope(scope)
+	})
+	if !slices.Contains(scopes, ScopeIdentity) {
+		return "", fmt.Errorf("%w: %s", ErrInsufficientScope, ScopeIdentity)
+	}
+
+	user, err := s.activeUser(ctx, token.Subject())
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkTerms(user, target); err != nil {
+		return "", err
+	}
+
+	lifetime := min(s.accessLifetime(target.Policy), time.Until(token.Expiration()))
+	exchanged, err := s.tokenIssuer.IssueAccessTokenWithOptions(token.Subject(), []string{target.Audience}, scopes, lifetime, tokens.IssueOptions{
+		AuthTime:     token.AuthTime(),
+		Actor:        token.Actor(),
+		TermsVersion: user.TermsVersion,
+	})
+	if err != nil {
+		return "", issueError("access token", err)
+	}
+	return exchanged.Encoded(), nil
+}
@@ -0,0 +1,181 @@
+package service_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// setupExchangeEnv registers alice and two integrations, with confidential
+// svc-a allowed to exchange tokens for svc-b's audience. It returns svc-a's
+// secret.
+func setupExchangeEnv(t *testing.T) (*testutil.TestEnv, string) {
+	t.Helper()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	env.CreateTestIntegration(t, "svc-b", "Service B", "aud-b", "https://svc-b.test/callback")
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	err = env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{ExchangeAudiences: []string{"aud-b"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	return env, secret
+}
+
+func TestExchangeAccessToken_Success(t *testing.T) {
+	t.Parallel()
+	env, secret := setupExchangeEnv(t)
+	original := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"aud-a", "test.consent.local"}, []string{service.ScopeIdentity, service.ScopeProfile})
+
+	encoded, err := env.Service.ExchangeAccessToken(t.Context(), original.Encoded(), "aud-b", service.ClientCredentials{ID: "svc-a", Secret: secret})
+	if err != nil {
+		t.Fatalf("ExchangeAccessToken failed: %v", err)
+	}
+
+	exchanged := new(tokens.AccessToken)
+	if err := exchanged.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if exchanged.Subject() != original.Subject() {
+		t.Errorf("Subject = %q, want %q", exchanged.Subject(), original.Subject())
+	}
+	if !slices.Equal(exchanged.Audience(), []string{"aud-b"}) {
+		t.Errorf("Audience = %v, want [aud-b]", exchanged.Audience())
+	}
+	if !slices.Equal(exchanged.Scopes(), original.Scopes()) {
+		t.Errorf("Scopes = %v, want %v", exchanged.Scopes(), original.Scopes())
+	}
+	if exchanged.Expiration().After(original.Expiration().Add(time.Second)) {
+		t.Errorf("exchanged token expires %v, after the original %v", exchanged.Expiration(), original.Expiration())
+	}
+}
+
+func TestExchangeAccessToken_NarrowsScopes(t *testing.T) {
+	t.Parallel()
+	env, secret := setupExchangeEnv(t)
+	err := env.Service.UpdateIntegration(t.Context(), "svc-b", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{AllowedScopes: []string{service.ScopeIdentity}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	original := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"aud-a"}, []string{service.ScopeIdentity, service.ScopeProfile})
+
+	encoded, err := env.Service.ExchangeAccessToken(t.Context(), original.Encoded(), "aud-b", service.ClientCredentials{ID: "svc-a", Secret: secret})
+	if err != nil {
+		t.Fatalf("ExchangeAccessToken failed: %v", err)
+	}
+	exchanged := new(tokens.AccessToken)
+	if err := exchanged.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !slices.Equal(exchanged.Scopes(), []string{service.ScopeIdentity}) {
+		t.Errorf("Scopes = %v, want [identity]", exchanged.Scopes())
+	}
+}
+
+func TestExchangeAccessToken_Rejects(t *testing.T) {
+	t.Parallel()
+	env, secret := setupExchangeEnv(t)
+	env.CreateTestIntegration(t, "svc-c", "Service C", "aud-c", "https://svc-c.test/callback")
+	token := env.IssueTestAccessToken(t, "alice", []string{"aud-a"}).Encoded()
+	credentials := service.ClientCredentials{ID: "svc-a", Secret: secret}
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		client   service.ClientCredentials
+		want     error
+	}{
+		{"garbage token", "not-a-token", "aud-b", credentials, service.ErrTokenInvalid},
+		{"no credentials", token, "aud-b", service.ClientCredentials{}, service.ErrInvalidClient},
+		{"wrong secret", token, "aud-b", service.ClientCredentials{ID: "svc-a", Secret: "wrong"}, service.ErrInvalidClient},
+		{"other client", token, "aud-b", service.ClientCredentials{ID: "svc-b"}, service.ErrInvalidClient},
+		{"audience not in policy", token, "aud-c", credentials, service.ErrInvalidTarget},
+		{"unknown audience", token, "aud-z", credentials, service.ErrInvalidTarget},
+	}
+	for _, tt := range tests {
+		_, err := env.Service.ExchangeAccessToken(t.Context(), tt.token, tt.audience, tt.client)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestExchangeAccessToken_RequiresConfidentialClient(t *testing.T) {
+	t.Parallel()
+	env, _ := setupExchangeEnv(t)
+	if err := env.Service.RemoveIntegrationSecret(t.Context(), "svc-a"); err != nil {
+		t.Fatalf("RemoveIntegrationSecret failed: %v", err)
+	}
+	token := env.IssueTestAccessToken(t, "alice", []string{"aud-a"}).Encoded()
+
+	_, err := env.Service.ExchangeAccessToken(t.Context(), token, "aud-b", service.ClientCredentials{ID: "svc-a"})
+	if !errors.Is(err, service.ErrInvalidClient) {
+		t.Errorf("err = %v, want ErrInvalidClient", err)
+	}
+}
+
+func TestExchangeAccessToken_RequiresCurrentTerms(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.Terms = service.Terms{Version: "2026-10", URL: "https://example.com/terms"}
+	})
+	env.RegisterTestUser(t, "alice", "password")
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	env.CreateTestIntegration(t, "svc-b", "Service B", "aud-b", "https://svc-b.test/callback")
+	secret, err := env.Service.RotateIntegrationSecret(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	err = env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{ExchangeAudiences: []string{"aud-b"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	client := service.ClientCredentials{ID: "svc-a", Secret: secret}
+
+	// alice accepted the terms before they changed, and holds a token from then
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	if err := env.DB.AcceptTerms(t.Context(), alice.Subject, "2025-01", time.Now()); err != nil {
+		t.Fatalf("AcceptTerms failed: %v", err)
+	}
+	original := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"aud-a"}, []string{service.ScopeIdentity})
+
+	_, err = env.Service.ExchangeAccessToken(t.Context(), original.Encoded(), "aud-b", client)
+	if !errors.Is(err, service.ErrTermsNotAccepted) {
+		t.Fatalf("expected ErrTermsNotAccepted, got %v", err)
+	}
+
+	// once she accepts the new terms, the exchanged token says so
+	if err := env.Service.AcceptTerms(t.Context(), alice.Subject, "2026-10"); err != nil {
+		t.Fatalf("AcceptTerms failed: %v", err)
+	}
+	encoded, err := env.Service.ExchangeAccessToken(t.Context(), original.Encoded(), "aud-b", client)
+	if err != nil {
+		t.Fatalf("ExchangeAccessToken failed: %v", err)
+	}
+	exchanged := new(tokens.AccessToken)
+	if err := exchanged.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if exchanged.TermsVersion() != "2026-10" {
+		t.Errorf("TermsVersion = %q, want 2026-10", exchanged.TermsVersion())
+	}
+}
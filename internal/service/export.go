@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format Export produces and
+// Import accepts.
+const SnapshotVersion = 1
+
+// Snapshot is everything needed to recreate a deployment's identities in
+// another store: roles, integrations with their client secret hashes, and
+// users with their password hashes, profiles, linked identities, and grants.
+// Sessions, pending authorizations, and API keys are left out; users sign in
+// again, and the new deployment is bootstrapped with its own API key.
+type Snapshot struct {
+	Version      int
+	ExportedAt   time.Time
+	Roles        []Role
+	Integrations []IntegrationSnapshot
+	Users        []UserSnapshot
+}
+
+// IntegrationSnapshot is an integration and its client secret hash, which is
+// empty for public clients.
+type IntegrationSnapshot struct {
+	Integration
+	SecretHash string
+}
+
+// UserSnapshot is a user and everything owned by them.
+type UserSnapshot struct {
+	User
+	PasswordHash       []byte
+	Profile            Profile
+	ExternalIdentities []ExternalIdentity
+	Grants             []Grant
+}
+
+// Grant is the scopes a user has granted one integration.
+type Grant struct {
+	Integration string
+	Scopes      []string
+}
+
+// ImportResult counts what Import created. Skipped names the roles,
+// integrations, and users (by handle) that already existed and were left
+// as they are.
+type ImportResult struct {
+	Roles        int
+	Integrations int
+	Users        int
+	Skipped      []string
+}
+
+// Export reads a snapshot of store. The system integration is included so
+// the snapshot is complete, but Import leaves the target's own alone.
+func Export(
+	ctx context.Context,
+	store Store,
+) (
+	*Snapshot,
+	error,
+) {
+	snapshot := &Snapshot{
+		Version:    SnapshotVersion,
+		ExportedAt: time.Now().UTC(),
+	}
+
+	roles, err := store.ListRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list roles: %v", ErrInternal, err)
+	}
+	snapshot.Roles = roles
+
+	integrations, err := store.ListIntegrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list integrations: %v", ErrInternal, err)
+	}
+	for _, integration := range integrations {
+		secretHash, err := store.GetIntegrationSecret(ctx, integration.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read secret of %s: %v", ErrInternal, integration.Name, err)
+		}
+		snapshot.Integrations = append(snapshot.Integrations, IntegrationSnapshot{
+			Integration: integration,
+			SecretHash:  secretHash,
+		})
+	}
+
+	users, err := store.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list users: %v", ErrInternal, err)
+	}
+	for _, user := range users {
+		userSnapshot, err := exportUser(ctx, store, user)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Users = append(snapshot.Users, *userSnapshot)
+	}
+
+	return snapshot, nil
+}
+
+func exportUser(
+	ctx context.Context,
+	store Store,
+	user User,
+) (
+	*UserSnapshot,
+	error,
+) {
+	passwordHash, err := store.GetSecret(ctx, user.Handle)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read password of %s: %v", ErrInternal, user.Handle, err)
+	}
+	profile, err := store.GetProfile(ctx, user.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read profile of %s: %v", ErrInternal, user.Handle, err)
+	}
+	identities, err := store.ListExternalIdentities(ctx, user.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list linked identities of %s: %v", ErrInternal, user.Handle, err)
+	}
+	grants, err := store.ListGrants(ctx, user.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list grants of %s: %v", ErrInternal, user.Handle, err)
+	}
+
+	return &UserSnapshot{
+		User:               user,
+		PasswordHash:       passwordHash,
+		Profile:            *profile,
+		ExternalIdentities: identities,
+		Grants:             grants,
+	}, nil
+}
+
+// Import recreates a snapshot in store. Roles, integrations, and users that
+// already exist, by name or by subject or handle, are skipped rather than
+// overwritten, so importing into an initialized store, or importing the same
+// snapshot twice, is safe. The system integration always comes from the
+// target's own initialization. Import is not atomic: if it fails part way,
+// what was created so far stays, and running it again picks up the rest.
+func Import(
+	ctx context.Context,
+	store Store,
+	snapshot *Snapshot,
+) (
+	*ImportResult,
+	error,
+) {
+	if snapshot.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: version %d, want %d", ErrInvalidSnapshot, snapshot.Version, SnapshotVersion)
+	}
+	result := &ImportResult{}
+
+	for _, role := range snapshot.Roles {
+		if _, err := store.GetRole(ctx, role.Name); err == nil {
+			result.Skipped = append(result.Skipped, "role "+role.Name)
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: failed to get role %s: %v", ErrInternal, role.Name, err)
+		}
+		if err := store.InsertRole(ctx, role.Name, role.Display); err != nil {
+			return nil, fmt.Errorf("%w: failed to insert role %s: %v", ErrInternal, role.Name, err)
+		}
+		result.Roles++
+	}
+
+	for _, integration := range snapshot.Integrations {
+		if integration.Name == InternalIntegrationName {
+			continue
+		}
+		if _, err := store.GetIntegration(ctx, integration.Name); err == nil {
+			result.Skipped = append(result.Skipped, "integration "+integration.Name)
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: failed to get integration %s: %v", ErrInternal, integration.Name, err)
+		}
+		if err := store.InsertIntegration(ctx, integration.Integration); err != nil {
+			return nil, fmt.Errorf("%w: failed to insert integration %s: %v", ErrInternal, integration.Name, err)
+		}
+		if integration.SecretHash != "" {
+			if err := store.SetIntegrationSecret(ctx, integration.Name, integration.SecretHash); err != nil {
+				return nil, fmt.Errorf("%w: failed to set secret of %s: %v", ErrInternal, integration.Name, err)
+			}
+		}
+		result.Integrations++
+	}
+
+	for _, user := range snapshot.Users {
+		created, err := importUser(ctx, store, user)
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			result.Skipped = append(result.Skipped, "user "+user.Handle)
+			continue
+		}
+		result.Users++
+	}
+
+	return result, nil
+}
+
+// importUser creates user and everything they own, unless their subject or
+// handle is already taken.
+func importUser(
+	ctx context.Context,
+	store Store,
+	user UserSnapshot,
+) (
+	bool,
+	error,
+) {
+	if user.Subject == "" || user.Handle == "" || len(user.PasswordHash) == 0 {
+		return false, fmt.Errorf("%w: user %q is missing its subject, handle, or password hash", ErrInvalidSnapshot, user.Handle)
+	}
+	if _, err := store.GetUserBySubject(ctx, user.Subject); err == nil {
+		return false, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("%w: failed to get user %s: %v", ErrInternal, user.Handle, err)
+	}
+
+	if err := store.InsertUser(ctx, user.Subject, user.Handle, user.PasswordHash, user.Roles); err != nil {
+		if isUniqueConstraintError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: failed to insert user %s: %v", ErrInternal, user.Handle, err)
+	}
+	if user.Disabled || !user.ExpiresAt.IsZero() {
+		if err := store.UpdateUser(ctx, user.User); err != nil {
+			return false, fmt.Errorf("%w: failed to update user %s: %v", ErrInternal, user.Handle, err)
+		}
+	}
+	if user.TermsVersion != "" {
+		if err := store.AcceptTerms(ctx, user.Subject, user.TermsVersion, user.TermsAcceptedAt); err != nil {
+			return false, fmt.Errorf("%w: failed to record terms accepted by %s: %v", ErrInternal, user.Handle, err)
+		}
+	}
+	if user.Profile != (Profile{}) {
+		profile := user.Profile
+		if err := store.UpsertProfile(ctx, user.Subject, &profile); err != nil {
+			return false, fmt.Errorf("%w: failed to save profile of %s: %v", ErrInternal, user.Handle, err)
+		}
+	}
+	for _, identity := range user.ExternalIdentities {
+		if err := store.InsertExternalIdentity(ctx, user.Subject, identity.Provider, identity.Subject, identity.CreatedAt); err != nil {
+			return false, fmt.Errorf("%w: failed to link identity of %s: %v", ErrInternal, user.Handle, err)
+		}
+	}
+	for _, grant := range user.Grants {
+		if err := store.InsertGrants(ctx, user.Subject, grant.Integration, grant.Scopes); err != nil {
+			return false, fmt.Errorf("%w: failed to save grants of %s: %v", ErrInternal, user.Handle, err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package service_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	t.Parallel()
+	source := testutil.SetupTestEnv(t)
+	target := testutil.SetupTestEnv(t)
+
+	// setup source
+	source.CreateTestIntegration(t, "app", "App", "app.test", "https://app.test/callback")
+	if _, err := source.Service.RotateIntegrationSecret(t.Context(), "app"); err != nil {
+		t.Fatalf("RotateIntegrationSecret failed: %v", err)
+	}
+	source.CreateTestRole(t, "editor", "Editor")
+	alice, err := source.Service.CreateUser(t.Context(), "alice", "password123", []string{"editor"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	displayName := "Alice"
+	if _, err := source.Service.UpdateProfile(t.Context(), alice.Subject, &service.ProfileUpdate{DisplayName: &displayName}); err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+	if err := source.DB.InsertGrants(t.Context(), alice.Subject, "app", []string{"identity", "profile"}); err != nil {
+		t.Fatalf("InsertGrants failed: %v", err)
+	}
+
+	snapshot, err := service.Export(t.Context(), source.DB)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	result, err := service.Import(t.Context(), target.DB, snapshot)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Users != 1 || result.Integrations != 1 || result.Roles != 1 {
+		t.Errorf("result = %+v, want 1 user, 1 integration, 1 role", result)
+	}
+
+	// alice keeps her subject, password, roles, profile, and grants
+	user, err := target.Service.GetUser(t.Context(), alice.Subject)
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if user.Handle != "alice" || !slices.Equal(user.Roles, []string{"editor"}) {
+		t.Errorf("user = %+v", user)
+	}
+	if _, err := target.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Errorf("GrantAuthCode with exported password failed: %v", err)
+	}
+	profile, err := target.Service.GetProfile(t.Context(), alice.Subject)
+	if err != nil || profile.DisplayName != "Alice" {
+		t.Errorf("profile = %+v, %v; want display name Alice", profile, err)
+	}
+	scopes, err := target.DB.ListGrantedScopeNames(t.Context(), alice.Subject, "app")
+	if err != nil || !slices.Equal(scopes, []string{"identity", "profile"}) {
+		t.Errorf("granted scopes = %v, %v; want identity, profile", scopes, err)
+	}
+	integration, err := target.Service.GetIntegration(t.Context(), "app")
+	if err != nil || !integration.HasSecret {
+		t.Errorf("integration = %+v, %v; want app with its secret", integration, err)
+	}
+
+	// importing again changes nothing
+	result, err = service.Import(t.Context(), target.DB, snapshot)
+	if err != nil {
+		t.Fatalf("second Import failed: %v", err)
+	}
+	if result.Users != 0 || result.Integrations != 0 || result.Roles != 0 || !slices.Contains(result.Skipped, "user alice") {
+		t.Errorf("second result = %+v, want everything skipped", result)
+	}
+}
+
+func TestImport_RejectsUnknownVersion(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	_, err := service.Import(t.Context(), env.DB, &service.Snapshot{Version: service.SnapshotVersion + 1})
+	if !errors.Is(err, service.ErrInvalidSnapshot) {
+		t.Errorf("err = %v, want ErrInvalidSnapshot", err)
+	}
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUpstreamSubjectClaim = "sub"
+	defaultUpstreamHandleClaim  = "preferred_username"
+	upstreamResponseLimit       = 1 << 20
+	upstreamHandleAttempts      = 16
+)
+
+// UpstreamProvider describes an external OIDC/OAuth identity provider that
+// consent can delegate authentication to.
+type UpstreamProvider struct {
+	Name         string
+	Display      string
+	AuthorizeURL string
+	TokenURL     string
+	UserInfoURL  string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	SubjectClaim string
+	HandleClaim  string
+}
+
+// UpstreamIdentity is the identity asserted by an upstream provider.
+type UpstreamIdentity struct {
+	Provider string
+	Subject  string
+	Handle   string
+}
+
+// ListUpstreamProviders returns the configured upstream providers in
+// configuration order.
+func (s *Service) ListUpstreamProviders() []UpstreamProvider {
+	providers := make([]UpstreamProvider, 0, len(s.upstreamOrder))
+	for _, name := range s.upstreamOrder {
+		providers = append(providers, s.upstreams[name])
+	}
+	return providers
+}
+
+// BeginUpstreamLogin returns the upstream authorization URL that starts a
+// federated login, with an S256 PKCE challenge derived from verifier. The
+// caller is responsible for binding state and verifier to the browser.
+func (s *Service) BeginUpstreamLogin(
+	providerName string,
+	state string,
+	verifier string,
+) (
+	*url.URL,
+	error,
+) {
+	provider, err := s.getUpstreamProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizeURL, err := url.Parse(provider.AuthorizeURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid authorize URL: %v", ErrInternal, err)
+	}
+
+	q := authorizeURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", provider.ClientID)
+	q.Set("redirect_uri", provider.RedirectURL)
+	q.Set("state", state)
+	q.Set("code_challenge", upstreamCodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	if len(provider.Scopes) > 0 {
+		q.Set("scope", strings.Join(provider.Scopes, " "))
+	}
+	authorizeURL.RawQuery = q.Encode()
+
+	return authorizeURL, nil
+}
+
+// CompleteUpstreamLogin exchanges an upstream authorization code with the PKCE
+// verifier from BeginUpstreamLogin, resolves the upstream subject to a local
+// identity (provisioning one on first login), and returns the consent app auth
+// code redirect.
+func (s *Service) CompleteUpstreamLogin(
+	ctx context.Context,
+	providerName string,
+	code string,
+	verifier string,
+	returnTo string,
+) (
+	*url.URL,
+	error,
+) {
+	identity, err := s.fetchUpstreamIdentity(ctx, providerName, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.resolveUpstreamIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	redirect, err := s.issueInternalAuthCode(ctx, user.Subject, LoginOptions{ReturnTo: returnTo})
+	if err != nil {
+		return nil, err
+	}
+	s.emit(ctx, Event{Type: EventLoginSucceeded, Subject: user.Subject, Handle: user.Handle, Integration: InternalIntegrationName})
+	s.countLogin(ctx, InternalIntegrationName, returnTo, true)
+	return redirect, nil
+}
+
+func (s *Service) getUpstreamProvider(
+	name string,
+) (
+	UpstreamProvider,
+	error,
+) {
+	provider, ok := s.upstreams[name]
+	if !ok {
+		return UpstreamProvider{}, fmt.Errorf("%w: %s", ErrUpstreamNotFound, name)
+	}
+	return provider, nil
+}
+
+// fetchUpstreamIdentity exchanges the code for an upstream access token and
+// reads the subject and handle claims from the userinfo endpoint. Both calls
+// are bound to ctx, so a hung provider can't outlive the login request.
+func (s *Service) fetchUpstreamIdentity(
+	ctx context.Context,
+	providerName string,
+	code string,
+	verifier string,
+) (
+	*UpstreamIdentity,
+	error,
+) {
+	provider, err := s.getUpstreamProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, fmt.Errorf("%w: missing authorization code", ErrUpstreamFailed)
+	}
+	if verifier == "" {
+		return nil, fmt.Errorf("%w: missing code verifier", ErrUpstreamFailed)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to build token request: %v", ErrInternal, err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := s.doUpstreamJSON(tokenReq, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("%w: token exchange with %s: %v", ErrUpstreamFailed, provider.Name, err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return nil, fmt.Errorf("%w: %s returned no access token", ErrUpstreamFailed, provider.Name)
+	}
+
+	userInfoReq, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.UserInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to build userinfo request: %v", ErrInternal, err)
+	}
+	userInfoReq.Header.Set("Authorization", "Bearer "+tokenResponse.AccessToken)
+	userInfoReq.Header.Set("Accept", "application/json")
+
+	var claims map[string]any
+	if err := s.doUpstreamJSON(userInfoReq, &claims); err != nil {
+		return nil, fmt.Errorf("%w: userinfo from %s: %v", ErrUpstreamFailed, provider.Name, err)
+	}
+
+	subject := claimString(claims, provider.SubjectClaim)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: %s userinfo missing %q claim", ErrUpstreamFailed, provider.Name, provider.SubjectClaim)
+	}
+
+	return &UpstreamIdentity{
+		Provider: provider.Name,
+		Subject:  subject,
+		Handle:   claimString(claims, provider.HandleClaim),
+	}, nil
+}
+
+func (s *Service) doUpstreamJSON(
+	req *http.Request,
+	dest any,
+) error {
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, upstreamResponseLimit))
+	if err != nil {
+		return fmt.Errorf("read response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	if err := json.Unmarshal(body, dest); err != nil {
+		return fmt.Errorf("decode response: %v", err)
+	}
+	return nil
+}
+
+// resolveUpstreamIdentity returns the local user linked to the upstream
+// subject, provisioning and linking a new local user on first login.
+func (s *Service) resolveUpstreamIdentity(
+	ctx context.Context,
+	identity *UpstreamIdentity,
+) (
+	*User,
+	error,
+) {
+	user, err := s.store.GetUserByExternalIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: failed to resolve external identity: %v", ErrInternal, err)
+	}
+
+	// linked users still sign in during maintenance, but new ones wait
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	subject, err := generateSubject()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to generate account subject: %v", ErrInternal, err)
+	}
+
+	// federated accounts get a random password hash so the password login
+	// path can never match until a password is explicitly set
+	unusable := make([]byte, 32)
+	if _, err := rand.Read(unusable); err != nil {
+		return nil, fmt.Errorf("%w: failed to generate secret: %v", ErrInternal, err)
+	}
+	secret, err := s.passwords.hash(ctx, []byte(base64.RawURLEncoding.EncodeToString(unusable)))
+	if err != nil {
+		if errors.Is(err, ErrBusy) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: failed to hash password: %v", ErrInternal, err)
+	}
+
+	baseHandle := upstreamHandle(identity)
+	for attempt := 0; attempt < upstreamHandleAttempts; attempt++ {
+		handle := baseHandle
+		if attempt > 0 {
+			handle = fmt.Sprintf("%s-%d", baseHandle, attempt+1)
+		}
+
+		err := s.store.InsertUser(ctx, subject, handle, secret, nil)
+		if err != nil {
+			if isUniqueConstraintError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("%w: failed to insert account: %v", ErrInternal, err)
+		}
+
+		if err := s.store.InsertExternalIdentity(ctx, subject, identity.Provider, identity.Subject, time.Now()); err != nil {
+			_, _ = s.store.DeleteUser(ctx, subject)
+			return nil, fmt.Errorf("%w: failed to link external identity: %v", ErrInternal, err)
+		}
+		s.emit(ctx, Event{Type: EventUserRegistered, Subject: subject, Handle: handle})
+
+		return &User{
+			Subject: subject,
+			Handle:  handle,
+			Roles:   []string{},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: no free handle for %s", ErrHandleExists, baseHandle)
+}
+
+// upstreamCodeChallenge derives the RFC 7636 S256 challenge for verifier.
+func upstreamCodeChallenge(
+	verifier string,
+) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func upstreamHandle(
+	identity *UpstreamIdentity,
+) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(identity.Handle) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return identity.Provider + "-user"
+	}
+	return b.String()
+}
+
+func claimString(
+	claims map[string]any,
+	name string,
+) string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return fmt.Sprintf("%.0f", value)
+	default:
+		return ""
+	}
+}
+
+func normalizeUpstreamProviders(
+	providers []UpstreamProvider,
+) (
+	map[string]UpstreamProvider,
+	[]string,
+	error,
+) {
+	byName := make(map[string]UpstreamProvider, len(providers))
+	order := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		if provider.Name == "" {
+			return nil, nil, errors.New("service: upstream provider name required")
+		}
+		if _, exists := byName[provider.Name]; exists {
+			return nil, nil, fmt.Errorf("service: duplicate upstream provider %q", provider.Name)
+		}
+		if provider.Display == "" {
+			provider.Display = provider.Name
+		}
+		if provider.SubjectClaim == "" {
+			provider.SubjectClaim = defaultUpstreamSubjectClaim
+		}
+		if provider.HandleClaim == "" {
+			provider.HandleClaim = defaultUpstreamHandleClaim
+		}
+		byName[provider.Name] = provider
+		order = append(order, provider.Name)
+	}
+	return byName, order, nil
+}
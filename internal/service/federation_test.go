@@ -0,0 +1,193 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+// upstreamVerifier is the PKCE verifier the fake upstream provider expects.
+const upstreamVerifier = "upstream-verifier"
+
+// setupUpstreamEnv starts a fake upstream provider that accepts code "good"
+// with upstreamVerifier and asserts the given userinfo claims.
+func setupUpstreamEnv(
+	t *testing.T,
+	claims map[string]any,
+) *testutil.TestEnv {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good" ||
+			r.FormValue("code_verifier") != upstreamVerifier ||
+			r.FormValue("client_secret") != "upstream-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "upstream-access",
+			"token_type":   "Bearer",
+		})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer upstream-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(claims)
+	})
+	upstream := httptest.NewServer(mux)
+	t.Cleanup(upstream.Close)
+
+	return testutil.SetupTestEnvWithUpstreams(t, []service.UpstreamProvider{{
+		Name:         "corp",
+		AuthorizeURL: upstream.URL + "/authorize",
+		TokenURL:     upstream.URL + "/token",
+		UserInfoURL:  upstream.URL + "/userinfo",
+		ClientID:     "consent",
+		ClientSecret: "upstream-secret",
+		RedirectURL:  "https://consent.test/login/with/corp/callback",
+		Scopes:       []string{"openid", "profile"},
+	}})
+}
+
+func TestBeginUpstreamLogin_BuildsAuthorizeURL(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, nil)
+
+	authorizeURL, err := env.Service.BeginUpstreamLogin("corp", "state-123", upstreamVerifier)
+	if err != nil {
+		t.Fatalf("BeginUpstreamLogin failed: %v", err)
+	}
+
+	query := authorizeURL.Query()
+	if query.Get("state") != "state-123" {
+		t.Fatalf("state = %q, want state-123", query.Get("state"))
+	}
+	if query.Get("client_id") != "consent" {
+		t.Fatalf("client_id = %q, want consent", query.Get("client_id"))
+	}
+	if query.Get("redirect_uri") != "https://consent.test/login/with/corp/callback" {
+		t.Fatalf("redirect_uri = %q, want callback", query.Get("redirect_uri"))
+	}
+	if query.Get("scope") != "openid profile" {
+		t.Fatalf("scope = %q, want openid profile", query.Get("scope"))
+	}
+	sum := sha256.Sum256([]byte(upstreamVerifier))
+	if query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("code_challenge = %q, want S256 of verifier", query.Get("code_challenge"))
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+}
+
+func TestBeginUpstreamLogin_UnknownProvider(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, nil)
+
+	_, err := env.Service.BeginUpstreamLogin("missing", "state-123", upstreamVerifier)
+	if !errors.Is(err, service.ErrUpstreamNotFound) {
+		t.Fatalf("expected ErrUpstreamNotFound, got %v", err)
+	}
+}
+
+func TestCompleteUpstreamLogin_ProvisionsAndReusesUser(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{
+		"sub":                "ext-123",
+		"preferred_username": "Alice",
+	})
+
+	// first login provisions a linked local user
+	redirectURL, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "good", upstreamVerifier, "/home")
+	if err != nil {
+		t.Fatalf("CompleteUpstreamLogin failed: %v", err)
+	}
+	if redirectURL.Query().Get("auth_code") == "" {
+		t.Fatal("redirect URL missing auth_code parameter")
+	}
+	if redirectURL.Query().Get("return_to") != "/home" {
+		t.Fatalf("return_to = %q, want /home", redirectURL.Query().Get("return_to"))
+	}
+
+	user, err := env.DB.GetUserByExternalIdentity(t.Context(), "corp", "ext-123")
+	if err != nil {
+		t.Fatalf("GetUserByExternalIdentity failed: %v", err)
+	}
+	if user.Handle != "alice" {
+		t.Fatalf("handle = %q, want alice", user.Handle)
+	}
+
+	// second login resolves to the same user
+	if _, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "good", upstreamVerifier, "/"); err != nil {
+		t.Fatalf("CompleteUpstreamLogin failed: %v", err)
+	}
+	again, err := env.DB.GetUserByExternalIdentity(t.Context(), "corp", "ext-123")
+	if err != nil {
+		t.Fatalf("GetUserByExternalIdentity failed: %v", err)
+	}
+	if again.Subject != user.Subject {
+		t.Fatalf("subject = %q, want %q", again.Subject, user.Subject)
+	}
+}
+
+func TestCompleteUpstreamLogin_HandleConflictGetsSuffix(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{
+		"sub":                "ext-123",
+		"preferred_username": "alice",
+	})
+	env.RegisterTestUser(t, "alice", "password")
+
+	if _, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "good", upstreamVerifier, "/"); err != nil {
+		t.Fatalf("CompleteUpstreamLogin failed: %v", err)
+	}
+
+	// the existing local account is not taken over
+	user, err := env.DB.GetUserByExternalIdentity(t.Context(), "corp", "ext-123")
+	if err != nil {
+		t.Fatalf("GetUserByExternalIdentity failed: %v", err)
+	}
+	if user.Handle != "alice-2" {
+		t.Fatalf("handle = %q, want alice-2", user.Handle)
+	}
+}
+
+func TestCompleteUpstreamLogin_RejectedCode(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{"sub": "ext-123"})
+
+	_, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "bad", upstreamVerifier, "/")
+	if !errors.Is(err, service.ErrUpstreamFailed) {
+		t.Fatalf("expected ErrUpstreamFailed, got %v", err)
+	}
+}
+
+func TestCompleteUpstreamLogin_WrongVerifier(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{"sub": "ext-123"})
+
+	_, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "good", "intercepted", "/")
+	if !errors.Is(err, service.ErrUpstreamFailed) {
+		t.Fatalf("expected ErrUpstreamFailed, got %v", err)
+	}
+}
+
+func TestCompleteUpstreamLogin_MissingSubjectClaim(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{"preferred_username": "alice"})
+
+	_, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "good", upstreamVerifier, "/")
+	if !errors.Is(err, service.ErrUpstreamFailed) {
+		t.Fatalf("expected ErrUpstreamFailed, got %v", err)
+	}
+}
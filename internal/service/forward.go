@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// ForwardIdentity is who a reverse proxy's forward-auth request belongs to,
+// for the identity headers passed on to the upstream app.
+type ForwardIdentity struct {
+	Subject string
+	Handle  string
+	Roles   []string
+	Scopes  []string
+}
+
+// ForwardAuth checks the access token a reverse proxy forwarded on behalf of
+// an upstream app. The token must be issued to integration, or to Consent's
+// own sign-in when integration is empty, and its subject must still exist
+// and be neither disabled nor expired.
+func (s *Service) ForwardAuth(
+	ctx context.Context,
+	encodedAccessToken string,
+	integration string,
+) (
+	*ForwardIdentity,
+	error,
+) {
+	if integration == "" {
+		integration = InternalIntegrationName
+	}
+	target, err := s.GetIntegration(ctx, integration)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.tokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+	if !slices.Contains(accessToken.Audience(), target.Audience) {
+		return nil, fmt.Errorf("%w: token was not issued to %s", ErrTokenInvalid, integration)
+	}
+
+	user, err := s.activeUser(ctx, accessToken.Subject())
+	if err != nil {
+		return nil, err
+	}
+	return &ForwardIdentity{
+		Subject: user.Subject,
+		Handle:  user.Handle,
+		Roles:   user.Roles,
+		Scopes:  accessToken.Scopes(),
+	}, nil
+}
+
+// ForwardAuthLoginURL is where a reverse proxy sends a user that ForwardAuth
+// turned away: the login page for Consent's own sign-in, or the authorize
+// page for an integration. requestURL is the absolute URL the user asked the
+// proxy for. When it shares an origin with Consent, or with one of the
+// integration's redirects, its path and query go along as return_to so the
+// user lands back on it after signing in; any other URL is left behind.
+func (s *Service) ForwardAuthLoginURL(
+	ctx context.Context,
+	integration string,
+	requestURL string,
+) string {
+	requested, err := url.Parse(requestURL)
+	if err != nil || !requested.IsAbs() || requested.Host == "" {
+		requested = nil
+	}
+
+	query := url.Values{}
+	if integration == "" || integration == InternalIntegrationName {
+		if requested != nil && sameOrigin(requested, s.publicURL) {
+			query.Set("return_to", requested.RequestURI())
+		}
+		return withQuery(s.publicURL+"/login", query)
+	}
+
+	query.Set("integration", integration)
+	query.Set("scope", ScopeIdentity)
+	if requested != nil {
+		if target, err := s.GetIntegration(ctx, integration); err == nil {
+			for _, redirect := range target.RedirectURIs() {
+				if sameOrigin(requested, redirect) {
+					query.Set("redirect_uri", redirect)
+					query.Set("return_to", requested.RequestURI())
+					break
+				}
+			}
+		}
+	}
+	return withQuery(s.publicURL+"/authorize", query)
+}
+
+// CompleteForwardAuth redeems the authorization code a forward-auth login
+// brings back to the integration's redirect, for the callback that sets the
+// proxied app's accessToken cookie. Proxies can't refresh tokens, so only an
+// access token is issued; once it expires the proxy sends the user back
+// through the authorize page, which approves at once while their Consent
+// session lasts.
+func (s *Service) CompleteForwardAuth(
+	ctx context.Context,
+	code string,
+	client ClientCredentials,
+) (
+	*tokens.AccessToken,
+	error,
+) {
+	record, integration, err := s.redeemAuthorizationCode(ctx, code, client)
+	if err != nil {
+		return nil, err
+	}
+	if integration.Name == InternalIntegrationName {
+		return nil, fmt.Errorf("%w: forward auth callbacks are for integrations", ErrInvalidIntegration)
+	}
+
+	user, err := s.activeUser(ctx, record.Subject)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.tokenIssuer.IssueAccessTokenWithOptions(
+		record.Subject,
+		[]string{integration.Audience},
+		record.Scopes,
+		s.accessLifetime(integration.Policy),
+		tokens.IssueOptions{
+			AuthTime:     record.AuthTime,
+			TermsVersion: user.TermsVersion,
+		},
+	)
+	if err != nil {
+		return nil, issueError("access token", err)
+	}
+	return accessToken, nil
+}
+
+// sameOrigin reports whether u has the scheme and host of the URL in other.
+func sameOrigin(
+	u *url.URL,
+	other string,
+) bool {
+	parsed, err := url.Parse(other)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, parsed.Scheme) &&
+		strings.EqualFold(u.Host, parsed.Host)
+}
+
+func withQuery(
+	base string,
+	query url.Values,
+) string {
+	if len(query) == 0 {
+		return base
+	}
+	return base + "?" + query.Encode()
+}
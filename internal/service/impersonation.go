@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// ImpersonationTokenLifetime caps access tokens issued for impersonation.
+const ImpersonationTokenLifetime = 15 * time.Minute
+
+// Impersonation is the audit event for an administrator acting as a user.
+// Actor and Subject are the administrator's and the user's subjects, and
+// Client is where the request came from.
+type Impersonation struct {
+	Actor       string
+	Subject     string
+	Integration string
+	Scopes      []string
+	Reason      string
+	ExpiresAt   time.Time
+	Client      ClientInfo
+}
+
+// Impersonate issues an access token for subject at integrationName that
+// names the actor as the one really acting, so support staff can see an app
+// as the user does. The actor is whoever actorToken, an access token from
+// their own sign-in to consent, was issued to, so the act claim and the audit
+// event name the administrator who signed in rather than one picked by the
+// caller. The actor must hold the admin role, and neither account may be
+// disabled or expired. The token has no refresh token, lasts at most
+// ImpersonationTokenLifetime, never counts as a recent sign-in, and is
+// reported to the impersonation audit hook.
+func (s *Service) Impersonate(
+	ctx context.Context,
+	actorToken string,
+	subject string,
+	integrationName string,
+	requestedScopes []string,
+	reason string,
+) (
+	string,
+	error,
+) {
+	admin, err := s.impersonationActor(ctx, actorToken)
+	if err != nil {
+		return "", err
+	}
+	actor := admin.Subject
+	if !slices.Contains(admin.Roles, ProtectedAdminRoleName) {
+		return "", fmt.Errorf("%w: %s", ErrNotAdmin, actor)
+	}
+	if err := accountActive(admin); err != nil {
+		return "", err
+	}
+	if subject == actor {
+		return "", fmt.Errorf("%w: cannot impersonate yourself", ErrInvalidUser)
+	}
+	if _, err := s.activeUser(ctx, subject); err != nil {
+		if errors.Is(err, ErrAccountNotFound) {
+			return "", fmt.Errorf("%w: %s", ErrUserNotFound, subject)
+		}
+		return "", err
+	}
+
+	integration, err := s.GetIntegration(ctx, integrationName)
+	if err != nil {
+		return "", err
+	}
+	if integration.Name == InternalIntegrationName {
+		return "", fmt.Errorf("%w: %s", ErrIntegrationProtected, integration.Name)
+	}
+	scopes, err := validateRequestedScopes(requestedScopes)
+	if err != nil {
+		return "", err
+	}
+	for _, scope := range scopes {
+		if !integration.Policy.AllowsScope(scope) {
+			return "", fmt.Errorf("%w: %s is not allowed for %s", ErrInvalidScope, scope, integration.Name)
+		}
+	}
+
+	lifetime := min(s.accessLifetime(integration.Policy), ImpersonationTokenLifetime)
+	accessToken, err := s.tokenIssuer.IssueAccessTokenWithOptions(
+		subject,
+		[]string{integration.Audience},
+		scopes,
+		lifetime,
+		tokens.IssueOptions{Actor: actor},
+	)
+	if err != nil {
+		return "", issueError("access token", err)
+	}
+
+	s.onImpersonation(ctx, Impersonation{
+		Actor:       actor,
+		Subject:     subject,
+		Integration: integration.Name,
+		Scopes:      scopes,
+		Reason:      reason,
+		ExpiresAt:   accessToken.Expiration(),
+		Client:      clientInfoFrom(ctx),
+	})
+	return accessToken.Encoded(), nil
+}
+
+// impersonationActor returns the user encodedAccessToken was issued to. The
+// token must come from a sign-in to consent itself, not an integration, and
+// must not be an impersonation token.
+func (s *Service) impersonationActor(
+	ctx context.Context,
+	encodedAccessToken string,
+) (
+	*User,
+	error,
+) {
+	internal, err := s.GetIntegration(ctx, InternalIntegrationName)
+	if err != nil {
+		return nil, err
+	}
+	token := new(tokens.AccessToken)
+	if err := token.Decode(encodedAccessToken, s.tokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode actor token: %w", ErrTokenInvalid, err)
+	}
+	if !slices.Contains(token.Audience(), internal.Audience) {
+		return nil, fmt.Errorf("%w: actor token was not issued by a sign-in to consent", ErrTokenInvalid)
+	}
+	if token.Actor() != "" {
+		return nil, fmt.Errorf("%w: actor token is itself an impersonation", ErrTokenInvalid)
+	}
+	return s.GetUser(ctx, token.Subject())
+}
+
+// logImpersonation is the audit hook used when none is configured.
+func logImpersonation(
+	ctx context.Context,
+	event Impersonation,
+) {
+	log.Printf(
+		"impersonation: actor=%s subject=%s integration=%s scopes=%q reason=%q ip=%s request=%s",
+		event.Actor,
+		event.Subject,
+		event.Integration,
+		strings.Join(event.Scopes, " "),
+		event.Reason,
+		event.Client.IP,
+		requestid.FromContext(ctx),
+	)
+}
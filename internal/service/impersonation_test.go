@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestImpersonate(t *testing.T) {
+	t.Parallel()
+	var events []service.Impersonation
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.OnImpersonation = func(_ context.Context, event service.Impersonation) {
+			events = append(events, event)
+		}
+	})
+
+	// setup env
+	env.CreateTestIntegration(t, "app", "App", "app.test", "https://app.test/callback")
+	admin, err := env.Service.CreateUser(t.Context(), "admin", "password123", []string{service.ProtectedAdminRoleName})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	alice, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	internal, err := env.Service.GetIntegration(t.Context(), service.InternalIntegrationName)
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	signIn := func(subject string) string {
+		return env.IssueTestAccessToken(t, subject, []string{internal.Audience}).Encoded()
+	}
+
+	// the token is alice's, naming the admin as actor
+	encoded, err := env.Service.Impersonate(t.Context(), signIn(admin.Subject), alice.Subject, "app", []string{"identity"}, "ticket 42")
+	if err != nil {
+		t.Fatalf("Impersonate failed: %v", err)
+	}
+	token := new(tokens.AccessToken)
+	if err := token.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode access token: %v", err)
+	}
+	if token.Subject() != alice.Subject || token.Actor() != admin.Subject {
+		t.Errorf("subject/actor = %s/%s, want %s/%s", token.Subject(), token.Actor(), alice.Subject, admin.Subject)
+	}
+	if !slices.Equal(token.Audience(), []string{"app.test"}) {
+		t.Errorf("audience = %v, want [app.test]", token.Audience())
+	}
+	if lifetime := token.Expiration().Sub(token.IssuedAt()); lifetime > service.ImpersonationTokenLifetime {
+		t.Errorf("lifetime = %v, want at most %v", lifetime, service.ImpersonationTokenLifetime)
+	}
+	if !token.AuthTime().IsZero() {
+		t.Errorf("auth time = %v, want zero", token.AuthTime())
+	}
+
+	// the audit hook hears about it
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(events))
+	}
+	if event := events[0]; event.Actor != admin.Subject || event.Subject != alice.Subject || event.Reason != "ticket 42" {
+		t.Errorf("audit event = %+v", event)
+	}
+
+	// rejected requests
+	past := time.Now().Add(-time.Hour)
+	lapsedAdmin, err := env.Service.CreateUserWithOptions(t.Context(), "lapsed", "password123", []string{service.ProtectedAdminRoleName}, service.UserOptions{ExpiresAt: past})
+	if err != nil {
+		t.Fatalf("CreateUserWithOptions failed: %v", err)
+	}
+	expired, err := env.Service.CreateUserWithOptions(t.Context(), "expired", "password123", nil, service.UserOptions{ExpiresAt: past})
+	if err != nil {
+		t.Fatalf("CreateUserWithOptions failed: %v", err)
+	}
+	disabled, err := env.Service.CreateUser(t.Context(), "disabled", "password123", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	off := true
+	if _, err := env.Service.UpdateUser(t.Context(), disabled.Subject, &service.UserUpdate{Disabled: &off}); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	impersonated, err := env.TokenIssuer.IssueAccessTokenWithOptions(admin.Subject, []string{internal.Audience}, nil, time.Minute, tokens.IssueOptions{Actor: alice.Subject})
+	if err != nil {
+		t.Fatalf("IssueAccessTokenWithOptions failed: %v", err)
+	}
+	tests := []struct {
+		name        string
+		actorToken  string
+		subject     string
+		integration string
+		want        error
+	}{
+		{"actor not admin", signIn(alice.Subject), admin.Subject, "app", service.ErrNotAdmin},
+		{"actor named, not signed in", admin.Subject, alice.Subject, "app", service.ErrTokenInvalid},
+		{"actor token for an app", env.IssueTestAccessToken(t, admin.Subject, []string{"app.test"}).Encoded(), alice.Subject, "app", service.ErrTokenInvalid},
+		{"actor token an impersonation", impersonated.Encoded(), alice.Subject, "app", service.ErrTokenInvalid},
+		{"self", signIn(admin.Subject), admin.Subject, "app", service.ErrInvalidUser},
+		{"unknown subject", signIn(admin.Subject), "nobody", "app", service.ErrUserNotFound},
+		{"consent itself", signIn(admin.Subject), alice.Subject, service.InternalIntegrationName, service.ErrIntegrationProtected},
+		{"actor expired", signIn(lapsedAdmin.Subject), alice.Subject, "app", service.ErrAccountExpired},
+		{"subject disabled", signIn(admin.Subject), disabled.Subject, "app", service.ErrAccountDisabled},
+		{"subject expired", signIn(admin.Subject), expired.Subject, "app", service.ErrAccountExpired},
+	}
+	for _, tt := range tests {
+		_, err := env.Service.Impersonate(t.Context(), tt.actorToken, tt.subject, tt.integration, []string{"identity"}, "")
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if len(events) != 1 {
+		t.Errorf("rejected requests were audited: %d events", len(events))
+	}
+}
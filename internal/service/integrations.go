@@ -1,11 +1,14 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,17 +16,123 @@ const (
 	internalIntegrationDisplay = "Consent"
 )
 
+// Integration is a registered relying service. Redirect is the default
+// callback; Redirects lists any additional callbacks (such as staging URLs)
+// that an authorization request may select by exact match. HasSecret marks
+// confidential integrations, which must authenticate to redeem tokens.
 type Integration struct {
-	Name     string
-	Display  string
-	Audience string
-	Redirect string
+	Name      string
+	Display   string
+	Audience  string
+	Redirect  string
+	Redirects []string
+	Policy    IntegrationPolicy
+	HasSecret bool
 }
 
+// IntegrationPolicy overrides how tokens are issued to an integration. Zero
+// lifetimes use the server defaults, an empty AllowedScopes permits every
+// registered scope, and refresh tokens are rotated on every use unless
+// ReuseRefreshTokens is set. ExchangeAudiences lists the audiences the
+// integration may exchange its users' access tokens for; empty allows none.
+// ReleasedClaims limits which user claims the integration receives in ID
+// tokens and userinfo; empty releases every claim but groups.
+type IntegrationPolicy struct {
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	AllowedScopes        []string
+	ReuseRefreshTokens   bool
+	AuthCodeLifetime     time.Duration
+	ExchangeAudiences    []string
+	ReleasedClaims       []string
+}
+
+// IntegrationUpdate describes a partial integration change. A non-nil Policy
+// replaces the whole policy.
 type IntegrationUpdate struct {
-	Display  *string
-	Audience *string
-	Redirect *string
+	Display   *string
+	Audience  *string
+	Redirect  *string
+	Redirects *[]string
+	Policy    *IntegrationPolicy
+}
+
+// AllowsScope reports whether the integration may be granted scope.
+func (p IntegrationPolicy) AllowsScope(
+	scope string,
+) bool {
+	return len(p.AllowedScopes) == 0 || slices.Contains(p.AllowedScopes, scope)
+}
+
+// ReleasesClaim reports whether the integration may receive claim, given the
+// user granted the scope it needs. Groups are only released when listed.
+func (p IntegrationPolicy) ReleasesClaim(
+	claim string,
+) bool {
+	if len(p.ReleasedClaims) == 0 {
+		return claim != ClaimGroups
+	}
+	return slices.Contains(p.ReleasedClaims, claim)
+}
+
+// RedirectURIs returns every registered redirect, default first.
+func (i Integration) RedirectURIs() []string {
+	return append([]string{i.Redirect}, i.Redirects...)
+}
+
+// ResolveRedirect picks the callback for an authorization request. An empty
+// request selects the default redirect, but only when it is the only one
+// registered; otherwise the request must name a registered redirect exactly.
+// As RFC 8252 asks of loopback redirects, one registered on localhost,
+// 127.0.0.1, or [::1] without a port also matches the same URL at any port,
+// since local apps pick theirs when they start.
+func (i Integration) ResolveRedirect(
+	requested string,
+) (
+	string,
+	error,
+) {
+	if requested == "" {
+		if len(i.Redirects) > 0 {
+			return "", fmt.Errorf("%w: redirect_uri is required when multiple redirects are registered", ErrInvalidRedirect)
+		}
+		return i.Redirect, nil
+	}
+	if slices.Contains(i.RedirectURIs(), requested) {
+		return requested, nil
+	}
+	for _, registered := range i.RedirectURIs() {
+		if matchesLoopbackRedirect(registered, requested) {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is not registered", ErrInvalidRedirect, requested)
+}
+
+// matchesLoopbackRedirect reports whether requested is registered, a
+// portless http loopback redirect, at some port.
+func matchesLoopbackRedirect(
+	registered string,
+	requested string,
+) bool {
+	want, err := url.Parse(registered)
+	if err != nil || want.Scheme != "http" || want.Port() != "" {
+		return false
+	}
+	switch want.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		return false
+	}
+
+	got, err := url.Parse(requested)
+	if err != nil || got.Port() == "" || got.User != nil || got.Fragment != "" {
+		return false
+	}
+	return got.Scheme == want.Scheme &&
+		got.Hostname() == want.Hostname() &&
+		got.EscapedPath() == want.EscapedPath() &&
+		got.RawQuery == want.RawQuery
 }
 
 func BuildInternalIntegration(
@@ -53,6 +162,7 @@ func BuildInternalIntegration(
 }
 
 func SeedSystemIntegrations(
+	ctx context.Context,
 	store Store,
 	publicURL string,
 ) error {
@@ -65,7 +175,7 @@ func SeedSystemIntegrations(
 		return fmt.Errorf("service: failed to build internal integration: %w", err)
 	}
 
-	if err := store.UpsertSystemIntegrations([]Integration{internalIntegration}); err != nil {
+	if err := store.UpsertSystemIntegrations(ctx, []Integration{internalIntegration}); err != nil {
 		return fmt.Errorf("service: failed to initialize system integrations: %w", err)
 	}
 
@@ -73,11 +183,29 @@ func SeedSystemIntegrations(
 }
 
 func (s *Service) CreateIntegration(
+	ctx context.Context,
+	name string,
+	display string,
+	audience string,
+	redirect string,
+	redirects ...string,
+) error {
+	return s.CreateIntegrationWithPolicy(ctx, name, display, audience, redirect, redirects, IntegrationPolicy{})
+}
+
+// CreateIntegrationWithPolicy creates an integration with a token policy.
+func (s *Service) CreateIntegrationWithPolicy(
+	ctx context.Context,
 	name string,
 	display string,
 	audience string,
 	redirect string,
+	redirects []string,
+	policy IntegrationPolicy,
 ) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if name == "" {
 		return ErrInvalidIntegration
 	}
@@ -89,11 +217,24 @@ func (s *Service) CreateIntegration(
 		return ErrInvalidIntegration
 	}
 
-	if _, err := parseAndValidateRedirectURL(redirect); err != nil {
-		return fmt.Errorf("%w: %w", ErrInvalidRedirect, err)
+	redirects, err := validateRedirects(redirect, redirects)
+	if err != nil {
+		return err
 	}
 
-	err := s.store.InsertIntegration(name, display, audience, redirect)
+	policy, err = s.validatePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.InsertIntegration(ctx, Integration{
+		Name:      name,
+		Display:   display,
+		Audience:  audience,
+		Redirect:  redirect,
+		Redirects: redirects,
+		Policy:    policy,
+	})
 	if err != nil {
 		if isUniqueConstraintError(err) {
 			return ErrIntegrationExists
@@ -105,6 +246,7 @@ func (s *Service) CreateIntegration(
 }
 
 func (s *Service) GetIntegration(
+	ctx context.Context,
 	name string,
 ) (
 	*Integration,
@@ -114,7 +256,7 @@ func (s *Service) GetIntegration(
 		return nil, ErrInvalidIntegration
 	}
 
-	record, err := s.store.GetIntegration(name)
+	record, err := s.store.GetIntegration(ctx, name)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: %s", ErrIntegrationNotFound, name)
@@ -126,9 +268,13 @@ func (s *Service) GetIntegration(
 }
 
 func (s *Service) UpdateIntegration(
+	ctx context.Context,
 	name string,
 	updates *IntegrationUpdate,
 ) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if updates == nil {
 		return nil
 	}
@@ -140,7 +286,7 @@ func (s *Service) UpdateIntegration(
 		return ErrIntegrationProtected
 	}
 
-	current, err := s.store.GetIntegration(name)
+	current, err := s.store.GetIntegration(ctx, name)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return fmt.Errorf("%w: %s", ErrIntegrationNotFound, name)
@@ -157,16 +303,32 @@ func (s *Service) UpdateIntegration(
 	if updates.Redirect != nil {
 		current.Redirect = *updates.Redirect
 	}
+	if updates.Redirects != nil {
+		current.Redirects = *updates.Redirects
+	}
 
 	if current.Display == "" || current.Audience == "" || current.Redirect == "" {
 		return ErrInvalidIntegration
 	}
 
-	if _, err := parseAndValidateRedirectURL(current.Redirect); err != nil {
-		return fmt.Errorf("%w: %w", ErrInvalidRedirect, err)
+	redirects, err := validateRedirects(current.Redirect, current.Redirects)
+	if err != nil {
+		return err
+	}
+	// store the cleaned list, which also drops an extra that became the default
+	storeUpdates := *updates
+	if updates.Redirects != nil || updates.Redirect != nil {
+		storeUpdates.Redirects = &redirects
+	}
+	if updates.Policy != nil {
+		policy, err := s.validatePolicy(*updates.Policy)
+		if err != nil {
+			return err
+		}
+		storeUpdates.Policy = &policy
 	}
 
-	err = s.store.UpdateIntegration(name, updates)
+	err = s.store.UpdateIntegration(ctx, name, &storeUpdates)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return fmt.Errorf("%w: %s", ErrIntegrationNotFound, name)
@@ -178,8 +340,12 @@ func (s *Service) UpdateIntegration(
 }
 
 func (s *Service) DeleteIntegration(
+	ctx context.Context,
 	name string,
 ) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if name == "" {
 		return ErrInvalidIntegration
 	}
@@ -187,7 +353,7 @@ func (s *Service) DeleteIntegration(
 		return ErrIntegrationProtected
 	}
 
-	deleted, err := s.store.DeleteIntegration(name)
+	deleted, err := s.store.DeleteIntegration(ctx, name)
 	if err != nil {
 		return fmt.Errorf("%w: failed to delete integration: %v", ErrInternal, err)
 	}
@@ -197,13 +363,144 @@ func (s *Service) DeleteIntegration(
 	return nil
 }
 
-func (s *Service) ListIntegrations() (
+func (s *Service) ListIntegrations(ctx context.Context) (
 	[]Integration,
 	error,
 ) {
-	records, err := s.store.ListIntegrations()
+	records, err := s.store.ListIntegrations(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to list integrations: %v", ErrInternal, err)
 	}
 	return records, nil
 }
+
+// IntegrationOrigins returns the distinct origins (scheme and host) of every
+// registered redirect, which identify the sites integrations are served from.
+func (s *Service) IntegrationOrigins(ctx context.Context) (
+	[]string,
+	error,
+) {
+	integrations, err := s.ListIntegrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var origins []string
+	for _, integration := range integrations {
+		for _, redirect := range integration.RedirectURIs() {
+			parsed, err := url.Parse(redirect)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				continue
+			}
+			origin := parsed.Scheme + "://" + parsed.Host
+			if !slices.Contains(origins, origin) {
+				origins = append(origins, origin)
+			}
+		}
+	}
+	return origins, nil
+}
+
+// validateRedirects checks every redirect is an absolute URL and returns the
+// additional redirects with blanks and duplicates of the default removed.
+func validateRedirects(
+	redirect string,
+	redirects []string,
+) (
+	[]string,
+	error,
+) {
+	if _, err := parseAndValidateRedirectURL(redirect); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRedirect, err)
+	}
+
+	additional := make([]string, 0, len(redirects))
+	for _, candidate := range redirects {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" || candidate == redirect || slices.Contains(additional, candidate) {
+			continue
+		}
+		if strings.ContainsAny(candidate, " \t\n") {
+			return nil, fmt.Errorf("%w: %q contains whitespace", ErrInvalidRedirect, candidate)
+		}
+		if _, err := parseAndValidateRedirectURL(candidate); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidRedirect, err)
+		}
+		additional = append(additional, candidate)
+	}
+	return additional, nil
+}
+
+// validatePolicy checks lifetimes are not negative and that allowed scopes and
+// released claims are known, returning the policy with allowed scopes,
+// exchange audiences, and released claims deduplicated and sorted.
+func (s *Service) validatePolicy(
+	policy IntegrationPolicy,
+) (
+	IntegrationPolicy,
+	error,
+) {
+	if policy.AccessTokenLifetime < 0 || policy.RefreshTokenLifetime < 0 {
+		return IntegrationPolicy{}, fmt.Errorf("%w: token lifetimes cannot be negative", ErrInvalidIntegration)
+	}
+	if err := validateTokenLifetimes(s.accessLifetime(policy), s.refreshLifetime(policy)); err != nil {
+		return IntegrationPolicy{}, fmt.Errorf("%w: %v", ErrInvalidIntegration, err)
+	}
+	if err := validateAuthCodeLifetime(policy.AuthCodeLifetime); err != nil {
+		return IntegrationPolicy{}, fmt.Errorf("%w: %v", ErrInvalidIntegration, err)
+	}
+
+	exchange := make([]string, 0, len(policy.ExchangeAudiences))
+	for _, audience := range policy.ExchangeAudiences {
+		audience = strings.TrimSpace(audience)
+		if audience == "" || strings.ContainsAny(audience, " \t\n") {
+			return IntegrationPolicy{}, fmt.Errorf("%w: invalid exchange audience %q", ErrInvalidIntegration, audience)
+		}
+		if !slices.Contains(exchange, audience) {
+			exchange = append(exchange, audience)
+		}
+	}
+	slices.Sort(exchange)
+	policy.ExchangeAudiences = nil
+	if len(exchange) > 0 {
+		policy.ExchangeAudiences = exchange
+	}
+
+	released := make([]string, 0, len(policy.ReleasedClaims))
+	for _, claim := range policy.ReleasedClaims {
+		claim = strings.TrimSpace(claim)
+		if !slices.Contains(claimRegistry, claim) {
+			return IntegrationPolicy{}, fmt.Errorf("%w: unknown claim %q", ErrInvalidIntegration, claim)
+		}
+		if !slices.Contains(released, claim) {
+			released = append(released, claim)
+		}
+	}
+	slices.Sort(released)
+	policy.ReleasedClaims = nil
+	if len(released) > 0 {
+		policy.ReleasedClaims = released
+	}
+
+	if len(policy.AllowedScopes) == 0 {
+		policy.AllowedScopes = nil
+		return policy, nil
+	}
+
+	allowed := make([]string, 0, len(policy.AllowedScopes))
+	for _, scope := range policy.AllowedScopes {
+		scope = strings.TrimSpace(scope)
+		if _, ok := scopeRegistry[scope]; !ok {
+			return IntegrationPolicy{}, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !slices.Contains(allowed, scope) {
+			allowed = append(allowed, scope)
+		}
+	}
+	if !slices.Contains(allowed, ScopeIdentity) {
+		return IntegrationPolicy{}, fmt.Errorf("%w: allowed scopes must include %s", ErrIdentityScopeRequired, ScopeIdentity)
+	}
+	slices.Sort(allowed)
+	policy.AllowedScopes = allowed
+	return policy, nil
+}
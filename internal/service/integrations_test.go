@@ -2,7 +2,9 @@ package service_test
 
 import (
 	"errors"
+	"slices"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
@@ -12,7 +14,7 @@ func TestCreateIntegration_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.CreateIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := env.Service.CreateIntegration(t.Context(), "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
 	if err != nil {
 		t.Fatalf("CreateIntegration failed: %v", err)
 	}
@@ -22,11 +24,11 @@ func TestCreateIntegration_DuplicateName(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if err := env.Service.CreateIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback"); err != nil {
+	if err := env.Service.CreateIntegration(t.Context(), "svc-a", "Service A", "aud-a", "https://svc-a.test/callback"); err != nil {
 		t.Fatalf("CreateIntegration failed: %v", err)
 	}
 
-	err := env.Service.CreateIntegration("svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := env.Service.CreateIntegration(t.Context(), "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
 	if !errors.Is(err, service.ErrIntegrationExists) {
 		t.Fatalf("expected ErrIntegrationExists, got %v", err)
 	}
@@ -36,7 +38,7 @@ func TestCreateIntegration_InvalidRedirect(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.CreateIntegration("svc-a", "Service A", "aud-a", "bad-url")
+	err := env.Service.CreateIntegration(t.Context(), "svc-a", "Service A", "aud-a", "bad-url")
 	if !errors.Is(err, service.ErrInvalidRedirect) {
 		t.Fatalf("expected ErrInvalidRedirect, got %v", err)
 	}
@@ -46,7 +48,7 @@ func TestCreateIntegration_InvalidName(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.CreateIntegration("", "Service A", "aud-a", "https://svc-a.test/callback")
+	err := env.Service.CreateIntegration(t.Context(), "", "Service A", "aud-a", "https://svc-a.test/callback")
 	if !errors.Is(err, service.ErrInvalidIntegration) {
 		t.Fatalf("expected ErrInvalidIntegration, got %v", err)
 	}
@@ -57,6 +59,7 @@ func TestCreateIntegration_ProtectedName(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	err := env.Service.CreateIntegration(
+		t.Context(),
 		service.InternalIntegrationName,
 		"Consent",
 		"consent.test",
@@ -72,7 +75,7 @@ func TestGetIntegration_Success(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
 
-	integration, err := env.Service.GetIntegration("svc-a")
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -88,7 +91,7 @@ func TestGetIntegration_NotFound(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.GetIntegration("missing")
+	_, err := env.Service.GetIntegration(t.Context(), "missing")
 	if !errors.Is(err, service.ErrIntegrationNotFound) {
 		t.Fatalf("expected ErrIntegrationNotFound, got %v", err)
 	}
@@ -102,12 +105,12 @@ func TestUpdateIntegration_Success(t *testing.T) {
 	display := "Service A2"
 	audience := "aud-b"
 	redirect := "https://svc-a.test/new"
-	err := env.Service.UpdateIntegration("svc-a", &service.IntegrationUpdate{Display: &display, Audience: &audience, Redirect: &redirect})
+	err := env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Display: &display, Audience: &audience, Redirect: &redirect})
 	if err != nil {
 		t.Fatalf("UpdateIntegration failed: %v", err)
 	}
 
-	integration, err := env.Service.GetIntegration("svc-a")
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("GetIntegration failed: %v", err)
 	}
@@ -121,7 +124,7 @@ func TestUpdateIntegration_NotFound(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	display := "Service A2"
-	err := env.Service.UpdateIntegration("missing", &service.IntegrationUpdate{Display: &display})
+	err := env.Service.UpdateIntegration(t.Context(), "missing", &service.IntegrationUpdate{Display: &display})
 	if !errors.Is(err, service.ErrIntegrationNotFound) {
 		t.Fatalf("expected ErrIntegrationNotFound, got %v", err)
 	}
@@ -133,7 +136,7 @@ func TestUpdateIntegration_InvalidRedirect(t *testing.T) {
 	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
 
 	redirect := "bad-url"
-	err := env.Service.UpdateIntegration("svc-a", &service.IntegrationUpdate{Redirect: &redirect})
+	err := env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Redirect: &redirect})
 	if !errors.Is(err, service.ErrInvalidRedirect) {
 		t.Fatalf("expected ErrInvalidRedirect, got %v", err)
 	}
@@ -144,7 +147,7 @@ func TestUpdateIntegration_ProtectedName(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	display := "Renamed"
-	err := env.Service.UpdateIntegration(service.InternalIntegrationName, &service.IntegrationUpdate{Display: &display})
+	err := env.Service.UpdateIntegration(t.Context(), service.InternalIntegrationName, &service.IntegrationUpdate{Display: &display})
 	if !errors.Is(err, service.ErrIntegrationProtected) {
 		t.Fatalf("expected ErrIntegrationProtected, got %v", err)
 	}
@@ -155,12 +158,12 @@ func TestDeleteIntegration_Success(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
 
-	err := env.Service.DeleteIntegration("svc-a")
+	err := env.Service.DeleteIntegration(t.Context(), "svc-a")
 	if err != nil {
 		t.Fatalf("DeleteIntegration failed: %v", err)
 	}
 
-	_, err = env.Service.GetIntegration("svc-a")
+	_, err = env.Service.GetIntegration(t.Context(), "svc-a")
 	if !errors.Is(err, service.ErrIntegrationNotFound) {
 		t.Fatalf("expected ErrIntegrationNotFound, got %v", err)
 	}
@@ -170,7 +173,7 @@ func TestDeleteIntegration_NotFound(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.DeleteIntegration("missing")
+	err := env.Service.DeleteIntegration(t.Context(), "missing")
 	if !errors.Is(err, service.ErrIntegrationNotFound) {
 		t.Fatalf("expected ErrIntegrationNotFound, got %v", err)
 	}
@@ -180,7 +183,7 @@ func TestDeleteIntegration_ProtectedName(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.DeleteIntegration(service.InternalIntegrationName)
+	err := env.Service.DeleteIntegration(t.Context(), service.InternalIntegrationName)
 	if !errors.Is(err, service.ErrIntegrationProtected) {
 		t.Fatalf("expected ErrIntegrationProtected, got %v", err)
 	}
@@ -190,7 +193,7 @@ func TestListIntegrations_Empty(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	integrations, err := env.Service.ListIntegrations()
+	integrations, err := env.Service.ListIntegrations(t.Context())
 	if err != nil {
 		t.Fatalf("ListIntegrations failed: %v", err)
 	}
@@ -208,7 +211,7 @@ func TestListIntegrations_Multiple(t *testing.T) {
 	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
 	env.CreateTestIntegration(t, "svc-b", "Service B", "aud-b", "https://svc-b.test/callback")
 
-	integrations, err := env.Service.ListIntegrations()
+	integrations, err := env.Service.ListIntegrations(t.Context())
 	if err != nil {
 		t.Fatalf("ListIntegrations failed: %v", err)
 	}
@@ -222,3 +225,242 @@ func TestListIntegrations_Multiple(t *testing.T) {
 		t.Errorf("expected svc-a second, got %s", integrations[1].Name)
 	}
 }
+
+func TestCreateIntegration_MultipleRedirects(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	err := env.Service.CreateIntegration(
+		t.Context(),
+		"svc-a", "Service A", "aud-a",
+		"https://svc-a.test/callback",
+		"https://staging.svc-a.test/callback",
+		"https://svc-a.test/callback", // duplicate of the default is dropped
+		"",
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegration failed: %v", err)
+	}
+
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	want := []string{"https://svc-a.test/callback", "https://staging.svc-a.test/callback"}
+	if !slices.Equal(integration.RedirectURIs(), want) {
+		t.Fatalf("RedirectURIs = %v, want %v", integration.RedirectURIs(), want)
+	}
+}
+
+func TestCreateIntegration_InvalidExtraRedirect(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	err := env.Service.CreateIntegration(t.Context(), "svc-a", "Service A", "aud-a", "https://svc-a.test/callback", "not-a-url")
+	if !errors.Is(err, service.ErrInvalidRedirect) {
+		t.Fatalf("expected ErrInvalidRedirect, got %v", err)
+	}
+}
+
+func TestUpdateIntegration_Redirects(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+
+	redirects := []string{"https://staging.svc-a.test/callback"}
+	err := env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Redirects: &redirects})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+
+	// promoting an extra redirect to default removes it from the extras
+	promoted := "https://staging.svc-a.test/callback"
+	err = env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{Redirect: &promoted})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if integration.Redirect != promoted || len(integration.Redirects) != 0 {
+		t.Fatalf("integration = %#v, want single promoted redirect", integration)
+	}
+}
+
+func TestIntegrationResolveRedirect(t *testing.T) {
+	t.Parallel()
+
+	single := service.Integration{Redirect: "https://app.test/callback"}
+	multiple := service.Integration{
+		Redirect:  "https://app.test/callback",
+		Redirects: []string{"https://staging.app.test/callback"},
+	}
+	loopback := service.Integration{Redirect: "http://localhost/callback"}
+	loopbackIP := service.Integration{Redirect: "http://127.0.0.1/callback"}
+	ported := service.Integration{Redirect: "http://localhost:10000/callback"}
+
+	cases := []struct {
+		name        string
+		integration service.Integration
+		requested   string
+		want        string
+		wantErr     bool
+	}{
+		{"single default", single, "", "https://app.test/callback", false},
+		{"single exact", single, "https://app.test/callback", "https://app.test/callback", false},
+		{"multiple requires choice", multiple, "", "", true},
+		{"multiple exact extra", multiple, "https://staging.app.test/callback", "https://staging.app.test/callback", false},
+		{"prefix is not a match", multiple, "https://app.test/callback/evil", "", true},
+		{"query is not a match", single, "https://app.test/callback?next=evil", "", true},
+		{"unregistered", single, "https://evil.test/callback", "", true},
+		{"loopback any port", loopback, "http://localhost:3000/callback", "http://localhost:3000/callback", false},
+		{"loopback ip any port", loopbackIP, "http://127.0.0.1:8080/callback", "http://127.0.0.1:8080/callback", false},
+		{"loopback default", loopback, "", "http://localhost/callback", false},
+		{"loopback other path", loopback, "http://localhost:3000/other", "", true},
+		{"loopback other host", loopback, "http://localhost.evil.test:3000/callback", "", true},
+		{"loopback needs portless registration", ported, "http://localhost:3000/callback", "", true},
+		{"https is not loopback", single, "https://app.test:8443/callback", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.integration.ResolveRedirect(tc.requested)
+			if tc.wantErr {
+				if !errors.Is(err, service.ErrInvalidRedirect) {
+					t.Fatalf("expected ErrInvalidRedirect, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveRedirect failed: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("redirect = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCreateIntegrationWithPolicy_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	err := env.Service.CreateIntegrationWithPolicy(
+		t.Context(),
+		"svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil,
+		service.IntegrationPolicy{
+			AccessTokenLifetime:  5 * time.Minute,
+			RefreshTokenLifetime: 24 * time.Hour,
+			AllowedScopes:        []string{"profile", "identity", "identity"},
+			ReuseRefreshTokens:   true,
+		},
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegrationWithPolicy failed: %v", err)
+	}
+
+	// policy round-trips with allowed scopes cleaned up
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	policy := integration.Policy
+	if policy.AccessTokenLifetime != 5*time.Minute || policy.RefreshTokenLifetime != 24*time.Hour {
+		t.Errorf("lifetimes = %v/%v, want 5m/24h", policy.AccessTokenLifetime, policy.RefreshTokenLifetime)
+	}
+	if !slices.Equal(policy.AllowedScopes, []string{"identity", "profile"}) {
+		t.Errorf("AllowedScopes = %v, want [identity profile]", policy.AllowedScopes)
+	}
+	if !policy.ReuseRefreshTokens {
+		t.Error("expected ReuseRefreshTokens to be set")
+	}
+}
+
+func TestCreateIntegrationWithPolicy_Invalid(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	tests := []struct {
+		name   string
+		policy service.IntegrationPolicy
+		want   error
+	}{
+		{"negative lifetime", service.IntegrationPolicy{AccessTokenLifetime: -time.Minute}, service.ErrInvalidIntegration},
+		{"access outlives refresh", service.IntegrationPolicy{AccessTokenLifetime: 2 * time.Hour, RefreshTokenLifetime: time.Hour}, service.ErrInvalidIntegration},
+		{"unknown scope", service.IntegrationPolicy{AllowedScopes: []string{"identity", "admin"}}, service.ErrInvalidScope},
+		{"missing identity", service.IntegrationPolicy{AllowedScopes: []string{"profile"}}, service.ErrIdentityScopeRequired},
+		{"negative auth code lifetime", service.IntegrationPolicy{AuthCodeLifetime: -time.Second}, service.ErrInvalidIntegration},
+		{"auth code lifetime too long", service.IntegrationPolicy{AuthCodeLifetime: time.Hour}, service.ErrInvalidIntegration},
+		{"unknown claim", service.IntegrationPolicy{ReleasedClaims: []string{"email", "ssn"}}, service.ErrInvalidIntegration},
+	}
+	for _, tt := range tests {
+		err := env.Service.CreateIntegrationWithPolicy(t.Context(), "svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil, tt.policy)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestUpdateIntegration_Policy(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.CreateTestIntegration(t, "svc-a", "Service A", "aud-a", "https://svc-a.test/callback")
+
+	// setting a policy leaves other fields alone
+	display := "Service A2"
+	err := env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{
+		Display: &display,
+		Policy:  &service.IntegrationPolicy{AccessTokenLifetime: 10 * time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	integration, err := env.Service.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if integration.Display != display || integration.Policy.AccessTokenLifetime != 10*time.Minute {
+		t.Fatalf("integration = %+v, want updated display and policy", integration)
+	}
+
+	// a zero policy restores the defaults
+	err = env.Service.UpdateIntegration(t.Context(), "svc-a", &service.IntegrationUpdate{
+		Policy: &service.IntegrationPolicy{},
+	})
+	if err != nil {
+		t.Fatalf("UpdateIntegration failed: %v", err)
+	}
+	integration, err = env.Service.GetIntegration(t.Context(), "svc-a")
+	if err != nil {
+		t.Fatalf("GetIntegration failed: %v", err)
+	}
+	if integration.Policy.AccessTokenLifetime != 0 {
+		t.Errorf("AccessTokenLifetime = %v, want unset", integration.Policy.AccessTokenLifetime)
+	}
+}
+
+func TestReviewAuthorizationRequest_AllowedScopes(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	err := env.Service.CreateIntegrationWithPolicy(
+		t.Context(),
+		"svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil,
+		service.IntegrationPolicy{AllowedScopes: []string{"identity"}},
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegrationWithPolicy failed: %v", err)
+	}
+
+	// allowed scopes can be requested
+	if _, err := env.Service.ReviewAuthorizationRequest(t.Context(), "subject-alice", "svc-a", []string{"identity"}, "", "", ""); err != nil {
+		t.Fatalf("ReviewAuthorizationRequest failed: %v", err)
+	}
+
+	// scopes outside the policy are rejected
+	_, err = env.Service.ReviewAuthorizationRequest(t.Context(), "subject-alice", "svc-a", []string{"identity", "profile"}, "", "", "")
+	if !errors.Is(err, service.ErrInvalidScope) {
+		t.Errorf("expected ErrInvalidScope, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// ExternalIdentity is an upstream provider subject linked to a local user.
+type ExternalIdentity struct {
+	Provider  string
+	Subject   string
+	CreatedAt time.Time
+}
+
+// ListLinkedIdentities returns the upstream identities linked to the user.
+func (s *Service) ListLinkedIdentities(
+	ctx context.Context,
+	subject string,
+) (
+	[]ExternalIdentity,
+	error,
+) {
+	if subject == "" {
+		return nil, ErrInvalidUser
+	}
+
+	identities, err := s.store.ListExternalIdentities(ctx, subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list linked identities: %v", ErrInternal, err)
+	}
+	return identities, nil
+}
+
+// ListAccountLinks returns the upstream identities linked to the account that
+// owns the access token.
+func (s *Service) ListAccountLinks(
+	ctx context.Context,
+	encodedAccessToken string,
+) (
+	[]ExternalIdentity,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.resourceTokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	return s.ListLinkedIdentities(ctx, accessToken.Subject())
+}
+
+// LinkUpstreamIdentity exchanges an upstream authorization code, with its PKCE
+// verifier, and links the resulting upstream subject to an existing local
+// user, so either credential resolves to the same subject at login. Linking an
+// identity the user already owns is a no-op.
+func (s *Service) LinkUpstreamIdentity(
+	ctx context.Context,
+	subject string,
+	providerName string,
+	code string,
+	verifier string,
+) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if subject == "" {
+		return ErrInvalidUser
+	}
+
+	identity, err := s.fetchUpstreamIdentity(ctx, providerName, code, verifier)
+	if err != nil {
+		return err
+	}
+
+	owner, err := s.store.GetUserByExternalIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if owner.Subject == subject {
+			return nil
+		}
+		return ErrIdentityLinked
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: failed to resolve external identity: %v", ErrInternal, err)
+	}
+
+	err = s.store.InsertExternalIdentity(ctx, subject, identity.Provider, identity.Subject, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, subject)
+		}
+		if isUniqueConstraintError(err) {
+			return ErrIdentityLinked
+		}
+		return fmt.Errorf("%w: failed to link external identity: %v", ErrInternal, err)
+	}
+
+	return nil
+}
+
+// UnlinkIdentity removes the links to an upstream provider from the account
+// that owns the access token. The caller must re-enter the account password,
+// which also keeps accounts that can only sign in upstream from locking
+// themselves out.
+func (s *Service) UnlinkIdentity(
+	ctx context.Context,
+	encodedAccessToken string,
+	password string,
+	providerName string,
+) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	user, err := s.authenticateAccountRequest(ctx, encodedAccessToken, password)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.store.DeleteExternalIdentities(ctx, user.Subject, providerName)
+	if err != nil {
+		return fmt.Errorf("%w: failed to unlink identity: %v", ErrInternal, err)
+	}
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrIdentityLinkNotFound, providerName)
+	}
+
+	return nil
+}
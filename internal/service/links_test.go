@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+func TestLinkUpstreamIdentity_LoginResolvesToLinkedUser(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{
+		"sub":                "ext-123",
+		"preferred_username": "someone-else",
+	})
+	env.RegisterTestUser(t, "alice", "password")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+
+	if err := env.Service.LinkUpstreamIdentity(t.Context(), alice.Subject, "corp", "good", upstreamVerifier); err != nil {
+		t.Fatalf("LinkUpstreamIdentity failed: %v", err)
+	}
+
+	// upstream login now resolves to the existing local user
+	if _, err := env.Service.CompleteUpstreamLogin(t.Context(), "corp", "good", upstreamVerifier, "/"); err != nil {
+		t.Fatalf("CompleteUpstreamLogin failed: %v", err)
+	}
+	user, err := env.DB.GetUserByExternalIdentity(t.Context(), "corp", "ext-123")
+	if err != nil {
+		t.Fatalf("GetUserByExternalIdentity failed: %v", err)
+	}
+	if user.Subject != alice.Subject {
+		t.Fatalf("subject = %q, want %q", user.Subject, alice.Subject)
+	}
+
+	// the password credential still resolves to the same user
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "password", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed: %v", err)
+	}
+
+	// relinking the same identity is a no-op
+	if err := env.Service.LinkUpstreamIdentity(t.Context(), alice.Subject, "corp", "good", upstreamVerifier); err != nil {
+		t.Fatalf("LinkUpstreamIdentity relink failed: %v", err)
+	}
+}
+
+func TestLinkUpstreamIdentity_LinkedToAnotherUser(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{"sub": "ext-123"})
+	env.RegisterTestUser(t, "alice", "password")
+	env.RegisterTestUser(t, "bob", "password")
+	alice, _ := env.DB.GetUserByHandle(t.Context(), "alice")
+	bob, _ := env.DB.GetUserByHandle(t.Context(), "bob")
+
+	if err := env.Service.LinkUpstreamIdentity(t.Context(), alice.Subject, "corp", "good", upstreamVerifier); err != nil {
+		t.Fatalf("LinkUpstreamIdentity failed: %v", err)
+	}
+
+	err := env.Service.LinkUpstreamIdentity(t.Context(), bob.Subject, "corp", "good", upstreamVerifier)
+	if !errors.Is(err, service.ErrIdentityLinked) {
+		t.Fatalf("expected ErrIdentityLinked, got %v", err)
+	}
+}
+
+func TestUnlinkIdentity_RequiresPasswordAndRemovesLink(t *testing.T) {
+	t.Parallel()
+	env := setupUpstreamEnv(t, map[string]any{"sub": "ext-123"})
+	env.RegisterTestUser(t, "alice", "password")
+	token := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	if err := env.Service.LinkUpstreamIdentity(t.Context(), token.Subject(), "corp", "good", upstreamVerifier); err != nil {
+		t.Fatalf("LinkUpstreamIdentity failed: %v", err)
+	}
+
+	// wrong password is rejected
+	err := env.Service.UnlinkIdentity(t.Context(), token.Encoded(), "wrong", "corp")
+	if !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if err := env.Service.UnlinkIdentity(t.Context(), token.Encoded(), "password", "corp"); err != nil {
+		t.Fatalf("UnlinkIdentity failed: %v", err)
+	}
+	links, err := env.Service.ListAccountLinks(t.Context(), token.Encoded())
+	if err != nil {
+		t.Fatalf("ListAccountLinks failed: %v", err)
+	}
+	if len(links) != 0 {
+		t.Fatalf("links = %d, want 0", len(links))
+	}
+
+	// unlinking again reports the missing link
+	err = env.Service.UnlinkIdentity(t.Context(), token.Encoded(), "password", "corp")
+	if !errors.Is(err, service.ErrIdentityLinkNotFound) {
+		t.Fatalf("expected ErrIdentityLinkNotFound, got %v", err)
+	}
+}
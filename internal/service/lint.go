@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// IntegrationProblem is one way an integration definition would be rejected
+// or is unsafe to deploy. Index is the definition's position in the linted
+// list.
+type IntegrationProblem struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (p IntegrationProblem) Error() string {
+	if p.Name == "" {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", p.Name, p.Err)
+}
+
+func (p IntegrationProblem) Unwrap() error {
+	return p.Err
+}
+
+// LintIntegrations checks integration definitions before they are created,
+// without a store. Beyond what CreateIntegrationWithPolicy enforces, it
+// requires https redirects (plain http only for loopback hosts) and that
+// names and audiences are unique across the set. Policies are checked
+// against the default token lifetimes.
+func LintIntegrations(
+	integrations []Integration,
+) []IntegrationProblem {
+	defaults := &Service{
+		defaultAccessLifetime:  AccessTokenLifetime,
+		defaultRefreshLifetime: RefreshTokenLifetime,
+	}
+
+	var problems []IntegrationProblem
+	var index int
+	report := func(name string, err error) {
+		problems = append(problems, IntegrationProblem{Index: index, Name: name, Err: err})
+	}
+
+	names := map[string]bool{}
+	audiences := map[string]string{}
+	for i, integration := range integrations {
+		index = i
+		name := integration.Name
+		switch {
+		case name == "":
+			report("", fmt.Errorf("%w: name is required", ErrInvalidIntegration))
+		case name == InternalIntegrationName:
+			report(name, ErrIntegrationProtected)
+		case names[name]:
+			report(name, fmt.Errorf("%w: defined more than once", ErrIntegrationExists))
+		}
+		names[name] = true
+
+		if integration.Display == "" {
+			report(name, fmt.Errorf("%w: display is required", ErrInvalidIntegration))
+		}
+		if integration.Audience == "" {
+			report(name, fmt.Errorf("%w: audience is required", ErrInvalidIntegration))
+		} else if other, ok := audiences[integration.Audience]; ok {
+			report(name, fmt.Errorf("%w: audience %q is also used by %s", ErrInvalidIntegration, integration.Audience, other))
+		} else {
+			audiences[integration.Audience] = name
+		}
+
+		if integration.Redirect == "" {
+			report(name, fmt.Errorf("%w: redirect is required", ErrInvalidIntegration))
+		} else if _, err := validateRedirects(integration.Redirect, integration.Redirects); err != nil {
+			report(name, err)
+		} else {
+			for _, redirect := range integration.RedirectURIs() {
+				if err := requireSecureRedirect(redirect); err != nil {
+					report(name, err)
+				}
+			}
+		}
+
+		if _, err := defaults.validatePolicy(integration.Policy); err != nil {
+			report(name, err)
+		}
+	}
+	return problems
+}
+
+// requireSecureRedirect rejects redirects that would send authorization
+// codes over plain http to anything but the local machine.
+func requireSecureRedirect(
+	redirect string,
+) error {
+	parsed, err := url.Parse(redirect)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRedirect, err)
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if isLoopbackHost(parsed.Hostname()) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s must use https", ErrInvalidRedirect, redirect)
+	default:
+		return fmt.Errorf("%w: %s must use https", ErrInvalidRedirect, redirect)
+	}
+}
+
+func isLoopbackHost(
+	host string,
+) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
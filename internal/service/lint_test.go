@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLintIntegrations_Valid(t *testing.T) {
+	problems := LintIntegrations([]Integration{
+		{
+			Name:      "notes",
+			Display:   "Notes",
+			Audience:  "notes.example.test",
+			Redirect:  "https://notes.example.test/callback",
+			Redirects: []string{"http://localhost:3000/callback", "http://127.0.0.1:3000/callback"},
+			Policy:    IntegrationPolicy{AllowedScopes: []string{ScopeIdentity, ScopeProfile}},
+		},
+		{
+			Name:     "wiki",
+			Display:  "Wiki",
+			Audience: "wiki.example.test",
+			Redirect: "https://wiki.example.test/callback",
+		},
+	})
+	if len(problems) != 0 {
+		t.Fatalf("LintIntegrations() = %v, want none", problems)
+	}
+}
+
+func TestLintIntegrations_ReportsEveryProblem(t *testing.T) {
+	integrations := []Integration{
+		{
+			Name:     "notes",
+			Display:  "Notes",
+			Audience: "shared.example.test",
+			Redirect: "http://notes.example.test/callback",
+		},
+		{
+			Name:     "notes",
+			Audience: "shared.example.test",
+			Redirect: "not a url",
+			Policy:   IntegrationPolicy{AccessTokenLifetime: 30 * 24 * time.Hour},
+		},
+		{
+			Name:     InternalIntegrationName,
+			Display:  "Consent",
+			Audience: "consent.example.test",
+			Redirect: "https://consent.example.test/auth/callback",
+			Policy:   IntegrationPolicy{AllowedScopes: []string{"unknown"}},
+		},
+	}
+
+	problems := LintIntegrations(integrations)
+
+	want := []struct {
+		index int
+		err   error
+	}{
+		{0, ErrInvalidRedirect},      // plain http to a remote host
+		{1, ErrIntegrationExists},    // duplicate name
+		{1, ErrInvalidIntegration},   // missing display
+		{1, ErrInvalidIntegration},   // duplicate audience
+		{1, ErrInvalidRedirect},      // unparseable redirect
+		{1, ErrInvalidIntegration},   // access lifetime exceeds refresh lifetime
+		{2, ErrIntegrationProtected}, // reserved name
+		{2, ErrInvalidScope},         // unregistered scope
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got %d problems, want %d: %v", len(problems), len(want), problems)
+	}
+	for i, w := range want {
+		if problems[i].Index != w.index || !errors.Is(problems[i], w.err) {
+			t.Errorf("problem %d = [%d] %v, want [%d] %v", i, problems[i].Index, problems[i], w.index, w.err)
+		}
+	}
+}
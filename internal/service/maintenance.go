@@ -0,0 +1,27 @@
+package service
+
+import "fmt"
+
+// SetMaintenance turns maintenance mode on or off. In maintenance mode users
+// can still sign in and refresh their tokens, but nothing can be created,
+// changed, or deleted: registrations, account and profile edits, identity
+// links, and user, role, and integration administration all fail with
+// ErrMaintenance. This keeps the store steady for migrations and backups.
+func (s *Service) SetMaintenance(
+	enabled bool,
+) {
+	s.maintenance.Store(enabled)
+}
+
+// Maintenance reports whether maintenance mode is on.
+func (s *Service) Maintenance() bool {
+	return s.maintenance.Load()
+}
+
+// writable returns ErrMaintenance while maintenance mode is on.
+func (s *Service) writable() error {
+	if s.maintenance.Load() {
+		return fmt.Errorf("%w: changes are paused, try again later", ErrMaintenance)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestMaintenance(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	// setup env
+	env.CreateTestIntegration(t, "app", "App", "app.test", "https://app.test/callback")
+	alice, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, alice.Subject, []string{"app.test"})
+
+	env.Service.SetMaintenance(true)
+	if !env.Service.Maintenance() {
+		t.Fatal("Maintenance() = false after SetMaintenance(true)")
+	}
+
+	// sign-ins and refreshes still work
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Errorf("GrantAuthCode failed: %v", err)
+	}
+	if _, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{}); err != nil {
+		t.Errorf("RefreshAccessToken failed: %v", err)
+	}
+
+	// changes are refused
+	displayName := "Alice"
+	refused := map[string]error{
+		"create user": func() error {
+			_, err := env.Service.CreateUser(t.Context(), "bob", "password123", nil)
+			return err
+		}(),
+		"delete user":        env.Service.DeleteUser(t.Context(), alice.Subject),
+		"update integration": env.Service.UpdateIntegration(t.Context(), "app", &service.IntegrationUpdate{}),
+		"delete integration": env.Service.DeleteIntegration(t.Context(), "app"),
+		"update profile": func() error {
+			_, err := env.Service.UpdateProfile(t.Context(), alice.Subject, &service.ProfileUpdate{DisplayName: &displayName})
+			return err
+		}(),
+		"create role": func() error {
+			_, err := env.Service.CreateRole(t.Context(), "editor", "Editor")
+			return err
+		}(),
+	}
+	for name, err := range refused {
+		if !errors.Is(err, service.ErrMaintenance) {
+			t.Errorf("%s: err = %v, want ErrMaintenance", name, err)
+		}
+	}
+
+	// and accepted again afterwards
+	env.Service.SetMaintenance(false)
+	if _, err := env.Service.CreateUser(t.Context(), "bob", "password123", nil); err != nil {
+		t.Errorf("CreateUser after maintenance failed: %v", err)
+	}
+}
+
+func TestMaintenance_StartsFromOptions(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.Maintenance = true
+	})
+
+	_, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil)
+	if !errors.Is(err, service.ErrMaintenance) {
+		t.Errorf("err = %v, want ErrMaintenance", err)
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// SignInRevocationLifetime is how long the "this wasn't me" link in a
+// new-device notice can end the sessions of its sign-in.
+const SignInRevocationLifetime = 7 * 24 * time.Hour
+
+// SignInRevocationPath is the app page the "this wasn't me" link opens.
+const SignInRevocationPath = "/not-me"
+
+// Mail is a plain text message to a user.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers mail to users. Send is called off the request path, so a
+// slow mail server doesn't hold up sign-ins.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// notifyNewDevice records the device of a password sign-in, and mails the
+// user if it's one they haven't signed in from before, with a link to end
+// every session started since at. Without a Mailer nothing is recorded.
+// Failures are logged rather than failing the sign-in.
+func (s *Service) notifyNewDevice(
+	ctx context.Context,
+	user *User,
+	at time.Time,
+) {
+	if s.mailer == nil {
+		return
+	}
+
+	client := clientInfoFrom(ctx)
+	isNew, err := s.store.RecordLoginDevice(ctx, user.Subject, client, at)
+	if err != nil {
+		log.Printf("new device notice for %s: record device: %v", user.Handle, err)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	profile, err := s.store.GetProfile(ctx, user.Subject)
+	if err != nil {
+		log.Printf("new device notice for %s: read profile: %v", user.Handle, err)
+		return
+	}
+	if profile.Email == "" {
+		return
+	}
+
+	revocation, err := s.tokenIssuer.NewAccessToken().
+		Subject(user.Subject).
+		Audience(s.signInRevocationAudience()).
+		Lifetime(SignInRevocationLifetime).
+		AuthTime(at).
+		Issue()
+	if err != nil {
+		log.Printf("new device notice for %s: issue revocation token: %v", user.Handle, err)
+		return
+	}
+
+	mail := newDeviceMail(profile.Email, user.Handle, client, at, s.signInRevocationURL(revocation.Encoded()))
+	go func() {
+		if err := s.mailer.Send(context.WithoutCancel(ctx), mail); err != nil {
+			log.Printf("new device notice for %s: send: %v", user.Handle, err)
+		}
+	}()
+}
+
+func newDeviceMail(
+	to string,
+	handle string,
+	client ClientInfo,
+	at time.Time,
+	revocationURL string,
+) Mail {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Your account %s was just signed in to from a new device.\n\n", handle)
+	fmt.Fprintf(&body, "Time:    %s\n", at.UTC().Format(time.RFC1123))
+	fmt.Fprintf(&body, "Address: %s\n", cmp.Or(client.IP, "unknown"))
+	fmt.Fprintf(&body, "Browser: %s\n\n", cmp.Or(client.UserAgent, "unknown"))
+	body.WriteString("If this was you, there's nothing to do.\n\n")
+	body.WriteString("If it wasn't, open this link to sign that device out, then change your secret:\n\n")
+	body.WriteString(revocationURL + "\n")
+
+	return Mail{
+		To:      to,
+		Subject: "New sign-in to " + handle,
+		Body:    body.String(),
+	}
+}
+
+func (s *Service) signInRevocationAudience() string {
+	return s.publicURL + SignInRevocationPath
+}
+
+func (s *Service) signInRevocationURL(
+	encodedToken string,
+) string {
+	return s.signInRevocationAudience() + "?" + url.Values{"token": {encodedToken}}.Encode()
+}
+
+// RevokeSignIn ends the sessions started by the sign-in a new-device notice
+// was sent for, along with any the user started after it, given the token
+// from the notice's link. It returns how many sessions were ended. Like
+// signing out, it is allowed in maintenance mode.
+func (s *Service) RevokeSignIn(
+	ctx context.Context,
+	encodedToken string,
+) (
+	int,
+	error,
+) {
+	token := new(tokens.AccessToken)
+	if err := token.Decode(encodedToken, s.tokenValidator); err != nil {
+		return 0, fmt.Errorf("%w: couldn't decode sign-in revocation token: %w", ErrTokenInvalid, err)
+	}
+	if !slices.Contains(token.Audience(), s.signInRevocationAudience()) || token.AuthTime().IsZero() {
+		return 0, fmt.Errorf("%w: not a sign-in revocation token", ErrTokenInvalid)
+	}
+
+	revoked, err := s.tokenStore.DeleteRefreshTokensSince(ctx, token.Subject(), token.AuthTime())
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to revoke sessions: %v", ErrInternal, err)
+	}
+	if revoked > 0 {
+		s.emit(ctx, Event{Type: EventTokenRevoked, Subject: token.Subject()})
+	}
+	return revoked, nil
+}
@@ -0,0 +1,140 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+// testMailer hands sent mail to the test.
+type testMailer struct {
+	sent chan service.Mail
+}
+
+func (m *testMailer) Send(_ context.Context, mail service.Mail) error {
+	m.sent <- mail
+	return nil
+}
+
+func setupMailerEnv(
+	t *testing.T,
+	mailer *testMailer,
+) *testutil.TestEnv {
+	t.Helper()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.Mailer = mailer
+	})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	email := "alice@example.com"
+	if _, err := env.Service.UpdateProfile(t.Context(), user.Subject, &service.ProfileUpdate{Email: &email}); err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+	return env
+}
+
+func loginFrom(
+	t *testing.T,
+	env *testutil.TestEnv,
+	client service.ClientInfo,
+) {
+	t.Helper()
+	ctx := service.WithClientInfo(t.Context(), client)
+	if _, err := env.Service.GrantAuthCode(ctx, "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed: %v", err)
+	}
+}
+
+func receiveMail(
+	t *testing.T,
+	mailer *testMailer,
+) service.Mail {
+	t.Helper()
+	select {
+	case mail := <-mailer.sent:
+		return mail
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail sent")
+		return service.Mail{}
+	}
+}
+
+func TestNotifyNewDevice(t *testing.T) {
+	t.Parallel()
+	mailer := &testMailer{sent: make(chan service.Mail, 4)}
+	env := setupMailerEnv(t, mailer)
+	laptop := service.ClientInfo{IP: "192.0.2.1", UserAgent: "laptop"}
+	phone := service.ClientInfo{IP: "198.51.100.1", UserAgent: "phone"}
+
+	// the first device and devices seen before send nothing
+	loginFrom(t, env, laptop)
+	loginFrom(t, env, laptop)
+
+	// a new device is reported with its details and a revocation link
+	loginFrom(t, env, phone)
+	mail := receiveMail(t, mailer)
+	if mail.To != "alice@example.com" {
+		t.Errorf("To = %s, want alice@example.com", mail.To)
+	}
+	for _, want := range []string{"198.51.100.1", "phone", "https://consent.test/not-me?token="} {
+		if !strings.Contains(mail.Body, want) {
+			t.Errorf("Body missing %q:\n%s", want, mail.Body)
+		}
+	}
+
+	select {
+	case extra := <-mailer.sent:
+		t.Errorf("unexpected mail %q", extra.Subject)
+	default:
+	}
+}
+
+func TestRevokeSignIn(t *testing.T) {
+	t.Parallel()
+	mailer := &testMailer{sent: make(chan service.Mail, 4)}
+	env := setupMailerEnv(t, mailer)
+	loginFrom(t, env, service.ClientInfo{IP: "192.0.2.1", UserAgent: "laptop"})
+
+	// the session started by the reported sign-in ends with the link
+	loginFrom(t, env, service.ClientInfo{IP: "198.51.100.1", UserAgent: "phone"})
+	session := env.StoreTestRefreshToken(t, "alice", []string{"consent.test"})
+	mail := receiveMail(t, mailer)
+	link, err := url.Parse(strings.TrimSpace(mail.Body[strings.Index(mail.Body, "https://"):]))
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	token := link.Query().Get("token")
+
+	revoked, err := env.Service.RevokeSignIn(t.Context(), token)
+	if err != nil {
+		t.Fatalf("RevokeSignIn failed: %v", err)
+	}
+	if revoked != 1 {
+		t.Errorf("revoked = %d, want 1", revoked)
+	}
+	if _, err := env.DB.GetRefreshTokenOwner(t.Context(), session.Encoded()); err == nil {
+		t.Error("expected the sign-in's session to be revoked")
+	}
+}
+
+func TestRevokeSignIn_RejectsOtherTokens(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithUsers(t, testutil.TestUser{Handle: "alice", Password: "password123"})
+
+	// ordinary access tokens can't end sessions
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{"https://consent.test"})
+	if _, err := env.Service.RevokeSignIn(t.Context(), accessToken.Encoded()); !errors.Is(err, service.ErrTokenInvalid) {
+		t.Errorf("RevokeSignIn err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := env.Service.RevokeSignIn(t.Context(), "garbage"); !errors.Is(err, service.ErrTokenInvalid) {
+		t.Errorf("RevokeSignIn err = %v, want ErrTokenInvalid", err)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHasher runs bcrypt on a bounded number of workers. Hashing is
+// deliberately slow and CPU-bound, so a burst of registrations or sign-ins
+// would otherwise occupy every core and starve the rest of the server.
+// Requests beyond the limit wait their turn, or give up when their context
+// ends, without doing the work.
+type passwordHasher struct {
+	cost    int
+	workers chan struct{}
+}
+
+func newPasswordHasher(
+	cost int,
+	workers int,
+) *passwordHasher {
+	return &passwordHasher{
+		cost:    cost,
+		workers: make(chan struct{}, workers),
+	}
+}
+
+// hash returns the bcrypt hash of password at the hasher's cost.
+func (h *passwordHasher) hash(
+	ctx context.Context,
+	password []byte,
+) (
+	[]byte,
+	error,
+) {
+	var hash []byte
+	err := h.do(ctx, func() (err error) {
+		hash, err = bcrypt.GenerateFromPassword(password, h.cost)
+		return err
+	})
+	return hash, err
+}
+
+// compare reports whether password matches hash, returning
+// ErrInvalidCredentials when it doesn't.
+func (h *passwordHasher) compare(
+	ctx context.Context,
+	hash []byte,
+	password []byte,
+) error {
+	var mismatch error
+	err := h.do(ctx, func() error {
+		mismatch = bcrypt.CompareHashAndPassword(hash, password)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mismatch != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+func (h *passwordHasher) do(
+	ctx context.Context,
+	work func() error,
+) error {
+	select {
+	case h.workers <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%w: waiting to hash password: %v", ErrBusy, ctx.Err())
+	}
+	defer func() { <-h.workers }()
+	return work()
+}
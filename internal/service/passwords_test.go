@@ -0,0 +1,61 @@
+package service_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateUser_PasswordCost(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.PasswordMode = service.PasswordModeProduction
+		options.PasswordCost = bcrypt.DefaultCost + 1
+		options.PasswordWorkers = 1
+	})
+
+	// new hashes use the configured cost
+	if _, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	hash, err := env.DB.GetSecret(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetSecret failed: %v", err)
+	}
+	if cost, _ := bcrypt.Cost(hash); cost != bcrypt.DefaultCost+1 {
+		t.Errorf("cost = %d, want %d", cost, bcrypt.DefaultCost+1)
+	}
+
+	// and still check out through the worker pool
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed: %v", err)
+	}
+}
+
+func TestNew_RejectsInvalidPasswordSettings(t *testing.T) {
+	t.Parallel()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	cases := map[string]service.Options{
+		"cost below default": {PasswordCost: bcrypt.MinCost},
+		"cost above max":     {PasswordCost: bcrypt.MaxCost + 1},
+		"negative workers":   {PasswordWorkers: -1},
+	}
+	for name, options := range cases {
+		options.Store = testutil.SetupTestDB(t)
+		options.TokenServerOpts = tokens.ServerOptions{SigningKey: key}
+		if _, err := service.New(options); err == nil || !strings.Contains(err.Error(), "password") {
+			t.Errorf("%s: New err = %v, want password error", name, err)
+		}
+	}
+}
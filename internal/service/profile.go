@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxAvatarURLLength   = 2048
+)
+
+// Profile holds optional, user-editable details shown to integrations that
+// were granted the profile or email scopes. Empty fields are unset.
+type Profile struct {
+	DisplayName string
+	Email       string
+	AvatarURL   string
+}
+
+// ProfileUpdate describes a partial profile change. Nil fields are left as
+// they are; empty strings clear the field.
+type ProfileUpdate struct {
+	DisplayName *string
+	Email       *string
+	AvatarURL   *string
+}
+
+// GetProfile returns the profile of the user identified by subject.
+func (s *Service) GetProfile(
+	ctx context.Context,
+	subject string,
+) (
+	*Profile,
+	error,
+) {
+	if subject == "" {
+		return nil, ErrInvalidUser
+	}
+
+	profile, err := s.store.GetProfile(ctx, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, subject)
+		}
+		return nil, fmt.Errorf("%w: failed to get profile: %v", ErrInternal, err)
+	}
+
+	return profile, nil
+}
+
+// UpdateProfile applies a partial update to the profile of the user
+// identified by subject and returns the stored result.
+func (s *Service) UpdateProfile(
+	ctx context.Context,
+	subject string,
+	updates *ProfileUpdate,
+) (
+	*Profile,
+	error,
+) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if updates == nil {
+		return nil, ErrInvalidUpdate
+	}
+
+	profile, err := s.GetProfile(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if updates.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*updates.DisplayName)
+	}
+	if updates.Email != nil {
+		profile.Email = strings.TrimSpace(*updates.Email)
+	}
+	if updates.AvatarURL != nil {
+		profile.AvatarURL = strings.TrimSpace(*updates.AvatarURL)
+	}
+
+	if err := validateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.UpsertProfile(ctx, subject, profile); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, subject)
+		}
+		return nil, fmt.Errorf("%w: failed to update profile: %v", ErrInternal, err)
+	}
+
+	return profile, nil
+}
+
+// GetAccountProfile returns the profile of the account that owns the access token.
+func (s *Service) GetAccountProfile(
+	ctx context.Context,
+	encodedAccessToken string,
+) (
+	*Profile,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.resourceTokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	profile, err := s.GetProfile(ctx, accessToken.Subject())
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrAccountNotFound
+	}
+	return profile, err
+}
+
+// UpdateAccountProfile updates the profile of the account that owns the
+// access token. Unlike handle changes, no password is required.
+func (s *Service) UpdateAccountProfile(
+	ctx context.Context,
+	encodedAccessToken string,
+	updates *ProfileUpdate,
+) (
+	*Profile,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.resourceTokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	profile, err := s.UpdateProfile(ctx, accessToken.Subject(), updates)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrAccountNotFound
+	}
+	return profile, err
+}
+
+func validateProfile(
+	profile *Profile,
+) error {
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+		return fmt.Errorf("%w: display name longer than %d characters", ErrInvalidProfile, maxDisplayNameLength)
+	}
+	if strings.ContainsFunc(profile.DisplayName, isControlRune) {
+		return fmt.Errorf("%w: display name contains control characters", ErrInvalidProfile)
+	}
+
+	if profile.Email != "" {
+		address, err := mail.ParseAddress(profile.Email)
+		if err != nil || address.Name != "" || address.Address != profile.Email {
+			return fmt.Errorf("%w: invalid email address", ErrInvalidProfile)
+		}
+	}
+
+	if profile.AvatarURL != "" {
+		if len(profile.AvatarURL) > maxAvatarURLLength {
+			return fmt.Errorf("%w: avatar URL too long", ErrInvalidProfile)
+		}
+		parsed, err := url.Parse(profile.AvatarURL)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+			return fmt.Errorf("%w: avatar URL must be an absolute http(s) URL", ErrInvalidProfile)
+		}
+	}
+
+	return nil
+}
+
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
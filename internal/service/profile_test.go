@@ -0,0 +1,154 @@
+package service_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func ptr(s string) *string { return &s }
+
+func TestUpdateAccountProfile_Success(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{consentAudience})
+
+	profile, err := env.Service.UpdateAccountProfile(t.Context(), accessToken.Encoded(), &service.ProfileUpdate{
+		DisplayName: ptr("  Alice  "),
+		Email:       ptr("alice@example.com"),
+	})
+	if err != nil {
+		t.Fatalf("UpdateAccountProfile failed: %v", err)
+	}
+	if profile.DisplayName != "Alice" || profile.Email != "alice@example.com" {
+		t.Fatalf("profile = %#v", profile)
+	}
+
+	// partial updates leave other fields alone
+	profile, err = env.Service.UpdateAccountProfile(t.Context(), accessToken.Encoded(), &service.ProfileUpdate{
+		AvatarURL: ptr("https://example.com/alice.png"),
+	})
+	if err != nil {
+		t.Fatalf("UpdateAccountProfile failed: %v", err)
+	}
+	if profile.DisplayName != "Alice" || profile.AvatarURL != "https://example.com/alice.png" {
+		t.Fatalf("profile = %#v", profile)
+	}
+
+	stored, err := env.Service.GetAccountProfile(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("GetAccountProfile failed: %v", err)
+	}
+	if *stored != *profile {
+		t.Fatalf("stored = %#v, want %#v", stored, profile)
+	}
+}
+
+func TestUpdateProfile_Validation(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		update service.ProfileUpdate
+	}{
+		{"long display name", service.ProfileUpdate{DisplayName: ptr(strings.Repeat("a", 65))}},
+		{"control characters", service.ProfileUpdate{DisplayName: ptr("Al\nice")}},
+		{"bad email", service.ProfileUpdate{Email: ptr("not-an-email")}},
+		{"named email", service.ProfileUpdate{Email: ptr("Alice <alice@example.com>")}},
+		{"relative avatar", service.ProfileUpdate{AvatarURL: ptr("/alice.png")}},
+		{"non-http avatar", service.ProfileUpdate{AvatarURL: ptr("javascript://example.com/x")}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := env.Service.UpdateProfile(t.Context(), user.Subject, &tc.update)
+			if !errors.Is(err, service.ErrInvalidProfile) {
+				t.Fatalf("expected ErrInvalidProfile, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateProfile_UnknownUser(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	_, err := env.Service.UpdateProfile(t.Context(), "missing", &service.ProfileUpdate{DisplayName: ptr("Ghost")})
+	if !errors.Is(err, service.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestGetAccountProfile_RequiresConsentAudience(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	accessToken := env.IssueTestAccessToken(t, "alice", []string{"test-audience"})
+
+	_, err := env.Service.GetAccountProfile(t.Context(), accessToken.Encoded())
+	if !errors.Is(err, service.ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func TestProfile_ReleasedByScope(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	user, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	if _, err := env.Service.UpdateProfile(t.Context(), user.Subject, &service.ProfileUpdate{
+		DisplayName: ptr("Alice"),
+		Email:       ptr("alice@example.com"),
+		AvatarURL:   ptr("https://example.com/alice.png"),
+	}); err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+
+	// profile scope releases name and avatar but not email
+	accessToken := env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience", consentAudience}, []string{"identity", "profile"})
+	userInfo, err := env.Service.GetUserInfo(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("GetUserInfo failed: %v", err)
+	}
+	if userInfo.Profile == nil || userInfo.Profile.DisplayName != "Alice" || userInfo.Profile.AvatarURL == "" {
+		t.Fatalf("profile = %#v", userInfo.Profile)
+	}
+	if userInfo.Email != "" {
+		t.Fatalf("email = %q, want empty without email scope", userInfo.Email)
+	}
+
+	// email scope releases the email address in userinfo and the ID token
+	accessToken = env.IssueTestAccessTokenWithScopes(t, "alice", []string{"test-audience", consentAudience}, []string{"identity", "email"})
+	userInfo, err = env.Service.GetUserInfo(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("GetUserInfo failed: %v", err)
+	}
+	if userInfo.Email != "alice@example.com" || userInfo.Profile != nil {
+		t.Fatalf("userInfo = %#v", userInfo)
+	}
+
+	encoded, err := env.Service.IssueIDToken(t.Context(), accessToken.Encoded())
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+	idToken := new(tokens.IDToken)
+	if err := idToken.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode id token: %v", err)
+	}
+	if idToken.Profile().Email != "alice@example.com" || idToken.Profile().Name != "" {
+		t.Fatalf("id token profile = %#v", idToken.Profile())
+	}
+}
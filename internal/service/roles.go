@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -22,18 +23,19 @@ type RoleUpdate struct {
 }
 
 func SeedSystemRoles(
+	ctx context.Context,
 	store Store,
 ) error {
 	if store == nil {
 		return fmt.Errorf("service: store required")
 	}
 
-	if _, err := store.GetRole(ProtectedAdminRoleName); err != nil {
+	if _, err := store.GetRole(ctx, ProtectedAdminRoleName); err != nil {
 		if !errors.Is(err, sql.ErrNoRows) {
 			return fmt.Errorf("service: check existing admin role: %w", err)
 		}
 
-		if err := store.InsertRole(ProtectedAdminRoleName, ProtectedAdminRoleDisplay); err != nil {
+		if err := store.InsertRole(ctx, ProtectedAdminRoleName, ProtectedAdminRoleDisplay); err != nil {
 			return fmt.Errorf("service: create admin role: %w", err)
 		}
 	}
@@ -42,12 +44,16 @@ func SeedSystemRoles(
 }
 
 func (s *Service) CreateRole(
+	ctx context.Context,
 	name string,
 	display string,
 ) (
 	*Role,
 	error,
 ) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
 	if name == "" {
 		return nil, ErrInvalidHandle
 	}
@@ -59,7 +65,7 @@ func (s *Service) CreateRole(
 		return nil, ErrInvalidHandle
 	}
 
-	err := s.store.InsertRole(name, display)
+	err := s.store.InsertRole(ctx, name, display)
 	if err != nil {
 		if isUniqueConstraintError(err) {
 			return nil, ErrRoleExists
@@ -74,6 +80,7 @@ func (s *Service) CreateRole(
 }
 
 func (s *Service) GetRole(
+	ctx context.Context,
 	name string,
 ) (
 	*Role,
@@ -83,7 +90,7 @@ func (s *Service) GetRole(
 		return nil, ErrInvalidHandle
 	}
 
-	record, err := s.store.GetRole(name)
+	record, err := s.store.GetRole(ctx, name)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: %s", ErrRoleNotFound, name)
@@ -95,12 +102,16 @@ func (s *Service) GetRole(
 }
 
 func (s *Service) UpdateRole(
+	ctx context.Context,
 	name string,
 	display *string,
 ) (
 	*Role,
 	error,
 ) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
 	if name == "" {
 		return nil, ErrInvalidHandle
 	}
@@ -112,7 +123,7 @@ func (s *Service) UpdateRole(
 		Display: display,
 	}
 
-	err := s.store.UpdateRole(name, updates)
+	err := s.store.UpdateRole(ctx, name, updates)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: %s", ErrRoleNotFound, name)
@@ -126,7 +137,7 @@ func (s *Service) UpdateRole(
 		}
 	}
 
-	current, err := s.store.GetRole(name)
+	current, err := s.store.GetRole(ctx, name)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to get role: %v", ErrInternal, err)
 	}
@@ -135,8 +146,12 @@ func (s *Service) UpdateRole(
 }
 
 func (s *Service) DeleteRole(
+	ctx context.Context,
 	name string,
 ) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if name == "" {
 		return ErrInvalidHandle
 	}
@@ -144,7 +159,7 @@ func (s *Service) DeleteRole(
 		return ErrRoleProtected
 	}
 
-	deleted, err := s.store.DeleteRole(name)
+	deleted, err := s.store.DeleteRole(ctx, name)
 	if err != nil {
 		if isFKConstraintError(err) {
 			return fmt.Errorf("%w: %s", ErrRoleInUse, name)
@@ -157,11 +172,11 @@ func (s *Service) DeleteRole(
 	return nil
 }
 
-func (s *Service) ListRoles() (
+func (s *Service) ListRoles(ctx context.Context) (
 	[]Role,
 	error,
 ) {
-	records, err := s.store.ListRoles()
+	records, err := s.store.ListRoles(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to list roles: %v", ErrInternal, err)
 	}
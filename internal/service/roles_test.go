@@ -12,7 +12,7 @@ func TestCreateRole_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	role, err := env.Service.CreateRole("editor", "Content Editor")
+	role, err := env.Service.CreateRole(t.Context(), "editor", "Content Editor")
 	if err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
@@ -28,7 +28,7 @@ func TestCreateRole_EmptyName(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.CreateRole("", "Something")
+	_, err := env.Service.CreateRole(t.Context(), "", "Something")
 	if !errors.Is(err, service.ErrInvalidHandle) {
 		t.Fatalf("expected ErrInvalidHandle, got %v", err)
 	}
@@ -38,7 +38,7 @@ func TestCreateRole_EmptyDisplay(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.CreateRole("editor", "")
+	_, err := env.Service.CreateRole(t.Context(), "editor", "")
 	if !errors.Is(err, service.ErrInvalidHandle) {
 		t.Fatalf("expected ErrInvalidHandle, got %v", err)
 	}
@@ -48,7 +48,7 @@ func TestCreateRole_AdminProtected(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.CreateRole("admin", "Administrator")
+	_, err := env.Service.CreateRole(t.Context(), "admin", "Administrator")
 	if !errors.Is(err, service.ErrRoleProtected) {
 		t.Fatalf("expected ErrRoleProtected, got %v", err)
 	}
@@ -58,11 +58,11 @@ func TestCreateRole_Duplicate(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if _, err := env.Service.CreateRole("editor", "Editor"); err != nil {
+	if _, err := env.Service.CreateRole(t.Context(), "editor", "Editor"); err != nil {
 		t.Fatalf("first CreateRole failed: %v", err)
 	}
 
-	_, err := env.Service.CreateRole("editor", "Another Editor")
+	_, err := env.Service.CreateRole(t.Context(), "editor", "Another Editor")
 	if !errors.Is(err, service.ErrRoleExists) {
 		t.Fatalf("expected ErrRoleExists, got %v", err)
 	}
@@ -72,12 +72,12 @@ func TestGetRole_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	created, err := env.Service.CreateRole("editor", "Editor")
+	created, err := env.Service.CreateRole(t.Context(), "editor", "Editor")
 	if err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
 
-	role, err := env.Service.GetRole("editor")
+	role, err := env.Service.GetRole(t.Context(), "editor")
 	if err != nil {
 		t.Fatalf("GetRole failed: %v", err)
 	}
@@ -93,7 +93,7 @@ func TestGetRole_NotFound(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.GetRole("nonexistent")
+	_, err := env.Service.GetRole(t.Context(), "nonexistent")
 	if !errors.Is(err, service.ErrRoleNotFound) {
 		t.Fatalf("expected ErrRoleNotFound, got %v", err)
 	}
@@ -103,7 +103,7 @@ func TestGetRole_EmptyName(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.GetRole("")
+	_, err := env.Service.GetRole(t.Context(), "")
 	if !errors.Is(err, service.ErrInvalidHandle) {
 		t.Fatalf("expected ErrInvalidHandle, got %v", err)
 	}
@@ -113,12 +113,12 @@ func TestUpdateRole_Display(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if _, err := env.Service.CreateRole("editor", "Editor"); err != nil {
+	if _, err := env.Service.CreateRole(t.Context(), "editor", "Editor"); err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
 
 	display := "Senior Editor"
-	role, err := env.Service.UpdateRole("editor", &display)
+	role, err := env.Service.UpdateRole(t.Context(), "editor", &display)
 	if err != nil {
 		t.Fatalf("UpdateRole failed: %v", err)
 	}
@@ -131,12 +131,12 @@ func TestUpdateRole_EmptyDisplay(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if _, err := env.Service.CreateRole("editor", "Editor"); err != nil {
+	if _, err := env.Service.CreateRole(t.Context(), "editor", "Editor"); err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
 
 	empty := ""
-	_, err := env.Service.UpdateRole("editor", &empty)
+	_, err := env.Service.UpdateRole(t.Context(), "editor", &empty)
 	if !errors.Is(err, service.ErrInvalidHandle) {
 		t.Fatalf("expected ErrInvalidHandle, got %v", err)
 	}
@@ -147,7 +147,7 @@ func TestUpdateRole_AdminProtected(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	display := "Super Admin"
-	_, err := env.Service.UpdateRole("admin", &display)
+	_, err := env.Service.UpdateRole(t.Context(), "admin", &display)
 	if !errors.Is(err, service.ErrRoleProtected) {
 		t.Fatalf("expected ErrRoleProtected, got %v", err)
 	}
@@ -158,7 +158,7 @@ func TestUpdateRole_NotFound(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	display := "Something"
-	_, err := env.Service.UpdateRole("nonexistent", &display)
+	_, err := env.Service.UpdateRole(t.Context(), "nonexistent", &display)
 	if !errors.Is(err, service.ErrRoleNotFound) {
 		t.Fatalf("expected ErrRoleNotFound, got %v", err)
 	}
@@ -168,16 +168,16 @@ func TestDeleteRole_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if _, err := env.Service.CreateRole("temp", "Temporary"); err != nil {
+	if _, err := env.Service.CreateRole(t.Context(), "temp", "Temporary"); err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
 
-	err := env.Service.DeleteRole("temp")
+	err := env.Service.DeleteRole(t.Context(), "temp")
 	if err != nil {
 		t.Fatalf("DeleteRole failed: %v", err)
 	}
 
-	_, err = env.Service.GetRole("temp")
+	_, err = env.Service.GetRole(t.Context(), "temp")
 	if !errors.Is(err, service.ErrRoleNotFound) {
 		t.Fatalf("expected ErrRoleNotFound, got %v", err)
 	}
@@ -187,7 +187,7 @@ func TestDeleteRole_AdminProtected(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.DeleteRole("admin")
+	err := env.Service.DeleteRole(t.Context(), "admin")
 	if !errors.Is(err, service.ErrRoleProtected) {
 		t.Fatalf("expected ErrRoleProtected, got %v", err)
 	}
@@ -197,7 +197,7 @@ func TestDeleteRole_NotFound(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.DeleteRole("nonexistent")
+	err := env.Service.DeleteRole(t.Context(), "nonexistent")
 	if !errors.Is(err, service.ErrRoleNotFound) {
 		t.Fatalf("expected ErrRoleNotFound, got %v", err)
 	}
@@ -209,22 +209,22 @@ func TestDeleteRole_InUse(t *testing.T) {
 
 	env.CreateTestRole(t, "editor", "Editor")
 
-	user, err := env.Service.CreateUser("alice", "securepassword", []string{"editor"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"editor"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	err = env.Service.DeleteRole("editor")
+	err = env.Service.DeleteRole(t.Context(), "editor")
 	if err != nil {
 		t.Fatalf("DeleteRole should succeed via cascade, got: %v", err)
 	}
 
-	_, err = env.Service.GetRole("editor")
+	_, err = env.Service.GetRole(t.Context(), "editor")
 	if !errors.Is(err, service.ErrRoleNotFound) {
 		t.Fatalf("expected ErrRoleNotFound after delete, got %v", err)
 	}
 
-	updated, err := env.Service.GetUser(user.Subject)
+	updated, err := env.Service.GetUser(t.Context(), user.Subject)
 	if err != nil {
 		t.Fatalf("user should still exist after role cascade delete, got: %v", err)
 	}
@@ -237,7 +237,7 @@ func TestDeleteRole_EmptyName(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	err := env.Service.DeleteRole("")
+	err := env.Service.DeleteRole(t.Context(), "")
 	if !errors.Is(err, service.ErrInvalidHandle) {
 		t.Fatalf("expected ErrInvalidHandle, got %v", err)
 	}
@@ -247,14 +247,14 @@ func TestListRoles(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if _, err := env.Service.CreateRole("editor", "Editor"); err != nil {
+	if _, err := env.Service.CreateRole(t.Context(), "editor", "Editor"); err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
-	if _, err := env.Service.CreateRole("viewer", "Viewer"); err != nil {
+	if _, err := env.Service.CreateRole(t.Context(), "viewer", "Viewer"); err != nil {
 		t.Fatalf("CreateRole failed: %v", err)
 	}
 
-	roles, err := env.Service.ListRoles()
+	roles, err := env.Service.ListRoles(t.Context())
 	if err != nil {
 		t.Fatalf("ListRoles failed: %v", err)
 	}
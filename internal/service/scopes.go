@@ -1,18 +1,41 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"slices"
 	"strings"
 	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
 const (
 	ScopeIdentity = "identity"
 	ScopeProfile  = "profile"
+	ScopeEmail    = "email"
 )
 
+// Claims an integration's policy can release. The profile claims and groups
+// also need the profile scope, and email the email scope.
+const (
+	ClaimPreferredUsername = "preferred_username"
+	ClaimName              = "name"
+	ClaimPicture           = "picture"
+	ClaimEmail             = "email"
+	ClaimGroups            = "groups"
+)
+
+// claimRegistry lists the claims a policy can release.
+var claimRegistry = []string{
+	ClaimEmail,
+	ClaimGroups,
+	ClaimName,
+	ClaimPicture,
+	ClaimPreferredUsername,
+}
+
 // ScopeDefinition describes a registered scope that an integration can request.
 type ScopeDefinition struct {
 	Name        string
@@ -22,10 +45,14 @@ type ScopeDefinition struct {
 }
 
 // AuthorizationRequest is a validated authorization request for an integration.
+// ReturnTo is a path on the redirect's origin that the callback sends the user
+// on to, or empty.
 type AuthorizationRequest struct {
 	Integration Integration
 	Scopes      []string
 	State       string
+	Redirect    string
+	ReturnTo    string
 }
 
 // AuthorizationReview summarizes a request against the subject's existing grants.
@@ -42,17 +69,49 @@ func (r AuthorizationReview) NeedsApproval() bool {
 }
 
 // ReviewAuthorizationRequest validates a request and returns a review of requested,
-// granted, and missing scopes for the subject.
+// granted, and missing scopes for the subject. The redirect must exactly match
+// one registered for the integration, and may only be omitted when the
+// integration has a single redirect. A returnTo that isn't a local path is
+// dropped.
 func (s *Service) ReviewAuthorizationRequest(
+	ctx context.Context,
 	subject string,
 	integrationName string,
 	requestedScopes []string,
 	state string,
+	redirect string,
+	returnTo string,
 ) (
 	*AuthorizationReview,
 	error,
 ) {
-	integration, err := s.GetIntegration(integrationName)
+	review, err := s.reviewScopes(ctx, subject, integrationName, requestedScopes, state)
+	if err != nil {
+		return nil, err
+	}
+
+	review.Request.Redirect, err = review.Request.Integration.ResolveRedirect(redirect)
+	if err != nil {
+		return nil, err
+	}
+	review.Request.ReturnTo = localReturnTo(returnTo)
+
+	return review, nil
+}
+
+// reviewScopes reviews requested scopes against the subject's grants without
+// resolving a redirect, for flows that never redirect back to the integration.
+func (s *Service) reviewScopes(
+	ctx context.Context,
+	subject string,
+	integrationName string,
+	requestedScopes []string,
+	state string,
+) (
+	*AuthorizationReview,
+	error,
+) {
+	integration, err := s.GetIntegration(ctx, integrationName)
 	if err != nil {
 		return nil, err
 	}
@@ -65,8 +124,13 @@ func (s *Service) ReviewAuthorizationRequest(
 	if err != nil {
 		return nil, err
 	}
+	for _, scope := range scopes {
+		if !integration.Policy.AllowsScope(scope) {
+			return nil, fmt.Errorf("%w: %s is not allowed for %s", ErrInvalidScope, scope, integration.Name)
+		}
+	}
 
-	grantedScopeNames, err := s.store.ListGrantedScopeNames(subject, integration.Name)
+	grantedScopeNames, err := s.store.ListGrantedScopeNames(ctx, subject, integration.Name)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to list granted scopes: %v", ErrInternal, err)
 	}
@@ -85,16 +149,20 @@ func (s *Service) ReviewAuthorizationRequest(
 	}, nil
 }
 
-// ApproveAuthorization stores any missing grants and returns an authorization code redirect.
+// ApproveAuthorization stores any missing grants and returns an authorization
+// code redirect. authTime is when the user last entered their credentials, as
+// carried by their consent session; the integration's tokens inherit it.
 func (s *Service) ApproveAuthorization(
+	ctx context.Context,
 	subject string,
+	authTime time.Time,
 	review *AuthorizationReview,
 ) (
 	*url.URL,
 	error,
 ) {
 	missingScopeNames := scopeNames(review.MissingScopes)
-	if err := s.store.InsertGrants(
+	if err := s.store.InsertGrants(ctx,
 		subject,
 		review.Request.Integration.Name,
 		missingScopeNames,
@@ -102,7 +170,7 @@ func (s *Service) ApproveAuthorization(
 		return nil, fmt.Errorf("%w: failed to store grants: %v", ErrInternal, err)
 	}
 
-	return s.issueAuthorizationCodeRedirect(subject, review.Request)
+	return s.issueAuthorizationCodeRedirect(ctx, subject, authTime, review.Request)
 }
 
 // DenyAuthorization returns an access_denied redirect for the reviewed request.
@@ -112,7 +180,7 @@ func (s *Service) DenyAuthorization(
 	*url.URL,
 	error,
 ) {
-	redirectURL, err := parseAndValidateRedirectURL(review.Request.Integration.Redirect)
+	redirectURL, err := parseAndValidateRedirectURL(review.Request.Redirect)
 	if err != nil {
 		return nil, fmt.Errorf("%w: invalid redirect URL: %v", ErrInternal, ErrInvalidRedirect)
 	}
@@ -129,39 +197,40 @@ var scopeRegistry = map[string]ScopeDefinition{
 	ScopeProfile: {
 		Name:        ScopeProfile,
 		Label:       "Profile",
-		Description: "Read your profile handle from Consent's user data API.",
+		Description: "Read your handle, display name, and avatar from Consent's user data API.",
+		Requires:    []string{ScopeIdentity},
+	},
+	ScopeEmail: {
+		Name:        ScopeEmail,
+		Label:       "Email",
+		Description: "Read the email address on your Consent profile.",
 		Requires:    []string{ScopeIdentity},
 	},
 }
 
 // issueAuthorizationCodeRedirect issues a short-lived auth code and builds the callback redirect.
 func (s *Service) issueAuthorizationCodeRedirect(
+	ctx context.Context,
 	subject string,
+	authTime time.Time,
 	req AuthorizationRequest,
 ) (
 	*url.URL,
 	error,
 ) {
-	refreshToken, err := s.tokenIssuer.IssueRefreshToken(
-		subject,
-		[]string{req.Integration.Audience, s.consentAPIAudience},
-		req.Scopes,
-		10*time.Second,
-	)
+	code, err := s.issueAuthorizationCode(ctx, subject, &req.Integration, req.Scopes, tokens.IssueOptions{
+		AuthTime: authTime,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to issue refresh token: %v", ErrInternal, err)
-	}
-
-	if err := s.store.InsertRefreshToken(refreshToken); err != nil {
-		return nil, fmt.Errorf("%w: failed to store auth code: %v", ErrInternal, err)
+		return nil, err
 	}
 
-	redirectURL, err := parseAndValidateRedirectURL(req.Integration.Redirect)
+	redirectURL, err := parseAndValidateRedirectURL(req.Redirect)
 	if err != nil {
 		return nil, fmt.Errorf("%w: invalid redirect URL: %v", ErrInternal, ErrInvalidRedirect)
 	}
 
-	return buildAuthCodeRedirectURL(redirectURL, refreshToken.Encoded(), req.State, ""), nil
+	return buildAuthCodeRedirectURL(redirectURL, code, req.State, req.ReturnTo), nil
 }
 
 func scopeDefinitions(
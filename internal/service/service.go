@@ -3,13 +3,23 @@
 package service
 
 import (
+	"cmp"
+	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/keys"
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
@@ -86,12 +96,90 @@ func (m PasswordMode) Cost() int {
 	}
 }
 
+// resolvePasswordCost returns the bcrypt cost for new hashes: mode's, unless
+// production sets cost explicitly.
+func resolvePasswordCost(
+	mode PasswordMode,
+	cost int,
+) (
+	int,
+	error,
+) {
+	if cost == 0 || mode == PasswordModeTesting {
+		return mode.Cost(), nil
+	}
+	if cost < bcrypt.DefaultCost || cost > bcrypt.MaxCost {
+		return 0, fmt.Errorf("password cost %d out of range %d-%d", cost, bcrypt.DefaultCost, bcrypt.MaxCost)
+	}
+	return cost, nil
+}
+
 // Options configures Service initialization.
 type Options struct {
 	Store                   Store
 	TokenServerOpts         tokens.ServerOptions
 	ResourceTokenClientOpts tokens.ClientOptions
 	PasswordMode            PasswordMode
+	PublicURL               string
+	Upstreams               []UpstreamProvider
+	HTTPClient              *http.Client
+
+	// TokenStore, when set, holds authorization codes, refresh tokens, and
+	// device authorizations in place of Store, such as a store shared by
+	// replicas. See TokenStore for what it must guarantee.
+	TokenStore TokenStore
+
+	// AuthCodeLifetime is the deployment-wide authorization code lifetime.
+	// Zero uses AuthorizationCodeLifetime; integrations may override it.
+	AuthCodeLifetime time.Duration
+
+	// AccessTokenLifetime and RefreshTokenLifetime are the deployment-wide
+	// token lifetimes. Zero uses the package defaults; integrations may
+	// override them.
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+
+	// RefreshPolicy judges each refresh of a stored refresh token. Nil uses
+	// a NetworkRefreshPolicy that flags with DefaultMinRefreshInterval.
+	RefreshPolicy RefreshPolicy
+
+	// OnRefreshAnomaly receives the refreshes RefreshPolicy flags or
+	// rejects. Nil logs them.
+	OnRefreshAnomaly func(context.Context, RefreshAnomaly)
+
+	// OnImpersonation receives every impersonation token issued. Nil logs
+	// them.
+	OnImpersonation func(context.Context, Impersonation)
+
+	// Maintenance starts the service in maintenance mode; see
+	// SetMaintenance.
+	Maintenance bool
+
+	// OnEvent receives identity events such as registrations and failed
+	// sign-ins, for notifying downstream systems. It is called synchronously
+	// and must not block. Nil discards them.
+	OnEvent func(context.Context, Event)
+
+	// LoginThrottle slows repeated wrong passwords; see
+	// LoginThrottleOptions. The zero value throttles with the defaults.
+	LoginThrottle LoginThrottleOptions
+
+	// Mailer sends users notices, such as of password sign-ins from devices
+	// they haven't used before. Nil sends none.
+	Mailer Mailer
+
+	// PasswordCost is the bcrypt cost of new password hashes under
+	// PasswordModeProduction, from bcrypt.DefaultCost to bcrypt.MaxCost.
+	// Zero uses bcrypt.DefaultCost. Existing hashes keep their cost.
+	PasswordCost int
+
+	// PasswordWorkers bounds how many passwords are hashed or checked at
+	// once; the rest wait. Zero uses GOMAXPROCS.
+	PasswordWorkers int
+
+	// Terms are the terms of service users must accept before integrations
+	// are issued tokens for them. The zero value asks for none.
+	Terms Terms
 }
 
 // InitOptions configures bootstrap initialization for service state.
@@ -105,12 +193,31 @@ type InitOptions struct {
 // Service coordinates authentication, registration, and token operations.
 // It depends on a Store interface and delegates to it for persistence.
 type Service struct {
-	store                  Store
-	passwordMode           PasswordMode
-	tokenIssuer            tokens.Issuer
-	tokenValidator         tokens.Validator
-	resourceTokenValidator tokens.Validator
-	consentAPIAudience     string
+	store                   Store
+	tokenStore              TokenStore
+	passwords               *passwordHasher
+	tokenIssuer             tokens.Issuer
+	tokenValidator          tokens.Validator
+	resourceTokenValidator  tokens.Validator
+	verificationKey         *ecdsa.PublicKey
+	issuerDomain            string
+	consentAPIAudience      string
+	publicURL               string
+	upstreams               map[string]UpstreamProvider
+	upstreamOrder           []string
+	httpClient              *http.Client
+	defaultAuthCodeLifetime time.Duration
+	defaultAccessLifetime   time.Duration
+	defaultRefreshLifetime  time.Duration
+	refreshPolicy           RefreshPolicy
+	onRefreshAnomaly        func(context.Context, RefreshAnomaly)
+	onImpersonation         func(context.Context, Impersonation)
+	onEvent                 func(context.Context, Event)
+	loginThrottle           *loginThrottle
+	mailer                  Mailer
+	terms                   Terms
+	logins                  *loginCounter
+	maintenance             atomic.Bool
 }
 
 func New(
@@ -122,28 +229,118 @@ func New(
 	if options.Store == nil {
 		return nil, errors.New("service: store required")
 	}
+	if err := validateAuthCodeLifetime(options.AuthCodeLifetime); err != nil {
+		return nil, fmt.Errorf("service: %w", err)
+	}
+	accessLifetime := cmp.Or(options.AccessTokenLifetime, AccessTokenLifetime)
+	refreshLifetime := cmp.Or(options.RefreshTokenLifetime, RefreshTokenLifetime)
+	if err := validateTokenLifetimes(accessLifetime, refreshLifetime); err != nil {
+		return nil, fmt.Errorf("service: %w", err)
+	}
 
+	passwordCost, err := resolvePasswordCost(options.PasswordMode, options.PasswordCost)
+	if err != nil {
+		return nil, fmt.Errorf("service: %w", err)
+	}
+	if options.PasswordWorkers < 0 {
+		return nil, errors.New("service: password workers cannot be negative")
+	}
+	if err := options.Terms.validate(); err != nil {
+		return nil, fmt.Errorf("service: %w", err)
+	}
+
+	verificationKey, err := options.TokenServerOpts.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("service: %w", err)
+	}
 	issuer, validator := tokens.InitServer(options.TokenServerOpts)
 	resourceValidator := tokens.InitClient(options.ResourceTokenClientOpts)
 
-	return &Service{
-		passwordMode:           options.PasswordMode,
-		store:                  options.Store,
-		tokenIssuer:            issuer,
-		tokenValidator:         validator,
-		resourceTokenValidator: resourceValidator,
-		consentAPIAudience:     options.ResourceTokenClientOpts.ValidAudience,
-	}, nil
+	upstreams, upstreamOrder, err := normalizeUpstreamProviders(options.Upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	authCodeLifetime := options.AuthCodeLifetime
+	if authCodeLifetime == 0 {
+		authCodeLifetime = AuthorizationCodeLifetime
+	}
+
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	refreshPolicy := options.RefreshPolicy
+	if refreshPolicy == nil {
+		refreshPolicy = NetworkRefreshPolicy{MinInterval: DefaultMinRefreshInterval}
+	}
+	onRefreshAnomaly := options.OnRefreshAnomaly
+	if onRefreshAnomaly == nil {
+		onRefreshAnomaly = logRefreshAnomaly
+	}
+	onImpersonation := options.OnImpersonation
+	if onImpersonation == nil {
+		onImpersonation = logImpersonation
+	}
+	onEvent := options.OnEvent
+	if onEvent == nil {
+		onEvent = discardEvent
+	}
+	tokenStore := options.TokenStore
+	if tokenStore == nil {
+		tokenStore = options.Store
+	}
+
+	svc := &Service{
+		passwords:               newPasswordHasher(passwordCost, cmp.Or(options.PasswordWorkers, runtime.GOMAXPROCS(0))),
+		store:                   options.Store,
+		tokenStore:              tokenStore,
+		tokenIssuer:             issuer,
+		tokenValidator:          validator,
+		resourceTokenValidator:  resourceValidator,
+		verificationKey:         verificationKey,
+		issuerDomain:            options.TokenServerOpts.IssuerDomain,
+		consentAPIAudience:      options.ResourceTokenClientOpts.ValidAudience,
+		publicURL:               strings.TrimRight(options.PublicURL, "/"),
+		upstreams:               upstreams,
+		upstreamOrder:           upstreamOrder,
+		httpClient:              httpClient,
+		defaultAuthCodeLifetime: authCodeLifetime,
+		defaultAccessLifetime:   accessLifetime,
+		defaultRefreshLifetime:  refreshLifetime,
+		refreshPolicy:           refreshPolicy,
+		onRefreshAnomaly:        onRefreshAnomaly,
+		onImpersonation:         onImpersonation,
+		onEvent:                 onEvent,
+		loginThrottle:           newLoginThrottle(options.LoginThrottle),
+		mailer:                  options.Mailer,
+		terms:                   options.Terms,
+		logins:                  newLoginCounter(),
+	}
+	svc.maintenance.Store(options.Maintenance)
+	return svc, nil
+}
+
+// VerificationKey returns the public half of the token signing key, or nil if
+// the service was built without one.
+func (s *Service) VerificationKey() *ecdsa.PublicKey {
+	return s.verificationKey
+}
+
+// IssuerDomain returns the "iss" claim of tokens the service issues.
+func (s *Service) IssuerDomain() string {
+	return s.issuerDomain
 }
 
 func Init(
+	ctx context.Context,
 	options InitOptions,
 ) error {
-	if err := SeedSystemIntegrations(options.Store, options.PublicURL); err != nil {
+	if err := SeedSystemIntegrations(ctx, options.Store, options.PublicURL); err != nil {
 		return err
 	}
 
-	if err := SeedSystemRoles(options.Store); err != nil {
+	if err := SeedSystemRoles(ctx, options.Store); err != nil {
 		return err
 	}
 
@@ -184,15 +381,37 @@ func generateSubject() (
 	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
 }
 
+// generateSecret returns a random opaque credential, such as a client secret
+// or authorization code.
+func generateSecret() (
+	string,
+	error,
+) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// hashSecret hashes an opaque credential for storage. Credentials are random
+// and high-entropy, so a fast unsalted hash is enough.
+func hashSecret(
+	secret string,
+) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
 func buildAuthCodeRedirectURL(
 	redirect *url.URL,
-	refreshToken string,
+	code string,
 	state string,
 	returnTo string,
 ) *url.URL {
 	redirectURL := *redirect
 	q := redirectURL.Query()
-	q.Set("auth_code", refreshToken)
+	q.Set("auth_code", code)
 	if state != "" {
 		q.Set("state", state)
 	}
@@ -203,6 +422,23 @@ func buildAuthCodeRedirectURL(
 	return &redirectURL
 }
 
+// localReturnTo returns returnTo if it is a path on the current origin, and
+// empty otherwise, so a return_to can't send the user to another site.
+func localReturnTo(
+	returnTo string,
+) string {
+	parsed, err := url.Parse(returnTo)
+	if err != nil ||
+		parsed.IsAbs() ||
+		parsed.Host != "" ||
+		!strings.HasPrefix(parsed.Path, "/") ||
+		strings.HasPrefix(returnTo, "//") ||
+		strings.Contains(returnTo, "\\") {
+		return ""
+	}
+	return parsed.String()
+}
+
 func buildAuthorizationErrorRedirectURL(
 	redirect *url.URL,
 	errorCode string,
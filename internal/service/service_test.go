@@ -21,7 +21,7 @@ func TestService_DefaultIntegration(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	integrations, err := env.Service.ListIntegrations()
+	integrations, err := env.Service.ListIntegrations(t.Context())
 	if err != nil {
 		t.Fatalf("ListIntegrations failed: %v", err)
 	}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// maxUserAgentLength bounds the User-Agent stored with a refresh token; the
+// header is client-controlled and otherwise unbounded.
+const maxUserAgentLength = 256
+
+// ClientInfo describes where a request came from. It is recorded on the
+// refresh tokens the request is issued.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo attaches info to ctx for the service calls made with it.
+func WithClientInfo(
+	ctx context.Context,
+	info ClientInfo,
+) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFromRequest describes the client that sent r by its address and
+// User-Agent.
+func ClientInfoFromRequest(
+	r *http.Request,
+) ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return ClientInfo{
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// clientInfoFrom returns the ClientInfo attached to ctx, or the zero value.
+func clientInfoFrom(
+	ctx context.Context,
+) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	if len(info.UserAgent) > maxUserAgentLength {
+		info.UserAgent = info.UserAgent[:maxUserAgentLength]
+	}
+	return info
+}
+
+// Session is a signed-in client: a stored refresh token and what is known
+// about where it was issued and when it was last used. Rotating the token
+// keeps its session, so CreatedAt, IP, and UserAgent describe the original
+// sign-in. LastUsedAt and LastIP are zero until the token is first refreshed.
+type Session struct {
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	LastIP     string
+	ExpiresAt  time.Time
+}
+
+// ListSessions returns the user's sessions, most recently created first.
+func (s *Service) ListSessions(
+	ctx context.Context,
+	subject string,
+) (
+	[]Session,
+	error,
+) {
+	if subject == "" {
+		return nil, ErrInvalidUser
+	}
+
+	sessions, err := s.tokenStore.ListRefreshTokens(ctx, subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list sessions: %v", ErrInternal, err)
+	}
+	return sessions, nil
+}
+
+// ListAccountSessions returns the sessions of the account that owns the
+// access token.
+func (s *Service) ListAccountSessions(
+	ctx context.Context,
+	encodedAccessToken string,
+) (
+	[]Session,
+	error,
+) {
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encodedAccessToken, s.resourceTokenValidator); err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode access token: %w", ErrTokenInvalid, err)
+	}
+
+	return s.ListSessions(ctx, accessToken.Subject())
+}
+
+// RevokeAudienceTokens revokes every refresh token issued to audience, as
+// when the app behind it is compromised or retired, returning how many were
+// revoked. Access tokens already issued stay valid until they expire. Like
+// signing out, it is allowed in maintenance mode.
+func (s *Service) RevokeAudienceTokens(
+	ctx context.Context,
+	audience string,
+) (
+	int,
+	error,
+) {
+	if audience == "" {
+		return 0, fmt.Errorf("%w: audience required", ErrInvalidAudience)
+	}
+
+	revoked, err := s.tokenStore.DeleteRefreshTokensByAudience(ctx, audience)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to revoke tokens: %v", ErrInternal, err)
+	}
+	return revoked, nil
+}
+
+// PruneExpiredTokens deletes refresh tokens, authorization codes, and device
+// authorizations that have expired, returning how many it deleted. It does
+// nothing for a token store that isn't a TokenPruner. Like signing out, it
+// is allowed in maintenance mode.
+func (s *Service) PruneExpiredTokens(
+	ctx context.Context,
+) (
+	int,
+	error,
+) {
+	pruner, ok := s.tokenStore.(TokenPruner)
+	if !ok {
+		return 0, nil
+	}
+	deleted, err := pruner.DeleteExpiredTokens(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to prune expired tokens: %v", ErrInternal, err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginStats counts sign-ins to one integration since the service started.
+// Sign-ins are credited to the app that sent the user to consent, taken from
+// the authorize page they return to, and otherwise to consent itself.
+// Failed counts rejected credentials only, not throttled attempts or errors
+// of the service.
+type LoginStats struct {
+	Integration string
+	Succeeded   int64
+	Failed      int64
+}
+
+// loginCounter tallies sign-ins in memory by integration.
+type loginCounter struct {
+	mu     sync.Mutex
+	since  time.Time
+	counts map[string]*LoginStats
+}
+
+func newLoginCounter() *loginCounter {
+	return &loginCounter{
+		since:  time.Now().UTC(),
+		counts: make(map[string]*LoginStats),
+	}
+}
+
+func (c *loginCounter) add(
+	integration string,
+	succeeded bool,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, ok := c.counts[integration]
+	if !ok {
+		stats = &LoginStats{Integration: integration}
+		c.counts[integration] = stats
+	}
+	if succeeded {
+		stats.Succeeded++
+	} else {
+		stats.Failed++
+	}
+}
+
+// LoginStats returns sign-in counts by integration, sorted by name, and when
+// counting began.
+func (s *Service) LoginStats() (
+	[]LoginStats,
+	time.Time,
+) {
+	s.logins.mu.Lock()
+	defer s.logins.mu.Unlock()
+
+	stats := make([]LoginStats, 0, len(s.logins.counts))
+	for _, entry := range s.logins.counts {
+		stats = append(stats, *entry)
+	}
+	slices.SortFunc(stats, func(a, b LoginStats) int {
+		return strings.Compare(a.Integration, b.Integration)
+	})
+	return stats, s.logins.since
+}
+
+// countLogin credits a sign-in to the integration the user came from. Names
+// that don't belong to a registered integration count as consent's own, so
+// callers can't grow the tally with made-up names.
+func (s *Service) countLogin(
+	ctx context.Context,
+	integrationName string,
+	returnTo string,
+	succeeded bool,
+) {
+	name := integrationName
+	if name == InternalIntegrationName {
+		name = authorizeIntegration(returnTo)
+	}
+	if name != InternalIntegrationName {
+		if _, err := s.store.GetIntegration(ctx, name); err != nil {
+			name = InternalIntegrationName
+		}
+	}
+	s.logins.add(name, succeeded)
+}
+
+// authorizeIntegration returns the integration named by returnTo when it is
+// the authorize page, and consent's own name otherwise.
+func authorizeIntegration(
+	returnTo string,
+) string {
+	parsed, err := url.Parse(returnTo)
+	if err != nil || !strings.HasSuffix(parsed.Path, "/authorize") {
+		return InternalIntegrationName
+	}
+	if name := parsed.Query().Get("integration"); name != "" {
+		return name
+	}
+	return InternalIntegrationName
+}
@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"reflect"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+func TestLoginStats_ByIntegration(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password")
+	err := env.Service.CreateIntegrationWithPolicy(
+		t.Context(),
+		"svc-a", "Service A", "aud-a", "https://svc-a.test/callback", nil,
+		service.IntegrationPolicy{},
+	)
+	if err != nil {
+		t.Fatalf("CreateIntegrationWithPolicy failed: %v", err)
+	}
+
+	login := func(password string, returnTo string) {
+		t.Helper()
+		_, _ = env.Service.GrantAuthCodeWithOptions(t.Context(), "alice", password, service.InternalIntegrationName, service.LoginOptions{ReturnTo: returnTo})
+	}
+
+	// sign-ins are credited to the app whose authorize page they return to
+	login("password", "/authorize?integration=svc-a&scope=identity")
+	login("wrong", "/authorize?integration=svc-a&scope=identity")
+	login("wrong", "/authorize?integration=svc-a&scope=identity")
+
+	// and to consent when there is none, or it isn't registered
+	login("password", "")
+	login("wrong", "/authorize?integration=made-up")
+
+	stats, since := env.Service.LoginStats()
+	if since.IsZero() {
+		t.Error("expected a start time")
+	}
+	want := []service.LoginStats{
+		{Integration: service.InternalIntegrationName, Succeeded: 1, Failed: 1},
+		{Integration: "svc-a", Succeeded: 1, Failed: 2},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("LoginStats = %+v, want %+v", stats, want)
+	}
+}
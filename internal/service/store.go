@@ -1,36 +1,101 @@
 package service
 
 import (
+	"context"
+	"time"
+
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
-// Store handles persistence of user data, refresh tokens, and integrations.
+// Store handles persistence of user data, integrations, and grants, along
+// with the sign-in state of TokenStore.
 type Store interface {
-	InsertUser(subject, handle string, secret []byte, roles []string) error
-	GetUserByHandle(handle string) (*User, error)
-	GetUserBySubject(subject string) (*User, error)
-	ListUsers() ([]User, error)
-	UpdateUser(subject, handle string, roles []string) error
-	DeleteUser(subject string) (deleted bool, err error)
-	GetSecret(handle string) ([]byte, error)
-
-	InsertRole(name, display string) error
-	GetRole(name string) (Role, error)
-	UpdateRole(name string, updates *RoleUpdate) error
-	DeleteRole(name string) (deleted bool, err error)
-	ListRoles() ([]Role, error)
-
-	InsertRefreshToken(token *tokens.RefreshToken) error
-	DeleteRefreshToken(jwt string) (deleted bool, err error)
-	GetRefreshTokenOwner(jwt string) (subject string, err error)
-
-	ListGrantedScopeNames(subject, integration string) ([]string, error)
-	InsertGrants(subject, integration string, scopes []string) error
-
-	InsertIntegration(name, display, audience, redirect string) error
-	UpsertSystemIntegrations(integrations []Integration) error
-	GetIntegration(name string) (Integration, error)
-	UpdateIntegration(name string, updates *IntegrationUpdate) error
-	DeleteIntegration(name string) (deleted bool, err error)
-	ListIntegrations() ([]Integration, error)
+	TokenStore
+
+	InsertUser(ctx context.Context, subject, handle string, secret []byte, roles []string) error
+	GetUserByHandle(ctx context.Context, handle string) (*User, error)
+	GetUserBySubject(ctx context.Context, subject string) (*User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	UpdateUser(ctx context.Context, user User) error
+	UpdateUserHandle(ctx context.Context, subject, handle string) error
+	DeleteUser(ctx context.Context, subject string) (deleted bool, err error)
+	ListExpiredUsers(ctx context.Context, since, until time.Time) (subjects []string, err error)
+	AcceptTerms(ctx context.Context, subject, version string, acceptedAt time.Time) error
+	GetSecret(ctx context.Context, handle string) ([]byte, error)
+
+	GetProfile(ctx context.Context, subject string) (*Profile, error)
+	UpsertProfile(ctx context.Context, subject string, profile *Profile) error
+
+	GetUserByExternalIdentity(ctx context.Context, provider, externalSubject string) (*User, error)
+	InsertExternalIdentity(ctx context.Context, subject, provider, externalSubject string, createdAt time.Time) error
+	ListExternalIdentities(ctx context.Context, subject string) ([]ExternalIdentity, error)
+	DeleteExternalIdentities(ctx context.Context, subject, provider string) (deleted bool, err error)
+
+	InsertRole(ctx context.Context, name, display string) error
+	GetRole(ctx context.Context, name string) (Role, error)
+	UpdateRole(ctx context.Context, name string, updates *RoleUpdate) error
+	DeleteRole(ctx context.Context, name string) (deleted bool, err error)
+	ListRoles(ctx context.Context) ([]Role, error)
+
+	ListGrantedScopeNames(ctx context.Context, subject, integration string) ([]string, error)
+	ListGrants(ctx context.Context, subject string) ([]Grant, error)
+	InsertGrants(ctx context.Context, subject, integration string, scopes []string) error
+
+	InsertIntegration(ctx context.Context, integration Integration) error
+	UpsertSystemIntegrations(ctx context.Context, integrations []Integration) error
+	GetIntegration(ctx context.Context, name string) (Integration, error)
+	UpdateIntegration(ctx context.Context, name string, updates *IntegrationUpdate) error
+	DeleteIntegration(ctx context.Context, name string) (deleted bool, err error)
+	ListIntegrations(ctx context.Context) ([]Integration, error)
+	SetIntegrationSecret(ctx context.Context, name, secretHash string) error
+	GetIntegrationSecret(ctx context.Context, name string) (secretHash string, err error)
+
+	RecordLoginDevice(ctx context.Context, subject string, client ClientInfo, at time.Time) (isNew bool, err error)
+}
+
+// TokenStore holds the state written while users sign in: authorization
+// codes, refresh tokens, and device authorizations. A single server keeps it
+// in its Store. Replicas behind a load balancer must share one TokenStore,
+// set with Options.TokenStore, and it must be:
+//
+//   - strongly consistent: a write returns only once every replica can read
+//     it, since a browser's next request may reach any replica
+//   - atomic where marked: ConsumeAuthorizationCode hands each code to one
+//     caller only, and RotateRefreshToken lets one caller replace a token,
+//     so a stolen code or token can't be redeemed twice in a race
+//   - durable for refresh tokens: losing one signs its user out, while
+//     codes and device authorizations may be lost, such as by cache
+//     eviction, once they expire
+//
+// Entries are keyed by code hash, token, or user code, and owned by a user
+// subject; DeleteUserTokens removes everything a user owns.
+type TokenStore interface {
+	InsertRefreshToken(ctx context.Context, token *tokens.RefreshToken, client ClientInfo) error
+	DeleteRefreshToken(ctx context.Context, jwt string) (deleted bool, err error)
+	RotateRefreshToken(ctx context.Context, jwt string, next *tokens.RefreshToken, client ClientInfo) (rotated bool, err error)
+	TouchRefreshToken(ctx context.Context, jwt string, usedAt time.Time, client ClientInfo) (found bool, err error)
+	GetRefreshSession(ctx context.Context, jwt string) (session Session, found bool, err error)
+	GetRefreshTokenOwner(ctx context.Context, jwt string) (subject string, err error)
+	ListRefreshTokens(ctx context.Context, subject string) ([]Session, error)
+	DeleteRefreshTokensByAudience(ctx context.Context, audience string) (deleted int, err error)
+	DeleteRefreshTokensSince(ctx context.Context, subject string, since time.Time) (deleted int, err error)
+
+	InsertAuthorizationCode(ctx context.Context, code *AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error)
+
+	InsertDeviceAuthorization(ctx context.Context, authorization *DeviceAuthorization) error
+	GetDeviceAuthorization(ctx context.Context, deviceCodeHash string) (*DeviceAuthorization, error)
+	GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	DecideDeviceAuthorization(ctx context.Context, userCode, subject string, status DeviceAuthorizationStatus, authTime time.Time) error
+	UpdateDeviceAuthorizationPoll(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error
+	DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) (deleted bool, err error)
+
+	DeleteUserTokens(ctx context.Context, subject string) error
+}
+
+// TokenPruner is a TokenStore that can delete expired entries in bulk. The
+// Store implements it; a shared TokenStore that expires entries by itself,
+// such as a cache with TTLs, needn't.
+type TokenPruner interface {
+	DeleteExpiredTokens(ctx context.Context, now time.Time) (deleted int, err error)
 }
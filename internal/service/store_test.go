@@ -0,0 +1,290 @@
+package service_test
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// memoryTokenStore is a TokenStore kept apart from the database, standing in
+// for one shared by replicas.
+type memoryTokenStore struct {
+	mu      sync.Mutex
+	refresh map[string]memoryRefresh
+	codes   map[string]service.AuthorizationCode
+	devices map[string]service.DeviceAuthorization
+}
+
+type memoryRefresh struct {
+	subject  string
+	audience []string
+	session  service.Session
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{
+		refresh: make(map[string]memoryRefresh),
+		codes:   make(map[string]service.AuthorizationCode),
+		devices: make(map[string]service.DeviceAuthorization),
+	}
+}
+
+func (m *memoryTokenStore) InsertRefreshToken(_ context.Context, token *tokens.RefreshToken, client service.ClientInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refresh[token.Encoded()] = memoryRefresh{
+		subject:  token.Subject(),
+		audience: token.Audience(),
+		session: service.Session{
+			IP:        client.IP,
+			UserAgent: client.UserAgent,
+			CreatedAt: token.IssuedAt(),
+			ExpiresAt: token.Expiration(),
+		},
+	}
+	return nil
+}
+
+func (m *memoryTokenStore) DeleteRefreshToken(_ context.Context, jwt string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.refresh[jwt]
+	delete(m.refresh, jwt)
+	return ok, nil
+}
+
+func (m *memoryTokenStore) RotateRefreshToken(_ context.Context, jwt string, next *tokens.RefreshToken, client service.ClientInfo) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.refresh[jwt]
+	if !ok {
+		return false, nil
+	}
+	delete(m.refresh, jwt)
+	stored.session.LastUsedAt = next.IssuedAt()
+	stored.session.LastIP = client.IP
+	stored.session.ExpiresAt = next.Expiration()
+	m.refresh[next.Encoded()] = stored
+	return true, nil
+}
+
+func (m *memoryTokenStore) TouchRefreshToken(_ context.Context, jwt string, usedAt time.Time, client service.ClientInfo) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.refresh[jwt]
+	if ok {
+		stored.session.LastUsedAt = usedAt
+		stored.session.LastIP = client.IP
+		m.refresh[jwt] = stored
+	}
+	return ok, nil
+}
+
+func (m *memoryTokenStore) GetRefreshSession(_ context.Context, jwt string) (service.Session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.refresh[jwt]
+	return stored.session, ok, nil
+}
+
+func (m *memoryTokenStore) GetRefreshTokenOwner(_ context.Context, jwt string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.refresh[jwt]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return stored.subject, nil
+}
+
+func (m *memoryTokenStore) ListRefreshTokens(_ context.Context, subject string) ([]service.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := []service.Session{}
+	for _, stored := range m.refresh {
+		if stored.subject == subject {
+			sessions = append(sessions, stored.session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *memoryTokenStore) DeleteRefreshTokensByAudience(_ context.Context, audience string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for jwt, stored := range m.refresh {
+		if slices.Contains(stored.audience, audience) {
+			delete(m.refresh, jwt)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *memoryTokenStore) DeleteRefreshTokensSince(_ context.Context, subject string, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for jwt, stored := range m.refresh {
+		if stored.subject == subject && !stored.session.CreatedAt.Before(since) {
+			delete(m.refresh, jwt)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *memoryTokenStore) InsertAuthorizationCode(_ context.Context, code *service.AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code.CodeHash] = *code
+	return nil
+}
+
+func (m *memoryTokenStore) ConsumeAuthorizationCode(_ context.Context, codeHash string) (*service.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[codeHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	delete(m.codes, codeHash)
+	return &code, nil
+}
+
+func (m *memoryTokenStore) InsertDeviceAuthorization(_ context.Context, authorization *service.DeviceAuthorization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices[authorization.DeviceCodeHash] = *authorization
+	return nil
+}
+
+func (m *memoryTokenStore) GetDeviceAuthorization(_ context.Context, deviceCodeHash string) (*service.DeviceAuthorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	authorization, ok := m.devices[deviceCodeHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &authorization, nil
+}
+
+func (m *memoryTokenStore) GetDeviceAuthorizationByUserCode(_ context.Context, userCode string) (*service.DeviceAuthorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, authorization := range m.devices {
+		if authorization.UserCode == userCode {
+			return &authorization, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryTokenStore) DecideDeviceAuthorization(_ context.Context, userCode, subject string, status service.DeviceAuthorizationStatus, authTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, authorization := range m.devices {
+		if authorization.UserCode == userCode && authorization.Status == service.DeviceAuthorizationPending {
+			authorization.Subject, authorization.Status, authorization.AuthTime = subject, status, authTime
+			m.devices[hash] = authorization
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memoryTokenStore) UpdateDeviceAuthorizationPoll(_ context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if authorization, ok := m.devices[deviceCodeHash]; ok {
+		authorization.LastPolledAt = polledAt
+		authorization.Interval = interval
+		m.devices[deviceCodeHash] = authorization
+	}
+	return nil
+}
+
+func (m *memoryTokenStore) DeleteDeviceAuthorization(_ context.Context, deviceCodeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.devices[deviceCodeHash]
+	delete(m.devices, deviceCodeHash)
+	return ok, nil
+}
+
+func (m *memoryTokenStore) DeleteUserTokens(_ context.Context, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for jwt, stored := range m.refresh {
+		if stored.subject == subject {
+			delete(m.refresh, jwt)
+		}
+	}
+	for hash, code := range m.codes {
+		if code.Subject == subject {
+			delete(m.codes, hash)
+		}
+	}
+	for hash, authorization := range m.devices {
+		if authorization.Subject == subject {
+			delete(m.devices, hash)
+		}
+	}
+	return nil
+}
+
+func TestTokenStore_KeepsSignInStateApart(t *testing.T) {
+	t.Parallel()
+	tokenStore := newMemoryTokenStore()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.TokenStore = tokenStore
+	})
+
+	// setup env
+	alice, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// sign in, redeem the code, and rotate the refresh token
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName)
+	if err != nil {
+		t.Fatalf("GrantAuthCode failed: %v", err)
+	}
+	_, refreshToken, err := env.Service.ExchangeAuthorizationCode(t.Context(), redirectURL.Query().Get("auth_code"), service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+	_, rotated, err := env.Service.RefreshAccessToken(t.Context(), refreshToken, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	if _, _, err := env.Service.RefreshAccessToken(t.Context(), refreshToken, service.ClientCredentials{}); err == nil {
+		t.Error("rotated-out refresh token was accepted again")
+	}
+
+	// the session lives in the token store, not the database
+	sessions, err := env.Service.ListSessions(t.Context(), alice.Subject)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("ListSessions = %d sessions, %v; want 1", len(sessions), err)
+	}
+	if stored, err := env.DB.ListRefreshTokens(t.Context(), alice.Subject); err != nil || len(stored) != 0 {
+		t.Errorf("database holds %d refresh tokens, %v; want none", len(stored), err)
+	}
+
+	// deleting the user clears their tokens from the token store
+	if err := env.Service.DeleteUser(t.Context(), alice.Subject); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if _, found, _ := tokenStore.GetRefreshSession(t.Context(), rotated); found {
+		t.Error("refresh token outlived its user")
+	}
+}
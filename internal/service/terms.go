@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Terms are the terms of service users must accept before integrations are
+// issued tokens for them. Version names the current terms, and raising it
+// asks every user to accept again; URL is where users read them. An empty
+// Version asks for nothing.
+type Terms struct {
+	Version string
+	URL     string
+}
+
+func (t Terms) validate() error {
+	if t.Version == "" {
+		if t.URL != "" {
+			return errors.New("terms URL set without a terms version")
+		}
+		return nil
+	}
+	parsed, err := url.Parse(t.URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("terms URL %q must be an absolute http(s) URL", t.URL)
+	}
+	return nil
+}
+
+// Terms returns the terms of service users must accept.
+func (s *Service) Terms() Terms {
+	return s.terms
+}
+
+// TermsPending reports whether subject has yet to accept the current terms
+// of service. Until they do, integrations can't be issued tokens for them;
+// consent's own pages still sign them in, so they can read and accept.
+func (s *Service) TermsPending(
+	ctx context.Context,
+	subject string,
+) (
+	bool,
+	error,
+) {
+	if s.terms.Version == "" {
+		return false, nil
+	}
+	user, err := s.store.GetUserBySubject(ctx, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%w: %s", ErrAccountNotFound, subject)
+		}
+		return false, fmt.Errorf("%w: failed to get user: %v", ErrInternal, err)
+	}
+	return user.TermsVersion != s.terms.Version, nil
+}
+
+// AcceptTerms records that subject accepted version of the terms of service.
+// It returns ErrTermsOutdated unless version is the current one, as when the
+// terms changed while the user was reading them.
+func (s *Service) AcceptTerms(
+	ctx context.Context,
+	subject string,
+	version string,
+) error {
+	if s.terms.Version == "" || version != s.terms.Version {
+		return fmt.Errorf("%w: version %q is not current", ErrTermsOutdated, version)
+	}
+
+	err := s.store.AcceptTerms(ctx, subject, version, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrAccountNotFound, subject)
+		}
+		return fmt.Errorf("%w: failed to record accepted terms: %v", ErrInternal, err)
+	}
+
+	s.emit(ctx, Event{Type: EventTermsAccepted, Subject: subject})
+	return nil
+}
+
+// checkTerms returns ErrTermsNotAccepted if user has yet to accept the
+// current terms of service and integration is not consent's own.
+func (s *Service) checkTerms(
+	user *User,
+	integration *Integration,
+) error {
+	if s.terms.Version == "" || integration == nil || integration.Name == InternalIntegrationName {
+		return nil
+	}
+	if user.TermsVersion != s.terms.Version {
+		return fmt.Errorf("%w: %s has not accepted version %s", ErrTermsNotAccepted, user.Handle, s.terms.Version)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func setupTermsEnv(
+	t *testing.T,
+) *testutil.TestEnv {
+	t.Helper()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.Terms = service.Terms{Version: "2026-10", URL: "https://example.com/terms"}
+	})
+	env.CreateTestIntegration(t, "app", "App", "app.test", "https://app.test/callback")
+	env.RegisterTestUser(t, "alice", "password123")
+	return env
+}
+
+func TestAcceptTerms_GatesIntegrationTokens(t *testing.T) {
+	t.Parallel()
+	env := setupTermsEnv(t)
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"app.test"})
+
+	// alice can still sign in to consent to read the terms
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed: %v", err)
+	}
+	if pending, err := env.Service.TermsPending(t.Context(), alice.Subject); err != nil || !pending {
+		t.Fatalf("TermsPending = %t, %v, want true", pending, err)
+	}
+
+	// but apps can't be issued tokens for her
+	review, err := env.Service.ReviewAuthorizationRequest(t.Context(), alice.Subject, "app", []string{"identity"}, "", "", "")
+	if err != nil {
+		t.Fatalf("ReviewAuthorizationRequest failed: %v", err)
+	}
+	_, err = env.Service.ApproveAuthorization(t.Context(), alice.Subject, time.Now(), review)
+	if !errors.Is(err, service.ErrTermsNotAccepted) {
+		t.Fatalf("ApproveAuthorization: expected ErrTermsNotAccepted, got %v", err)
+	}
+	_, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if !errors.Is(err, service.ErrTermsNotAccepted) {
+		t.Fatalf("RefreshAccessToken: expected ErrTermsNotAccepted, got %v", err)
+	}
+
+	// accepting an old version is refused
+	err = env.Service.AcceptTerms(t.Context(), alice.Subject, "2025-01")
+	if !errors.Is(err, service.ErrTermsOutdated) {
+		t.Fatalf("AcceptTerms: expected ErrTermsOutdated, got %v", err)
+	}
+
+	// accepting the current version lets apps in, and their tokens say so
+	if err := env.Service.AcceptTerms(t.Context(), alice.Subject, "2026-10"); err != nil {
+		t.Fatalf("AcceptTerms failed: %v", err)
+	}
+	if pending, err := env.Service.TermsPending(t.Context(), alice.Subject); err != nil || pending {
+		t.Fatalf("TermsPending = %t, %v, want false", pending, err)
+	}
+	code := env.IssueTestAuthorizationCode(t, "alice", "app", []string{"identity"})
+	encoded, _, err := env.Service.ExchangeAuthorizationCode(t.Context(), code, service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode failed: %v", err)
+	}
+	accessToken := new(tokens.AccessToken)
+	if err := accessToken.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode access token: %v", err)
+	}
+	if accessToken.TermsVersion() != "2026-10" {
+		t.Errorf("TermsVersion = %q, want 2026-10", accessToken.TermsVersion())
+	}
+	encoded, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+	if err := accessToken.Decode(encoded, env.TokenValidator); err != nil {
+		t.Fatalf("failed to decode refreshed access token: %v", err)
+	}
+	if accessToken.TermsVersion() != "2026-10" {
+		t.Errorf("refreshed TermsVersion = %q, want 2026-10", accessToken.TermsVersion())
+	}
+}
+
+func TestAcceptTerms_NoTermsConfigured(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+	env.RegisterTestUser(t, "alice", "password123")
+	alice, err := env.DB.GetUserByHandle(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByHandle failed: %v", err)
+	}
+
+	if pending, err := env.Service.TermsPending(t.Context(), alice.Subject); err != nil || pending {
+		t.Fatalf("TermsPending = %t, %v, want false", pending, err)
+	}
+	err = env.Service.AcceptTerms(t.Context(), alice.Subject, "2026-10")
+	if !errors.Is(err, service.ErrTermsOutdated) {
+		t.Fatalf("AcceptTerms: expected ErrTermsOutdated, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// Defaults for LoginThrottleOptions fields left zero.
+const (
+	DefaultLoginFreeAttempts = 3
+	DefaultLoginBaseDelay    = time.Second
+	DefaultLoginMaxDelay     = 30 * time.Second
+	DefaultLoginWindow       = 15 * time.Minute
+)
+
+// loginThrottlePruneSize is how many tracked handle and address pairs the
+// throttle holds before it sweeps out forgotten ones.
+const loginThrottlePruneSize = 10000
+
+// LoginThrottleOptions configures the progressive delay on password checks.
+// After FreeAttempts failed passwords for one handle from one address, each
+// further attempt from there waits BaseDelay, doubling with every failure up
+// to MaxDelay, before its password is checked. Failures are forgotten Window
+// after the last one, and a correct password clears them. Attempts for one
+// handle from one address are checked one at a time, so concurrent guesses
+// queue behind each other's delays instead of sharing one. Guessing slows to
+// a crawl, but the account never locks, so its owner can still sign in.
+type LoginThrottleOptions struct {
+	// Disabled turns the throttle off.
+	Disabled bool
+
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+
+	// Clock reads the time failures are recorded and judged at. Nil uses
+	// the system clock.
+	Clock tokens.Clock
+
+	// Sleep waits out a delay, returning early with ctx's error if ctx ends
+	// first. Nil uses a timer.
+	Sleep func(ctx context.Context, delay time.Duration) error
+}
+
+type loginThrottle struct {
+	options LoginThrottleOptions
+
+	mu       sync.Mutex
+	failures map[loginThrottleKey]loginFailures
+	turns    map[loginThrottleKey]*loginTurn
+}
+
+type loginThrottleKey struct {
+	handle string
+	ip     string
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// loginTurn lets one attempt for a key at a time wait out its delay and
+// check its password. Attempts holds how many are queued or in progress, so
+// the turn can be dropped once it reaches zero.
+type loginTurn struct {
+	held     chan struct{}
+	attempts int
+}
+
+func newLoginThrottle(
+	options LoginThrottleOptions,
+) *loginThrottle {
+	options.FreeAttempts = cmp.Or(options.FreeAttempts, DefaultLoginFreeAttempts)
+	options.BaseDelay = cmp.Or(options.BaseDelay, DefaultLoginBaseDelay)
+	options.MaxDelay = cmp.Or(options.MaxDelay, DefaultLoginMaxDelay)
+	options.Window = cmp.Or(options.Window, DefaultLoginWindow)
+	if options.Sleep == nil {
+		options.Sleep = sleepContext
+	}
+	return &loginThrottle{
+		options:  options,
+		failures: make(map[loginThrottleKey]loginFailures),
+		turns:    make(map[loginThrottleKey]*loginTurn),
+	}
+}
+
+func (t *loginThrottle) now() time.Time {
+	if t.options.Clock == nil {
+		return time.Now()
+	}
+	return t.options.Clock.Now()
+}
+
+// delay returns how much longer the next attempt for key must wait.
+func (t *loginThrottle) delay(
+	key loginThrottleKey,
+) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	failures, ok := t.failures[key]
+	if !ok || now.Sub(failures.last) >= t.options.Window {
+		return 0
+	}
+	over := failures.count - t.options.FreeAttempts
+	if over < 0 {
+		return 0
+	}
+
+	delay := t.options.MaxDelay
+	if over < 32 {
+		delay = min(t.options.BaseDelay<<over, t.options.MaxDelay)
+	}
+	return max(failures.last.Add(delay).Sub(now), 0)
+}
+
+// wait holds an attempt for key until the attempts ahead of it are done and
+// its own delay has passed. The attempt keeps its turn until it calls the
+// returned release, after its failure or success is recorded.
+func (t *loginThrottle) wait(
+	ctx context.Context,
+	key loginThrottleKey,
+) (
+	func(),
+	error,
+) {
+	if t.options.Disabled {
+		return func() {}, nil
+	}
+
+	t.mu.Lock()
+	turn, ok := t.turns[key]
+	if !ok {
+		turn = &loginTurn{held: make(chan struct{}, 1)}
+		t.turns[key] = turn
+	}
+	turn.attempts++
+	t.mu.Unlock()
+
+	select {
+	case turn.held <- struct{}{}:
+	case <-ctx.Done():
+		t.leave(key, turn)
+		return nil, ctx.Err()
+	}
+	release := func() {
+		<-turn.held
+		t.leave(key, turn)
+	}
+
+	if delay := t.delay(key); delay > 0 {
+		if err := t.options.Sleep(ctx, delay); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// leave drops an attempt from key's turn, forgetting the turn once no
+// attempts remain.
+func (t *loginThrottle) leave(
+	key loginThrottleKey,
+	turn *loginTurn,
+) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	turn.attempts--
+	if turn.attempts == 0 {
+		delete(t.turns, key)
+	}
+}
+
+// fail records a wrong password for key.
+func (t *loginThrottle) fail(
+	key loginThrottleKey,
+) {
+	if t.options.Disabled {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if len(t.failures) >= loginThrottlePruneSize {
+		for stale, failures := range t.failures {
+			if now.Sub(failures.last) >= t.options.Window {
+				delete(t.failures, stale)
+			}
+		}
+	}
+
+	failures := t.failures[key]
+	if now.Sub(failures.last) >= t.options.Window {
+		failures.count = 0
+	}
+	failures.count++
+	failures.last = now
+	t.failures[key] = failures
+}
+
+// succeed clears the failures of key.
+func (t *loginThrottle) succeed(
+	key loginThrottleKey,
+) {
+	if t.options.Disabled {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+// throttledPassword checks handle's password behind the login throttle,
+// keyed by handle and the address in ctx.
+func (s *Service) throttledPassword(
+	ctx context.Context,
+	handle string,
+	password string,
+) error {
+	key := loginThrottleKey{handle: handle, ip: clientInfoFrom(ctx).IP}
+	release, err := s.loginThrottle.wait(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%w: login throttled: %v", ErrInternal, err)
+	}
+	defer release()
+
+	err = s.checkPassword(ctx, handle, password)
+	switch {
+	case err == nil:
+		s.loginThrottle.succeed(key)
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrAccountNotFound):
+		s.loginThrottle.fail(key)
+	}
+	return err
+}
+
+func sleepContext(
+	ctx context.Context,
+	delay time.Duration,
+) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
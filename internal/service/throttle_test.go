@@ -0,0 +1,154 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/testutil"
+)
+
+// throttleClock is a clock that only moves when the throttle sleeps on it.
+type throttleClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *throttleClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *throttleClock) Sleep(_ context.Context, delay time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, delay)
+	c.now = c.now.Add(delay)
+	return nil
+}
+
+func setupThrottledEnv(
+	t *testing.T,
+	clock *throttleClock,
+) *testutil.TestEnv {
+	t.Helper()
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.LoginThrottle = service.LoginThrottleOptions{
+			FreeAttempts: 2,
+			BaseDelay:    time.Second,
+			MaxDelay:     4 * time.Second,
+			Window:       time.Hour,
+			Clock:        clock,
+			Sleep:        clock.Sleep,
+		}
+	})
+	if _, err := env.Service.CreateUser(t.Context(), "alice", "password123", nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	return env
+}
+
+func TestLoginThrottle_DelaysRepeatedFailures(t *testing.T) {
+	t.Parallel()
+	clock := &throttleClock{now: time.Unix(1700000000, 0)}
+	env := setupThrottledEnv(t, clock)
+	ctx := service.WithClientInfo(t.Context(), service.ClientInfo{IP: "192.0.2.1"})
+
+	// delays start after the free attempts and double up to the cap
+	for range 6 {
+		_, err := env.Service.GrantAuthCode(ctx, "alice", "wrong", service.InternalIntegrationName)
+		if !errors.Is(err, service.ErrInvalidCredentials) {
+			t.Fatalf("GrantAuthCode err = %v, want ErrInvalidCredentials", err)
+		}
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	if !slices.Equal(clock.sleeps, want) {
+		t.Errorf("sleeps = %v, want %v", clock.sleeps, want)
+	}
+
+	// other addresses are not held up
+	clock.sleeps = nil
+	other := service.WithClientInfo(t.Context(), service.ClientInfo{IP: "198.51.100.1"})
+	if _, err := env.Service.GrantAuthCode(other, "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode from other address failed: %v", err)
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("sleeps = %v, want none from another address", clock.sleeps)
+	}
+
+	// the right password still gets through, after the wait, and clears
+	// the failures
+	if _, err := env.Service.GrantAuthCode(ctx, "alice", "password123", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode with right password failed: %v", err)
+	}
+	clock.sleeps = nil
+	if _, err := env.Service.GrantAuthCode(ctx, "alice", "wrong", service.InternalIntegrationName); err == nil {
+		t.Fatal("GrantAuthCode with wrong password succeeded")
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("sleeps = %v, want none after a successful login", clock.sleeps)
+	}
+}
+
+func TestLoginThrottle_QueuesConcurrentAttempts(t *testing.T) {
+	t.Parallel()
+	clock := &throttleClock{now: time.Unix(1700000000, 0)}
+	env := setupThrottledEnv(t, clock)
+	ctx := service.WithClientInfo(t.Context(), service.ClientInfo{IP: "192.0.2.1"})
+
+	// attempts fired at once still wait for each other's delays, rather
+	// than all sharing the delay of the failures before them
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			env.Service.GrantAuthCode(ctx, "alice", "wrong", service.InternalIntegrationName)
+		}()
+	}
+	wg.Wait()
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if !slices.Equal(clock.sleeps, want) {
+		t.Errorf("sleeps = %v, want %v", clock.sleeps, want)
+	}
+}
+
+func TestLoginThrottle_ForgetsOldFailures(t *testing.T) {
+	t.Parallel()
+	clock := &throttleClock{now: time.Unix(1700000000, 0)}
+	env := setupThrottledEnv(t, clock)
+
+	for range 3 {
+		env.Service.GrantAuthCode(t.Context(), "alice", "wrong", service.InternalIntegrationName)
+	}
+	clock.sleeps = nil
+
+	// failures older than the window no longer delay attempts
+	clock.now = clock.now.Add(2 * time.Hour)
+	env.Service.GrantAuthCode(t.Context(), "alice", "wrong", service.InternalIntegrationName)
+	if len(clock.sleeps) != 0 {
+		t.Errorf("sleeps = %v, want none after the window", clock.sleeps)
+	}
+}
+
+func TestLoginThrottle_Disabled(t *testing.T) {
+	t.Parallel()
+	clock := &throttleClock{now: time.Unix(1700000000, 0)}
+	env := testutil.SetupTestEnvWithServiceOptions(t, func(options *service.Options) {
+		options.LoginThrottle = service.LoginThrottleOptions{Disabled: true, Clock: clock, Sleep: clock.Sleep}
+	})
+
+	for range 10 {
+		env.Service.GrantAuthCode(t.Context(), "nobody", "wrong", service.InternalIntegrationName)
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("sleeps = %v, want none when disabled", clock.sleeps)
+	}
+}
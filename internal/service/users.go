@@ -1,26 +1,54 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
+// User is an account. A Disabled user keeps their data but can't sign in,
+// and their refresh tokens are refused until they are enabled again. The
+// same goes for a temporary user, such as a contractor, once ExpiresAt
+// passes; a zero ExpiresAt never expires. TermsVersion is the version of
+// the terms of service the user last accepted, at TermsAcceptedAt, or empty
+// if they have accepted none.
 type User struct {
-	Subject string
-	Handle  string
-	Roles   []string
+	Subject         string
+	Handle          string
+	Roles           []string
+	Disabled        bool
+	ExpiresAt       time.Time
+	TermsVersion    string
+	TermsAcceptedAt time.Time
+}
+
+// Expired reports whether the account has expired by now.
+func (u *User) Expired(
+	now time.Time,
+) bool {
+	return !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt)
 }
 
+// UserUpdate changes the fields that are set. A zero ExpiresAt removes the
+// account's expiry, and one that has already passed signs the user out at
+// once.
 type UserUpdate struct {
-	Handle *string
-	Roles  *[]string
+	Handle    *string
+	Roles     *[]string
+	Disabled  *bool
+	ExpiresAt *time.Time
+}
+
+// UserOptions are the optional settings of a new account.
+type UserOptions struct {
+	ExpiresAt time.Time
 }
 
 func (s *Service) CreateUser(
+	ctx context.Context,
 	handle string,
 	password string,
 	roles []string,
@@ -28,6 +56,23 @@ func (s *Service) CreateUser(
 	*User,
 	error,
 ) {
+	return s.CreateUserWithOptions(ctx, handle, password, roles, UserOptions{})
+}
+
+// CreateUserWithOptions is CreateUser with the account's optional settings.
+func (s *Service) CreateUserWithOptions(
+	ctx context.Context,
+	handle string,
+	password string,
+	roles []string,
+	options UserOptions,
+) (
+	*User,
+	error,
+) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
 	if handle == "" {
 		return nil, ErrInvalidHandle
 	}
@@ -37,27 +82,40 @@ func (s *Service) CreateUser(
 		return nil, fmt.Errorf("%w: failed to generate account subject: %v", ErrInternal, err)
 	}
 
-	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), s.passwordMode.Cost())
+	hashPass, err := s.passwords.hash(ctx, []byte(password))
 	if err != nil {
+		if errors.Is(err, ErrBusy) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("%w: failed to hash password: %v", ErrInternal, err)
 	}
 
-	err = s.store.InsertUser(subject, handle, hashPass, roles)
+	err = s.store.InsertUser(ctx, subject, handle, hashPass, roles)
 	if err != nil {
 		if isUniqueConstraintError(err) {
 			return nil, ErrHandleExists
 		}
 		return nil, fmt.Errorf("%w: failed to insert account: %v", ErrInternal, err)
 	}
+	user := &User{
+		Subject:   subject,
+		Handle:    handle,
+		Roles:     roles,
+		ExpiresAt: options.ExpiresAt,
+	}
+	if !user.ExpiresAt.IsZero() {
+		if err := s.store.UpdateUser(ctx, *user); err != nil {
+			_, _ = s.store.DeleteUser(ctx, subject)
+			return nil, fmt.Errorf("%w: failed to set account expiry: %v", ErrInternal, err)
+		}
+	}
+	s.emit(ctx, Event{Type: EventUserRegistered, Subject: subject, Handle: handle})
 
-	return &User{
-		Subject: subject,
-		Handle:  handle,
-		Roles:   roles,
-	}, nil
+	return user, nil
 }
 
 func (s *Service) GetUser(
+	ctx context.Context,
 	subject string,
 ) (
 	*User,
@@ -67,7 +125,7 @@ func (s *Service) GetUser(
 		return nil, ErrInvalidUser
 	}
 
-	record, err := s.store.GetUserBySubject(subject)
+	record, err := s.store.GetUserBySubject(ctx, subject)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, subject)
@@ -78,11 +136,11 @@ func (s *Service) GetUser(
 	return record, nil
 }
 
-func (s *Service) ListUsers() (
+func (s *Service) ListUsers(ctx context.Context) (
 	[]User,
 	error,
 ) {
-	records, err := s.store.ListUsers()
+	records, err := s.store.ListUsers(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to list users: %v", ErrInternal, err)
 	}
@@ -90,12 +148,16 @@ func (s *Service) ListUsers() (
 }
 
 func (s *Service) UpdateUser(
+	ctx context.Context,
 	subject string,
 	updates *UserUpdate,
 ) (
 	*User,
 	error,
 ) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
 	if subject == "" {
 		return nil, ErrInvalidUser
 	}
@@ -103,7 +165,7 @@ func (s *Service) UpdateUser(
 		return nil, ErrInvalidUpdate
 	}
 
-	current, err := s.store.GetUserBySubject(subject)
+	current, err := s.store.GetUserBySubject(ctx, subject)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, subject)
@@ -117,12 +179,19 @@ func (s *Service) UpdateUser(
 	if updates.Roles != nil {
 		current.Roles = *updates.Roles
 	}
+	wasDisabled := current.Disabled
+	if updates.Disabled != nil {
+		current.Disabled = *updates.Disabled
+	}
+	if updates.ExpiresAt != nil {
+		current.ExpiresAt = *updates.ExpiresAt
+	}
 
 	if current.Handle == "" {
 		return nil, ErrInvalidHandle
 	}
 
-	err = s.store.UpdateUser(subject, current.Handle, current.Roles)
+	err = s.store.UpdateUser(ctx, *current)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, subject)
@@ -133,30 +202,129 @@ func (s *Service) UpdateUser(
 		return nil, fmt.Errorf("%w: failed to update user: %v", ErrInternal, err)
 	}
 
+	// the janitor only sweeps expiries that pass after its last run, so an
+	// expiry that has already passed signs the user out here
+	if updates.ExpiresAt != nil && current.Expired(time.Now()) {
+		if err := s.tokenStore.DeleteUserTokens(ctx, subject); err != nil {
+			return nil, fmt.Errorf("%w: failed to revoke tokens of expired user: %v", ErrInternal, err)
+		}
+	}
+
+	switch {
+	case current.Disabled && !wasDisabled:
+		s.emit(ctx, Event{Type: EventUserDisabled, Subject: subject, Handle: current.Handle})
+	case !current.Disabled && wasDisabled:
+		s.emit(ctx, Event{Type: EventUserEnabled, Subject: subject, Handle: current.Handle})
+	}
+
 	return &User{
-		Subject: subject,
-		Handle:  current.Handle,
-		Roles:   append([]string(nil), current.Roles...),
+		Subject:   subject,
+		Handle:    current.Handle,
+		Roles:     append([]string(nil), current.Roles...),
+		Disabled:  current.Disabled,
+		ExpiresAt: current.ExpiresAt,
 	}, nil
 }
 
+// activeUser returns subject's account, or ErrAccountDisabled or
+// ErrAccountExpired if it is disabled or has expired, and ErrAccountNotFound
+// if it no longer exists. Anything that signs a user in or issues them
+// tokens checks it first.
+func (s *Service) activeUser(
+	ctx context.Context,
+	subject string,
+) (
+	*User,
+	error,
+) {
+	user, err := s.store.GetUserBySubject(ctx, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, subject)
+		}
+		return nil, fmt.Errorf("%w: failed to get user: %v", ErrInternal, err)
+	}
+	if err := accountActive(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// accountActive returns ErrAccountDisabled or ErrAccountExpired if user's
+// account is disabled or has expired.
+func accountActive(
+	user *User,
+) error {
+	if user.Disabled {
+		return fmt.Errorf("%w: %s", ErrAccountDisabled, user.Handle)
+	}
+	if user.Expired(time.Now()) {
+		return fmt.Errorf("%w: %s expired %s", ErrAccountExpired, user.Handle, user.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RevokeExpiredAccounts signs out users whose accounts expired after since
+// and no later than until, deleting their refresh tokens, authorization
+// codes, and device authorizations. It returns how many accounts it signed
+// out. Expired accounts are refused anyway; this clears what they left.
+func (s *Service) RevokeExpiredAccounts(
+	ctx context.Context,
+	since time.Time,
+	until time.Time,
+) (
+	int,
+	error,
+) {
+	subjects, err := s.store.ListExpiredUsers(ctx, since, until)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to list expired users: %v", ErrInternal, err)
+	}
+	for i, subject := range subjects {
+		if err := s.tokenStore.DeleteUserTokens(ctx, subject); err != nil {
+			return i, fmt.Errorf("%w: failed to revoke tokens of expired user: %v", ErrInternal, err)
+		}
+	}
+	return len(subjects), nil
+}
+
 func (s *Service) DeleteUser(
+	ctx context.Context,
 	subject string,
 ) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if subject == "" {
 		return ErrInvalidUser
 	}
 
-	deleted, err := s.store.DeleteUser(subject)
+	deleted, err := s.deleteUser(ctx, subject)
 	if err != nil {
 		return fmt.Errorf("%w: failed to delete user: %v", ErrInternal, err)
 	}
 	if !deleted {
 		return fmt.Errorf("%w: %s", ErrUserNotFound, subject)
 	}
+	s.emit(ctx, Event{Type: EventUserDeleted, Subject: subject})
 	return nil
 }
 
+// deleteUser deletes a user along with their sign-in state, which the token
+// store may keep apart from the user.
+func (s *Service) deleteUser(
+	ctx context.Context,
+	subject string,
+) (
+	bool,
+	error,
+) {
+	if err := s.tokenStore.DeleteUserTokens(ctx, subject); err != nil {
+		return false, err
+	}
+	return s.store.DeleteUser(ctx, subject)
+}
+
 func isUniqueConstraintError(err error) bool {
 	if err == nil {
 		return false
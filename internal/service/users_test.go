@@ -3,6 +3,7 @@ package service_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/internal/service"
 	"git.sr.ht/~jakintosh/consent/internal/testutil"
@@ -14,7 +15,7 @@ func TestCreateUser_Success(t *testing.T) {
 
 	env.CreateTestRole(t, "ops", "Operations")
 
-	user, err := env.Service.CreateUser("alice", "securepassword", []string{"admin", "ops"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"admin", "ops"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -41,7 +42,7 @@ func TestCreateUser_InvalidRole(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	// roles with spaces are auto-created by the database
-	user, err := env.Service.CreateUser("alice", "securepassword", []string{"bad role"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"bad role"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -62,7 +63,7 @@ func TestCreateUser_UnknownRole(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 
 	// unknown roles are auto-created by the database
-	user, err := env.Service.CreateUser("alice", "securepassword", []string{"nonexistent"})
+	user, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"nonexistent"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -82,12 +83,12 @@ func TestGetUser_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	created, err := env.Service.CreateUser("alice", "securepassword", []string{"admin"})
+	created, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"admin"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	user, err := env.Service.GetUser(created.Subject)
+	user, err := env.Service.GetUser(t.Context(), created.Subject)
 	if err != nil {
 		t.Fatalf("GetUser failed: %v", err)
 	}
@@ -103,14 +104,14 @@ func TestListUsers(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	if _, err := env.Service.CreateUser("bob", "securepassword", nil); err != nil {
+	if _, err := env.Service.CreateUser(t.Context(), "bob", "securepassword", nil); err != nil {
 		t.Fatalf("CreateUser bob failed: %v", err)
 	}
-	if _, err := env.Service.CreateUser("alice", "securepassword", []string{"admin"}); err != nil {
+	if _, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"admin"}); err != nil {
 		t.Fatalf("CreateUser alice failed: %v", err)
 	}
 
-	users, err := env.Service.ListUsers()
+	users, err := env.Service.ListUsers(t.Context())
 	if err != nil {
 		t.Fatalf("ListUsers failed: %v", err)
 	}
@@ -129,14 +130,14 @@ func TestUpdateUser_Success(t *testing.T) {
 	env.CreateTestRole(t, "ops", "Operations")
 	env.CreateTestRole(t, "billing", "Billing")
 
-	created, err := env.Service.CreateUser("alice", "securepassword", []string{"admin"})
+	created, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", []string{"admin"})
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
 	handle := "alice-2"
 	roles := []string{"ops", "billing"}
-	updated, err := env.Service.UpdateUser(created.Subject, &service.UserUpdate{Handle: &handle, Roles: &roles})
+	updated, err := env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{Handle: &handle, Roles: &roles})
 	if err != nil {
 		t.Fatalf("UpdateUser failed: %v", err)
 	}
@@ -159,13 +160,13 @@ func TestUpdateUser_UnknownRole(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	created, err := env.Service.CreateUser("alice", "securepassword", nil)
+	created, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", nil)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
 	roles := []string{"nonexistent"}
-	updated, err := env.Service.UpdateUser(created.Subject, &service.UserUpdate{Roles: &roles})
+	updated, err := env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{Roles: &roles})
 	if err != nil {
 		t.Fatalf("UpdateUser failed: %v", err)
 	}
@@ -181,20 +182,158 @@ func TestUpdateUser_UnknownRole(t *testing.T) {
 	}
 }
 
+func TestUpdateUser_DisableGatesSignIn(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	created, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	disabled := true
+	updated, err := env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{Disabled: &disabled})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if !updated.Disabled {
+		t.Fatal("user was not disabled")
+	}
+
+	// a disabled user can't sign in, and their sessions can't be refreshed
+	_, err = env.Service.GrantAuthCode(t.Context(), "alice", "securepassword", service.InternalIntegrationName)
+	if !errors.Is(err, service.ErrAccountDisabled) {
+		t.Fatalf("GrantAuthCode: expected ErrAccountDisabled, got %v", err)
+	}
+	_, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if !errors.Is(err, service.ErrAccountDisabled) {
+		t.Fatalf("RefreshAccessToken: expected ErrAccountDisabled, got %v", err)
+	}
+
+	// enabling the user again restores the sessions they had
+	enabled := false
+	if _, err := env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{Disabled: &enabled}); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if _, _, err := env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{}); err != nil {
+		t.Fatalf("RefreshAccessToken failed after enabling: %v", err)
+	}
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "securepassword", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed after enabling: %v", err)
+	}
+}
+
+func TestUpdateUser_ExpiryGatesSignIn(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	expiresAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	created, err := env.Service.CreateUserWithOptions(t.Context(), "alice", "securepassword", nil, service.UserOptions{ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("CreateUserWithOptions failed: %v", err)
+	}
+	if !created.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("ExpiresAt = %v, want %v", created.ExpiresAt, expiresAt)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	// an expired user can't sign in, and their sessions can't be refreshed
+	_, err = env.Service.GrantAuthCode(t.Context(), "alice", "securepassword", service.InternalIntegrationName)
+	if !errors.Is(err, service.ErrAccountExpired) {
+		t.Fatalf("GrantAuthCode: expected ErrAccountExpired, got %v", err)
+	}
+	_, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if !errors.Is(err, service.ErrAccountExpired) {
+		t.Fatalf("RefreshAccessToken: expected ErrAccountExpired, got %v", err)
+	}
+
+	// the janitor signs out accounts that expired in its window only once
+	revoked, err := env.Service.RevokeExpiredAccounts(t.Context(), time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("RevokeExpiredAccounts failed: %v", err)
+	}
+	if revoked != 1 {
+		t.Fatalf("RevokeExpiredAccounts = %d, want 1", revoked)
+	}
+	revoked, err = env.Service.RevokeExpiredAccounts(t.Context(), time.Now(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("RevokeExpiredAccounts failed: %v", err)
+	}
+	if revoked != 0 {
+		t.Fatalf("RevokeExpiredAccounts = %d, want 0", revoked)
+	}
+
+	// extending the account lets alice sign in again, but not revive the
+	// sessions the janitor revoked
+	extended := time.Now().Add(time.Hour)
+	updated, err := env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{ExpiresAt: &extended})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if updated.Expired(time.Now()) {
+		t.Fatal("user is still expired after extending")
+	}
+	if _, err := env.Service.GrantAuthCode(t.Context(), "alice", "securepassword", service.InternalIntegrationName); err != nil {
+		t.Fatalf("GrantAuthCode failed after extending: %v", err)
+	}
+	_, _, err = env.Service.RefreshAccessToken(t.Context(), token.Encoded(), service.ClientCredentials{})
+	if !errors.Is(err, service.ErrTokenNotFound) {
+		t.Fatalf("RefreshAccessToken: expected ErrTokenNotFound, got %v", err)
+	}
+
+	// a zero time removes the expiry
+	never := time.Time{}
+	updated, err = env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{ExpiresAt: &never})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if !updated.ExpiresAt.IsZero() {
+		t.Fatalf("ExpiresAt = %v, want zero", updated.ExpiresAt)
+	}
+}
+
+func TestUpdateUser_PastExpirySignsOut(t *testing.T) {
+	t.Parallel()
+	env := testutil.SetupTestEnv(t)
+
+	created, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	token := env.StoreTestRefreshToken(t, "alice", []string{"test-audience"})
+
+	// the janitor sweeps up to now, then alice's contract is ended early
+	// with an expiry it has already swept past
+	sweptUntil := time.Now()
+	if _, err := env.Service.RevokeExpiredAccounts(t.Context(), time.Time{}, sweptUntil); err != nil {
+		t.Fatalf("RevokeExpiredAccounts failed: %v", err)
+	}
+	ended := sweptUntil.Add(-time.Hour)
+	if _, err := env.Service.UpdateUser(t.Context(), created.Subject, &service.UserUpdate{ExpiresAt: &ended}); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+
+	// her sessions are gone without waiting for a sweep that would miss them
+	if _, err := env.DB.GetRefreshTokenOwner(t.Context(), token.Encoded()); err == nil {
+		t.Fatal("expected refresh token to be revoked")
+	}
+}
+
 func TestDeleteUser_Success(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	created, err := env.Service.CreateUser("alice", "securepassword", nil)
+	created, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", nil)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	if err := env.Service.DeleteUser(created.Subject); err != nil {
+	if err := env.Service.DeleteUser(t.Context(), created.Subject); err != nil {
 		t.Fatalf("DeleteUser failed: %v", err)
 	}
 
-	_, err = env.Service.GetUser(created.Subject)
+	_, err = env.Service.GetUser(t.Context(), created.Subject)
 	if !errors.Is(err, service.ErrUserNotFound) {
 		t.Fatalf("expected ErrUserNotFound, got %v", err)
 	}
@@ -204,7 +343,7 @@ func TestCreateUser_NoRoles(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.CreateUser("alice", "securepassword", nil)
+	_, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", nil)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -214,12 +353,12 @@ func TestCreateUser_ThenLogin(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, err := env.Service.CreateUser("alice", "securepassword", nil)
+	_, err := env.Service.CreateUser(t.Context(), "alice", "securepassword", nil)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	redirectURL, err := env.Service.GrantAuthCode("alice", "securepassword", service.InternalIntegrationName)
+	redirectURL, err := env.Service.GrantAuthCode(t.Context(), "alice", "securepassword", service.InternalIntegrationName)
 	if err != nil {
 		t.Errorf("user cannot login: %v", err)
 	}
@@ -232,9 +371,9 @@ func TestCreateUser_DuplicateHandle(t *testing.T) {
 	t.Parallel()
 	env := testutil.SetupTestEnv(t)
 
-	_, _ = env.Service.CreateUser("alice", "password1", nil)
+	_, _ = env.Service.CreateUser(t.Context(), "alice", "password1", nil)
 
-	_, err := env.Service.CreateUser("alice", "password2", nil)
+	_, err := env.Service.CreateUser(t.Context(), "alice", "password2", nil)
 	if err == nil {
 		t.Error("expected error for duplicate handle")
 	}
@@ -248,9 +387,9 @@ func TestCreateUser_PasswordHashed(t *testing.T) {
 	env := testutil.SetupTestEnv(t)
 	password := "mypassword"
 
-	_, _ = env.Service.CreateUser("alice", password, nil)
+	_, _ = env.Service.CreateUser(t.Context(), "alice", password, nil)
 
-	secret, err := env.DB.GetSecret("alice")
+	secret, err := env.DB.GetSecret(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetSecret failed: %v", err)
 	}
@@ -276,13 +415,13 @@ func TestCreateUser_MultipleUsers(t *testing.T) {
 	}
 
 	for _, u := range users {
-		if _, err := env.Service.CreateUser(u.handle, u.password, nil); err != nil {
+		if _, err := env.Service.CreateUser(t.Context(), u.handle, u.password, nil); err != nil {
 			t.Fatalf("CreateUser %s failed: %v", u.handle, err)
 		}
 	}
 
 	for _, u := range users {
-		_, err := env.Service.GrantAuthCode(u.handle, u.password, service.InternalIntegrationName)
+		_, err := env.Service.GrantAuthCode(t.Context(), u.handle, u.password, service.InternalIntegrationName)
 		if err != nil {
 			t.Errorf("Login %s failed: %v", u.handle, err)
 		}
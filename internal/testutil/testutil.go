@@ -80,6 +80,36 @@ func SetupTestEnv(
 	t *testing.T,
 ) *TestEnv {
 	t.Helper()
+	return setupTestEnv(t, nil)
+}
+
+// SetupTestEnvWithServiceOptions creates a test environment whose service
+// options configure adjusts before the service is built.
+func SetupTestEnvWithServiceOptions(
+	t *testing.T,
+	configure func(*service.Options),
+) *TestEnv {
+	t.Helper()
+	return setupTestEnv(t, configure)
+}
+
+// SetupTestEnvWithUpstreams creates a test environment whose service can
+// federate logins to the given upstream providers.
+func SetupTestEnvWithUpstreams(
+	t *testing.T,
+	upstreams []service.UpstreamProvider,
+) *TestEnv {
+	t.Helper()
+	return setupTestEnv(t, func(options *service.Options) {
+		options.Upstreams = upstreams
+	})
+}
+
+func setupTestEnv(
+	t *testing.T,
+	configure func(*service.Options),
+) *TestEnv {
+	t.Helper()
 
 	db := SetupTestDB(t)
 
@@ -99,13 +129,14 @@ func SetupTestEnv(
 		PublicURL:      "https://consent.test",
 		BootstrapToken: testBootstrapAPIKey,
 	}
-	if err := service.Init(initOpts); err != nil {
+	if err := service.Init(t.Context(), initOpts); err != nil {
 		t.Fatalf("failed to initialize service state: %v", err)
 	}
 
 	serviceOpts := service.Options{
 		PasswordMode:    service.PasswordModeTesting,
 		Store:           db,
+		PublicURL:       "https://consent.test",
 		TokenServerOpts: tkServerOpts,
 		ResourceTokenClientOpts: tokens.ClientOptions{
 			VerificationKey: &getSharedSigningKey().PublicKey,
@@ -113,6 +144,9 @@ func SetupTestEnv(
 			ValidAudience:   "test.consent.local",
 		},
 	}
+	if configure != nil {
+		configure(&serviceOpts)
+	}
 	svc, err := service.New(serviceOpts)
 	if err != nil {
 		t.Fatalf("failed to initialize test service: %v", err)
@@ -145,7 +179,7 @@ func (env *TestEnv) CreateTestIntegration(
 	redirect string,
 ) {
 	t.Helper()
-	if err := env.Service.CreateIntegration(name, display, audience, redirect); err != nil {
+	if err := env.Service.CreateIntegration(t.Context(), name, display, audience, redirect); err != nil {
 		t.Fatalf("failed to create test integration: %v", err)
 	}
 }
@@ -157,7 +191,7 @@ func (env *TestEnv) CreateTestRole(
 	display string,
 ) *service.Role {
 	t.Helper()
-	role, err := env.Service.CreateRole(name, display)
+	role, err := env.Service.CreateRole(t.Context(), name, display)
 	if err != nil {
 		t.Fatalf("failed to create test role %q: %v", name, err)
 	}
@@ -194,7 +228,7 @@ func (env *TestEnv) RegisterTestUser(
 	password string,
 ) {
 	t.Helper()
-	if _, err := env.Service.CreateUser(handle, password, nil); err != nil {
+	if _, err := env.Service.CreateUser(t.Context(), handle, password, nil); err != nil {
 		t.Fatalf("failed to register test user: %v", err)
 	}
 }
@@ -251,7 +285,7 @@ func (env *TestEnv) IssueTestAccessTokenWithScopes(
 
 func (env *TestEnv) resolveSubject(t *testing.T, subject string) string {
 	t.Helper()
-	user, err := env.DB.GetUserByHandle(subject)
+	user, err := env.DB.GetUserByHandle(t.Context(), subject)
 	if err == nil {
 		return user.Subject
 	}
@@ -266,8 +300,33 @@ func (env *TestEnv) StoreTestRefreshToken(
 ) *tokens.RefreshToken {
 	t.Helper()
 	token := env.IssueTestRefreshToken(t, subject, audience)
-	if err := env.DB.InsertRefreshToken(token); err != nil {
+	if err := env.DB.InsertRefreshToken(t.Context(), token, service.ClientInfo{}); err != nil {
 		t.Fatalf("failed to store test refresh token: %v", err)
 	}
 	return token
 }
+
+// IssueTestAuthorizationCode approves an authorization request for a
+// registered user and returns the single-use code from the redirect
+func (env *TestEnv) IssueTestAuthorizationCode(
+	t *testing.T,
+	handle string,
+	integration string,
+	scopes []string,
+) string {
+	t.Helper()
+	subject := env.resolveSubject(t, handle)
+	review, err := env.Service.ReviewAuthorizationRequest(t.Context(), subject, integration, scopes, "", "", "")
+	if err != nil {
+		t.Fatalf("failed to review test authorization request: %v", err)
+	}
+	redirectURL, err := env.Service.ApproveAuthorization(t.Context(), subject, time.Now(), review)
+	if err != nil {
+		t.Fatalf("failed to approve test authorization request: %v", err)
+	}
+	code := redirectURL.Query().Get("auth_code")
+	if code == "" {
+		t.Fatalf("approval redirect has no auth_code: %s", redirectURL)
+	}
+	return code
+}
@@ -0,0 +1,287 @@
+// Package webhook delivers identity events to the URLs a deployment
+// registers, signed with a secret shared with each receiver and retried with
+// exponential backoff until the receiver accepts them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// Headers set on every delivery.
+const (
+	HeaderSignature = "Consent-Signature"
+	HeaderEvent     = "Consent-Event"
+	HeaderDelivery  = "Consent-Delivery"
+)
+
+// Defaults for Options left zero.
+const (
+	DefaultQueueSize   = 256
+	DefaultMaxAttempts = 6
+	DefaultBackoff     = time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Endpoint is a registered receiver. Events limits which event types it
+// receives; empty means all of them.
+type Endpoint struct {
+	Name   string
+	URL    string
+	Secret string
+	Events []service.EventType
+}
+
+func (e Endpoint) wants(
+	eventType service.EventType,
+) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, eventType)
+}
+
+// Options configures a Dispatcher.
+type Options struct {
+	Endpoints []Endpoint
+
+	// HTTPClient sends deliveries. Nil uses a client with DefaultTimeout.
+	HTTPClient *http.Client
+
+	// QueueSize bounds the deliveries waiting to be sent; events arriving
+	// while it is full are dropped and logged.
+	QueueSize int
+
+	// MaxAttempts bounds how often a delivery is tried. Backoff is the wait
+	// after the first failure, doubling after each further failure up to
+	// MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Payload is the JSON body of a delivery. RequestID is the ID of the request
+// that caused the event, when there was one, for matching deliveries to
+// consent's logs.
+type Payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	RequestID string      `json:"requestId,omitempty"`
+	Data      PayloadData `json:"data"`
+}
+
+// PayloadData describes the identity an event is about. Fields the event
+// doesn't have are omitted.
+type PayloadData struct {
+	Subject     string `json:"subject,omitempty"`
+	Handle      string `json:"handle,omitempty"`
+	Integration string `json:"integration,omitempty"`
+	IP          string `json:"ip,omitempty"`
+}
+
+type delivery struct {
+	endpoint Endpoint
+	payload  Payload
+	body     []byte
+}
+
+// Dispatcher queues events and delivers them in the background. Its Notify
+// method is a service.Options.OnEvent hook.
+type Dispatcher struct {
+	endpoints   []Endpoint
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	queue   chan delivery
+	done    chan struct{}
+	closing sync.Once
+	wg      sync.WaitGroup
+}
+
+// New starts a Dispatcher delivering to options.Endpoints. Close it to stop
+// delivering.
+func New(
+	options Options,
+) *Dispatcher {
+	client := options.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	queueSize := options.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	maxAttempts := options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	backoff := options.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	maxBackoff := options.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	d := &Dispatcher{
+		endpoints:   options.Endpoints,
+		client:      client,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		queue:       make(chan delivery, queueSize),
+		done:        make(chan struct{}),
+	}
+	d.wg.Add(1)
+	go d.run()
+	return d
+}
+
+// Notify queues event for every endpoint that wants it. It never blocks.
+func (d *Dispatcher) Notify(
+	_ context.Context,
+	event service.Event,
+) {
+	payload := Payload{
+		ID:        event.ID,
+		Type:      string(event.Type),
+		Time:      event.Time,
+		RequestID: event.RequestID,
+		Data: PayloadData{
+			Subject:     event.Subject,
+			Handle:      event.Handle,
+			Integration: event.Integration,
+			IP:          event.Client.IP,
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("webhook: failed to encode %s event: %v", event.Type, err)
+		return
+	}
+
+	for _, endpoint := range d.endpoints {
+		if !endpoint.wants(event.Type) {
+			continue
+		}
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+		select {
+		case d.queue <- delivery{endpoint: endpoint, payload: payload, body: body}:
+		default:
+			log.Printf("webhook %s: queue full, dropped %s event %s", endpoint.Name, payload.Type, payload.ID)
+		}
+	}
+}
+
+// Close stops delivering, abandoning queued and retrying deliveries, and
+// waits for the delivery in flight to finish.
+func (d *Dispatcher) Close() {
+	d.closing.Do(func() { close(d.done) })
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.done:
+			return
+		case next := <-d.queue:
+			d.deliver(next)
+		}
+	}
+}
+
+// deliver sends one delivery, retrying failures with backoff, and logs it if
+// every attempt fails.
+func (d *Dispatcher) deliver(
+	next delivery,
+) {
+	wait := d.backoff
+	var err error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if err = d.send(next); err == nil {
+			return
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		select {
+		case <-d.done:
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, d.maxBackoff)
+	}
+	log.Printf("webhook %s: giving up on %s event %s after %d attempts: %v",
+		next.endpoint.Name, next.payload.Type, next.payload.ID, d.maxAttempts, err)
+}
+
+// send makes one delivery attempt. Any 2xx response is success.
+func (d *Dispatcher) send(
+	next delivery,
+) error {
+	req, err := http.NewRequest(http.MethodPost, next.endpoint.URL, bytes.NewReader(next.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, next.payload.Type)
+	req.Header.Set(HeaderDelivery, next.payload.ID)
+	req.Header.Set(HeaderSignature, Sign(next.endpoint.Secret, time.Now(), next.body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">". Putting
+// the time under the signature lets receivers reject replayed deliveries.
+func Sign(
+	secret string,
+	t time.Time,
+	body []byte,
+) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+func signature(
+	secret string,
+	timestamp string,
+	body []byte,
+) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
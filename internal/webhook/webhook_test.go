@@ -0,0 +1,146 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/internal/webhook"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver answers the first failures deliveries with 503 and records the
+// rest.
+func receiver(
+	t *testing.T,
+	failures int32,
+) (
+	*httptest.Server,
+	<-chan received,
+	*atomic.Int32,
+) {
+	t.Helper()
+	deliveries := make(chan received, 8)
+	attempts := new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, deliveries, attempts
+}
+
+func awaitDelivery(
+	t *testing.T,
+	deliveries <-chan received,
+) received {
+	t.Helper()
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery")
+		return received{}
+	}
+}
+
+func TestDispatcher_DeliversSignedPayload(t *testing.T) {
+	t.Parallel()
+	srv, deliveries, _ := receiver(t, 0)
+	dispatcher := webhook.New(webhook.Options{
+		Endpoints: []webhook.Endpoint{{Name: "hook", URL: srv.URL, Secret: "shh"}},
+	})
+	defer dispatcher.Close()
+
+	dispatcher.Notify(t.Context(), service.Event{
+		ID:      "evt-1",
+		Type:    service.EventUserRegistered,
+		Time:    time.Now(),
+		Subject: "sub-1",
+		Handle:  "alice",
+	})
+	delivery := awaitDelivery(t, deliveries)
+
+	if got := delivery.header.Get(webhook.HeaderEvent); got != "user.registered" {
+		t.Errorf("event header = %q, want user.registered", got)
+	}
+	if got := delivery.header.Get(webhook.HeaderDelivery); got != "evt-1" {
+		t.Errorf("delivery header = %q, want evt-1", got)
+	}
+
+	// the signature covers the timestamp and the exact body
+	signature := delivery.header.Get(webhook.HeaderSignature)
+	timestamp, _, _ := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("signature %q has no timestamp", signature)
+	}
+	if want := webhook.Sign("shh", time.Unix(unix, 0), delivery.body); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+	if forged := webhook.Sign("other", time.Unix(unix, 0), delivery.body); signature == forged {
+		t.Error("signature doesn't depend on the secret")
+	}
+
+	var payload webhook.Payload
+	if err := json.Unmarshal(delivery.body, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.ID != "evt-1" || payload.Type != "user.registered" || payload.Data.Subject != "sub-1" || payload.Data.Handle != "alice" {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	t.Parallel()
+	srv, deliveries, attempts := receiver(t, 2)
+	dispatcher := webhook.New(webhook.Options{
+		Endpoints:   []webhook.Endpoint{{Name: "hook", URL: srv.URL, Secret: "shh"}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	})
+	defer dispatcher.Close()
+
+	dispatcher.Notify(t.Context(), service.Event{ID: "evt-1", Type: service.EventTokenRevoked})
+	awaitDelivery(t, deliveries)
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestDispatcher_FiltersEvents(t *testing.T) {
+	t.Parallel()
+	srv, deliveries, _ := receiver(t, 0)
+	dispatcher := webhook.New(webhook.Options{
+		Endpoints: []webhook.Endpoint{{
+			Name:   "hook",
+			URL:    srv.URL,
+			Secret: "shh",
+			Events: []service.EventType{service.EventUserDeleted},
+		}},
+	})
+	defer dispatcher.Close()
+
+	// deliveries are in order, so the first one seen shows the filter held
+	dispatcher.Notify(t.Context(), service.Event{ID: "evt-1", Type: service.EventLoginFailed})
+	dispatcher.Notify(t.Context(), service.Event{ID: "evt-2", Type: service.EventUserDeleted})
+	delivery := awaitDelivery(t, deliveries)
+	if got := delivery.header.Get(webhook.HeaderDelivery); got != "evt-2" {
+		t.Errorf("first delivery = %q, want evt-2", got)
+	}
+}
@@ -1,16 +1,21 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"git.sr.ht/~jakintosh/command-go/pkg/wire"
 	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
@@ -26,6 +31,17 @@ const (
 
 const LogLevelDefault = LogLevelError
 
+// CSRFHeader is the request header consent.js sets to the CSRF secret on
+// fetch requests. CSRFFromRequest reads it alongside the `csrf` query
+// parameter.
+const CSRFHeader = "Consent-CSRF"
+
+// RequestIDHeader names each token call the client makes to the consent
+// server, for consent's logs, audit events, and error responses. Calls made
+// while serving a request reuse that request's ID, so a failed refresh can
+// be traced from the app's logs to consent's.
+const RequestIDHeader = requestid.Header
+
 var (
 	// ErrTokenAbsent indicates no token cookie was found in the request.
 	ErrTokenAbsent = errors.New("token not present")
@@ -41,15 +57,28 @@ var (
 	// ErrNetworkTokenRefresh indicates a network error occurred while
 	// communicating with the consent server during token refresh.
 	ErrNetworkTokenRefresh = errors.New("network issue during token refresh")
+
+	// ErrAuthCodeExpired indicates the auth server rejected an authorization
+	// code because it expired before it was exchanged. Login should be
+	// restarted to get a fresh code.
+	ErrAuthCodeExpired = errors.New("authorization code expired")
+
+	// ErrReauthenticationRequired indicates the request is authorized, but
+	// the user signed in longer ago than the action allows. Send them to
+	// ReauthenticateURL to sign in again.
+	ErrReauthenticationRequired = errors.New("recent authentication required")
 )
 
 type UserInfo struct {
 	Sub     string           `json:"sub"`
 	Profile *UserInfoProfile `json:"profile,omitempty"`
+	Email   string           `json:"email,omitempty"`
 }
 
 type UserInfoProfile struct {
-	Handle string `json:"handle"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
 }
 
 // Client manages authorization for a backend application integrating with
@@ -59,10 +88,14 @@ type UserInfoProfile struct {
 // Create a Client using Init, then use its methods to protect your HTTP handlers.
 type Client struct {
 	apiClient       *wire.Client
+	httpClient      *http.Client
 	insecureCookies bool
 	logLevel        LogLevel
 	authUrl         string
 	tokenValidator  TokenValidator
+	clientID        string
+	clientSecret    string
+	refreshWindow   time.Duration
 }
 
 // Init creates a new Client for integrating with the consent identity server.
@@ -81,6 +114,7 @@ func Init(
 		apiClient: &wire.Client{
 			BaseURL: authUrl,
 		},
+		httpClient:      http.DefaultClient,
 		insecureCookies: false,
 		logLevel:        LogLevelDefault,
 		authUrl:         authUrl,
@@ -88,6 +122,55 @@ func Init(
 	}
 }
 
+// InitFromServer creates a Client for audience by fetching the verification
+// key and issuer domain from the consent server at authUrl. The key is fetched
+// once and pinned for the life of the Client; restart the app to pick up a
+// rotated key.
+//
+// Only use this when the connection to authUrl is trusted (HTTPS in
+// production), since whoever answers it decides which tokens are valid.
+func InitFromServer(
+	authUrl string,
+	audience string,
+) (
+	*Client,
+	error,
+) {
+	authUrl = strings.TrimRight(authUrl, "/")
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	response, err := httpClient.Get(authUrl + "/api/v1/key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch verification key: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/api/v1/key returned status %d", response.StatusCode)
+	}
+
+	// a PEM-encoded P-256 key is well under this
+	data, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read verification key: %w", err)
+	}
+	key, err := tokens.ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse verification key: %w", err)
+	}
+
+	issuer := response.Header.Get(api.IssuerHeader)
+	if issuer == "" {
+		return nil, fmt.Errorf("/api/v1/key response missing %s header", api.IssuerHeader)
+	}
+
+	validator := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: key,
+		IssuerDomain:    issuer,
+		ValidAudience:   audience,
+	})
+	return Init(validator, authUrl), nil
+}
+
 func (c *Client) log(level LogLevel, format string, v ...any) {
 	if c.logLevel >= level {
 		log.Printf(format, v...)
@@ -109,6 +192,51 @@ func (c *Client) EnableInsecureCookies() {
 	c.insecureCookies = true
 }
 
+// SetHTTPClient makes the client send its requests to the consent server
+// through httpClient, for custom timeouts, proxies, TLS roots, or recording
+// transports in tests. The default is http.DefaultClient.
+func (c *Client) SetHTTPClient(
+	httpClient *http.Client,
+) {
+	c.httpClient = httpClient
+	c.apiClient.HTTPClient = httpClient
+}
+
+// SetClientSecret configures the credentials this client presents when
+// redeeming auth codes and refresh tokens. It is required once a secret has
+// been generated for the integration; clientID is the integration name.
+func (c *Client) SetClientSecret(
+	clientID string,
+	secret string,
+) {
+	c.clientID = clientID
+	c.clientSecret = secret
+}
+
+// SetRefreshWindow makes the Verify methods refresh tokens once the access
+// token has less than window left, rather than only after it expires, so
+// requests don't all stall on a refresh right at the expiry boundary. A valid
+// access token is still returned if the early refresh fails. Zero, the
+// default, refreshes only after expiry.
+func (c *Client) SetRefreshWindow(
+	window time.Duration,
+) {
+	c.refreshWindow = window
+}
+
+// SetClockSkew makes the client accept tokens up to leeway before they were
+// issued and after they expire, for hosts whose clock is slightly off from
+// the consent server's. It wraps the validator passed to Init with
+// tokens.WithLeeway; a validator built with ClientOptions.Leeway needs no
+// call.
+func (c *Client) SetClockSkew(
+	leeway time.Duration,
+) {
+	if c.tokenValidator != nil {
+		c.tokenValidator = tokens.WithLeeway(c.tokenValidator, leeway)
+	}
+}
+
 /*
 HandleAuthorizationCode returns a handler that fully handles the authorization
 code flow for a client. Set this to the same route you register with the
@@ -117,7 +245,7 @@ auth server as the redirect link, and it works out of the box.
 func (c *Client) HandleAuthorizationCode() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		// extract 'auth_code' refresh token
+		// extract single-use 'auth_code'
 		queries := r.URL.Query()
 		code := queries.Get("auth_code")
 		if code == "" {
@@ -126,25 +254,33 @@ func (c *Client) HandleAuthorizationCode() http.HandlerFunc {
 			return
 		}
 
-		// refresh tokens using code
-		accessToken, refreshToken, ok := c.RefreshTokens(code)
-		if !ok {
-			c.log(LogLevelDebug, "handle auth code error: error refreshing with auth server\n")
+		// exchange code for tokens
+		returnTo := callbackReturnTo(queries.Get("return_to"))
+		accessToken, refreshToken, err := c.exchangeAuthorizationCode(requestIDFor(r), code)
+		if errors.Is(err, ErrAuthCodeExpired) {
+			// send the user back where they started, which restarts login
+			c.log(LogLevelInfo, "handle auth code: code expired, restarting login\n")
+			c.ClearTokenCookies(w)
+			http.Redirect(w, r, returnTo, http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			c.log(LogLevelDebug, "handle auth code error: error exchanging code with auth server: %v\n", err)
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
 
 		c.SetTokenCookies(w, accessToken, refreshToken)
-		http.Redirect(w, r, callbackReturnTo(r.URL.Query().Get("return_to")), http.StatusSeeOther)
+		http.Redirect(w, r, returnTo, http.StatusSeeOther)
 	}
 }
 
 // HandleLogout returns a handler that revokes the current refresh token,
 // clears auth cookies, and redirects to "/".
 //
-// The request must include a CSRF token in the `csrf` query parameter that
-// matches the refresh token secret. The handler is method-agnostic and may be
-// registered for GET, POST, or both.
+// The request must include a CSRF token in the `csrf` query parameter or the
+// Consent-CSRF header that matches the refresh token secret. The handler is
+// method-agnostic and may be registered for GET, POST, or both.
 func (c *Client) HandleLogout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -155,8 +291,8 @@ func (c *Client) HandleLogout() http.HandlerFunc {
 			c.log(LogLevelDebug, "handle logout: invalid refresh token: %v\n", err)
 		} else {
 			// if present, validate CSRF and revoke
-			csrfSecret := r.URL.Query().Get("csrf")
-			if csrfSecret == "" || refreshToken.Secret() != csrfSecret {
+			csrfSecret := CSRFFromRequest(r)
+			if csrfSecret == "" || !tokens.SecureCompare(refreshToken.Secret(), csrfSecret) {
 				// if csrf fails, do not clear or revoke—invalid logout request
 				http.Error(w, "CSRF validation failed", http.StatusForbidden)
 				return
@@ -173,6 +309,15 @@ func (c *Client) HandleLogout() http.HandlerFunc {
 	}
 }
 
+// CSRFFromRequest returns the CSRF secret a request carries, from the `csrf`
+// query parameter or, failing that, the Consent-CSRF header.
+func CSRFFromRequest(r *http.Request) string {
+	if csrf := r.URL.Query().Get("csrf"); csrf != "" {
+		return csrf
+	}
+	return r.Header.Get(CSRFHeader)
+}
+
 func callbackReturnTo(returnTo string) string {
 	if returnTo == "" {
 		return "/"
@@ -197,15 +342,70 @@ func (c *Client) VerifyAuthorization(
 	*AccessToken,
 	error,
 ) {
+	result, err := c.VerifyAuthorizationResult(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return result.AccessToken, nil
+}
+
+// VerifyResult is the outcome of VerifyAuthorizationResult.
+type VerifyResult struct {
+	// AccessToken is the verified access token, the new one if Refreshed.
+	AccessToken *AccessToken
+
+	// Refreshed reports that the tokens were refreshed and new cookies set
+	// on the response.
+	Refreshed bool
+
+	// RefreshToken is the new refresh token when Refreshed, and nil
+	// otherwise.
+	RefreshToken *RefreshToken
+}
+
+// AccessExpiry is when the verified access token expires.
+func (r *VerifyResult) AccessExpiry() time.Time {
+	return r.AccessToken.Expiration()
+}
+
+// RefreshExpiry is when the new refresh token expires, or zero if the tokens
+// weren't refreshed.
+func (r *VerifyResult) RefreshExpiry() time.Time {
+	if r.RefreshToken == nil {
+		return time.Time{}
+	}
+	return r.RefreshToken.Expiration()
+}
+
+// VerifyAuthorizationResult verifies authorization like VerifyAuthorization,
+// and also reports whether it refreshed the tokens to do so, with the new
+// expiry times, so apps can update session state shown to the user or count
+// refreshes.
+func (c *Client) VerifyAuthorizationResult(
+	w http.ResponseWriter,
+	r *http.Request,
+) (
+	*VerifyResult,
+	error,
+) {
 
 	// validate access token in the request
 	accessToken, err := validateAccessToken(r, c.tokenValidator)
 	if accessToken != nil {
-		return accessToken, nil
+		result := &VerifyResult{AccessToken: accessToken}
+		if c.dueForRefresh(accessToken) {
+			if refreshToken, err := validateRefreshToken(r, c.tokenValidator); err == nil {
+				nextAccess, nextRefresh := c.refreshEarly(w, r, accessToken, refreshToken)
+				if nextAccess != accessToken {
+					result = &VerifyResult{AccessToken: nextAccess, Refreshed: true, RefreshToken: nextRefresh}
+				}
+			}
+		}
+		return result, nil
 	}
 	if !errorIsRefreshable(err) {
 		c.log(LogLevelDebug, "failed to validate access token: %v\n", err)
-		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+		return nil, fmt.Errorf("%w: %w", ErrTokenInvalid, err)
 	}
 
 	// if in refreshable state, validate refresh token
@@ -216,14 +416,28 @@ func (c *Client) VerifyAuthorization(
 	}
 
 	// refresh the tokens
-	accessToken, refreshToken, ok := c.RefreshTokens(refreshToken.Encoded())
-	if !ok {
-		c.log(LogLevelDebug, "couldn't exchange refresh token: error refreshing with auth server\n")
+	accessToken, refreshToken, err = c.refreshTokens(requestIDFor(r), refreshToken.Encoded())
+	if err != nil {
+		c.log(LogLevelDebug, "couldn't exchange refresh token: %v\n", err)
 		return nil, ErrNetworkTokenRefresh
 	}
 	c.SetTokenCookies(w, accessToken, refreshToken)
 
-	return accessToken, nil
+	return &VerifyResult{AccessToken: accessToken, Refreshed: true, RefreshToken: refreshToken}, nil
+}
+
+// VerifyAuthorizationNoRefresh verifies the request's access token and
+// nothing else. An expired access token is an error rather than a refresh,
+// so it never contacts the consent server or writes cookies; use it on hot
+// paths and read-only endpoints that would rather answer 401 than wait on a
+// refresh. It returns ErrTokenAbsent or ErrTokenInvalid.
+func (c *Client) VerifyAuthorizationNoRefresh(
+	r *http.Request,
+) (
+	*AccessToken,
+	error,
+) {
+	return validateAccessToken(r, c.tokenValidator)
 }
 
 // VerifyAuthorizationGetCSRF verifies authorization and returns the CSRF secret
@@ -251,16 +465,19 @@ func (c *Client) VerifyAuthorizationGetCSRF(
 	// validate access token in the request
 	accessToken, err := validateAccessToken(r, c.tokenValidator)
 	if accessToken != nil {
+		if c.dueForRefresh(accessToken) {
+			accessToken, refreshToken = c.refreshEarly(w, r, accessToken, refreshToken)
+		}
 		return accessToken, refreshToken.Secret(), nil
 	}
 	if !errorIsRefreshable(err) {
-		return nil, "", fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+		return nil, "", fmt.Errorf("%w: %w", ErrTokenInvalid, err)
 	}
 
 	// refresh the tokens
-	accessToken, refreshToken, ok := c.RefreshTokens(refreshToken.Encoded())
-	if !ok {
-		c.log(LogLevelDebug, "couldn't exchange refresh token: error refreshing with auth server\n")
+	accessToken, refreshToken, err = c.refreshTokens(requestIDFor(r), refreshToken.Encoded())
+	if err != nil {
+		c.log(LogLevelDebug, "couldn't exchange refresh token: %v\n", err)
 		return nil, "", ErrNetworkTokenRefresh
 	}
 
@@ -293,23 +510,26 @@ func (c *Client) VerifyAuthorizationCheckCSRF(
 	}
 
 	currentCSRFSecret := refreshToken.Secret()
-	if currentCSRFSecret != reqCSRFSecret {
+	if !tokens.SecureCompare(currentCSRFSecret, reqCSRFSecret) {
 		return nil, "", ErrCSRFInvalid
 	}
 
 	// validate access token in the request
 	accessToken, err := validateAccessToken(r, c.tokenValidator)
 	if accessToken != nil {
-		return accessToken, currentCSRFSecret, nil
+		if c.dueForRefresh(accessToken) {
+			accessToken, refreshToken = c.refreshEarly(w, r, accessToken, refreshToken)
+		}
+		return accessToken, refreshToken.Secret(), nil
 	}
 	if !errorIsRefreshable(err) {
-		return nil, "", fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+		return nil, "", fmt.Errorf("%w: %w", ErrTokenInvalid, err)
 	}
 
 	// refresh the tokens
-	accessToken, refreshToken, ok := c.RefreshTokens(refreshToken.Encoded())
-	if !ok {
-		c.log(LogLevelDebug, "couldn't exchange refresh token: error refreshing with auth server\n")
+	accessToken, refreshToken, err = c.refreshTokens(requestIDFor(r), refreshToken.Encoded())
+	if err != nil {
+		c.log(LogLevelDebug, "couldn't exchange refresh token: %v\n", err)
 		return nil, "", ErrNetworkTokenRefresh
 	}
 	newCSRFSecret := refreshToken.Secret()
@@ -318,6 +538,77 @@ func (c *Client) VerifyAuthorizationCheckCSRF(
 	return accessToken, newCSRFSecret, nil
 }
 
+// dueForRefresh reports whether a still-valid access token is inside the
+// refresh window.
+func (c *Client) dueForRefresh(
+	accessToken *AccessToken,
+) bool {
+	return c.refreshWindow > 0 && time.Until(accessToken.Expiration()) < c.refreshWindow
+}
+
+// refreshEarly refreshes a token pair whose access token is still valid,
+// setting the new cookies. If the refresh fails the pair is returned as is,
+// since it is still good until the access token expires.
+func (c *Client) refreshEarly(
+	w http.ResponseWriter,
+	r *http.Request,
+	accessToken *AccessToken,
+	refreshToken *RefreshToken,
+) (
+	*AccessToken,
+	*RefreshToken,
+) {
+	nextAccess, nextRefresh, err := c.refreshTokens(requestIDFor(r), refreshToken.Encoded())
+	if err != nil {
+		c.log(LogLevelDebug, "early refresh failed, keeping current tokens: %v\n", err)
+		return accessToken, refreshToken
+	}
+	c.SetTokenCookies(w, nextAccess, nextRefresh)
+	return nextAccess, nextRefresh
+}
+
+// RequireRecentAuth verifies authorization like VerifyAuthorization, and also
+// requires that the user entered their credentials within maxAge. Use it to
+// gate sensitive actions such as changing a password or deleting data. If the
+// sign-in is older, or the token doesn't say when it was, it returns
+// ErrReauthenticationRequired.
+func (c *Client) RequireRecentAuth(
+	w http.ResponseWriter,
+	r *http.Request,
+	maxAge time.Duration,
+) (
+	*AccessToken,
+	error,
+) {
+	accessToken, err := c.VerifyAuthorization(w, r)
+	if err != nil {
+		return nil, err
+	}
+	authTime := accessToken.AuthTime()
+	if authTime.IsZero() || time.Since(authTime) > maxAge {
+		return nil, ErrReauthenticationRequired
+	}
+	return accessToken, nil
+}
+
+// ReauthenticateURL returns the consent server's authorize URL for
+// integration and scopes with a max_age of maxAge. Consent makes a user who
+// signed in longer ago than that enter their credentials again before it
+// redirects back with a fresh authorization code.
+func (c *Client) ReauthenticateURL(
+	integration string,
+	scopes []string,
+	maxAge time.Duration,
+) string {
+	query := url.Values{}
+	query.Set("integration", integration)
+	for _, scope := range scopes {
+		query.Add("scope", scope)
+	}
+	query.Set("max_age", strconv.Itoa(int(maxAge/time.Second)))
+	return strings.TrimRight(c.authUrl, "/") + "/authorize?" + query.Encode()
+}
+
 /*
 RefreshTokens uses the provided encoded RefreshToken to fetch new tokens from
 the auth server. You can automatically invoke this behavior with
@@ -333,35 +624,133 @@ func (c *Client) RefreshTokens(
 	*RefreshToken,
 	bool,
 ) {
-	body, err := json.Marshal(api.RefreshRequest{RefreshToken: refreshTokenStr})
+	accessToken, refreshToken, err := c.refreshTokens(requestid.New(), refreshTokenStr)
 	if err != nil {
-		c.log(LogLevelError, "failed to encode refresh payload: %v\n", err)
+		c.log(LogLevelDebug, "%v\n", err)
 		return nil, nil, false
 	}
+	return accessToken, refreshToken, true
+}
 
-	response := api.RefreshResponse{}
-	c.log(LogLevelDebug, "POST { refresh_token } => %s/api/v1/auth/refresh\n", c.authUrl)
-	if err := c.apiClient.Post("/api/v1/auth/refresh", body, &response); err != nil {
-		c.log(LogLevelDebug, "POST %s/api/v1/auth/refresh failed: %v\n", c.authUrl, err)
-		return nil, nil, false
+func (c *Client) refreshTokens(
+	requestID string,
+	refreshTokenStr string,
+) (
+	*AccessToken,
+	*RefreshToken,
+	error,
+) {
+	body, err := json.Marshal(api.RefreshRequest{
+		RefreshToken: refreshTokenStr,
+		ClientID:     c.clientID,
+		ClientSecret: c.clientSecret,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode refresh payload: %v", err)
 	}
-	if response.AccessToken == "" || response.RefreshToken == "" {
-		c.log(LogLevelError, "refresh endpoint returned empty tokens\n")
+	return c.requestTokens(requestID, "/api/v1/auth/refresh", body)
+}
+
+/*
+ExchangeAuthorizationCode redeems a single-use authorization code for tokens.
+HandleAuthorizationCode() calls this for you; use it directly to compose a
+custom callback.
+
+Returns decoded token structures and a bool indicating success.
+*/
+func (c *Client) ExchangeAuthorizationCode(
+	code string,
+) (
+	*AccessToken,
+	*RefreshToken,
+	bool,
+) {
+	accessToken, refreshToken, err := c.exchangeAuthorizationCode(requestid.New(), code)
+	if err != nil {
+		c.log(LogLevelDebug, "%v\n", err)
 		return nil, nil, false
 	}
+	return accessToken, refreshToken, true
+}
+
+func (c *Client) exchangeAuthorizationCode(
+	requestID string,
+	code string,
+) (
+	*AccessToken,
+	*RefreshToken,
+	error,
+) {
+	body, err := json.Marshal(api.ExchangeRequest{
+		Code:         code,
+		ClientID:     c.clientID,
+		ClientSecret: c.clientSecret,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode exchange payload: %v", err)
+	}
+
+	return c.requestTokens(requestID, "/api/v1/auth/exchange", body)
+}
+
+// requestTokens posts body to a token-issuing endpoint and decodes the
+// returned token pair, sending requestID so failures can be found in the
+// server's logs. An expired authorization code is reported as
+// ErrAuthCodeExpired.
+func (c *Client) requestTokens(
+	requestID string,
+	path string,
+	body []byte,
+) (
+	*AccessToken,
+	*RefreshToken,
+	error,
+) {
+	c.log(LogLevelDebug, "POST => %s%s (request %s)\n", c.authUrl, path, requestID)
+	request, err := http.NewRequest(http.MethodPost, c.authUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s request: %v", path, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(RequestIDHeader, requestID)
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to call %s (request %s): %v", path, requestID, err)
+	}
+	defer response.Body.Close()
+
+	var envelope struct {
+		Data  api.RefreshResponse `json:"data"`
+		Error *api.Error          `json:"error"`
+	}
+	decodeErr := json.NewDecoder(response.Body).Decode(&envelope)
+	if response.StatusCode != http.StatusOK {
+		if decodeErr == nil && envelope.Error != nil {
+			if envelope.Error.Code == "auth_code_expired" {
+				return nil, nil, ErrAuthCodeExpired
+			}
+			return nil, nil, fmt.Errorf("%s returned status %d (request %s): %s", path, response.StatusCode, requestID, envelope.Error.Message)
+		}
+		return nil, nil, fmt.Errorf("%s returned status %d (request %s)", path, response.StatusCode, requestID)
+	}
+	if decodeErr != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s response: %v", path, decodeErr)
+	}
+	if envelope.Data.AccessToken == "" || envelope.Data.RefreshToken == "" {
+		return nil, nil, fmt.Errorf("%s returned empty tokens", path)
+	}
 
 	// decode tokens from response
 	accessToken := new(AccessToken)
-	if err := accessToken.Decode(response.AccessToken, c.tokenValidator); err != nil {
-		c.log(LogLevelError, "failed to decode access token: %v\n", err)
-		return nil, nil, false
+	if err := accessToken.Decode(envelope.Data.AccessToken, c.tokenValidator); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode access token: %w", err)
 	}
 	refreshToken := new(RefreshToken)
-	if err := refreshToken.Decode(response.RefreshToken, c.tokenValidator); err != nil {
-		c.log(LogLevelError, "failed to decode refresh token: %v\n", err)
-		return nil, nil, false
+	if err := refreshToken.Decode(envelope.Data.RefreshToken, c.tokenValidator); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode refresh token: %w", err)
 	}
-	return accessToken, refreshToken, true
+	return accessToken, refreshToken, nil
 }
 
 // SetTokenCookies sets HTTP-only cookies for the access and refresh tokens.
@@ -370,6 +759,9 @@ func (c *Client) RefreshTokens(
 // SameSite=Lax, Secure=true, and HttpOnly=true.
 // When EnableInsecureCookies is set, cookies are configured with
 // SameSite=Lax, Secure=false, and HttpOnly=true to support local HTTP.
+// Cookies last as long as their tokens, unless the refresh token is
+// session-only, in which case both are session cookies that the browser
+// drops when it closes.
 //
 // Call this after successful login or token refresh to store tokens in the client's browser.
 func (c *Client) SetTokenCookies(
@@ -380,6 +772,9 @@ func (c *Client) SetTokenCookies(
 	now := time.Now()
 	accessMaxAge := accessToken.Expiration().Sub(now).Seconds()
 	refreshMaxAge := refreshToken.Expiration().Sub(now).Seconds()
+	if refreshToken.SessionOnly() {
+		accessMaxAge, refreshMaxAge = 0, 0
+	}
 	secureCookie := !c.insecureCookies
 
 	accessTokenCookie := &http.Cookie{
@@ -449,7 +844,7 @@ func (c *Client) FetchUserInfo(
 	}
 	request.Header.Set("Authorization", "Bearer "+accessToken)
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call /api/v1/auth/userinfo: %v", err)
 	}
@@ -469,6 +864,18 @@ func (c *Client) FetchUserInfo(
 	return &body.Data, nil
 }
 
+// requestIDFor returns the ID for calls made while serving r: the request's
+// own, from consent's middleware or an inbound X-Request-ID, or a new one.
+func requestIDFor(r *http.Request) string {
+	if id := requestid.FromContext(r.Context()); id != "" {
+		return id
+	}
+	if id := r.Header.Get(RequestIDHeader); requestid.Valid(id) {
+		return id
+	}
+	return requestid.New()
+}
+
 func getCookie(r *http.Request, cookieName string) *http.Cookie {
 	if cookie, err := r.Cookie(cookieName); err == nil {
 		return cookie
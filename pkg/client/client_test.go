@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"git.sr.ht/~jakintosh/consent/internal/api"
 	"git.sr.ht/~jakintosh/consent/pkg/tokens"
 )
 
@@ -41,6 +43,25 @@ func TestHandleLogout_Success(t *testing.T) {
 	}
 }
 
+func TestHandleLogout_AcceptsCSRFHeader(t *testing.T) {
+	refreshToken, c := setupLogoutTestClient(t, http.StatusOK)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set(CSRFHeader, refreshToken.Secret())
+	req.AddCookie(&http.Cookie{Name: "refreshToken", Value: refreshToken.Encoded()})
+	rr := httptest.NewRecorder()
+
+	c.HandleLogout()(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	assertCookiesCleared(t, rr)
+	if !logoutCalled {
+		t.Fatalf("expected logout endpoint to be called")
+	}
+}
+
 func TestHandleLogout_InvalidCSRF(t *testing.T) {
 	refreshToken, c := setupLogoutTestClient(t, http.StatusOK)
 
@@ -141,6 +162,25 @@ func TestVerifyAuthorization_InvalidRefreshIncludesContext(t *testing.T) {
 	}
 }
 
+func TestVerifyAuthorization_InvalidAccessWrapsTokenError(t *testing.T) {
+	c := testClient(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "accessToken", Value: "invalid-token"})
+	rr := httptest.NewRecorder()
+
+	_, err := c.VerifyAuthorization(rr, req)
+	if !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid, got %v", err)
+	}
+	if !errors.Is(err, tokens.ErrTokenMalformed()) {
+		t.Fatalf("expected ErrTokenMalformed, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "expected three parts") {
+		t.Fatalf("expected malformed context, got %q", err.Error())
+	}
+}
+
 func TestVerifyAuthorizationCheckCSRF_MissingRefreshIsAbsent(t *testing.T) {
 	c := testClient(t)
 
@@ -187,6 +227,50 @@ func TestSetTokenCookies_InsecureCookiesDisablesSecure(t *testing.T) {
 	assertCookieSecure(t, rr.Result().Cookies(), false)
 }
 
+func TestSetTokenCookies_PersistentByDefault(t *testing.T) {
+	c := testClient(t)
+	accessToken, refreshToken := issueTestTokens(t, "alice", "app.test")
+	rr := httptest.NewRecorder()
+
+	c.SetTokenCookies(rr, accessToken, refreshToken)
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge <= 0 {
+			t.Errorf("cookie %q MaxAge = %d, want persistent", cookie.Name, cookie.MaxAge)
+		}
+	}
+}
+
+func TestSetTokenCookies_SessionOnly(t *testing.T) {
+	c := testClient(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		SigningKey:   key,
+		IssuerDomain: "consent.test",
+	})
+	accessToken, refreshToken, err := issuer.IssueSessionTokenPair("alice", []string{"app.test"}, nil, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("IssueSessionTokenPair failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+
+	c.SetTokenCookies(rr, accessToken, refreshToken)
+
+	// session-only refresh tokens get browser-session cookies
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("cookies = %d, want 2", len(cookies))
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge != 0 || !cookie.Expires.IsZero() {
+			t.Errorf("cookie %q MaxAge = %d, Expires = %v; want session cookie", cookie.Name, cookie.MaxAge, cookie.Expires)
+		}
+	}
+}
+
 func TestClearTokenCookies_UsesLaxSameSite(t *testing.T) {
 	c := testClient(t)
 	rr := httptest.NewRecorder()
@@ -439,3 +523,329 @@ func testClient(t *testing.T) *Client {
 	validator := tokens.InitClient(clientOpts)
 	return Init(validator, "https://consent.test")
 }
+
+func TestRefreshTokens_SendsClientSecret(t *testing.T) {
+	var received struct {
+		RefreshToken string `json:"refreshToken"`
+		ClientID     string `json:"clientId"`
+		ClientSecret string `json:"clientSecret"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/auth/refresh" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+
+	c := Init(nil, server.URL)
+	c.SetLogLevel(LogLevelNone)
+	c.SetClientSecret("myapp", "s3cret")
+	if _, _, ok := c.RefreshTokens("refresh.token.value"); ok {
+		t.Fatal("expected refresh to fail")
+	}
+
+	if received.RefreshToken != "refresh.token.value" {
+		t.Errorf("refreshToken = %q, want refresh.token.value", received.RefreshToken)
+	}
+	if received.ClientID != "myapp" || received.ClientSecret != "s3cret" {
+		t.Errorf("credentials = %q/%q, want myapp/s3cret", received.ClientID, received.ClientSecret)
+	}
+}
+
+func TestRefreshTokens_SendsRequestID(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(RequestIDHeader))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+
+	c := Init(nil, server.URL)
+	c.SetLogLevel(LogLevelNone)
+
+	// calls made while serving a request carry that request's ID, and
+	// failures name it
+	req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	req.Header.Set(RequestIDHeader, "trace-42")
+	_, _, err := c.refreshTokens(requestIDFor(req), "refresh.token.value")
+	if err == nil || !strings.Contains(err.Error(), "trace-42") {
+		t.Errorf("err = %v, want it to name request trace-42", err)
+	}
+
+	// standalone calls get an ID of their own
+	c.RefreshTokens("refresh.token.value")
+	if len(received) != 2 || received[0] != "trace-42" || received[1] == "" || received[1] == "trace-42" {
+		t.Errorf("request IDs sent = %q, want trace-42 then a new ID", received)
+	}
+}
+
+func TestExchangeAuthorizationCode_SendsCode(t *testing.T) {
+	var received struct {
+		Code         string `json:"code"`
+		ClientID     string `json:"clientId"`
+		ClientSecret string `json:"clientSecret"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/auth/exchange" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	c := Init(nil, server.URL)
+	c.SetLogLevel(LogLevelNone)
+	c.SetClientSecret("myapp", "s3cret")
+	if _, _, ok := c.ExchangeAuthorizationCode("opaque-code"); ok {
+		t.Fatal("expected exchange to fail")
+	}
+
+	if received.Code != "opaque-code" {
+		t.Errorf("code = %q, want opaque-code", received.Code)
+	}
+	if received.ClientID != "myapp" || received.ClientSecret != "s3cret" {
+		t.Errorf("credentials = %q/%q, want myapp/s3cret", received.ClientID, received.ClientSecret)
+	}
+}
+
+func TestHandleAuthorizationCode_ExpiredCodeRestartsLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"code":"auth_code_expired","message":"authorization code expired"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	c := Init(nil, server.URL)
+	c.SetLogLevel(LogLevelNone)
+	if _, _, err := c.exchangeAuthorizationCode("test-request", "stale-code"); !errors.Is(err, ErrAuthCodeExpired) {
+		t.Fatalf("err = %v, want ErrAuthCodeExpired", err)
+	}
+
+	// the user is sent back to the page they started from, which restarts login
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?auth_code=stale-code&return_to=%2Fdashboard", nil)
+	res := httptest.NewRecorder()
+	c.HandleAuthorizationCode()(res, req)
+	if res.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusSeeOther)
+	}
+	if location := res.Header().Get("Location"); location != "/dashboard" {
+		t.Errorf("Location = %q, want /dashboard", location)
+	}
+}
+
+func TestInitFromServer_PinsServedKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/key" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Header().Set(api.IssuerHeader, "consent.test")
+		_, _ = w.Write(der)
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := InitFromServer(server.URL+"/", "app.test")
+	if err != nil {
+		t.Fatalf("InitFromServer failed: %v", err)
+	}
+
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		SigningKey:   key,
+		IssuerDomain: "consent.test",
+	})
+	accessToken, err := issuer.IssueAccessToken("alice", []string{"app.test"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	for range 2 {
+		decoded := new(AccessToken)
+		if err := decoded.Decode(accessToken.Encoded(), c.tokenValidator); err != nil {
+			t.Fatalf("served key rejected a token it signed: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("fetches = %d, want 1", fetches)
+	}
+}
+
+func TestInitFromServer_RejectsUnavailableKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := InitFromServer(server.URL, "app.test"); err == nil {
+		t.Fatal("InitFromServer succeeded against a failing server")
+	}
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		SigningKey:   key,
+		IssuerDomain: "consent.test",
+	})
+	c := Init(tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "consent.test",
+		ValidAudience:   "app.test",
+	}), "https://consent.test")
+
+	tests := []struct {
+		name     string
+		authTime time.Time
+		wantErr  error
+	}{
+		{name: "recent", authTime: time.Now().Add(-time.Minute)},
+		{name: "stale", authTime: time.Now().Add(-time.Hour), wantErr: ErrReauthenticationRequired},
+		{name: "unknown", wantErr: ErrReauthenticationRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessToken, err := issuer.IssueAccessTokenWithOptions(
+				"subject",
+				[]string{"app.test"},
+				nil,
+				time.Hour,
+				tokens.IssueOptions{AuthTime: tt.authTime},
+			)
+			if err != nil {
+				t.Fatalf("IssueAccessTokenWithOptions failed: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "accessToken", Value: accessToken.Encoded()})
+
+			token, err := c.RequireRecentAuth(httptest.NewRecorder(), req, 5*time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RequireRecentAuth error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && token.Subject() != "subject" {
+				t.Fatalf("subject = %q, want %q", token.Subject(), "subject")
+			}
+		})
+	}
+}
+
+func TestReauthenticateURL(t *testing.T) {
+	c := testClient(t)
+
+	got := c.ReauthenticateURL("my-app", []string{"identity", "profile"}, 5*time.Minute)
+	want := "https://consent.test/authorize?integration=my-app&max_age=300&scope=identity&scope=profile"
+	if got != want {
+		t.Fatalf("ReauthenticateURL = %q, want %q", got, want)
+	}
+}
+
+func TestSetRefreshWindow_RefreshesBeforeExpiry(t *testing.T) {
+	c, issue := refreshingServer(t)
+	accessToken, refreshToken := issue(time.Minute)
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "accessToken", Value: accessToken.Encoded()})
+		req.AddCookie(&http.Cookie{Name: "refreshToken", Value: refreshToken.Encoded()})
+		return req
+	}
+
+	// without a window, a valid token is used as is
+	rr := httptest.NewRecorder()
+	verified, err := c.VerifyAuthorization(rr, request())
+	if err != nil || verified.Encoded() != accessToken.Encoded() {
+		t.Fatalf("VerifyAuthorization = %v, %v; want the current token", verified, err)
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Error("cookies set without a refresh")
+	}
+
+	// inside the window, it is refreshed ahead of expiry
+	c.SetRefreshWindow(2 * time.Minute)
+	rr = httptest.NewRecorder()
+	verified, csrf, err := c.VerifyAuthorizationGetCSRF(rr, request())
+	if err != nil {
+		t.Fatalf("VerifyAuthorizationGetCSRF failed: %v", err)
+	}
+	if !verified.Expiration().After(accessToken.Expiration()) {
+		t.Error("access token was not refreshed early")
+	}
+	if csrf == refreshToken.Secret() {
+		t.Error("csrf secret is from the rotated-out refresh token")
+	}
+	if len(rr.Result().Cookies()) != 2 {
+		t.Errorf("got %d cookies, want the new pair", len(rr.Result().Cookies()))
+	}
+}
+
+func TestVerifyAuthorizationNoRefresh_NeverContactsServer(t *testing.T) {
+	c, issue := refreshingServer(t)
+	accessToken, refreshToken := issue(-time.Minute)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "accessToken", Value: accessToken.Encoded()})
+	req.AddCookie(&http.Cookie{Name: "refreshToken", Value: refreshToken.Encoded()})
+
+	if _, err := c.VerifyAuthorizationNoRefresh(req); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("err = %v, want ErrTokenInvalid for an expired token", err)
+	}
+	if _, err := c.VerifyAuthorizationNoRefresh(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrTokenAbsent) {
+		t.Fatalf("err = %v, want ErrTokenAbsent without cookies", err)
+	}
+}
+
+func TestVerifyAuthorizationResult_ReportsRefresh(t *testing.T) {
+	c, issue := refreshingServer(t)
+	request := func(accessToken *AccessToken, refreshToken *RefreshToken) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "accessToken", Value: accessToken.Encoded()})
+		req.AddCookie(&http.Cookie{Name: "refreshToken", Value: refreshToken.Encoded()})
+		return req
+	}
+
+	// a valid token is not refreshed
+	accessToken, refreshToken := issue(time.Hour)
+	result, err := c.VerifyAuthorizationResult(httptest.NewRecorder(), request(accessToken, refreshToken))
+	if err != nil {
+		t.Fatalf("VerifyAuthorizationResult failed: %v", err)
+	}
+	if result.Refreshed || result.RefreshToken != nil || !result.RefreshExpiry().IsZero() {
+		t.Errorf("result = %+v, want no refresh", result)
+	}
+	if result.AccessExpiry().Unix() != accessToken.Expiration().Unix() {
+		t.Errorf("AccessExpiry = %v, want %v", result.AccessExpiry(), accessToken.Expiration())
+	}
+
+	// an expired one is, and the result carries the new expiry times
+	accessToken, refreshToken = issue(-time.Minute)
+	result, err = c.VerifyAuthorizationResult(httptest.NewRecorder(), request(accessToken, refreshToken))
+	if err != nil {
+		t.Fatalf("VerifyAuthorizationResult failed: %v", err)
+	}
+	if !result.Refreshed || result.RefreshToken == nil {
+		t.Fatalf("result = %+v, want a refresh", result)
+	}
+	if !result.AccessExpiry().After(time.Now()) || result.RefreshExpiry().IsZero() {
+		t.Errorf("expiries = %v, %v; want the new tokens'", result.AccessExpiry(), result.RefreshExpiry())
+	}
+}
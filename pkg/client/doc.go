@@ -28,6 +28,9 @@
 //	// Initialize the client
 //	authClient := client.Init(validator, "https://consent.example.com")
 //
+//	// Required once a client secret has been generated for the integration
+//	// authClient.SetClientSecret("myapp", os.Getenv("CONSENT_CLIENT_SECRET"))
+//
 //	// Optional: local development only (plain HTTP localhost)
 //	// authClient.EnableInsecureCookies()
 //
@@ -47,6 +50,14 @@
 //	    fmt.Fprintf(w, "Opaque subject: %s", subject)
 //	}
 //
+// VerifyAuthorizationResult verifies the same way and also reports whether
+// the tokens were refreshed, with their new expiry times, for apps that show
+// session state to the user or count refreshes.
+//
+// Hot paths that would rather answer 401 than wait on a refresh can use
+// VerifyAuthorizationNoRefresh, which only checks the access token and never
+// calls the consent server.
+//
 // # Authorization Code Flow
 //
 // Register a handler for the OAuth authorization code callback. Integrations should
@@ -57,10 +68,12 @@
 //	http.HandleFunc("/auth/callback", authClient.HandleAuthorizationCode())
 //	// Redirect users to:
 //	// https://consent.example.com/authorize?integration=myapp&scope=identity&scope=profile
+//	// Integrations with more than one registered redirect must also pass
+//	// &redirect_uri=... with an exact match of the one to use.
 //
 //	// When users complete login at the consent server, they'll be redirected
 //	// back to /auth/callback?auth_code=... and this handler will:
-//	// 1. Exchange the code for tokens
+//	// 1. Exchange the single-use code for tokens at /api/v1/auth/exchange
 //	// 2. Set auth cookies
 //	// 3. Redirect to your home page
 //
@@ -74,8 +87,8 @@
 //
 //	http.HandleFunc("/logout", authClient.HandleLogout())
 //
-// The logout handler validates CSRF using the `csrf` query parameter against
-// the refresh token secret in cookies. The handler supports both GET and POST
+// The logout handler validates CSRF using the `csrf` query parameter or the
+// Consent-CSRF header against the refresh token secret in cookies. The handler supports both GET and POST
 // routes; POST is preferred for state-changing operations.
 //
 // # CSRF Protection
@@ -96,7 +109,7 @@
 //
 //	// POST request - verify CSRF token
 //	func updateSettings(w http.ResponseWriter, r *http.Request) {
-//	    csrfFromRequest := r.URL.Query().Get("csrf")
+//	    csrfFromRequest := client.CSRFFromRequest(r)
 //	    accessToken, _, err := authClient.VerifyAuthorizationCheckCSRF(w, r, csrfFromRequest)
 //	    if err == client.ErrCSRFInvalid {
 //	        http.Error(w, "CSRF validation failed", http.StatusForbidden)
@@ -110,6 +123,74 @@
 //	    // Process the settings update...
 //	}
 //
+// # Browser Helper
+//
+// Pages can load consent.js from the consent server to follow these
+// conventions from JavaScript. Render the CSRF secret into a meta tag and
+// name the integration on the script tag:
+//
+//	<meta name="consent-csrf" content="{{ .CSRF }}">
+//	<script src="https://auth.example.com/assets/consent.js"
+//	        data-integration="myapp" data-scope="identity"></script>
+//
+// consent.login() redirects to the authorize page, consent.logout() posts to
+// /logout with the CSRF secret, and consent.fetch() adds the Consent-CSRF
+// header to same-origin requests, which CSRFFromRequest reads.
+//
+// # Router Middleware
+//
+// Middleware wraps the authorization check for any router that takes
+// func(http.Handler) http.Handler, and puts the access token in the request
+// context:
+//
+//	auth := client.Middleware(authClient, client.MiddlewareOptions{CheckCSRF: true})
+//
+//	mux.Handle("GET /settings", auth(settings)) // net/http ServeMux
+//	r.Use(auth)                                 // chi
+//	e.Use(echo.WrapMiddleware(auth))            // echo
+//
+//	// inside a handler
+//	accessToken, ok := client.AccessTokenFromContext(r.Context())
+//
+// A gateway fronting several apps can accept all of their tokens with
+// tokens.ClientOptions.ValidAudiences, then require one per route:
+//
+//	mux.Handle("/billing/", client.Middleware(authClient, client.MiddlewareOptions{Audience: "billing"})(billing))
+//
+// Gin handlers take a *gin.Context, so wrap the middleware around the rest
+// of the chain:
+//
+//	r.Use(func(c *gin.Context) {
+//	    next := false
+//	    auth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+//	        c.Request, next = req, true
+//	        c.Next()
+//	    })).ServeHTTP(c.Writer, c.Request)
+//	    if !next {
+//	        c.Abort()
+//	    }
+//	})
+//
+// # Long-Lived Connections
+//
+// VerifyAuthorization refreshes lazily, on the next request after the access
+// token expires. A server-sent event stream or long poll makes no next
+// request, so run RenewTokens beside it. It refreshes shortly before each
+// expiry and calls RenewOptions.OnRenew with the new pair.
+//
+// # Service-to-Service Identity
+//
+// After VerifyAuthorization, pass the user on to internal services with a
+// short-lived assertion signed by a key the services share, instead of the
+// access token:
+//
+//	req, _ := http.NewRequest("GET", "http://billing.internal/invoices", nil)
+//	err := client.StampIdentity(req, accessToken, "billing", sharedKey, 0)
+//
+// The receiving service checks it is the intended audience:
+//
+//	identity, err := client.VerifyIdentity(r, "billing", sharedKey)
+//
 // # Token Management
 //
 // Tokens are managed automatically through HTTP-only cookies:
@@ -124,6 +205,19 @@
 // EnableInsecureCookies uses Secure=false cookies for localhost HTTP
 // development only. Never use insecure cookies in production.
 //
+// # Step-Up Authentication
+//
+// Gate sensitive actions on a recent sign-in with RequireRecentAuth. When the
+// user signed in too long ago, send them back through Consent, which asks for
+// their secret again and redirects to your callback with fresh tokens:
+//
+//	accessToken, err := authClient.RequireRecentAuth(w, r, 5*time.Minute)
+//	if errors.Is(err, client.ErrReauthenticationRequired) {
+//	    scopes := []string{"identity"}
+//	    http.Redirect(w, r, authClient.ReauthenticateURL("myapp", scopes, 5*time.Minute), http.StatusSeeOther)
+//	    return
+//	}
+//
 // # Error Handling
 //
 // The package defines several error types for different failure modes.
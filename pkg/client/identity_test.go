@@ -0,0 +1,61 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStampIdentity_VerifiesAtAudience(t *testing.T) {
+	accessToken, _ := issueTestTokens(t, "alice", "app.test")
+	secret := []byte("shared-secret")
+	now := time.Now()
+
+	req := httptest.NewRequest(http.MethodGet, "http://billing.internal/invoices", nil)
+	if err := stampIdentity(req, accessToken, "billing", secret, 0, now); err != nil {
+		t.Fatalf("stampIdentity failed: %v", err)
+	}
+
+	identity, err := verifyIdentity(req, "billing", secret, now)
+	if err != nil {
+		t.Fatalf("verifyIdentity failed: %v", err)
+	}
+	if identity.Subject != "alice" || identity.Audience != "billing" {
+		t.Errorf("identity = %+v, want alice at billing", identity)
+	}
+
+	// another service, another key, or a later hop are all turned away
+	cases := map[string]func() error{
+		"audience": func() error { _, err := verifyIdentity(req, "search", secret, now); return err },
+		"secret":   func() error { _, err := verifyIdentity(req, "billing", []byte("other"), now); return err },
+		"expired": func() error {
+			_, err := verifyIdentity(req, "billing", secret, now.Add(DefaultIdentityLifetime+time.Second))
+			return err
+		},
+	}
+	for name, verify := range cases {
+		if err := verify(); !errors.Is(err, ErrIdentityInvalid) {
+			t.Errorf("%s: err = %v, want ErrIdentityInvalid", name, err)
+		}
+	}
+}
+
+func TestVerifyIdentity_RejectsTampering(t *testing.T) {
+	accessToken, _ := issueTestTokens(t, "alice", "app.test")
+	secret := []byte("shared-secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := StampIdentity(req, accessToken, "billing", secret, time.Minute); err != nil {
+		t.Fatalf("StampIdentity failed: %v", err)
+	}
+	req.Header.Set(IdentityHeader, "e30"+req.Header.Get(IdentityHeader)[3:])
+
+	if _, err := VerifyIdentity(req, "billing", secret); !errors.Is(err, ErrIdentityInvalid) {
+		t.Errorf("err = %v, want ErrIdentityInvalid", err)
+	}
+	if _, err := VerifyIdentity(httptest.NewRequest(http.MethodGet, "/", nil), "billing", secret); !errors.Is(err, ErrIdentityInvalid) {
+		t.Errorf("missing header: err = %v, want ErrIdentityInvalid", err)
+	}
+}
@@ -5,8 +5,14 @@ import "net/http"
 // Verifier validates authorization from HTTP requests.
 // Consuming projects should depend on this interface rather than *Client
 // to enable testing with mock implementations.
+//
+// VerifyAuthorization and the CSRF variants refresh an expired access token
+// with the refresh token, which costs a call to the consent server and sets
+// new cookies through w. VerifyAuthorizationNoRefresh only checks the access
+// token, failing once it expires, so it never adds that latency.
 type Verifier interface {
 	VerifyAuthorization(w http.ResponseWriter, r *http.Request) (*AccessToken, error)
+	VerifyAuthorizationNoRefresh(r *http.Request) (*AccessToken, error)
 	VerifyAuthorizationGetCSRF(w http.ResponseWriter, r *http.Request) (*AccessToken, string, error)
 	VerifyAuthorizationCheckCSRF(w http.ResponseWriter, r *http.Request, csrf string) (*AccessToken, string, error)
 }
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+type accessTokenKey struct{}
+
+// MiddlewareOptions configures Middleware.
+type MiddlewareOptions struct {
+	// Unauthorized answers requests without valid authorization. The default
+	// responds 401 Unauthorized.
+	Unauthorized http.Handler
+
+	// CheckCSRF requires requests with methods other than GET, HEAD, and
+	// OPTIONS to carry the CSRF secret, as read by CSRFFromRequest. Requests
+	// with a missing or wrong secret get 403 Forbidden.
+	CheckCSRF bool
+
+	// NoRefresh checks requests with VerifyAuthorizationNoRefresh, so an
+	// expired access token is unauthorized rather than refreshed. CSRF
+	// checks still use the refresh token.
+	NoRefresh bool
+
+	// Audience, when set, requires the access token's audience to include
+	// it, for a gateway whose validator accepts several apps' tokens but
+	// whose routes each belong to one. Other tokens are unauthorized.
+	Audience string
+}
+
+/*
+Middleware returns the client's authorization check in the standard
+func(http.Handler) http.Handler shape. Authorized requests reach next with
+their access token in the request context, read with AccessTokenFromContext.
+
+The shape plugs into most Go routers as is:
+
+	mux.Handle("GET /settings", auth(settingsHandler)) // net/http ServeMux
+	r.Use(auth)                                       // chi, gorilla/mux
+	e.Use(echo.WrapMiddleware(auth))                  // echo
+
+See the package documentation for gin.
+*/
+func Middleware(
+	verifier Verifier,
+	options MiddlewareOptions,
+) func(http.Handler) http.Handler {
+	unauthorized := options.Unauthorized
+	if unauthorized == nil {
+		unauthorized = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var accessToken *AccessToken
+			var err error
+			if options.CheckCSRF && !safeMethod(r.Method) {
+				accessToken, _, err = verifier.VerifyAuthorizationCheckCSRF(w, r, CSRFFromRequest(r))
+			} else if options.NoRefresh {
+				accessToken, err = verifier.VerifyAuthorizationNoRefresh(r)
+			} else {
+				accessToken, err = verifier.VerifyAuthorization(w, r)
+			}
+			if errors.Is(err, ErrCSRFInvalid) {
+				http.Error(w, "CSRF validation failed", http.StatusForbidden)
+				return
+			}
+			if err != nil || (options.Audience != "" && !slices.Contains(accessToken.Audience(), options.Audience)) {
+				unauthorized.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithAccessToken(r.Context(), accessToken)))
+		})
+	}
+}
+
+// WithAccessToken returns a copy of ctx carrying accessToken, as Middleware
+// does for authorized requests.
+func WithAccessToken(
+	ctx context.Context,
+	accessToken *AccessToken,
+) context.Context {
+	return context.WithValue(ctx, accessTokenKey{}, accessToken)
+}
+
+// AccessTokenFromContext returns the access token Middleware verified for
+// the request, if any.
+func AccessTokenFromContext(
+	ctx context.Context,
+) (
+	*AccessToken,
+	bool,
+) {
+	accessToken, ok := ctx.Value(accessTokenKey{}).(*AccessToken)
+	return accessToken, ok && accessToken != nil
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubVerifier authorizes requests carrying its token cookie, and checks
+// CSRF against a fixed secret.
+type stubVerifier struct {
+	accessToken *AccessToken
+}
+
+func (v stubVerifier) VerifyAuthorization(w http.ResponseWriter, r *http.Request) (*AccessToken, error) {
+	if _, err := r.Cookie("accessToken"); err != nil {
+		return nil, ErrTokenAbsent
+	}
+	return v.accessToken, nil
+}
+
+func (v stubVerifier) VerifyAuthorizationNoRefresh(r *http.Request) (*AccessToken, error) {
+	return v.VerifyAuthorization(nil, r)
+}
+
+func (v stubVerifier) VerifyAuthorizationGetCSRF(w http.ResponseWriter, r *http.Request) (*AccessToken, string, error) {
+	accessToken, err := v.VerifyAuthorization(w, r)
+	return accessToken, "secret", err
+}
+
+func (v stubVerifier) VerifyAuthorizationCheckCSRF(w http.ResponseWriter, r *http.Request, csrf string) (*AccessToken, string, error) {
+	accessToken, err := v.VerifyAuthorization(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	if csrf != "secret" {
+		return nil, "", ErrCSRFInvalid
+	}
+	return accessToken, "secret", nil
+}
+
+func TestMiddleware(t *testing.T) {
+	accessToken, _ := issueTestTokens(t, "alice", "app.test")
+	auth := Middleware(stubVerifier{accessToken: accessToken}, MiddlewareOptions{CheckCSRF: true})
+
+	mux := http.NewServeMux()
+	mux.Handle("/settings", auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := AccessTokenFromContext(r.Context())
+		if !ok || token.Subject() != "alice" {
+			t.Errorf("AccessTokenFromContext = %v, %v; want alice's token", token, ok)
+		}
+	})))
+
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		cookie bool
+		header string
+		want   int
+	}{
+		{"signed out", http.MethodGet, "/settings", false, "", http.StatusUnauthorized},
+		{"signed in", http.MethodGet, "/settings", true, "", http.StatusOK},
+		{"post without csrf", http.MethodPost, "/settings", true, "", http.StatusForbidden},
+		{"post with csrf query", http.MethodPost, "/settings?csrf=secret", true, "", http.StatusOK},
+		{"post with csrf header", http.MethodPost, "/settings", true, "secret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		if tc.cookie {
+			req.AddCookie(&http.Cookie{Name: "accessToken", Value: accessToken.Encoded()})
+		}
+		if tc.header != "" {
+			req.Header.Set(CSRFHeader, tc.header)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
+
+func TestMiddleware_CustomUnauthorized(t *testing.T) {
+	auth := Middleware(stubVerifier{}, MiddlewareOptions{
+		Unauthorized: http.RedirectHandler("/login", http.StatusSeeOther),
+	})
+	handler := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached without authorization")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/login" {
+		t.Errorf("status = %d, location = %q; want redirect to /login", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestMiddleware_RequiresRouteAudience(t *testing.T) {
+	accessToken, _ := issueTestTokens(t, "alice", "billing")
+	verifier := stubVerifier{accessToken: accessToken}
+	reached := ""
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = name })
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/billing", Middleware(verifier, MiddlewareOptions{Audience: "billing"})(handler("billing")))
+	mux.Handle("/search", Middleware(verifier, MiddlewareOptions{Audience: "search"})(handler("search")))
+
+	for path, want := range map[string]int{"/billing": http.StatusOK, "/search": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: "accessToken", Value: accessToken.Encoded()})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rr.Code, want)
+		}
+	}
+	if reached != "billing" {
+		t.Errorf("reached %q, want only billing", reached)
+	}
+}
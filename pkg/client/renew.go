@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/requestid"
+)
+
+// DefaultRenewBefore is how long before its access token expires
+// RenewTokens refreshes, when RenewOptions leaves Before zero.
+const DefaultRenewBefore = time.Minute
+
+// RenewOptions configures RenewTokens.
+type RenewOptions struct {
+	// Before is how long before the access token expires to refresh it.
+	Before time.Duration
+
+	// OnRenew receives each fresh pair of tokens. The refresh token it
+	// replaces has been rotated out, so anything holding the old pair, such
+	// as a long-lived connection's session, should switch to these.
+	OnRenew func(accessToken *AccessToken, refreshToken *RefreshToken)
+}
+
+/*
+RenewTokens keeps a token pair fresh for a long-lived connection, such as a
+server-sent event stream or a long poll, that will not make another request
+for VerifyAuthorization to refresh on. It refreshes shortly before each
+access token expires and hands the new pair to options.OnRenew.
+
+RenewTokens blocks until ctx is done, returning ctx.Err(), or until a refresh
+fails, returning that error; the connection should then be treated as signed
+out. Run it in its own goroutine:
+
+	go func() {
+	    err := authClient.RenewTokens(ctx, accessToken, refreshToken, client.RenewOptions{
+	        OnRenew: func(a *client.AccessToken, _ *client.RefreshToken) { stream.SetToken(a) },
+	    })
+	    if !errors.Is(err, context.Canceled) {
+	        stream.Close()
+	    }
+	}()
+*/
+func (c *Client) RenewTokens(
+	ctx context.Context,
+	accessToken *AccessToken,
+	refreshToken *RefreshToken,
+	options RenewOptions,
+) error {
+	before := options.Before
+	if before <= 0 {
+		before = DefaultRenewBefore
+	}
+
+	for {
+		timer := time.NewTimer(max(time.Until(accessToken.Expiration().Add(-before)), 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		nextAccess, nextRefresh, err := c.refreshTokens(requestid.New(), refreshToken.Encoded())
+		if err != nil {
+			c.log(LogLevelInfo, "renew tokens: %v\n", err)
+			return fmt.Errorf("failed to renew tokens: %w", err)
+		}
+		accessToken, refreshToken = nextAccess, nextRefresh
+		if options.OnRenew != nil {
+			options.OnRenew(accessToken, refreshToken)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// refreshingServer answers refreshes with a fresh pair from issuer, and
+// returns a Client that trusts it along with a way to issue test tokens.
+func refreshingServer(
+	t *testing.T,
+) (
+	*Client,
+	func(time.Duration) (*AccessToken, *RefreshToken),
+) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{SigningKey: key, IssuerDomain: "consent.test"})
+	issue := func(lifetime time.Duration) (*AccessToken, *RefreshToken) {
+		accessToken, err := issuer.IssueAccessToken("alice", []string{"app.test"}, nil, lifetime)
+		if err != nil {
+			t.Errorf("IssueAccessToken failed: %v", err)
+		}
+		refreshToken, err := issuer.IssueRefreshToken("alice", []string{"app.test"}, nil, time.Hour)
+		if err != nil {
+			t.Errorf("IssueRefreshToken failed: %v", err)
+		}
+		return accessToken, refreshToken
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken, refreshToken := issue(time.Hour)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": api.RefreshResponse{
+			AccessToken:  accessToken.Encoded(),
+			RefreshToken: refreshToken.Encoded(),
+		}})
+	}))
+	t.Cleanup(server.Close)
+
+	c := Init(tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "consent.test",
+		ValidAudience:   "app.test",
+	}), server.URL)
+	return c, issue
+}
+
+func TestRenewTokens_RefreshesBeforeExpiry(t *testing.T) {
+	c, issue := refreshingServer(t)
+
+	// a token inside the renewal window is refreshed straight away
+	accessToken, refreshToken := issue(30 * time.Second)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	var renewed *AccessToken
+	err := c.RenewTokens(ctx, accessToken, refreshToken, RenewOptions{
+		OnRenew: func(accessToken *AccessToken, _ *RefreshToken) {
+			renewed = accessToken
+			cancel()
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RenewTokens = %v, want context.Canceled", err)
+	}
+	if renewed == nil || !renewed.Expiration().After(accessToken.Expiration()) {
+		t.Errorf("renewed token = %v, want one expiring later", renewed)
+	}
+}
+
+func TestRenewTokens_StopsWhenRefreshFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+
+	accessToken, refreshToken := issueTestTokens(t, "alice", "app.test")
+	c := Init(nil, server.URL)
+	c.SetLogLevel(LogLevelNone)
+
+	err := c.RenewTokens(t.Context(), accessToken, refreshToken, RenewOptions{Before: 2 * time.Hour})
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("RenewTokens = %v, want the refresh failure", err)
+	}
+}
@@ -0,0 +1,51 @@
+// Package server embeds the consent identity server in another Go program.
+//
+// The consent binary assembles storage, token signing, the service layer,
+// the web app, and the API from files on disk. This package performs the
+// same assembly from a Config value, so a program can serve consent from its
+// own binary instead of running cmd/consent alongside it.
+//
+// # Quick Start
+//
+//	import (
+//	    "net/http"
+//
+//	    "git.sr.ht/~jakintosh/consent/pkg/server"
+//	)
+//
+//	srv, err := server.New(server.Config{
+//	    PublicURL:       "https://consent.example.com",
+//	    AuthorityDomain: "consent.example.com",
+//	    DatabasePath:    "/var/lib/myapp/consent.db",
+//	    SigningKey:      signingKey,      // *ecdsa.PrivateKey (P-256)
+//	    BootstrapAPIKey: bootstrapAPIKey, // seeds the database on startup
+//	})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer srv.Close()
+//
+//	log.Fatal(http.ListenAndServe(":9001", srv))
+//
+// The server's routes are absolute (the web app at / and the API under
+// /api/v1), so mount it at the root of its own listener or host rather than
+// under a path prefix. Stop serving requests before calling Close.
+//
+// Integrations verify tokens with the public half of SigningKey, exactly as
+// with a standalone consent server; see the client and tokens packages. To
+// keep the key in hardware, set Signer to a crypto.Signer for it instead.
+//
+// # Events
+//
+// The host program can react to identity events, such as logins and new
+// accounts, by subscribing to them instead of polling the database. These are
+// the same events a standalone server sends to webhooks:
+//
+//	unsubscribe := srv.Subscribe(server.EventLogin, func(e server.Event) {
+//	    log.Printf("%s signed in from %s", e.Handle, e.IP)
+//	})
+//	defer unsubscribe()
+//
+// Handlers run on the request that caused the event, so hand slow work off
+// to another goroutine.
+package server
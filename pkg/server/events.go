@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// EventType names an identity event. The values match the event types sent
+// to webhooks.
+type EventType string
+
+const (
+	EventUserRegistered EventType = EventType(service.EventUserRegistered)
+	EventUserDeleted    EventType = EventType(service.EventUserDeleted)
+	EventTokenRevoked   EventType = EventType(service.EventTokenRevoked)
+	EventLogin          EventType = EventType(service.EventLoginSucceeded)
+	EventLoginFailed    EventType = EventType(service.EventLoginFailed)
+)
+
+// Event is something that happened to an identity. Subject is empty when the
+// account is unknown, as for a failed login with an unregistered handle;
+// Handle and Integration are set when the server knows them, and IP is the
+// client address of the request that caused the event.
+type Event struct {
+	ID          string
+	Type        EventType
+	Time        time.Time
+	Subject     string
+	Handle      string
+	Integration string
+	IP          string
+}
+
+// Subscribe calls handler with every event of eventType until the returned
+// function is called. Handlers run on the request that caused the event,
+// before its response is written, so they should return quickly and hand
+// slow work to another goroutine.
+func (s *Server) Subscribe(
+	eventType EventType,
+	handler func(Event),
+) (
+	unsubscribe func(),
+) {
+	return s.events.subscribe(eventType, handler)
+}
+
+// eventBus fans service events out to subscribers.
+type eventBus struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[EventType]map[int]func(Event)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{handlers: make(map[EventType]map[int]func(Event))}
+}
+
+func (b *eventBus) subscribe(
+	eventType EventType,
+	handler func(Event),
+) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	if b.handlers[eventType] == nil {
+		b.handlers[eventType] = make(map[int]func(Event))
+	}
+	b.handlers[eventType][id] = handler
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.handlers[eventType], id)
+		})
+	}
+}
+
+// publish is the service's event hook.
+func (b *eventBus) publish(
+	_ context.Context,
+	event service.Event,
+) {
+	eventType := EventType(event.Type)
+	b.mu.RLock()
+	handlers := make([]func(Event), 0, len(b.handlers[eventType]))
+	for _, handler := range b.handlers[eventType] {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	published := Event{
+		ID:          event.ID,
+		Type:        eventType,
+		Time:        event.Time,
+		Subject:     event.Subject,
+		Handle:      event.Handle,
+		Integration: event.Integration,
+		IP:          event.Client.IP,
+	}
+	for _, handler := range handlers {
+		handler(published)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+
+	"git.sr.ht/~jakintosh/consent/internal/service"
+)
+
+// Mail is a plain text message to a user.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers the server's mail to users, such as notices of sign-ins
+// from new devices. Send runs on its own goroutine, after the request that
+// caused it has been answered.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// serviceMailer adapts a Mailer to the service's.
+type serviceMailer struct {
+	mailer Mailer
+}
+
+func (m serviceMailer) Send(
+	ctx context.Context,
+	mail service.Mail,
+) error {
+	return m.mailer.Send(ctx, Mail(mail))
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/accesslog"
+	"git.sr.ht/~jakintosh/consent/internal/config"
+	internalserver "git.sr.ht/~jakintosh/consent/internal/server"
+	"git.sr.ht/~jakintosh/consent/internal/service"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// Config assembles an embedded consent server. PublicURL, AuthorityDomain,
+// DatabasePath, and SigningKey or Signer are required; zero values elsewhere use the
+// same defaults as the consent binary.
+type Config struct {
+	// PublicURL is where users' browsers reach the server, such as
+	// "https://consent.example.com".
+	PublicURL string
+
+	// AuthorityDomain is the issuer of every token the server signs.
+	AuthorityDomain string
+
+	// DatabasePath is the SQLite database file, created if missing. It is
+	// opened in WAL mode; DatabaseBusyTimeout and DatabaseMaxOpenConns tune
+	// lock waits and the connection pool, and DatabaseQueryTimeout bounds
+	// each query a request makes.
+	DatabasePath         string
+	DatabaseBusyTimeout  time.Duration
+	DatabaseMaxOpenConns int
+	DatabaseQueryTimeout time.Duration
+
+	// SigningKey signs tokens. Integrations verify them with its public key.
+	SigningKey *ecdsa.PrivateKey
+
+	// Signer, when set, signs tokens in place of SigningKey, so the key can
+	// stay in an HSM, TPM, KMS, or hardware token. Its public key must be
+	// P-256 ECDSA.
+	Signer tokens.Signer
+
+	// SignWorkers, when positive, bounds how many tokens are signed at
+	// once, and SignQueue how many more may wait; past that, issuing fails
+	// with 503 until signing catches up.
+	SignWorkers int
+	SignQueue   int
+
+	// PasswordCost is the bcrypt cost of new password hashes, from 10 (the
+	// default) to 31. PasswordWorkers bounds how many passwords are hashed
+	// or checked at once; zero uses one per CPU.
+	PasswordCost    int
+	PasswordWorkers int
+
+	// TermsVersion, when set, asks users to accept that version of the
+	// terms of service, read at TermsURL, before apps are issued tokens for
+	// them. Access tokens carry the accepted version as terms_version.
+	TermsVersion string
+	TermsURL     string
+
+	// BootstrapAPIKey, when set, seeds the database on startup with the
+	// system integration, the admin role, and this admin API key, as
+	// `consent init` does. Seeding is safe to repeat.
+	BootstrapAPIKey string
+
+	// DevMode replaces the login flow for consent's own pages with local
+	// dev login, for development only.
+	DevMode bool
+
+	// Maintenance starts the server in read-only maintenance mode, where
+	// sign-ins and refreshes work but registrations and edits are refused
+	// until an administrator turns it off through the admin API.
+	Maintenance bool
+
+	// InsecureCookies emits auth cookies without Secure, for plain-HTTP
+	// localhost development only.
+	InsecureCookies bool
+
+	// Deployment-wide lifetimes; integration policies may override them.
+	AuthCodeLifetime     time.Duration
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+
+	// CORSAllowedOrigins and CORSIntegrationOrigins let browser apps on
+	// other origins call the API with credentials.
+	CORSAllowedOrigins     []string
+	CORSIntegrationOrigins bool
+
+	// TemplatesPath is an optional directory of page templates that
+	// override the built-in ones by file name.
+	TemplatesPath string
+
+	// StaticTemplates reads TemplatesPath and LocalesPath only at startup
+	// and on Reload, rather than checking for edits on every render.
+	StaticTemplates bool
+
+	// BrandingName and BrandingOrganization replace "Consent" and
+	// "Pollinator Network" on the server's pages.
+	BrandingName         string
+	BrandingOrganization string
+
+	// Locale is the default page language, e.g. "es"; browsers asking for
+	// another supported language get it instead. LocalesPath is an optional
+	// directory of <locale>.json message catalogs.
+	Locale      string
+	LocalesPath string
+
+	// AccessLog receives one line per request, in AccessLogFormat ("common",
+	// the default, or "json"). Nil disables request logging.
+	AccessLog       io.Writer
+	AccessLogFormat string
+
+	// Mailer, when set, emails users with a profile address when they sign
+	// in with their secret from a device they haven't used before, with a
+	// link that signs out every device that signed in since. Nil sends no
+	// mail.
+	Mailer Mailer
+}
+
+// Server is an embedded consent server. It is an http.Handler.
+type Server struct {
+	server *internalserver.Server
+	events *eventBus
+}
+
+// New validates cfg, opens the database, and assembles the server.
+func New(
+	cfg Config,
+) (
+	*Server,
+	error,
+) {
+	if strings.TrimSpace(cfg.DatabasePath) == "" {
+		return nil, errors.New("server: database path required")
+	}
+	if cfg.SigningKey == nil && cfg.Signer == nil {
+		return nil, errors.New("server: signing key required")
+	}
+
+	resolved := config.Default()
+	resolved.Server.PublicURL = cfg.PublicURL
+	resolved.Server.AuthorityDomain = cfg.AuthorityDomain
+	resolved.Server.DevMode = cfg.DevMode
+	resolved.Server.Maintenance = cfg.Maintenance
+	resolved.Storage.BusyTimeout = cfg.DatabaseBusyTimeout
+	resolved.Storage.MaxOpenConns = cfg.DatabaseMaxOpenConns
+	resolved.Storage.QueryTimeout = cfg.DatabaseQueryTimeout
+	resolved.Signing.Workers = cfg.SignWorkers
+	resolved.Signing.Queue = cfg.SignQueue
+	resolved.Passwords = config.PasswordsConfig{
+		Cost:    cfg.PasswordCost,
+		Workers: cfg.PasswordWorkers,
+	}
+	resolved.Terms = config.TermsConfig{
+		Version: cfg.TermsVersion,
+		URL:     cfg.TermsURL,
+	}
+	resolved.Server.TemplatesPath = cfg.TemplatesPath
+	resolved.Server.StaticTemplates = cfg.StaticTemplates
+	resolved.Server.Branding = config.BrandingConfig{
+		Name:         cfg.BrandingName,
+		Organization: cfg.BrandingOrganization,
+	}
+	resolved.Server.Locale = cfg.Locale
+	resolved.Server.LocalesPath = cfg.LocalesPath
+	resolved.Server.AuthCodeLifetime = cfg.AuthCodeLifetime
+	resolved.Server.AccessTokenLifetime = cfg.AccessTokenLifetime
+	resolved.Server.RefreshTokenLifetime = cfg.RefreshTokenLifetime
+	resolved.Server.CORS = config.CORSConfig{
+		AllowedOrigins:     cfg.CORSAllowedOrigins,
+		IntegrationOrigins: cfg.CORSIntegrationOrigins,
+	}
+	resolved.Server.AccessLog = string(accesslog.FormatOff)
+	if cfg.AccessLog != nil {
+		resolved.Server.AccessLog = cfg.AccessLogFormat
+		if resolved.Server.AccessLog == "" {
+			resolved.Server.AccessLog = string(accesslog.FormatCommon)
+		}
+	}
+	resolved.Normalize()
+	if err := resolved.Validate(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+
+	runtimeServer, err := config.ResolveServer(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+
+	events := newEventBus()
+	var mailer service.Mailer
+	if cfg.Mailer != nil {
+		mailer = serviceMailer{mailer: cfg.Mailer}
+	}
+	srv, err := internalserver.New(internalserver.Options{
+		Runtime: config.Runtime{
+			Config: resolved,
+			Paths: config.Paths{
+				DatabaseFile: cfg.DatabasePath,
+			},
+			Server: runtimeServer,
+			Secrets: config.RuntimeSecrets{
+				SigningKey:      cfg.SigningKey,
+				Signer:          cfg.Signer,
+				BootstrapAPIKey: cfg.BootstrapAPIKey,
+			},
+		},
+		InsecureCookies: cfg.InsecureCookies,
+		PasswordMode:    service.PasswordModeProduction,
+		AccessLog:       cfg.AccessLog,
+		InitializeStore: cfg.BootstrapAPIKey != "",
+		OnEvent:         events.publish,
+		Mailer:          mailer,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+
+	return &Server{server: srv, events: events}, nil
+}
+
+// ServeHTTP serves the web app at / and the API under /api/v1.
+func (s *Server) ServeHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	s.server.Handler().ServeHTTP(w, r)
+}
+
+// Reload re-reads TemplatesPath and LocalesPath. Pages that fail to parse
+// are reported, while everything else that loaded takes effect.
+func (s *Server) Reload() error {
+	return s.server.Reload()
+}
+
+// Close releases the database.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,160 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/pkg/server"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+const testBootstrapKey = "test.0123456789abcdef"
+
+func testConfig(t *testing.T) server.Config {
+	t.Helper()
+	return server.Config{
+		PublicURL:       "http://localhost:9001",
+		AuthorityDomain: "consent.test",
+		DatabasePath:    filepath.Join(t.TempDir(), "consent.db"),
+		SigningKey:      consenttesting.SharedTestKey(),
+		BootstrapAPIKey: testBootstrapKey,
+		InsecureCookies: true,
+	}
+}
+
+func TestNew_ServesAppAndAPI(t *testing.T) {
+	t.Parallel()
+	srv, err := server.New(testConfig(t))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /login status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// the bootstrap key seeded the store, including the system integration
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/integrations", nil)
+	req.Header.Set("Authorization", "Bearer "+testBootstrapKey)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET integrations status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"consent"`) {
+		t.Errorf("integrations = %s, want system integration", rec.Body)
+	}
+}
+
+func TestNew_ReopensSeededStore(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+
+	for range 2 {
+		srv, err := server.New(cfg)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if err := srv.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+}
+
+func TestNew_RejectsInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]func(*server.Config){
+		"missing database":    func(c *server.Config) { c.DatabasePath = "" },
+		"missing signing key": func(c *server.Config) { c.SigningKey = nil },
+		"missing public url":  func(c *server.Config) { c.PublicURL = "" },
+		"relative public url": func(c *server.Config) { c.PublicURL = "consent.test" },
+		"missing authority":   func(c *server.Config) { c.AuthorityDomain = "" },
+		"bad log format":      func(c *server.Config) { c.AccessLog, c.AccessLogFormat = &strings.Builder{}, "xml" },
+		"p384 signer":         func(c *server.Config) { c.SigningKey, c.Signer = nil, p384Key(t) },
+	}
+	for name, mutate := range cases {
+		cfg := testConfig(t)
+		mutate(&cfg)
+		srv, err := server.New(cfg)
+		if err == nil {
+			_ = srv.Close()
+			t.Errorf("%s: New() = nil error, want error", name)
+		}
+	}
+}
+
+func TestNew_AcceptsSigner(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	cfg.SigningKey, cfg.Signer = nil, consenttesting.SharedTestKey()
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	_ = srv.Close()
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+	srv, err := server.New(testConfig(t))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	var registered, logins []server.Event
+	srv.Subscribe(server.EventUserRegistered, func(e server.Event) { registered = append(registered, e) })
+	unsubscribe := srv.Subscribe(server.EventLogin, func(e server.Event) { logins = append(logins, e) })
+
+	// creating a user through the API is heard
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users", strings.NewReader(`{"username":"alice","password":"password123"}`))
+	req.Header.Set("Authorization", "Bearer "+testBootstrapKey)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+		t.Fatalf("POST users status = %d: %s", rec.Code, rec.Body)
+	}
+	if len(registered) != 1 || registered[0].Handle != "alice" || registered[0].Subject == "" {
+		t.Fatalf("registered events = %+v, want alice", registered)
+	}
+
+	login := func() {
+		form := url.Values{"handle": {"alice"}, "secret": {"password123"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// logging in is heard until unsubscribed
+	login()
+	if len(logins) != 1 || logins[0].Subject != registered[0].Subject || logins[0].Type != server.EventLogin {
+		t.Fatalf("login events = %+v, want one for alice", logins)
+	}
+	unsubscribe()
+	login()
+	if len(logins) != 1 {
+		t.Errorf("got %d login events after unsubscribing, want 1", len(logins))
+	}
+}
+
+func p384Key(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return key
+}
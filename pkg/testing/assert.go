@@ -0,0 +1,74 @@
+package testing
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// IssuedTokens decodes the access and refresh token cookies a handler set on
+// rr, such as after a login or refresh. A token is nil if its cookie wasn't
+// set, was cleared, or doesn't parse. The tokens are not verified; use them
+// to inspect the subject and expiry a handler issued.
+func IssuedTokens(
+	rr *httptest.ResponseRecorder,
+) (
+	access *tokens.UnverifiedToken,
+	refresh *tokens.UnverifiedToken,
+) {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			continue
+		}
+		token, err := tokens.ParseUnverified(cookie.Value)
+		if err != nil {
+			continue
+		}
+		switch cookie.Name {
+		case accessTokenCookieName:
+			access = token
+		case refreshTokenCookieName:
+			refresh = token
+		}
+	}
+	return access, refresh
+}
+
+// AssertAuthenticatedAs fails t unless the handler that wrote rr set new
+// access and refresh token cookies for subject.
+func AssertAuthenticatedAs(
+	t testing.TB,
+	rr *httptest.ResponseRecorder,
+	subject string,
+) {
+	t.Helper()
+	access, refresh := IssuedTokens(rr)
+	if access == nil || refresh == nil {
+		t.Fatalf("response set no token cookies, want tokens for %q", subject)
+	}
+	if access.Subject() != subject {
+		t.Fatalf("access token subject = %q, want %q", access.Subject(), subject)
+	}
+	if refresh.Subject() != subject {
+		t.Fatalf("refresh token subject = %q, want %q", refresh.Subject(), subject)
+	}
+}
+
+// AssertCookiesCleared fails t unless the handler that wrote rr cleared both
+// token cookies, as logout does.
+func AssertCookiesCleared(
+	t testing.TB,
+	rr *httptest.ResponseRecorder,
+) {
+	t.Helper()
+	cleared := map[string]bool{}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			cleared[cookie.Name] = true
+		}
+	}
+	if !cleared[accessTokenCookieName] || !cleared[refreshTokenCookieName] {
+		t.Fatalf("response did not clear both token cookies")
+	}
+}
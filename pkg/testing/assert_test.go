@@ -0,0 +1,42 @@
+package testing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAssertAuthenticatedAs_AfterRefresh(t *testing.T) {
+	tv := NewTestVerifier("consent.test", "app.test")
+	tv.SimulateFailures(FailExpiredAccess)
+
+	req, err := tv.AuthenticatedRequest(http.MethodGet, "/", "bob")
+	if err != nil {
+		t.Fatalf("AuthenticatedRequest failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	if _, err := tv.VerifyAuthorization(rr, req); err != nil {
+		t.Fatalf("VerifyAuthorization failed: %v", err)
+	}
+
+	AssertAuthenticatedAs(t, rr, "bob")
+	access, refresh := IssuedTokens(rr)
+	if until := time.Until(access.Expiration()); until <= 0 || until > defaultAccessTokenLifetime {
+		t.Fatalf("access token expires in %v, want within %v", until, defaultAccessTokenLifetime)
+	}
+	if !refresh.Expiration().After(access.Expiration()) {
+		t.Fatal("refresh token expires before the access token")
+	}
+}
+
+func TestIssuedTokens_IgnoresClearedCookies(t *testing.T) {
+	tv := NewTestVerifier("consent.test", "app.test")
+	rr := httptest.NewRecorder()
+	tv.HandleDevLogout()(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	AssertCookiesCleared(t, rr)
+	if access, refresh := IssuedTokens(rr); access != nil || refresh != nil {
+		t.Fatal("IssuedTokens returned tokens from cleared cookies")
+	}
+}
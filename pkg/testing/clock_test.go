@@ -0,0 +1,51 @@
+package testing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestUseClock_AdvancingExpiresTokens(t *testing.T) {
+	env := NewTestEnv("consent.test", "app.test")
+	clock := NewFakeClock(time.Now())
+	env.UseClock(clock)
+	tv := NewTestVerifierWithEnv(env)
+
+	accessToken, err := env.IssueAccessToken(DefaultTestSubject, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	refreshToken, err := env.IssueRefreshToken(DefaultTestSubject, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	decoded := new(tokens.AccessToken)
+	if err := decoded.Decode(accessToken.Encoded(), env.Validator); !errors.Is(err, tokens.ErrTokenExpired()) {
+		t.Fatalf("Decode err = %v, want ErrTokenExpired", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	env.AddAuthCookies(req, accessToken, refreshToken)
+	rr := httptest.NewRecorder()
+	refreshed, err := tv.VerifyAuthorization(rr, req)
+	if err != nil {
+		t.Fatalf("VerifyAuthorization failed: %v", err)
+	}
+	if refreshed.IssuedAt().Unix() != clock.Now().Unix() {
+		t.Fatalf("refreshed IssuedAt = %v, want %v", refreshed.IssuedAt(), clock.Now())
+	}
+
+	clock.Advance(2 * time.Hour)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	env.AddAuthCookies(req, accessToken, refreshToken)
+	if _, err := tv.VerifyAuthorization(httptest.NewRecorder(), req); err == nil {
+		t.Fatal("VerifyAuthorization accepted an expired refresh token")
+	}
+}
@@ -0,0 +1,279 @@
+// Package conformance checks that an app wires consent authentication
+// correctly. Run drives the app's router through a table of canned
+// scenarios, such as an expired access token, a replayed refresh token, a
+// revoked session, and a missing CSRF secret, against a fake consent server,
+// and fails the test for every edge case the app gets wrong:
+//
+//	func TestConsentConformance(t *testing.T) {
+//	    conformance.Run(t, conformance.Config{
+//	        NewApp: func(verifier client.Verifier) http.Handler {
+//	            return myapp.NewRouter(verifier)
+//	        },
+//	        ProtectedPath: "/api/profile",
+//	        MutatingPath:  "/api/settings",
+//	    })
+//	}
+//
+// By default the app gets a real *client.Client pointed at the fake server.
+// Config.NewVerifier substitutes any other client.Verifier.
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+// Default issuer domain and audience of the fake consent server.
+const (
+	DefaultDomain   = "consent.test"
+	DefaultAudience = "app.test"
+)
+
+// refreshTokenCookieName is the cookie client.Client and
+// testing.TestVerifier keep the refresh token in.
+const refreshTokenCookieName = "refreshToken"
+
+// Config describes the app under test.
+type Config struct {
+	// Domain and Audience configure the fake consent server. Empty values
+	// use DefaultDomain and DefaultAudience.
+	Domain   string
+	Audience string
+
+	// NewVerifier builds the verifier the app is given, for the fake server
+	// served at serverURL. Nil uses a *client.Client, which is what apps
+	// run in production.
+	NewVerifier func(fake *consenttesting.FakeServer, serverURL string) client.Verifier
+
+	// NewApp builds the app's router around verifier. Required.
+	NewApp func(verifier client.Verifier) http.Handler
+
+	// ProtectedPath is a GET route that answers 2xx to signed-in users and
+	// refuses everyone else. Required.
+	ProtectedPath string
+
+	// MutatingPath is a POST route that checks the CSRF secret, read with
+	// client.CSRFFromRequest, using VerifyAuthorizationCheckCSRF. CSRF
+	// scenarios are skipped when it is empty.
+	MutatingPath string
+
+	// LocalRefresh marks a verifier that refreshes tokens without asking
+	// the consent server, like testing.TestVerifier. It can't know about
+	// rotated or revoked sessions, so those scenarios are skipped.
+	LocalRefresh bool
+}
+
+// Scenario is one canned authentication edge case. Remote scenarios need a
+// verifier that refreshes through the consent server; CSRF scenarios need
+// Config.MutatingPath.
+type Scenario struct {
+	Name        string
+	Description string
+	Remote      bool
+	CSRF        bool
+
+	run func(t *testing.T, h *harness)
+}
+
+// Scenarios returns the table Run works through, in order.
+func Scenarios() []Scenario {
+	return slices.Clone(scenarios)
+}
+
+// Run runs every scenario against the app described by config, each as a
+// subtest with its own fake consent server.
+func Run(
+	t *testing.T,
+	config Config,
+) {
+	t.Helper()
+	if config.NewApp == nil || config.ProtectedPath == "" {
+		t.Fatal("conformance: Config.NewApp and Config.ProtectedPath are required")
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			switch {
+			case scenario.Remote && config.LocalRefresh:
+				t.Skip("verifier refreshes locally, so it can't see server-side session state")
+			case scenario.CSRF && config.MutatingPath == "":
+				t.Skip("no MutatingPath configured")
+			}
+			scenario.run(t, newHarness(t, config))
+		})
+	}
+}
+
+// harness is one scenario's app, fake consent server, and clock.
+type harness struct {
+	config Config
+	fake   *consenttesting.FakeServer
+	env    *consenttesting.TestEnv
+	clock  *consenttesting.FakeClock
+	app    http.Handler
+}
+
+func newHarness(
+	t *testing.T,
+	config Config,
+) *harness {
+	t.Helper()
+	domain, audience := config.Domain, config.Audience
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	if audience == "" {
+		audience = DefaultAudience
+	}
+
+	env := consenttesting.NewTestEnv(domain, audience)
+	clock := consenttesting.NewFakeClock(time.Now())
+	env.UseClock(clock)
+	fake := consenttesting.NewFakeServerWithEnv(env)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	var verifier client.Verifier
+	if config.NewVerifier != nil {
+		verifier = config.NewVerifier(fake, server.URL)
+	} else {
+		c := client.Init(env.Validator, server.URL)
+		c.SetLogLevel(client.LogLevelNone)
+		verifier = c
+	}
+
+	return &harness{
+		config: config,
+		fake:   fake,
+		env:    env,
+		clock:  clock,
+		app:    config.NewApp(verifier),
+	}
+}
+
+// session is a signed-in browser's token cookies.
+type session struct {
+	access  *consenttesting.AccessToken
+	refresh *consenttesting.RefreshToken
+}
+
+// login returns a fresh session, as a completed login would leave it.
+func (h *harness) login(
+	t *testing.T,
+) session {
+	t.Helper()
+	access, refresh, err := h.env.IssueTokenPair(consenttesting.DefaultTestSubject)
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+	return session{access: access, refresh: refresh}
+}
+
+// revoke signs the session out at the consent server, as logging out on
+// another device would.
+func (h *harness) revoke(
+	t *testing.T,
+	s session,
+) {
+	t.Helper()
+	body, err := json.Marshal(api.LogoutRequest{RefreshToken: s.refresh.Encoded()})
+	if err != nil {
+		t.Fatalf("failed to encode logout: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.fake.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logout at the fake server: status %d", rec.Code)
+	}
+}
+
+// get requests the protected route with the session's cookies.
+func (h *harness) get(
+	s *session,
+) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, h.config.ProtectedPath, nil)
+	return h.serve(req, s)
+}
+
+// post requests the mutating route with the session's cookies and csrf in
+// the Consent-CSRF header.
+func (h *harness) post(
+	s *session,
+	csrf string,
+) *http.Response {
+	req := httptest.NewRequest(http.MethodPost, h.config.MutatingPath, nil)
+	if csrf != "" {
+		req.Header.Set(client.CSRFHeader, csrf)
+	}
+	return h.serve(req, s)
+}
+
+func (h *harness) serve(
+	req *http.Request,
+	s *session,
+) *http.Response {
+	if s != nil {
+		h.env.AddAuthCookies(req, s.access, s.refresh)
+	}
+	rec := httptest.NewRecorder()
+	h.app.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+// expireAccess moves the clock past the access token's lifetime but not the
+// refresh token's.
+func (h *harness) expireAccess() {
+	h.clock.Advance(time.Hour)
+}
+
+// expectAllowed fails unless the app served res successfully.
+func expectAllowed(
+	t *testing.T,
+	res *http.Response,
+	what string,
+) {
+	t.Helper()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Errorf("%s: status %d, want 2xx", what, res.StatusCode)
+	}
+}
+
+// expectDenied fails unless the app refused res with a redirect or a client
+// error. Server errors count as failures: the app should handle these cases,
+// not crash on them.
+func expectDenied(
+	t *testing.T,
+	res *http.Response,
+	what string,
+) {
+	t.Helper()
+	if res.StatusCode < 300 || res.StatusCode > 499 {
+		t.Errorf("%s: status %d, want a 3xx redirect or 4xx refusal", what, res.StatusCode)
+	}
+}
+
+// expectRefreshed fails unless res replaced the refresh token cookie, so the
+// browser keeps the tokens the verifier refreshed to.
+func expectRefreshed(
+	t *testing.T,
+	res *http.Response,
+	old *consenttesting.RefreshToken,
+) {
+	t.Helper()
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == refreshTokenCookieName && cookie.Value != "" && cookie.Value != old.Encoded() {
+			return
+		}
+	}
+	t.Error("refreshed tokens weren't set as cookies; pass the handler's ResponseWriter to the verifier")
+}
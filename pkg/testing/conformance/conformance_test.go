@@ -0,0 +1,62 @@
+package conformance_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+	"git.sr.ht/~jakintosh/consent/pkg/testing/conformance"
+)
+
+// newApp wires verifier the way the integration guide recommends.
+func newApp(
+	verifier client.Verifier,
+) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /profile", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := verifier.VerifyAuthorization(w, r); err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("POST /settings", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := verifier.VerifyAuthorizationCheckCSRF(w, r, client.CSRFFromRequest(r)); err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func TestRun_Client(t *testing.T) {
+	conformance.Run(t, conformance.Config{
+		NewApp:        newApp,
+		ProtectedPath: "/profile",
+		MutatingPath:  "/settings",
+	})
+}
+
+func TestRun_TestVerifier(t *testing.T) {
+	conformance.Run(t, conformance.Config{
+		NewVerifier: func(fake *consenttesting.FakeServer, _ string) client.Verifier {
+			return consenttesting.NewTestVerifierWithEnv(fake.TestEnv())
+		},
+		NewApp:        newApp,
+		ProtectedPath: "/profile",
+		MutatingPath:  "/settings",
+		LocalRefresh:  true,
+	})
+}
+
+func TestScenarios_Named(t *testing.T) {
+	seen := map[string]bool{}
+	for _, scenario := range conformance.Scenarios() {
+		if scenario.Name == "" || scenario.Description == "" || seen[scenario.Name] {
+			t.Errorf("scenario %q needs a unique name and a description", scenario.Name)
+		}
+		seen[scenario.Name] = true
+	}
+}
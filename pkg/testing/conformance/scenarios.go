@@ -0,0 +1,123 @@
+package conformance
+
+import (
+	"testing"
+	"time"
+
+	consenttesting "git.sr.ht/~jakintosh/consent/pkg/testing"
+)
+
+var scenarios = []Scenario{
+	{
+		Name:        "signed-in",
+		Description: "A fresh session is let in.",
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			expectAllowed(t, h.get(&s), "GET with a fresh session")
+		},
+	},
+	{
+		Name:        "signed-out",
+		Description: "A request without token cookies is refused.",
+		run: func(t *testing.T, h *harness) {
+			expectDenied(t, h.get(nil), "GET without cookies")
+		},
+	},
+	{
+		Name:        "expired-access-token",
+		Description: "An expired access token is refreshed with the refresh token, and the new tokens are set as cookies.",
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			h.expireAccess()
+			res := h.get(&s)
+			expectAllowed(t, res, "GET with an expired access token")
+			expectRefreshed(t, res, s.refresh)
+		},
+	},
+	{
+		Name:        "expired-session",
+		Description: "A session whose refresh token has expired too is refused.",
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			h.clock.Advance(48 * time.Hour)
+			expectDenied(t, h.get(&s), "GET with expired tokens")
+		},
+	},
+	{
+		Name:        "forged-tokens",
+		Description: "Tokens signed by a key other than the consent server's are refused.",
+		run: func(t *testing.T, h *harness) {
+			key, err := consenttesting.GenerateTestKey()
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			forger := consenttesting.NewTestEnvWithKey(key, h.env.Domain, h.env.Audience)
+			access, refresh, err := forger.IssueTokenPair(consenttesting.DefaultTestSubject)
+			if err != nil {
+				t.Fatalf("failed to issue tokens: %v", err)
+			}
+			expectDenied(t, h.get(&session{access: access, refresh: refresh}), "GET with forged tokens")
+		},
+	},
+	{
+		Name:        "rotated-refresh-token",
+		Description: "A refresh token already redeemed for new tokens is refused when replayed.",
+		Remote:      true,
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			h.expireAccess()
+			expectAllowed(t, h.get(&s), "GET that refreshes the session")
+			expectDenied(t, h.get(&s), "GET replaying the redeemed refresh token")
+		},
+	},
+	{
+		Name:        "revoked-session",
+		Description: "A session signed out at the consent server is refused once its access token expires.",
+		Remote:      true,
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			h.revoke(t, s)
+			h.expireAccess()
+			expectDenied(t, h.get(&s), "GET with a revoked session")
+		},
+	},
+	{
+		Name:        "csrf-valid",
+		Description: "A state-changing request with the session's CSRF secret is let in.",
+		CSRF:        true,
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			expectAllowed(t, h.post(&s, s.refresh.Secret()), "POST with the CSRF secret")
+		},
+	},
+	{
+		Name:        "csrf-missing",
+		Description: "A state-changing request without a CSRF secret is refused.",
+		CSRF:        true,
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			expectDenied(t, h.post(&s, ""), "POST without a CSRF secret")
+		},
+	},
+	{
+		Name:        "csrf-mismatch",
+		Description: "A state-changing request with another session's CSRF secret is refused.",
+		CSRF:        true,
+		run: func(t *testing.T, h *harness) {
+			s, other := h.login(t), h.login(t)
+			expectDenied(t, h.post(&s, other.refresh.Secret()), "POST with the wrong CSRF secret")
+		},
+	},
+	{
+		Name:        "csrf-expired-access-token",
+		Description: "A state-changing request with an expired access token and the CSRF secret refreshes the session.",
+		CSRF:        true,
+		run: func(t *testing.T, h *harness) {
+			s := h.login(t)
+			h.expireAccess()
+			res := h.post(&s, s.refresh.Secret())
+			expectAllowed(t, res, "POST with an expired access token")
+			expectRefreshed(t, res, s.refresh)
+		},
+	},
+}
@@ -8,6 +8,8 @@
 //   - TestVerifier: A client.Verifier implementation that works locally
 //   - HTTP helpers: Functions to create authenticated test requests
 //   - Dev login handler: A prebuilt handler for local browsing
+//   - FakeServer: An in-memory consent server for client.Client tests
+//   - Recorder: Record and replay of client.Client's HTTP interactions
 //
 // # Basic Usage
 //
@@ -47,6 +49,18 @@
 //	    // Test that your app handles expired tokens correctly...
 //	}
 //
+// # Controlling Time
+//
+// To expire tokens deterministically, install a FakeClock and advance it:
+//
+//	env := testing.NewTestEnv("consent.example.com", "my-app")
+//	clock := testing.NewFakeClock(time.Now())
+//	env.UseClock(clock)
+//
+//	accessToken, _ := env.IssueAccessToken(testing.DefaultTestSubject, time.Minute)
+//	clock.Advance(2 * time.Minute)
+//	// accessToken is now expired for env.Validator and any verifier on env
+//
 // # CSRF Testing
 //
 // To test CSRF-protected endpoints:
@@ -67,6 +81,59 @@
 //	    // Test...
 //	}
 //
+// # Simulating Failures
+//
+// To test how an app handles auth failures, have the TestVerifier fail on
+// demand:
+//
+//	tv.SimulateFailures(testing.FailExpiredAccess | testing.FailRefreshNetwork)
+//	// every request now fails with client.ErrNetworkTokenRefresh
+//
+// FailCSRFMismatch fails every CSRF check, and FailInvalidSignature rejects
+// every token. SimulateFailures(0) restores normal behavior.
+//
+// # Inspecting Issued Cookies
+//
+// To check which user a handler logged in or refreshed, assert on the
+// recorder it wrote to:
+//
+//	rr := httptest.NewRecorder()
+//	handler.ServeHTTP(rr, req)
+//	testing.AssertAuthenticatedAs(t, rr, "alice")
+//
+// IssuedTokens decodes the token cookies for checks on their expiry, and
+// AssertCookiesCleared checks that a logout handler cleared them.
+//
+// # Fake Consent Server
+//
+// To test code that uses a real *client.Client, including its refresh and
+// logout requests, serve a FakeServer over HTTP:
+//
+//	fake := testing.NewFakeServer("consent.example.com", "my-app")
+//	authServer := httptest.NewServer(fake)
+//	defer authServer.Close()
+//
+//	c := client.Init(fake.TestEnv().Validator, authServer.URL)
+//	fake.SetRedirectURL(appServer.URL + "/auth/callback")
+//
+// GET /login on the fake server skips the login form and redirects to the
+// callback with an authorization code for the "subject" query parameter.
+// IssueAuthCode issues one directly, and IsRevoked reports whether a refresh
+// token has been redeemed or logged out.
+//
+// Tests can change the fake server's state mid-test with AddUser,
+// AddService, ExpireAllTokens, and FailNextRefreshes, or over HTTP through
+// the loopback-only control API under ControlPrefix.
+//
+// To test an app's https-only behavior, StartTLS serves the fake server over
+// HTTPS with a fresh self-signed certificate and returns the certificate PEM.
+//
+// # Recording Real Server Interactions
+//
+// A Recorder captures a client.Client's requests to a real consent server
+// into a golden file in ModeRecord and answers them from the file in
+// ModeReplay, with no network. Install it with client.Client.SetHTTPClient.
+//
 // # Development Mode (No Consent Server)
 //
 // For local dev with a browser, you can add a dev-only login handler that
@@ -15,6 +15,9 @@ type TestEnv struct {
 	Domain    string
 	Audience  string
 	Scopes    []string
+
+	key   *ecdsa.PrivateKey
+	clock tokens.Clock
 }
 
 // NewTestEnv creates a test environment with a shared key.
@@ -23,18 +26,7 @@ func NewTestEnv(
 	domain string,
 	audience string,
 ) *TestEnv {
-	opts := tokens.ServerOptions{
-		SigningKey:   SharedTestKey(),
-		IssuerDomain: domain,
-	}
-	issuer, validator := tokens.InitServer(opts)
-	return &TestEnv{
-		Issuer:    issuer,
-		Validator: validator,
-		Domain:    domain,
-		Audience:  audience,
-		Scopes:    nil,
-	}
+	return NewTestEnvWithKey(SharedTestKey(), domain, audience)
 }
 
 // NewTestEnvWithKey creates a test environment with a specific key.
@@ -56,7 +48,31 @@ func NewTestEnvWithKey(
 		Domain:    domain,
 		Audience:  audience,
 		Scopes:    nil,
+		key:       key,
+	}
+}
+
+// UseClock makes the environment issue and validate tokens by clock, usually
+// a FakeClock, replacing Issuer and Validator. Verifiers and fake servers
+// built on the environment follow it too.
+func (env *TestEnv) UseClock(
+	clock tokens.Clock,
+) {
+	opts := tokens.ServerOptions{
+		SigningKey:   env.key,
+		IssuerDomain: env.Domain,
+		Clock:        clock,
 	}
+	env.Issuer, env.Validator = tokens.InitServer(opts)
+	env.clock = clock
+}
+
+// now returns the current time by the environment's clock.
+func (env *TestEnv) now() time.Time {
+	if env.clock == nil {
+		return time.Now()
+	}
+	return env.clock.Now()
 }
 
 // IssueAccessToken creates a valid access token for the test audience.
@@ -81,6 +97,18 @@ func (env *TestEnv) IssueRefreshToken(
 	return env.Issuer.IssueRefreshToken(subject, []string{env.Audience}, env.Scopes, lifetime)
 }
 
+// IssueTokenPair creates an access token and a refresh token for the test
+// audience with the default lifetimes, as a login would.
+func (env *TestEnv) IssueTokenPair(
+	subject string,
+) (
+	*tokens.AccessToken,
+	*tokens.RefreshToken,
+	error,
+) {
+	return env.Issuer.IssueTokenPair(subject, []string{env.Audience}, env.Scopes, defaultAccessTokenLifetime, defaultRefreshTokenLifetime)
+}
+
 // IssueAccessTokenWithAudience creates an access token with custom audiences.
 func (env *TestEnv) IssueAccessTokenWithAudience(
 	subject string,
@@ -112,7 +140,7 @@ func (env *TestEnv) SetTokenCookies(
 	accessToken *AccessToken,
 	refreshToken *RefreshToken,
 ) {
-	setTokenCookies(w, accessToken, refreshToken)
+	setTokenCookies(w, env.now(), accessToken, refreshToken)
 }
 
 // ClearTokenCookies removes the access and refresh token cookies by setting
@@ -0,0 +1,60 @@
+package testing
+
+import (
+	"fmt"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// Failure is a set of failure modes a TestVerifier simulates, so apps can
+// test their error-handling branches deterministically. Combine modes with |.
+type Failure uint
+
+const (
+	// FailExpiredAccess treats every access token as expired, so each
+	// request takes the refresh path.
+	FailExpiredAccess Failure = 1 << iota
+
+	// FailRefreshNetwork makes every refresh fail with
+	// client.ErrNetworkTokenRefresh, as if the consent server were
+	// unreachable. Combine with FailExpiredAccess to fail on every request.
+	FailRefreshNetwork
+
+	// FailCSRFMismatch makes every CSRF check fail with
+	// client.ErrCSRFInvalid.
+	FailCSRFMismatch
+
+	// FailInvalidSignature rejects every access and refresh token as if its
+	// signature were forged, wrapped in client.ErrTokenInvalid.
+	FailInvalidSignature
+)
+
+// SimulateFailures makes the verifier fail in the given ways until it is
+// called again; SimulateFailures(0) restores normal behavior. Set failures
+// before serving requests, not while handlers are running.
+func (tv *TestVerifier) SimulateFailures(
+	failures Failure,
+) {
+	tv.failures = failures
+}
+
+func (tv *TestVerifier) simulates(
+	failure Failure,
+) bool {
+	return tv.failures&failure != 0
+}
+
+// injectedTokenError returns the error a simulated failure reports for a
+// token that would otherwise have been valid.
+func (tv *TestVerifier) injectedTokenError(
+	kind string,
+	allowExpired bool,
+) error {
+	if tv.simulates(FailInvalidSignature) {
+		return fmt.Errorf("%w: %s token (simulated)", tokens.ErrTokenBadSignature(), kind)
+	}
+	if allowExpired && tv.simulates(FailExpiredAccess) {
+		return fmt.Errorf("%w: %s token (simulated)", tokens.ErrTokenExpired(), kind)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package testing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestSimulateFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures Failure
+		wantErr  error
+	}{
+		{"none", 0, nil},
+		{"expired access refreshes", FailExpiredAccess, nil},
+		{"refresh network alone", FailRefreshNetwork, nil},
+		{"refresh network", FailExpiredAccess | FailRefreshNetwork, client.ErrNetworkTokenRefresh},
+		{"csrf mismatch", FailCSRFMismatch, client.ErrCSRFInvalid},
+		{"invalid signature", FailInvalidSignature, tokens.ErrTokenBadSignature()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tv := NewTestVerifier("consent.test", "app.test")
+			tv.SimulateFailures(tt.failures)
+
+			env := tv.TestEnv()
+			accessToken, err := env.IssueAccessToken(DefaultTestSubject, time.Hour)
+			if err != nil {
+				t.Fatalf("IssueAccessToken failed: %v", err)
+			}
+			refreshToken, err := env.IssueRefreshToken(DefaultTestSubject, time.Hour)
+			if err != nil {
+				t.Fatalf("IssueRefreshToken failed: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			env.AddAuthCookies(req, accessToken, refreshToken)
+			rr := httptest.NewRecorder()
+
+			_, _, err = tv.VerifyAuthorizationCheckCSRF(rr, req, refreshToken.Secret())
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("VerifyAuthorizationCheckCSRF failed: %v", err)
+				}
+				refreshed := len(rr.Result().Cookies()) > 0
+				if want := tt.failures&FailExpiredAccess != 0; refreshed != want {
+					t.Fatalf("refreshed = %t, want %t", refreshed, want)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
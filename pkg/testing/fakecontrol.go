@@ -0,0 +1,143 @@
+package testing
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"git.sr.ht/~jakintosh/consent/internal/api"
+)
+
+// ControlPrefix is where a FakeServer serves its control API, which lets
+// black-box tests change its state over HTTP mid-test. Each route is a POST
+// that mirrors a FakeServer method:
+//
+//	POST /__control/users     {"handle": "bob", "subject": "sub-bob"}  AddUser
+//	POST /__control/services  {"name", "audience", "redirect"}         AddService
+//	POST /__control/expire                                             ExpireAllTokens
+//	POST /__control/failures  {"refresh": 1}                           FailNextRefreshes
+//
+// The control API only answers requests from loopback addresses.
+const ControlPrefix = "/__control"
+
+// AddUser registers handle, so GET /login?handle=... issues codes for
+// subject.
+func (fs *FakeServer) AddUser(
+	handle string,
+	subject string,
+) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.users[handle] = subject
+}
+
+// AddService registers an integration, so GET /login?integration=... issues
+// codes that exchange for tokens for its audience.
+func (fs *FakeServer) AddService(
+	service FakeService,
+) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.services[service.Name] = service
+}
+
+// ExpireAllTokens revokes every refresh token issued so far, so the next
+// refresh for any of them fails and the app has to log in again. Access
+// tokens are validated by apps without the server; advance a FakeClock
+// installed with TestEnv.UseClock to expire those.
+func (fs *FakeServer) ExpireAllTokens() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for token := range fs.issued {
+		fs.revoked[token] = true
+	}
+}
+
+// FailNextRefreshes makes the next n refresh requests fail with a 500, as if
+// the server were down. Failed refreshes don't redeem their token.
+func (fs *FakeServer) FailNextRefreshes(
+	n int,
+) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.failRefreshes = n
+}
+
+func (fs *FakeServer) registerControlRoutes() {
+	fs.mux.Handle("POST "+ControlPrefix+"/users", loopbackOnly(fs.handleControlUser))
+	fs.mux.Handle("POST "+ControlPrefix+"/services", loopbackOnly(fs.handleControlService))
+	fs.mux.Handle("POST "+ControlPrefix+"/expire", loopbackOnly(fs.handleControlExpire))
+	fs.mux.Handle("POST "+ControlPrefix+"/failures", loopbackOnly(fs.handleControlFailures))
+}
+
+func (fs *FakeServer) handleControlUser(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req struct {
+		Handle  string `json:"handle"`
+		Subject string `json:"subject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Handle == "" {
+		writeFakeError(w, http.StatusBadRequest, api.CodeMalformedRequest, "handle required")
+		return
+	}
+	if req.Subject == "" {
+		req.Subject = req.Handle
+	}
+	fs.AddUser(req.Handle, req.Subject)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (fs *FakeServer) handleControlService(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req FakeService
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Audience == "" || req.Redirect == "" {
+		writeFakeError(w, http.StatusBadRequest, api.CodeMalformedRequest, "name, audience, and redirect required")
+		return
+	}
+	fs.AddService(req)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (fs *FakeServer) handleControlExpire(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	fs.ExpireAllTokens()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (fs *FakeServer) handleControlFailures(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req struct {
+		Refresh int `json:"refresh"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Refresh < 0 {
+		writeFakeError(w, http.StatusBadRequest, api.CodeMalformedRequest, "refresh must be a count")
+		return
+	}
+	fs.FailNextRefreshes(req.Refresh)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loopbackOnly rejects requests that don't come from the local machine.
+func loopbackOnly(
+	next http.HandlerFunc,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			http.Error(w, "control API is loopback only", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
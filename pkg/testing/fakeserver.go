@@ -0,0 +1,319 @@
+package testing
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/command-go/pkg/wire"
+	"git.sr.ht/~jakintosh/consent/internal/api"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// defaultAuthCodeLifetime matches the real server's default authorization
+// code lifetime.
+const defaultAuthCodeLifetime = 10 * time.Second
+
+// FakeServer is an in-memory stand-in for a consent server. It implements
+// the endpoints client.Client calls, so client integration tests can run real
+// HTTP login, refresh, and logout flows:
+//
+//	fake := testing.NewFakeServer("consent.example.com", "my-app")
+//	srv := httptest.NewServer(fake)
+//	defer srv.Close()
+//	c := client.Init(fake.TestEnv().Validator, srv.URL)
+//
+// Refresh tokens are single-use, as on the real server: each refresh revokes
+// the token it redeems.
+type FakeServer struct {
+	env *TestEnv
+	mux *http.ServeMux
+
+	mu            sync.Mutex
+	redirectURL   string
+	users         map[string]string
+	services      map[string]FakeService
+	codes         map[string]fakeAuthCode
+	issued        map[string]bool
+	revoked       map[string]bool
+	failRefreshes int
+}
+
+// FakeService is an integration registered on a FakeServer: GET /login with
+// its name as "integration" redirects to Redirect, and the code exchanges for
+// tokens for Audience.
+type FakeService struct {
+	Name     string `json:"name"`
+	Audience string `json:"audience"`
+	Redirect string `json:"redirect"`
+}
+
+type fakeAuthCode struct {
+	subject   string
+	audience  string
+	expiresAt time.Time
+}
+
+// NewFakeServer creates a FakeServer that issues tokens for audience from
+// domain, signed with the shared test key.
+func NewFakeServer(
+	domain string,
+	audience string,
+) *FakeServer {
+	return NewFakeServerWithEnv(NewTestEnv(domain, audience))
+}
+
+// NewFakeServerWithEnv creates a FakeServer that issues tokens from env.
+func NewFakeServerWithEnv(
+	env *TestEnv,
+) *FakeServer {
+	fs := &FakeServer{
+		env:      env,
+		mux:      http.NewServeMux(),
+		users:    map[string]string{},
+		services: map[string]FakeService{},
+		codes:    map[string]fakeAuthCode{},
+		issued:   map[string]bool{},
+		revoked:  map[string]bool{},
+	}
+	fs.mux.HandleFunc("GET /login", fs.handleLogin)
+	fs.mux.HandleFunc("POST /api/v1/auth/exchange", fs.handleExchange)
+	fs.mux.HandleFunc("POST /api/v1/auth/refresh", fs.handleRefresh)
+	fs.mux.HandleFunc("POST /api/v1/auth/logout", fs.handleLogout)
+	fs.registerControlRoutes()
+	return fs
+}
+
+// TestEnv returns the underlying TestEnv for token issuance.
+func (fs *FakeServer) TestEnv() *TestEnv {
+	return fs.env
+}
+
+// SetRedirectURL sets the app callback GET /login redirects to with a fresh
+// authorization code, the equivalent of an integration's redirect URL.
+func (fs *FakeServer) SetRedirectURL(
+	redirectURL string,
+) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.redirectURL = redirectURL
+}
+
+// IssueAuthCode returns a single-use authorization code for subject, for
+// tests that call client.ExchangeAuthorizationCode without a login redirect.
+func (fs *FakeServer) IssueAuthCode(
+	subject string,
+) string {
+	return fs.issueAuthCode(subject, fs.env.Audience)
+}
+
+func (fs *FakeServer) issueAuthCode(
+	subject string,
+	audience string,
+) string {
+	code := rand.Text()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.codes[code] = fakeAuthCode{
+		subject:   subject,
+		audience:  audience,
+		expiresAt: fs.env.now().Add(defaultAuthCodeLifetime),
+	}
+	return code
+}
+
+// IsRevoked reports whether a refresh token has been redeemed or logged out.
+func (fs *FakeServer) IsRevoked(
+	refreshToken *RefreshToken,
+) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.revoked[refreshToken.Encoded()]
+}
+
+// ServeHTTP implements http.Handler.
+func (fs *FakeServer) ServeHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	fs.mux.ServeHTTP(w, r)
+}
+
+// handleLogin skips the login form: it issues a code for the user named by
+// the "handle" query parameter, the "subject" parameter, or
+// DefaultTestSubject, and redirects to the callback of the "integration"
+// parameter's service, or to the redirect URL.
+func (fs *FakeServer) handleLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	query := r.URL.Query()
+
+	fs.mu.Lock()
+	redirectURL, audience := fs.redirectURL, fs.env.Audience
+	service, isService := fs.services[query.Get("integration")]
+	subject, isUser := fs.users[query.Get("handle")]
+	fs.mu.Unlock()
+
+	if isService {
+		redirectURL, audience = service.Redirect, service.Audience
+	} else if query.Get("integration") != "" {
+		http.Error(w, "unknown integration", http.StatusBadRequest)
+		return
+	}
+	if redirectURL == "" {
+		http.Error(w, "fake server has no redirect URL", http.StatusInternalServerError)
+		return
+	}
+	redirect, err := url.Parse(redirectURL)
+	if err != nil {
+		http.Error(w, "invalid redirect URL", http.StatusInternalServerError)
+		return
+	}
+
+	if !isUser {
+		if query.Get("handle") != "" {
+			http.Error(w, "unknown handle", http.StatusBadRequest)
+			return
+		}
+		subject = query.Get("subject")
+	}
+	if subject == "" {
+		subject = DefaultTestSubject
+	}
+
+	q := redirect.Query()
+	q.Set("auth_code", fs.issueAuthCode(subject, audience))
+	if returnTo := r.URL.Query().Get("return_to"); returnTo != "" {
+		q.Set("return_to", returnTo)
+	}
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusSeeOther)
+}
+
+func (fs *FakeServer) handleExchange(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req api.ExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeError(w, http.StatusBadRequest, api.CodeMalformedRequest, "Malformed JSON")
+		return
+	}
+
+	fs.mu.Lock()
+	code, ok := fs.codes[req.Code]
+	delete(fs.codes, req.Code)
+	fs.mu.Unlock()
+	if !ok {
+		writeFakeError(w, http.StatusBadRequest, "invalid_auth_code", "invalid authorization code")
+		return
+	}
+	if fs.env.now().After(code.expiresAt) {
+		writeFakeError(w, http.StatusBadRequest, "auth_code_expired", "authorization code expired")
+		return
+	}
+
+	fs.writeTokens(w, code.subject, []string{code.audience}, fs.env.Scopes)
+}
+
+func (fs *FakeServer) handleRefresh(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req api.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeError(w, http.StatusBadRequest, api.CodeMalformedRequest, "Malformed JSON")
+		return
+	}
+
+	refreshToken := new(tokens.RefreshToken)
+	if err := refreshToken.Decode(req.RefreshToken, fs.env.Validator); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "token_invalid", err.Error())
+		return
+	}
+
+	// a simulated failure leaves the token unredeemed, so the app can retry
+	fs.mu.Lock()
+	failed := fs.failRefreshes > 0
+	if failed {
+		fs.failRefreshes--
+	}
+	reused := !failed && fs.revoked[req.RefreshToken]
+	if !failed {
+		fs.revoked[req.RefreshToken] = true
+	}
+	fs.mu.Unlock()
+	if failed {
+		writeFakeError(w, http.StatusInternalServerError, api.CodeInternal, "simulated failure")
+		return
+	}
+	if reused {
+		writeFakeError(w, http.StatusBadRequest, "token_not_found", "refresh token revoked")
+		return
+	}
+
+	fs.writeTokens(w, refreshToken.Subject(), refreshToken.Audience(), refreshToken.Scopes())
+}
+
+func (fs *FakeServer) handleLogout(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var req api.LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeError(w, http.StatusBadRequest, api.CodeMalformedRequest, "Malformed JSON")
+		return
+	}
+
+	fs.mu.Lock()
+	fs.revoked[req.RefreshToken] = true
+	fs.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (fs *FakeServer) writeTokens(
+	w http.ResponseWriter,
+	subject string,
+	audience []string,
+	scopes []string,
+) {
+	accessToken, refreshToken, err := fs.env.Issuer.IssueTokenPair(subject, audience, scopes, defaultAccessTokenLifetime, defaultRefreshTokenLifetime)
+	if err != nil {
+		writeFakeError(w, http.StatusInternalServerError, api.CodeInternal, "failed to issue tokens")
+		return
+	}
+
+	fs.mu.Lock()
+	fs.issued[refreshToken.Encoded()] = true
+	fs.mu.Unlock()
+
+	wire.WriteData(w, http.StatusOK, api.RefreshResponse{
+		AccessToken:      accessToken.Encoded(),
+		RefreshToken:     refreshToken.Encoded(),
+		TokenType:        "Bearer",
+		ExpiresIn:        int(defaultAccessTokenLifetime / time.Second),
+		RefreshExpiresIn: int(defaultRefreshTokenLifetime / time.Second),
+	})
+}
+
+// writeFakeError writes an error in the real API's envelope, so the client
+// sees the same codes it would from a consent server.
+func writeFakeError(
+	w http.ResponseWriter,
+	status int,
+	code string,
+	message string,
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error api.Error `json:"error"`
+	}{
+		Error: api.Error{Code: code, Message: message},
+	})
+}
@@ -0,0 +1,191 @@
+package testing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestFakeServer_LoginRefreshLogout(t *testing.T) {
+	fake := NewFakeServer("consent.test", "app.test")
+	authServer := httptest.NewServer(fake)
+	t.Cleanup(authServer.Close)
+
+	c := client.Init(fake.TestEnv().Validator, authServer.URL)
+	c.EnableInsecureCookies()
+	app := http.NewServeMux()
+	app.Handle("/auth/callback", c.HandleAuthorizationCode())
+	app.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	appServer := httptest.NewServer(app)
+	t.Cleanup(appServer.Close)
+	fake.SetRedirectURL(appServer.URL + "/auth/callback")
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New failed: %v", err)
+	}
+	browser := &http.Client{Jar: jar}
+	res, err := browser.Get(authServer.URL + "/login?subject=bob&return_to=/home")
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	res.Body.Close()
+	if res.Request.URL.Path != "/home" {
+		t.Fatalf("landed on %s, want /home", res.Request.URL.Path)
+	}
+
+	var encodedRefresh string
+	for _, cookie := range jar.Cookies(res.Request.URL) {
+		if cookie.Name == refreshTokenCookieName {
+			encodedRefresh = cookie.Value
+		}
+	}
+	if encodedRefresh == "" {
+		t.Fatal("login did not set a refresh token cookie")
+	}
+
+	accessToken, refreshToken, ok := c.RefreshTokens(encodedRefresh)
+	if !ok {
+		t.Fatal("RefreshTokens failed")
+	}
+	if accessToken.Subject() != "bob" {
+		t.Fatalf("subject = %q, want bob", accessToken.Subject())
+	}
+	if _, _, ok := c.RefreshTokens(encodedRefresh); ok {
+		t.Fatal("redeemed a refresh token twice")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/logout?csrf="+refreshToken.Secret(), nil)
+	req.AddCookie(&http.Cookie{Name: refreshTokenCookieName, Value: refreshToken.Encoded()})
+	c.HandleLogout()(httptest.NewRecorder(), req)
+	if !fake.IsRevoked(refreshToken) {
+		t.Fatal("logout did not revoke the refresh token")
+	}
+}
+
+func TestFakeServer_AuthCodeIsSingleUse(t *testing.T) {
+	fake := NewFakeServer("consent.test", "app.test")
+	authServer := httptest.NewServer(fake)
+	t.Cleanup(authServer.Close)
+	c := client.Init(fake.TestEnv().Validator, authServer.URL)
+
+	code := fake.IssueAuthCode(DefaultTestSubject)
+	if _, _, ok := c.ExchangeAuthorizationCode(code); !ok {
+		t.Fatal("ExchangeAuthorizationCode failed")
+	}
+	if _, _, ok := c.ExchangeAuthorizationCode(code); ok {
+		t.Fatal("exchanged an authorization code twice")
+	}
+}
+
+func postControl(t *testing.T, baseURL string, path string, body string) {
+	t.Helper()
+	res, err := http.Post(baseURL+ControlPrefix+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST %s status = %d, want %d", path, res.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestFakeServer_ControlAPI(t *testing.T) {
+	fake := NewFakeServer("consent.test", "app.test")
+	authServer := httptest.NewServer(fake)
+	t.Cleanup(authServer.Close)
+
+	var code string
+	notes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code = r.URL.Query().Get("auth_code")
+	}))
+	t.Cleanup(notes.Close)
+
+	postControl(t, authServer.URL, "/users", `{"handle": "bob", "subject": "sub-bob"}`)
+	postControl(t, authServer.URL, "/services", `{"name": "notes", "audience": "notes.test", "redirect": "`+notes.URL+`/callback"}`)
+
+	res, err := http.Get(authServer.URL + "/login?handle=bob&integration=notes")
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	res.Body.Close()
+	if code == "" {
+		t.Fatal("login did not redirect to the notes callback")
+	}
+
+	validator := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &SharedTestKey().PublicKey,
+		IssuerDomain:    "consent.test",
+		ValidAudience:   "notes.test",
+	})
+	c := client.Init(validator, authServer.URL)
+	accessToken, refreshToken, ok := c.ExchangeAuthorizationCode(code)
+	if !ok {
+		t.Fatal("ExchangeAuthorizationCode failed")
+	}
+	if accessToken.Subject() != "sub-bob" {
+		t.Fatalf("subject = %q, want sub-bob", accessToken.Subject())
+	}
+
+	postControl(t, authServer.URL, "/failures", `{"refresh": 1}`)
+	if _, _, ok := c.RefreshTokens(refreshToken.Encoded()); ok {
+		t.Fatal("refresh succeeded despite an injected failure")
+	}
+	_, refreshToken, ok = c.RefreshTokens(refreshToken.Encoded())
+	if !ok {
+		t.Fatal("refresh after the injected failure failed")
+	}
+
+	postControl(t, authServer.URL, "/expire", ``)
+	if _, _, ok := c.RefreshTokens(refreshToken.Encoded()); ok {
+		t.Fatal("refreshed a token issued before ExpireAllTokens")
+	}
+}
+
+func TestFakeServer_ControlAPIIsLoopbackOnly(t *testing.T) {
+	fake := NewFakeServer("consent.test", "app.test")
+
+	req := httptest.NewRequest(http.MethodPost, ControlPrefix+"/expire", nil)
+	req.RemoteAddr = "203.0.113.7:4000"
+	rr := httptest.NewRecorder()
+	fake.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestFakeServer_StartTLS(t *testing.T) {
+	fake := NewFakeServer("consent.test", "app.test")
+	server, certPEM, err := fake.StartTLS()
+	if err != nil {
+		t.Fatalf("StartTLS failed: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	if !strings.HasPrefix(server.URL, "https://") {
+		t.Fatalf("URL = %q, want https", server.URL)
+	}
+
+	// a client trusting only the emitted PEM can reach the server
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certPEM) {
+		t.Fatal("certificate PEM did not parse")
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	body := `{"code": "` + fake.IssueAuthCode(DefaultTestSubject) + `"}`
+	res, err := httpClient.Post(server.URL+"/api/v1/auth/exchange", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("exchange over TLS failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("exchange status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
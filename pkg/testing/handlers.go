@@ -7,18 +7,13 @@ import (
 // HandleDevLogin returns a handler that issues cookies for DefaultTestSubject.
 func (tv *TestVerifier) HandleDevLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		accessToken, err := tv.env.IssueAccessToken(DefaultTestSubject, defaultAccessTokenLifetime)
+		accessToken, refreshToken, err := tv.env.IssueTokenPair(DefaultTestSubject)
 		if err != nil {
-			http.Error(w, "failed to issue access token", http.StatusInternalServerError)
-			return
-		}
-		refreshToken, err := tv.env.IssueRefreshToken(DefaultTestSubject, defaultRefreshTokenLifetime)
-		if err != nil {
-			http.Error(w, "failed to issue refresh token", http.StatusInternalServerError)
+			http.Error(w, "failed to issue tokens", http.StatusInternalServerError)
 			return
 		}
 
-		setTokenCookies(w, accessToken, refreshToken)
+		setTokenCookies(w, tv.env.now(), accessToken, refreshToken)
 		returnTo := r.URL.Query().Get("return_to")
 		if returnTo == "" {
 			returnTo = "/"
@@ -21,11 +21,7 @@ func (env *TestEnv) AuthenticatedRequest(
 		return nil, err
 	}
 
-	accessToken, err := env.IssueAccessToken(subject, defaultAccessTokenLifetime)
-	if err != nil {
-		return nil, err
-	}
-	refreshToken, err := env.IssueRefreshToken(subject, defaultRefreshTokenLifetime)
+	accessToken, refreshToken, err := env.IssueTokenPair(subject)
 	if err != nil {
 		return nil, err
 	}
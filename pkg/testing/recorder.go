@@ -0,0 +1,238 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// RecordMode selects whether a Recorder talks to a real server or replays a
+// golden file.
+type RecordMode int
+
+const (
+	// ModeReplay answers requests from the golden file, in order, without
+	// touching the network.
+	ModeReplay RecordMode = iota
+
+	// ModeRecord forwards requests to the real server and captures each
+	// exchange; Save writes them to the golden file.
+	ModeRecord
+)
+
+// Interaction is one recorded request and the server's response. JSON
+// bodies are stored as JSON so golden files stay readable; other bodies are
+// stored as text.
+type Interaction struct {
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	RequestBody  json.RawMessage `json:"requestBody,omitempty"`
+	RequestText  string          `json:"requestText,omitempty"`
+	Status       int             `json:"status"`
+	ContentType  string          `json:"contentType,omitempty"`
+	ResponseBody json.RawMessage `json:"responseBody,omitempty"`
+	ResponseText string          `json:"responseText,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that records a client's interactions with
+// a consent server into a golden file and replays them offline, so tests of
+// apps that rely on exact server responses can run hermetically:
+//
+//	rec, _ := testing.NewRecorder("testdata/refresh.json", mode, nil)
+//	c := client.Init(validator, "https://consent.example.com")
+//	c.SetHTTPClient(rec.Client())
+//	// ... exercise the app ...
+//	rec.Save() // no-op when replaying
+//
+// Replayed tokens keep the expiry they were issued with. Validate them with a
+// tokens.Clock set to when they were recorded.
+type Recorder struct {
+	mode      RecordMode
+	path      string
+	transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+}
+
+// NewRecorder creates a Recorder for the golden file at path. In ModeRecord,
+// requests go through transport, or http.DefaultTransport if it is nil. In
+// ModeReplay, the golden file must already exist.
+func NewRecorder(
+	path string,
+	mode RecordMode,
+	transport http.RoundTripper,
+) (
+	*Recorder,
+	error,
+) {
+	r := &Recorder{
+		mode:      mode,
+		path:      path,
+		transport: transport,
+	}
+	if r.transport == nil {
+		r.transport = http.DefaultTransport
+	}
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read golden file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, fmt.Errorf("parse golden file %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Client returns an http.Client that sends its requests through r.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Interactions returns the interactions recorded or loaded so far.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Save writes the recorded interactions to the golden file. It does nothing
+// in ModeReplay.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.Interactions(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode golden file: %w", err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write golden file: %w", err)
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(
+	req *http.Request,
+) (
+	*http.Response,
+	error,
+) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req)
+	}
+	return r.record(req, requestBody)
+}
+
+func (r *Recorder) record(
+	req *http.Request,
+	requestBody []byte,
+) (
+	*http.Response,
+	error,
+) {
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interaction := Interaction{
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Status:      res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+	}
+	interaction.RequestBody, interaction.RequestText = splitBody(requestBody)
+	interaction.ResponseBody, interaction.ResponseText = splitBody(responseBody)
+	r.interactions = append(r.interactions, interaction)
+	return res, nil
+}
+
+// replay answers req with the next interaction, which must be for the same
+// method and path. Request bodies aren't compared, since they carry tokens
+// that differ between runs.
+func (r *Recorder) replay(
+	req *http.Request,
+) (
+	*http.Response,
+	error,
+) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= len(r.interactions) {
+		return nil, fmt.Errorf("replay: unexpected %s %s after %d recorded interactions", req.Method, req.URL.Path, len(r.interactions))
+	}
+	interaction := r.interactions[r.next]
+	if interaction.Method != req.Method || interaction.Path != req.URL.Path {
+		return nil, fmt.Errorf("replay: got %s %s, recorded %s %s", req.Method, req.URL.Path, interaction.Method, interaction.Path)
+	}
+	r.next++
+
+	body := []byte(interaction.ResponseBody)
+	if body == nil {
+		body = []byte(interaction.ResponseText)
+	}
+	header := http.Header{}
+	if interaction.ContentType != "" {
+		header.Set("Content-Type", interaction.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// splitBody returns body as JSON if it is JSON, and as text otherwise.
+func splitBody(
+	body []byte,
+) (
+	json.RawMessage,
+	string,
+) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, ""
+	}
+	if json.Valid(body) {
+		compact := new(bytes.Buffer)
+		if err := json.Compact(compact, body); err == nil {
+			return compact.Bytes(), ""
+		}
+	}
+	return nil, string(body)
+}
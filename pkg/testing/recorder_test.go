@@ -0,0 +1,54 @@
+package testing
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/client"
+)
+
+func TestRecorder_RecordThenReplay(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "refresh.json")
+	fake := NewFakeServer("consent.test", "app.test")
+	authServer := httptest.NewServer(fake)
+	t.Cleanup(authServer.Close)
+
+	refreshToken, err := fake.TestEnv().IssueRefreshToken(DefaultTestSubject, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+
+	recorder, err := NewRecorder(golden, ModeRecord, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	c := client.Init(fake.TestEnv().Validator, authServer.URL)
+	c.SetHTTPClient(recorder.Client())
+	recorded, _, ok := c.RefreshTokens(refreshToken.Encoded())
+	if !ok {
+		t.Fatal("RefreshTokens failed while recording")
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	authServer.Close()
+
+	replayer, err := NewRecorder(golden, ModeReplay, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder replay failed: %v", err)
+	}
+	c.SetHTTPClient(replayer.Client())
+	replayed, _, ok := c.RefreshTokens(refreshToken.Encoded())
+	if !ok {
+		t.Fatal("RefreshTokens failed while replaying")
+	}
+	if replayed.Encoded() != recorded.Encoded() {
+		t.Fatal("replay returned a different access token than was recorded")
+	}
+
+	if _, _, ok := c.RefreshTokens(refreshToken.Encoded()); ok {
+		t.Fatal("replay answered a request it has no recording for")
+	}
+}
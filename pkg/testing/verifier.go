@@ -13,7 +13,8 @@ import (
 // TestVerifier implements client.Verifier for testing.
 // It validates tokens locally and handles refresh without network calls.
 type TestVerifier struct {
-	env *TestEnv
+	env      *TestEnv
+	failures Failure
 }
 
 // Compile-time check that TestVerifier implements client.Verifier.
@@ -68,7 +69,7 @@ func (tv *TestVerifier) VerifyAuthorization(
 		return accessToken, nil
 	}
 	if !errorIsRefreshable(err) {
-		return nil, fmt.Errorf("%w: %v", client.ErrTokenInvalid, err)
+		return nil, fmt.Errorf("%w: %w", client.ErrTokenInvalid, err)
 	}
 
 	// If in refreshable state, validate refresh token
@@ -85,12 +86,29 @@ func (tv *TestVerifier) VerifyAuthorization(
 	if err != nil {
 		return nil, err
 	}
-	setTokenCookies(w, accessToken, refreshToken)
+	setTokenCookies(w, tv.env.now(), accessToken, refreshToken)
 
 	return accessToken, nil
 }
 
 // VerifyAuthorizationGetCSRF implements client.Verifier.
+// VerifyAuthorizationNoRefresh implements client.Verifier.
+func (tv *TestVerifier) VerifyAuthorizationNoRefresh(
+	r *http.Request,
+) (
+	*client.AccessToken,
+	error,
+) {
+	accessToken, err := tv.validateAccessToken(r)
+	if err != nil {
+		if errors.Is(err, client.ErrTokenAbsent) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", client.ErrTokenInvalid, err)
+	}
+	return accessToken, nil
+}
+
 func (tv *TestVerifier) VerifyAuthorizationGetCSRF(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -114,7 +132,7 @@ func (tv *TestVerifier) VerifyAuthorizationGetCSRF(
 		return accessToken, refreshToken.Secret(), nil
 	}
 	if !errorIsRefreshable(err) {
-		return nil, "", fmt.Errorf("%w: %v", client.ErrTokenInvalid, err)
+		return nil, "", fmt.Errorf("%w: %w", client.ErrTokenInvalid, err)
 	}
 
 	// Refresh the tokens locally.
@@ -123,7 +141,7 @@ func (tv *TestVerifier) VerifyAuthorizationGetCSRF(
 		return nil, "", err
 	}
 
-	setTokenCookies(w, accessToken, refreshToken)
+	setTokenCookies(w, tv.env.now(), accessToken, refreshToken)
 
 	return accessToken, refreshToken.Secret(), nil
 }
@@ -145,7 +163,7 @@ func (tv *TestVerifier) VerifyAuthorizationCheckCSRF(
 	}
 
 	currentCSRFSecret := refreshToken.Secret()
-	if currentCSRFSecret != reqCSRFSecret {
+	if tv.simulates(FailCSRFMismatch) || !tokens.SecureCompare(currentCSRFSecret, reqCSRFSecret) {
 		return nil, "", client.ErrCSRFInvalid
 	}
 
@@ -155,7 +173,7 @@ func (tv *TestVerifier) VerifyAuthorizationCheckCSRF(
 		return accessToken, currentCSRFSecret, nil
 	}
 	if !errorIsRefreshable(err) {
-		return nil, "", fmt.Errorf("%w: %v", client.ErrTokenInvalid, err)
+		return nil, "", fmt.Errorf("%w: %w", client.ErrTokenInvalid, err)
 	}
 
 	// Refresh the tokens locally
@@ -165,7 +183,7 @@ func (tv *TestVerifier) VerifyAuthorizationCheckCSRF(
 	}
 	newCSRFSecret := refreshToken.Secret()
 
-	setTokenCookies(w, accessToken, refreshToken)
+	setTokenCookies(w, tv.env.now(), accessToken, refreshToken)
 	return accessToken, newCSRFSecret, nil
 }
 
@@ -177,21 +195,15 @@ func (tv *TestVerifier) refreshTokens(
 	*tokens.RefreshToken,
 	error,
 ) {
+	if tv.simulates(FailRefreshNetwork) {
+		return nil, nil, client.ErrNetworkTokenRefresh
+	}
+
 	subject := oldRefresh.Subject()
 	audience := oldRefresh.Audience()
 	scopes := oldRefresh.Scopes()
 
-	accessToken, err := tv.env.Issuer.IssueAccessToken(subject, audience, scopes, defaultAccessTokenLifetime)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	refreshToken, err := tv.env.Issuer.IssueRefreshToken(subject, audience, scopes, defaultRefreshTokenLifetime)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	return accessToken, refreshToken, nil
+	return tv.env.Issuer.IssueTokenPair(subject, audience, scopes, defaultAccessTokenLifetime, defaultRefreshTokenLifetime)
 }
 
 func (tv *TestVerifier) validateAccessToken(
@@ -209,6 +221,9 @@ func (tv *TestVerifier) validateAccessToken(
 	if err := token.Decode(cookie.Value, tv.env.Validator); err != nil {
 		return nil, err
 	}
+	if err := tv.injectedTokenError("access", true); err != nil {
+		return nil, err
+	}
 	return token, nil
 }
 
@@ -225,17 +240,20 @@ func (tv *TestVerifier) validateRefreshToken(
 
 	token := new(tokens.RefreshToken)
 	if err := token.Decode(cookie.Value, tv.env.Validator); err != nil {
-		return nil, fmt.Errorf("%w: %v", client.ErrTokenInvalid, err)
+		return nil, fmt.Errorf("%w: %w", client.ErrTokenInvalid, err)
+	}
+	if err := tv.injectedTokenError("refresh", false); err != nil {
+		return nil, fmt.Errorf("%w: %w", client.ErrTokenInvalid, err)
 	}
 	return token, nil
 }
 
 func setTokenCookies(
 	w http.ResponseWriter,
+	now time.Time,
 	accessToken *AccessToken,
 	refreshToken *RefreshToken,
 ) {
-	now := time.Now()
 	accessMaxAge := int(accessToken.Expiration().Sub(now).Seconds())
 	refreshMaxAge := int(refreshToken.Expiration().Sub(now).Seconds())
 
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jakintosh/consent/pkg/client"
 )
@@ -23,3 +24,33 @@ func TestVerifyAuthorizationCheckCSRF_MissingRefreshIsAbsent(t *testing.T) {
 		t.Fatalf("expected ErrTokenAbsent, got %v", err)
 	}
 }
+
+func TestVerifyAuthorizationNoRefresh_DoesNotRefresh(t *testing.T) {
+	tv := NewTestVerifier("consent.test", "app.test")
+	env := tv.TestEnv()
+
+	accessToken, err := env.IssueAccessToken(DefaultTestSubject, -time.Minute)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	refreshToken, err := env.IssueRefreshToken(DefaultTestSubject, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: accessTokenCookieName, Value: accessToken.Encoded()})
+	req.AddCookie(&http.Cookie{Name: refreshTokenCookieName, Value: refreshToken.Encoded()})
+
+	if _, err := tv.VerifyAuthorizationNoRefresh(req); !errors.Is(err, client.ErrTokenInvalid) {
+		t.Fatalf("VerifyAuthorizationNoRefresh err = %v, want ErrTokenInvalid", err)
+	}
+
+	// the refreshing variant recovers the same request
+	rr := httptest.NewRecorder()
+	if _, err := tv.VerifyAuthorization(rr, req); err != nil {
+		t.Fatalf("VerifyAuthorization failed: %v", err)
+	}
+	if len(rr.Result().Cookies()) == 0 {
+		t.Error("expected VerifyAuthorization to set refreshed cookies")
+	}
+}
@@ -1,7 +1,10 @@
 package tokens
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"log"
+	"slices"
 	"strings"
 	"time"
 )
@@ -12,36 +15,60 @@ import (
 // It contains standard JWT claims (exp, iat, iss, aud, sub) and sits between
 // the JSON representation in the token and the AccessToken Go struct.
 type AccessTokenClaims struct {
-	Expiration int64  `json:"exp"`
-	IssuedAt   int64  `json:"iat"`
-	Issuer     string `json:"iss"`
-	Audience   string `json:"aud"`
-	Subject    string `json:"sub"`
-	Scopes     string `json:"scopes,omitempty"`
+	Expiration   int64       `json:"exp"`
+	IssuedAt     int64       `json:"iat"`
+	Issuer       string      `json:"iss"`
+	Audience     string      `json:"aud"`
+	Subject      string      `json:"sub"`
+	Scopes       string      `json:"scopes,omitempty"`
+	AuthTime     int64       `json:"auth_time,omitempty"`
+	Actor        *ActorClaim `json:"act,omitempty"`
+	TermsVersion string      `json:"terms_version,omitempty"`
+
+	// Extra holds claims beyond the ones above, added with
+	// AccessTokenBuilder.Claim. They are encoded alongside the others.
+	Extra map[string]any `json:"-"`
 }
 
-func (claims *AccessTokenClaims) validate(validator Validator) error {
-	now := time.Now()
+// accessTokenClaimNames are the claims AccessTokenClaims defines, which
+// extra claims may not reuse.
+var accessTokenClaimNames = []string{"exp", "iat", "iss", "aud", "sub", "scopes", "auth_time", "act", "terms_version"}
 
-	if time.Unix(claims.IssuedAt, 0).After(now) {
-		return ErrTokenNotIssued()
+// MarshalJSON encodes the claims with any Extra claims merged in.
+func (claims AccessTokenClaims) MarshalJSON() ([]byte, error) {
+	type plain AccessTokenClaims
+	data, err := json.Marshal(plain(claims))
+	if err != nil || len(claims.Extra) == 0 {
+		return data, err
 	}
-
-	if time.Unix(claims.Expiration, 0).Before(now) {
-		return ErrTokenExpired()
+	merged := map[string]any{}
+	for name, value := range claims.Extra {
+		merged[name] = value
 	}
-
-	if !validator.ValidateDomain(claims.Issuer) {
-		return ErrTokenInvalidIssuer()
+	var standard map[string]json.RawMessage
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return nil, err
 	}
-
-	if validator.ShouldValidateAudience() {
-		if !validator.ValidateAudiences(claims.Audience) {
-			return ErrTokenInvalidAudience()
-		}
+	for name, value := range standard {
+		merged[name] = value
 	}
+	return json.Marshal(merged)
+}
 
-	return nil
+// ActorClaim is the RFC 8693 "act" claim: who is acting as the token's
+// subject, such as an administrator impersonating a user.
+type ActorClaim struct {
+	Subject string `json:"sub"`
+}
+
+func (claims *AccessTokenClaims) validate(validator Validator) error {
+	return validateStandardClaims(
+		validator,
+		claims.IssuedAt,
+		claims.Expiration,
+		claims.Issuer,
+		claims.Audience,
+	)
 }
 
 // ==============================================
@@ -52,6 +79,16 @@ func (claims *AccessTokenClaims) validate(validator Validator) error {
 //
 // Access tokens are typically valid for a short duration (e.g., 1 hour) and should be
 // stored in HTTP-only cookies or authorization headers.
+//
+// AuthTime is when the user last entered their credentials, which may be long
+// before the token was issued. It is zero if the token doesn't say.
+//
+// Actor is the subject of an administrator impersonating the token's subject,
+// or empty for the user's own tokens. Apps should make impersonation visible,
+// for example with a banner.
+//
+// TermsVersion is the version of the terms of service the user has
+// accepted, or empty if they have accepted none.
 type AccessToken struct {
 	issuer     string
 	issuedAt   time.Time
@@ -59,6 +96,11 @@ type AccessToken struct {
 	audience   []string
 	subject    string
 	scopes     []string
+	authTime   time.Time
+	actor      string
+	terms      string
+	extra      map[string]any
+	claims     map[string]json.RawMessage
 	encoded    string
 }
 
@@ -68,14 +110,24 @@ func (t *AccessToken) Expiration() time.Time { return t.expiration }
 func (t *AccessToken) Audience() []string    { return t.audience }
 func (t *AccessToken) Subject() string       { return t.subject }
 func (t *AccessToken) Scopes() []string      { return append([]string(nil), t.scopes...) }
+func (t *AccessToken) AuthTime() time.Time   { return t.authTime }
+func (t *AccessToken) Actor() string         { return t.actor }
+func (t *AccessToken) TermsVersion() string  { return t.terms }
 func (t *AccessToken) Encoded() string       { return t.encoded }
 
+// Claim returns the raw JSON of a claim beyond the standard ones, as added
+// with AccessTokenBuilder.Claim, and whether the token has it.
+func (t *AccessToken) Claim(name string) (json.RawMessage, bool) {
+	value, ok := t.claims[name]
+	return value, ok
+}
+
 func (token *AccessToken) Decode(encToken string, validator Validator) error {
 	claims, err := decodeToken[*AccessTokenClaims](encToken, validator)
 	if err != nil {
 		if true {
 			// TODO: make this actually check log level
-			log.Println(err.Context())
+			log.Println(err)
 		}
 		return err
 	}
@@ -91,6 +143,12 @@ func (token *AccessToken) intoClaims() *AccessTokenClaims {
 	claims.Audience = strings.Join(token.audience, " ")
 	claims.Subject = token.subject
 	claims.Scopes = strings.Join(token.scopes, " ")
+	claims.AuthTime = unixOrZero(token.authTime)
+	if token.actor != "" {
+		claims.Actor = &ActorClaim{Subject: token.actor}
+	}
+	claims.TermsVersion = token.terms
+	claims.Extra = token.extra
 	return claims
 }
 
@@ -101,9 +159,60 @@ func (token *AccessToken) fromClaims(claims *AccessTokenClaims, encToken string)
 	token.audience = strings.Split(claims.Audience, " ")
 	token.subject = claims.Subject
 	token.scopes = splitClaimValues(claims.Scopes)
+	token.authTime = timeOrZero(claims.AuthTime)
+	if claims.Actor != nil {
+		token.actor = claims.Actor.Subject
+	}
+	token.terms = claims.TermsVersion
+	token.claims = extraClaims(encToken, accessTokenClaimNames)
 	token.encoded = encToken
 }
 
+// extraClaims reads the claims of an already verified token that are not
+// among known.
+func extraClaims(
+	encToken string,
+	known []string,
+) map[string]json.RawMessage {
+	parts := strings.Split(encToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil
+	}
+	for name := range claims {
+		if slices.Contains(known, name) {
+			delete(claims, name)
+		}
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+	return claims
+}
+
+// unixOrZero encodes an optional time claim, leaving zero times out.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// timeOrZero decodes an optional time claim written by unixOrZero.
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
 func splitClaimValues(value string) []string {
 	if value == "" {
 		return nil
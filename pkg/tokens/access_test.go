@@ -1,6 +1,8 @@
 package tokens_test
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -53,6 +55,45 @@ func TestAccessToken_Decode_Expired(t *testing.T) {
 	}
 }
 
+func TestAccessToken_Decode_WrapsTokenErrors(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+	otherIssuer, _ := newTestServer(t, "other.domain")
+
+	expired, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, -time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	wrongIssuer, err := otherIssuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		want        error
+		wantContext string
+	}{
+		{"expired", expired.Encoded(), tokens.ErrTokenExpired(), "expired at"},
+		{"wrong issuer", wrongIssuer.Encoded(), tokens.ErrTokenInvalidIssuer(), "other.domain"},
+		{"malformed", "a.b", tokens.ErrTokenMalformed(), "expected three parts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&tokens.AccessToken{}).Decode(tt.token, validator)
+			wrapped := fmt.Errorf("request rejected: %w", err)
+			if !errors.Is(wrapped, tt.want) {
+				t.Fatalf("errors.Is(%v, %v) = false", wrapped, tt.want)
+			}
+			if !strings.Contains(wrapped.Error(), tt.wantContext) {
+				t.Errorf("error %q does not contain %q", wrapped, tt.wantContext)
+			}
+		})
+	}
+}
+
 func TestAccessToken_Decode_WrongIssuer(t *testing.T) {
 	t.Parallel()
 	// issue from one domain, validate with another
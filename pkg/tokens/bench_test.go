@@ -0,0 +1,102 @@
+package tokens_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func BenchmarkIssueAccessToken(b *testing.B) {
+	issuer, _ := newTestServer(b, "test.domain")
+	audience := []string{"aud"}
+	scopes := []string{"profile"}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := issuer.IssueAccessToken("user", audience, scopes, time.Hour); err != nil {
+			b.Fatalf("IssueAccessToken failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkIssueRefreshToken(b *testing.B) {
+	issuer, _ := newTestServer(b, "test.domain")
+	audience := []string{"aud"}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := issuer.IssueRefreshToken("user", audience, nil, 24*time.Hour); err != nil {
+			b.Fatalf("IssueRefreshToken failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkAccessToken_Decode(b *testing.B) {
+	issuer, validator := newTestServer(b, "test.domain")
+	token, err := issuer.IssueAccessToken("user", []string{"aud"}, []string{"profile"}, time.Hour)
+	if err != nil {
+		b.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	encoded := token.Encoded()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := new(tokens.AccessToken).Decode(encoded, validator); err != nil {
+			b.Fatalf("Decode failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkParseUnverified(b *testing.B) {
+	issuer, _ := newTestServer(b, "test.domain")
+	token, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour)
+	if err != nil {
+		b.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	encoded := token.Encoded()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := tokens.ParseUnverified(encoded); err != nil {
+			b.Fatalf("ParseUnverified failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkIssueAccessToken_Parallel measures issuance throughput when many
+// goroutines issue at once, as during a refresh storm, with signing
+// unbounded and bounded to a pool of workers.
+func BenchmarkIssueAccessToken_Parallel(b *testing.B) {
+	cases := []struct {
+		name    string
+		workers int
+	}{
+		{"unbounded", 0},
+		{"workers=1", 1},
+		{"workers=4", 4},
+		{"workers=GOMAXPROCS", runtime.GOMAXPROCS(0)},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			issuer, _ := tokens.InitServer(tokens.ServerOptions{
+				SigningKey:   getSharedTestKey(b),
+				IssuerDomain: "test.domain",
+				SignWorkers:  tc.workers,
+				SignQueue:    1 << 16,
+			})
+			audience := []string{"aud"}
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := issuer.IssueAccessToken("user", audience, nil, time.Hour); err != nil {
+						b.Errorf("IssueAccessToken failed: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
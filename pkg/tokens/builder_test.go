@@ -0,0 +1,105 @@
+package tokens_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestAccessTokenBuilder_Issue(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+
+	// building a token sets the same fields as the positional methods
+	original, err := issuer.NewAccessToken().
+		Subject("subject").
+		Audience("aud").
+		Scopes("identity", "profile").
+		Lifetime(time.Hour).
+		Actor("admin").
+		TermsVersion("2026-10").
+		Claim("tenant", "acme").
+		Issue()
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	decoded := &tokens.AccessToken{}
+	if err := decoded.Decode(original.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Subject() != "subject" {
+		t.Errorf("Subject = %s, want subject", decoded.Subject())
+	}
+	if !slices.Equal(decoded.Audience(), []string{"aud"}) {
+		t.Errorf("Audience = %v, want [aud]", decoded.Audience())
+	}
+	if !slices.Equal(decoded.Scopes(), []string{"identity", "profile"}) {
+		t.Errorf("Scopes = %v, want [identity profile]", decoded.Scopes())
+	}
+	if decoded.Actor() != "admin" {
+		t.Errorf("Actor = %s, want admin", decoded.Actor())
+	}
+	if decoded.TermsVersion() != "2026-10" {
+		t.Errorf("TermsVersion = %s, want 2026-10", decoded.TermsVersion())
+	}
+
+	// custom claims round trip
+	raw, ok := decoded.Claim("tenant")
+	if !ok {
+		t.Fatal("tenant claim missing")
+	}
+	var tenant string
+	if err := json.Unmarshal(raw, &tenant); err != nil || tenant != "acme" {
+		t.Errorf("tenant = %s (%v), want acme", raw, err)
+	}
+	if _, ok := decoded.Claim("sub"); ok {
+		t.Error("Claim should not return standard claims")
+	}
+}
+
+func TestAccessTokenBuilder_Invalid(t *testing.T) {
+	t.Parallel()
+	issuer, _ := newTestServer(t, "test.domain")
+
+	cases := map[string]*tokens.AccessTokenBuilder{
+		"no subject":     issuer.NewAccessToken().Audience("aud").Lifetime(time.Hour),
+		"no lifetime":    issuer.NewAccessToken().Subject("subject").Audience("aud"),
+		"no audience":    issuer.NewAccessToken().Subject("subject").Lifetime(time.Hour),
+		"reserved claim": issuer.NewAccessToken().Subject("subject").Audience("aud").Lifetime(time.Hour).Claim("exp", 0),
+	}
+	for name, builder := range cases {
+		if _, err := builder.Issue(); err == nil {
+			t.Errorf("%s: Issue succeeded, want error", name)
+		}
+	}
+}
+
+func TestRefreshTokenBuilder_Issue(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+
+	original, err := issuer.NewRefreshToken().
+		Subject("subject").
+		Audience("aud").
+		Lifetime(time.Hour).
+		SessionOnly().
+		Issue()
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	decoded := &tokens.RefreshToken{}
+	if err := decoded.Decode(original.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Subject() != "subject" {
+		t.Errorf("Subject = %s, want subject", decoded.Subject())
+	}
+	if !decoded.SessionOnly() {
+		t.Error("SessionOnly = false, want true")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"slices"
 	"strings"
+	"time"
 )
 
 // Client implements the Validator interface for backend applications.
@@ -13,7 +14,11 @@ import (
 type Client struct {
 	verificationKey *ecdsa.PublicKey
 	issuerDomain    string
-	validAudience   string
+	validAudiences  []string
+	audienceMatch   AudienceMatch
+	parse           ParseOptions
+	clock           Clock
+	leeway          time.Duration
 }
 
 //
@@ -32,6 +37,18 @@ func (client *Client) VerifySignature(
 	)
 }
 
+func (client *Client) parseOptions() ParseOptions {
+	return client.parse
+}
+
+func (client *Client) currentTime() time.Time {
+	return nowFrom(client.clock)
+}
+
+func (client *Client) clockLeeway() time.Duration {
+	return client.leeway
+}
+
 func (client *Client) ShouldValidateAudience() bool {
 	return true
 }
@@ -48,10 +65,10 @@ func (client *Client) ValidateAudiences(audience string) bool {
 		return false
 	}
 
-	// must contain the valid audience
-	if !slices.Contains(audiences, client.validAudience) {
-		return false
-	}
-
-	return true
+	// must contain one of the valid audiences
+	return slices.ContainsFunc(audiences, func(audience string) bool {
+		return slices.ContainsFunc(client.validAudiences, func(valid string) bool {
+			return client.audienceMatch.matches(valid, audience)
+		})
+	})
 }
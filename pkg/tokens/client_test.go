@@ -87,6 +87,90 @@ func TestClient_ValidateAudiences_Multiple(t *testing.T) {
 	}
 }
 
+func TestClient_ValidateAudiences_SeveralValid(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+	clientOpts := tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "consent.domain",
+		ValidAudience:   "gateway",
+		ValidAudiences:  []string{"billing", "search"},
+	}
+	validator := tokens.InitClient(clientOpts)
+
+	// any one of the valid audiences is enough
+	for _, audience := range []string{"gateway", "billing", "other-app search"} {
+		if !validator.ValidateAudiences(audience) {
+			t.Errorf("ValidateAudiences(%q) should return true", audience)
+		}
+	}
+
+	// none of them is not
+	if validator.ValidateAudiences("other-app") {
+		t.Error("ValidateAudiences should return false when no valid audience is present")
+	}
+}
+
+func TestClient_ValidateAudiences_MatchStrategies(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+	cases := []struct {
+		match    tokens.AudienceMatch
+		valid    string
+		accepted []string
+		rejected []string
+	}{
+		{
+			match:    tokens.AudienceMatchExact,
+			valid:    "app.example.com",
+			accepted: []string{"app.example.com"},
+			rejected: []string{"tenant1.app.example.com"},
+		},
+		{
+			match:    tokens.AudienceMatchSubdomain,
+			valid:    "app.example.com",
+			accepted: []string{"app.example.com", "tenant1.app.example.com", "a.b.app.example.com"},
+			rejected: []string{"evilapp.example.com", "app.example.com.evil", "example.com"},
+		},
+		{
+			match:    tokens.AudienceMatchGlob,
+			valid:    "tenant-*.example.com",
+			accepted: []string{"tenant-1.example.com", "tenant-acme.example.com"},
+			rejected: []string{"tenant.example.com", "tenant-1.example.org", "tenant-1/x.example.com", "tenant-a.b.example.com"},
+		},
+		{
+			match:    tokens.AudienceMatchGlob,
+			valid:    "*.example.com",
+			accepted: []string{"a.example.com"},
+			rejected: []string{"a.b.example.com", "example.com", "a.example.com.evil"},
+		},
+		{
+			match:    tokens.AudienceMatchGlob,
+			valid:    "api.*",
+			accepted: []string{"api.internal"},
+			rejected: []string{"api.attacker.net", "api"},
+		},
+	}
+	for _, tc := range cases {
+		validator := tokens.InitClient(tokens.ClientOptions{
+			VerificationKey: &key.PublicKey,
+			IssuerDomain:    "consent.domain",
+			ValidAudience:   tc.valid,
+			AudienceMatch:   tc.match,
+		})
+		for _, audience := range tc.accepted {
+			if !validator.ValidateAudiences(audience) {
+				t.Errorf("match %d: %q should accept %q", tc.match, tc.valid, audience)
+			}
+		}
+		for _, audience := range tc.rejected {
+			if validator.ValidateAudiences(audience) {
+				t.Errorf("match %d: %q should reject %q", tc.match, tc.valid, audience)
+			}
+		}
+	}
+}
+
 func TestClient_VerifySignature_Valid(t *testing.T) {
 	t.Parallel()
 	key := getSharedTestKey(t)
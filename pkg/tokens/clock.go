@@ -0,0 +1,66 @@
+package tokens
+
+import "time"
+
+// Clock tells a Server or Client the current time, which it uses to stamp
+// issued tokens and to check that tokens are issued and not yet expired.
+// Tests install a fake clock to expire tokens deterministically; everything
+// else leaves it nil to use the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// clocker is implemented by validators that carry a Clock. Other validators
+// use the system clock.
+type clocker interface {
+	currentTime() time.Time
+}
+
+func nowFrom(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
+
+func nowOf(validator Validator) time.Time {
+	if v, ok := validator.(clocker); ok {
+		return v.currentTime()
+	}
+	return time.Now()
+}
+
+// leewayer is implemented by validators that tolerate clock skew. Other
+// validators allow none.
+type leewayer interface {
+	clockLeeway() time.Duration
+}
+
+func leewayOf(validator Validator) time.Duration {
+	if v, ok := validator.(leewayer); ok {
+		return max(v.clockLeeway(), 0)
+	}
+	return 0
+}
+
+// WithLeeway returns validator accepting tokens up to leeway before they
+// were issued and after they expire, for apps whose clock may be slightly off
+// from the consent server's. It keeps validator's clock and parse options.
+func WithLeeway(
+	validator Validator,
+	leeway time.Duration,
+) Validator {
+	if v, ok := validator.(leewayValidator); ok {
+		validator = v.Validator
+	}
+	return leewayValidator{Validator: validator, leeway: leeway}
+}
+
+type leewayValidator struct {
+	Validator
+	leeway time.Duration
+}
+
+func (v leewayValidator) currentTime() time.Time     { return nowOf(v.Validator) }
+func (v leewayValidator) parseOptions() ParseOptions { return parseOptionsOf(v.Validator) }
+func (v leewayValidator) clockLeeway() time.Duration { return v.leeway }
@@ -0,0 +1,99 @@
+package tokens_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time { return c.now }
+
+func TestClock_StampsAndExpiresTokens(t *testing.T) {
+	start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fixedClock{now: start}
+	key := getSharedTestKey(t)
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		SigningKey:   key,
+		IssuerDomain: "consent.test",
+		Clock:        clock,
+	})
+	validator := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "consent.test",
+		ValidAudience:   "app",
+		Clock:           clock,
+	})
+
+	issued, err := issuer.IssueAccessToken("alice", []string{"app"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	if !issued.IssuedAt().Equal(start) {
+		t.Fatalf("IssuedAt = %v, want %v", issued.IssuedAt(), start)
+	}
+
+	// the token is from the future by the system clock, but valid by ours
+	decoded := new(tokens.AccessToken)
+	if err := decoded.Decode(issued.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	clock.now = start.Add(time.Hour + time.Second)
+	if err := decoded.Decode(issued.Encoded(), validator); !errors.Is(err, tokens.ErrTokenExpired()) {
+		t.Fatalf("Decode after advancing err = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestLeeway_ToleratesClockSkew(t *testing.T) {
+	start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	serverClock := &fixedClock{now: start}
+	clientClock := &fixedClock{now: start.Add(-5 * time.Second)}
+	key := getSharedTestKey(t)
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		SigningKey:   key,
+		IssuerDomain: "consent.test",
+		Clock:        serverClock,
+	})
+	options := tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "consent.test",
+		ValidAudience:   "app",
+		Clock:           clientClock,
+	}
+	strict := tokens.InitClient(options)
+	options.Leeway = 10 * time.Second
+	tolerant := tokens.InitClient(options)
+
+	issued, err := issuer.IssueAccessToken("alice", []string{"app"}, nil, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	// the server's clock is ahead, so the token isn't issued yet here
+	decoded := new(tokens.AccessToken)
+	if err := decoded.Decode(issued.Encoded(), strict); !errors.Is(err, tokens.ErrTokenNotIssued()) {
+		t.Fatalf("strict Decode err = %v, want ErrTokenNotIssued", err)
+	}
+	if err := decoded.Decode(issued.Encoded(), tolerant); err != nil {
+		t.Fatalf("tolerant Decode failed: %v", err)
+	}
+	if err := decoded.Decode(issued.Encoded(), tokens.WithLeeway(strict, 10*time.Second)); err != nil {
+		t.Fatalf("WithLeeway Decode failed: %v", err)
+	}
+
+	// leeway also runs past expiry, but only so far
+	clientClock.now = start.Add(time.Minute + 5*time.Second)
+	if err := decoded.Decode(issued.Encoded(), tolerant); err != nil {
+		t.Fatalf("Decode within leeway of expiry failed: %v", err)
+	}
+	clientClock.now = start.Add(time.Minute + 11*time.Second)
+	if err := decoded.Decode(issued.Encoded(), tolerant); !errors.Is(err, tokens.ErrTokenExpired()) {
+		t.Fatalf("Decode past leeway err = %v, want ErrTokenExpired", err)
+	}
+}
@@ -7,10 +7,11 @@
 //   - Server: Issues and validates tokens using a private signing key
 //   - Client: Validates tokens using a public verification key
 //
-// The package defines two token types:
+// The package defines three token types:
 //
 //   - AccessToken: Short-lived tokens for API authorization
 //   - RefreshToken: Long-lived tokens for obtaining new access tokens (includes CSRF secret)
+//   - IDToken: OIDC-style tokens carrying the subject and released profile claims
 //
 // # Server Usage (Issuing Tokens)
 //
@@ -45,6 +46,28 @@
 //	// Get encoded token string for transmission
 //	tokenString := accessToken.Encoded()
 //
+// IssueTokenPair issues both at once, as a login or refresh does, stamping
+// them with the same issued-at time.
+//
+// NewAccessToken and NewRefreshToken build the same tokens field by field,
+// which leaves room for claims the positional methods do not take:
+//
+//	accessToken, err := issuer.NewAccessToken().
+//	    Subject("opaque-subject").
+//	    Audience("app.example.com").
+//	    Scopes("identity").
+//	    Lifetime(time.Hour).
+//	    Claim("tenant", "acme").
+//	    Issue()
+//
+// Custom claims are read back with AccessToken.Claim. Validators that set
+// ParseOptions.DisallowUnknownClaims reject them.
+//
+// To keep the private key off disk, set Signer instead of SigningKey. Any
+// crypto.Signer with a P-256 ECDSA public key works, such as one backed by an
+// HSM, TPM, cloud KMS, or YubiKey. InitServer panics on any other key, so
+// check it first with ServerOptions.PublicKey or SignerPublicKey.
+//
 // # Client Usage (Validating Tokens)
 //
 // Backend applications use InitClient to validate tokens issued by the
@@ -85,10 +108,45 @@
 //	    // Token not intended for this application
 //	case errors.Is(err, tokens.ErrTokenBadSignature()):
 //	    // Token signature verification failed
+//	case errors.Is(err, tokens.ErrTokenUnsigned()), errors.Is(err, tokens.ErrTokenNoAlgorithm()):
+//	    // Token header declares no signature algorithm
+//	case errors.Is(err, tokens.ErrTokenTooLarge()):
+//	    // Token is longer than the validator accepts
 //	case errors.Is(err, tokens.ErrTokenMalformed()):
 //	    // Token structure is invalid
 //	}
 //
+// # Parse Options
+//
+// ServerOptions and ClientOptions take ParseOptions that set the longest
+// token a validator will parse (DefaultMaxTokenLength unless set) and can
+// reject claims the token type doesn't define or fields repeated within a
+// token's header or claims.
+//
+// # Clocks
+//
+// ServerOptions and ClientOptions also take a Clock, which stamps issued
+// tokens and decides whether they are expired. It defaults to the system
+// clock; tests can supply one they advance by hand.
+//
+// ClientOptions.Leeway, or WithLeeway for any validator, tolerates clock skew
+// between an app and the consent server: tokens are accepted that far before
+// they were issued and after they expire. A few seconds avoids "not issued
+// yet" failures right after a refresh from a server whose clock runs ahead.
+//
+// # Audiences
+//
+// A Client accepts tokens whose audience includes ValidAudience or one of
+// ValidAudiences. Set AudienceMatch to AudienceMatchSubdomain or
+// AudienceMatchGlob to accept per-tenant audiences, such as
+// tenant1.app.example.com, without a validator per tenant.
+//
+// # Inspecting Tokens
+//
+// ParseUnverified reads a token's header and claims without checking its
+// signature or claims, for debugging and logging, such as recording who an
+// expired token belonged to. Never authorize a request with its result.
+//
 // # CSRF Protection with Refresh Tokens
 //
 // Refresh tokens include a CSRF secret that can be used to protect
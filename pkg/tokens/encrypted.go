@@ -0,0 +1,298 @@
+package tokens
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// encryptedKeyIterations is the PBKDF2 work factor EncryptPrivateKey uses.
+const encryptedKeyIterations = 600_000
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// errIncorrectPassphrase is returned when an encrypted key doesn't decrypt,
+// which almost always means the passphrase is wrong.
+var errIncorrectPassphrase = errors.New("incorrect passphrase")
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// IsEncryptedPrivateKey reports whether data is a passphrase-encrypted
+// PKCS#8 key, either PEM ("ENCRYPTED PRIVATE KEY") or DER.
+func IsEncryptedPrivateKey(
+	data []byte,
+) bool {
+	_, ok := encryptedKeyInfo(data)
+	return ok
+}
+
+// ParseEncryptedPrivateKey decrypts and parses a P-256 signing key encrypted
+// as PKCS#8 with PBES2, as `openssl pkcs8 -topk8 -v2 aes-256-cbc` writes it.
+// PBKDF2 with HMAC-SHA1, SHA-256, or SHA-512 and AES-CBC are supported.
+func ParseEncryptedPrivateKey(
+	data []byte,
+	passphrase []byte,
+) (
+	*ecdsa.PrivateKey,
+	error,
+) {
+	info, ok := encryptedKeyInfo(data)
+	if !ok {
+		return nil, fmt.Errorf("not an encrypted PKCS#8 private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %s, want PBES2", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %v", err)
+	}
+
+	block, iv, keyLength, err := parseEncryptionScheme(params.EncryptionScheme)
+	if err != nil {
+		return nil, err
+	}
+	derivedKey, err := deriveKey(params.KeyDerivationFunc, passphrase, keyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := info.EncryptedData
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted key length")
+	}
+	c, err := block(derivedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encryption: %v", err)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(plaintext, ciphertext)
+	der, err := unpad(plaintext)
+	if err != nil {
+		return nil, errIncorrectPassphrase
+	}
+
+	key, err := ParsePrivateKey(der)
+	if err != nil {
+		return nil, errIncorrectPassphrase
+	}
+	return key, nil
+}
+
+// EncryptPrivateKey encrypts key with passphrase as a PEM "ENCRYPTED PRIVATE
+// KEY", using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC. openssl and
+// ParseEncryptedPrivateKey can both read it.
+func EncryptPrivateKey(
+	key *ecdsa.PrivateKey,
+	passphrase []byte,
+) (
+	[]byte,
+	error,
+) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase required")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode private key: %v", err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %v", err)
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("generate iv: %v", err)
+	}
+	derivedKey, err := pbkdf2.Key(sha256.New, string(passphrase), salt, encryptedKeyIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %v", err)
+	}
+	c, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %v", err)
+	}
+	ciphertext := pad(der)
+	cipher.NewCBCEncrypter(c, iv).CryptBlocks(ciphertext, ciphertext)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: encryptedKeyIterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode kdf parameters: %v", err)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, fmt.Errorf("encode iv: %v", err)
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode encryption parameters: %v", err)
+	}
+	encrypted, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: ciphertext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode encrypted key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}), nil
+}
+
+// encryptedKeyInfo decodes data as an EncryptedPrivateKeyInfo.
+func encryptedKeyInfo(
+	data []byte,
+) (
+	encryptedPrivateKeyInfo,
+	bool,
+) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "ENCRYPTED PRIVATE KEY" {
+			return encryptedPrivateKeyInfo{}, false
+		}
+		der = block.Bytes
+	}
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil || len(rest) > 0 || len(info.Algorithm.Algorithm) == 0 {
+		return encryptedPrivateKeyInfo{}, false
+	}
+	return info, true
+}
+
+// parseEncryptionScheme returns the AES-CBC cipher, IV, and key length an
+// encryption scheme names.
+func parseEncryptionScheme(
+	scheme pkix.AlgorithmIdentifier,
+) (
+	func([]byte) (cipher.Block, error),
+	[]byte,
+	int,
+	error,
+) {
+	var keyLength int
+	switch {
+	case scheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case scheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case scheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, nil, 0, fmt.Errorf("unsupported key cipher %s, want AES-CBC", scheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(scheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, nil, 0, fmt.Errorf("invalid AES-CBC iv")
+	}
+	return aes.NewCipher, iv, keyLength, nil
+}
+
+// deriveKey runs the PBKDF2 function kdf describes over passphrase.
+func deriveKey(
+	kdf pkix.AlgorithmIdentifier,
+	passphrase []byte,
+	keyLength int,
+) (
+	[]byte,
+	error,
+) {
+	if !kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s, want PBKDF2", kdf.Algorithm)
+	}
+	var params pbkdf2Params
+	if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+	}
+	if params.KeyLength != 0 && params.KeyLength != keyLength {
+		return nil, fmt.Errorf("PBKDF2 key length %d doesn't match cipher", params.KeyLength)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(params.PRF.Algorithm) == 0, params.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case params.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	case params.PRF.Algorithm.Equal(oidHMACSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 hash %s", params.PRF.Algorithm)
+	}
+	key, err := pbkdf2.Key(prf, string(passphrase), params.Salt, params.Iterations, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %v", err)
+	}
+	return key, nil
+}
+
+// pad applies PKCS#7 padding to a fresh copy of data.
+func pad(
+	data []byte,
+) []byte {
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	return append(bytes.Clone(data), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+// unpad strips PKCS#7 padding.
+func unpad(
+	data []byte,
+) (
+	[]byte,
+	error,
+) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize || n > len(data) {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return data[:len(data)-n], nil
+}
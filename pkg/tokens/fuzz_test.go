@@ -0,0 +1,166 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFuzzClient returns a client validator for "app" on "consent.test" and
+// the server that issues tokens it accepts.
+func newFuzzClient(f *testing.F) (*Server, *Client) {
+	f.Helper()
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.Fatalf("failed to generate key: %v", err)
+	}
+	server := &Server{
+		signer:          signingKey,
+		verificationKey: &signingKey.PublicKey,
+		issuerDomain:    "consent.test",
+	}
+	client := &Client{
+		verificationKey: &signingKey.PublicKey,
+		issuerDomain:    "consent.test",
+		validAudiences:  []string{"app"},
+	}
+	return server, client
+}
+
+func FuzzDecodeToken(f *testing.F) {
+	server, client := newFuzzClient(f)
+
+	access, err := server.IssueAccessToken("alice", []string{"app"}, []string{"identity"}, time.Hour)
+	if err != nil {
+		f.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	refresh, err := server.IssueRefreshToken("alice", []string{"app"}, nil, time.Hour)
+	if err != nil {
+		f.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+	id, err := server.IssueIDToken("alice", []string{"app"}, IDTokenProfile{Name: "Alice"}, time.Hour)
+	if err != nil {
+		f.Fatalf("IssueIDToken failed: %v", err)
+	}
+	oversized, err := server.IssueAccessToken(strings.Repeat("a", DefaultMaxTokenLength), []string{"app"}, nil, time.Hour)
+	if err != nil {
+		f.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	valid := access.Encoded()
+	parts := strings.Split(valid, ".")
+	header, claims := parts[0], parts[1]
+	// well-formed tokens
+	f.Add(valid)
+	f.Add(refresh.Encoded())
+	f.Add(id.Encoded())
+	// truncated tokens
+	f.Add(valid[:len(valid)/2])
+	f.Add(valid[:len(valid)-1])
+	f.Add(header + "." + claims)
+	f.Add(header + "." + claims + ".")
+	// malformed tokens
+	f.Add("")
+	f.Add("..")
+	f.Add("a.b.c.d")
+	f.Add("!!!.???.***")
+	f.Add(valid + "." + claims)
+	f.Add("eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + claims + ".")
+	// oversized tokens
+	f.Add(oversized.Encoded())
+	f.Add(strings.Repeat(".", DefaultMaxTokenLength))
+
+	f.Fuzz(func(t *testing.T, token string) {
+		results := []struct {
+			name string
+			ok   bool
+		}{
+			{"access", decodeOK[*AccessTokenClaims](token, client)},
+			{"refresh", decodeOK[*RefreshTokenClaims](token, client)},
+			{"id", decodeOK[*IDTokenClaims](token, client)},
+		}
+		for _, result := range results {
+			if result.ok && len(token) > DefaultMaxTokenLength {
+				t.Fatalf("%s: decoded a %d byte token, want rejected over %d", result.name, len(token), DefaultMaxTokenLength)
+			}
+		}
+	})
+}
+
+func decodeOK[T claims](token string, validator Validator) bool {
+	claims, err := decodeToken[T](token, validator)
+	if err != nil {
+		return false
+	}
+	return claims != nil
+}
+
+func FuzzDecodeSignature(f *testing.F) {
+	f.Add(make([]byte, 64))
+	f.Add(make([]byte, 63))
+	f.Add(make([]byte, 65))
+	f.Add([]byte{})
+
+	limit := new(big.Int).Lsh(big.NewInt(1), 256)
+	f.Fuzz(func(t *testing.T, signature []byte) {
+		r, s, err := decodeSignature(signature)
+		if len(signature) != 64 {
+			if err == nil {
+				t.Fatalf("decoded a %d byte signature, want error", len(signature))
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("decodeSignature failed: %v", err)
+		}
+		if r.Cmp(limit) >= 0 || s.Cmp(limit) >= 0 {
+			t.Fatalf("r or s wider than 32 bytes: %x, %x", r.Bytes(), s.Bytes())
+		}
+	})
+}
+
+func FuzzClaimsValidate(f *testing.F) {
+	_, client := newFuzzClient(f)
+
+	now := time.Now().Unix()
+	f.Add(now+3600, now, "consent.test", "app")
+	f.Add(now-1, now-3600, "consent.test", "app")
+	f.Add(now+3600, now+3600, "consent.test", "app")
+	f.Add(now+3600, now, "evil.test", "app")
+	f.Add(now+3600, now, "consent.test", "other app")
+	f.Add(now+3600, now, "consent.test", "app  other")
+	f.Add(int64(0), int64(0), "", "")
+
+	f.Fuzz(func(t *testing.T, exp int64, iat int64, iss string, aud string) {
+		before := time.Now()
+		err := (&AccessTokenClaims{
+			Expiration: exp,
+			IssuedAt:   iat,
+			Issuer:     iss,
+			Audience:   aud,
+			Subject:    "alice",
+		}).validate(client)
+		after := time.Now()
+		if err != nil {
+			return
+		}
+
+		if time.Unix(iat, 0).After(after) {
+			t.Errorf("accepted iat %d in the future", iat)
+		}
+		if time.Unix(exp, 0).Before(before.Truncate(time.Second)) {
+			t.Errorf("accepted exp %d in the past", exp)
+		}
+		if iss != "consent.test" {
+			t.Errorf("accepted issuer %q", iss)
+		}
+		if !slices.Contains(strings.Split(aud, " "), "app") {
+			t.Errorf("accepted audience %q", aud)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package tokens_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestIDToken_Decode_Valid(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+
+	profile := tokens.IDTokenProfile{
+		Handle:  "alice",
+		Name:    "Alice",
+		Email:   "alice@example.com",
+		Picture: "https://example.com/alice.png",
+		Groups:  []string{"admin", "staff"},
+	}
+	original, err := issuer.IssueIDToken("user", []string{"aud"}, profile, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+
+	// decode succeeds and profile claims round-trip
+	decoded := &tokens.IDToken{}
+	if err := decoded.Decode(original.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Subject() != "user" {
+		t.Errorf("Subject = %s, want user", decoded.Subject())
+	}
+	if decoded.Issuer() != "test.domain" {
+		t.Errorf("Issuer = %s, want test.domain", decoded.Issuer())
+	}
+	if !reflect.DeepEqual(decoded.Profile(), profile) {
+		t.Errorf("Profile = %+v, want %+v", decoded.Profile(), profile)
+	}
+}
+
+func decodeTestClaims(t *testing.T, encoded string) map[string]any {
+	t.Helper()
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 token parts, got %d", len(parts))
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("failed to decode claims: %v", err)
+	}
+	claims := map[string]any{}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatalf("failed to unmarshal claims: %v", err)
+	}
+	return claims
+}
+
+func TestIDToken_OmitsEmptyProfileClaims(t *testing.T) {
+	t.Parallel()
+	issuer, _ := newTestServer(t, "test.domain")
+
+	token, err := issuer.IssueIDToken("user", []string{"aud"}, tokens.IDTokenProfile{}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+
+	claims := decodeTestClaims(t, token.Encoded())
+	for _, key := range []string{"preferred_username", "name", "email", "picture"} {
+		if _, ok := claims[key]; ok {
+			t.Errorf("expected %s claim to be omitted", key)
+		}
+	}
+}
+
+func TestIDToken_Decode_Expired(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+
+	original, err := issuer.IssueIDToken("user", []string{"aud"}, tokens.IDTokenProfile{}, -time.Hour)
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+
+	decoded := &tokens.IDToken{}
+	err = decoded.Decode(original.Encoded(), validator)
+	if err == nil {
+		t.Fatal("expected error for expired token")
+	}
+	if !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected error about expiration, got %v", err)
+	}
+}
+
+func TestIDToken_Decode_ClientAudience(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+	issuer, _ := newTestServerWithKey(t, key, "test.domain")
+
+	token, err := issuer.IssueIDToken("user", []string{"app"}, tokens.IDTokenProfile{}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+
+	// client for the same audience accepts the token
+	accepting := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "test.domain",
+		ValidAudience:   "app",
+	})
+	if err := new(tokens.IDToken).Decode(token.Encoded(), accepting); err != nil {
+		t.Errorf("expected client for app to accept token: %v", err)
+	}
+
+	// client for another audience rejects it
+	rejecting := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "test.domain",
+		ValidAudience:   "other",
+	})
+	if err := new(tokens.IDToken).Decode(token.Encoded(), rejecting); err == nil {
+		t.Error("expected client for other audience to reject token")
+	}
+}
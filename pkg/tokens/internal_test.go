@@ -2,9 +2,16 @@ package tokens
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Tests for encodeSignature/decodeSignature
@@ -83,6 +90,72 @@ func TestDecodeSignature_InvalidLength(t *testing.T) {
 	}
 }
 
+func FuzzEncodeSignature_RoundTrip(f *testing.F) {
+	f.Add(bytes.Repeat([]byte{0xFF}, 32), bytes.Repeat([]byte{0xAB}, 32))
+	f.Add(bytes.Repeat([]byte{0xFF}, 31), bytes.Repeat([]byte{0xAB}, 32))
+	f.Add(bytes.Repeat([]byte{0xFF}, 32), bytes.Repeat([]byte{0xAB}, 31))
+	f.Add([]byte{0x01}, []byte{0x00})
+	f.Add([]byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, rIn []byte, sIn []byte) {
+		// r and s are P-256 scalars, so never wider than 32 bytes
+		if len(rIn) > 32 {
+			rIn = rIn[:32]
+		}
+		if len(sIn) > 32 {
+			sIn = sIn[:32]
+		}
+		r := new(big.Int).SetBytes(rIn)
+		s := new(big.Int).SetBytes(sIn)
+
+		encoded, err := encodeSignature(r, s)
+		if err != nil {
+			t.Fatalf("encodeSignature failed: %v", err)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("base64 decode failed: %v", err)
+		}
+		if len(decoded) != 64 {
+			t.Fatalf("length = %d, want 64", len(decoded))
+		}
+
+		rDec, sDec, err := decodeSignature(decoded)
+		if err != nil {
+			t.Fatalf("decodeSignature failed: %v", err)
+		}
+		if r.Cmp(rDec) != 0 || s.Cmp(sDec) != 0 {
+			t.Fatalf("round trip mismatch: got (%x, %x), want (%x, %x)",
+				rDec.Bytes(), sDec.Bytes(), r.Bytes(), s.Bytes())
+		}
+	})
+}
+
+func FuzzSignHash_Verifies(f *testing.F) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.Fatalf("failed to generate key: %v", err)
+	}
+	server := &Server{
+		signer:          signingKey,
+		verificationKey: &signingKey.PublicKey,
+	}
+
+	f.Add("header", "claims")
+	f.Add("", "")
+	f.Add("eyJhbGciOiJFUzI1NiIsInR5cCI6IkpXVCJ9", "e30")
+
+	f.Fuzz(func(t *testing.T, encHeader string, encClaims string) {
+		encSignature, err := server.SignHash(hashMessage(buildMessage(encHeader, encClaims)))
+		if err != nil {
+			t.Fatalf("SignHash failed: %v", err)
+		}
+		if err := verifySignature(encHeader, encClaims, encSignature, &signingKey.PublicKey); err != nil {
+			t.Fatalf("signature did not verify: %v", err)
+		}
+	})
+}
+
 // Tests for JWT section encoding/decoding
 
 func TestEncodeDecodeJWTSection_RoundTrip(t *testing.T) {
@@ -199,6 +272,100 @@ func TestVerifyHeader(t *testing.T) {
 	}
 }
 
+// Tests for parse options
+
+// signTestSections signs raw header and claims JSON with server, so tests can
+// build tokens this package would never issue.
+func signTestSections(t *testing.T, server *Server, header string, claims string) string {
+	t.Helper()
+	encHeader := base64.RawURLEncoding.EncodeToString([]byte(header))
+	encClaims := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	message := buildMessage(encHeader, encClaims)
+	encSignature, err := server.SignHash(hashMessage(message))
+	if err != nil {
+		t.Fatalf("SignHash failed: %v", err)
+	}
+	return message + "." + encSignature
+}
+
+func newParseTestServer(t *testing.T, options ParseOptions) *Server {
+	t.Helper()
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	issuer, _ := InitServer(ServerOptions{
+		SigningKey:   signingKey,
+		IssuerDomain: "consent.test",
+		Parse:        options,
+	})
+	return issuer.(*Server)
+}
+
+func TestDecodeToken_ParseOptions(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Unix()
+	header := `{"alg":"ES256","typ":"JWT"}`
+	claims := fmt.Sprintf(`{"exp":%d,"iat":%d,"iss":"consent.test","aud":"app","sub":"alice"`, now+3600, now)
+
+	tests := []struct {
+		name    string
+		options ParseOptions
+		header  string
+		claims  string
+		wantErr error
+	}{
+		{"valid", ParseOptions{}, header, claims + `}`, nil},
+		{"none algorithm", ParseOptions{}, `{"alg":"none","typ":"JWT"}`, claims + `}`, errTokenUnsigned},
+		{"none algorithm uppercase", ParseOptions{}, `{"alg":"NONE","typ":"JWT"}`, claims + `}`, errTokenUnsigned},
+		{"missing algorithm", ParseOptions{}, `{"typ":"JWT"}`, claims + `}`, errTokenNoAlgorithm},
+		{"other algorithm", ParseOptions{}, `{"alg":"HS256","typ":"JWT"}`, claims + `}`, errTokenBadSignature},
+		{"too large", ParseOptions{MaxTokenLength: 64}, header, claims + `}`, errTokenTooLarge},
+		{"unknown claim allowed", ParseOptions{}, header, claims + `,"admin":true}`, nil},
+		{"unknown claim disallowed", ParseOptions{DisallowUnknownClaims: true}, header, claims + `,"admin":true}`, errTokenMalformed},
+		{"duplicate claim allowed", ParseOptions{}, header, claims + `,"sub":"mallory"}`, nil},
+		{"duplicate claim disallowed", ParseOptions{DisallowDuplicateFields: true}, header, claims + `,"sub":"mallory"}`, errTokenMalformed},
+		{"duplicate header disallowed", ParseOptions{DisallowDuplicateFields: true}, `{"alg":"ES256","typ":"JWT","alg":"none"}`, claims + `}`, errTokenMalformed},
+		{"trailing data", ParseOptions{}, header, claims + `} {}`, errTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := newParseTestServer(t, tt.options)
+			token := signTestSections(t, server, tt.header, tt.claims)
+
+			_, err := decodeToken[*AccessTokenClaims](token, server)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("decodeToken failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected %v", tt.wantErr)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeToken_DefaultMaxTokenLength(t *testing.T) {
+	t.Parallel()
+	server := newParseTestServer(t, ParseOptions{})
+	token, err := server.IssueAccessToken(strings.Repeat("a", DefaultMaxTokenLength), []string{"app"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	_, verr := decodeToken[*AccessTokenClaims](token.Encoded(), server)
+	if verr == nil || !errors.Is(verr, errTokenTooLarge) {
+		t.Fatalf("err = %v, want %v", verr, errTokenTooLarge)
+	}
+}
+
 // Tests for helper functions
 
 func TestBuildMessage(t *testing.T) {
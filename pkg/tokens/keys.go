@@ -0,0 +1,127 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadPrivateKeyFile reads a signing key from path. See ParsePrivateKey for
+// the formats it accepts.
+func LoadPrivateKeyFile(
+	path string,
+) (
+	*ecdsa.PrivateKey,
+	error,
+) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key %s: %w", path, err)
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadPublicKeyFile reads a verification key from path. See ParsePublicKey
+// for the formats it accepts.
+func LoadPublicKeyFile(
+	path string,
+) (
+	*ecdsa.PublicKey,
+	error,
+) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read verification key %s: %w", path, err)
+	}
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse verification key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParsePrivateKey parses a P-256 signing key encoded as SEC1 or PKCS#8,
+// either raw DER (as `consent keygen` writes) or PEM (as openssl writes).
+func ParsePrivateKey(
+	data []byte,
+) (
+	*ecdsa.PrivateKey,
+	error,
+) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "EC PRIVATE KEY", "PRIVATE KEY":
+			der = block.Bytes
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("key is encrypted; decrypt it with ParseEncryptedPrivateKey")
+		default:
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return checkCurve(key, &key.PublicKey)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		if IsEncryptedPrivateKey(der) {
+			return nil, fmt.Errorf("key is encrypted; decrypt it with ParseEncryptedPrivateKey")
+		}
+		return nil, fmt.Errorf("not a SEC1 or PKCS#8 private key")
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an ECDSA private key")
+	}
+	return checkCurve(key, &key.PublicKey)
+}
+
+// ParsePublicKey parses a P-256 verification key encoded as PKIX, either raw
+// DER (as `consent keygen` writes) or PEM (as openssl writes).
+func ParsePublicKey(
+	data []byte,
+) (
+	*ecdsa.PublicKey,
+	error,
+) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		der = block.Bytes
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("not a PKIX public key")
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an ECDSA public key")
+	}
+	return checkCurve(key, key)
+}
+
+// checkCurve returns key if public is on P-256, the only curve ES256 allows.
+func checkCurve[K any](
+	key K,
+	public *ecdsa.PublicKey,
+) (
+	K,
+	error,
+) {
+	if public.Curve != elliptic.P256() {
+		var zero K
+		return zero, fmt.Errorf("key uses %s, want P-256", public.Curve.Params().Name)
+	}
+	return key, nil
+}
@@ -0,0 +1,155 @@
+package tokens_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestParsePrivateKey_Formats(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"SEC1 DER", sec1},
+		{"PKCS#8 DER", pkcs8},
+		{"SEC1 PEM", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})},
+		{"PKCS#8 PEM", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := tokens.ParsePrivateKey(tt.data)
+			if err != nil {
+				t.Fatalf("ParsePrivateKey failed: %v", err)
+			}
+			if !parsed.Equal(key) {
+				t.Error("parsed key does not match")
+			}
+		})
+	}
+}
+
+func TestParsePublicKey_Formats(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"PKIX DER", pkix},
+		{"PKIX PEM", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := tokens.ParsePublicKey(tt.data)
+			if err != nil {
+				t.Fatalf("ParsePublicKey failed: %v", err)
+			}
+			if !parsed.Equal(&key.PublicKey) {
+				t.Error("parsed key does not match")
+			}
+		})
+	}
+}
+
+func TestParseKeys_Rejects(t *testing.T) {
+	t.Parallel()
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	p384Private, err := x509.MarshalECPrivateKey(p384)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	p384Public, err := x509.MarshalPKIXPublicKey(&p384.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	p256Public, err := x509.MarshalPKIXPublicKey(&getSharedTestKey(t).PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+
+	if _, err := tokens.ParsePrivateKey(p384Private); err == nil {
+		t.Error("expected error for P-384 private key")
+	}
+	if _, err := tokens.ParsePublicKey(p384Public); err == nil {
+		t.Error("expected error for P-384 public key")
+	}
+	if _, err := tokens.ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Error("expected error for garbage private key")
+	}
+	if _, err := tokens.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: p256Public})); err == nil {
+		t.Error("expected error for public key PEM as private key")
+	}
+	if _, err := tokens.ParsePublicKey(p256Public[:10]); err == nil {
+		t.Error("expected error for truncated public key")
+	}
+}
+
+func TestLoadKeyFiles(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+	dir := t.TempDir()
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+	}
+	privatePath := filepath.Join(dir, "signing_key.pem")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	publicPath := filepath.Join(dir, "verification_key.der")
+	if err := os.WriteFile(publicPath, pkix, 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	private, err := tokens.LoadPrivateKeyFile(privatePath)
+	if err != nil {
+		t.Fatalf("LoadPrivateKeyFile failed: %v", err)
+	}
+	public, err := tokens.LoadPublicKeyFile(publicPath)
+	if err != nil {
+		t.Fatalf("LoadPublicKeyFile failed: %v", err)
+	}
+	if !private.PublicKey.Equal(public) {
+		t.Error("loaded keys do not match")
+	}
+
+	if _, err := tokens.LoadPublicKeyFile(filepath.Join(dir, "missing.der")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
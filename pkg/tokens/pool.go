@@ -0,0 +1,55 @@
+package tokens
+
+import (
+	"errors"
+)
+
+var errSignerBusy = errors.New("signer busy")
+
+// ErrSignerBusy returns an error indicating a token wasn't signed because
+// the server's signing queue was full. Callers should ask the client to
+// retry shortly.
+func ErrSignerBusy() error { return errSignerBusy }
+
+// signPool bounds how many signatures are computed at once and how many
+// may wait their turn, so a burst of issuance, such as many clients
+// refreshing together, queues briefly and then fails fast rather than
+// piling up unbounded work.
+type signPool struct {
+	workers chan struct{}
+	slots   chan struct{}
+}
+
+// newSignPool returns a pool of workers signers with room for queue more to
+// wait, or nil, which never limits, when workers isn't positive.
+func newSignPool(
+	workers int,
+	queue int,
+) *signPool {
+	if workers <= 0 {
+		return nil
+	}
+	return &signPool{
+		workers: make(chan struct{}, workers),
+		slots:   make(chan struct{}, workers+max(queue, 0)),
+	}
+}
+
+// do runs sign on a worker, waiting for one if all are busy. It returns
+// errSignerBusy without running sign when the queue is full.
+func (p *signPool) do(sign func() error) error {
+	if p == nil {
+		return sign()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return errSignerBusy
+	}
+	defer func() { <-p.slots }()
+
+	p.workers <- struct{}{}
+	defer func() { <-p.workers }()
+	return sign()
+}
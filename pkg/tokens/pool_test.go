@@ -0,0 +1,93 @@
+package tokens_test
+
+import (
+	"crypto"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// gatedSigner signs only once its gate is opened, reporting each signature
+// as it starts.
+type gatedSigner struct {
+	opaqueSigner
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (s gatedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.started <- struct{}{}
+	<-s.gate
+	return s.opaqueSigner.Sign(rand, digest, opts)
+}
+
+func newGatedServer(
+	t *testing.T,
+	queue int,
+) (
+	tokens.Issuer,
+	gatedSigner,
+) {
+	t.Helper()
+	signer := gatedSigner{
+		opaqueSigner: opaqueSigner{key: getSharedTestKey(t)},
+		started:      make(chan struct{}, 4),
+		gate:         make(chan struct{}),
+	}
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		Signer:       signer,
+		IssuerDomain: "test.domain",
+		SignWorkers:  1,
+		SignQueue:    queue,
+	})
+	return issuer, signer
+}
+
+func issueInBackground(issuer tokens.Issuer) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour)
+		result <- err
+	}()
+	return result
+}
+
+func TestInitServer_SignWorkersFailFast(t *testing.T) {
+	t.Parallel()
+	issuer, signer := newGatedServer(t, 0)
+
+	// while the only worker signs, with no queue, more tokens fail fast
+	first := issueInBackground(issuer)
+	<-signer.started
+	if _, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour); !errors.Is(err, tokens.ErrSignerBusy()) {
+		t.Fatalf("IssueAccessToken err = %v, want ErrSignerBusy", err)
+	}
+
+	// and succeed again once it is free
+	close(signer.gate)
+	if err := <-first; err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	if _, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour); err != nil {
+		t.Fatalf("IssueAccessToken after busy failed: %v", err)
+	}
+}
+
+func TestInitServer_SignQueueWaits(t *testing.T) {
+	t.Parallel()
+	issuer, signer := newGatedServer(t, 1)
+
+	// a token issued while the worker is busy waits for it
+	first := issueInBackground(issuer)
+	<-signer.started
+	second := issueInBackground(issuer)
+	close(signer.gate)
+	for _, result := range []<-chan error{first, second} {
+		if err := <-result; err != nil {
+			t.Errorf("IssueAccessToken failed: %v", err)
+		}
+	}
+}
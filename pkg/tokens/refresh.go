@@ -11,37 +11,25 @@ import (
 // It is validated against the provided Validator and current time.
 // It implements the `validate()` function as part of the [claims] interface.
 type RefreshTokenClaims struct {
-	Expiration int64  `json:"exp"`
-	IssuedAt   int64  `json:"iat"`
-	Issuer     string `json:"iss"`
-	Audience   string `json:"aud"`
-	Subject    string `json:"sub"`
-	Scopes     string `json:"scopes,omitempty"`
-	Secret     string `json:"secret"`
+	Expiration  int64  `json:"exp"`
+	IssuedAt    int64  `json:"iat"`
+	Issuer      string `json:"iss"`
+	Audience    string `json:"aud"`
+	Subject     string `json:"sub"`
+	Scopes      string `json:"scopes,omitempty"`
+	Secret      string `json:"secret"`
+	SessionOnly bool   `json:"session_only,omitempty"`
+	AuthTime    int64  `json:"auth_time,omitempty"`
 }
 
 func (claims *RefreshTokenClaims) validate(validator Validator) error {
-	now := time.Now()
-
-	if time.Unix(claims.IssuedAt, 0).After(now) {
-		return ErrTokenNotIssued()
-	}
-
-	if time.Unix(claims.Expiration, 0).Before(now) {
-		return ErrTokenExpired()
-	}
-
-	if !validator.ValidateDomain(claims.Issuer) {
-		return ErrTokenInvalidIssuer()
-	}
-
-	if validator.ShouldValidateAudience() {
-		if !validator.ValidateAudiences(claims.Audience) {
-			return ErrTokenInvalidAudience()
-		}
-	}
-
-	return nil
+	return validateStandardClaims(
+		validator,
+		claims.IssuedAt,
+		claims.Expiration,
+		claims.Issuer,
+		claims.Audience,
+	)
 }
 
 // ==============================================
@@ -52,15 +40,21 @@ func (claims *RefreshTokenClaims) validate(validator Validator) error {
 // Refresh tokens are typically valid for weeks or months and should be stored in
 // secure HTTP-only cookies. The included CSRF secret must be provided by the client
 // when using the token to refresh, protecting against CSRF attacks.
+//
+// A session-only refresh token belongs to a sign-in the user didn't ask to
+// keep; clients should store it in a cookie that ends with the browser session.
+// AuthTime carries the sign-in time over to the access tokens it refreshes.
 type RefreshToken struct {
-	issuer     string
-	issuedAt   time.Time
-	expiration time.Time
-	audience   []string
-	subject    string
-	scopes     []string
-	secret     string
-	encoded    string
+	issuer      string
+	issuedAt    time.Time
+	expiration  time.Time
+	audience    []string
+	subject     string
+	scopes      []string
+	secret      string
+	sessionOnly bool
+	authTime    time.Time
+	encoded     string
 }
 
 func (t *RefreshToken) Issuer() string        { return t.issuer }
@@ -70,6 +64,8 @@ func (t *RefreshToken) Audience() []string    { return t.audience }
 func (t *RefreshToken) Subject() string       { return t.subject }
 func (t *RefreshToken) Scopes() []string      { return append([]string(nil), t.scopes...) }
 func (t *RefreshToken) Secret() string        { return t.secret }
+func (t *RefreshToken) SessionOnly() bool     { return t.sessionOnly }
+func (t *RefreshToken) AuthTime() time.Time   { return t.authTime }
 func (t *RefreshToken) Encoded() string       { return t.encoded }
 
 func (token *RefreshToken) Decode(encToken string, validator Validator) error {
@@ -77,7 +73,7 @@ func (token *RefreshToken) Decode(encToken string, validator Validator) error {
 	if err != nil {
 		if true {
 			// TODO: make this actually check log level
-			log.Println(err.Context())
+			log.Println(err)
 		}
 		return err
 	}
@@ -94,6 +90,8 @@ func (token *RefreshToken) intoClaims() *RefreshTokenClaims {
 	claims.Subject = token.subject
 	claims.Scopes = strings.Join(token.scopes, " ")
 	claims.Secret = token.secret
+	claims.SessionOnly = token.sessionOnly
+	claims.AuthTime = unixOrZero(token.authTime)
 	return claims
 }
 
@@ -105,5 +103,7 @@ func (token *RefreshToken) fromClaims(claims *RefreshTokenClaims, encToken strin
 	token.subject = claims.Subject
 	token.scopes = splitClaimValues(claims.Scopes)
 	token.secret = claims.Secret
+	token.sessionOnly = claims.SessionOnly
+	token.authTime = timeOrZero(claims.AuthTime)
 	token.encoded = encToken
 }
@@ -2,18 +2,21 @@ package tokens
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"fmt"
+	"math/big"
 	"time"
 )
 
 // Server implements both Issuer and Validator interfaces for the consent auth server.
-// It holds the private signing key for issuing tokens and the corresponding public key
+// It holds the signer for issuing tokens and the corresponding public key
 // for verification. Create a Server instance using InitServer.
 type Server struct {
-	signingKey      *ecdsa.PrivateKey
+	signer          Signer
+	pool            *signPool
 	verificationKey *ecdsa.PublicKey
 	issuerDomain    string
+	parse           ParseOptions
+	clock           Clock
 }
 
 //
@@ -25,9 +28,13 @@ func (server *Server) SignHash(
 	string,
 	error,
 ) {
-	r, s, err := ecdsa.Sign(rand.Reader, server.signingKey, hash[:])
+	var r, s *big.Int
+	err := server.pool.do(func() (err error) {
+		r, s, err = signHash(server.signer, hash)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to sign message: %v", err)
+		return "", fmt.Errorf("failed to sign message: %w", err)
 	}
 	encSignature, err := encodeSignature(r, s)
 	if err != nil {
@@ -44,31 +51,46 @@ func (server *Server) IssueRefreshToken(
 ) (
 	*RefreshToken,
 	error,
+) {
+	return server.issueRefreshToken(server.currentTime(), subject, audience, scopes, lifetime, IssueOptions{})
+}
+
+func (server *Server) issueRefreshToken(
+	now time.Time,
+	subject string,
+	audience []string,
+	scopes []string,
+	lifetime time.Duration,
+	options IssueOptions,
+) (
+	*RefreshToken,
+	error,
 ) {
 	if err := validateIssuedAudiences(audience); err != nil {
 		return nil, fmt.Errorf("invalid refresh token audience: %v", err)
 	}
 
-	now := time.Now()
 	exp := now.Add(lifetime)
 	secret, err := generateCSRFCode()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate csrf secret: %v", err)
 	}
 	token := &RefreshToken{
-		issuer:     server.issuerDomain,
-		issuedAt:   now,
-		expiration: exp,
-		audience:   audience,
-		subject:    subject,
-		scopes:     scopes,
-		secret:     secret,
+		issuer:      server.issuerDomain,
+		issuedAt:    now,
+		expiration:  exp,
+		audience:    audience,
+		subject:     subject,
+		scopes:      scopes,
+		secret:      secret,
+		sessionOnly: options.SessionOnly,
+		authTime:    options.AuthTime,
 	}
 
 	claims := token.intoClaims()
 	encToken, err := encodeToken(claims, server)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode refresh token: %v", err)
+		return nil, fmt.Errorf("failed to encode refresh token: %w", err)
 	}
 	token.encoded = encToken
 
@@ -83,12 +105,41 @@ func (server *Server) IssueAccessToken(
 ) (
 	*AccessToken,
 	error,
+) {
+	return server.issueAccessToken(server.currentTime(), subject, audience, scopes, lifetime, IssueOptions{}, nil)
+}
+
+// IssueAccessTokenWithOptions is IssueAccessToken with the sign-in details in
+// options.
+func (server *Server) IssueAccessTokenWithOptions(
+	subject string,
+	audience []string,
+	scopes []string,
+	lifetime time.Duration,
+	options IssueOptions,
+) (
+	*AccessToken,
+	error,
+) {
+	return server.issueAccessToken(server.currentTime(), subject, audience, scopes, lifetime, options, nil)
+}
+
+func (server *Server) issueAccessToken(
+	now time.Time,
+	subject string,
+	audience []string,
+	scopes []string,
+	lifetime time.Duration,
+	options IssueOptions,
+	extra map[string]any,
+) (
+	*AccessToken,
+	error,
 ) {
 	if err := validateIssuedAudiences(audience); err != nil {
 		return nil, fmt.Errorf("invalid access token audience: %v", err)
 	}
 
-	now := time.Now()
 	exp := now.Add(lifetime)
 	token := &AccessToken{
 		issuer:     server.issuerDomain,
@@ -97,12 +148,108 @@ func (server *Server) IssueAccessToken(
 		audience:   audience,
 		subject:    subject,
 		scopes:     scopes,
+		authTime:   options.AuthTime,
+		actor:      options.Actor,
+		terms:      options.TermsVersion,
+		extra:      extra,
+	}
+
+	claims := token.intoClaims()
+	encodedToken, err := encodeToken(claims, server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode access token: %w", err)
+	}
+	token.encoded = encodedToken
+
+	return token, nil
+}
+
+// IssueTokenPair issues an access token and a refresh token for the same
+// grant, both stamped with the same issued-at time.
+func (server *Server) IssueTokenPair(
+	subject string,
+	audience []string,
+	scopes []string,
+	accessLifetime time.Duration,
+	refreshLifetime time.Duration,
+) (
+	*AccessToken,
+	*RefreshToken,
+	error,
+) {
+	return server.IssueTokenPairWithOptions(subject, audience, scopes, accessLifetime, refreshLifetime, IssueOptions{})
+}
+
+// IssueSessionTokenPair is IssueTokenPair for a sign-in the user didn't ask
+// to keep: the refresh token is marked session-only.
+func (server *Server) IssueSessionTokenPair(
+	subject string,
+	audience []string,
+	scopes []string,
+	accessLifetime time.Duration,
+	refreshLifetime time.Duration,
+) (
+	*AccessToken,
+	*RefreshToken,
+	error,
+) {
+	return server.IssueTokenPairWithOptions(subject, audience, scopes, accessLifetime, refreshLifetime, IssueOptions{SessionOnly: true})
+}
+
+// IssueTokenPairWithOptions is IssueTokenPair with the sign-in details in
+// options.
+func (server *Server) IssueTokenPairWithOptions(
+	subject string,
+	audience []string,
+	scopes []string,
+	accessLifetime time.Duration,
+	refreshLifetime time.Duration,
+	options IssueOptions,
+) (
+	*AccessToken,
+	*RefreshToken,
+	error,
+) {
+	now := server.currentTime()
+	accessToken, err := server.issueAccessToken(now, subject, audience, scopes, accessLifetime, options, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, err := server.issueRefreshToken(now, subject, audience, scopes, refreshLifetime, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (server *Server) IssueIDToken(
+	subject string,
+	audience []string,
+	profile IDTokenProfile,
+	lifetime time.Duration,
+) (
+	*IDToken,
+	error,
+) {
+	if err := validateIssuedAudiences(audience); err != nil {
+		return nil, fmt.Errorf("invalid id token audience: %v", err)
+	}
+
+	now := server.currentTime()
+	exp := now.Add(lifetime)
+	token := &IDToken{
+		issuer:     server.issuerDomain,
+		issuedAt:   now,
+		expiration: exp,
+		audience:   audience,
+		subject:    subject,
+		profile:    profile,
 	}
 
 	claims := token.intoClaims()
 	encodedToken, err := encodeToken(claims, server)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode access token: %v", err)
+		return nil, fmt.Errorf("failed to encode id token: %w", err)
 	}
 	token.encoded = encodedToken
 
@@ -125,6 +272,14 @@ func (server *Server) VerifySignature(
 	)
 }
 
+func (server *Server) parseOptions() ParseOptions {
+	return server.parse
+}
+
+func (server *Server) currentTime() time.Time {
+	return nowFrom(server.clock)
+}
+
 func (server *Server) ShouldValidateAudience() bool {
 	return false
 }
@@ -2,6 +2,7 @@ package tokens_test
 
 import (
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,6 +52,113 @@ func TestServer_IssueAccessToken(t *testing.T) {
 	}
 }
 
+// tickingClock moves forward a second every time it is read.
+type tickingClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *tickingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+func TestServer_IssueTokenPair(t *testing.T) {
+	t.Parallel()
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		SigningKey:   getSharedTestKey(t),
+		IssuerDomain: "test.domain",
+		Clock:        &tickingClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	// both tokens share one issued-at, even as the clock moves
+	access, refresh, err := issuer.IssueTokenPair("subject", []string{"aud"}, []string{"profile"}, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("IssueTokenPair failed: %v", err)
+	}
+	if !access.IssuedAt().Equal(refresh.IssuedAt()) {
+		t.Errorf("IssuedAt differs: access %v, refresh %v", access.IssuedAt(), refresh.IssuedAt())
+	}
+	if got := access.Expiration().Sub(access.IssuedAt()); got != time.Hour {
+		t.Errorf("access lifetime = %v, want 1h", got)
+	}
+	if got := refresh.Expiration().Sub(refresh.IssuedAt()); got != 24*time.Hour {
+		t.Errorf("refresh lifetime = %v, want 24h", got)
+	}
+	if access.Subject() != "subject" || refresh.Subject() != "subject" {
+		t.Errorf("Subject = %q/%q, want subject", access.Subject(), refresh.Subject())
+	}
+	if refresh.SessionOnly() {
+		t.Error("IssueTokenPair refresh token is session-only")
+	}
+
+	// an invalid audience fails the whole pair
+	if _, _, err := issuer.IssueTokenPair("subject", []string{""}, nil, time.Hour, time.Hour); err == nil {
+		t.Error("IssueTokenPair accepted an empty audience")
+	}
+}
+
+func TestServer_IssueSessionTokenPair(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+
+	// the refresh token is marked session-only, and the mark survives decoding
+	_, refresh, err := issuer.IssueSessionTokenPair("subject", []string{"aud"}, nil, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("IssueSessionTokenPair failed: %v", err)
+	}
+	decoded := new(tokens.RefreshToken)
+	if err := decoded.Decode(refresh.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !refresh.SessionOnly() || !decoded.SessionOnly() {
+		t.Errorf("SessionOnly = %v/%v, want true", refresh.SessionOnly(), decoded.SessionOnly())
+	}
+}
+
+func TestServer_IssueTokenPairWithOptions_AuthTime(t *testing.T) {
+	t.Parallel()
+	issuer, validator := newTestServer(t, "test.domain")
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// both tokens carry the auth time through encoding
+	access, refresh, err := issuer.IssueTokenPairWithOptions("subject", []string{"aud"}, nil, time.Hour, 24*time.Hour, tokens.IssueOptions{
+		AuthTime: authTime,
+	})
+	if err != nil {
+		t.Fatalf("IssueTokenPairWithOptions failed: %v", err)
+	}
+	decodedAccess := new(tokens.AccessToken)
+	if err := decodedAccess.Decode(access.Encoded(), validator); err != nil {
+		t.Fatalf("Decode access failed: %v", err)
+	}
+	decodedRefresh := new(tokens.RefreshToken)
+	if err := decodedRefresh.Decode(refresh.Encoded(), validator); err != nil {
+		t.Fatalf("Decode refresh failed: %v", err)
+	}
+	if !decodedAccess.AuthTime().Equal(authTime) {
+		t.Errorf("access AuthTime = %v, want %v", decodedAccess.AuthTime(), authTime)
+	}
+	if !decodedRefresh.AuthTime().Equal(authTime) {
+		t.Errorf("refresh AuthTime = %v, want %v", decodedRefresh.AuthTime(), authTime)
+	}
+
+	// tokens issued without one leave it zero
+	plain, err := issuer.IssueAccessToken("subject", []string{"aud"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	decodedPlain := new(tokens.AccessToken)
+	if err := decodedPlain.Decode(plain.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !decodedPlain.AuthTime().IsZero() {
+		t.Errorf("AuthTime = %v, want zero", decodedPlain.AuthTime())
+	}
+}
+
 func TestServer_IssueAccessToken_InvalidAudience(t *testing.T) {
 	t.Parallel()
 	issuer, _ := newTestServer(t, "test.domain")
@@ -187,3 +295,44 @@ func TestServer_MultipleAudiences(t *testing.T) {
 		t.Errorf("Audience len = %d, want 3", len(decoded.Audience()))
 	}
 }
+
+func TestServer_IndependentIssuers(t *testing.T) {
+	t.Parallel()
+	issuerA, validatorA := newTestServerWithKey(t, generateTestKey(t), "a.domain")
+	issuerB, validatorB := newTestServerWithKey(t, generateTestKey(t), "b.domain")
+
+	// issuers with their own keys and domains can run side by side, and each
+	// validator only accepts its own issuer's tokens
+	var wg sync.WaitGroup
+	for range 8 {
+		for _, pair := range []struct {
+			issuer     tokens.Issuer
+			own, other tokens.Validator
+			domain     string
+		}{
+			{issuerA, validatorA, validatorB, "a.domain"},
+			{issuerB, validatorB, validatorA, "b.domain"},
+		} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := pair.issuer.IssueAccessToken("subject", []string{"aud"}, nil, time.Minute)
+				if err != nil {
+					t.Errorf("IssueAccessToken failed: %v", err)
+					return
+				}
+				decoded := new(tokens.AccessToken)
+				if err := decoded.Decode(token.Encoded(), pair.own); err != nil {
+					t.Errorf("%s: own validator rejected token: %v", pair.domain, err)
+				}
+				if decoded.Issuer() != pair.domain {
+					t.Errorf("Issuer = %s, want %s", decoded.Issuer(), pair.domain)
+				}
+				if err := new(tokens.AccessToken).Decode(token.Encoded(), pair.other); err == nil {
+					t.Errorf("%s: other validator accepted token", pair.domain)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
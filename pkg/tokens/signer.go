@@ -0,0 +1,93 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signer signs tokens with a private key that may never be loaded into the
+// process, such as one held by an HSM, a TPM, a cloud KMS, or a YubiKey. Any
+// crypto.Signer whose public key is a P-256 ECDSA key works, including
+// *ecdsa.PrivateKey.
+type Signer = crypto.Signer
+
+// SignerPublicKey returns signer's public key, or an error if it is not a
+// P-256 ECDSA key. Check a Signer with it before passing it to InitServer.
+func SignerPublicKey(
+	signer Signer,
+) (
+	*ecdsa.PublicKey,
+	error,
+) {
+	if signer == nil {
+		return nil, errors.New("signer required")
+	}
+	key, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("signer public key is %T, want *ecdsa.PublicKey", signer.Public())
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("signer curve is %s, want P-256", key.Curve.Params().Name)
+	}
+	return key, nil
+}
+
+// PublicKey returns the public half of the key options sign with, or an error
+// if there is none or it isn't P-256.
+func (options ServerOptions) PublicKey() (
+	*ecdsa.PublicKey,
+	error,
+) {
+	return SignerPublicKey(signerOf(options))
+}
+
+// signerOf returns the Signer options describe: Signer if set, otherwise
+// SigningKey.
+func signerOf(
+	options ServerOptions,
+) Signer {
+	if options.Signer != nil {
+		return options.Signer
+	}
+	if options.SigningKey != nil {
+		return options.SigningKey
+	}
+	return nil
+}
+
+// signHash signs hash and returns the signature's r and s. Private keys sign
+// directly; other signers return ASN.1, which is unpacked.
+func signHash(
+	signer Signer,
+	hash []byte,
+) (
+	*big.Int,
+	*big.Int,
+	error,
+) {
+	if key, ok := signer.(*ecdsa.PrivateKey); ok {
+		return ecdsa.Sign(rand.Reader, key, hash)
+	}
+
+	der, err := signer.Sign(rand.Reader, hash, crypto.SHA256)
+	if err != nil {
+		return nil, nil, err
+	}
+	var signature struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(der, &signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid signer signature: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, nil, errors.New("invalid signer signature: trailing data")
+	}
+	return signature.R, signature.S, nil
+}
@@ -0,0 +1,76 @@
+package tokens_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// opaqueSigner exposes only crypto.Signer, as a hardware-backed key does.
+type opaqueSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s opaqueSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestInitServer_Signer(t *testing.T) {
+	t.Parallel()
+	key := getSharedTestKey(t)
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{
+		Signer:       opaqueSigner{key: key},
+		IssuerDomain: "test.domain",
+	})
+
+	token, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	// tokens verify against the signer's public key alone
+	validator := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &key.PublicKey,
+		IssuerDomain:    "test.domain",
+		ValidAudience:   "aud",
+	})
+	if err := new(tokens.AccessToken).Decode(token.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+}
+
+func TestSignerPublicKey_RejectsOtherKeys(t *testing.T) {
+	t.Parallel()
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for name, signer := range map[string]tokens.Signer{
+		"nil":     nil,
+		"p384":    p384,
+		"ed25519": ed,
+	} {
+		if _, err := tokens.SignerPublicKey(signer); err == nil {
+			t.Errorf("%s: SignerPublicKey() = nil error, want error", name)
+		}
+	}
+	if _, err := tokens.SignerPublicKey(getSharedTestKey(t)); err != nil {
+		t.Errorf("SignerPublicKey(P-256) failed: %v", err)
+	}
+}
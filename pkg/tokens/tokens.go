@@ -1,28 +1,49 @@
 package tokens
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"path"
+	"slices"
 	"strings"
 	"time"
 )
 
+// DefaultMaxTokenLength bounds the tokens a validator will parse unless its
+// ParseOptions set another limit, so a hostile token can't make it decode and
+// allocate arbitrarily large sections. Tokens this package issues are a small
+// fraction of it.
+const DefaultMaxTokenLength = 8192
+
+// validateError is returned by token Decode methods. It wraps one of the
+// ErrToken errors, so errors.Is and errors.As see through it, and its message
+// adds why the token failed that check.
 type validateError struct {
 	context string
 	err     error
 }
 
+// Context describes why the token failed, without the wrapped error.
 func (t *validateError) Context() string {
 	return t.context
 }
 func (t *validateError) Error() string {
-	return fmt.Sprintf("%v", t.err)
+	if t.context == "" {
+		return t.err.Error()
+	}
+	return fmt.Sprintf("%v: %s", t.err, t.context)
+}
+func (t *validateError) Unwrap() error {
+	return t.err
 }
 
 var (
@@ -32,6 +53,9 @@ var (
 	errTokenInvalidIssuer   = errors.New("token invalid issuer")
 	errTokenExpired         = errors.New("token expired")
 	errTokenNotIssued       = errors.New("token not issued yet")
+	errTokenTooLarge        = errors.New("token too large")
+	errTokenUnsigned        = errors.New("token unsigned")
+	errTokenNoAlgorithm     = errors.New("token missing algorithm")
 )
 
 // ErrTokenMalformed returns an error indicating the token structure is invalid or cannot be parsed.
@@ -52,12 +76,28 @@ func ErrTokenExpired() error { return errTokenExpired }
 // ErrTokenNotIssued returns an error indicating the token's issued-at time is in the future.
 func ErrTokenNotIssued() error { return errTokenNotIssued }
 
+// ErrTokenTooLarge returns an error indicating the token is longer than the validator's MaxTokenLength.
+func ErrTokenTooLarge() error { return errTokenTooLarge }
+
+// ErrTokenUnsigned returns an error indicating the token's header declares the "none" algorithm.
+func ErrTokenUnsigned() error { return errTokenUnsigned }
+
+// ErrTokenNoAlgorithm returns an error indicating the token's header has no algorithm.
+func ErrTokenNoAlgorithm() error { return errTokenNoAlgorithm }
+
 // Issuer can issue new tokens by signing them with a private key.
 // This interface is implemented by Server, which has access to the signing key.
 type Issuer interface {
 	SignHash([]byte) (string, error)
 	IssueRefreshToken(string, []string, []string, time.Duration) (*RefreshToken, error)
 	IssueAccessToken(string, []string, []string, time.Duration) (*AccessToken, error)
+	IssueAccessTokenWithOptions(string, []string, []string, time.Duration, IssueOptions) (*AccessToken, error)
+	IssueTokenPair(string, []string, []string, time.Duration, time.Duration) (*AccessToken, *RefreshToken, error)
+	IssueSessionTokenPair(string, []string, []string, time.Duration, time.Duration) (*AccessToken, *RefreshToken, error)
+	IssueTokenPairWithOptions(string, []string, []string, time.Duration, time.Duration, IssueOptions) (*AccessToken, *RefreshToken, error)
+	IssueIDToken(string, []string, IDTokenProfile, time.Duration) (*IDToken, error)
+	NewAccessToken() *AccessTokenBuilder
+	NewRefreshToken() *RefreshTokenBuilder
 }
 
 // Validator can validate tokens by verifying signatures with a public key.
@@ -75,6 +115,44 @@ type Validator interface {
 type ServerOptions struct {
 	SigningKey   *ecdsa.PrivateKey
 	IssuerDomain string
+	Parse        ParseOptions
+
+	// Signer, when set, signs tokens in place of SigningKey, so the key can
+	// stay in hardware. Its public key must be P-256 ECDSA; check it with
+	// SignerPublicKey.
+	Signer Signer
+
+	// Clock stamps issued tokens and checks their lifetimes. Nil uses the
+	// system clock.
+	Clock Clock
+
+	// SignWorkers bounds how many tokens are signed at once. Zero signs
+	// every token as soon as it is issued.
+	SignWorkers int
+
+	// SignQueue bounds how many tokens may wait for a signing worker when
+	// all SignWorkers are busy. Issuing more fails with ErrSignerBusy.
+	SignQueue int
+}
+
+// IssueOptions describes the sign-in behind tokens issued with the
+// WithOptions methods of Issuer.
+type IssueOptions struct {
+	// AuthTime is when the user last entered their credentials, stamped as
+	// the auth_time claim. Zero leaves the claim out.
+	AuthTime time.Time
+
+	// SessionOnly marks refresh tokens session-only. Access tokens ignore it.
+	SessionOnly bool
+
+	// Actor is the subject of someone acting as the user, stamped as the
+	// act claim. Only access tokens carry it.
+	Actor string
+
+	// TermsVersion is the version of the terms of service the user has
+	// accepted, stamped as the terms_version claim. Only access tokens
+	// carry it; empty leaves the claim out.
+	TermsVersion string
 }
 
 // ClientOptions configures a token validator for backend applications.
@@ -82,6 +160,115 @@ type ClientOptions struct {
 	VerificationKey *ecdsa.PublicKey
 	IssuerDomain    string
 	ValidAudience   string
+	Parse           ParseOptions
+
+	// ValidAudiences are further audiences to accept alongside
+	// ValidAudience, for a gateway fronting several apps. A token is valid
+	// if its audience includes any of them.
+	ValidAudiences []string
+
+	// AudienceMatch is how token audiences are compared with the valid
+	// ones. The zero value, AudienceMatchExact, requires equal strings.
+	AudienceMatch AudienceMatch
+
+	// Clock checks token lifetimes. Nil uses the system clock.
+	Clock Clock
+
+	// Leeway is how far the client's clock may be off from the server's.
+	// Tokens are accepted up to Leeway before they were issued and after
+	// they expire.
+	Leeway time.Duration
+}
+
+// AudienceMatch is a strategy for matching a token's audience against a
+// Client's valid audiences, for deployments with an audience per tenant.
+type AudienceMatch int
+
+const (
+	// AudienceMatchExact accepts only audiences equal to a valid audience.
+	AudienceMatchExact AudienceMatch = iota
+
+	// AudienceMatchSubdomain also accepts subdomains of a valid audience:
+	// "app.example.com" accepts "tenant1.app.example.com".
+	AudienceMatchSubdomain
+
+	// AudienceMatchGlob treats valid audiences as path.Match patterns, such
+	// as "tenant-*.example.com", matched one dot-separated label at a time.
+	// A "*" stays within its label: "*.example.com" accepts
+	// "a.example.com" but not "a.b.example.com", and "api.*" does not
+	// accept "api.attacker.net". Nor does it match across a "/".
+	AudienceMatchGlob
+)
+
+func (m AudienceMatch) matches(
+	valid string,
+	audience string,
+) bool {
+	switch m {
+	case AudienceMatchSubdomain:
+		return audience == valid || strings.HasSuffix(audience, "."+valid)
+	case AudienceMatchGlob:
+		return matchLabels(valid, audience)
+	default:
+		return audience == valid
+	}
+}
+
+// matchLabels reports whether audience has as many dot-separated labels as
+// pattern and each matches the pattern's label at the same position.
+func matchLabels(
+	pattern string,
+	audience string,
+) bool {
+	patternLabels := strings.Split(pattern, ".")
+	audienceLabels := strings.Split(audience, ".")
+	if len(patternLabels) != len(audienceLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		matched, err := path.Match(label, audienceLabels[i])
+		if err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseOptions controls how strictly a validator parses tokens before
+// checking their signature and claims. The zero value accepts any token up to
+// DefaultMaxTokenLength and ignores unknown and repeated JSON fields.
+type ParseOptions struct {
+	// MaxTokenLength rejects longer tokens with ErrTokenTooLarge. Zero uses
+	// DefaultMaxTokenLength.
+	MaxTokenLength int
+
+	// DisallowUnknownClaims rejects tokens whose claims include fields the
+	// token type does not define.
+	DisallowUnknownClaims bool
+
+	// DisallowDuplicateFields rejects tokens whose header or claims repeat a
+	// field, which JSON decoding would otherwise resolve to the last value.
+	DisallowDuplicateFields bool
+}
+
+func (options ParseOptions) maxTokenLength() int {
+	if options.MaxTokenLength <= 0 {
+		return DefaultMaxTokenLength
+	}
+	return options.MaxTokenLength
+}
+
+// parseOptioner is implemented by validators that carry ParseOptions.
+// Other validators parse with the defaults.
+type parseOptioner interface {
+	parseOptions() ParseOptions
+}
+
+func parseOptionsOf(validator Validator) ParseOptions {
+	if v, ok := validator.(parseOptioner); ok {
+		return v.parseOptions()
+	}
+	return ParseOptions{}
 }
 
 // InitServer creates a token issuer and validator for the consent auth server.
@@ -92,16 +279,24 @@ type ClientOptions struct {
 //   - options: ServerOptions with signing key and issuer domain
 //
 // Returns both an Issuer and Validator interface backed by the same Server instance.
+// InitServer panics if options has neither a SigningKey nor a P-256 Signer.
 func InitServer(
 	options ServerOptions,
 ) (
 	Issuer,
 	Validator,
 ) {
+	verificationKey, err := options.PublicKey()
+	if err != nil {
+		panic("tokens: " + err.Error())
+	}
 	server := &Server{
-		signingKey:      options.SigningKey,
-		verificationKey: &options.SigningKey.PublicKey,
+		signer:          signerOf(options),
+		pool:            newSignPool(options.SignWorkers, options.SignQueue),
+		verificationKey: verificationKey,
 		issuerDomain:    options.IssuerDomain,
+		parse:           options.Parse,
+		clock:           options.Clock,
 	}
 	return server, server
 }
@@ -119,8 +314,27 @@ func InitClient(
 	return &Client{
 		verificationKey: options.VerificationKey,
 		issuerDomain:    options.IssuerDomain,
-		validAudience:   options.ValidAudience,
+		validAudiences:  validAudiencesOf(options),
+		audienceMatch:   options.AudienceMatch,
+		parse:           options.Parse,
+		clock:           options.Clock,
+		leeway:          options.Leeway,
+	}
+}
+
+func validAudiencesOf(
+	options ClientOptions,
+) []string {
+	audiences := []string{}
+	if options.ValidAudience != "" {
+		audiences = append(audiences, options.ValidAudience)
 	}
+	for _, audience := range options.ValidAudiences {
+		if audience != "" && !slices.Contains(audiences, audience) {
+			audiences = append(audiences, audience)
+		}
+	}
+	return audiences
 }
 
 type JWTHeader struct {
@@ -149,8 +363,14 @@ func generateCSRFCode() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
 }
 
+// SecureCompare reports whether a and b are equal, taking time that depends
+// only on their lengths. Use it to check secrets such as CSRF codes.
+func SecureCompare(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
 func buildMessage(encHeader string, encClaims string) string {
-	return fmt.Sprintf("%s.%s", encHeader, encClaims)
+	return encHeader + "." + encClaims
 }
 
 func hashMessage(message string) []byte {
@@ -159,14 +379,11 @@ func hashMessage(message string) []byte {
 }
 
 func encodeSignature(r *big.Int, s *big.Int) (string, error) {
-	signature := make([]byte, 64)
-	rBytes := r.Bytes()
-	sBytes := s.Bytes()
-	// Right-align r in first 32 bytes (padding with zeros on the left)
-	copy(signature[32-len(rBytes):32], rBytes)
-	// Right-align s in second 32 bytes (padding with zeros on the left)
-	copy(signature[64-len(sBytes):64], sBytes)
-	encSignature := base64.RawURLEncoding.EncodeToString(signature)
+	var signature [64]byte
+	// Right-align r and s in their 32 bytes (padding with zeros on the left)
+	r.FillBytes(signature[00:32])
+	s.FillBytes(signature[32:64])
+	encSignature := base64.RawURLEncoding.EncodeToString(signature[:])
 	return encSignature, nil
 }
 
@@ -184,20 +401,33 @@ func encodeJWTSection[T comparable](section T) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("json marshal failure: %v", err)
 	}
-	encodedSection := base64.RawURLEncoding.EncodeToString([]byte(sectionJSON))
+	encodedSection := base64.RawURLEncoding.EncodeToString(sectionJSON)
 	return encodedSection, nil
 }
 
-func encodeMessage[T comparable](claims T) (string, error) {
+// encodedES256Header is the encoded header of every token this package
+// issues. It never changes, so it is encoded once rather than per token.
+var encodedES256Header = func() string {
 	encHeader, err := encodeJWTSection(newES256JWTHeader())
 	if err != nil {
-		return "", fmt.Errorf("failed to encode header: %v", err)
+		panic("failed to encode ES256 header: " + err.Error())
 	}
-	encClaims, err := encodeJWTSection(claims)
+	return encHeader
+}()
+
+func encodeMessage[T comparable](claims T) (string, error) {
+	claimsJSON, err := json.Marshal(claims)
 	if err != nil {
-		return "", fmt.Errorf("failed to encode claims: %v", err)
+		return "", fmt.Errorf("failed to encode claims: json marshal failure: %v", err)
 	}
-	return buildMessage(encHeader, encClaims), nil
+
+	// write header and claims into one buffer sized for both
+	enc := base64.RawURLEncoding
+	message := make([]byte, 0, len(encodedES256Header)+1+enc.EncodedLen(len(claimsJSON)))
+	message = append(message, encodedES256Header...)
+	message = append(message, '.')
+	message = enc.AppendEncode(message, claimsJSON)
+	return string(message), nil
 }
 
 func encodeToken[T comparable](claims T, issuer Issuer) (string, error) {
@@ -209,18 +439,71 @@ func encodeToken[T comparable](claims T, issuer Issuer) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s.%s", message, encSignature), nil
+	return message + "." + encSignature, nil
 }
 
 func decodeJWTSection[T comparable](str string, value *T) error {
-	bytes, err := base64.RawURLEncoding.DecodeString(str)
+	return decodeStrictJWTSection(str, value, false, false)
+}
+
+// decodeStrictJWTSection decodes a section, optionally rejecting fields value
+// does not define and fields that appear more than once.
+func decodeStrictJWTSection[T comparable](
+	str string,
+	value *T,
+	disallowUnknown bool,
+	disallowDuplicates bool,
+) error {
+	data, err := base64.RawURLEncoding.DecodeString(str)
 	if err != nil {
 		return fmt.Errorf("invalid base64 encoding: %v", err)
 	}
-	err = json.Unmarshal(bytes, &value)
-	if err != nil {
+	if disallowDuplicates {
+		if err := checkDuplicateFields(data); err != nil {
+			return err
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if disallowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&value); err != nil {
 		return fmt.Errorf("not valid JSON: %v", err)
 	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("not valid JSON: trailing data")
+	}
+	return nil
+}
+
+// checkDuplicateFields returns an error if the JSON object in data names a
+// top-level field more than once. Data that isn't an object is left for the
+// decoder to reject.
+func checkDuplicateFields(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+	seen := map[string]bool{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		name, ok := token.(string)
+		if !ok {
+			return nil
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate field %q", name)
+		}
+		seen[name] = true
+
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return nil
+		}
+	}
 	return nil
 }
 
@@ -230,17 +513,51 @@ func validateStructure(tokenStr string) (
 	signature string,
 	err error,
 ) {
-	parts := strings.Split(tokenStr, ".")
-	if len(parts) != 3 {
-		err = fmt.Errorf("JWT expected three parts, found %d", len(parts))
+	if count := strings.Count(tokenStr, ".") + 1; count != 3 {
+		err = fmt.Errorf("JWT expected three parts, found %d", count)
 		return
 	}
+	parts := strings.SplitN(tokenStr, ".", 3)
 	header = parts[0]
 	claims = parts[1]
 	signature = parts[2]
 	return
 }
 
+// validateStandardClaims checks the claims every token type carries against
+// validator and its clock's current time. Errors wrap the matching ErrToken error and
+// name the claim that failed.
+func validateStandardClaims(
+	validator Validator,
+	issuedAt int64,
+	expiration int64,
+	issuer string,
+	audience string,
+) error {
+	now := nowOf(validator)
+	leeway := leewayOf(validator)
+
+	if issued := time.Unix(issuedAt, 0); issued.After(now.Add(leeway)) {
+		return fmt.Errorf("%w: issued at %s", errTokenNotIssued, issued.UTC().Format(time.RFC3339))
+	}
+
+	if expires := time.Unix(expiration, 0); expires.Before(now.Add(-leeway)) {
+		return fmt.Errorf("%w: expired at %s", errTokenExpired, expires.UTC().Format(time.RFC3339))
+	}
+
+	if !validator.ValidateDomain(issuer) {
+		return fmt.Errorf("%w: %q", errTokenInvalidIssuer, issuer)
+	}
+
+	if validator.ShouldValidateAudience() {
+		if !validator.ValidateAudiences(audience) {
+			return fmt.Errorf("%w: %q", errTokenInvalidAudience, audience)
+		}
+	}
+
+	return nil
+}
+
 func validateIssuedAudiences(
 	audience []string,
 ) error {
@@ -256,6 +573,13 @@ func validateIssuedAudiences(
 }
 
 func verifyHeader(header *JWTHeader) error {
+	switch strings.ToLower(header.Algorithm) {
+	case "":
+		return errTokenNoAlgorithm
+	case "none":
+		return errTokenUnsigned
+	}
+
 	switch header.Type {
 	case "JWT":
 		break
@@ -299,48 +623,65 @@ func verifySignature(
 }
 
 func decodeToken[T claims](tokenStr string, validator Validator) (*T, *validateError) {
+	options := parseOptionsOf(validator)
+	if maxLength := options.maxTokenLength(); len(tokenStr) > maxLength {
+		return nil, &validateError{
+			context: fmt.Sprintf("%d bytes, limit %d", len(tokenStr), maxLength),
+			err:     errTokenTooLarge,
+		}
+	}
+
 	encHeader, encClaims, encSignature, err := validateStructure(tokenStr)
 	if err != nil {
 		return nil, &validateError{
-			context: fmt.Sprintf("token malformed: %v", err),
+			context: err.Error(),
 			err:     errTokenMalformed,
 		}
 	}
 
 	header := JWTHeader{}
-	if err := decodeJWTSection(encHeader, &header); err != nil {
+	if err := decodeStrictJWTSection(encHeader, &header, false, options.DisallowDuplicateFields); err != nil {
 		return nil, &validateError{
-			context: fmt.Sprintf("token header malformed: %v", err),
+			context: fmt.Sprintf("header: %v", err),
 			err:     errTokenMalformed,
 		}
 	}
 
 	if err := verifyHeader(&header); err != nil {
+		if errors.Is(err, errTokenUnsigned) || errors.Is(err, errTokenNoAlgorithm) {
+			return nil, &validateError{
+				context: fmt.Sprintf("header algorithm %q", header.Algorithm),
+				err:     err,
+			}
+		}
 		return nil, &validateError{
-			context: fmt.Sprintf("token header illegal: %v", err),
+			context: fmt.Sprintf("header: %v", err),
 			err:     errTokenBadSignature,
 		}
 	}
 
 	if err := validator.VerifySignature(encHeader, encClaims, encSignature); err != nil {
 		return nil, &validateError{
-			context: fmt.Sprintf("token signature illegal: %v", err),
+			context: fmt.Sprintf("signature: %v", err),
 			err:     errTokenBadSignature,
 		}
 	}
 
 	claims := new(T)
-	if err := decodeJWTSection(encClaims, &claims); err != nil {
+	if err := decodeStrictJWTSection(
+		encClaims,
+		&claims,
+		options.DisallowUnknownClaims,
+		options.DisallowDuplicateFields,
+	); err != nil {
 		return nil, &validateError{
-			context: fmt.Sprintf("token claims malformed: %v", err),
+			context: fmt.Sprintf("claims: %v", err),
 			err:     errTokenMalformed,
 		}
 	}
 	if err = (*claims).validate(validator); err != nil {
-		return nil, &validateError{
-			context: fmt.Sprintf("token claims invalid: %v", err),
-			err:     err,
-		}
+		// validate wraps an ErrToken error with the offending claim
+		return nil, &validateError{err: err}
 	}
 
 	return claims, nil
@@ -18,7 +18,7 @@ var (
 
 // getSharedTestKey returns a shared ECDSA key for tests that don't need isolation.
 // This avoids the overhead of generating a new key for each test.
-func getSharedTestKey(t *testing.T) *ecdsa.PrivateKey {
+func getSharedTestKey(t testing.TB) *ecdsa.PrivateKey {
 	t.Helper()
 	sharedTestKeyOnce.Do(func() {
 		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -40,13 +40,13 @@ func generateTestKey(t *testing.T) *ecdsa.PrivateKey {
 	return key
 }
 
-func newTestServer(t *testing.T, domain string) (tokens.Issuer, tokens.Validator) {
+func newTestServer(t testing.TB, domain string) (tokens.Issuer, tokens.Validator) {
 	t.Helper()
 	return newTestServerWithKey(t, getSharedTestKey(t), domain)
 }
 
 func newTestServerWithKey(
-	t *testing.T,
+	t testing.TB,
 	key *ecdsa.PrivateKey,
 	domain string,
 ) (tokens.Issuer, tokens.Validator) {
@@ -167,3 +167,27 @@ func TestToken_CrossValidation(t *testing.T) {
 		t.Errorf("Subject = %s, want user", decoded.Subject())
 	}
 }
+
+func TestSecureCompare(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{"equal", "secret", "secret", true},
+		{"both empty", "", "", true},
+		{"different", "secret", "secreT", false},
+		{"prefix", "secret", "secret-longer", false},
+		{"one empty", "secret", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokens.SecureCompare(tt.a, tt.b); got != tt.want {
+				t.Errorf("SecureCompare(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// UnverifiedToken is a token's header and claims exactly as written. Nothing
+// about it has been checked: the signature may be forged and the claims may be
+// expired, for another audience, or from another issuer.
+type UnverifiedToken struct {
+	header  JWTHeader
+	claims  map[string]any
+	encoded string
+}
+
+// ParseUnverified decodes a token's header and claims without verifying its
+// signature or validating its claims. It is for debugging tools, logging the
+// subject of a rejected token, and inspecting expired tokens; never authorize
+// a request with what it returns. Only tokens that aren't well-formed JWTs, or
+// are longer than DefaultMaxTokenLength, fail to parse.
+func ParseUnverified(
+	tokenStr string,
+) (
+	*UnverifiedToken,
+	error,
+) {
+	if len(tokenStr) > DefaultMaxTokenLength {
+		return nil, &validateError{
+			context: fmt.Sprintf("%d bytes, limit %d", len(tokenStr), DefaultMaxTokenLength),
+			err:     errTokenTooLarge,
+		}
+	}
+
+	encHeader, encClaims, _, err := validateStructure(tokenStr)
+	if err != nil {
+		return nil, &validateError{
+			context: err.Error(),
+			err:     errTokenMalformed,
+		}
+	}
+
+	header := JWTHeader{}
+	if err := decodeJWTSection(encHeader, &header); err != nil {
+		return nil, &validateError{
+			context: fmt.Sprintf("header: %v", err),
+			err:     errTokenMalformed,
+		}
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encClaims)
+	if err != nil {
+		return nil, &validateError{
+			context: fmt.Sprintf("claims: invalid base64 encoding: %v", err),
+			err:     errTokenMalformed,
+		}
+	}
+	claims := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, &validateError{
+			context: fmt.Sprintf("claims: not valid JSON: %v", err),
+			err:     errTokenMalformed,
+		}
+	}
+
+	return &UnverifiedToken{
+		header:  header,
+		claims:  claims,
+		encoded: tokenStr,
+	}, nil
+}
+
+// Header returns the token's header.
+func (t *UnverifiedToken) Header() JWTHeader { return t.header }
+
+// Encoded returns the token as it was parsed.
+func (t *UnverifiedToken) Encoded() string { return t.encoded }
+
+// Claims returns every claim in the token. Numbers are json.Number values.
+func (t *UnverifiedToken) Claims() map[string]any {
+	claims := make(map[string]any, len(t.claims))
+	for name, value := range t.claims {
+		claims[name] = value
+	}
+	return claims
+}
+
+// Issuer returns the "iss" claim, or "" if it is missing or not a string.
+func (t *UnverifiedToken) Issuer() string { return t.stringClaim("iss") }
+
+// Subject returns the "sub" claim, or "" if it is missing or not a string.
+func (t *UnverifiedToken) Subject() string { return t.stringClaim("sub") }
+
+// IssuedAt returns the "iat" claim, or the zero time if it is missing or not
+// a number.
+func (t *UnverifiedToken) IssuedAt() time.Time { return t.timeClaim("iat") }
+
+// Expiration returns the "exp" claim, or the zero time if it is missing or
+// not a number.
+func (t *UnverifiedToken) Expiration() time.Time { return t.timeClaim("exp") }
+
+// Audience returns the "aud" claim, which consent writes space-separated and
+// other issuers may write as an array.
+func (t *UnverifiedToken) Audience() []string {
+	switch aud := t.claims["aud"].(type) {
+	case string:
+		return splitClaimValues(aud)
+	case []any:
+		audience := []string{}
+		for _, value := range aud {
+			if s, ok := value.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	default:
+		return nil
+	}
+}
+
+// Scopes returns the "scopes" claim, or nil if it is missing.
+func (t *UnverifiedToken) Scopes() []string {
+	return splitClaimValues(t.stringClaim("scopes"))
+}
+
+func (t *UnverifiedToken) stringClaim(name string) string {
+	value, _ := t.claims[name].(string)
+	return value
+}
+
+func (t *UnverifiedToken) timeClaim(name string) time.Time {
+	number, ok := t.claims[name].(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	seconds, err := number.Int64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
@@ -0,0 +1,94 @@
+package tokens_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+func TestParseUnverified_ExpiredToken(t *testing.T) {
+	t.Parallel()
+	issuer, _ := newTestServer(t, "test.domain")
+
+	original, err := issuer.IssueAccessToken("alice", []string{"app", "api"}, []string{"identity"}, -time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	parsed, err := tokens.ParseUnverified(original.Encoded())
+	if err != nil {
+		t.Fatalf("ParseUnverified failed: %v", err)
+	}
+	if parsed.Subject() != "alice" {
+		t.Errorf("Subject = %q, want alice", parsed.Subject())
+	}
+	if parsed.Issuer() != "test.domain" {
+		t.Errorf("Issuer = %q, want test.domain", parsed.Issuer())
+	}
+	if !slices.Equal(parsed.Audience(), []string{"app", "api"}) {
+		t.Errorf("Audience = %v, want [app api]", parsed.Audience())
+	}
+	if !slices.Equal(parsed.Scopes(), []string{"identity"}) {
+		t.Errorf("Scopes = %v, want [identity]", parsed.Scopes())
+	}
+	if parsed.Expiration().Unix() != original.Expiration().Unix() {
+		t.Errorf("Expiration = %v, want %v", parsed.Expiration(), original.Expiration())
+	}
+	if parsed.Header().Algorithm != "ES256" {
+		t.Errorf("Algorithm = %q, want ES256", parsed.Header().Algorithm)
+	}
+	if parsed.Encoded() != original.Encoded() {
+		t.Error("Encoded does not match the parsed token")
+	}
+}
+
+func TestParseUnverified_IgnoresSignature(t *testing.T) {
+	t.Parallel()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory","aud":["a","b"],"exp":"soon","role":"admin"}`))
+
+	parsed, err := tokens.ParseUnverified(header + "." + claims + ".")
+	if err != nil {
+		t.Fatalf("ParseUnverified failed: %v", err)
+	}
+	if parsed.Subject() != "mallory" {
+		t.Errorf("Subject = %q, want mallory", parsed.Subject())
+	}
+	if !slices.Equal(parsed.Audience(), []string{"a", "b"}) {
+		t.Errorf("Audience = %v, want [a b]", parsed.Audience())
+	}
+	if !parsed.Expiration().IsZero() {
+		t.Errorf("Expiration = %v, want zero for non-numeric exp", parsed.Expiration())
+	}
+	if parsed.Claims()["role"] != "admin" {
+		t.Errorf("role claim = %v, want admin", parsed.Claims()["role"])
+	}
+}
+
+func TestParseUnverified_Malformed(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"two parts", "a.b", tokens.ErrTokenMalformed()},
+		{"bad header", "!!!.e30.", tokens.ErrTokenMalformed()},
+		{"claims not an object", "e30.W10.", tokens.ErrTokenMalformed()},
+		{"too large", strings.Repeat("a", tokens.DefaultMaxTokenLength+1), tokens.ErrTokenTooLarge()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tokens.ParseUnverified(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
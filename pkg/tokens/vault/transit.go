@@ -0,0 +1,362 @@
+/*
+Package vault signs tokens with a key in HashiCorp Vault's transit secrets
+engine, so the private key never leaves Vault. The key must be of type
+ecdsa-p256:
+
+	vault secrets enable transit
+	vault write -f transit/keys/consent type=ecdsa-p256
+
+A TransitSigner is a tokens.Signer; set it as ServerOptions.Signer or the
+embedded server's Config.Signer:
+
+	signer, err := vault.NewTransitSigner(ctx, vault.Options{
+	    Address: "https://vault.example.com:8200",
+	    Token:   os.Getenv("VAULT_TOKEN"),
+	    Key:     "consent",
+	})
+
+The signer pins the key version it was created with, so rotating the key in
+Vault doesn't change the public key tokens verify with until the signer is
+recreated. Every signature is a request to Vault; Check reports whether Vault
+can currently sign, and Stats and Options.OnSign report how long signing takes.
+*/
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+)
+
+// Defaults for Options fields left zero.
+const (
+	DefaultMount   = "transit"
+	DefaultTimeout = 5 * time.Second
+)
+
+// Options configures a TransitSigner.
+type Options struct {
+	// Address is Vault's base URL, such as "https://vault.example.com:8200".
+	Address string
+
+	// Token authenticates to Vault. It needs read on the key and update on
+	// its sign endpoint.
+	Token string
+
+	// Mount is where the transit engine is mounted. Empty uses "transit".
+	Mount string
+
+	// Key names the transit key, which must be of type ecdsa-p256.
+	Key string
+
+	// Timeout bounds each request to Vault. Zero uses DefaultTimeout.
+	Timeout time.Duration
+
+	// HTTPClient sends requests to Vault. Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	// OnSign, when set, is called after every signing request with how long
+	// it took and its error, if any, for feeding a metrics system.
+	OnSign func(latency time.Duration, err error)
+}
+
+// Stats summarizes a TransitSigner's signing requests.
+type Stats struct {
+	Signs        int64
+	Failures     int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	LastLatency  time.Duration
+}
+
+// MeanLatency returns the average signing request latency, or zero before
+// the first request.
+func (s Stats) MeanLatency() time.Duration {
+	if s.Signs == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Signs)
+}
+
+// TransitSigner is a tokens.Signer backed by a Vault transit key.
+type TransitSigner struct {
+	options   Options
+	client    *http.Client
+	version   int
+	publicKey *ecdsa.PublicKey
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+var _ tokens.Signer = (*TransitSigner)(nil)
+
+// NewTransitSigner reads the public half of the latest version of the
+// transit key and returns a signer for that version.
+func NewTransitSigner(
+	ctx context.Context,
+	options Options,
+) (
+	*TransitSigner,
+	error,
+) {
+	if options.Address == "" {
+		return nil, errors.New("vault: address required")
+	}
+	if options.Token == "" {
+		return nil, errors.New("vault: token required")
+	}
+	if options.Key == "" {
+		return nil, errors.New("vault: key required")
+	}
+	if options.Mount == "" {
+		options.Mount = DefaultMount
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	client := options.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	signer := &TransitSigner{options: options, client: client}
+	version, publicKey, err := signer.readKey(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	signer.version = version
+	signer.publicKey = publicKey
+	return signer, nil
+}
+
+// Public returns the public key of the pinned key version.
+func (s *TransitSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// KeyVersion returns the transit key version the signer signs with.
+func (s *TransitSigner) KeyVersion() int {
+	return s.version
+}
+
+// Sign asks Vault to sign digest, a SHA-256 hash, and returns the ASN.1
+// signature. rand is unused; Vault supplies its own randomness.
+func (s *TransitSigner) Sign(
+	_ io.Reader,
+	digest []byte,
+	opts crypto.SignerOpts,
+) (
+	[]byte,
+	error,
+) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("vault: unsupported hash %v, want SHA-256", opts.HashFunc())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	signature, err := s.sign(ctx, digest)
+	s.record(time.Since(start), err)
+	return signature, err
+}
+
+// Check reports whether Vault can sign right now: it is reachable, unsealed,
+// and the token can still read the key, whose pinned version still exists.
+func (s *TransitSigner) Check(
+	ctx context.Context,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+	defer cancel()
+
+	var health struct {
+		Sealed bool `json:"sealed"`
+	}
+	// standbyok counts standbys as healthy, since they forward to the active node
+	status, err := s.do(ctx, http.MethodGet, "/v1/sys/health?standbyok=true", nil, &health)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || health.Sealed {
+		return fmt.Errorf("vault: unhealthy (status %d, sealed %t)", status, health.Sealed)
+	}
+
+	_, _, err = s.readKey(ctx, s.version)
+	return err
+}
+
+// Stats returns the signer's signing request counts and latencies.
+func (s *TransitSigner) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *TransitSigner) record(
+	latency time.Duration,
+	err error,
+) {
+	s.mu.Lock()
+	s.stats.Signs++
+	if err != nil {
+		s.stats.Failures++
+	}
+	s.stats.TotalLatency += latency
+	s.stats.MaxLatency = max(s.stats.MaxLatency, latency)
+	s.stats.LastLatency = latency
+	s.mu.Unlock()
+
+	if s.options.OnSign != nil {
+		s.options.OnSign(latency, err)
+	}
+}
+
+func (s *TransitSigner) sign(
+	ctx context.Context,
+	digest []byte,
+) (
+	[]byte,
+	error,
+) {
+	request := map[string]any{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"prehashed":            true,
+		"hash_algorithm":       "sha2-256",
+		"marshaling_algorithm": "asn1",
+		"key_version":          s.version,
+	}
+	var response struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	status, err := s.do(ctx, http.MethodPost, "/v1/"+s.options.Mount+"/sign/"+s.options.Key, request, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("vault: sign with key %s: status %d", s.options.Key, status)
+	}
+
+	// signatures look like vault:v<version>:<base64>
+	parts := strings.SplitN(response.Data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("vault: malformed signature %q", response.Data.Signature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("vault: decode signature: %w", err)
+	}
+	return signature, nil
+}
+
+// readKey returns the public key of the transit key's version, or of its
+// latest version when version is zero, along with the version read.
+func (s *TransitSigner) readKey(
+	ctx context.Context,
+	version int,
+) (
+	int,
+	*ecdsa.PublicKey,
+	error,
+) {
+	var response struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	status, err := s.do(ctx, http.MethodGet, "/v1/"+s.options.Mount+"/keys/"+s.options.Key, nil, &response)
+	if err != nil {
+		return 0, nil, err
+	}
+	if status != http.StatusOK {
+		return 0, nil, fmt.Errorf("vault: read key %s: status %d", s.options.Key, status)
+	}
+	if response.Data.Type != "ecdsa-p256" {
+		return 0, nil, fmt.Errorf("vault: key %s is %s, want ecdsa-p256", s.options.Key, response.Data.Type)
+	}
+
+	if version == 0 {
+		version = response.Data.LatestVersion
+	}
+	entry, ok := response.Data.Keys[strconv.Itoa(version)]
+	if !ok {
+		return 0, nil, fmt.Errorf("vault: key %s has no version %d", s.options.Key, version)
+	}
+	block, _ := pem.Decode([]byte(entry.PublicKey))
+	if block == nil {
+		return 0, nil, fmt.Errorf("vault: key %s version %d has no PEM public key", s.options.Key, version)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("vault: parse public key: %w", err)
+	}
+	publicKey, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return 0, nil, fmt.Errorf("vault: public key is %T, want *ecdsa.PublicKey", parsed)
+	}
+	return version, publicKey, nil
+}
+
+// do sends a request to Vault, decoding a JSON response into out. It returns
+// the response status; errors are for requests that got no response.
+func (s *TransitSigner) do(
+	ctx context.Context,
+	method string,
+	path string,
+	body any,
+	out any,
+) (
+	int,
+	error,
+) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("vault: encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.options.Address, "/")+path, reader)
+	if err != nil {
+		return 0, fmt.Errorf("vault: build request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", s.options.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("vault: decode response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
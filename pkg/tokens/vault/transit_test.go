@@ -0,0 +1,158 @@
+package vault_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jakintosh/consent/pkg/tokens"
+	"git.sr.ht/~jakintosh/consent/pkg/tokens/vault"
+)
+
+// fakeVault serves the transit endpoints a TransitSigner uses for one
+// ecdsa-p256 key named "consent".
+type fakeVault struct {
+	key    *ecdsa.PrivateKey
+	sealed atomic.Bool
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	fake := &fakeVault{key: key}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /v1/sys/health":
+		status := http.StatusOK
+		if f.sealed.Load() {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"sealed":%t}`, f.sealed.Load())
+
+	case "GET /v1/transit/keys/consent":
+		der, _ := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+		public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"type":           "ecdsa-p256",
+			"latest_version": 1,
+			"keys":           map[string]any{"1": map[string]any{"public_key": string(public)}},
+		}})
+
+	case "POST /v1/transit/sign/consent":
+		var request struct {
+			Input      string `json:"input"`
+			Prehashed  bool   `json:"prehashed"`
+			KeyVersion int    `json:"key_version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Prehashed || request.KeyVersion != 1 {
+			http.Error(w, `{"errors":["bad request"]}`, http.StatusBadRequest)
+			return
+		}
+		digest, _ := base64.StdEncoding.DecodeString(request.Input)
+		signature, _ := ecdsa.SignASN1(rand.Reader, f.key, digest)
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(signature),
+		}})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestTransitSigner_SignsTokens(t *testing.T) {
+	t.Parallel()
+	fake, server := newFakeVault(t)
+
+	var observed atomic.Int64
+	signer, err := vault.NewTransitSigner(t.Context(), vault.Options{
+		Address: server.URL,
+		Token:   "token",
+		Key:     "consent",
+		OnSign:  func(time.Duration, error) { observed.Add(1) },
+	})
+	if err != nil {
+		t.Fatalf("NewTransitSigner failed: %v", err)
+	}
+	if !signer.Public().(*ecdsa.PublicKey).Equal(&fake.key.PublicKey) {
+		t.Fatal("Public does not match the vault key")
+	}
+
+	// tokens signed in vault verify with its public key
+	issuer, _ := tokens.InitServer(tokens.ServerOptions{Signer: signer, IssuerDomain: "test.domain"})
+	token, err := issuer.IssueAccessToken("user", []string{"aud"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	validator := tokens.InitClient(tokens.ClientOptions{
+		VerificationKey: &fake.key.PublicKey,
+		IssuerDomain:    "test.domain",
+		ValidAudience:   "aud",
+	})
+	if err := new(tokens.AccessToken).Decode(token.Encoded(), validator); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	// signing requests are measured
+	stats := signer.Stats()
+	if stats.Signs != 1 || stats.Failures != 0 || observed.Load() != 1 {
+		t.Errorf("stats = %+v, OnSign calls = %d, want one successful sign", stats, observed.Load())
+	}
+}
+
+func TestTransitSigner_Check(t *testing.T) {
+	t.Parallel()
+	fake, server := newFakeVault(t)
+	signer, err := vault.NewTransitSigner(t.Context(), vault.Options{Address: server.URL, Token: "token", Key: "consent"})
+	if err != nil {
+		t.Fatalf("NewTransitSigner failed: %v", err)
+	}
+
+	if err := signer.Check(t.Context()); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	fake.sealed.Store(true)
+	if err := signer.Check(t.Context()); err == nil {
+		t.Fatal("Check on sealed vault = nil, want error")
+	}
+}
+
+func TestNewTransitSigner_Errors(t *testing.T) {
+	t.Parallel()
+	_, server := newFakeVault(t)
+
+	cases := map[string]vault.Options{
+		"no address":  {Token: "token", Key: "consent"},
+		"no token":    {Address: server.URL, Key: "consent"},
+		"bad token":   {Address: server.URL, Token: "wrong", Key: "consent"},
+		"unknown key": {Address: server.URL, Token: "token", Key: "other"},
+	}
+	for name, options := range cases {
+		if _, err := vault.NewTransitSigner(t.Context(), options); err == nil {
+			t.Errorf("%s: NewTransitSigner = nil error, want error", name)
+		}
+	}
+}